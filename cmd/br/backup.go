@@ -3,6 +3,9 @@
 package main
 
 import (
+	"time"
+
+	"github.com/docker/go-units"
 	"github.com/pingcap/errors"
 	"github.com/pingcap/log"
 	"github.com/pingcap/tidb/ddl"
@@ -25,6 +28,7 @@ func runBackupCommand(command *cobra.Command, cmdName string) error {
 		command.SilenceUsage = false
 		return errors.Trace(err)
 	}
+	recordRunConfig(&cfg)
 
 	ctx := GetDefaultContext()
 	if cfg.EnableOpenTracing {
@@ -50,6 +54,7 @@ func runBackupRawCommand(command *cobra.Command, cmdName string) error {
 		command.SilenceUsage = false
 		return errors.Trace(err)
 	}
+	recordRunConfig(&cfg)
 
 	ctx := GetDefaultContext()
 	if cfg.EnableOpenTracing {
@@ -64,6 +69,27 @@ func runBackupRawCommand(command *cobra.Command, cmdName string) error {
 	return nil
 }
 
+func runBackupTxnCommand(command *cobra.Command, cmdName string) error {
+	cfg := task.TxnKvConfig{RawKvConfig: task.RawKvConfig{Config: task.Config{LogProgress: HasLogFile()}}}
+	if err := cfg.ParseFromFlags(command.Flags()); err != nil {
+		command.SilenceUsage = false
+		return errors.Trace(err)
+	}
+	recordRunConfig(&cfg)
+
+	ctx := GetDefaultContext()
+	if cfg.EnableOpenTracing {
+		var store *appdash.MemoryStore
+		ctx, store = trace.TracerStartSpan(ctx)
+		defer trace.TracerFinishSpan(ctx, store)
+	}
+	if err := task.RunBackupTxn(ctx, gluetikv.Glue{}, cmdName, &cfg); err != nil {
+		log.Error("failed to backup txn kv", zap.Error(err))
+		return errors.Trace(err)
+	}
+	return nil
+}
+
 // NewBackupCommand return a full backup subcommand.
 func NewBackupCommand() *cobra.Command {
 	command := &cobra.Command{
@@ -90,6 +116,8 @@ func NewBackupCommand() *cobra.Command {
 		newDBBackupCommand(),
 		newTableBackupCommand(),
 		newRawBackupCommand(),
+		newTxnBackupCommand(),
+		newBackupStatusCommand(),
 	)
 
 	task.DefineBackupFlags(command.PersistentFlags())
@@ -156,3 +184,60 @@ func newRawBackupCommand() *cobra.Command {
 	task.DefineRawBackupFlags(command)
 	return command
 }
+
+// newTxnBackupCommand return a txn kv range backup subcommand.
+func newTxnBackupCommand() *cobra.Command {
+	command := &cobra.Command{
+		Use:   "txn",
+		Short: "(experimental) backup a txn kv range from TiKV cluster",
+		Args:  cobra.NoArgs,
+		RunE: func(command *cobra.Command, _ []string) error {
+			return runBackupTxnCommand(command, "Txn backup")
+		},
+	}
+
+	task.DefineTxnBackupFlags(command)
+	return command
+}
+
+// newBackupStatusCommand return a subcommand printing the progress of a backup.
+func newBackupStatusCommand() *cobra.Command {
+	command := &cobra.Command{
+		Use:   "status",
+		Short: "print the progress of the backup in the storage, which may run on another host",
+		Args:  cobra.NoArgs,
+		RunE: func(command *cobra.Command, _ []string) error {
+			cfg := task.Config{}
+			if err := cfg.ParseFromFlags(command.Flags()); err != nil {
+				command.SilenceUsage = false
+				return errors.Trace(err)
+			}
+			progress, err := task.ReadBackupStatus(GetDefaultContext(), &cfg)
+			if err != nil {
+				log.Error("failed to read the backup status", zap.Error(err))
+				return errors.Trace(err)
+			}
+			state := progress.State
+			if progress.IsStale(time.Now()) {
+				state += " (stale, not updated since " + progress.UpdateTime.Format(time.RFC3339) + ")"
+			}
+			command.Printf("State: %s\n", state)
+			command.Printf("Phase: %s\n", progress.Phase)
+			if progress.Total > 0 {
+				command.Printf("Completed: %d/%d %ss (%.1f%%)\n", progress.Completed, progress.Total, progress.Unit,
+					float64(progress.Completed)*100/float64(progress.Total))
+			}
+			command.Printf("Size: %s\n", units.HumanSize(float64(progress.Size)))
+			command.Printf("Started: %s\n", progress.StartTime.Format(time.RFC3339))
+			command.Printf("Updated: %s\n", progress.UpdateTime.Format(time.RFC3339))
+			if progress.ETA > 0 {
+				command.Printf("ETA: %s\n", progress.ETA)
+			}
+			if progress.Error != "" {
+				command.Printf("Error: %s\n", progress.Error)
+			}
+			return nil
+		},
+	}
+	return command
+}
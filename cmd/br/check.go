@@ -0,0 +1,74 @@
+// Copyright 2021 PingCAP, Inc. Licensed under Apache-2.0.
+
+package main
+
+import (
+	"strings"
+
+	"github.com/pingcap/errors"
+	"github.com/pingcap/log"
+	"github.com/pingcap/tidb/ddl"
+	"github.com/spf13/cobra"
+	"go.uber.org/zap"
+
+	"github.com/pingcap/br/pkg/task"
+	"github.com/pingcap/br/pkg/utils"
+	"github.com/pingcap/br/pkg/version/build"
+)
+
+// NewCheckCommand returns a check subcommand.
+func NewCheckCommand() *cobra.Command {
+	command := &cobra.Command{
+		Use:          "check",
+		Short:        "check whether a task would fail before running it",
+		SilenceUsage: true,
+		PersistentPreRunE: func(c *cobra.Command, args []string) error {
+			if err := Init(c); err != nil {
+				return errors.Trace(err)
+			}
+			build.LogInfo(build.BR)
+			utils.LogEnvVariables()
+			task.LogArguments(c)
+
+			// Do not run ddl worker in BR.
+			ddl.RunWorker = false
+			return nil
+		},
+	}
+	command.AddCommand(newCheckBackupCommand())
+	return command
+}
+
+// newCheckBackupCommand returns a backup pre-flight check subcommand.
+func newCheckBackupCommand() *cobra.Command {
+	command := &cobra.Command{
+		Use:   "backup",
+		Short: "check the storage and the cluster for a full backup",
+		Long: "check the permissions of writing, listing and deleting files in the storage, " +
+			"the connectivity and the versions of PD and TiKV, the headroom of the GC safe point, " +
+			"and the estimated backup size against --storage-quota, without backing up anything",
+		Args: cobra.NoArgs,
+		RunE: runCheckBackupCommand,
+	}
+	task.DefineBackupFlags(command.Flags())
+	task.DefineFilterFlags(command, acceptAllTables)
+	task.DefineCheckBackupFlags(command)
+	return command
+}
+
+func runCheckBackupCommand(cmd *cobra.Command, _ []string) error {
+	cfg := task.CheckBackupConfig{BackupConfig: task.BackupConfig{Config: task.Config{LogProgress: HasLogFile()}}}
+	if err := cfg.ParseFromFlags(cmd.Flags()); err != nil {
+		cmd.SilenceUsage = false
+		return errors.Trace(err)
+	}
+	results, err := task.RunCheckBackup(GetDefaultContext(), tidbGlue, &cfg)
+	for _, r := range results {
+		cmd.Printf("[%s] %s: %s\n", strings.ToUpper(string(r.Status)), r.Name, r.Detail)
+	}
+	if err != nil {
+		log.Error("backup pre-flight check failed", zap.Error(err))
+		return errors.Trace(err)
+	}
+	return nil
+}
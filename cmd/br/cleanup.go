@@ -0,0 +1,91 @@
+// Copyright 2021 PingCAP, Inc. Licensed under Apache-2.0.
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"strings"
+
+	"github.com/pingcap/errors"
+	"github.com/pingcap/log"
+	"github.com/spf13/cobra"
+	"go.uber.org/zap"
+
+	"github.com/pingcap/br/pkg/task"
+	"github.com/pingcap/br/pkg/utils"
+	"github.com/pingcap/br/pkg/version/build"
+)
+
+const flagYes = "yes"
+
+// askConfirmation prints the question and reads the answer from the stdin,
+// only y or yes confirms it.
+func askConfirmation(cmd *cobra.Command, question string) bool {
+	cmd.Printf("%s [y/N] ", question)
+	answer, _ := bufio.NewReader(cmd.InOrStdin()).ReadString('\n')
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return answer == "y" || answer == "yes"
+}
+
+// NewCleanupCommand returns a cleanup subcommand.
+func NewCleanupCommand() *cobra.Command {
+	command := &cobra.Command{
+		Use:   "cleanup",
+		Short: "delete the files of a backup from the storage",
+		Long: "delete exactly the files referenced by the backupmeta in the storage, " +
+			"the other files under the same prefix are kept",
+		Args:         cobra.NoArgs,
+		SilenceUsage: true,
+		PersistentPreRunE: func(c *cobra.Command, args []string) error {
+			if err := Init(c); err != nil {
+				return errors.Trace(err)
+			}
+			build.LogInfo(build.BR)
+			utils.LogEnvVariables()
+			task.LogArguments(c)
+			return nil
+		},
+		RunE: runCleanupCommand,
+	}
+	task.DefineCleanupFlags(command)
+	command.Flags().BoolP(flagYes, "y", false, "delete the files without confirmation")
+	return command
+}
+
+func runCleanupCommand(cmd *cobra.Command, _ []string) error {
+	var cfg task.CleanupConfig
+	if err := cfg.ParseFromFlags(cmd.Flags()); err != nil {
+		cmd.SilenceUsage = false
+		return errors.Trace(err)
+	}
+	yes, err := cmd.Flags().GetBool(flagYes)
+	if err != nil {
+		return errors.Trace(err)
+	}
+
+	confirm := func(plan *task.CleanupPlan) bool {
+		if len(plan.Files) == 0 {
+			cmd.Printf("no file of the backup is found in %s\n", plan.URI)
+			return false
+		}
+		if yes {
+			return true
+		}
+		return askConfirmation(cmd, fmt.Sprintf("delete %d files (%d bytes) of the backup in %s, %d unrelated files are kept?",
+			len(plan.Files), plan.Size, plan.URI, plan.Unrelated))
+	}
+	plan, err := task.RunCleanup(GetDefaultContext(), &cfg, confirm)
+	if err != nil {
+		log.Error("failed to cleanup", zap.Error(err))
+		return errors.Trace(err)
+	}
+	if cfg.DryRun {
+		for _, name := range plan.Files {
+			cmd.Println(name)
+		}
+		cmd.Printf("%d files (%d bytes) of the backup would be deleted, %d unrelated files are kept\n",
+			len(plan.Files), plan.Size, plan.Unrelated)
+	}
+	return nil
+}
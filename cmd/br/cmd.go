@@ -17,6 +17,7 @@ import (
 	"github.com/pingcap/tidb/util/logutil"
 	"github.com/spf13/cobra"
 
+	berrors "github.com/pingcap/br/pkg/errors"
 	"github.com/pingcap/br/pkg/gluetidb"
 	"github.com/pingcap/br/pkg/redact"
 	"github.com/pingcap/br/pkg/summary"
@@ -29,6 +30,7 @@ var (
 	initOnce        = sync.Once{}
 	defaultContext  context.Context
 	hasLogFile      uint64
+	metricsPusher   *utils.MetricsPusher
 	tidbGlue        = gluetidb.New()
 	envLogToTermKey = "BR_LOG_TO_TERM"
 
@@ -56,12 +58,26 @@ const (
 	FlagLogFormat = "log-format"
 	// FlagStatusAddr is the name of status-addr flag.
 	FlagStatusAddr = "status-addr"
+	// FlagStatusToken is the name of status-token flag.
+	FlagStatusToken = "status-token"
+	// FlagStatusControlToken is the name of status-control-token flag.
+	FlagStatusControlToken = "status-control-token"
+	// FlagStatusClientCN is the name of status-client-cn flag.
+	FlagStatusClientCN = "status-client-cn"
+	// FlagMetricsPushURL is the name of metrics-push-url flag.
+	FlagMetricsPushURL = "metrics-push-url"
+	// FlagMetricsPushJob is the name of metrics-push-job flag.
+	FlagMetricsPushJob = "metrics-push-job"
+	// FlagMetricsPushInterval is the name of metrics-push-interval flag.
+	FlagMetricsPushInterval = "metrics-push-interval"
 	// FlagSlowLogFile is the name of slow-log-file flag.
 	FlagSlowLogFile = "slow-log-file"
 	// FlagRedactLog is whether to redact sensitive information in log, already deprecated by FlagRedactInfoLog
 	FlagRedactLog = "redact-log"
 	// FlagRedactInfoLog is whether to redact sensitive information in log.
 	FlagRedactInfoLog = "redact-info-log"
+	// FlagOutput is the name of output flag.
+	FlagOutput = "output"
 
 	flagVersion      = "version"
 	flagVersionShort = "V"
@@ -89,6 +105,26 @@ func AddFlags(cmd *cobra.Command) {
 		"Set whether to redact sensitive info in log")
 	cmd.PersistentFlags().String(FlagStatusAddr, "",
 		"Set the HTTP listening address for the status report service. Set to empty string to disable")
+	cmd.PersistentFlags().String(FlagStatusToken, "",
+		"Set the bearer token required by the read-only requests to the status service. "+
+			"Set both tokens to empty string to disable authentication")
+	cmd.PersistentFlags().String(FlagStatusControlToken, "",
+		"Set the bearer token required by the requests that may change the state of the task to the status service, "+
+			"it also grants the read-only requests")
+	cmd.PersistentFlags().StringSlice(FlagStatusClientCN, nil,
+		"Require the clients of the status service to present a certificate signed by --ca "+
+			"with one of the common names, only works when TLS is enabled")
+	cmd.PersistentFlags().String(FlagMetricsPushURL, "",
+		"Set the address of the Prometheus pushgateway to push the metrics to, "+
+			"the metrics are pushed periodically and when the command finishes. Set to empty string to disable")
+	cmd.PersistentFlags().String(FlagMetricsPushJob, utils.DefaultMetricsPushJob,
+		"Set the job label of the metrics pushed to the pushgateway, the instance label is the hostname and pid")
+	cmd.PersistentFlags().Duration(FlagMetricsPushInterval, utils.DefaultMetricsPushInterval,
+		"Set the interval of pushing the metrics to the pushgateway")
+	cmd.PersistentFlags().String(FlagOutput, outputText,
+		"Set the output format of the result, text|json. With json, the final line of the stdout is "+
+			"a JSON document containing the status, error, summary and artifacts of the command")
+	defineHookFlags(cmd.PersistentFlags())
 	task.DefineCommonFlags(cmd.PersistentFlags())
 
 	cmd.PersistentFlags().StringP(FlagSlowLogFile, "", "",
@@ -100,6 +136,9 @@ func AddFlags(cmd *cobra.Command) {
 // Init initializes BR cli.
 func Init(cmd *cobra.Command) (err error) {
 	initOnce.Do(func() {
+		if err = checkOutputFormat(cmd); err != nil {
+			return
+		}
 		slowLogFilename, e := cmd.Flags().GetString(FlagSlowLogFile)
 		if e != nil {
 			err = e
@@ -143,6 +182,7 @@ func Init(cmd *cobra.Command) (err error) {
 		if len(conf.File.Filename) != 0 {
 			atomic.StoreUint64(&hasLogFile, 1)
 			summary.InitCollector(true)
+			summary.CollectArtifact("log", conf.File.Filename)
 			// cmd.PrintErr prints to stderr, but PrintErrf prints to stdout.
 			cmd.PrintErr(fmt.Sprintf("Detail BR log in %s \n", conf.File.Filename))
 		}
@@ -164,7 +204,10 @@ func Init(cmd *cobra.Command) (err error) {
 			return
 		}
 		redact.InitRedact(redactLog || redactInfoLog)
-		err = startPProf(cmd)
+		if err = startPProf(cmd); err != nil {
+			return
+		}
+		err = startMetricsPusher(cmd)
 	})
 	return errors.Trace(err)
 }
@@ -175,23 +218,72 @@ func startPProf(cmd *cobra.Command) error {
 	if err != nil {
 		return errors.Trace(err)
 	}
+	auth := &utils.StatusAuth{}
+	if auth.ReadToken, err = cmd.Flags().GetString(FlagStatusToken); err != nil {
+		return errors.Trace(err)
+	}
+	if auth.ControlToken, err = cmd.Flags().GetString(FlagStatusControlToken); err != nil {
+		return errors.Trace(err)
+	}
+	clientCN, err := cmd.Flags().GetStringSlice(FlagStatusClientCN)
+	if err != nil {
+		return errors.Trace(err)
+	}
 	ca, cert, key, err := task.ParseTLSTripleFromFlags(cmd.Flags())
 	if err != nil {
 		return errors.Trace(err)
 	}
+	if len(clientCN) > 0 && ca == "" {
+		return errors.Annotatef(berrors.ErrInvalidArgument, "--%s requires TLS to be enabled", FlagStatusClientCN)
+	}
+	if auth.Enabled() && ca == "" {
+		log.Warn("the tokens of the status service are sent in plain text since TLS isn't enabled")
+	}
 	// Host isn't used here.
-	tls, err := tidbutils.NewTLS(ca, cert, key, "localhost", nil)
+	tls, err := tidbutils.NewTLS(ca, cert, key, "localhost", clientCN)
 	if err != nil {
 		return errors.Trace(err)
 	}
 
 	if statusAddr != "" {
-		return utils.StartPProfListener(statusAddr, tls)
+		return utils.StartPProfListener(statusAddr, tls, auth)
+	}
+	utils.StartDynamicPProfListener(tls, auth)
+	return nil
+}
+
+func startMetricsPusher(cmd *cobra.Command) error {
+	url, err := cmd.Flags().GetString(FlagMetricsPushURL)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if url == "" {
+		return nil
+	}
+	job, err := cmd.Flags().GetString(FlagMetricsPushJob)
+	if err != nil {
+		return errors.Trace(err)
 	}
-	utils.StartDynamicPProfListener(tls)
+	if job == "" {
+		return errors.Annotatef(berrors.ErrInvalidArgument, "--%s must not be empty", FlagMetricsPushJob)
+	}
+	interval, err := cmd.Flags().GetDuration(FlagMetricsPushInterval)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if interval <= 0 {
+		return errors.Annotatef(berrors.ErrInvalidArgument, "--%s must be positive", FlagMetricsPushInterval)
+	}
+	metricsPusher = utils.StartMetricsPusher(url, job, interval)
 	return nil
 }
 
+// StopMetricsPusher pushes the final metrics to the pushgateway if it's
+// enabled.
+func StopMetricsPusher() {
+	metricsPusher.Stop()
+}
+
 // HasLogFile returns whether we set a log file.
 func HasLogFile() bool {
 	return atomic.LoadUint64(&hasLogFile) != uint64(0)
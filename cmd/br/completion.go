@@ -0,0 +1,149 @@
+// Copyright 2021 PingCAP, Inc. Licensed under Apache-2.0.
+
+package main
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pingcap/errors"
+	"github.com/pingcap/log"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"go.uber.org/zap/zapcore"
+
+	"github.com/pingcap/br/pkg/task"
+)
+
+// completionTimeout is how long the completion can wait for the storage and
+// the cluster, the shell is blocked meanwhile.
+const completionTimeout = 5 * time.Second
+
+// NewCompletionCommand returns a completion subcommand.
+func NewCompletionCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:   "completion [bash|zsh|fish|powershell]",
+		Short: "generate the completion script of the shell",
+		Long: "generate the completion script of the shell, e.g. for bash\n" +
+			"  source <(br completion bash)\n" +
+			"the tables of the backup in --storage or of the cluster in --pd are completed if reachable",
+		Args:         cobra.ExactValidArgs(1),
+		ValidArgs:    []string{"bash", "zsh", "fish", "powershell"},
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			root, out := cmd.Root(), cmd.OutOrStdout()
+			var err error
+			switch args[0] {
+			case "bash":
+				err = root.GenBashCompletion(out)
+			case "zsh":
+				err = root.GenZshCompletion(out)
+			case "fish":
+				err = root.GenFishCompletion(out, true)
+			case "powershell":
+				err = root.GenPowerShellCompletion(out)
+			}
+			return errors.Trace(err)
+		},
+	}
+}
+
+// completionFunc is the function completing the value of a flag.
+type completionFunc = func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective)
+
+// registerCompletions registers the completion of the flag values of the
+// command and its subcommands.
+func registerCompletions(cmd *cobra.Command, listTables func(*cobra.Command) (map[string][]string, error)) {
+	switch cmd.Name() {
+	case "backup":
+		listTables = func(c *cobra.Command) (map[string][]string, error) {
+			ctx, cancel := context.WithTimeout(GetDefaultContext(), completionTimeout)
+			defer cancel()
+			return task.ListClusterTables(ctx, tidbGlue, c.Flags())
+		}
+	case "restore":
+		listTables = func(c *cobra.Command) (map[string][]string, error) {
+			ctx, cancel := context.WithTimeout(GetDefaultContext(), completionTimeout)
+			defer cancel()
+			return task.ListBackupTables(ctx, c.Flags())
+		}
+	}
+	register := func(flag *pflag.Flag) {
+		if f := flagCompletion(flag.Name, listTables); f != nil {
+			// the flag is registered by the command defining it only once.
+			_ = cmd.RegisterFlagCompletionFunc(flag.Name, f)
+		}
+	}
+	cmd.Flags().VisitAll(register)
+	cmd.PersistentFlags().VisitAll(register)
+	for _, sub := range cmd.Commands() {
+		registerCompletions(sub, listTables)
+	}
+}
+
+func flagCompletion(name string, listTables func(*cobra.Command) (map[string][]string, error)) completionFunc {
+	switch {
+	case task.IsStorageFlag(name):
+		return func(_ *cobra.Command, _ []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+			if strings.Contains(toComplete, "://") {
+				return nil, cobra.ShellCompDirectiveDefault
+			}
+			return task.StorageSchemes, cobra.ShellCompDirectiveNoSpace
+		}
+	case task.IsTableFlag(name):
+		if listTables == nil {
+			return nil
+		}
+		return func(c *cobra.Command, _ []string, _ string) ([]string, cobra.ShellCompDirective) {
+			// the logs would mess up the completion.
+			log.SetLevel(zapcore.FatalLevel)
+			tables, err := listTables(c)
+			if err != nil {
+				return nil, cobra.ShellCompDirectiveError
+			}
+			return tableCompletions(c, name, tables), cobra.ShellCompDirectiveNoFileComp
+		}
+	}
+	var values []string
+	switch name {
+	case FlagLogLevel:
+		values = []string{"debug", "info", "warn", "error", "fatal"}
+	case FlagLogFormat:
+		values = []string{"text", "json"}
+	case FlagOutput:
+		values = []string{outputText, outputJSON}
+	default:
+		values = task.FlagValues(name)
+	}
+	if values == nil {
+		return nil
+	}
+	return func(_ *cobra.Command, _ []string, _ string) ([]string, cobra.ShellCompDirective) {
+		return values, cobra.ShellCompDirectiveNoFileComp
+	}
+}
+
+// tableCompletions returns the databases for --db, the tables of --db for
+// --table and database.table for --filter.
+func tableCompletions(c *cobra.Command, name string, tables map[string][]string) []string {
+	var completions []string
+	switch name {
+	case "db":
+		for db := range tables {
+			completions = append(completions, db)
+		}
+	case "table":
+		db, _ := c.Flags().GetString("db")
+		completions = append(completions, tables[db]...)
+	default:
+		for db, names := range tables {
+			for _, table := range names {
+				completions = append(completions, db+"."+table)
+			}
+		}
+	}
+	sort.Strings(completions)
+	return completions
+}
@@ -0,0 +1,64 @@
+// Copyright 2021 PingCAP, Inc. Licensed under Apache-2.0.
+
+package main
+
+import (
+	"github.com/pingcap/errors"
+	"github.com/pingcap/log"
+	"github.com/spf13/cobra"
+	"go.uber.org/zap"
+
+	"github.com/pingcap/br/pkg/task"
+	"github.com/pingcap/br/pkg/utils"
+	"github.com/pingcap/br/pkg/version/build"
+)
+
+// NewCopyCommand returns a copy subcommand.
+func NewCopyCommand() *cobra.Command {
+	command := &cobra.Command{
+		Use:   "copy",
+		Short: "copy a backup between storages",
+		Long: "copy exactly the files of the backup from --from to --to with --concurrency workers and verify them, " +
+			"the backupmetas are copied last so the destination isn't a complete backup until the copy finishes. " +
+			"an interrupted copy is resumed by running it again, " +
+			"the files already copied with the same checksums are skipped. " +
+			"the data files referenced from the other backups by --dedup-base-files are copied into the destination",
+		Example:      "br copy --from s3://bucket/backup --to gcs://bucket/backup",
+		Args:         cobra.NoArgs,
+		SilenceUsage: true,
+		PersistentPreRunE: func(c *cobra.Command, args []string) error {
+			if err := Init(c); err != nil {
+				return errors.Trace(err)
+			}
+			build.LogInfo(build.BR)
+			utils.LogEnvVariables()
+			task.LogArguments(c)
+			return nil
+		},
+		RunE: runCopyCommand,
+	}
+	task.DefineCopyFlags(command)
+	return command
+}
+
+func runCopyCommand(cmd *cobra.Command, _ []string) error {
+	var cfg task.CopyConfig
+	if err := cfg.ParseFromFlags(cmd.Flags()); err != nil {
+		cmd.SilenceUsage = false
+		return errors.Trace(err)
+	}
+	result, err := task.RunCopy(GetDefaultContext(), &cfg)
+	if err != nil {
+		log.Error("failed to copy the backup", zap.Error(err))
+		return errors.Trace(err)
+	}
+	cmd.Printf("%d files (%d bytes) copied to %s, %d files already copied are skipped\n",
+		result.Copied, result.CopiedSize, cfg.To, result.Skipped)
+	if result.Verified > 0 {
+		cmd.Printf("%d files verified with the checksum manifest\n", result.Verified)
+	}
+	if result.Resolved > 0 {
+		cmd.Printf("%d files referenced from the other backups are copied into the backup\n", result.Resolved)
+	}
+	return nil
+}
@@ -8,9 +8,13 @@ import (
 	"crypto/sha256"
 	"encoding/hex"
 	"encoding/json"
+	"fmt"
 	"path"
 	"reflect"
+	"strings"
+	"text/tabwriter"
 
+	"github.com/docker/go-units"
 	"github.com/gogo/protobuf/proto"
 	"github.com/pingcap/errors"
 	backuppb "github.com/pingcap/kvproto/pkg/backup"
@@ -26,6 +30,7 @@ import (
 	"github.com/pingcap/br/pkg/mock/mockid"
 	"github.com/pingcap/br/pkg/restore"
 	"github.com/pingcap/br/pkg/rtree"
+	"github.com/pingcap/br/pkg/summary"
 	"github.com/pingcap/br/pkg/task"
 	"github.com/pingcap/br/pkg/utils"
 	"github.com/pingcap/br/pkg/version/build"
@@ -50,10 +55,16 @@ func NewDebugCommand() *cobra.Command {
 		Aliases: []string{"validate"},
 	}
 	meta.AddCommand(newCheckSumCommand())
+	meta.AddCommand(newVerifyManifestCommand())
 	meta.AddCommand(newBackupMetaCommand())
 	meta.AddCommand(decodeBackupMetaCommand())
 	meta.AddCommand(encodeBackupMetaCommand())
+	meta.AddCommand(rebuildBackupMetaCommand())
+	meta.AddCommand(convertBackupMetaCommand())
 	meta.AddCommand(setPDConfigCommand())
+	meta.AddCommand(planRestoreCommand())
+	meta.AddCommand(peekTableCommand())
+	meta.AddCommand(newCopyCommand())
 	meta.Hidden = true
 
 	return meta
@@ -145,6 +156,56 @@ origin sha256 is %s`,
 	return command
 }
 
+func newVerifyManifestCommand() *cobra.Command {
+	command := &cobra.Command{
+		Use:   "data",
+		Short: "verify the backup files with the checksum manifest",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			ctx, cancel := context.WithCancel(GetDefaultContext())
+			defer cancel()
+
+			var cfg task.Config
+			if err := cfg.ParseFromFlags(cmd.Flags()); err != nil {
+				return errors.Trace(err)
+			}
+			_, s, err := task.GetStorage(ctx, &cfg)
+			if err != nil {
+				return errors.Trace(err)
+			}
+
+			result, err := metautil.VerifyChecksumManifest(ctx, s, uint(cfg.Concurrency))
+			if result != nil {
+				for _, name := range result.Mismatched {
+					cmd.Printf("MISMATCHED: %s\n", name)
+				}
+				for _, name := range result.Missing {
+					cmd.Printf("MISSING: %s\n", name)
+				}
+				for _, name := range result.Unlisted {
+					cmd.Printf("UNLISTED: %s\n", name)
+				}
+			}
+			if err != nil {
+				return errors.Trace(err)
+			}
+			cmd.Printf("%d backup files verified\n", result.Verified)
+			lock, err := metautil.ReadRetention(ctx, s)
+			if err != nil {
+				return errors.Trace(err)
+			}
+			if lock != nil && lock.Mode != "" {
+				cmd.Printf("backup files are locked in %s mode until %s\n", lock.Mode, lock.RetainUntil)
+			}
+			if lock != nil && lock.LegalHold {
+				cmd.Println("backup files are under legal hold")
+			}
+			return nil
+		},
+	}
+	return command
+}
+
 func newBackupMetaCommand() *cobra.Command {
 	command := &cobra.Command{
 		Use:          "backupmeta",
@@ -249,6 +310,184 @@ func newBackupMetaValidateCommand() *cobra.Command {
 	return command
 }
 
+func planRestoreCommand() *cobra.Command {
+	command := &cobra.Command{
+		Use:   "plan-restore",
+		Short: "simulate the merge, split and batches of restoring the backup without a cluster",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			ctx, cancel := context.WithCancel(GetDefaultContext())
+			defer cancel()
+
+			var cfg task.Config
+			if err := cfg.ParseFromFlags(cmd.Flags()); err != nil {
+				return errors.Trace(err)
+			}
+			var (
+				opts restore.PlanOptions
+				err  error
+			)
+			if opts.Stores, err = cmd.Flags().GetInt("stores"); err != nil {
+				return errors.Trace(err)
+			}
+			if opts.Replicas, err = cmd.Flags().GetInt("replicas"); err != nil {
+				return errors.Trace(err)
+			}
+			if opts.BatchSize, err = cmd.Flags().GetInt("batch-size"); err != nil {
+				return errors.Trace(err)
+			}
+			if opts.SplitSizeBytes, err = cmd.Flags().GetUint64(task.FlagMergeRegionSizeBytes); err != nil {
+				return errors.Trace(err)
+			}
+			if opts.SplitKeyCount, err = cmd.Flags().GetUint64(task.FlagMergeRegionKeyCount); err != nil {
+				return errors.Trace(err)
+			}
+
+			_, s, backupMeta, err := task.ReadBackupMeta(ctx, metautil.MetaFile, &cfg)
+			if err != nil {
+				return errors.Trace(err)
+			}
+			var filesOfTables [][]*backuppb.File
+			if backupMeta.IsRawKv {
+				filesOfTables = append(filesOfTables, backupMeta.Files)
+			} else {
+				dbs, err := utils.LoadBackupTables(ctx, metautil.NewMetaReader(backupMeta, s))
+				if err != nil {
+					return errors.Trace(err)
+				}
+				for _, db := range dbs {
+					for _, table := range db.Tables {
+						filesOfTables = append(filesOfTables, table.Files)
+					}
+				}
+			}
+			plan, err := restore.PlanRestore(filesOfTables, opts)
+			if err != nil {
+				return errors.Trace(err)
+			}
+
+			cmd.Printf("tables: %d, files: %d, size: %s, kvs: %d\n",
+				plan.Tables, plan.Files, units.HumanSize(float64(plan.Bytes)), plan.Kvs)
+			cmd.Printf("ranges: %d, regions after merging: %d, split keys: %d, batches: %d\n",
+				plan.Ranges, plan.Regions, plan.SplitKeys, plan.Batches)
+			for _, store := range plan.Stores {
+				cmd.Printf("store %d: regions: %d, leaders: %d, size: %s, kvs: %d\n",
+					store.StoreID, store.Regions, store.Leaders, units.HumanSize(float64(store.Bytes)), store.Kvs)
+			}
+			return nil
+		},
+	}
+	command.Flags().Int("stores", 3, "the number of the TiKV stores of the target cluster")
+	command.Flags().Int("replicas", 3, "the number of the replicas of a region in the target cluster")
+	// the batch size of the restore is the --concurrency, 128 by default.
+	command.Flags().Int("batch-size", 128, "the number of the ranges in a batch")
+	command.Flags().Uint64(task.FlagMergeRegionSizeBytes, restore.DefaultMergeRegionSizeBytes,
+		"the threshold of merging small regions (Default 96MB, region split size)")
+	command.Flags().Uint64(task.FlagMergeRegionKeyCount, restore.DefaultMergeRegionKeyCount,
+		"the threshold of merging small regions (Default 960_000, region split key count)")
+	return command
+}
+
+func peekTableCommand() *cobra.Command {
+	command := &cobra.Command{
+		Use:   "peek-table",
+		Short: "decode and print the sample rows of a table from the backup files without restoring",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			ctx, cancel := context.WithCancel(GetDefaultContext())
+			defer cancel()
+
+			var cfg task.Config
+			if err := cfg.ParseFromFlags(cmd.Flags()); err != nil {
+				return errors.Trace(err)
+			}
+			dbName, err := cmd.Flags().GetString("db")
+			if err != nil {
+				return errors.Trace(err)
+			}
+			tableName, err := cmd.Flags().GetString("table")
+			if err != nil {
+				return errors.Trace(err)
+			}
+			limit, err := cmd.Flags().GetInt("limit")
+			if err != nil {
+				return errors.Trace(err)
+			}
+			if dbName == "" || tableName == "" {
+				return errors.Annotate(berrors.ErrInvalidArgument, "--db and --table are required")
+			}
+			if limit <= 0 {
+				return errors.Annotate(berrors.ErrInvalidArgument, "--limit must be positive")
+			}
+
+			_, s, err := task.GetStorage(ctx, &cfg)
+			if err != nil {
+				return errors.Trace(err)
+			}
+			exists, err := s.FileExists(ctx, metautil.MetaFile)
+			if err != nil {
+				return errors.Trace(err)
+			}
+			var result *restore.PeekResult
+			if exists {
+				_, _, backupMeta, err := task.ReadBackupMeta(ctx, metautil.MetaFile, &cfg)
+				if err != nil {
+					return errors.Trace(err)
+				}
+				if backupMeta.IsRawKv {
+					return errors.Annotate(berrors.ErrInvalidArgument, "the raw kv backup has no table")
+				}
+				dbs, err := utils.LoadBackupTables(ctx, metautil.NewMetaReader(backupMeta, s))
+				if err != nil {
+					return errors.Trace(err)
+				}
+				db, ok := dbs[dbName]
+				if !ok {
+					return errors.Annotatef(berrors.ErrInvalidArgument, "database %s not found in the backup", dbName)
+				}
+				table := db.GetTable(tableName)
+				if table == nil {
+					return errors.Annotatef(berrors.ErrInvalidArgument, "table %s.%s not found in the backup", dbName, tableName)
+				}
+				result, err = restore.PeekTableRows(ctx, s, table, limit)
+				if err != nil {
+					return errors.Trace(err)
+				}
+			} else {
+				// the storage without backupmeta is treated as a cdclog backup.
+				result, err = restore.PeekLogTableRows(ctx, s, dbName, tableName, limit)
+				if err != nil {
+					return errors.Trace(err)
+				}
+			}
+
+			w := tabwriter.NewWriter(cmd.OutOrStdout(), 0, 4, 2, ' ', 0)
+			fmt.Fprintln(w, "_handle\t"+strings.Join(result.Columns, "\t"))
+			for _, row := range result.Rows {
+				values := make([]string, 0, len(row.Values)+1)
+				values = append(values, row.Handle)
+				for _, v := range row.Values {
+					if v == nil {
+						values = append(values, "NULL")
+					} else {
+						values = append(values, *v)
+					}
+				}
+				fmt.Fprintln(w, strings.Join(values, "\t"))
+			}
+			if err = w.Flush(); err != nil {
+				return errors.Trace(err)
+			}
+			cmd.Printf("%d rows\n", len(result.Rows))
+			return nil
+		},
+	}
+	command.Flags().String("db", "", "the database of the table")
+	command.Flags().String("table", "", "the table to peek")
+	command.Flags().IntP("limit", "n", 10, "the number of the rows to print")
+	return command
+}
+
 func decodeBackupMetaCommand() *cobra.Command {
 	decodeBackupMetaCmd := &cobra.Command{
 		Use:   "decode",
@@ -279,6 +518,7 @@ func decodeBackupMetaCommand() *cobra.Command {
 					return errors.Trace(err)
 				}
 				cmd.Printf("backupmeta decoded at %s\n", path.Join(cfg.Storage, metautil.MetaJSONFile))
+				summary.CollectArtifact("backupmeta", s.URI()+"/"+metautil.MetaJSONFile)
 				return nil
 			}
 
@@ -351,12 +591,134 @@ func encodeBackupMetaCommand() *cobra.Command {
 			if err != nil {
 				return errors.Trace(err)
 			}
+			summary.CollectArtifact("backupmeta", s.URI()+"/"+fileName)
 			return nil
 		},
 	}
 	return encodeBackupMetaCmd
 }
 
+func newCopyCommand() *cobra.Command {
+	command := &cobra.Command{
+		Use:   "copy",
+		Short: "copy a backup to another storage",
+		Long: "copy the files of the backup to another storage and verify them, " +
+			"the files are copied inside S3 if both storages are the buckets of the same endpoint",
+		Args: cobra.NoArgs,
+		RunE: runCopyCommand,
+	}
+	task.DefineCopyFlags(command)
+	return command
+}
+
+func rebuildBackupMetaCommand() *cobra.Command {
+	rebuildBackupMetaCmd := &cobra.Command{
+		Use:   "rebuild-meta",
+		Short: "rebuild a best-effort backupmeta from the SST files",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ctx, cancel := context.WithCancel(GetDefaultContext())
+			defer cancel()
+
+			var cfg task.Config
+			if err := cfg.ParseFromFlags(cmd.Flags()); err != nil {
+				return errors.Trace(err)
+			}
+			_, s, err := task.GetStorage(ctx, &cfg)
+			if err != nil {
+				return errors.Trace(err)
+			}
+
+			isRawKv, _ := cmd.Flags().GetBool("raw")
+			opts := metautil.RebuildOptions{IsRawKv: isRawKv}
+			schemaFrom, _ := cmd.Flags().GetString("schema-from")
+			if schemaFrom != "" {
+				refCfg := cfg
+				refCfg.Storage = schemaFrom
+				_, refStorage, refMeta, err := task.ReadBackupMeta(ctx, metautil.MetaFile, &refCfg)
+				if err != nil {
+					return errors.Annotate(err, "failed to read the schema reference")
+				}
+				opts.SchemaReference = metautil.NewMetaReader(refMeta, refStorage)
+			}
+
+			backupMeta, report, err := metautil.RebuildBackupMeta(ctx, s, opts)
+			if err != nil {
+				return errors.Trace(err)
+			}
+			data, err := proto.Marshal(backupMeta)
+			if err != nil {
+				return errors.Trace(err)
+			}
+			fileName := metautil.MetaFile
+			if ok, _ := s.FileExists(ctx, fileName); ok {
+				// Do not overwrite origin meta file
+				fileName = metautil.RebuiltMetaFile
+			}
+			if err = s.WriteFile(ctx, fileName, data); err != nil {
+				return errors.Trace(err)
+			}
+			summary.CollectArtifact("backupmeta", s.URI()+"/"+fileName)
+
+			reportJSON, err := json.MarshalIndent(report, "", "  ")
+			if err != nil {
+				return errors.Trace(err)
+			}
+			log.Info("backupmeta rebuilt", zap.String("file", fileName), zap.ByteString("report", reportJSON))
+			cmd.Printf("backupmeta rebuilt at %s, the checksum must be disabled when restoring\n",
+				path.Join(cfg.Storage, fileName))
+			cmd.Println(string(reportJSON))
+			return nil
+		},
+	}
+
+	rebuildBackupMetaCmd.Flags().Bool("raw", false, "whether the SST files are backed up by raw backup")
+	rebuildBackupMetaCmd.Flags().String("schema-from", "",
+		"the storage url of another backup of the same cluster, whose table schemas are reused")
+
+	return rebuildBackupMetaCmd
+}
+
+func convertBackupMetaCommand() *cobra.Command {
+	convertBackupMetaCmd := &cobra.Command{
+		Use:   "convert-meta",
+		Short: "convert a v1 backupmeta into the sharded v2 one",
+		Long: "convert a v1 backupmeta into v2, whose data files, schemas and DDLs are sharded into metafiles, " +
+			"so that a backup of millions of files can be restored without loading the whole backupmeta, " +
+			"the v1 backupmeta is kept as " + metautil.MetaV1File,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ctx, cancel := context.WithCancel(GetDefaultContext())
+			defer cancel()
+
+			var cfg task.Config
+			if err := cfg.ParseFromFlags(cmd.Flags()); err != nil {
+				return errors.Trace(err)
+			}
+			_, s, backupMeta, err := task.ReadBackupMeta(ctx, metautil.MetaFile, &cfg)
+			if err != nil {
+				return errors.Trace(err)
+			}
+			sizeLimit, err := cmd.Flags().GetUint64("metafile-size")
+			if err != nil {
+				return errors.Trace(err)
+			}
+			converted, err := metautil.ConvertToMetaV2(ctx, s, backupMeta, int(sizeLimit))
+			if err != nil {
+				return errors.Trace(err)
+			}
+			cmd.Printf("backupmeta converted to version %d, the v1 one is kept at %s\n",
+				converted.GetVersion(), path.Join(cfg.Storage, metautil.MetaV1File))
+			return nil
+		},
+	}
+
+	convertBackupMetaCmd.Flags().Uint64("metafile-size", metautil.MetaFileSize,
+		"the size limit of each sharded metafile in bytes")
+
+	return convertBackupMetaCmd
+}
+
 func setPDConfigCommand() *cobra.Command {
 	pdConfigCmd := &cobra.Command{
 		Use:   "reset-pd-config-as-default",
@@ -371,7 +733,7 @@ func setPDConfigCommand() *cobra.Command {
 				return errors.Trace(err)
 			}
 
-			mgr, err := task.NewMgr(ctx, tidbGlue, cfg.PD, cfg.TLS, task.GetKeepalive(&cfg), cfg.CheckRequirements, false)
+			mgr, err := task.NewMgr(ctx, tidbGlue, cfg.PD, cfg.TLS, task.GetKeepalive(&cfg), cfg.Timeout, cfg.CheckRequirements, false)
 			if err != nil {
 				return errors.Trace(err)
 			}
@@ -0,0 +1,78 @@
+// Copyright 2021 PingCAP, Inc. Licensed under Apache-2.0.
+
+package main
+
+import (
+	"github.com/pingcap/errors"
+	"github.com/pingcap/log"
+	"github.com/spf13/cobra"
+	"go.uber.org/zap"
+
+	"github.com/pingcap/br/pkg/gc"
+	"github.com/pingcap/br/pkg/task"
+	"github.com/pingcap/br/pkg/utils"
+	"github.com/pingcap/br/pkg/version/build"
+)
+
+// NewGCCommand returns a gc subcommand.
+func NewGCCommand() *cobra.Command {
+	command := &cobra.Command{
+		Use:   "gc",
+		Short: "delete the expired backups in a directory by the retention policy",
+		Long: "delete the expired backups in the sub directories of the storage, " +
+			"the base backups of the kept incremental backups are never deleted",
+		Args:         cobra.NoArgs,
+		SilenceUsage: true,
+		PersistentPreRunE: func(c *cobra.Command, args []string) error {
+			if err := Init(c); err != nil {
+				return errors.Trace(err)
+			}
+			build.LogInfo(build.BR)
+			utils.LogEnvVariables()
+			task.LogArguments(c)
+			return nil
+		},
+		RunE: runGCCommand,
+	}
+	task.DefineGCFlags(command)
+	command.Flags().BoolP(flagYes, "y", false, "delete the backups without confirmation")
+	return command
+}
+
+func runGCCommand(cmd *cobra.Command, _ []string) error {
+	var cfg task.GCConfig
+	if err := cfg.ParseFromFlags(cmd.Flags()); err != nil {
+		cmd.SilenceUsage = false
+		return errors.Trace(err)
+	}
+	yes, err := cmd.Flags().GetBool(flagYes)
+	if err != nil {
+		return errors.Trace(err)
+	}
+
+	printDecisions := func(decisions []*gc.Decision) {
+		for _, d := range decisions {
+			if d.Expired {
+				cmd.Printf("EXPIRED: %s\n", d.Backup.Dir)
+			} else {
+				cmd.Printf("KEPT: %s (%s)\n", d.Backup.Dir, d.Reason)
+			}
+		}
+	}
+	confirm := func(decisions []*gc.Decision) bool {
+		if yes {
+			return true
+		}
+		printDecisions(decisions)
+		return askConfirmation(cmd, "delete the expired backups?")
+	}
+	decisions, err := task.RunGC(GetDefaultContext(), &cfg, confirm)
+	if err != nil {
+		log.Error("failed to gc backups", zap.Error(err))
+		return errors.Trace(err)
+	}
+	if cfg.DryRun {
+		printDecisions(decisions)
+	}
+	return nil
+}
@@ -0,0 +1,88 @@
+// Copyright 2021 PingCAP, Inc. Licensed under Apache-2.0.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/pingcap/errors"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+
+	"github.com/pingcap/br/pkg/utils"
+)
+
+const (
+	// FlagHookURL is the name of hook-url flag.
+	FlagHookURL = "hook-url"
+	// FlagHookExec is the name of hook-exec flag.
+	FlagHookExec = "hook-exec"
+	// FlagHookTimeout is the name of hook-timeout flag.
+	FlagHookTimeout = "hook-timeout"
+)
+
+// hookCommands are the commands firing the hooks when they finish or fail.
+var hookCommands = map[string]bool{
+	"backup":  true,
+	"restore": true,
+}
+
+func defineHookFlags(flags *pflag.FlagSet) {
+	flags.StringSlice(FlagHookURL, nil,
+		"Set the webhooks receiving the JSON report of the result by POST requests when a backup or restore finishes or fails, "+
+			"the report is the same as --output json")
+	flags.String(FlagHookExec, "",
+		"Set the command run by the shell when a backup or restore finishes or fails, "+
+			"the JSON report is written to its stdin and the status is passed by the env "+utils.HookStatusEnv)
+	flags.Duration(FlagHookTimeout, utils.DefaultHookTimeout,
+		"Set the timeout of each hook")
+}
+
+func parseHooks(flags *pflag.FlagSet) (*utils.Hooks, error) {
+	hooks := &utils.Hooks{}
+	var err error
+	if hooks.URLs, err = flags.GetStringSlice(FlagHookURL); err != nil {
+		return nil, errors.Trace(err)
+	}
+	if hooks.Exec, err = flags.GetString(FlagHookExec); err != nil {
+		return nil, errors.Trace(err)
+	}
+	if hooks.Timeout, err = flags.GetDuration(FlagHookTimeout); err != nil {
+		return nil, errors.Trace(err)
+	}
+	return hooks, nil
+}
+
+// isHookCommand checks whether the command is a backup or restore task, the
+// auxiliary commands like `br backup status` don't fire the hooks.
+func isHookCommand(cmd *cobra.Command) bool {
+	if !cmd.Runnable() || cmd.Name() == "status" {
+		return false
+	}
+	for c := cmd; c.HasParent(); c = c.Parent() {
+		if !c.Parent().HasParent() {
+			return hookCommands[c.Name()]
+		}
+	}
+	return false
+}
+
+// fireHooks notifies the result of the command by the hooks. The failures of
+// the hooks are logged and don't change the result of the command.
+func fireHooks(cmd *cobra.Command, err error) {
+	if !isHookCommand(cmd) {
+		return
+	}
+	hooks, e := parseHooks(cmd.Flags())
+	if e != nil || !hooks.Enabled() {
+		return
+	}
+	r := newReport(cmd, err)
+	payload, e := json.Marshal(r)
+	if e != nil {
+		return
+	}
+	// the hooks are fired even if the task is canceled by the signals.
+	_ = hooks.Fire(context.Background(), r.Status, payload)
+}
@@ -0,0 +1,66 @@
+// Copyright 2021 PingCAP, Inc. Licensed under Apache-2.0.
+
+package main
+
+import (
+	"github.com/docker/go-units"
+	"github.com/pingcap/errors"
+	"github.com/pingcap/log"
+	"github.com/spf13/cobra"
+	"go.uber.org/zap"
+
+	"github.com/pingcap/br/pkg/task"
+	"github.com/pingcap/br/pkg/utils"
+	"github.com/pingcap/br/pkg/version/build"
+)
+
+// NewKeyCommand returns a key subcommand.
+func NewKeyCommand() *cobra.Command {
+	command := &cobra.Command{
+		Use:          "key",
+		Short:        "manage the keys the backups are encrypted by",
+		SilenceUsage: true,
+		PersistentPreRunE: func(c *cobra.Command, args []string) error {
+			if err := Init(c); err != nil {
+				return errors.Trace(err)
+			}
+			build.LogInfo(build.BR)
+			utils.LogEnvVariables()
+			task.LogArguments(c)
+			return nil
+		},
+	}
+	command.AddCommand(newKeyRotateCommand())
+	return command
+}
+
+func newKeyRotateCommand() *cobra.Command {
+	command := &cobra.Command{
+		Use:   "rotate",
+		Short: "encrypt a backup in S3 by a new KMS key without taking it again",
+		Long: "encrypt the files of the backup in --storage by the KMS key of --new-key-id, the files are copied to " +
+			"themselves inside S3 so the data isn't backed up again, the new key is recorded in the backup and " +
+			"the backup index. an interrupted rotation is continued by running it again",
+		Example: "br key rotate -s s3://bucket/backup/2021-07-01 --new-key-id alias/backup-2021 --chain",
+		Args:    cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			var cfg task.KeyRotateConfig
+			if err := cfg.ParseFromFlags(cmd.Flags()); err != nil {
+				cmd.SilenceUsage = false
+				return errors.Trace(err)
+			}
+			results, err := task.RunKeyRotate(GetDefaultContext(), &cfg)
+			for _, result := range results {
+				cmd.Printf("%s: %d files (%s) encrypted by the key %s of version %d\n", result.URI,
+					result.Rotated, units.HumanSize(float64(result.RotatedSize)), result.Key.KeyID, result.Key.Version)
+			}
+			if err != nil {
+				log.Error("failed to rotate the key of the backup", zap.Error(err))
+				return errors.Trace(err)
+			}
+			return nil
+		},
+	}
+	task.DefineKeyRotateFlags(command)
+	return command
+}
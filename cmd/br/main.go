@@ -47,13 +47,29 @@ func main() {
 	rootCmd.AddCommand(
 		NewDebugCommand(),
 		NewBackupCommand(),
+		NewCheckCommand(),
 		NewRestoreCommand(),
+		NewCleanupCommand(),
+		NewGCCommand(),
+		NewCopyCommand(),
+		NewOperatorCommand(),
+		NewKeyCommand(),
+		NewReplayCommand(),
+		NewCompletionCommand(),
 	)
+	registerCompletions(rootCmd, nil)
 	// Ouputs cmd.Print to stdout.
 	rootCmd.SetOut(os.Stdout)
 
 	rootCmd.SetArgs(os.Args[1:])
-	if err := rootCmd.Execute(); err != nil {
+	cmd, err := rootCmd.ExecuteC()
+	StopMetricsPusher()
+	writeRunRecord(cmd)
+	if e := writeReport(os.Stdout, cmd, err); e != nil {
+		log.Warn("failed to write the report", zap.Error(e))
+	}
+	fireHooks(cmd, err)
+	if err != nil {
 		cancel()
 		log.Error("br failed", zap.Error(err))
 		os.Exit(1) // nolint:gocritic
@@ -0,0 +1,65 @@
+// Copyright 2021 PingCAP, Inc. Licensed under Apache-2.0.
+
+package main
+
+import (
+	"github.com/docker/go-units"
+	"github.com/pingcap/errors"
+	"github.com/pingcap/log"
+	"github.com/spf13/cobra"
+	"go.uber.org/zap"
+
+	"github.com/pingcap/br/pkg/task"
+	"github.com/pingcap/br/pkg/utils"
+	"github.com/pingcap/br/pkg/version/build"
+)
+
+// NewOperatorCommand returns an operator subcommand.
+func NewOperatorCommand() *cobra.Command {
+	command := &cobra.Command{
+		Use:          "operator",
+		Short:        "operate the running tasks of BR by their status servers",
+		SilenceUsage: true,
+		PersistentPreRunE: func(c *cobra.Command, args []string) error {
+			if err := Init(c); err != nil {
+				return errors.Trace(err)
+			}
+			build.LogInfo(build.BR)
+			utils.LogEnvVariables()
+			task.LogArguments(c)
+			return nil
+		},
+	}
+	command.AddCommand(newSetRateLimitCommand())
+	return command
+}
+
+func newSetRateLimitCommand() *cobra.Command {
+	command := &cobra.Command{
+		Use:   "set-ratelimit",
+		Short: "change the rate limit of each store of a running backup",
+		Long: "change the rate limit of each store of a running backup to --ratelimit without restarting it, " +
+			"0 means unlimited, it takes effect on the ranges backed up after it",
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			var cfg task.SetBackupRateLimitConfig
+			if err := cfg.ParseFromFlags(cmd.Flags(), FlagStatusControlToken); err != nil {
+				cmd.SilenceUsage = false
+				return errors.Trace(err)
+			}
+			result, err := task.SetBackupRateLimit(GetDefaultContext(), &cfg)
+			if err != nil {
+				log.Error("failed to set the rate limit of the backup", zap.Error(err))
+				return errors.Trace(err)
+			}
+			rate := "unlimited"
+			if result.RateLimit > 0 {
+				rate = units.HumanSize(float64(result.RateLimit)) + "/s"
+			}
+			cmd.Printf("the rate limit of each store of backup job %s is %s\n", result.Job, rate)
+			return nil
+		},
+	}
+	task.DefineSetBackupRateLimitFlags(command.Flags())
+	return command
+}
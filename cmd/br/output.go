@@ -0,0 +1,89 @@
+// Copyright 2021 PingCAP, Inc. Licensed under Apache-2.0.
+
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"strings"
+
+	"github.com/pingcap/errors"
+	"github.com/spf13/cobra"
+
+	berrors "github.com/pingcap/br/pkg/errors"
+	"github.com/pingcap/br/pkg/summary"
+)
+
+const (
+	outputText = "text"
+	outputJSON = "json"
+)
+
+// outputReport is the machine-readable result of a command, which is printed
+// as the final line of the stdout with `--output json`.
+type outputReport struct {
+	Status    string             `json:"status"`
+	Command   string             `json:"command"`
+	Error     *outputError       `json:"error,omitempty"`
+	Summary   *summary.Record    `json:"summary,omitempty"`
+	Artifacts []summary.Artifact `json:"artifacts"`
+}
+
+type outputError struct {
+	// Class is the component of the error, e.g. "Common" or "KV".
+	Class   string `json:"class"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+func checkOutputFormat(cmd *cobra.Command) error {
+	output, err := cmd.Flags().GetString(FlagOutput)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if output != outputText && output != outputJSON {
+		return errors.Annotatef(berrors.ErrInvalidArgument,
+			"unknown output format %q, should be one of 'text|json'", output)
+	}
+	// the report can't follow the backup archive streamed to the stdout.
+	if s, e := cmd.Flags().GetString("storage"); e == nil && output == outputJSON && strings.HasPrefix(s, "stdout:") {
+		return errors.Annotatef(berrors.ErrInvalidArgument,
+			"--%s json is not supported with the storage %s", FlagOutput, s)
+	}
+	return nil
+}
+
+// newReport creates the report of the result of the command.
+func newReport(cmd *cobra.Command, err error) *outputReport {
+	r := &outputReport{
+		Status:    "success",
+		Command:   cmd.CommandPath(),
+		Summary:   summary.LastRecord(),
+		Artifacts: summary.Artifacts(),
+	}
+	if err != nil {
+		code := berrors.Code(err)
+		class := ""
+		if parts := strings.Split(code, ":"); len(parts) == 3 {
+			class = parts[1]
+		}
+		r.Status = "failed"
+		r.Error = &outputError{Class: class, Code: code, Message: err.Error()}
+	}
+	return r
+}
+
+// writeReport writes the result of the command as a single line of JSON if
+// the output format is json.
+func writeReport(w io.Writer, cmd *cobra.Command, err error) error {
+	output, e := cmd.Flags().GetString(FlagOutput)
+	if e != nil || output != outputJSON {
+		return nil
+	}
+	data, e := json.Marshal(newReport(cmd, err))
+	if e != nil {
+		return errors.Trace(e)
+	}
+	_, e = w.Write(append(data, '\n'))
+	return errors.Trace(e)
+}
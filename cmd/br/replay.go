@@ -0,0 +1,166 @@
+// Copyright 2021 PingCAP, Inc. Licensed under Apache-2.0.
+
+package main
+
+import (
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"syscall"
+
+	"github.com/pingcap/errors"
+	"github.com/pingcap/log"
+	"github.com/spf13/cobra"
+	"go.uber.org/zap"
+
+	berrors "github.com/pingcap/br/pkg/errors"
+	"github.com/pingcap/br/pkg/summary"
+	"github.com/pingcap/br/pkg/task"
+	"github.com/pingcap/br/pkg/utils"
+	"github.com/pingcap/br/pkg/version/build"
+)
+
+const (
+	flagFromJob = "from-job"
+	flagDryRun  = "dry-run"
+)
+
+var (
+	runConfigMu sync.Mutex
+	// runConfig is the config of the running task, which is recorded with the
+	// flags when the task finishes.
+	runConfig interface{}
+)
+
+// recordRunConfig records the config of the task in the run record, the
+// config is taken when the task finishes, so it's after the auto tuning.
+func recordRunConfig(cfg interface{}) {
+	runConfigMu.Lock()
+	defer runConfigMu.Unlock()
+	runConfig = cfg
+}
+
+// writeRunRecord writes the flags, the environment and the config of the
+// backup or restore task beside the log file, which can be run again by
+// `br replay`.
+func writeRunRecord(cmd *cobra.Command) {
+	if cmd == nil || !isHookCommand(cmd) || !HasLogFile() {
+		return
+	}
+	logFile, err := cmd.Flags().GetString(FlagLogFile)
+	if err != nil || logFile == "" {
+		return
+	}
+	record := task.NewRunRecord(cmd)
+	runConfigMu.Lock()
+	if runConfig != nil {
+		err = record.SetConfig(runConfig)
+	}
+	runConfigMu.Unlock()
+	if err != nil {
+		log.Warn("failed to record the config of the task", zap.Error(err))
+	}
+	path := logFile + task.RunRecordSuffix
+	if err = task.WriteRunRecord(path, record); err != nil {
+		log.Warn("failed to write the run record", zap.String("path", path), zap.Error(err))
+		return
+	}
+	summary.CollectArtifact("run-record", path)
+}
+
+// NewReplayCommand returns a replay subcommand.
+func NewReplayCommand() *cobra.Command {
+	command := &cobra.Command{
+		Use:   "replay --from-job <run record> [-- <flags>]",
+		Short: "run a backup or restore again with the settings recorded by a previous run",
+		Long: "run a backup or restore again with the flags and the environment recorded in the run record, " +
+			"which is written beside the log file as <log-file>" + task.RunRecordSuffix + ". " +
+			"The redacted secrets, e.g. the tokens and the access keys in the storage URL, " +
+			"and the flags to override are passed after --",
+		Args:         cobra.ArbitraryArgs,
+		SilenceUsage: true,
+		PersistentPreRunE: func(c *cobra.Command, args []string) error {
+			if err := Init(c); err != nil {
+				return errors.Trace(err)
+			}
+			build.LogInfo(build.BR)
+			utils.LogEnvVariables()
+			task.LogArguments(c)
+			return nil
+		},
+		RunE: runReplayCommand,
+	}
+	command.Flags().String(flagFromJob, "", "the path of the run record of the previous run")
+	command.Flags().Bool(flagDryRun, false, "print the command line of the replay without running it")
+	return command
+}
+
+func runReplayCommand(cmd *cobra.Command, extra []string) error {
+	path, err := cmd.Flags().GetString(flagFromJob)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if path == "" {
+		cmd.SilenceUsage = false
+		return errors.Annotatef(berrors.ErrInvalidArgument, "--%s is required", flagFromJob)
+	}
+	dryRun, err := cmd.Flags().GetBool(flagDryRun)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	record, err := task.ReadRunRecord(path)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	args, missing, err := record.ReplayArgs(cmd.Root(), extra)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if record.BRVersion != build.ReleaseVersion || record.GitHash != build.GitHash {
+		log.Warn("the run record is written by another version of BR",
+			zap.String("version", record.BRVersion), zap.String("git-hash", record.GitHash))
+	}
+	if len(missing) > 0 {
+		log.Warn("the secrets of the flags are redacted in the run record, pass them after -- if required",
+			zap.Strings("flags", missing))
+		cmd.PrintErrf("the secrets of the flags are redacted, pass them after -- if required: %s\n",
+			strings.Join(missing, ", "))
+	}
+	if dryRun {
+		cmd.Println(cmd.Root().Name() + " " + strings.Join(args, " "))
+		return nil
+	}
+
+	exe, err := os.Executable()
+	if err != nil {
+		return errors.Trace(err)
+	}
+	child := exec.Command(exe, args...)
+	child.Stdin, child.Stdout, child.Stderr = os.Stdin, os.Stdout, os.Stderr
+	child.Env = os.Environ()
+	for name, value := range record.Env {
+		if value != task.RedactedValue {
+			child.Env = append(child.Env, name+"="+value)
+		}
+	}
+	log.Info("replay the run record", zap.String("record", path), zap.String("command", record.Command))
+	if err = child.Start(); err != nil {
+		return errors.Trace(err)
+	}
+	ctx := GetDefaultContext()
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			// shut the replay down gracefully as well.
+			_ = child.Process.Signal(syscall.SIGTERM)
+		case <-done:
+		}
+	}()
+	if err = child.Wait(); err != nil {
+		return errors.Annotatef(err, "the replay of %s failed", record.Command)
+	}
+	return nil
+}
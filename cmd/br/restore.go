@@ -3,6 +3,8 @@
 package main
 
 import (
+	"fmt"
+
 	"github.com/pingcap/errors"
 	"github.com/pingcap/log"
 	"github.com/pingcap/tidb/session"
@@ -24,6 +26,24 @@ func runRestoreCommand(command *cobra.Command, cmdName string) error {
 		command.SilenceUsage = false
 		return errors.Trace(err)
 	}
+	recordRunConfig(&cfg)
+	yes, err := command.Flags().GetBool(flagYes)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if !yes {
+		cfg.Confirm = func(overwrites *task.RestoreOverwrites) bool {
+			for _, table := range overwrites.Tables {
+				command.Printf("OVERWRITE: %s\n", table)
+			}
+			for _, table := range overwrites.SystemTables {
+				command.Printf("REPLACE: %s\n", table)
+			}
+			return askConfirmation(command, fmt.Sprintf(
+				"%d existing tables would be overwritten and %d system tables would be replaced, continue?",
+				len(overwrites.Tables), len(overwrites.SystemTables)))
+		}
+	}
 
 	ctx := GetDefaultContext()
 	if cfg.EnableOpenTracing {
@@ -44,6 +64,7 @@ func runLogRestoreCommand(command *cobra.Command) error {
 		command.SilenceUsage = false
 		return errors.Trace(err)
 	}
+	recordRunConfig(&cfg)
 
 	ctx := GetDefaultContext()
 	if cfg.EnableOpenTracing {
@@ -66,6 +87,7 @@ func runRestoreRawCommand(command *cobra.Command, cmdName string) error {
 		command.SilenceUsage = false
 		return errors.Trace(err)
 	}
+	recordRunConfig(&cfg)
 
 	ctx := GetDefaultContext()
 	if cfg.EnableOpenTracing {
@@ -80,6 +102,42 @@ func runRestoreRawCommand(command *cobra.Command, cmdName string) error {
 	return nil
 }
 
+func runRestoreTxnCommand(command *cobra.Command, cmdName string) error {
+	cfg := task.RestoreTxnConfig{Config: task.Config{LogProgress: HasLogFile()}}
+	if err := cfg.ParseFromFlags(command.Flags()); err != nil {
+		command.SilenceUsage = false
+		return errors.Trace(err)
+	}
+	recordRunConfig(&cfg)
+
+	ctx := GetDefaultContext()
+	if cfg.EnableOpenTracing {
+		var store *appdash.MemoryStore
+		ctx, store = trace.TracerStartSpan(ctx)
+		defer trace.TracerFinishSpan(ctx, store)
+	}
+	if err := task.RunRestoreTxn(ctx, gluetikv.Glue{}, cmdName, &cfg); err != nil {
+		log.Error("failed to restore txn kv", zap.Error(err))
+		return errors.Trace(err)
+	}
+	return nil
+}
+
+func runRecoverJournalCommand(command *cobra.Command, cmdName string) error {
+	cfg := task.RestoreConfig{Config: task.Config{LogProgress: HasLogFile()}}
+	if err := cfg.ParseFromFlags(command.Flags()); err != nil {
+		command.SilenceUsage = false
+		return errors.Trace(err)
+	}
+	recordRunConfig(&cfg)
+
+	if err := task.RunRecoverJournal(GetDefaultContext(), tidbGlue, cmdName, &cfg); err != nil {
+		log.Error("failed to recover restore journal", zap.Error(err))
+		return errors.Trace(err)
+	}
+	return nil
+}
+
 // NewRestoreCommand returns a restore subcommand.
 func NewRestoreCommand() *cobra.Command {
 	command := &cobra.Command{
@@ -105,12 +163,22 @@ func NewRestoreCommand() *cobra.Command {
 		newTableRestoreCommand(),
 		newLogRestoreCommand(),
 		newRawRestoreCommand(),
+		newTxnRestoreCommand(),
+		newRecoverJournalCommand(),
+		newProvenanceCommand(),
 	)
 	task.DefineRestoreFlags(command.PersistentFlags())
 
 	return command
 }
 
+// defineRestoreConfirmFlag defines --yes, which skips the confirmation of
+// overwriting the existing tables.
+func defineRestoreConfirmFlag(command *cobra.Command) {
+	command.Flags().BoolP(flagYes, "y", false,
+		"overwrite the existing tables of the cluster without confirmation")
+}
+
 func newFullRestoreCommand() *cobra.Command {
 	command := &cobra.Command{
 		Use:   "full",
@@ -121,6 +189,7 @@ func newFullRestoreCommand() *cobra.Command {
 		},
 	}
 	task.DefineFilterFlags(command, filterOutSysAndMemTables)
+	defineRestoreConfirmFlag(command)
 	return command
 }
 
@@ -134,6 +203,7 @@ func newDBRestoreCommand() *cobra.Command {
 		},
 	}
 	task.DefineDatabaseFlags(command)
+	defineRestoreConfirmFlag(command)
 	return command
 }
 
@@ -147,6 +217,7 @@ func newTableRestoreCommand() *cobra.Command {
 		},
 	}
 	task.DefineTableFlags(command)
+	defineRestoreConfirmFlag(command)
 	return command
 }
 
@@ -154,7 +225,9 @@ func newLogRestoreCommand() *cobra.Command {
 	command := &cobra.Command{
 		Use:   "cdclog",
 		Short: "(experimental) restore data from cdc log backup",
-		Args:  cobra.NoArgs,
+		Long: "(experimental) restore data from cdc log backup, " +
+			"or the log backup written by TiKV, the format is detected from the storage",
+		Args: cobra.NoArgs,
 		RunE: func(cmd *cobra.Command, _ []string) error {
 			return runLogRestoreCommand(cmd)
 		},
@@ -177,3 +250,61 @@ func newRawRestoreCommand() *cobra.Command {
 	task.DefineRawRestoreFlags(command)
 	return command
 }
+
+func newTxnRestoreCommand() *cobra.Command {
+	command := &cobra.Command{
+		Use:   "txn",
+		Short: "(experimental) restore a txn kv backup to TiKV cluster",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			return runRestoreTxnCommand(cmd, "Txn restore")
+		},
+	}
+
+	task.DefineTxnRestoreFlags(command)
+	return command
+}
+
+func newRecoverJournalCommand() *cobra.Command {
+	command := &cobra.Command{
+		Use:   "recover-journal",
+		Short: "(experimental) roll back the cluster changes left by crashed restore jobs",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			return runRecoverJournalCommand(cmd, "Recover journal")
+		},
+	}
+	return command
+}
+
+func newProvenanceCommand() *cobra.Command {
+	command := &cobra.Command{
+		Use:   "provenance",
+		Short: "(experimental) list the restore jobs recorded in the comments of the tables by --provenance",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			cfg := task.Config{LogProgress: HasLogFile()}
+			if err := cfg.ParseFromFlags(cmd.Flags()); err != nil {
+				cmd.SilenceUsage = false
+				return errors.Trace(err)
+			}
+			tables, err := task.ListRestoreProvenance(GetDefaultContext(), tidbGlue, &cfg)
+			if err != nil {
+				log.Error("failed to list restore provenance", zap.Error(err))
+				return errors.Trace(err)
+			}
+			for _, table := range tables {
+				cmd.Printf("%s.%s\n", utils.EncloseName(table.Schema), utils.EncloseName(table.Table))
+				indent := "  "
+				for p := table.Provenance; p != nil; p = p.Inherited {
+					cmd.Printf("%sjob: %s, backup-ts: %d, cluster-id: %d, br: %s, restored-at: %s\n",
+						indent, p.JobID, p.BackupTS, p.ClusterID, p.Version, p.RestoredAt)
+					indent += "  "
+				}
+			}
+			return nil
+		},
+	}
+	task.DefineFilterFlags(command, filterOutSysAndMemTables)
+	return command
+}
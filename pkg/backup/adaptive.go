@@ -0,0 +1,463 @@
+// Copyright 2021 PingCAP, Inc. Licensed under Apache-2.0.
+
+package backup
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/pingcap/errors"
+	backuppb "github.com/pingcap/kvproto/pkg/backup"
+	"github.com/pingcap/kvproto/pkg/metapb"
+	"github.com/pingcap/log"
+	"github.com/prometheus/common/expfmt"
+	"go.uber.org/zap"
+
+	berrors "github.com/pingcap/br/pkg/errors"
+	"github.com/pingcap/br/pkg/httputil"
+)
+
+// The metrics of TiKV read from its status address.
+const (
+	// tikvCPUSecondsMetric is the counter of the CPU time of the process.
+	tikvCPUSecondsMetric = "process_cpu_seconds_total"
+	// tikvCPUQuotaMetric is the gauge of the CPU cores available to TiKV.
+	tikvCPUQuotaMetric = "tikv_server_cpu_cores_quota"
+	// tikvAppendLogMetric is the histogram of appending the raft logs, which
+	// is bound by the disk, so it tells the IO pressure of the store.
+	tikvAppendLogMetric = "tikv_raftstore_append_log_duration_seconds"
+)
+
+const (
+	// DefaultAdaptiveInterval is the interval of adjusting the concurrency.
+	DefaultAdaptiveInterval = 10 * time.Second
+	// minAdaptiveRateLimit is the lowest rate limit of a store, 1MiB/s.
+	minAdaptiveRateLimit = 1 << 20
+	// relaxRatio is the ratio of the limits below which the load is low
+	// enough to speed up.
+	relaxRatio = 0.8
+	// throughputDropRatio is the ratio of the throughput to the last one,
+	// below which more concurrency doesn't help.
+	throughputDropRatio = 0.9
+)
+
+// StoreLoad is a sample of the load metrics of a TiKV store. The values are
+// cumulative, so the load of a period is the difference of two samples.
+type StoreLoad struct {
+	StoreID uint64
+	At      time.Time
+
+	CPUSeconds       float64
+	CPUCores         float64
+	AppendLogCount   uint64
+	AppendLogSeconds float64
+}
+
+// ParseStoreLoad parses the load metrics from the metrics of a TiKV store in
+// the prometheus text format. The missing metrics are zero.
+func ParseStoreLoad(r io.Reader) (*StoreLoad, error) {
+	var parser expfmt.TextParser
+	families, err := parser.TextToMetricFamilies(r)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	load := &StoreLoad{At: time.Now()}
+	for _, m := range families[tikvCPUSecondsMetric].GetMetric() {
+		load.CPUSeconds += m.GetCounter().GetValue()
+	}
+	for _, m := range families[tikvCPUQuotaMetric].GetMetric() {
+		load.CPUCores += m.GetGauge().GetValue()
+	}
+	for _, m := range families[tikvAppendLogMetric].GetMetric() {
+		load.AppendLogCount += m.GetHistogram().GetSampleCount()
+		load.AppendLogSeconds += m.GetHistogram().GetSampleSum()
+	}
+	return load, nil
+}
+
+// FetchStoreLoad reads the load metrics of the TiKV store from the metrics
+// exposed by its status address.
+func FetchStoreLoad(ctx context.Context, tlsConf *tls.Config, store *metapb.Store) (*StoreLoad, error) {
+	if store.GetStatusAddress() == "" {
+		return nil, errors.Annotatef(berrors.ErrInvalidArgument, "store %d has no status address", store.GetId())
+	}
+	scheme := "http"
+	if tlsConf != nil {
+		scheme = "https"
+	}
+	url := fmt.Sprintf("%s://%s/metrics", scheme, store.GetStatusAddress())
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	resp, err := httputil.NewClient(tlsConf).Do(req)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.Annotatef(berrors.ErrUnknown, "get %s: %s", url, resp.Status)
+	}
+	load, err := ParseStoreLoad(resp.Body)
+	if err != nil {
+		return nil, errors.Annotatef(err, "parse the metrics of store %d", store.GetId())
+	}
+	load.StoreID = store.GetId()
+	return load, nil
+}
+
+// StorePressure is the load of a TiKV store during a period.
+type StorePressure struct {
+	// CPUUsage is the ratio of the CPU time to the CPU cores, 0 if unknown.
+	CPUUsage float64
+	// IOLatency is the average duration of appending the raft logs.
+	IOLatency time.Duration
+}
+
+// pressureBetween returns the load of the store between two samples.
+func pressureBetween(first, last *StoreLoad) StorePressure {
+	var p StorePressure
+	elapsed := last.At.Sub(first.At).Seconds()
+	if elapsed > 0 && last.CPUCores > 0 && last.CPUSeconds >= first.CPUSeconds {
+		p.CPUUsage = (last.CPUSeconds - first.CPUSeconds) / elapsed / last.CPUCores
+	}
+	if last.AppendLogCount > first.AppendLogCount && last.AppendLogSeconds >= first.AppendLogSeconds {
+		p.IOLatency = time.Duration((last.AppendLogSeconds - first.AppendLogSeconds) /
+			float64(last.AppendLogCount-first.AppendLogCount) * float64(time.Second))
+	}
+	return p
+}
+
+// AdaptiveConfig is the configuration of the adaptive concurrency.
+type AdaptiveConfig struct {
+	MinConcurrency uint
+	MaxConcurrency uint
+	// MaxRateLimit is the highest rate limit of a store in bytes per second,
+	// 0 means unlimited.
+	MaxRateLimit uint64
+	// MaxCPUUsage is the CPU usage of TiKV above which the backup slows down.
+	MaxCPUUsage float64
+	// MaxIOLatency is the IO latency of TiKV above which the backup slows
+	// down, 0 means ignoring the IO latency.
+	MaxIOLatency time.Duration
+	Interval     time.Duration
+}
+
+// AdaptiveController adjusts the concurrency of the backup ranges and the rate
+// limit of each store by the load of the TiKV stores and the throughput of the
+// backup, instead of the static `--concurrency` and `--ratelimit`.
+//
+// It is additive-increase/multiplicative-decrease: the rate limit of a store
+// is halved when its CPU usage or IO latency is above the limits, and the
+// concurrency is halved when most stores are overloaded. Otherwise the
+// concurrency grows by one every interval as long as the throughput grows
+// with it, and the rate limits of the stores are relaxed by a quarter.
+type AdaptiveController struct {
+	cfg     AdaptiveConfig
+	tlsConf *tls.Config
+	stores  []*metapb.Store
+	limiter *concurrencyLimiter
+
+	mu             sync.Mutex
+	last           map[uint64]*StoreLoad
+	bytes          map[uint64]uint64
+	rates          map[uint64]uint64
+	lastThroughput float64
+	warnedStore    map[uint64]bool
+}
+
+// NewAdaptiveController returns the controller of the backup of the stores,
+// which starts from the concurrency.
+func NewAdaptiveController(
+	tlsConf *tls.Config,
+	stores []*metapb.Store,
+	concurrency uint,
+	cfg AdaptiveConfig,
+) *AdaptiveController {
+	if cfg.MinConcurrency == 0 {
+		cfg.MinConcurrency = 1
+	}
+	if cfg.MaxConcurrency < cfg.MinConcurrency {
+		cfg.MaxConcurrency = cfg.MinConcurrency
+	}
+	if concurrency < cfg.MinConcurrency {
+		concurrency = cfg.MinConcurrency
+	}
+	if concurrency > cfg.MaxConcurrency {
+		concurrency = cfg.MaxConcurrency
+	}
+	if cfg.Interval == 0 {
+		cfg.Interval = DefaultAdaptiveInterval
+	}
+	return &AdaptiveController{
+		cfg:         cfg,
+		tlsConf:     tlsConf,
+		stores:      stores,
+		limiter:     newConcurrencyLimiter(concurrency),
+		last:        make(map[uint64]*StoreLoad),
+		bytes:       make(map[uint64]uint64),
+		rates:       make(map[uint64]uint64),
+		warnedStore: make(map[uint64]bool),
+	}
+}
+
+// Concurrency returns the current concurrency of the backup ranges.
+func (c *AdaptiveController) Concurrency() uint {
+	return c.limiter.getLimit()
+}
+
+// MaxConcurrency returns the highest concurrency of the backup ranges.
+func (c *AdaptiveController) MaxConcurrency() uint {
+	return c.cfg.MaxConcurrency
+}
+
+// StoreRateLimit returns the rate limit of the store in bytes per second, 0
+// means unlimited.
+func (c *AdaptiveController) StoreRateLimit(storeID uint64) uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.storeRateLimit(storeID)
+}
+
+func (c *AdaptiveController) storeRateLimit(storeID uint64) uint64 {
+	if rate, ok := c.rates[storeID]; ok {
+		return rate
+	}
+	return c.cfg.MaxRateLimit
+}
+
+// requestRateLimit returns the rate limit of a backup request sent to the
+// store. TiKV limits the rate by each request, so the rate limit of the store
+// is shared by the concurrent requests.
+func (c *AdaptiveController) requestRateLimit(storeID uint64) uint64 {
+	rate := c.StoreRateLimit(storeID)
+	if rate == 0 {
+		return 0
+	}
+	rate /= uint64(c.Concurrency())
+	if rate == 0 {
+		rate = 1
+	}
+	return rate
+}
+
+// observe records the files backed up by the store.
+func (c *AdaptiveController) observe(storeID uint64, files []*backuppb.File) {
+	var size uint64
+	for _, f := range files {
+		size += f.GetSize_()
+	}
+	c.mu.Lock()
+	c.bytes[storeID] += size
+	c.mu.Unlock()
+}
+
+// Run samples the stores and adjusts the concurrency every interval until the
+// context is done.
+func (c *AdaptiveController) Run(ctx context.Context) {
+	c.sample(ctx)
+	ticker := time.NewTicker(c.cfg.Interval)
+	defer ticker.Stop()
+	lastAdjust := time.Now()
+	for {
+		select {
+		case <-ctx.Done():
+			log.Info("adaptive concurrency stopped", zap.Uint("concurrency", c.Concurrency()))
+			return
+		case now := <-ticker.C:
+			c.Adjust(c.sample(ctx), now.Sub(lastAdjust))
+			lastAdjust = now
+		}
+	}
+}
+
+// sample returns the load of the stores since the last sample. The stores
+// whose metrics can't be read are skipped with a warning.
+func (c *AdaptiveController) sample(ctx context.Context) map[uint64]StorePressure {
+	pressures := make(map[uint64]StorePressure, len(c.stores))
+	for _, store := range c.stores {
+		storeID := store.GetId()
+		load, err := FetchStoreLoad(ctx, c.tlsConf, store)
+		c.mu.Lock()
+		if err != nil {
+			warned := c.warnedStore[storeID]
+			c.warnedStore[storeID] = true
+			c.mu.Unlock()
+			if !warned {
+				log.Warn("failed to read the load metrics of store, its load is ignored",
+					zap.Uint64("store", storeID), zap.Error(err))
+			}
+			continue
+		}
+		if last, ok := c.last[storeID]; ok {
+			pressures[storeID] = pressureBetween(last, load)
+		}
+		c.last[storeID] = load
+		c.mu.Unlock()
+	}
+	return pressures
+}
+
+func (c *AdaptiveController) overloaded(p StorePressure) bool {
+	return (c.cfg.MaxCPUUsage > 0 && p.CPUUsage > c.cfg.MaxCPUUsage) ||
+		(c.cfg.MaxIOLatency > 0 && p.IOLatency > c.cfg.MaxIOLatency)
+}
+
+func (c *AdaptiveController) relaxed(p StorePressure) bool {
+	return (c.cfg.MaxCPUUsage <= 0 || p.CPUUsage < c.cfg.MaxCPUUsage*relaxRatio) &&
+		(c.cfg.MaxIOLatency <= 0 || float64(p.IOLatency) < float64(c.cfg.MaxIOLatency)*relaxRatio)
+}
+
+// Adjust adjusts the concurrency and the rate limits of the stores by the
+// load of the stores and the bytes backed up during the elapsed period.
+func (c *AdaptiveController) Adjust(pressures map[uint64]StorePressure, elapsed time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if elapsed <= 0 {
+		return
+	}
+	var total uint64
+	for _, bytes := range c.bytes {
+		total += bytes
+	}
+	overloaded := 0
+	allRelaxed := true
+	for storeID, p := range pressures {
+		throughput := uint64(float64(c.bytes[storeID]) / elapsed.Seconds())
+		rate := c.storeRateLimit(storeID)
+		switch {
+		case c.overloaded(p):
+			overloaded++
+			allRelaxed = false
+			// the unlimited or loose rate limit starts from what the store
+			// actually does.
+			if rate == 0 || (throughput > 0 && rate > 2*throughput) {
+				rate = throughput
+			}
+			rate /= 2
+			if rate < minAdaptiveRateLimit {
+				rate = minAdaptiveRateLimit
+			}
+			log.Info("store is overloaded by backup, slow down",
+				zap.Uint64("store", storeID),
+				zap.Float64("cpu-usage", p.CPUUsage),
+				zap.Duration("io-latency", p.IOLatency),
+				zap.Uint64("rate-limit", rate))
+		case c.relaxed(p):
+			if rate == 0 {
+				break
+			}
+			step := rate / 4
+			if step < minAdaptiveRateLimit {
+				step = minAdaptiveRateLimit
+			}
+			rate += step
+			if c.cfg.MaxRateLimit != 0 && rate >= c.cfg.MaxRateLimit {
+				rate = c.cfg.MaxRateLimit
+			} else if c.cfg.MaxRateLimit == 0 && rate > 2*throughput {
+				// the rate limit doesn't bind the store any more.
+				rate = 0
+			}
+		default:
+			allRelaxed = false
+		}
+		c.rates[storeID] = rate
+		label := strconv.FormatUint(storeID, 10)
+		backupStoreRateLimitGauge.WithLabelValues(label).Set(float64(rate))
+	}
+
+	concurrency := c.limiter.getLimit()
+	throughput := float64(total) / elapsed.Seconds()
+	switch {
+	case overloaded > 0 && overloaded*2 > len(pressures):
+		concurrency /= 2
+	case allRelaxed && throughput >= c.lastThroughput*throughputDropRatio:
+		concurrency++
+	}
+	if concurrency < c.cfg.MinConcurrency {
+		concurrency = c.cfg.MinConcurrency
+	}
+	if concurrency > c.cfg.MaxConcurrency {
+		concurrency = c.cfg.MaxConcurrency
+	}
+	if concurrency != c.limiter.getLimit() {
+		log.Info("adjust the backup concurrency",
+			zap.Uint("concurrency", concurrency),
+			zap.Int("overloaded-stores", overloaded),
+			zap.Float64("throughput", throughput))
+	}
+	c.limiter.setLimit(concurrency)
+	backupAdaptiveConcurrencyGauge.Set(float64(concurrency))
+	c.lastThroughput = throughput
+	c.bytes = make(map[uint64]uint64, len(c.bytes))
+}
+
+// concurrencyLimiter limits the concurrent tasks, the limit can be changed
+// while the tasks are running.
+type concurrencyLimiter struct {
+	mu      sync.Mutex
+	limit   uint
+	running uint
+	// wake is closed when a task is released or the limit is changed.
+	wake chan struct{}
+}
+
+func newConcurrencyLimiter(limit uint) *concurrencyLimiter {
+	return &concurrencyLimiter{
+		limit: limit,
+		wake:  make(chan struct{}),
+	}
+}
+
+func (l *concurrencyLimiter) getLimit() uint {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.limit
+}
+
+// setLimit changes the limit, the running tasks beyond the new limit aren't
+// interrupted, but no more task starts until they finish.
+func (l *concurrencyLimiter) setLimit(limit uint) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.limit = limit
+	l.broadcast()
+}
+
+// acquire waits until a task can run.
+func (l *concurrencyLimiter) acquire(ctx context.Context) error {
+	for {
+		l.mu.Lock()
+		if l.running < l.limit {
+			l.running++
+			l.mu.Unlock()
+			return nil
+		}
+		wake := l.wake
+		l.mu.Unlock()
+		select {
+		case <-ctx.Done():
+			return errors.Trace(ctx.Err())
+		case <-wake:
+		}
+	}
+}
+
+// release marks a task acquired finished.
+func (l *concurrencyLimiter) release() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.running--
+	l.broadcast()
+}
+
+func (l *concurrencyLimiter) broadcast() {
+	close(l.wake)
+	l.wake = make(chan struct{})
+}
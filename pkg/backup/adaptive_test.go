@@ -0,0 +1,87 @@
+// Copyright 2021 PingCAP, Inc. Licensed under Apache-2.0.
+
+package backup_test
+
+import (
+	"strings"
+	"time"
+
+	. "github.com/pingcap/check"
+
+	"github.com/pingcap/br/pkg/backup"
+)
+
+var _ = Suite(&testAdaptiveSuite{})
+
+type testAdaptiveSuite struct{}
+
+func (s *testAdaptiveSuite) TestParseStoreLoad(c *C) {
+	metrics := `# TYPE process_cpu_seconds_total counter
+process_cpu_seconds_total 120.5
+# TYPE tikv_server_cpu_cores_quota gauge
+tikv_server_cpu_cores_quota 8
+# TYPE tikv_raftstore_append_log_duration_seconds histogram
+tikv_raftstore_append_log_duration_seconds_bucket{le="+Inf"} 40
+tikv_raftstore_append_log_duration_seconds_sum 2
+tikv_raftstore_append_log_duration_seconds_count 40
+`
+	load, err := backup.ParseStoreLoad(strings.NewReader(metrics))
+	c.Assert(err, IsNil)
+	c.Assert(load.CPUSeconds, Equals, 120.5)
+	c.Assert(load.CPUCores, Equals, 8.0)
+	c.Assert(load.AppendLogCount, Equals, uint64(40))
+	c.Assert(load.AppendLogSeconds, Equals, 2.0)
+}
+
+func (s *testAdaptiveSuite) TestAdjust(c *C) {
+	controller := backup.NewAdaptiveController(nil, nil, 4, backup.AdaptiveConfig{
+		MaxConcurrency: 5,
+		MaxCPUUsage:    0.7,
+		MaxIOLatency:   50 * time.Millisecond,
+	})
+	c.Assert(controller.Concurrency(), Equals, uint(4))
+	relaxed := map[uint64]backup.StorePressure{1: {CPUUsage: 0.3}, 2: {CPUUsage: 0.2}}
+
+	// speeds up until the max concurrency.
+	controller.Adjust(relaxed, 10*time.Second)
+	c.Assert(controller.Concurrency(), Equals, uint(5))
+	controller.Adjust(relaxed, 10*time.Second)
+	c.Assert(controller.Concurrency(), Equals, uint(5))
+	c.Assert(controller.StoreRateLimit(1), Equals, uint64(0))
+
+	// a single overloaded store is limited alone.
+	controller.Adjust(map[uint64]backup.StorePressure{
+		1: {CPUUsage: 0.3, IOLatency: 100 * time.Millisecond},
+		2: {CPUUsage: 0.2},
+	}, 10*time.Second)
+	c.Assert(controller.Concurrency(), Equals, uint(5))
+	c.Assert(controller.StoreRateLimit(1), Equals, uint64(1<<20))
+	c.Assert(controller.StoreRateLimit(2), Equals, uint64(0))
+
+	// most stores are overloaded, slows down.
+	controller.Adjust(map[uint64]backup.StorePressure{1: {CPUUsage: 0.9}, 2: {CPUUsage: 0.8}}, 10*time.Second)
+	c.Assert(controller.Concurrency(), Equals, uint(2))
+	controller.Adjust(map[uint64]backup.StorePressure{1: {CPUUsage: 0.9}, 2: {CPUUsage: 0.8}}, 10*time.Second)
+	c.Assert(controller.Concurrency(), Equals, uint(1))
+
+	// the rate limit is lifted once the store is relaxed and doesn't reach it.
+	controller.Adjust(relaxed, 10*time.Second)
+	c.Assert(controller.Concurrency(), Equals, uint(2))
+	c.Assert(controller.StoreRateLimit(1), Equals, uint64(0))
+}
+
+func (s *testAdaptiveSuite) TestSetMaxRateLimit(c *C) {
+	controller := backup.NewAdaptiveController(nil, nil, 2, backup.AdaptiveConfig{
+		MaxConcurrency: 4,
+		MaxCPUUsage:    0.7,
+	})
+	controller.Adjust(map[uint64]backup.StorePressure{1: {CPUUsage: 0.9}, 2: {CPUUsage: 0.3}}, 10*time.Second)
+	c.Assert(controller.StoreRateLimit(1), Equals, uint64(1<<20))
+	c.Assert(controller.StoreRateLimit(2), Equals, uint64(0))
+
+	// the rate limits above the max are lowered at once.
+	controller.SetMaxRateLimit(512 * 1024)
+	c.Assert(controller.StoreRateLimit(1), Equals, uint64(512*1024))
+	c.Assert(controller.StoreRateLimit(2), Equals, uint64(512*1024))
+	c.Assert(controller.StoreRateLimit(3), Equals, uint64(512*1024))
+}
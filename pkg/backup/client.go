@@ -10,6 +10,7 @@ import (
 	"io"
 	"os"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/pingcap/br/pkg/metautil"
@@ -87,6 +88,19 @@ type Client struct {
 	backend *backuppb.StorageBackend
 
 	gcTTL int64
+
+	features *storeFeatures
+	adaptive *AdaptiveController
+	schedule *RateLimitSchedule
+	// rateLimit is the rate limit of each store set while backing up.
+	rateLimit runtimeRateLimit
+
+	// backedUpSize is the size of the files of the backed up ranges.
+	backedUpSize uint64
+	tableStats   physicalTableStats
+
+	rangeRetry   rangeRetryConfig
+	failedRanges failedRanges
 }
 
 // NewBackupClient returns a new backup client.
@@ -97,9 +111,15 @@ func NewBackupClient(ctx context.Context, mgr ClientMgr) (*Client, error) {
 	return &Client{
 		clusterID: clusterID,
 		mgr:       mgr,
+		features:  newStoreFeatures(),
 	}, nil
 }
 
+// BackedUpSize returns the size of the files of the ranges backed up so far.
+func (bc *Client) BackedUpSize() uint64 {
+	return atomic.LoadUint64(&bc.backedUpSize)
+}
+
 // GetTS returns the latest timestamp.
 func (bc *Client) GetTS(ctx context.Context, duration time.Duration, ts uint64) (uint64, error) {
 	var (
@@ -159,6 +179,25 @@ func (bc *Client) GetGCTTL() int64 {
 	return bc.gcTTL
 }
 
+// SetAdaptiveController adjusts the concurrency of the backup ranges and the
+// rate limits of the stores by the controller, the concurrency passed to
+// BackupRanges is ignored then.
+func (bc *Client) SetAdaptiveController(c *AdaptiveController) {
+	bc.adaptive = c
+}
+
+// SetRateLimitSchedule switches the rate limit of the backup requests by the
+// schedule, the rate limit of the requests is ignored then.
+func (bc *Client) SetRateLimitSchedule(s *RateLimitSchedule) {
+	bc.schedule = s
+}
+
+// DowngradedFeatures returns the features of the backup requests disabled on
+// the stores of old versions, by the store IDs.
+func (bc *Client) DowngradedFeatures() map[uint64][]string {
+	return bc.features.Downgraded()
+}
+
 // GetStorage gets storage for this backup.
 func (bc *Client) GetStorage() storage.ExternalStorage {
 	return bc.storage
@@ -171,26 +210,66 @@ func (bc *Client) SetStorage(ctx context.Context, backend *backuppb.StorageBacke
 	if err != nil {
 		return errors.Trace(err)
 	}
+	if err = CheckNoBackup(ctx, bc.storage); err != nil {
+		return errors.Trace(err)
+	}
+	bc.backend = backend
+	return nil
+}
+
+// ReopenStorage sets the storage of an existing backup, e.g. to fill the
+// ranges failed to be backed up.
+func (bc *Client) ReopenStorage(ctx context.Context, backend *backuppb.StorageBackend, opts *storage.ExternalStorageOptions) error {
+	var err error
+	bc.storage, err = storage.New(ctx, backend, opts)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	bc.backend = backend
+	return nil
+}
+
+// SetMirrorStorage mirrors the files of the backup to another storage, it
+// must be called after SetStorage. The SST files written by TiKV are copied to
+// the mirror by storage.SyncMirror.
+func (bc *Client) SetMirrorStorage(
+	ctx context.Context,
+	backend *backuppb.StorageBackend,
+	opts *storage.ExternalStorageOptions,
+	policy storage.MirrorPolicy,
+) error {
+	mirror, err := storage.New(ctx, backend, opts)
+	if err != nil {
+		return errors.Annotate(err, "create mirror storage failed")
+	}
+	if err = CheckNoBackup(ctx, mirror); err != nil {
+		return errors.Trace(err)
+	}
+	bc.storage = storage.WithMirror(bc.storage, mirror, policy)
+	return nil
+}
+
+// CheckNoBackup checks that there isn't any backup in the storage.
+func CheckNoBackup(ctx context.Context, s storage.ExternalStorage) error {
 	// backupmeta already exists
-	exist, err := bc.storage.FileExists(ctx, metautil.MetaFile)
+	exist, err := s.FileExists(ctx, metautil.MetaFile)
 	if err != nil {
 		return errors.Annotatef(err, "error occurred when checking %s file", metautil.MetaFile)
 	}
 	if exist {
 		return errors.Annotatef(berrors.ErrInvalidArgument, "backup meta file exists in %v, "+
 			"there may be some backup files in the path already, "+
-			"please specify a correct backup directory!", bc.storage.URI()+"/"+metautil.MetaFile)
+			"please specify a correct backup directory!", s.URI()+"/"+metautil.MetaFile)
 	}
-	exist, err = bc.storage.FileExists(ctx, metautil.LockFile)
+	exist, err = s.FileExists(ctx, metautil.LockFile)
 	if err != nil {
 		return errors.Annotatef(err, "error occurred when checking %s file", metautil.LockFile)
 	}
 	if exist {
 		return errors.Annotatef(berrors.ErrInvalidArgument, "backup lock file exists in %v, "+
 			"there may be some backup files in the path already, "+
-			"please specify a correct backup directory!", bc.storage.URI()+"/"+metautil.LockFile)
+			"please specify a correct backup directory!", s.URI()+"/"+metautil.LockFile)
 	}
-	bc.backend = backend
 	return nil
 }
 
@@ -335,6 +414,12 @@ func BuildBackupRangeAndSchema(
 			tableInfo.Indices = tableInfo.Indices[:n]
 
 			backupSchemas.addSchema(dbInfo, tableInfo)
+			// the ranges are backed up by the TiKV stores only, the TiFlash
+			// replicas are rebuilt from TiKV after restore.
+			if replicas := tiFlashReplicas(tableInfo); replicas > 0 {
+				logger.Info("the TiFlash replicas of table are excluded from backup",
+					zap.Uint64("replicas", replicas))
+			}
 
 			tableRanges, err := BuildTableRanges(tableInfo)
 			if err != nil {
@@ -420,6 +505,11 @@ func (bc *Client) BackupRanges(
 		ctx = opentracing.ContextWithSpan(ctx, span1)
 	}
 
+	if bc.adaptive != nil {
+		// the controller limits the ranges running actually.
+		concurrency = bc.adaptive.MaxConcurrency()
+	}
+	bc.rateLimit.setConcurrency(concurrency)
 	// we collect all files in a single goroutine to avoid thread safety issues.
 	workerPool := utils.NewWorkerPool(concurrency, "Ranges")
 	eg, ectx := errgroup.WithContext(ctx)
@@ -427,8 +517,14 @@ func (bc *Client) BackupRanges(
 		id := id
 		sk, ek := r.StartKey, r.EndKey
 		workerPool.ApplyOnErrorGroup(eg, func() error {
+			if bc.adaptive != nil {
+				if err := bc.adaptive.limiter.acquire(ectx); err != nil {
+					return errors.Trace(err)
+				}
+				defer bc.adaptive.limiter.release()
+			}
 			elctx := logutil.ContextWithField(ectx, logutil.RedactAny("range-sn", id))
-			err := bc.BackupRange(elctx, sk, ek, req, metaWriter, progressCallBack)
+			err := bc.backupRangeWithRetry(elctx, sk, ek, req, metaWriter, progressCallBack)
 			if err != nil {
 				return errors.Trace(err)
 			}
@@ -446,15 +542,44 @@ func (bc *Client) BackupRange(
 	req backuppb.BackupRequest,
 	metaWriter *metautil.MetaWriter,
 	progressCallBack func(ProgressUnit),
+) error {
+	err := bc.backupRange(ctx, startKey, endKey, req, metaWriter, progressCallBack)
+	if err != nil {
+		summary.CollectFailureUnit(rangeUnitKey(startKey, endKey), err)
+	}
+	return errors.Trace(err)
+}
+
+func rangeUnitKey(startKey, endKey []byte) string {
+	return "range start:" + hex.EncodeToString(startKey) + " end:" + hex.EncodeToString(endKey)
+}
+
+func (bc *Client) backupRange(
+	ctx context.Context,
+	startKey, endKey []byte,
+	req backuppb.BackupRequest,
+	metaWriter *metautil.MetaWriter,
+	progressCallBack func(ProgressUnit),
+) error {
+	return bc.backupRangeAllowMissing(ctx, startKey, endKey, req, metaWriter, progressCallBack, false)
+}
+
+// backupRangeAllowMissing backs up the range. If allowMissing is true, the
+// regions still failing after the fine grained backup are skipped, the files
+// of the others are sent to the backupmeta, and a *missingRangesError of the
+// skipped sub-ranges is returned.
+func (bc *Client) backupRangeAllowMissing(
+	ctx context.Context,
+	startKey, endKey []byte,
+	req backuppb.BackupRequest,
+	metaWriter *metautil.MetaWriter,
+	progressCallBack func(ProgressUnit),
+	allowMissing bool,
 ) (err error) {
 	start := time.Now()
 	defer func() {
 		elapsed := time.Since(start)
 		logutil.CL(ctx).Info("backup range finished", zap.Duration("take", elapsed))
-		key := "range start:" + hex.EncodeToString(startKey) + " end:" + hex.EncodeToString(endKey)
-		if err != nil {
-			summary.CollectFailureUnit(key, err)
-		}
 	}()
 	logutil.CL(ctx).Info("backup started",
 		logutil.Key("startKey", startKey), logutil.Key("endKey", endKey),
@@ -469,24 +594,45 @@ func (bc *Client) BackupRange(
 
 	req.StartKey = startKey
 	req.EndKey = endKey
-	req.StorageBackend = bc.backend
+	// the caller may redirect the backup files to another location.
+	if req.StorageBackend == nil {
+		req.StorageBackend = bc.backend
+	}
 
-	push := newPushDown(bc.mgr, len(allStores))
+	bc.features.setStores(allStores)
+	push := newPushDown(bc.mgr, bc.features, len(allStores))
+	push.adaptive = bc.adaptive
+	push.schedule = bc.schedule
+	push.rateLimit = &bc.rateLimit
 
 	var results rtree.RangeTree
 	results, err = push.pushBackup(ctx, req, allStores, progressCallBack)
 	if err != nil {
-		return errors.Trace(err)
+		if !allowMissing || isPermanentRangeError(err) {
+			return errors.Trace(err)
+		}
+		// the regions not backed up are retried by the fine grained backup.
+		logutil.CL(ctx).Warn("backup push down failed", zap.Error(err))
 	}
 	logutil.CL(ctx).Info("finish backup push down", zap.Int("small-range-count", results.Len()))
 
 	// Find and backup remaining ranges.
 	// TODO: test fine grained backup.
+	rateLimit := req.RateLimit
+	if rate, ok := bc.rateLimit.requestRateLimit(); ok {
+		rateLimit = rate
+	}
 	err = bc.fineGrainedBackup(
-		ctx, startKey, endKey, req.StartVersion, req.EndVersion, req.CompressionType, req.CompressionLevel,
-		req.RateLimit, req.Concurrency, results, progressCallBack)
+		ctx, startKey, endKey, req.StorageBackend, req.StartVersion, req.EndVersion, req.CompressionType,
+		req.CompressionLevel, rateLimit, req.Concurrency, results, progressCallBack)
+	var missing *missingRangesError
 	if err != nil {
-		return errors.Trace(err)
+		if !allowMissing || isPermanentRangeError(err) {
+			return errors.Trace(err)
+		}
+		missing = &missingRangesError{ranges: results.GetIncompleteRange(startKey, endKey), cause: err}
+		logutil.CL(ctx).Error("regions failed to be backed up, skip them",
+			zap.Int("missing-ranges", len(missing.ranges)), zap.Error(err))
 	}
 
 	// update progress of range unit
@@ -504,11 +650,15 @@ func (bc *Client) BackupRange(
 	}
 
 	var ascendErr error
+	var kvs, size uint64
 	results.Ascend(func(i btree.Item) bool {
 		r := i.(*rtree.Range)
 		for _, f := range r.Files {
 			summary.CollectSuccessUnit(summary.TotalKV, 1, f.TotalKvs)
 			summary.CollectSuccessUnit(summary.TotalBytes, 1, f.TotalBytes)
+			atomic.AddUint64(&bc.backedUpSize, f.Size_)
+			kvs += f.TotalKvs
+			size += f.Size_
 		}
 		// we need keep the files in order after we support multi_ingest sst.
 		// default_sst and write_sst need to be together.
@@ -521,10 +671,14 @@ func (bc *Client) BackupRange(
 	if ascendErr != nil {
 		return errors.Trace(ascendErr)
 	}
+	bc.tableStats.add(startKey, kvs, size, time.Since(start))
 
 	// Check if there are duplicated files.
 	checkDupFiles(&results)
 
+	if missing != nil {
+		return missing
+	}
 	return nil
 }
 
@@ -556,6 +710,7 @@ func (bc *Client) findRegionLeader(ctx context.Context, key []byte) (*metapb.Pee
 func (bc *Client) fineGrainedBackup(
 	ctx context.Context,
 	startKey, endKey []byte,
+	backend *backuppb.StorageBackend,
 	lastBackupTS uint64,
 	backupTS uint64,
 	compressType backuppb.CompressionType,
@@ -611,7 +766,7 @@ func (bc *Client) fineGrainedBackup(
 				defer wg.Done()
 				for rg := range retry {
 					backoffMs, err :=
-						bc.handleFineGrained(ctx, boFork, rg, lastBackupTS, backupTS,
+						bc.handleFineGrained(ctx, boFork, rg, backend, lastBackupTS, backupTS,
 							compressType, compressLevel, rateLimit, concurrency, respCh)
 					if err != nil {
 						errCh <- err
@@ -751,6 +906,7 @@ func (bc *Client) handleFineGrained(
 	ctx context.Context,
 	bo *tikv.Backoffer,
 	rg rtree.Range,
+	backend *backuppb.StorageBackend,
 	lastBackupTS uint64,
 	backupTS uint64,
 	compressType backuppb.CompressionType,
@@ -771,12 +927,13 @@ func (bc *Client) handleFineGrained(
 		EndKey:           rg.EndKey,
 		StartVersion:     lastBackupTS,
 		EndVersion:       backupTS,
-		StorageBackend:   bc.backend,
+		StorageBackend:   backend,
 		RateLimit:        rateLimit,
 		Concurrency:      concurrency,
 		CompressionType:  compressType,
 		CompressionLevel: compressionLevel,
 	}
+	req = bc.features.downgrade(storeID, req)
 	lockResolver := bc.mgr.GetLockResolver()
 	client, err := bc.mgr.GetBackupClient(ctx, storeID)
 	if err != nil {
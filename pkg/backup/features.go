@@ -0,0 +1,134 @@
+// Copyright 2021 PingCAP, Inc. Licensed under Apache-2.0.
+
+package backup
+
+import (
+	"strings"
+	"sync"
+
+	"github.com/coreos/go-semver/semver"
+	"github.com/pingcap/errors"
+	backuppb "github.com/pingcap/kvproto/pkg/backup"
+	"github.com/pingcap/kvproto/pkg/metapb"
+	"github.com/pingcap/log"
+	"go.uber.org/zap"
+)
+
+// FeatureCompression is the compression of the SST files of the backup.
+const FeatureCompression = "compression"
+
+// backupFeature is a parameter of the backup request, which isn't supported
+// by the old versions of TiKV.
+type backupFeature struct {
+	name       string
+	minVersion semver.Version
+	requested  func(req *backuppb.BackupRequest) bool
+	disable    func(req *backuppb.BackupRequest)
+}
+
+var backupFeatures = []backupFeature{
+	{
+		name:       FeatureCompression,
+		minVersion: *semver.New("4.0.10"),
+		requested: func(req *backuppb.BackupRequest) bool {
+			return req.CompressionType != backuppb.CompressionType_UNKNOWN
+		},
+		disable: func(req *backuppb.BackupRequest) {
+			req.CompressionType = backuppb.CompressionType_UNKNOWN
+			req.CompressionLevel = 0
+		},
+	},
+}
+
+// RequestedFeatures returns the features requested by the backup request.
+func RequestedFeatures(req *backuppb.BackupRequest) []string {
+	var features []string
+	for _, feature := range backupFeatures {
+		if feature.requested(req) {
+			features = append(features, feature.name)
+		}
+	}
+	return features
+}
+
+// parseStoreVersion parses the release version of the store, the pre-release
+// and the build metadata are ignored.
+func parseStoreVersion(version string) (*semver.Version, error) {
+	ver, err := semver.NewVersion(strings.TrimPrefix(version, "v"))
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	return &semver.Version{Major: ver.Major, Minor: ver.Minor, Patch: ver.Patch}, nil
+}
+
+// DowngradeBackupRequest disables the features of the request which the
+// store of the version doesn't support, and returns the disabled features.
+// The request is kept as is if the version can't be parsed.
+func DowngradeBackupRequest(req backuppb.BackupRequest, version string) (backuppb.BackupRequest, []string) {
+	ver, err := parseStoreVersion(version)
+	if err != nil {
+		return req, nil
+	}
+	var disabled []string
+	for _, feature := range backupFeatures {
+		if feature.requested(&req) && ver.LessThan(feature.minVersion) {
+			feature.disable(&req)
+			disabled = append(disabled, feature.name)
+		}
+	}
+	return req, disabled
+}
+
+// storeFeatures downgrades the backup requests sent to the stores by their
+// versions, and records the features disabled on each store.
+type storeFeatures struct {
+	mu         sync.Mutex
+	versions   map[uint64]string
+	downgraded map[uint64][]string
+}
+
+func newStoreFeatures() *storeFeatures {
+	return &storeFeatures{
+		versions:   make(map[uint64]string),
+		downgraded: make(map[uint64][]string),
+	}
+}
+
+// setStores updates the versions of the stores.
+func (f *storeFeatures) setStores(stores []*metapb.Store) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, store := range stores {
+		f.versions[store.GetId()] = store.GetVersion()
+	}
+}
+
+// downgrade returns the request sent to the store.
+func (f *storeFeatures) downgrade(storeID uint64, req backuppb.BackupRequest) backuppb.BackupRequest {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	version, ok := f.versions[storeID]
+	if !ok {
+		return req
+	}
+	req, disabled := DowngradeBackupRequest(req, version)
+	if len(disabled) > 0 {
+		if _, ok := f.downgraded[storeID]; !ok {
+			log.Warn("the store doesn't support some features of backup, back up without them",
+				zap.Uint64("store", storeID), zap.String("version", version), zap.Strings("features", disabled))
+		}
+		f.downgraded[storeID] = disabled
+	}
+	return req
+}
+
+// Downgraded returns the features disabled on each store.
+func (f *storeFeatures) Downgraded() map[uint64][]string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	downgraded := make(map[uint64][]string, len(f.downgraded))
+	for storeID, features := range f.downgraded {
+		downgraded[storeID] = features
+	}
+	return downgraded
+}
@@ -0,0 +1,43 @@
+// Copyright 2021 PingCAP, Inc. Licensed under Apache-2.0.
+
+package backup_test
+
+import (
+	. "github.com/pingcap/check"
+	backuppb "github.com/pingcap/kvproto/pkg/backup"
+
+	"github.com/pingcap/br/pkg/backup"
+)
+
+var _ = Suite(&testBackupFeaturesSuite{})
+
+type testBackupFeaturesSuite struct{}
+
+func (s *testBackupFeaturesSuite) TestDowngradeBackupRequest(c *C) {
+	req := backuppb.BackupRequest{
+		StartVersion:     1,
+		EndVersion:       2,
+		CompressionType:  backuppb.CompressionType_ZSTD,
+		CompressionLevel: 3,
+	}
+	c.Assert(backup.RequestedFeatures(&req), DeepEquals, []string{backup.FeatureCompression})
+
+	for _, version := range []string{"v5.0.0", "4.0.10", "v4.0.10-rc-20-gabcdef", "unknown"} {
+		downgraded, disabled := backup.DowngradeBackupRequest(req, version)
+		c.Assert(disabled, IsNil, Commentf("version %s", version))
+		c.Assert(downgraded, DeepEquals, req, Commentf("version %s", version))
+	}
+
+	downgraded, disabled := backup.DowngradeBackupRequest(req, "v4.0.9")
+	c.Assert(disabled, DeepEquals, []string{backup.FeatureCompression})
+	c.Assert(downgraded.CompressionType, Equals, backuppb.CompressionType_UNKNOWN)
+	c.Assert(downgraded.CompressionLevel, Equals, int32(0))
+	c.Assert(downgraded.EndVersion, Equals, uint64(2))
+	// the request of the caller is kept.
+	c.Assert(req.CompressionType, Equals, backuppb.CompressionType_ZSTD)
+
+	req.CompressionType = backuppb.CompressionType_UNKNOWN
+	c.Assert(backup.RequestedFeatures(&req), IsNil)
+	_, disabled = backup.DowngradeBackupRequest(req, "v4.0.9")
+	c.Assert(disabled, IsNil)
+}
@@ -23,9 +23,27 @@ var (
 			Help:      "Backup region latency distributions.",
 			Buckets:   prometheus.ExponentialBuckets(0.05, 2, 16),
 		})
+
+	backupAdaptiveConcurrencyGauge = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: "br",
+			Subsystem: "backup",
+			Name:      "adaptive_concurrency",
+			Help:      "The concurrency of the backup ranges adjusted by the load of TiKV.",
+		})
+
+	backupStoreRateLimitGauge = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: "br",
+			Subsystem: "backup",
+			Name:      "store_rate_limit_bytes",
+			Help:      "The rate limit of the backup of each store adjusted by its load, 0 means unlimited.",
+		}, []string{"store"})
 )
 
 func init() { // nolint:gochecknoinits
 	prometheus.MustRegister(backupRegionCounters)
 	prometheus.MustRegister(backupRegionHistogram)
+	prometheus.MustRegister(backupAdaptiveConcurrencyGauge)
+	prometheus.MustRegister(backupStoreRateLimitGauge)
 }
@@ -0,0 +1,46 @@
+// Copyright 2021 PingCAP, Inc. Licensed under Apache-2.0.
+
+package backup
+
+import (
+	"sort"
+
+	"github.com/pingcap/tidb/tablecodec"
+
+	"github.com/pingcap/br/pkg/metautil"
+)
+
+// MissingTables returns the tables whose data is in the failed ranges, sorted
+// by their names. The ranges of the partitions are counted into their tables.
+func (ss *Schemas) MissingTables(ranges []metautil.FailedRange) []metautil.MissingTable {
+	tables := make(map[int64]*scheamInfo)
+	for _, schema := range ss.schemas {
+		tables[schema.tableInfo.ID] = schema
+		if partitions := schema.tableInfo.GetPartitionInfo(); partitions != nil {
+			for _, def := range partitions.Definitions {
+				tables[def.ID] = schema
+			}
+		}
+	}
+	missing := make(map[*scheamInfo]int)
+	for _, r := range ranges {
+		if schema, ok := tables[tablecodec.DecodeTableID(r.StartKey)]; ok {
+			missing[schema]++
+		}
+	}
+	result := make([]metautil.MissingTable, 0, len(missing))
+	for schema, n := range missing {
+		result = append(result, metautil.MissingTable{
+			DB:     schema.dbInfo.Name.O,
+			Table:  schema.tableInfo.Name.O,
+			Ranges: n,
+		})
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].DB != result[j].DB {
+			return result[i].DB < result[j].DB
+		}
+		return result[i].Table < result[j].Table
+	})
+	return result
+}
@@ -6,6 +6,7 @@ import (
 	"context"
 	"fmt"
 	"sync"
+	"time"
 
 	"github.com/opentracing/opentracing-go"
 	"github.com/pingcap/errors"
@@ -23,9 +24,15 @@ import (
 
 // pushDown wraps a backup task.
 type pushDown struct {
-	mgr    ClientMgr
-	respCh chan responseAndStore
-	errCh  chan error
+	mgr      ClientMgr
+	features *storeFeatures
+	adaptive *AdaptiveController
+	schedule *RateLimitSchedule
+	// rateLimit is the rate limit set while backing up, which overrides the
+	// schedule.
+	rateLimit *runtimeRateLimit
+	respCh    chan responseAndStore
+	errCh     chan error
 }
 
 type responseAndStore struct {
@@ -42,11 +49,12 @@ func (r responseAndStore) GetStore() *metapb.Store {
 }
 
 // newPushDown creates a push down backup.
-func newPushDown(mgr ClientMgr, cap int) *pushDown {
+func newPushDown(mgr ClientMgr, features *storeFeatures, cap int) *pushDown {
 	return &pushDown{
-		mgr:    mgr,
-		respCh: make(chan responseAndStore, cap),
-		errCh:  make(chan error, cap),
+		mgr:      mgr,
+		features: features,
+		respCh:   make(chan responseAndStore, cap),
+		errCh:    make(chan error, cap),
 	}
 }
 
@@ -86,11 +94,24 @@ func (push *pushDown) pushBackup(
 			logutil.CL(lctx).Warn("fail to connect store, skipping", zap.Error(err))
 			return res, nil
 		}
+		storeReq := push.features.downgrade(storeID, req)
+		if push.schedule != nil {
+			storeReq.RateLimit = push.schedule.requestRateLimit(time.Now())
+		}
+		if push.rateLimit != nil {
+			if rate, ok := push.rateLimit.requestRateLimit(); ok {
+				storeReq.RateLimit = rate
+			}
+		}
+		// the adaptive controller is capped by the rate limit set.
+		if push.adaptive != nil {
+			storeReq.RateLimit = push.adaptive.requestRateLimit(storeID)
+		}
 		wg.Add(1)
 		go func() {
 			defer wg.Done()
 			err := SendBackup(
-				lctx, storeID, client, req,
+				lctx, storeID, client, storeReq,
 				func(resp *backuppb.BackupResponse) error {
 					// Forward all responses (including error).
 					push.respCh <- responseAndStore{
@@ -144,6 +165,9 @@ func (push *pushDown) pushBackup(
 				// None error means range has been backuped successfully.
 				res.Put(
 					resp.GetStartKey(), resp.GetEndKey(), resp.GetFiles())
+				if push.adaptive != nil {
+					push.adaptive.observe(store.GetId(), resp.GetFiles())
+				}
 
 				// Update progress
 				progressCallBack(RegionUnit)
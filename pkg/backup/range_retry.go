@@ -0,0 +1,203 @@
+// Copyright 2021 PingCAP, Inc. Licensed under Apache-2.0.
+
+package backup
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/pingcap/errors"
+	backuppb "github.com/pingcap/kvproto/pkg/backup"
+	"go.uber.org/zap"
+
+	berrors "github.com/pingcap/br/pkg/errors"
+	"github.com/pingcap/br/pkg/logutil"
+	"github.com/pingcap/br/pkg/metautil"
+	"github.com/pingcap/br/pkg/rtree"
+	"github.com/pingcap/br/pkg/summary"
+	"github.com/pingcap/br/pkg/utils"
+)
+
+const (
+	// DefaultRangeRetryTimes is the default times of retrying a failed range.
+	DefaultRangeRetryTimes = 3
+
+	rangeRetryWaitInterval     = 2 * time.Second
+	rangeRetryMaxWaitInterval  = 30 * time.Second
+	rangeRetryWaitIntervalRate = 2
+)
+
+// permanentRangeErrors are the errors which fail again if the range is
+// retried.
+var permanentRangeErrors = []*errors.Error{
+	berrors.ErrInvalidArgument,
+	berrors.ErrBackupGCSafepointExceeded,
+	berrors.ErrKVClusterIDMismatch,
+	berrors.ErrStorageInvalidConfig,
+	berrors.ErrStorageInvalidPermission,
+}
+
+type rangeRetryConfig struct {
+	times        int
+	tolerate     bool
+	allowPartial bool
+}
+
+// SetRangeRetry sets the times of retrying a range failed, e.g. by the stores
+// failing their requests, instead of aborting the backup. If tolerate is
+// true, the ranges still failing are recorded by FailedRanges and the other
+// ranges continue to be backed up.
+func (bc *Client) SetRangeRetry(times int, tolerate bool) {
+	bc.rangeRetry = rangeRetryConfig{times: times, tolerate: tolerate}
+}
+
+// SetAllowPartial sets whether the last attempt of a range skips only the
+// regions still failing, e.g. by a corrupted peer, instead of the whole range.
+// The skipped sub-ranges are recorded by FailedRanges, it takes effect with
+// SetRangeRetry tolerating the failed ranges.
+func (bc *Client) SetAllowPartial(allow bool) {
+	bc.rangeRetry.allowPartial = allow
+}
+
+// FailedRanges returns the ranges failed after all retries, which are
+// tolerated by SetRangeRetry.
+func (bc *Client) FailedRanges() []metautil.FailedRange {
+	bc.failedRanges.mu.Lock()
+	defer bc.failedRanges.mu.Unlock()
+	return append([]metautil.FailedRange(nil), bc.failedRanges.ranges...)
+}
+
+// missingRangesError is returned by the range backed up except the regions
+// failed to be backed up.
+type missingRangesError struct {
+	ranges []rtree.Range
+	cause  error
+}
+
+func (e *missingRangesError) Error() string {
+	return fmt.Sprintf("%d sub-ranges failed to be backed up: %v", len(e.ranges), e.cause)
+}
+
+type failedRanges struct {
+	mu     sync.Mutex
+	ranges []metautil.FailedRange
+}
+
+func (f *failedRanges) add(r metautil.FailedRange) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.ranges = append(f.ranges, r)
+}
+
+// rangeBackoffer retries the failed ranges with an exponential backoff, the
+// cancellation and the permanent errors aren't retried.
+type rangeBackoffer struct {
+	attempt   int
+	delayTime time.Duration
+}
+
+func newRangeBackoffer(retryTimes int) *rangeBackoffer {
+	return &rangeBackoffer{attempt: retryTimes + 1, delayTime: rangeRetryWaitInterval}
+}
+
+func isPermanentRangeError(err error) bool {
+	if errors.Cause(err) == context.Canceled {
+		return true
+	}
+	for _, e := range permanentRangeErrors {
+		if berrors.Is(err, e) {
+			return true
+		}
+	}
+	return false
+}
+
+func (bo *rangeBackoffer) NextBackoff(err error) time.Duration {
+	if isPermanentRangeError(err) {
+		bo.attempt = 0
+		return 0
+	}
+	bo.attempt--
+	delay := bo.delayTime
+	bo.delayTime *= rangeRetryWaitIntervalRate
+	if bo.delayTime > rangeRetryMaxWaitInterval {
+		bo.delayTime = rangeRetryMaxWaitInterval
+	}
+	return delay
+}
+
+func (bo *rangeBackoffer) Attempt() int {
+	return bo.attempt
+}
+
+// backupRangeWithRetry backs up the range, and retries it with backoff if it
+// fails. The range is backed up from scratch by every attempt, the files of the
+// failed attempts are never sent to the backupmeta.
+func (bc *Client) backupRangeWithRetry(
+	ctx context.Context,
+	startKey, endKey []byte,
+	req backuppb.BackupRequest,
+	metaWriter *metautil.MetaWriter,
+	progressCallBack func(ProgressUnit),
+) error {
+	attempts := 0
+	var lastErr error
+	err := utils.WithRetry(ctx, func() error {
+		attempts++
+		// the regions backed up by the last attempt are kept.
+		allowMissing := bc.rangeRetry.tolerate && bc.rangeRetry.allowPartial && attempts > bc.rangeRetry.times
+		lastErr = bc.backupRangeAllowMissing(ctx, startKey, endKey, req, metaWriter, progressCallBack, allowMissing)
+		if lastErr != nil && !isPermanentRangeError(lastErr) && attempts <= bc.rangeRetry.times {
+			logutil.CL(ctx).Warn("backup range failed, retry it",
+				logutil.Key("startKey", startKey), logutil.Key("endKey", endKey),
+				zap.Int("attempt", attempts), zap.Error(lastErr))
+		}
+		return lastErr
+	}, newRangeBackoffer(bc.rangeRetry.times))
+	if err == nil {
+		return nil
+	}
+	if lastErr == nil {
+		// canceled before any attempt.
+		return errors.Trace(ctx.Err())
+	}
+	if missing, ok := lastErr.(*missingRangesError); ok {
+		logutil.CL(ctx).Error("backup range partially failed after all retries, record the regions as gaps",
+			logutil.Key("startKey", startKey), logutil.Key("endKey", endKey),
+			zap.Int("attempts", attempts), zap.Int("missing-ranges", len(missing.ranges)), zap.Error(missing.cause))
+		for _, r := range missing.ranges {
+			bc.failedRanges.add(metautil.FailedRange{
+				StartKey: r.StartKey,
+				EndKey:   r.EndKey,
+				Attempts: attempts,
+				Error:    missing.cause.Error(),
+			})
+		}
+		return nil
+	}
+	if !bc.rangeRetry.tolerate || ctx.Err() != nil || isPermanentRangeError(lastErr) {
+		summary.CollectFailureUnit(rangeUnitKey(startKey, endKey), lastErr)
+		if attempts > 1 {
+			return errors.Annotatef(lastErr, "backup range failed after %d attempts", attempts)
+		}
+		return errors.Trace(lastErr)
+	}
+	// the partial backup succeeds without the failed ranges.
+	if !bc.rangeRetry.allowPartial {
+		summary.CollectFailureUnit(rangeUnitKey(startKey, endKey), lastErr)
+	}
+	logutil.CL(ctx).Error("backup range failed after all retries, record it as a gap of the backup",
+		logutil.Key("startKey", startKey), logutil.Key("endKey", endKey),
+		zap.Int("attempts", attempts), zap.Error(lastErr))
+	bc.failedRanges.add(metautil.FailedRange{
+		StartKey: startKey,
+		EndKey:   endKey,
+		Attempts: attempts,
+		Error:    lastErr.Error(),
+	})
+	// the range is done, though it's a gap to be filled.
+	progressCallBack(RangeUnit)
+	return nil
+}
@@ -0,0 +1,89 @@
+// Copyright 2021 PingCAP, Inc. Licensed under Apache-2.0.
+
+package backup
+
+import (
+	"sync"
+
+	"github.com/pingcap/log"
+	"go.uber.org/zap"
+)
+
+// runtimeRateLimit is the rate limit of each store set while the backup is
+// running, which overrides `--ratelimit` and the rate limit schedule.
+type runtimeRateLimit struct {
+	mu          sync.Mutex
+	set         bool
+	rate        uint64
+	concurrency uint
+}
+
+func (l *runtimeRateLimit) setConcurrency(concurrency uint) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.concurrency = concurrency
+}
+
+func (l *runtimeRateLimit) get() (uint64, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.rate, l.set
+}
+
+// requestRateLimit returns the rate limit of a backup request, the rate limit
+// of the store is shared by the concurrent requests.
+func (l *runtimeRateLimit) requestRateLimit() (uint64, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if !l.set || l.rate == 0 {
+		return 0, l.set
+	}
+	rate := l.rate
+	if l.concurrency > 1 {
+		rate /= uint64(l.concurrency)
+	}
+	if rate == 0 {
+		rate = 1
+	}
+	return rate, true
+}
+
+// SetStoreRateLimit changes the rate limit of each store in bytes per second
+// while the backup is running, 0 means unlimited. It overrides `--ratelimit`
+// and the rate limit schedule, and caps the rate limits of the adaptive
+// controller.
+//
+// TiKV limits the rate by each backup request, so it takes effect on the
+// requests sent after it's set, i.e. the next ranges and the retries of the
+// fine grained backup.
+func (bc *Client) SetStoreRateLimit(rate uint64) {
+	bc.rateLimit.mu.Lock()
+	bc.rateLimit.set, bc.rateLimit.rate = true, rate
+	bc.rateLimit.mu.Unlock()
+	if bc.adaptive != nil {
+		bc.adaptive.SetMaxRateLimit(rate)
+	}
+	log.Info("set the rate limit of backup", zap.String("rate-limit", rateString(rate)))
+}
+
+// StoreRateLimit returns the rate limit of each store set by
+// SetStoreRateLimit, and whether it's set.
+func (bc *Client) StoreRateLimit() (uint64, bool) {
+	return bc.rateLimit.get()
+}
+
+// SetMaxRateLimit changes the highest rate limit of a store in bytes per
+// second, 0 means unlimited. The rate limits above it are lowered at once.
+func (c *AdaptiveController) SetMaxRateLimit(rate uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.cfg.MaxRateLimit = rate
+	if rate == 0 {
+		return
+	}
+	for storeID, r := range c.rates {
+		if r == 0 || r > rate {
+			c.rates[storeID] = rate
+		}
+	}
+}
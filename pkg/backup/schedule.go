@@ -0,0 +1,173 @@
+// Copyright 2021 PingCAP, Inc. Licensed under Apache-2.0.
+
+package backup
+
+import (
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/docker/go-units"
+	"github.com/pingcap/errors"
+	"github.com/pingcap/log"
+	"go.uber.org/zap"
+
+	berrors "github.com/pingcap/br/pkg/errors"
+)
+
+const day = 24 * time.Hour
+
+// RateLimitWindow is a daily time window with its own rate limit.
+type RateLimitWindow struct {
+	// Start and End are the offsets since the midnight of the local time, the
+	// window crosses the midnight if End isn't after Start.
+	Start time.Duration
+	End   time.Duration
+	// RateLimit is the rate limit of a store in bytes per second, 0 means
+	// unlimited.
+	RateLimit uint64
+}
+
+// contains returns whether the offset since the midnight is in the window.
+func (w RateLimitWindow) contains(offset time.Duration) bool {
+	if w.Start < w.End {
+		return offset >= w.Start && offset < w.End
+	}
+	return offset >= w.Start || offset < w.End
+}
+
+// ParseRateLimitWindows parses the windows in the form of
+// `HH:MM-HH:MM=rate,...`, e.g. `00:00-06:00=0,18:00-20:00=100`, where the
+// rate is in the unit and 0 means unlimited.
+func ParseRateLimitWindows(spec string, unit uint64) ([]RateLimitWindow, error) {
+	var windows []RateLimitWindow
+	for _, item := range strings.Split(spec, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		eq := strings.IndexByte(item, '=')
+		dash := strings.IndexByte(item, '-')
+		if eq < 0 || dash < 0 || dash > eq {
+			return nil, errors.Annotatef(berrors.ErrInvalidArgument,
+				"rate limit window %q should be in the form of HH:MM-HH:MM=rate", item)
+		}
+		start, err := parseTimeOfDay(item[:dash])
+		if err != nil {
+			return nil, errors.Annotatef(err, "invalid rate limit window %q", item)
+		}
+		end, err := parseTimeOfDay(item[dash+1 : eq])
+		if err != nil {
+			return nil, errors.Annotatef(err, "invalid rate limit window %q", item)
+		}
+		if start == end {
+			return nil, errors.Annotatef(berrors.ErrInvalidArgument, "empty rate limit window %q", item)
+		}
+		rate, err := strconv.ParseUint(strings.TrimSpace(item[eq+1:]), 10, 64)
+		if err != nil {
+			return nil, errors.Annotatef(berrors.ErrInvalidArgument, "invalid rate of the rate limit window %q", item)
+		}
+		windows = append(windows, RateLimitWindow{Start: start, End: end, RateLimit: rate * unit})
+	}
+	if len(windows) == 0 {
+		return nil, errors.Annotatef(berrors.ErrInvalidArgument, "no rate limit window in %q", spec)
+	}
+	return windows, nil
+}
+
+func parseTimeOfDay(s string) (time.Duration, error) {
+	s = strings.TrimSpace(s)
+	if s == "24:00" {
+		return 0, nil
+	}
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, errors.Annotatef(berrors.ErrInvalidArgument, "invalid time of day %q, it should be HH:MM", s)
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+// RateLimitSchedule switches the rate limit of the backup by the time windows.
+// The rate limit of the window the current time falls in takes effect, the
+// first one takes effect if the windows overlap, and the default one takes
+// effect out of all windows.
+//
+// TiKV limits the rate by each backup request, so the rate limit takes effect
+// on the ranges sent after switching, and it is shared by the concurrent
+// ranges.
+type RateLimitSchedule struct {
+	windows     []RateLimitWindow
+	defaultRate uint64
+	concurrency uint
+
+	mu   sync.Mutex
+	last *RateLimitWindow
+	used bool
+}
+
+// NewRateLimitSchedule returns the schedule of the windows, the rate limits
+// are shared by the concurrency of the ranges.
+func NewRateLimitSchedule(windows []RateLimitWindow, defaultRate uint64, concurrency uint) *RateLimitSchedule {
+	if concurrency == 0 {
+		concurrency = 1
+	}
+	return &RateLimitSchedule{
+		windows:     windows,
+		defaultRate: defaultRate,
+		concurrency: concurrency,
+	}
+}
+
+// window returns the window of the time, nil if out of all windows.
+func (s *RateLimitSchedule) window(now time.Time) *RateLimitWindow {
+	midnight := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	offset := now.Sub(midnight) % day
+	for i := range s.windows {
+		if s.windows[i].contains(offset) {
+			return &s.windows[i]
+		}
+	}
+	return nil
+}
+
+// StoreRateLimit returns the rate limit of a store at the time in bytes per
+// second, 0 means unlimited.
+func (s *RateLimitSchedule) StoreRateLimit(now time.Time) uint64 {
+	if w := s.window(now); w != nil {
+		return w.RateLimit
+	}
+	return s.defaultRate
+}
+
+// requestRateLimit returns the rate limit of a backup request sent at the
+// time, and logs when the rate limit switches.
+func (s *RateLimitSchedule) requestRateLimit(now time.Time) uint64 {
+	w := s.window(now)
+	rate := s.defaultRate
+	if w != nil {
+		rate = w.RateLimit
+	}
+	s.mu.Lock()
+	if !s.used || s.last != w {
+		s.used, s.last = true, w
+		log.Info("switch the rate limit of backup", zap.Bool("in-window", w != nil),
+			zap.String("rate-limit", rateString(rate)))
+	}
+	s.mu.Unlock()
+	if rate == 0 {
+		return 0
+	}
+	rate /= uint64(s.concurrency)
+	if rate == 0 {
+		rate = 1
+	}
+	return rate
+}
+
+func rateString(rate uint64) string {
+	if rate == 0 {
+		return "unlimited"
+	}
+	return units.HumanSize(float64(rate)) + "/s"
+}
@@ -0,0 +1,44 @@
+// Copyright 2021 PingCAP, Inc. Licensed under Apache-2.0.
+
+package backup_test
+
+import (
+	"time"
+
+	. "github.com/pingcap/check"
+
+	"github.com/pingcap/br/pkg/backup"
+)
+
+var _ = Suite(&testRateLimitScheduleSuite{})
+
+type testRateLimitScheduleSuite struct{}
+
+func (s *testRateLimitScheduleSuite) TestParseRateLimitWindows(c *C) {
+	windows, err := backup.ParseRateLimitWindows("00:00-06:00=0, 22:30-02:00=100", 1<<20)
+	c.Assert(err, IsNil)
+	c.Assert(windows, DeepEquals, []backup.RateLimitWindow{
+		{Start: 0, End: 6 * time.Hour, RateLimit: 0},
+		{Start: 22*time.Hour + 30*time.Minute, End: 2 * time.Hour, RateLimit: 100 << 20},
+	})
+
+	for _, spec := range []string{"", "00:00-06:00", "06:00=1", "25:00-06:00=1", "06:00-06:00=1", "00:00-06:00=-1"} {
+		_, err = backup.ParseRateLimitWindows(spec, 1)
+		c.Assert(err, ErrorMatches, ".*rate limit window.*|.*time of day.*", Commentf("%s", spec))
+	}
+}
+
+func (s *testRateLimitScheduleSuite) TestStoreRateLimit(c *C) {
+	windows, err := backup.ParseRateLimitWindows("00:00-06:00=0,18:00-02:00=10", 1)
+	c.Assert(err, IsNil)
+	schedule := backup.NewRateLimitSchedule(windows, 50, 4)
+	at := func(hour, minute int) time.Time {
+		return time.Date(2021, 7, 1, hour, minute, 0, 0, time.Local)
+	}
+	// the first window takes effect on the overlap.
+	c.Assert(schedule.StoreRateLimit(at(1, 0)), Equals, uint64(0))
+	c.Assert(schedule.StoreRateLimit(at(5, 59)), Equals, uint64(0))
+	c.Assert(schedule.StoreRateLimit(at(6, 0)), Equals, uint64(50))
+	c.Assert(schedule.StoreRateLimit(at(18, 0)), Equals, uint64(10))
+	c.Assert(schedule.StoreRateLimit(at(23, 59)), Equals, uint64(10))
+}
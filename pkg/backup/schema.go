@@ -6,6 +6,7 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"sort"
 	"time"
 
 	"github.com/opentracing/opentracing-go"
@@ -15,7 +16,9 @@ import (
 	"github.com/pingcap/parser/model"
 	"github.com/pingcap/tidb/kv"
 	"github.com/pingcap/tidb/statistics/handle"
+	"github.com/pingcap/tidb/tablecodec"
 	"github.com/pingcap/tipb/go-tipb"
+	"github.com/tikv/pd/server/schedule/placement"
 	"go.uber.org/zap"
 	"golang.org/x/sync/errgroup"
 
@@ -23,6 +26,8 @@ import (
 	"github.com/pingcap/br/pkg/glue"
 	"github.com/pingcap/br/pkg/logutil"
 	"github.com/pingcap/br/pkg/metautil"
+	"github.com/pingcap/br/pkg/pdutil"
+	"github.com/pingcap/br/pkg/rtree"
 	"github.com/pingcap/br/pkg/summary"
 	"github.com/pingcap/br/pkg/utils"
 )
@@ -31,6 +36,10 @@ const (
 	// DefaultSchemaConcurrency is the default number of the concurrent
 	// backup schema tasks.
 	DefaultSchemaConcurrency = 64
+
+	// tiflashRuleGroupID is the group of the placement rules of the TiFlash
+	// replicas.
+	tiflashRuleGroupID = "tiflash"
 )
 
 type scheamInfo struct {
@@ -143,12 +152,13 @@ func (ss *Schemas) BackupSchemas(
 				}
 			}
 			s := &backuppb.Schema{
-				Db:         dbBytes,
-				Table:      tableBytes,
-				Crc64Xor:   schema.crc64xor,
-				TotalKvs:   schema.totalKvs,
-				TotalBytes: schema.totalBytes,
-				Stats:      statsBytes,
+				Db:              dbBytes,
+				Table:           tableBytes,
+				Crc64Xor:        schema.crc64xor,
+				TotalKvs:        schema.totalKvs,
+				TotalBytes:      schema.totalBytes,
+				TiflashReplicas: uint32(tiFlashReplicas(schema.tableInfo)),
+				Stats:           statsBytes,
 			}
 
 			if err := metaWriter.Send(s, op); err != nil {
@@ -166,11 +176,122 @@ func (ss *Schemas) BackupSchemas(
 	return metaWriter.FinishWriteMetas(ctx, op)
 }
 
+// SkipUnchanged removes the schemas of the tables whose schemas aren't changed
+// since the version, and returns the IDs of all tables.
+func (ss *Schemas) SkipUnchanged(since uint64) []int64 {
+	tableIDs := make([]int64, 0, len(ss.schemas))
+	for name, schema := range ss.schemas {
+		tableIDs = append(tableIDs, schema.tableInfo.ID)
+		if schema.tableInfo.UpdateTS <= since {
+			delete(ss.schemas, name)
+		}
+	}
+	sort.Slice(tableIDs, func(i, j int) bool { return tableIDs[i] < tableIDs[j] })
+	return tableIDs
+}
+
 // Len returns the number of schemas.
 func (ss *Schemas) Len() int {
 	return len(ss.schemas)
 }
 
+// TiFlashReplicaTables returns the number of the tables with TiFlash
+// replicas. Their TiFlash replicas aren't backed up, and are rebuilt from TiKV
+// after restore.
+func (ss *Schemas) TiFlashReplicaTables() int {
+	n := 0
+	for _, schema := range ss.schemas {
+		if tiFlashReplicas(schema.tableInfo) > 0 {
+			n++
+		}
+	}
+	return n
+}
+
+// PlacementRules returns the placement rules bound to the tables or their
+// partitions, sorted by the names of the tables. The rules of TiFlash are
+// skipped, since the TiFlash replicas are rebuilt by their own after restore.
+func (ss *Schemas) PlacementRules(rules []placement.Rule) []metautil.TablePlacementRules {
+	ruleTables := make(map[int64]*scheamInfo)
+	for _, schema := range ss.schemas {
+		ruleTables[schema.tableInfo.ID] = schema
+		if partitions := schema.tableInfo.GetPartitionInfo(); partitions != nil {
+			for _, partition := range partitions.Definitions {
+				ruleTables[partition.ID] = schema
+			}
+		}
+	}
+	tableRules := make(map[int64]*metautil.TablePlacementRules)
+	for _, rule := range rules {
+		if rule.GroupID == tiflashRuleGroupID {
+			continue
+		}
+		schema, ok := ruleTables[pdutil.PlacementRuleTableID(&rule)]
+		if !ok {
+			continue
+		}
+		tableRule, ok := tableRules[schema.tableInfo.ID]
+		if !ok {
+			tableRule = &metautil.TablePlacementRules{
+				DB:      schema.dbInfo.Name.O,
+				Table:   schema.tableInfo.Name.O,
+				TableID: schema.tableInfo.ID,
+			}
+			tableRules[schema.tableInfo.ID] = tableRule
+		}
+		tableRule.Rules = append(tableRule.Rules, rule)
+	}
+	result := make([]metautil.TablePlacementRules, 0, len(tableRules))
+	for _, tableRule := range tableRules {
+		result = append(result, *tableRule)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].DB != result[j].DB {
+			return result[i].DB < result[j].DB
+		}
+		return result[i].Table < result[j].Table
+	})
+	return result
+}
+
+// tiFlashReplicas returns the number of the TiFlash replicas of the table.
+func tiFlashReplicas(tableInfo *model.TableInfo) uint64 {
+	if tableInfo.TiFlashReplica == nil {
+		return 0
+	}
+	return tableInfo.TiFlashReplica.Count
+}
+
+// SplitByDB splits the schemas and the ranges of their tables by databases.
+// The ranges which don't belong to any table of the schemas are dropped.
+func (ss *Schemas) SplitByDB(ranges []rtree.Range) (map[string]*Schemas, map[string][]rtree.Range) {
+	schemas := make(map[string]*Schemas)
+	tableDB := make(map[int64]string)
+	for name, schema := range ss.schemas {
+		dbName := schema.dbInfo.Name.O
+		if _, ok := schemas[dbName]; !ok {
+			schemas[dbName] = newBackupSchemas()
+		}
+		schemas[dbName].schemas[name] = schema
+		tableDB[schema.tableInfo.ID] = dbName
+		if partitions := schema.tableInfo.GetPartitionInfo(); partitions != nil {
+			for _, def := range partitions.Definitions {
+				tableDB[def.ID] = dbName
+			}
+		}
+	}
+	dbRanges := make(map[string][]rtree.Range)
+	for _, r := range ranges {
+		dbName, ok := tableDB[tablecodec.DecodeTableID(r.StartKey)]
+		if !ok {
+			log.Warn("skip the range not belonging to any table", logutil.Key("startKey", r.StartKey))
+			continue
+		}
+		dbRanges[dbName] = append(dbRanges[dbName], r)
+	}
+	return schemas, dbRanges
+}
+
 func calculateChecksum(
 	ctx context.Context,
 	table *model.TableInfo,
@@ -15,6 +15,7 @@ import (
 	. "github.com/pingcap/check"
 	filter "github.com/pingcap/tidb-tools/pkg/table-filter"
 	"github.com/pingcap/tidb/sessionctx/variable"
+	"github.com/pingcap/tidb/tablecodec"
 	"github.com/pingcap/tidb/util/testkit"
 	"github.com/pingcap/tidb/util/testleak"
 
@@ -128,6 +129,7 @@ func (s *testBackupSchemaSuite) TestBuildBackupRangeAndSchema(c *C) {
 		s.mock.Storage, testFilter, math.MaxUint64)
 	c.Assert(err, IsNil)
 	c.Assert(backupSchemas.Len(), Equals, 1)
+	c.Assert(backupSchemas.TiFlashReplicaTables(), Equals, 0)
 	updateCh := new(simpleProgress)
 	skipChecksum := false
 	es := s.GetRandomStorage(c)
@@ -144,6 +146,7 @@ func (s *testBackupSchemaSuite) TestBuildBackupRangeAndSchema(c *C) {
 	c.Assert(schemas[0].Crc64Xor, Not(Equals), 0, Commentf("%v", schemas[0]))
 	c.Assert(schemas[0].TotalKvs, Not(Equals), 0, Commentf("%v", schemas[0]))
 	c.Assert(schemas[0].TotalBytes, Not(Equals), 0, Commentf("%v", schemas[0]))
+	c.Assert(schemas[0].TiFlashReplicas, Equals, 0)
 
 	tk.MustExec("drop table if exists t2;")
 	tk.MustExec("create table t2 (a int);")
@@ -277,3 +280,48 @@ func (s *testBackupSchemaSuite) TestBackupSchemasForSystemTable(c *C) {
 		c.Assert(strings.HasPrefix(schema.Info.Name.O, tablePrefix), Equals, true)
 	}
 }
+
+func (s *testBackupSchemaSuite) TestSkipIndexes(c *C) {
+	tk := testkit.NewTestKit(c, s.mock.Storage)
+	tk.MustExec("use test")
+	tk.MustExec("drop table if exists t4;")
+	tk.MustExec("create table t4 (a int, b int, c int, key idx_a(a), unique key uk_b(b, c));")
+
+	f, err := filter.Parse([]string{"test.t4"})
+	c.Assert(err, IsNil)
+	ranges, backupSchemas, err := backup.BuildBackupRangeAndSchema(s.mock.Storage, f, math.MaxUint64)
+	c.Assert(err, IsNil)
+	c.Assert(ranges, HasLen, 3)
+
+	// only the ranges of the row data are kept.
+	ranges, tables := backupSchemas.SkipIndexes(ranges)
+	c.Assert(ranges, HasLen, 1)
+	c.Assert(tablecodec.IsRecordKey(ranges[0].StartKey), IsTrue)
+	c.Assert(tables, DeepEquals, []metautil.TableSkippedIndexes{
+		{DB: "test", Table: "t4", Indexes: []string{"idx_a", "uk_b"}},
+	})
+}
+
+func (s *testBackupSchemaSuite) TestMissingTables(c *C) {
+	tk := testkit.NewTestKit(c, s.mock.Storage)
+	tk.MustExec("use test")
+	tk.MustExec("drop table if exists t5, t6;")
+	tk.MustExec("create table t5 (a int);")
+	tk.MustExec("create table t6 (a int) partition by hash(a) partitions 2;")
+
+	f, err := filter.Parse([]string{"test.t5", "test.t6"})
+	c.Assert(err, IsNil)
+	ranges, backupSchemas, err := backup.BuildBackupRangeAndSchema(s.mock.Storage, f, math.MaxUint64)
+	c.Assert(err, IsNil)
+
+	failed := make([]metautil.FailedRange, 0, len(ranges)+1)
+	for _, r := range ranges {
+		failed = append(failed, metautil.FailedRange{StartKey: r.StartKey, EndKey: r.EndKey})
+	}
+	// the ranges not in the tables are skipped.
+	failed = append(failed, metautil.FailedRange{StartKey: []byte("a"), EndKey: []byte("b")})
+	c.Assert(backupSchemas.MissingTables(failed), DeepEquals, []metautil.MissingTable{
+		{DB: "test", Table: "t5", Ranges: 1},
+		{DB: "test", Table: "t6", Ranges: 2},
+	})
+}
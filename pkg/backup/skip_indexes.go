@@ -0,0 +1,87 @@
+// Copyright 2021 PingCAP, Inc. Licensed under Apache-2.0.
+
+package backup
+
+import (
+	"sort"
+
+	"github.com/pingcap/parser/model"
+	"github.com/pingcap/tidb/tablecodec"
+
+	"github.com/pingcap/br/pkg/metautil"
+	"github.com/pingcap/br/pkg/rtree"
+)
+
+// rebuildableIndexes returns the indexes of the table which can be rebuilt by
+// ADD INDEX. The primary keys aren't, since the one of the clustered table is
+// the row data itself and the others can't be added without alter-primary-key.
+// Neither are the expression indexes, whose hidden columns can't be
+// referenced by ADD INDEX.
+func rebuildableIndexes(tableInfo *model.TableInfo) []*model.IndexInfo {
+	var indexes []*model.IndexInfo
+	for _, index := range tableInfo.Indices {
+		if index.State != model.StatePublic || index.Primary {
+			continue
+		}
+		hidden := false
+		for _, col := range index.Columns {
+			if col.Offset < len(tableInfo.Columns) && tableInfo.Columns[col.Offset].Hidden {
+				hidden = true
+				break
+			}
+		}
+		if !hidden {
+			indexes = append(indexes, index)
+		}
+	}
+	return indexes
+}
+
+// SkipIndexes removes the ranges of the indexes which can be rebuilt by ADD
+// INDEX after restore, and returns the skipped indexes of the tables sorted
+// by their names. The ranges of the other indexes are kept.
+func (ss *Schemas) SkipIndexes(ranges []rtree.Range) ([]rtree.Range, []metautil.TableSkippedIndexes) {
+	prefixes := make(map[string]struct{})
+	tables := make([]metautil.TableSkippedIndexes, 0, len(ss.schemas))
+	for _, schema := range ss.schemas {
+		indexes := rebuildableIndexes(schema.tableInfo)
+		if len(indexes) == 0 {
+			continue
+		}
+		tableIDs := []int64{schema.tableInfo.ID}
+		if partitions := schema.tableInfo.GetPartitionInfo(); partitions != nil {
+			for _, def := range partitions.Definitions {
+				tableIDs = append(tableIDs, def.ID)
+			}
+		}
+		table := metautil.TableSkippedIndexes{
+			DB:    schema.dbInfo.Name.O,
+			Table: schema.tableInfo.Name.O,
+		}
+		for _, index := range indexes {
+			table.Indexes = append(table.Indexes, index.Name.O)
+			for _, id := range tableIDs {
+				prefixes[string(tablecodec.EncodeTableIndexPrefix(id, index.ID))] = struct{}{}
+			}
+		}
+		tables = append(tables, table)
+	}
+	sort.Slice(tables, func(i, j int) bool {
+		if tables[i].DB != tables[j].DB {
+			return tables[i].DB < tables[j].DB
+		}
+		return tables[i].Table < tables[j].Table
+	})
+
+	prefixLen := len(tablecodec.EncodeTableIndexPrefix(0, 0))
+	kept := make([]rtree.Range, 0, len(ranges))
+	for _, r := range ranges {
+		if len(r.StartKey) >= prefixLen {
+			if _, ok := prefixes[string(r.StartKey[:prefixLen])]; ok {
+				continue
+			}
+		}
+		kept = append(kept, r)
+	}
+	return kept, tables
+}
@@ -0,0 +1,87 @@
+// Copyright 2021 PingCAP, Inc. Licensed under Apache-2.0.
+
+package backup
+
+import (
+	"context"
+
+	"github.com/docker/go-units"
+	"github.com/pingcap/errors"
+	"github.com/pingcap/log"
+	pd "github.com/tikv/pd/client"
+	"github.com/tikv/pd/server/statistics"
+	"go.uber.org/zap"
+
+	"github.com/pingcap/br/pkg/logutil"
+	"github.com/pingcap/br/pkg/rtree"
+)
+
+// RegionSource provides the sizes and the boundaries of the regions, it's
+// implemented by conn.Mgr.
+type RegionSource interface {
+	GetRegionStats(ctx context.Context, startKey, endKey []byte) (*statistics.RegionStats, error)
+	GetPDClient() pd.Client
+}
+
+// SplitLargeRanges splits the ranges whose estimated size exceeds the
+// threshold at the region boundaries, so the regions of a huge table are
+// backed up by several requests in parallel instead of a single one pinning
+// the stores of the table while the others idle. The size is the approximate
+// size of a replica from the region stats. It returns the ranges after split
+// and the count of the ranges split.
+func SplitLargeRanges(
+	ctx context.Context, source RegionSource, ranges []rtree.Range, threshold uint64,
+) ([]rtree.Range, int, error) {
+	if threshold == 0 {
+		return ranges, 0, nil
+	}
+	result := make([]rtree.Range, 0, len(ranges))
+	split := 0
+	for _, r := range ranges {
+		stats, err := source.GetRegionStats(ctx, r.StartKey, r.EndKey)
+		if err != nil {
+			return nil, 0, errors.Trace(err)
+		}
+		size := uint64(stats.StorageSize) * units.MiB
+		if size <= threshold {
+			result = append(result, r)
+			continue
+		}
+		boundaries, err := CollectRegionBoundaries(ctx, source.GetPDClient(), []rtree.Range{r})
+		if err != nil {
+			return nil, 0, errors.Trace(err)
+		}
+		pieces := splitRangeAt(r, boundaries, int((size+threshold-1)/threshold))
+		if len(pieces) > 1 {
+			split++
+			log.Info("split the large range by regions",
+				logutil.Key("startKey", r.StartKey), logutil.Key("endKey", r.EndKey),
+				zap.String("size", units.BytesSize(float64(size))),
+				zap.Int("regions", len(boundaries)+1), zap.Int("pieces", len(pieces)))
+		}
+		result = append(result, pieces...)
+	}
+	return result, split, nil
+}
+
+// splitRangeAt splits the range into at most n pieces at the boundaries,
+// each piece has a similar count of regions. The region sizes are assumed to
+// be even, since PD doesn't report the size of every region cheaply.
+func splitRangeAt(r rtree.Range, boundaries [][]byte, n int) []rtree.Range {
+	regions := len(boundaries) + 1
+	if n > regions {
+		n = regions
+	}
+	if n <= 1 {
+		return []rtree.Range{r}
+	}
+	pieces := make([]rtree.Range, 0, n)
+	startKey := r.StartKey
+	for i := 1; i < n; i++ {
+		// the first region of the i-th piece.
+		key := boundaries[i*regions/n-1]
+		pieces = append(pieces, rtree.Range{StartKey: startKey, EndKey: key})
+		startKey = key
+	}
+	return append(pieces, rtree.Range{StartKey: startKey, EndKey: r.EndKey})
+}
@@ -0,0 +1,106 @@
+// Copyright 2021 PingCAP, Inc. Licensed under Apache-2.0.
+
+package backup_test
+
+import (
+	"bytes"
+	"context"
+
+	"github.com/docker/go-units"
+	. "github.com/pingcap/check"
+	"github.com/pingcap/kvproto/pkg/metapb"
+	"github.com/pingcap/tidb/util/codec"
+	pd "github.com/tikv/pd/client"
+	"github.com/tikv/pd/server/statistics"
+
+	"github.com/pingcap/br/pkg/backup"
+	"github.com/pingcap/br/pkg/rtree"
+)
+
+var _ = Suite(&testSplitRangeSuite{})
+
+type testSplitRangeSuite struct{}
+
+// mockRegionSource has the regions starting from the keys, each region is
+// 10MiB.
+type mockRegionSource struct {
+	pd.Client
+	keys [][]byte
+}
+
+func (m *mockRegionSource) GetRegionStats(_ context.Context, startKey, endKey []byte) (*statistics.RegionStats, error) {
+	stats := &statistics.RegionStats{}
+	for _, region := range m.regions() {
+		if bytes.Compare(region.Meta.EndKey, codec.EncodeBytes(nil, startKey)) > 0 &&
+			bytes.Compare(region.Meta.StartKey, codec.EncodeBytes(nil, endKey)) < 0 {
+			stats.Count++
+			stats.StorageSize += 10
+		}
+	}
+	return stats, nil
+}
+
+func (m *mockRegionSource) GetPDClient() pd.Client {
+	return m
+}
+
+func (m *mockRegionSource) regions() []*pd.Region {
+	regions := make([]*pd.Region, 0, len(m.keys))
+	for i, key := range m.keys {
+		region := &metapb.Region{StartKey: codec.EncodeBytes(nil, key)}
+		if i+1 < len(m.keys) {
+			region.EndKey = codec.EncodeBytes(nil, m.keys[i+1])
+		}
+		regions = append(regions, &pd.Region{Meta: region})
+	}
+	return regions
+}
+
+func (m *mockRegionSource) ScanRegions(_ context.Context, key, endKey []byte, limit int) ([]*pd.Region, error) {
+	var regions []*pd.Region
+	for _, region := range m.regions() {
+		if len(regions) == limit {
+			break
+		}
+		if (len(region.Meta.EndKey) == 0 || bytes.Compare(region.Meta.EndKey, key) > 0) &&
+			(len(endKey) == 0 || bytes.Compare(region.Meta.StartKey, endKey) < 0) {
+			regions = append(regions, region)
+		}
+	}
+	return regions, nil
+}
+
+func (s *testSplitRangeSuite) TestSplitLargeRanges(c *C) {
+	ctx := context.Background()
+	source := &mockRegionSource{keys: [][]byte{
+		[]byte(""), []byte("a1"), []byte("a2"), []byte("a3"), []byte("a4"), []byte("a5"), []byte("b"),
+	}}
+	ranges := []rtree.Range{
+		{StartKey: []byte("a"), EndKey: []byte("a9")},
+		{StartKey: []byte("b1"), EndKey: []byte("b2")},
+	}
+
+	// the ranges aren't split without the threshold.
+	result, split, err := backup.SplitLargeRanges(ctx, source, ranges, 0)
+	c.Assert(err, IsNil)
+	c.Assert(split, Equals, 0)
+	c.Assert(result, DeepEquals, ranges)
+
+	// 6 regions of 60MiB are split into 3 pieces of 2 regions.
+	result, split, err = backup.SplitLargeRanges(ctx, source, ranges, 25*units.MiB)
+	c.Assert(err, IsNil)
+	c.Assert(split, Equals, 1)
+	c.Assert(result, DeepEquals, []rtree.Range{
+		{StartKey: []byte("a"), EndKey: []byte("a2")},
+		{StartKey: []byte("a2"), EndKey: []byte("a4")},
+		{StartKey: []byte("a4"), EndKey: []byte("a9")},
+		{StartKey: []byte("b1"), EndKey: []byte("b2")},
+	})
+
+	// a range isn't split into more pieces than its regions.
+	result, split, err = backup.SplitLargeRanges(ctx, source, ranges[:1], units.MiB)
+	c.Assert(err, IsNil)
+	c.Assert(split, Equals, 1)
+	c.Assert(result, HasLen, 6)
+	c.Assert(result[5], DeepEquals, rtree.Range{StartKey: []byte("a5"), EndKey: []byte("a9")})
+}
@@ -0,0 +1,85 @@
+// Copyright 2021 PingCAP, Inc. Licensed under Apache-2.0.
+
+package backup
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/pingcap/tidb/tablecodec"
+
+	"github.com/pingcap/br/pkg/summary"
+)
+
+type rangeStat struct {
+	kvs      uint64
+	size     uint64
+	duration time.Duration
+}
+
+// physicalTableStats sums the stats of the backed up ranges by the physical
+// tables, i.e. the tables and the partitions.
+type physicalTableStats struct {
+	mu    sync.Mutex
+	stats map[int64]*rangeStat
+}
+
+// add adds the stat of the range starting from the key, the ranges not in any
+// table, e.g. the raw kv ranges, are skipped.
+func (s *physicalTableStats) add(startKey []byte, kvs, size uint64, duration time.Duration) {
+	physicalID := tablecodec.DecodeTableID(startKey)
+	if physicalID == 0 {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.stats == nil {
+		s.stats = make(map[int64]*rangeStat)
+	}
+	stat, ok := s.stats[physicalID]
+	if !ok {
+		stat = &rangeStat{}
+		s.stats[physicalID] = stat
+	}
+	stat.kvs += kvs
+	stat.size += size
+	stat.duration += duration
+}
+
+// TableStats returns the size and the duration of backing up the tables of
+// the schemas, sorted by their names. The stats of the partitions are summed
+// into their tables.
+func (bc *Client) TableStats(schemas *Schemas) []summary.TableStat {
+	bc.tableStats.mu.Lock()
+	defer bc.tableStats.mu.Unlock()
+	result := make([]summary.TableStat, 0, schemas.Len())
+	for _, schema := range schemas.schemas {
+		physicalIDs := []int64{schema.tableInfo.ID}
+		if partitions := schema.tableInfo.GetPartitionInfo(); partitions != nil {
+			for _, def := range partitions.Definitions {
+				physicalIDs = append(physicalIDs, def.ID)
+			}
+		}
+		tableStat := summary.TableStat{DB: schema.dbInfo.Name.O, Table: schema.tableInfo.Name.O}
+		found := false
+		for _, id := range physicalIDs {
+			if stat, ok := bc.tableStats.stats[id]; ok {
+				found = true
+				tableStat.KVs += stat.kvs
+				tableStat.Size += stat.size
+				tableStat.Duration += stat.duration
+			}
+		}
+		if found {
+			result = append(result, tableStat)
+		}
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].DB != result[j].DB {
+			return result[i].DB < result[j].DB
+		}
+		return result[i].Table < result[j].Table
+	})
+	return result
+}
@@ -0,0 +1,58 @@
+// Copyright 2021 PingCAP, Inc. Licensed under Apache-2.0.
+
+package backup
+
+import (
+	"bytes"
+	"context"
+
+	"github.com/pingcap/errors"
+	"github.com/pingcap/tidb/util/codec"
+	pd "github.com/tikv/pd/client"
+
+	"github.com/pingcap/br/pkg/rtree"
+)
+
+// regionScanLimit is the page size of scanning regions from PD.
+const regionScanLimit = 128
+
+// CollectRegionBoundaries returns the start keys of the regions lying inside
+// the ranges, so the restore can pre-split the target cluster to a similar
+// topology. The keys are raw keys.
+func CollectRegionBoundaries(ctx context.Context, pdClient pd.Client, ranges []rtree.Range) ([][]byte, error) {
+	boundaries := make([][]byte, 0, len(ranges))
+	for _, r := range ranges {
+		// Keys are saved in encoded format in TiKV.
+		startKey := codec.EncodeBytes([]byte{}, r.StartKey)
+		var endKey []byte
+		if len(r.EndKey) > 0 {
+			endKey = codec.EncodeBytes([]byte{}, r.EndKey)
+		}
+		for {
+			regions, err := pdClient.ScanRegions(ctx, startKey, endKey, regionScanLimit)
+			if err != nil {
+				return nil, errors.Trace(err)
+			}
+			for _, region := range regions {
+				if len(region.Meta.GetStartKey()) == 0 {
+					continue
+				}
+				_, key, err := codec.DecodeBytes(region.Meta.GetStartKey(), nil)
+				if err != nil {
+					return nil, errors.Trace(err)
+				}
+				if bytes.Compare(key, r.StartKey) > 0 && (len(r.EndKey) == 0 || bytes.Compare(key, r.EndKey) < 0) {
+					boundaries = append(boundaries, key)
+				}
+			}
+			if len(regions) < regionScanLimit {
+				break
+			}
+			startKey = regions[len(regions)-1].Meta.GetEndKey()
+			if len(startKey) == 0 || (len(endKey) > 0 && bytes.Compare(startKey, endKey) >= 0) {
+				break
+			}
+		}
+	}
+	return boundaries, nil
+}
@@ -0,0 +1,204 @@
+// Copyright 2021 PingCAP, Inc. Licensed under Apache-2.0.
+
+package backup
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"math"
+	"math/rand"
+	"path"
+	"sort"
+	"sync"
+
+	"github.com/cockroachdb/pebble/sstable"
+	"github.com/cockroachdb/pebble/vfs"
+	"github.com/pingcap/errors"
+	backuppb "github.com/pingcap/kvproto/pkg/backup"
+	"github.com/pingcap/log"
+	"github.com/pingcap/tidb/util/codec"
+	"go.uber.org/zap"
+	"golang.org/x/sync/errgroup"
+
+	berrors "github.com/pingcap/br/pkg/errors"
+	"github.com/pingcap/br/pkg/logutil"
+	"github.com/pingcap/br/pkg/storage"
+	"github.com/pingcap/br/pkg/utils"
+)
+
+// mvccTsLen is the length of the ts suffix of the MVCC keys.
+const mvccTsLen = 8
+
+// VerifyResult is the result of reading back the data files of a backup.
+type VerifyResult struct {
+	// Verified is the number of the files read back.
+	Verified int
+	// Bytes is the size of the files read back.
+	Bytes uint64
+	// Corrupted are the files failed the verification with the reasons.
+	Corrupted map[string]string
+}
+
+// sampleDataFiles returns about ratio of the files, all files if ratio >= 1.
+// At least one file is sampled if ratio > 0.
+func sampleDataFiles(files []*backuppb.File, ratio float64) []*backuppb.File {
+	if ratio >= 1 || len(files) == 0 {
+		return files
+	}
+	n := int(math.Ceil(float64(len(files)) * ratio))
+	sampled := append([]*backuppb.File(nil), files...)
+	rand.Shuffle(len(sampled), func(i, j int) {
+		sampled[i], sampled[j] = sampled[j], sampled[i]
+	})
+	return sampled[:n]
+}
+
+// VerifyDataFiles reads back about ratio of the data files of the backup from
+// the storage, and checks their sha256 digests and sizes, the checksums of
+// the blocks of the SST files, and the keys in them are in the ranges of the
+// files in the backupmeta. The corruptions, e.g. by a bad write of the object
+// store, fail it with ErrBackupFileCorrupted.
+func VerifyDataFiles(
+	ctx context.Context,
+	s storage.ExternalStorage,
+	files []*backuppb.File,
+	isRawKv bool,
+	ratio float64,
+	concurrency uint,
+) (*VerifyResult, error) {
+	result := &VerifyResult{Corrupted: make(map[string]string)}
+	if ratio <= 0 {
+		return result, nil
+	}
+	sampled := sampleDataFiles(files, ratio)
+	log.Info("verify the data files of the backup",
+		zap.Int("files", len(files)), zap.Int("sampled", len(sampled)))
+
+	var mu sync.Mutex
+	pool := utils.NewWorkerPool(concurrency, "verify data files")
+	eg, ectx := errgroup.WithContext(ctx)
+	for _, file := range sampled {
+		file := file
+		pool.ApplyOnErrorGroup(eg, func() error {
+			size, reason, err := verifyDataFile(ectx, s, file, isRawKv)
+			if err != nil {
+				return errors.Trace(err)
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			result.Verified++
+			result.Bytes += size
+			if reason != "" {
+				log.Error("backup data file corrupted", logutil.File(file), zap.String("reason", reason))
+				result.Corrupted[file.Name] = reason
+			}
+			return nil
+		})
+	}
+	if err := eg.Wait(); err != nil {
+		return nil, errors.Trace(err)
+	}
+	if len(result.Corrupted) > 0 {
+		names := make([]string, 0, len(result.Corrupted))
+		for name := range result.Corrupted {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return result, errors.Annotatef(berrors.ErrBackupFileCorrupted,
+			"%d of %d verified files are corrupted, e.g. %s: %s",
+			len(names), result.Verified, names[0], result.Corrupted[names[0]])
+	}
+	log.Info("data files of the backup verified",
+		zap.Int("files", result.Verified), zap.Uint64("bytes", result.Bytes))
+	return result, nil
+}
+
+// verifyDataFile reads back the file, and returns the reason if it's
+// corrupted. The error is the failure of reading it.
+func verifyDataFile(
+	ctx context.Context,
+	s storage.ExternalStorage,
+	file *backuppb.File,
+	isRawKv bool,
+) (uint64, string, error) {
+	data, err := s.ReadFile(ctx, file.Name)
+	if err != nil {
+		return 0, "", errors.Annotatef(err, "failed to read %s", file.Name)
+	}
+	size := uint64(len(data))
+	if file.Size_ > 0 && file.Size_ != size {
+		return size, fmt.Sprintf("size mismatch, expect %d, got %d", file.Size_, size), nil
+	}
+	if len(file.Sha256) > 0 {
+		checksum := sha256.Sum256(data)
+		if !bytes.Equal(file.Sha256, checksum[:]) {
+			return size, fmt.Sprintf("sha256 mismatch, expect %x, got %x", file.Sha256, checksum[:]), nil
+		}
+	}
+	reason, err := verifySSTKeys(data, file, isRawKv)
+	return size, reason, errors.Trace(err)
+}
+
+// verifySSTKeys iterates the SST file, so the checksums of its blocks are
+// checked by pebble, and checks its keys are in the range of the file.
+func verifySSTKeys(data []byte, file *backuppb.File, isRawKv bool) (string, error) {
+	// pebble can only read SST files from a file system.
+	fs := vfs.NewMem()
+	name := path.Base(file.Name)
+	f, err := fs.Create(name)
+	if err != nil {
+		return "", errors.Trace(err)
+	}
+	if _, err = f.Write(data); err != nil {
+		return "", errors.Trace(err)
+	}
+	if err = f.Close(); err != nil {
+		return "", errors.Trace(err)
+	}
+	if f, err = fs.Open(name); err != nil {
+		return "", errors.Trace(err)
+	}
+	reader, err := sstable.NewReader(f, sstable.ReaderOptions{})
+	if err != nil {
+		return fmt.Sprintf("invalid SST file: %v", err), nil
+	}
+	defer reader.Close()
+	iter, err := reader.NewIter(nil, nil)
+	if err != nil {
+		return fmt.Sprintf("invalid SST file: %v", err), nil
+	}
+	defer iter.Close()
+	for key, _ := iter.First(); key != nil; key, _ = iter.Next() {
+		raw, err := decodeSSTKey(key.UserKey, isRawKv)
+		if err != nil {
+			return fmt.Sprintf("invalid key %x: %v", key.UserKey, err), nil
+		}
+		if bytes.Compare(raw, file.StartKey) < 0 ||
+			(len(file.EndKey) > 0 && bytes.Compare(raw, file.EndKey) >= 0) {
+			return fmt.Sprintf("key %x out of the range [%x, %x)", raw, file.StartKey, file.EndKey), nil
+		}
+	}
+	if err = iter.Error(); err != nil {
+		return fmt.Sprintf("invalid SST file: %v", err), nil
+	}
+	return "", nil
+}
+
+// decodeSSTKey returns the raw key of the key in the SST file written by
+// TiKV, which is the data key with the prefix 'z', and is MVCC encoded if
+// it's a transactional key.
+func decodeSSTKey(key []byte, isRawKv bool) ([]byte, error) {
+	if len(key) > 0 && key[0] == 'z' {
+		key = key[1:]
+	}
+	if isRawKv {
+		return key, nil
+	}
+	if len(key) < mvccTsLen {
+		return nil, errors.New("too short for a MVCC key")
+	}
+	_, raw, err := codec.DecodeBytes(key[:len(key)-mvccTsLen], nil)
+	return raw, errors.Trace(err)
+}
@@ -0,0 +1,88 @@
+// Copyright 2021 PingCAP, Inc. Licensed under Apache-2.0.
+
+package backup_test
+
+import (
+	"context"
+	"crypto/sha256"
+	"os"
+	"path/filepath"
+
+	"github.com/cockroachdb/pebble/sstable"
+	. "github.com/pingcap/check"
+	backuppb "github.com/pingcap/kvproto/pkg/backup"
+	"github.com/pingcap/tidb/util/codec"
+
+	"github.com/pingcap/br/pkg/backup"
+	berrors "github.com/pingcap/br/pkg/errors"
+	"github.com/pingcap/br/pkg/storage"
+)
+
+var _ = Suite(&testVerifySuite{})
+
+type testVerifySuite struct{}
+
+func writeVerifySST(c *C, dir, name string, keys []string) *backuppb.File {
+	f, err := os.Create(filepath.Join(dir, name))
+	c.Assert(err, IsNil)
+	w := sstable.NewWriter(f, sstable.WriterOptions{})
+	for _, key := range keys {
+		k := append([]byte{'z'}, codec.EncodeBytes(nil, []byte(key))...)
+		k = codec.EncodeUintDesc(k, 100)
+		c.Assert(w.Set(k, []byte("v")), IsNil)
+	}
+	c.Assert(w.Close(), IsNil)
+	data, err := os.ReadFile(filepath.Join(dir, name))
+	c.Assert(err, IsNil)
+	checksum := sha256.Sum256(data)
+	return &backuppb.File{Name: name, Sha256: checksum[:], Size_: uint64(len(data))}
+}
+
+func (s *testVerifySuite) TestVerifyDataFiles(c *C) {
+	ctx := context.Background()
+	dir := c.MkDir()
+	store, err := storage.NewLocalStorage(dir)
+	c.Assert(err, IsNil)
+
+	good := writeVerifySST(c, dir, "1_write.sst", []string{"a1", "a2"})
+	good.StartKey, good.EndKey = []byte("a"), []byte("b")
+	unbounded := writeVerifySST(c, dir, "2_write.sst", []string{"b1", "z"})
+	unbounded.StartKey = []byte("b")
+	result, err := backup.VerifyDataFiles(ctx, store, []*backuppb.File{good, unbounded}, false, 1, 2)
+	c.Assert(err, IsNil)
+	c.Assert(result.Verified, Equals, 2)
+	c.Assert(result.Bytes, Equals, good.Size_+unbounded.Size_)
+
+	// the keys are out of the range of the file.
+	outOfRange := writeVerifySST(c, dir, "3_write.sst", []string{"c1", "d1"})
+	outOfRange.StartKey, outOfRange.EndKey = []byte("c"), []byte("d")
+	// the SST file is overwritten after its digest is taken.
+	overwritten := writeVerifySST(c, dir, "4_write.sst", []string{"e1"})
+	overwritten.StartKey, overwritten.EndKey = []byte("e"), []byte("f")
+	c.Assert(store.WriteFile(ctx, overwritten.Name, []byte("not a SST file")), IsNil)
+	overwritten.Sha256 = nil
+	overwritten.Size_ = 0
+
+	files := []*backuppb.File{good, outOfRange, overwritten}
+	result, err = backup.VerifyDataFiles(ctx, store, files, false, 1, 2)
+	c.Assert(berrors.Is(err, berrors.ErrBackupFileCorrupted), IsTrue, Commentf("%v", err))
+	c.Assert(result.Verified, Equals, 3)
+	c.Assert(result.Corrupted, HasLen, 2)
+	c.Assert(result.Corrupted[outOfRange.Name], Matches, "key .* out of the range .*")
+	c.Assert(result.Corrupted[overwritten.Name], Matches, "invalid SST file.*")
+
+	// the digest mismatches.
+	truncated := writeVerifySST(c, dir, "5_write.sst", []string{"g1"})
+	truncated.Size_ = 0
+	truncated.Sha256[0]++
+	_, err = backup.VerifyDataFiles(ctx, store, []*backuppb.File{truncated}, false, 1, 1)
+	c.Assert(berrors.Is(err, berrors.ErrBackupFileCorrupted), IsTrue)
+
+	// at least one file is sampled, and none if disabled.
+	result, err = backup.VerifyDataFiles(ctx, store, []*backuppb.File{good, unbounded}, false, 0.01, 1)
+	c.Assert(err, IsNil)
+	c.Assert(result.Verified, Equals, 1)
+	result, err = backup.VerifyDataFiles(ctx, store, files, false, 0, 1)
+	c.Assert(err, IsNil)
+	c.Assert(result.Verified, Equals, 0)
+}
@@ -14,10 +14,12 @@
 package cdclog
 
 import (
+	"strings"
 	"time"
 
 	"github.com/pingcap/errors"
 	"github.com/pingcap/log"
+	"github.com/pingcap/parser/mysql"
 	"github.com/pingcap/tidb/meta/autoid"
 	"github.com/pingcap/tidb/table"
 	"github.com/pingcap/tidb/types"
@@ -31,18 +33,26 @@ import (
 // this is the concurrent unit of log restore.
 type TableBuffer struct {
 	KvPairs []kv.Row
-	count   int
-	size    int64
+	state   FlushState
 
 	KvEncoder kv.Encoder
 	tableInfo table.Table
 	allocator autoid.Allocators
 
-	flushKVSize  int64
-	flushKVPairs int
+	flushPolicy FlushPolicy
 
 	colNames []string
 	colPerm  []int
+
+	// shardHandle rewrites the handles of the rows, nil means keeping them.
+	shardHandle *kv.ShardHandle
+	// pkOffset is the offset of the primary key column if it's the handle.
+	pkOffset int
+
+	// transforms are the transforms of the columns by the lower case names,
+	// and colTransforms are them in the order of colNames.
+	transforms    map[string]Transform
+	colTransforms []Transform
 }
 
 func newKVEncoder(allocators autoid.Allocators, tbl table.Table) (kv.Encoder, error) {
@@ -64,9 +74,8 @@ func newKVEncoder(allocators autoid.Allocators, tbl table.Table) (kv.Encoder, er
 // NewTableBuffer creates TableBuffer.
 func NewTableBuffer(tbl table.Table, allocators autoid.Allocators, flushKVPairs int, flushKVSize int64) *TableBuffer {
 	tb := &TableBuffer{
-		KvPairs:      make([]kv.Row, 0, flushKVPairs),
-		flushKVPairs: flushKVPairs,
-		flushKVSize:  flushKVSize,
+		KvPairs:     make([]kv.Row, 0, flushKVPairs),
+		flushPolicy: DefaultFlushPolicy(flushKVPairs, flushKVSize),
 	}
 	if tbl != nil {
 		tb.ReloadMeta(tbl, allocators)
@@ -74,6 +83,33 @@ func NewTableBuffer(tbl table.Table, allocators autoid.Allocators, flushKVPairs
 	return tb
 }
 
+// SetShardHandle sets the rewriter of the handles of the rows, so the rows of
+// many shard tables restored into the same table don't collide.
+func (t *TableBuffer) SetShardHandle(shardHandle *kv.ShardHandle) {
+	t.shardHandle = shardHandle
+}
+
+// SetColumnTransforms sets the transforms of the columns by their names,
+// which are applied to the decoded rows before encoding.
+func (t *TableBuffer) SetColumnTransforms(transforms map[string]Transform) {
+	t.transforms = make(map[string]Transform, len(transforms))
+	for name, transform := range transforms {
+		t.transforms[strings.ToLower(name)] = transform
+	}
+	t.resetColumnTransforms()
+}
+
+func (t *TableBuffer) resetColumnTransforms() {
+	t.colTransforms = nil
+	if len(t.transforms) == 0 {
+		return
+	}
+	t.colTransforms = make([]Transform, len(t.colNames))
+	for i, name := range t.colNames {
+		t.colTransforms[i] = t.transforms[strings.ToLower(name)]
+	}
+}
+
 // ResetTableInfo set tableInfo to nil for next reload.
 func (t *TableBuffer) ResetTableInfo() {
 	t.tableInfo = nil
@@ -100,9 +136,13 @@ func (t *TableBuffer) ReloadMeta(tbl table.Table, allocator autoid.Allocators) {
 	colNames := make([]string, 0, len(columns))
 	colPerm := make([]int, 0, len(columns)+1)
 
+	pkOffset := -1
 	for i, col := range columns {
 		colNames = append(colNames, col.Name.String())
 		colPerm = append(colPerm, i)
+		if tbl.Meta().PKIsHandle && mysql.HasPriKeyFlag(col.Flag) {
+			pkOffset = i
+		}
 	}
 	if kv.TableHasAutoRowID(tbl.Meta()) {
 		colPerm = append(colPerm, -1)
@@ -113,17 +153,24 @@ func (t *TableBuffer) ReloadMeta(tbl table.Table, allocator autoid.Allocators) {
 	t.tableInfo = tbl
 	t.colNames = colNames
 	t.colPerm = colPerm
+	t.pkOffset = pkOffset
+	t.resetColumnTransforms()
 	// reset kv encoder after meta changed
 	t.KvEncoder = nil
 }
 
 func (t *TableBuffer) translateToDatum(row map[string]Column) ([]types.Datum, error) {
 	cols := make([]types.Datum, 0, len(row))
-	for _, col := range t.colNames {
+	for i, col := range t.colNames {
 		val, err := row[col].ToDatum()
 		if err != nil {
 			return nil, errors.Trace(err)
 		}
+		if t.colTransforms != nil && t.colTransforms[i] != nil && !val.IsNull() {
+			if val, err = t.colTransforms[i](val); err != nil {
+				return nil, errors.Annotatef(err, "transform column %s", col)
+			}
+		}
 		cols = append(cols, val)
 	}
 	return cols, nil
@@ -140,16 +187,46 @@ func (t *TableBuffer) appendRow(
 	if err != nil {
 		return errors.Trace(err)
 	}
-	pair, size, err := encodeFn(cols, item.RowID, t.colPerm)
+	rowID := item.RowID
+	if t.shardHandle != nil {
+		rowID, err = t.rewriteHandle(cols, rowID)
+		if err != nil {
+			return errors.Trace(err)
+		}
+	}
+	pair, size, err := encodeFn(cols, rowID, t.colPerm)
 	if err != nil {
 		return errors.Trace(err)
 	}
 	t.KvPairs = append(t.KvPairs, pair)
-	t.size += int64(size)
-	t.count++
+	t.state.Size += int64(size)
+	t.state.Rows++
 	return nil
 }
 
+// rewriteHandle rewrites the row ID and the primary key if it's the handle.
+func (t *TableBuffer) rewriteHandle(cols []types.Datum, rowID int64) (int64, error) {
+	tblInfo := t.tableInfo.Meta()
+	rowID, err := t.shardHandle.Rewrite(tblInfo, rowID)
+	if err != nil {
+		return 0, errors.Trace(err)
+	}
+	if t.pkOffset < 0 || cols[t.pkOffset].IsNull() {
+		return rowID, nil
+	}
+	pk := &cols[t.pkOffset]
+	handle, err := t.shardHandle.Rewrite(tblInfo, pk.GetInt64())
+	if err != nil {
+		return 0, errors.Trace(err)
+	}
+	if pk.Kind() == types.KindUint64 {
+		pk.SetUint64(uint64(handle))
+	} else {
+		pk.SetInt64(handle)
+	}
+	return rowID, nil
+}
+
 // Append appends the item to this buffer.
 func (t *TableBuffer) Append(item *SortItem) error {
 	var err error
@@ -158,6 +235,11 @@ func (t *TableBuffer) Append(item *SortItem) error {
 		zap.Any("item", item),
 	)
 	row := item.Data.(*MessageRow)
+	if t.state.FirstAppend.IsZero() {
+		t.state.FirstAppend = time.Now()
+		t.state.FirstTS = item.TS
+	}
+	t.state.LastTS = item.TS
 
 	if t.KvEncoder == nil {
 		// lazy create kv encoder
@@ -200,18 +282,21 @@ func (t *TableBuffer) Append(item *SortItem) error {
 
 // ShouldApply tells whether we should flush memory kv buffer to storage.
 func (t *TableBuffer) ShouldApply() bool {
-	// flush when reached flush kv len or flush size
-	return t.size >= t.flushKVSize || t.count >= t.flushKVPairs
+	return t.flushPolicy.ShouldFlush(&t.state, time.Now())
+}
+
+// SetFlushPolicy replaces the policy deciding when to flush the buffer.
+func (t *TableBuffer) SetFlushPolicy(policy FlushPolicy) {
+	t.flushPolicy = policy
 }
 
 // IsEmpty tells buffer is empty.
 func (t *TableBuffer) IsEmpty() bool {
-	return t.size == 0
+	return t.state.Size == 0
 }
 
 // Clear reset the buffer.
 func (t *TableBuffer) Clear() {
 	t.KvPairs = t.KvPairs[:0]
-	t.count = 0
-	t.size = 0
+	t.state = FlushState{}
 }
@@ -0,0 +1,63 @@
+// Copyright 2021 PingCAP, Inc. Licensed under Apache-2.0.
+
+package cdclog
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/pingcap/br/pkg/storage"
+)
+
+// DDLFileCache caches the ddl files read by the pullers of all tables, so each
+// ddl file is fetched once. The files are keyed by their names and the sizes
+// listed by Record, so a ddl file rewritten with another size since it was
+// cached, e.g. by a resumed changefeed, is fetched again. The files not
+// recorded are read through without caching.
+type DDLFileCache struct {
+	storage.ExternalStorage
+	cache storage.ExternalStorage
+
+	mu    sync.Mutex
+	sizes map[string]int64
+}
+
+// NewDDLFileCache returns a DDLFileCache of at most `capacity` bytes reading
+// the ddl files from s.
+func NewDDLFileCache(s storage.ExternalStorage, capacity int64) *DDLFileCache {
+	return &DDLFileCache{
+		ExternalStorage: s,
+		cache:           storage.WithCache(ddlFileKeyStorage{ExternalStorage: s}, capacity),
+		sizes:           make(map[string]int64),
+	}
+}
+
+// Record records the size of the ddl file listed in the storage.
+func (c *DDLFileCache) Record(name string, size int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.sizes[name] = size
+}
+
+// ReadFile reads the ddl file from the cache if it's recorded.
+func (c *DDLFileCache) ReadFile(ctx context.Context, name string) ([]byte, error) {
+	c.mu.Lock()
+	size, ok := c.sizes[name]
+	c.mu.Unlock()
+	if !ok {
+		return c.ExternalStorage.ReadFile(ctx, name)
+	}
+	return c.cache.ReadFile(ctx, name+"@"+strconv.FormatInt(size, 10))
+}
+
+// ddlFileKeyStorage reads the ddl files by their keys in the cache, i.e. the
+// names with the sizes.
+type ddlFileKeyStorage struct {
+	storage.ExternalStorage
+}
+
+func (s ddlFileKeyStorage) ReadFile(ctx context.Context, key string) ([]byte, error) {
+	return s.ExternalStorage.ReadFile(ctx, key[:strings.LastIndexByte(key, '@')])
+}
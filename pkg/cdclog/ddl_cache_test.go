@@ -0,0 +1,59 @@
+// Copyright 2021 PingCAP, Inc. Licensed under Apache-2.0.
+
+package cdclog
+
+import (
+	"context"
+
+	"github.com/pingcap/check"
+
+	"github.com/pingcap/br/pkg/storage"
+)
+
+type ddlCacheSuite struct{}
+
+var _ = check.Suite(&ddlCacheSuite{})
+
+// readCounter counts the files read from the storage.
+type readCounter struct {
+	storage.ExternalStorage
+	reads map[string]int
+}
+
+func (r *readCounter) ReadFile(ctx context.Context, name string) ([]byte, error) {
+	r.reads[name]++
+	return r.ExternalStorage.ReadFile(ctx, name)
+}
+
+func (s *ddlCacheSuite) TestDDLFileCache(c *check.C) {
+	ctx := context.Background()
+	local, err := storage.NewLocalStorage(c.MkDir())
+	c.Assert(err, check.IsNil)
+	c.Assert(local.WriteFile(ctx, "ddl.1", []byte("create table t1")), check.IsNil)
+	counter := &readCounter{ExternalStorage: local, reads: make(map[string]int)}
+
+	cache := NewDDLFileCache(counter, 1<<20)
+	cache.Record("ddl.1", 15)
+	for i := 0; i < 3; i++ {
+		data, err := cache.ReadFile(ctx, "ddl.1")
+		c.Assert(err, check.IsNil)
+		c.Assert(string(data), check.Equals, "create table t1")
+	}
+	c.Assert(counter.reads["ddl.1"], check.Equals, 1)
+
+	// the file rewritten with another size is fetched again.
+	c.Assert(local.WriteFile(ctx, "ddl.1", []byte("create table t10")), check.IsNil)
+	cache.Record("ddl.1", 16)
+	data, err := cache.ReadFile(ctx, "ddl.1")
+	c.Assert(err, check.IsNil)
+	c.Assert(string(data), check.Equals, "create table t10")
+	c.Assert(counter.reads["ddl.1"], check.Equals, 2)
+
+	// the files not recorded aren't cached.
+	c.Assert(local.WriteFile(ctx, "ddl.2", []byte("drop table t1")), check.IsNil)
+	for i := 0; i < 2; i++ {
+		_, err = cache.ReadFile(ctx, "ddl.2")
+		c.Assert(err, check.IsNil)
+	}
+	c.Assert(counter.reads["ddl.2"], check.Equals, 2)
+}
@@ -14,10 +14,12 @@
 package cdclog
 
 import (
+	"bufio"
 	"bytes"
 	"encoding/base64"
 	"encoding/binary"
 	"encoding/json"
+	"io"
 	"strconv"
 
 	"github.com/pingcap/errors"
@@ -225,34 +227,58 @@ func (s *SortItem) LessThan(other *SortItem) bool {
 // JSONEventBatchMixedDecoder decodes the byte of a batch into the original messages.
 type JSONEventBatchMixedDecoder struct {
 	mixedBytes []byte
+	// reader is the rest of the batch if the batch is decoded from a stream
+	// instead of mixedBytes.
+	reader *bufio.Reader
+	err    error
+}
+
+// nextMessage returns the next message prefixed by its length.
+func (b *JSONEventBatchMixedDecoder) nextMessage() ([]byte, error) {
+	if b.reader == nil {
+		msgLen := binary.BigEndian.Uint64(b.mixedBytes[:8])
+		msg := b.mixedBytes[8 : msgLen+8]
+		b.mixedBytes = b.mixedBytes[msgLen+8:]
+		return msg, nil
+	}
+	var header [8]byte
+	if _, err := io.ReadFull(b.reader, header[:]); err != nil {
+		return nil, errors.Trace(err)
+	}
+	msg := make([]byte, binary.BigEndian.Uint64(header[:]))
+	if _, err := io.ReadFull(b.reader, msg); err != nil {
+		return nil, errors.Trace(err)
+	}
+	return msg, nil
 }
 
 func (b *JSONEventBatchMixedDecoder) decodeNextKey() (*messageKey, error) {
-	keyLen := binary.BigEndian.Uint64(b.mixedBytes[:8])
-	key := b.mixedBytes[8 : keyLen+8]
-	// drop value bytes
+	key, err := b.nextMessage()
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
 	msgKey := new(messageKey)
-	err := msgKey.Decode(key)
+	err = msgKey.Decode(key)
 	if err != nil {
 		return nil, errors.Trace(err)
 	}
-	b.mixedBytes = b.mixedBytes[keyLen+8:]
 	return msgKey, nil
 }
 
 // NextEvent return next item depends on type.
 func (b *JSONEventBatchMixedDecoder) NextEvent(itemType ItemType) (*SortItem, error) {
 	if !b.HasNext() {
-		return nil, nil
+		return nil, errors.Trace(b.err)
 	}
 	nextKey, err := b.decodeNextKey()
 	if err != nil {
 		return nil, errors.Trace(err)
 	}
 
-	valueLen := binary.BigEndian.Uint64(b.mixedBytes[:8])
-	value := b.mixedBytes[8 : valueLen+8]
-	b.mixedBytes = b.mixedBytes[valueLen+8:]
+	value, err := b.nextMessage()
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
 
 	var m interface{}
 	if itemType == DDL {
@@ -278,9 +304,27 @@ func (b *JSONEventBatchMixedDecoder) NextEvent(itemType ItemType) (*SortItem, er
 	return item, nil
 }
 
-// HasNext represents whether it has next kv to decode.
+// HasNext represents whether it has next kv to decode. It returns false if
+// the stream of the batch fails, see Err.
 func (b *JSONEventBatchMixedDecoder) HasNext() bool {
-	return len(b.mixedBytes) > 0
+	if b.reader == nil {
+		return len(b.mixedBytes) > 0
+	}
+	if b.err != nil {
+		return false
+	}
+	if _, err := b.reader.Peek(1); err != nil {
+		if err != io.EOF { // nolint:errorlint
+			b.err = errors.Trace(err)
+		}
+		return false
+	}
+	return true
+}
+
+// Err returns the error failing to read the stream of the batch.
+func (b *JSONEventBatchMixedDecoder) Err() error {
+	return b.err
 }
 
 // NewJSONEventBatchDecoder creates a new JSONEventBatchDecoder.
@@ -297,3 +341,21 @@ func NewJSONEventBatchDecoder(data []byte) (*JSONEventBatchMixedDecoder, error)
 		mixedBytes: data,
 	}, nil
 }
+
+// NewJSONEventBatchStreamDecoder creates a JSONEventBatchDecoder decoding the
+// batch from the reader, so the batch needn't be read into memory at once. It
+// returns nil if the batch is empty.
+func NewJSONEventBatchStreamDecoder(r io.Reader) (*JSONEventBatchMixedDecoder, error) {
+	reader := bufio.NewReader(r)
+	var header [8]byte
+	if _, err := io.ReadFull(reader, header[:]); err != nil {
+		if err == io.EOF { // nolint:errorlint
+			return nil, nil
+		}
+		return nil, errors.Trace(err)
+	}
+	if binary.BigEndian.Uint64(header[:]) != BatchVersion1 {
+		return nil, errors.Annotate(berrors.ErrPiTRInvalidCDCLogFormat, "unexpected key format version")
+	}
+	return &JSONEventBatchMixedDecoder{reader: reader}, nil
+}
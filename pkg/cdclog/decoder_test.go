@@ -14,9 +14,11 @@
 package cdclog
 
 import (
+	"bytes"
 	"encoding/binary"
 	"encoding/json"
 	"testing"
+	"testing/iotest"
 
 	"github.com/pingcap/check"
 	"github.com/pingcap/parser/mysql"
@@ -144,6 +146,44 @@ func (s *batchSuite) TestDecoder(c *check.C) {
 	}
 }
 
+func (s *batchSuite) TestStreamDecoder(c *check.C) {
+	data := buildEncodeRowData(s.rowEvents)
+	// the stream is read byte by byte to cut the messages anywhere.
+	decoder, err := NewJSONEventBatchStreamDecoder(iotest.OneByteReader(bytes.NewReader(data)))
+	c.Assert(err, check.IsNil)
+	index := 0
+	for decoder.HasNext() {
+		item, err := decoder.NextEvent(RowChanged)
+		c.Assert(err, check.IsNil)
+		c.Assert(item.Data.(*MessageRow), check.DeepEquals, s.rowEvents[index])
+		index++
+	}
+	c.Assert(decoder.Err(), check.IsNil)
+	c.Assert(index, check.Equals, len(s.rowEvents))
+
+	decoder, err = NewJSONEventBatchStreamDecoder(bytes.NewReader(nil))
+	c.Assert(err, check.IsNil)
+	c.Assert(decoder, check.IsNil)
+
+	// the failure of the stream is returned.
+	decoder, err = NewJSONEventBatchStreamDecoder(iotest.TimeoutReader(bytes.NewReader(data[:8])))
+	c.Assert(err, check.IsNil)
+	c.Assert(decoder.HasNext(), check.IsFalse)
+	c.Assert(decoder.Err(), check.NotNil)
+	_, err = decoder.NextEvent(RowChanged)
+	c.Assert(err, check.NotNil)
+
+	// the truncated message fails.
+	decoder, err = NewJSONEventBatchStreamDecoder(bytes.NewReader(data[:len(data)-1]))
+	c.Assert(err, check.IsNil)
+	for decoder.HasNext() {
+		if _, err = decoder.NextEvent(RowChanged); err != nil {
+			break
+		}
+	}
+	c.Assert(err, check.NotNil)
+}
+
 func (s *batchSuite) TestColumn(c *check.C) {
 	// test varbinary columns (same type with varchar 15)
 	col1 := Column{Type: mysql.TypeVarchar, Flag: BinaryFlag, Value: "\\x00\\x01"}
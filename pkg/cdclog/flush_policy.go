@@ -0,0 +1,91 @@
+// Copyright 2021 PingCAP, Inc. Licensed under Apache-2.0.
+
+package cdclog
+
+import (
+	"time"
+
+	"github.com/tikv/client-go/v2/oracle"
+)
+
+// FlushState is the state of a TableBuffer since its last flush.
+type FlushState struct {
+	// Rows is the number of the encoded kv rows.
+	Rows int
+	// Size is the size in bytes of the encoded kv rows.
+	Size int64
+	// FirstTS and LastTS are the commit ts of the first and the last appended
+	// items.
+	FirstTS uint64
+	LastTS  uint64
+	// FirstAppend is the wall-clock time when the first item is appended.
+	FirstAppend time.Time
+}
+
+// FlushPolicy decides whether a TableBuffer should be flushed. The policies
+// are checked after every append and must be safe to share by the buffers of
+// all tables.
+type FlushPolicy interface {
+	ShouldFlush(state *FlushState, now time.Time) bool
+}
+
+// FlushPolicyFunc is an adapter to use a function as a FlushPolicy.
+type FlushPolicyFunc func(state *FlushState, now time.Time) bool
+
+// ShouldFlush implements FlushPolicy.
+func (f FlushPolicyFunc) ShouldFlush(state *FlushState, now time.Time) bool {
+	return f(state, now)
+}
+
+// FlushOnRows flushes the buffer once it holds the number of kv rows.
+func FlushOnRows(rows int) FlushPolicy {
+	return FlushPolicyFunc(func(state *FlushState, _ time.Time) bool {
+		return state.Rows >= rows
+	})
+}
+
+// FlushOnSize flushes the buffer once the kv rows take the size in memory.
+func FlushOnSize(size int64) FlushPolicy {
+	return FlushPolicyFunc(func(state *FlushState, _ time.Time) bool {
+		return state.Size >= size
+	})
+}
+
+// FlushOnInterval flushes the buffer once the oldest buffered item has waited
+// for the interval, which bounds the latency of the data in follow-mode
+// restores.
+func FlushOnInterval(interval time.Duration) FlushPolicy {
+	return FlushPolicyFunc(func(state *FlushState, now time.Time) bool {
+		return state.Rows > 0 && now.Sub(state.FirstAppend) >= interval
+	})
+}
+
+// FlushOnTSWindow flushes the buffer once the commit ts of the buffered items
+// span the window.
+func FlushOnTSWindow(window time.Duration) FlushPolicy {
+	return FlushPolicyFunc(func(state *FlushState, _ time.Time) bool {
+		if state.Rows == 0 {
+			return false
+		}
+		first := oracle.GetTimeFromTS(state.FirstTS)
+		return oracle.GetTimeFromTS(state.LastTS).Sub(first) >= window
+	})
+}
+
+// FlushOnAny flushes the buffer once any of the policies is satisfied.
+func FlushOnAny(policies ...FlushPolicy) FlushPolicy {
+	return FlushPolicyFunc(func(state *FlushState, now time.Time) bool {
+		for _, p := range policies {
+			if p.ShouldFlush(state, now) {
+				return true
+			}
+		}
+		return false
+	})
+}
+
+// DefaultFlushPolicy flushes the buffer on the kv count or the kv size, which
+// is the policy of NewTableBuffer.
+func DefaultFlushPolicy(flushKVPairs int, flushKVSize int64) FlushPolicy {
+	return FlushOnAny(FlushOnRows(flushKVPairs), FlushOnSize(flushKVSize))
+}
@@ -0,0 +1,52 @@
+// Copyright 2021 PingCAP, Inc. Licensed under Apache-2.0.
+
+package cdclog
+
+import (
+	"time"
+
+	"github.com/pingcap/check"
+	"github.com/tikv/client-go/v2/oracle"
+)
+
+type flushPolicySuite struct{}
+
+var _ = check.Suite(&flushPolicySuite{})
+
+func (s *flushPolicySuite) TestFlushPolicy(c *check.C) {
+	now := time.Now()
+	state := &FlushState{}
+	policy := DefaultFlushPolicy(10, 100)
+	c.Assert(policy.ShouldFlush(state, now), check.IsFalse)
+	state.Rows, state.Size = 10, 50
+	c.Assert(policy.ShouldFlush(state, now), check.IsTrue)
+	state.Rows, state.Size = 5, 100
+	c.Assert(policy.ShouldFlush(state, now), check.IsTrue)
+
+	state = &FlushState{Rows: 1, FirstAppend: now.Add(-time.Second)}
+	c.Assert(FlushOnInterval(time.Second).ShouldFlush(state, now), check.IsTrue)
+	c.Assert(FlushOnInterval(time.Minute).ShouldFlush(state, now), check.IsFalse)
+	// an empty buffer is never flushed.
+	c.Assert(FlushOnInterval(0).ShouldFlush(&FlushState{}, now), check.IsFalse)
+
+	state.FirstTS = oracle.ComposeTS(oracle.GetPhysical(now), 0)
+	state.LastTS = oracle.ComposeTS(oracle.GetPhysical(now.Add(10*time.Second)), 0)
+	c.Assert(FlushOnTSWindow(10*time.Second).ShouldFlush(state, now), check.IsTrue)
+	c.Assert(FlushOnTSWindow(time.Minute).ShouldFlush(state, now), check.IsFalse)
+
+	policy = FlushOnAny(DefaultFlushPolicy(10, 100), FlushOnTSWindow(time.Minute), FlushOnInterval(time.Second))
+	c.Assert(policy.ShouldFlush(state, now), check.IsTrue)
+	c.Assert(policy.ShouldFlush(state, now.Add(-time.Second+time.Millisecond)), check.IsFalse)
+}
+
+func (s *flushPolicySuite) TestTableBufferFlushPolicy(c *check.C) {
+	tb := NewTableBuffer(nil, nil, 10, 100)
+	c.Assert(tb.ShouldApply(), check.IsFalse)
+	tb.state = FlushState{Rows: 1, Size: 1, FirstAppend: time.Now().Add(-time.Hour)}
+	c.Assert(tb.ShouldApply(), check.IsFalse)
+	tb.SetFlushPolicy(FlushOnInterval(time.Minute))
+	c.Assert(tb.ShouldApply(), check.IsTrue)
+	tb.Clear()
+	c.Assert(tb.IsEmpty(), check.IsTrue)
+	c.Assert(tb.ShouldApply(), check.IsFalse)
+}
@@ -22,6 +22,29 @@ import (
 	"github.com/pingcap/br/pkg/storage"
 )
 
+// rowChangedReadChunkSize is the size of the ranges reading the row changed
+// files, which may be large.
+const rowChangedReadChunkSize = 4 << 20
+
+// newRowChangedDecoder returns the decoder of the row changed file, nil if the
+// file is empty. The uncompressed files are read by ranges while being
+// decoded instead of read into memory at once. The compressed files can't be
+// read by ranges, so they're read at once.
+func newRowChangedDecoder(
+	ctx context.Context, s storage.ExternalStorage, path string,
+) (*JSONEventBatchMixedDecoder, error) {
+	if storage.CompressTypeFromName(path) != storage.NoCompression {
+		data, err := s.ReadFile(ctx, path)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		decoder, err := NewJSONEventBatchDecoder(data)
+		return decoder, errors.Trace(err)
+	}
+	decoder, err := NewJSONEventBatchStreamDecoder(storage.NewRangeReader(ctx, s, path, rowChangedReadChunkSize))
+	return decoder, errors.Annotatef(err, "failed to read %s", path)
+}
+
 // EventPuller pulls next event in ts order.
 type EventPuller struct {
 	ddlDecoder            *JSONEventBatchMixedDecoder
@@ -33,6 +56,7 @@ type EventPuller struct {
 	table  string
 
 	storage         storage.ExternalStorage
+	ddlStorage      storage.ExternalStorage
 	ddlFiles        []string
 	rowChangedFiles []string
 
@@ -41,13 +65,16 @@ type EventPuller struct {
 }
 
 // NewEventPuller create eventPuller by given log files, we assume files come in ts order.
+// The ddl files are read from ddlStorage, which may be a cache shared by the
+// pullers of all tables, since every table reads all the ddl files.
 func NewEventPuller(
 	ctx context.Context,
 	schema string,
 	table string,
 	ddlFiles []string,
 	rowChangedFiles []string,
-	storage storage.ExternalStorage) (*EventPuller, error) {
+	storage storage.ExternalStorage,
+	ddlStorage storage.ExternalStorage) (*EventPuller, error) {
 	var (
 		ddlDecoder        *JSONEventBatchMixedDecoder
 		ddlFileIndex      int
@@ -57,7 +84,7 @@ func NewEventPuller(
 	if len(ddlFiles) == 0 {
 		log.Info("There is no ddl file to restore")
 	} else {
-		data, err := storage.ReadFile(ctx, ddlFiles[0])
+		data, err := ddlStorage.ReadFile(ctx, ddlFiles[0])
 		if err != nil {
 			return nil, errors.Trace(err)
 		}
@@ -73,16 +100,13 @@ func NewEventPuller(
 	if len(rowChangedFiles) == 0 {
 		log.Info("There is no row changed file to restore")
 	} else {
-		data, err := storage.ReadFile(ctx, rowChangedFiles[0])
+		decoder, err := newRowChangedDecoder(ctx, storage, rowChangedFiles[0])
 		if err != nil {
 			return nil, errors.Trace(err)
 		}
-		if len(data) != 0 {
+		if decoder != nil {
 			rowFileIndex++
-			rowChangedDecoder, err = NewJSONEventBatchDecoder(data)
-			if err != nil {
-				return nil, errors.Trace(err)
-			}
+			rowChangedDecoder = decoder
 		}
 	}
 
@@ -98,7 +122,8 @@ func NewEventPuller(
 		ddlFileIndex:        ddlFileIndex,
 		rowChangedFileIndex: rowFileIndex,
 
-		storage: storage,
+		storage:    storage,
+		ddlStorage: ddlStorage,
 	}, nil
 }
 
@@ -114,7 +139,7 @@ func (e *EventPuller) PullOneEvent(ctx context.Context) (*SortItem, error) {
 		// current file end, read next file if next file exists
 		if !e.ddlDecoder.HasNext() && e.ddlFileIndex < len(e.ddlFiles) {
 			path := e.ddlFiles[e.ddlFileIndex]
-			data, err = e.storage.ReadFile(ctx, path)
+			data, err = e.ddlStorage.ReadFile(ctx, path)
 			if err != nil {
 				return nil, errors.Trace(err)
 			}
@@ -137,18 +162,19 @@ func (e *EventPuller) PullOneEvent(ctx context.Context) (*SortItem, error) {
 	// dml exists
 	if e.rowChangedDecoder != nil {
 		// current file end, read next file if next file exists
+		if !e.rowChangedDecoder.HasNext() && e.rowChangedDecoder.Err() != nil {
+			return nil, errors.Annotatef(e.rowChangedDecoder.Err(), "failed to read %s",
+				e.rowChangedFiles[e.rowChangedFileIndex-1])
+		}
 		if !e.rowChangedDecoder.HasNext() && e.rowChangedFileIndex < len(e.rowChangedFiles) {
 			path := e.rowChangedFiles[e.rowChangedFileIndex]
-			data, err = e.storage.ReadFile(ctx, path)
+			decoder, err := newRowChangedDecoder(ctx, e.storage, path)
 			if err != nil {
 				return nil, errors.Trace(err)
 			}
-			if len(data) != 0 {
+			if decoder != nil {
 				e.rowChangedFileIndex++
-				e.rowChangedDecoder, err = NewJSONEventBatchDecoder(data)
-				if err != nil {
-					return nil, errors.Trace(err)
-				}
+				e.rowChangedDecoder = decoder
 			}
 		}
 		if e.currentRowChangedItem == nil {
@@ -0,0 +1,160 @@
+// Copyright 2021 PingCAP, Inc. Licensed under Apache-2.0.
+
+package cdclog
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"plugin"
+	"strconv"
+	"strings"
+
+	"github.com/pingcap/errors"
+	"github.com/pingcap/tidb/types"
+
+	berrors "github.com/pingcap/br/pkg/errors"
+)
+
+// Transform transforms the value of a column before the row is encoded, e.g.
+// masks the sensitive data. NULL is never passed to it.
+type Transform func(value types.Datum) (types.Datum, error)
+
+// PluginTransform is the type of the transforms exported by a Go plugin.
+// The value is the string form of the column value, and the result is
+// converted to the type of the column when encoding.
+type PluginTransform = func(value string) (string, error)
+
+// LoadTransformPlugin opens the Go plugin exporting the transforms used by
+// `plugin(name)`.
+func LoadTransformPlugin(path string) (*plugin.Plugin, error) {
+	p, err := plugin.Open(path)
+	if err != nil {
+		return nil, errors.Annotatef(berrors.ErrInvalidArgument, "failed to open transform plugin %s: %v", path, err)
+	}
+	return p, nil
+}
+
+// ParseTransform parses the transform expression, which is one of
+//   - null: replaces the value with NULL.
+//   - const(value): replaces the value with the constant.
+//   - mask(prefix, suffix): keeps the first prefix and the last suffix
+//     characters and replaces the others with '*', the values not longer
+//     than prefix+suffix are masked entirely.
+//   - hash or hash(salt): replaces the value with the hex SHA-256 of the salt
+//     and the value, so the equal values are still equal after masked.
+//   - plugin(name): calls the function exported by the plugin with the name,
+//     the function must be a PluginTransform.
+//
+// The transforms are deterministic except the plugin ones, so the old values
+// of the updated or deleted rows are masked to the same keys as inserted.
+func ParseTransform(expr string, p *plugin.Plugin) (Transform, error) {
+	expr = strings.TrimSpace(expr)
+	name, args := expr, []string(nil)
+	if i := strings.IndexByte(expr, '('); i >= 0 {
+		if !strings.HasSuffix(expr, ")") {
+			return nil, errors.Annotatef(berrors.ErrInvalidArgument, "invalid transform %q, unclosed parenthesis", expr)
+		}
+		name = strings.TrimSpace(expr[:i])
+		if argList := strings.TrimSpace(expr[i+1 : len(expr)-1]); argList != "" {
+			args = strings.Split(argList, ",")
+			for j := range args {
+				args[j] = strings.TrimSpace(args[j])
+			}
+		}
+	}
+	argsErr := func(n string) error {
+		return errors.Annotatef(berrors.ErrInvalidArgument, "transform %q takes %s arguments", expr, n)
+	}
+
+	switch strings.ToLower(name) {
+	case "null":
+		if len(args) != 0 {
+			return nil, argsErr("no")
+		}
+		return func(types.Datum) (types.Datum, error) {
+			return types.Datum{}, nil
+		}, nil
+	case "const":
+		if len(args) != 1 {
+			return nil, argsErr("1")
+		}
+		value := args[0]
+		return func(types.Datum) (types.Datum, error) {
+			return types.NewStringDatum(value), nil
+		}, nil
+	case "mask":
+		if len(args) != 2 {
+			return nil, argsErr("2")
+		}
+		prefix, err1 := strconv.Atoi(args[0])
+		suffix, err2 := strconv.Atoi(args[1])
+		if err1 != nil || err2 != nil || prefix < 0 || suffix < 0 {
+			return nil, errors.Annotatef(berrors.ErrInvalidArgument,
+				"the arguments of transform %q should be non-negative integers", expr)
+		}
+		return func(value types.Datum) (types.Datum, error) {
+			s, err := value.ToString()
+			if err != nil {
+				return value, errors.Trace(err)
+			}
+			return types.NewStringDatum(maskString(s, prefix, suffix)), nil
+		}, nil
+	case "hash":
+		if len(args) > 1 {
+			return nil, argsErr("at most 1")
+		}
+		var salt string
+		if len(args) == 1 {
+			salt = args[0]
+		}
+		return func(value types.Datum) (types.Datum, error) {
+			s, err := value.ToString()
+			if err != nil {
+				return value, errors.Trace(err)
+			}
+			sum := sha256.Sum256([]byte(salt + s))
+			return types.NewStringDatum(hex.EncodeToString(sum[:])), nil
+		}, nil
+	case "plugin":
+		if len(args) != 1 {
+			return nil, argsErr("1")
+		}
+		if p == nil {
+			return nil, errors.Annotatef(berrors.ErrInvalidArgument, "transform %q needs a transform plugin", expr)
+		}
+		sym, err := p.Lookup(args[0])
+		if err != nil {
+			return nil, errors.Annotatef(berrors.ErrInvalidArgument, "transform %q: %v", expr, err)
+		}
+		fn, ok := sym.(PluginTransform)
+		if !ok {
+			return nil, errors.Annotatef(berrors.ErrInvalidArgument,
+				"transform %q: %s isn't a func(string) (string, error) but %T", expr, args[0], sym)
+		}
+		return func(value types.Datum) (types.Datum, error) {
+			s, err := value.ToString()
+			if err != nil {
+				return value, errors.Trace(err)
+			}
+			s, err = fn(s)
+			if err != nil {
+				return value, errors.Annotatef(err, "transform plugin %s", args[0])
+			}
+			return types.NewStringDatum(s), nil
+		}, nil
+	default:
+		return nil, errors.Annotatef(berrors.ErrInvalidArgument, "unknown transform %q", expr)
+	}
+}
+
+func maskString(s string, prefix, suffix int) string {
+	runes := []rune(s)
+	if prefix+suffix >= len(runes) {
+		// nothing would be masked, mask all rather than leaking short values.
+		prefix, suffix = 0, 0
+	}
+	for i := prefix; i < len(runes)-suffix; i++ {
+		runes[i] = '*'
+	}
+	return string(runes)
+}
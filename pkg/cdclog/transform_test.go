@@ -0,0 +1,50 @@
+// Copyright 2021 PingCAP, Inc. Licensed under Apache-2.0.
+
+package cdclog
+
+import (
+	"github.com/pingcap/check"
+	"github.com/pingcap/tidb/types"
+)
+
+type transformSuite struct{}
+
+var _ = check.Suite(&transformSuite{})
+
+func (s *transformSuite) TestParseTransform(c *check.C) {
+	cases := []struct {
+		expr     string
+		value    types.Datum
+		expected types.Datum
+	}{
+		{"null", types.NewStringDatum("alice"), types.Datum{}},
+		{"const( unknown )", types.NewStringDatum("alice"), types.NewStringDatum("unknown")},
+		{"mask(1, 4)", types.NewStringDatum("alice@a.io"), types.NewStringDatum("a*****a.io")},
+		{"mask(2,2)", types.NewStringDatum("bob"), types.NewStringDatum("***")},
+		{"mask(0,4)", types.NewIntDatum(13800138000), types.NewStringDatum("*******8000")},
+		{
+			"hash", types.NewStringDatum("alice"),
+			types.NewStringDatum("2bd806c97f0e00af1a1fc3328fa763a9269723c8db8fac4f93af71db186d6e90"),
+		},
+	}
+	for _, ca := range cases {
+		transform, err := ParseTransform(ca.expr, nil)
+		c.Assert(err, check.IsNil, check.Commentf("%s", ca.expr))
+		value, err := transform(ca.value)
+		c.Assert(err, check.IsNil)
+		c.Assert(value, check.DeepEquals, ca.expected, check.Commentf("%s", ca.expr))
+	}
+
+	hash, err := ParseTransform("hash(salt)", nil)
+	c.Assert(err, check.IsNil)
+	salted, err := hash(types.NewStringDatum("alice"))
+	c.Assert(err, check.IsNil)
+	c.Assert(salted.GetString(), check.Not(check.Equals), cases[len(cases)-1].expected.GetString())
+
+	for _, expr := range []string{"unknown", "null(1)", "const", "mask(1)", "mask(-1,2)", "mask(1,2", "hash(a,b)"} {
+		_, err = ParseTransform(expr, nil)
+		c.Assert(err, check.ErrorMatches, ".*transform.*", check.Commentf("%s", expr))
+	}
+	_, err = ParseTransform("plugin(mask)", nil)
+	c.Assert(err, check.ErrorMatches, ".*needs a transform plugin.*")
+}
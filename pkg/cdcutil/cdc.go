@@ -0,0 +1,217 @@
+// Copyright 2021 PingCAP, Inc. Licensed under Apache-2.0.
+
+package cdcutil
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/pingcap/errors"
+	filter "github.com/pingcap/tidb-tools/pkg/table-filter"
+	"go.etcd.io/etcd/clientv3"
+
+	berrors "github.com/pingcap/br/pkg/errors"
+	"github.com/pingcap/br/pkg/httputil"
+)
+
+const (
+	// the keys of TiCDC in the etcd of PD.
+	changefeedInfoPrefix = "/tidb/cdc/changefeed/info/"
+	capturePrefix        = "/tidb/cdc/capture/"
+	ownerPrefix          = "/tidb/cdc/owner/"
+
+	ownerAdminURL = "/capture/owner/admin"
+
+	// the admin jobs accepted by the owner of TiCDC.
+	adminJobStop   = 1
+	adminJobResume = 2
+)
+
+// Changefeed is a changefeed of TiCDC registered in the etcd of PD.
+type Changefeed struct {
+	ID string
+	// State is the state of the changefeed, e.g. normal and stopped. It's
+	// empty for the changefeeds created by the old versions of TiCDC.
+	State        string
+	AdminJobType int
+	// Rules are the table filter rules of the changefeed, empty means all
+	// tables.
+	Rules []string
+}
+
+type changefeedInfo struct {
+	State        string `json:"state"`
+	AdminJobType int    `json:"admin-job-type"`
+	Config       struct {
+		Filter struct {
+			Rules []string `json:"rules"`
+		} `json:"filter"`
+	} `json:"config"`
+}
+
+type captureInfo struct {
+	ID      string `json:"id"`
+	Address string `json:"address"`
+}
+
+// IsActive checks whether the changefeed is replicating.
+func (cf *Changefeed) IsActive() bool {
+	if cf.State != "" {
+		return cf.State == "normal"
+	}
+	return cf.AdminJobType == 0
+}
+
+// MatchTable checks whether the changefeed replicates the table.
+func (cf *Changefeed) MatchTable(schema, table string) (bool, error) {
+	rules := cf.Rules
+	if len(rules) == 0 {
+		rules = []string{"*.*"}
+	}
+	f, err := filter.Parse(rules)
+	if err != nil {
+		return false, errors.Annotatef(berrors.ErrInvalidArgument,
+			"invalid filter rules of the changefeed %s: %v", cf.ID, err)
+	}
+	return filter.CaseInsensitive(f).MatchTable(schema, table), nil
+}
+
+// Client finds the changefeeds of TiCDC by the etcd of PD, and pauses or
+// resumes them by the owner of TiCDC.
+type Client struct {
+	kv      clientv3.KV
+	httpCli *http.Client
+	scheme  string
+}
+
+// NewClient creates a Client by the KV of the etcd of PD.
+func NewClient(kv clientv3.KV, tlsConf *tls.Config) *Client {
+	scheme := "http"
+	if tlsConf != nil {
+		scheme = "https"
+	}
+	return &Client{
+		kv:      kv,
+		httpCli: httputil.NewClient(tlsConf),
+		scheme:  scheme,
+	}
+}
+
+// Changefeeds lists the changefeeds of all TiCDC clusters using the PD.
+func (c *Client) Changefeeds(ctx context.Context) ([]*Changefeed, error) {
+	resp, err := c.kv.Get(ctx, changefeedInfoPrefix, clientv3.WithPrefix())
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	changefeeds := make([]*Changefeed, 0, len(resp.Kvs))
+	for _, kv := range resp.Kvs {
+		id := strings.TrimPrefix(string(kv.Key), changefeedInfoPrefix)
+		info := changefeedInfo{}
+		if err = json.Unmarshal(kv.Value, &info); err != nil {
+			return nil, errors.Annotatef(err, "failed to parse the info of the changefeed %s", id)
+		}
+		changefeeds = append(changefeeds, &Changefeed{
+			ID:           id,
+			State:        info.State,
+			AdminJobType: info.AdminJobType,
+			Rules:        info.Config.Filter.Rules,
+		})
+	}
+	return changefeeds, nil
+}
+
+// Pause pauses the changefeed.
+func (c *Client) Pause(ctx context.Context, id string) error {
+	return errors.Trace(c.sendAdminJob(ctx, id, adminJobStop))
+}
+
+// Resume resumes the changefeed.
+func (c *Client) Resume(ctx context.Context, id string) error {
+	return errors.Trace(c.sendAdminJob(ctx, id, adminJobResume))
+}
+
+// ownerAddress returns the address of the owner capture, which is the
+// campaigner of the lowest create revision.
+func (c *Client) ownerAddress(ctx context.Context) (string, error) {
+	resp, err := c.kv.Get(ctx, ownerPrefix,
+		clientv3.WithPrefix(), clientv3.WithSort(clientv3.SortByCreateRevision, clientv3.SortAscend), clientv3.WithLimit(1))
+	if err != nil {
+		return "", errors.Trace(err)
+	}
+	if len(resp.Kvs) == 0 {
+		return "", errors.Annotate(berrors.ErrInvalidArgument, "the owner of TiCDC isn't found")
+	}
+	captureID := string(resp.Kvs[0].Value)
+	resp, err = c.kv.Get(ctx, capturePrefix+captureID)
+	if err != nil {
+		return "", errors.Trace(err)
+	}
+	if len(resp.Kvs) == 0 {
+		return "", errors.Annotatef(berrors.ErrInvalidArgument, "the owner capture %s of TiCDC isn't found", captureID)
+	}
+	info := captureInfo{}
+	if err = json.Unmarshal(resp.Kvs[0].Value, &info); err != nil {
+		return "", errors.Annotatef(err, "failed to parse the info of the capture %s", captureID)
+	}
+	return info.Address, nil
+}
+
+func (c *Client) sendAdminJob(ctx context.Context, id string, job int) error {
+	addr, err := c.ownerAddress(ctx)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	form := url.Values{}
+	form.Set("cf-id", id)
+	form.Set("admin-job", fmt.Sprint(job))
+	reqURL := fmt.Sprintf("%s://%s%s", c.scheme, addr, ownerAdminURL)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, reqURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return errors.Trace(err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	resp, err := c.httpCli.Do(req)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return errors.Annotatef(berrors.ErrInvalidArgument, "TiCDC owner %s rejects the admin job %d of the changefeed %s: %s",
+			addr, job, id, string(body))
+	}
+	return nil
+}
+
+// Policy is how the restore coordinates with the changefeeds replicating the
+// restored tables.
+type Policy string
+
+const (
+	// PolicyNone doesn't look for the changefeeds.
+	PolicyNone Policy = "none"
+	// PolicyWarn only warns about the changefeeds.
+	PolicyWarn Policy = "warn"
+	// PolicyPause pauses the changefeeds during the restore, and resumes them
+	// afterwards.
+	PolicyPause Policy = "pause"
+	// PolicyFail fails the restore if there are such changefeeds.
+	PolicyFail Policy = "fail"
+)
+
+// Validate checks whether the policy is supported.
+func (p Policy) Validate() error {
+	switch p {
+	case PolicyNone, PolicyWarn, PolicyPause, PolicyFail:
+		return nil
+	default:
+		return errors.Annotatef(berrors.ErrInvalidArgument,
+			"unknown cdc coordination policy %q, should be one of 'none|warn|pause|fail'", p)
+	}
+}
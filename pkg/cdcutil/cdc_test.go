@@ -0,0 +1,99 @@
+// Copyright 2021 PingCAP, Inc. Licensed under Apache-2.0.
+
+package cdcutil_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"testing"
+
+	. "github.com/pingcap/check"
+	"go.etcd.io/etcd/clientv3"
+	"go.etcd.io/etcd/mvcc/mvccpb"
+
+	"github.com/pingcap/br/pkg/cdcutil"
+)
+
+func Test(t *testing.T) {
+	TestingT(t)
+}
+
+var _ = Suite(&testCDCSuite{})
+
+type testCDCSuite struct{}
+
+// fakeKV serves the gets of the keys, any option means getting by the prefix.
+type fakeKV struct {
+	clientv3.KV
+	kvs map[string]string
+}
+
+func (f *fakeKV) Get(_ context.Context, key string, opts ...clientv3.OpOption) (*clientv3.GetResponse, error) {
+	resp := &clientv3.GetResponse{}
+	for k, v := range f.kvs {
+		if k == key || (len(opts) > 0 && strings.HasPrefix(k, key)) {
+			resp.Kvs = append(resp.Kvs, &mvccpb.KeyValue{Key: []byte(k), Value: []byte(v)})
+		}
+	}
+	sort.Slice(resp.Kvs, func(i, j int) bool { return string(resp.Kvs[i].Key) < string(resp.Kvs[j].Key) })
+	return resp, nil
+}
+
+func (s *testCDCSuite) TestChangefeeds(c *C) {
+	kv := &fakeKV{kvs: map[string]string{
+		"/tidb/cdc/changefeed/info/cf-1": `{"state":"normal","config":{"filter":{"rules":["db1.*"]}}}`,
+		"/tidb/cdc/changefeed/info/cf-2": `{"state":"stopped","admin-job-type":1}`,
+		"/tidb/cdc/changefeed/info/cf-3": `{"admin-job-type":0}`,
+	}}
+	changefeeds, err := cdcutil.NewClient(kv, nil).Changefeeds(context.Background())
+	c.Assert(err, IsNil)
+	c.Assert(changefeeds, HasLen, 3)
+
+	c.Assert(changefeeds[0].ID, Equals, "cf-1")
+	c.Assert(changefeeds[0].IsActive(), IsTrue)
+	match, err := changefeeds[0].MatchTable("DB1", "t")
+	c.Assert(err, IsNil)
+	c.Assert(match, IsTrue)
+	match, err = changefeeds[0].MatchTable("db2", "t")
+	c.Assert(err, IsNil)
+	c.Assert(match, IsFalse)
+
+	c.Assert(changefeeds[1].IsActive(), IsFalse)
+	// the old changefeeds have no state and replicate all tables.
+	c.Assert(changefeeds[2].IsActive(), IsTrue)
+	match, err = changefeeds[2].MatchTable("db2", "t")
+	c.Assert(err, IsNil)
+	c.Assert(match, IsTrue)
+}
+
+func (s *testCDCSuite) TestPauseResume(c *C) {
+	var jobs []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c.Assert(r.URL.Path, Equals, "/capture/owner/admin")
+		c.Assert(r.ParseForm(), IsNil)
+		if r.Form.Get("cf-id") == "unknown" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		jobs = append(jobs, r.Form.Get("cf-id")+":"+r.Form.Get("admin-job"))
+	}))
+	defer server.Close()
+
+	kv := &fakeKV{kvs: map[string]string{
+		"/tidb/cdc/owner/22319a1e": "capture-1",
+		"/tidb/cdc/capture/capture-1": `{"id":"capture-1","address":"` +
+			strings.TrimPrefix(server.URL, "http://") + `"}`,
+	}}
+	client := cdcutil.NewClient(kv, nil)
+	ctx := context.Background()
+	c.Assert(client.Pause(ctx, "cf-1"), IsNil)
+	c.Assert(client.Resume(ctx, "cf-1"), IsNil)
+	c.Assert(jobs, DeepEquals, []string{"cf-1:1", "cf-1:2"})
+	c.Assert(client.Pause(ctx, "unknown"), ErrorMatches, ".*rejects the admin job.*")
+
+	delete(kv.kvs, "/tidb/cdc/owner/22319a1e")
+	c.Assert(client.Pause(ctx, "cf-1"), ErrorMatches, ".*owner of TiCDC isn't found.*")
+}
@@ -0,0 +1,359 @@
+// Copyright 2021 PingCAP, Inc. Licensed under Apache-2.0.
+
+package checksum
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/pingcap/errors"
+	"github.com/pingcap/log"
+	"github.com/pingcap/tidb/kv"
+	"github.com/pingcap/tidb/util/codec"
+	"github.com/pingcap/tipb/go-tipb"
+	pd "github.com/tikv/pd/client"
+	"go.uber.org/zap"
+
+	"github.com/pingcap/br/pkg/storage"
+)
+
+const (
+	// CheckpointFilePrefix is the prefix of the checkpoint files of the
+	// chunked checksum in the storage. Every table is stored as
+	// `checksum.checkpoint.<cluster-id>.<table-id>`.
+	CheckpointFilePrefix = "checksum.checkpoint."
+
+	regionScanLimit = 128
+	// checkpointInterval is the min interval between the writes of a
+	// checkpoint, so the small chunks don't write the storage too often.
+	checkpointInterval = 10 * time.Second
+)
+
+// RangeSplitter splits the key range of a checksum request into chunks.
+type RangeSplitter interface {
+	// SplitRange returns the continuous chunks covering the range in order.
+	SplitRange(ctx context.Context, r kv.KeyRange) ([]kv.KeyRange, error)
+}
+
+type regionSplitter struct {
+	pdClient        pd.Client
+	regionsPerChunk int
+}
+
+// NewRegionSplitter returns the RangeSplitter splitting the ranges by the
+// region boundaries in PD, every chunk covers at most regionsPerChunk regions.
+func NewRegionSplitter(pdClient pd.Client, regionsPerChunk int) RangeSplitter {
+	if regionsPerChunk <= 0 {
+		regionsPerChunk = 1
+	}
+	return &regionSplitter{pdClient: pdClient, regionsPerChunk: regionsPerChunk}
+}
+
+func (s *regionSplitter) SplitRange(ctx context.Context, r kv.KeyRange) ([]kv.KeyRange, error) {
+	// Keys are saved in encoded format in TiKV.
+	startKey := codec.EncodeBytes([]byte{}, r.StartKey)
+	var endKey []byte
+	if len(r.EndKey) > 0 {
+		endKey = codec.EncodeBytes([]byte{}, r.EndKey)
+	}
+	var boundaries []kv.Key
+	scanned := 0
+	for {
+		regions, err := s.pdClient.ScanRegions(ctx, startKey, endKey, regionScanLimit)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		for _, region := range regions {
+			scanned++
+			if scanned%s.regionsPerChunk != 0 || len(region.Meta.GetEndKey()) == 0 {
+				continue
+			}
+			_, key, err := codec.DecodeBytes(region.Meta.GetEndKey(), nil)
+			if err != nil {
+				return nil, errors.Trace(err)
+			}
+			if bytes.Compare(key, r.StartKey) > 0 && (len(r.EndKey) == 0 || bytes.Compare(key, r.EndKey) < 0) {
+				boundaries = append(boundaries, key)
+			}
+		}
+		if len(regions) < regionScanLimit {
+			break
+		}
+		startKey = regions[len(regions)-1].Meta.GetEndKey()
+		if len(startKey) == 0 || (len(endKey) > 0 && bytes.Compare(startKey, endKey) >= 0) {
+			break
+		}
+	}
+
+	chunks := make([]kv.KeyRange, 0, len(boundaries)+1)
+	start := r.StartKey
+	for _, key := range boundaries {
+		chunks = append(chunks, kv.KeyRange{StartKey: start, EndKey: key})
+		start = key
+	}
+	return append(chunks, kv.KeyRange{StartKey: start, EndKey: r.EndKey}), nil
+}
+
+// ChunkChecksum is a chunk of a checksum request, and its checksum once
+// finished.
+type ChunkChecksum struct {
+	// Request is the index of the checksum request of the executor.
+	Request  int    `json:"request"`
+	StartKey []byte `json:"start-key"`
+	EndKey   []byte `json:"end-key"`
+
+	Finished   bool   `json:"finished"`
+	Checksum   uint64 `json:"checksum"`
+	TotalKvs   uint64 `json:"total-kvs"`
+	TotalBytes uint64 `json:"total-bytes"`
+}
+
+// CheckpointScope identifies the restore a checkpoint is written by. The
+// checkpoint is only resumed by the restore of the same scope, since the data
+// of the tables differs among the clusters and the backups.
+type CheckpointScope struct {
+	// ClusterID is the ID of the cluster the tables are restored into.
+	ClusterID uint64 `json:"cluster-id"`
+	// Job identifies the restore job, e.g. by the backup it restores.
+	Job string `json:"job"`
+	// SnapshotTS is the ts of the snapshot of the restored backup.
+	SnapshotTS uint64 `json:"snapshot-ts"`
+}
+
+// checkpointFile is the content of a checkpoint file.
+type checkpointFile struct {
+	CheckpointScope
+	Chunks []*ChunkChecksum `json:"chunks"`
+}
+
+// Checkpoint persists the chunks of the checksum of a table and the checksums
+// of the finished ones, so a failed checksum resumes from the unfinished
+// chunks. It's only valid while the data of the table doesn't change, e.g. a
+// restored table.
+//
+// All methods of a nil *Checkpoint are no-op, so the chunks are split again
+// and the checksum starts from scratch.
+type Checkpoint struct {
+	storage storage.ExternalStorage
+	name    string
+	scope   CheckpointScope
+
+	chunks    []*ChunkChecksum
+	dirty     bool
+	lastWrite time.Time
+}
+
+// LoadCheckpoint loads the checkpoint of the table from the storage, or
+// creates an empty one if the table has no checkpoint. The checkpoint written
+// by a restore of another scope is discarded and overwritten.
+func LoadCheckpoint(
+	ctx context.Context,
+	s storage.ExternalStorage,
+	scope CheckpointScope,
+	tableID int64,
+) (*Checkpoint, error) {
+	cp := &Checkpoint{
+		storage:   s,
+		name:      fmt.Sprintf("%s%d.%d", CheckpointFilePrefix, scope.ClusterID, tableID),
+		scope:     scope,
+		lastWrite: time.Now(),
+	}
+	exists, err := s.FileExists(ctx, cp.name)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	if !exists {
+		return cp, nil
+	}
+	data, err := s.ReadFile(ctx, cp.name)
+	if err != nil {
+		return nil, errors.Annotatef(err, "failed to read checksum checkpoint %s", cp.name)
+	}
+	var file checkpointFile
+	if err = json.Unmarshal(data, &file); err != nil {
+		log.Warn("invalid checksum checkpoint, checksum from scratch",
+			zap.String("checkpoint", cp.name), zap.Error(err))
+		return cp, nil
+	}
+	if file.CheckpointScope != scope {
+		log.Warn("checksum checkpoint of another restore, checksum from scratch",
+			zap.String("checkpoint", cp.name),
+			zap.Uint64("cluster-id", file.ClusterID),
+			zap.String("job", file.Job),
+			zap.Uint64("snapshot-ts", file.SnapshotTS))
+		return cp, nil
+	}
+	cp.chunks = file.Chunks
+	return cp, nil
+}
+
+// Chunks returns the chunks recorded in the checkpoint.
+func (cp *Checkpoint) Chunks() []*ChunkChecksum {
+	if cp == nil {
+		return nil
+	}
+	return cp.chunks
+}
+
+func (cp *Checkpoint) requestChunks(req int) []*ChunkChecksum {
+	var chunks []*ChunkChecksum
+	for _, chunk := range cp.Chunks() {
+		if chunk.Request == req {
+			chunks = append(chunks, chunk)
+		}
+	}
+	return chunks
+}
+
+func (cp *Checkpoint) addChunks(chunks []*ChunkChecksum) {
+	if cp == nil {
+		return
+	}
+	cp.chunks = append(cp.chunks, chunks...)
+	cp.dirty = true
+}
+
+func (cp *Checkpoint) removeChunks(req int) {
+	chunks := cp.chunks[:0]
+	for _, chunk := range cp.chunks {
+		if chunk.Request != req {
+			chunks = append(chunks, chunk)
+		}
+	}
+	cp.chunks = chunks
+	cp.dirty = true
+}
+
+// finish records the checksum of the chunk, and writes the checkpoint if it
+// isn't written for a while.
+func (cp *Checkpoint) finish(ctx context.Context, chunk *ChunkChecksum, resp *tipb.ChecksumResponse) error {
+	chunk.Finished = true
+	chunk.Checksum = resp.Checksum
+	chunk.TotalKvs = resp.TotalKvs
+	chunk.TotalBytes = resp.TotalBytes
+	if cp == nil {
+		return nil
+	}
+	cp.dirty = true
+	if time.Since(cp.lastWrite) < checkpointInterval {
+		return nil
+	}
+	return errors.Trace(cp.Flush(ctx))
+}
+
+// Flush writes the checkpoint to the storage if it's changed.
+func (cp *Checkpoint) Flush(ctx context.Context) error {
+	if cp == nil || !cp.dirty {
+		return nil
+	}
+	data, err := json.Marshal(checkpointFile{CheckpointScope: cp.scope, Chunks: cp.chunks})
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if err = cp.storage.WriteFile(ctx, cp.name, data); err != nil {
+		return errors.Annotatef(err, "failed to write checksum checkpoint %s", cp.name)
+	}
+	cp.dirty = false
+	cp.lastWrite = time.Now()
+	return nil
+}
+
+// Remove deletes the checkpoint from the storage.
+func (cp *Checkpoint) Remove(ctx context.Context) error {
+	if cp == nil {
+		return nil
+	}
+	exists, err := cp.storage.FileExists(ctx, cp.name)
+	if err != nil || !exists {
+		return errors.Trace(err)
+	}
+	return errors.Trace(cp.storage.DeleteFile(ctx, cp.name))
+}
+
+// planChunks returns the chunks of the request, which are recorded in the
+// checkpoint, or split by the splitter.
+func (exec *Executor) planChunks(ctx context.Context, i int, req *kv.Request) ([]*ChunkChecksum, error) {
+	chunks := exec.checkpoint.requestChunks(i)
+	if len(chunks) > 0 && coversRanges(chunks, req.KeyRanges) {
+		return chunks, nil
+	}
+	if len(chunks) > 0 {
+		log.Warn("the chunks in checksum checkpoint don't match the request, split again", zap.Int("request", i))
+		exec.checkpoint.removeChunks(i)
+		chunks = nil
+	}
+	for _, r := range req.KeyRanges {
+		ranges, err := exec.splitter.SplitRange(ctx, r)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		for _, chunk := range ranges {
+			chunks = append(chunks, &ChunkChecksum{Request: i, StartKey: chunk.StartKey, EndKey: chunk.EndKey})
+		}
+	}
+	exec.checkpoint.addChunks(chunks)
+	return chunks, nil
+}
+
+// coversRanges returns whether the chunks are split from the ranges.
+func coversRanges(chunks []*ChunkChecksum, ranges []kv.KeyRange) bool {
+	if len(ranges) == 0 {
+		return false
+	}
+	return bytes.Equal(chunks[0].StartKey, ranges[0].StartKey) &&
+		bytes.Equal(chunks[len(chunks)-1].EndKey, ranges[len(ranges)-1].EndKey)
+}
+
+// executeChunks executes the requests chunk by chunk, skipping the chunks
+// finished in the checkpoint, and aggregates the checksums of all chunks.
+func (exec *Executor) executeChunks(
+	ctx context.Context,
+	client kv.Client,
+	updateFn func(),
+) (*tipb.ChecksumResponse, error) {
+	checksumResp := &tipb.ChecksumResponse{}
+	total, resumed := 0, 0
+	for i, req := range exec.reqs {
+		chunks, err := exec.planChunks(ctx, i, req)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		for _, chunk := range chunks {
+			total++
+			if chunk.Finished {
+				resumed++
+			} else if err = exec.executeChunk(ctx, client, req, chunk); err != nil {
+				if flushErr := exec.checkpoint.Flush(ctx); flushErr != nil {
+					log.Warn("failed to write checksum checkpoint", zap.Error(flushErr))
+				}
+				return nil, errors.Trace(err)
+			}
+			updateChecksumResponse(checksumResp, &tipb.ChecksumResponse{
+				Checksum:   chunk.Checksum,
+				TotalKvs:   chunk.TotalKvs,
+				TotalBytes: chunk.TotalBytes,
+			})
+		}
+		updateFn()
+	}
+	if err := exec.checkpoint.Remove(ctx); err != nil {
+		log.Warn("failed to remove checksum checkpoint", zap.Error(err))
+	}
+	log.Info("chunked checksum finished", zap.Int("chunks", total), zap.Int("resumed", resumed))
+	return checksumResp, nil
+}
+
+func (exec *Executor) executeChunk(ctx context.Context, client kv.Client, req *kv.Request, chunk *ChunkChecksum) error {
+	chunkReq := *req
+	chunkReq.KeyRanges = []kv.KeyRange{{StartKey: chunk.StartKey, EndKey: chunk.EndKey}}
+	// It's a place holder in BR, see Execute.
+	killed := uint32(0)
+	resp, err := sendChecksumRequest(ctx, client, &chunkReq, kv.NewVariables(&killed))
+	if err != nil {
+		return errors.Annotatef(err, "failed to checksum chunk [%x, %x)", chunk.StartKey, chunk.EndKey)
+	}
+	return errors.Trace(exec.checkpoint.finish(ctx, chunk, resp))
+}
@@ -28,6 +28,9 @@ type ExecutorBuilder struct {
 	oldTable *metautil.Table
 
 	concurrency uint
+
+	splitter   RangeSplitter
+	checkpoint *Checkpoint
 }
 
 // NewExecutorBuilder returns a new executor builder.
@@ -52,13 +55,23 @@ func (builder *ExecutorBuilder) SetConcurrency(conc uint) *ExecutorBuilder {
 	return builder
 }
 
+// SetChunks makes the checksum executed chunk by chunk split by the splitter,
+// and the checksums of the chunks are recorded in the checkpoint, so a failed
+// checksum of a large table resumes from the unfinished chunks. The
+// checkpoint can be nil.
+func (builder *ExecutorBuilder) SetChunks(splitter RangeSplitter, checkpoint *Checkpoint) *ExecutorBuilder {
+	builder.splitter = splitter
+	builder.checkpoint = checkpoint
+	return builder
+}
+
 // Build builds a checksum executor.
 func (builder *ExecutorBuilder) Build() (*Executor, error) {
 	reqs, err := buildChecksumRequest(builder.table, builder.oldTable, builder.ts, builder.concurrency)
 	if err != nil {
 		return nil, errors.Trace(err)
 	}
-	return &Executor{reqs: reqs}, nil
+	return &Executor{reqs: reqs, splitter: builder.splitter, checkpoint: builder.checkpoint}, nil
 }
 
 func buildChecksumRequest(
@@ -264,6 +277,9 @@ func updateChecksumResponse(resp, update *tipb.ChecksumResponse) {
 // Executor is a checksum executor.
 type Executor struct {
 	reqs []*kv.Request
+
+	splitter   RangeSplitter
+	checkpoint *Checkpoint
 }
 
 // Len returns the total number of checksum requests.
@@ -302,6 +318,9 @@ func (exec *Executor) Execute(
 	client kv.Client,
 	updateFn func(),
 ) (*tipb.ChecksumResponse, error) {
+	if exec.splitter != nil {
+		return exec.executeChunks(ctx, client, updateFn)
+	}
 	checksumResp := &tipb.ChecksumResponse{}
 	for _, req := range exec.reqs {
 		// Pointer to SessionVars.Killed
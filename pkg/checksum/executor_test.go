@@ -4,6 +4,8 @@ package checksum_test
 
 import (
 	"context"
+	"encoding/json"
+	"fmt"
 	"math"
 	"testing"
 
@@ -19,6 +21,7 @@ import (
 	"github.com/pingcap/br/pkg/backup"
 	"github.com/pingcap/br/pkg/checksum"
 	"github.com/pingcap/br/pkg/mock"
+	"github.com/pingcap/br/pkg/storage"
 )
 
 func TestT(t *testing.T) {
@@ -130,3 +133,94 @@ func (s *testChecksumSuite) TestChecksum(c *C) {
 		return nil
 	}), IsNil)
 }
+
+// halfSplitter splits every range into two chunks.
+type halfSplitter struct{}
+
+func (halfSplitter) SplitRange(_ context.Context, r kv.KeyRange) ([]kv.KeyRange, error) {
+	mid := append(append(kv.Key{}, r.StartKey...), 0)
+	return []kv.KeyRange{{StartKey: r.StartKey, EndKey: mid}, {StartKey: mid, EndKey: r.EndKey}}, nil
+}
+
+func (s *testChecksumSuite) TestChunkedChecksum(c *C) {
+	c.Assert(s.mock.Start(), IsNil)
+	defer s.mock.Stop()
+
+	tk := testkit.NewTestKit(c, s.mock.Storage)
+	tk.MustExec("use test")
+	tk.MustExec("drop table if exists t4;")
+	tk.MustExec("create table t4 (a int);")
+	tk.MustExec("insert into t4 values (10);")
+	tableInfo := s.getTableInfo(c, "test", "t4")
+	ctx := context.Background()
+
+	exe, err := checksum.NewExecutorBuilder(tableInfo, math.MaxUint64).
+		SetChunks(halfSplitter{}, nil).
+		Build()
+	c.Assert(err, IsNil)
+	resp, err := exe.Execute(ctx, s.mock.Storage.GetClient(), func() {})
+	c.Assert(err, IsNil)
+	// Cluster returns a dummy checksum for each chunk.
+	c.Assert(resp.Checksum, Equals, uint64(0), Commentf("%v", resp))
+	c.Assert(resp.TotalKvs, Equals, uint64(2), Commentf("%v", resp))
+	c.Assert(resp.TotalBytes, Equals, uint64(2), Commentf("%v", resp))
+
+	// the checkpoint records the first chunk is finished.
+	var keyRanges []kv.KeyRange
+	c.Assert(exe.Each(func(req *kv.Request) error {
+		keyRanges = req.KeyRanges
+		return nil
+	}), IsNil)
+	c.Assert(keyRanges, HasLen, 1)
+	chunks, err := halfSplitter{}.SplitRange(ctx, keyRanges[0])
+	c.Assert(err, IsNil)
+	recorded := []*checksum.ChunkChecksum{
+		{StartKey: chunks[0].StartKey, EndKey: chunks[0].EndKey, Finished: true, Checksum: 7, TotalKvs: 10, TotalBytes: 100},
+		{StartKey: chunks[1].StartKey, EndKey: chunks[1].EndKey},
+	}
+	scope := checksum.CheckpointScope{ClusterID: 1, Job: "backup", SnapshotTS: 100}
+	data, err := json.Marshal(map[string]interface{}{
+		"cluster-id":  scope.ClusterID,
+		"job":         scope.Job,
+		"snapshot-ts": scope.SnapshotTS,
+		"chunks":      recorded,
+	})
+	c.Assert(err, IsNil)
+	backend, err := storage.ParseBackend("local://"+c.MkDir(), nil)
+	c.Assert(err, IsNil)
+	store, err := storage.Create(ctx, backend, true)
+	c.Assert(err, IsNil)
+	name := fmt.Sprintf("%s%d.%d", checksum.CheckpointFilePrefix, scope.ClusterID, tableInfo.ID)
+	c.Assert(store.WriteFile(ctx, name, data), IsNil)
+
+	// the checkpoint of another restore is discarded.
+	for _, other := range []checksum.CheckpointScope{
+		{ClusterID: 1, Job: "another backup", SnapshotTS: 100},
+		{ClusterID: 1, Job: "backup", SnapshotTS: 101},
+	} {
+		checkpoint, err := checksum.LoadCheckpoint(ctx, store, other, tableInfo.ID)
+		c.Assert(err, IsNil)
+		c.Assert(checkpoint.Chunks(), HasLen, 0)
+	}
+	checkpoint, err := checksum.LoadCheckpoint(ctx, store, checksum.CheckpointScope{ClusterID: 2}, tableInfo.ID)
+	c.Assert(err, IsNil)
+	c.Assert(checkpoint.Chunks(), HasLen, 0)
+
+	checkpoint, err = checksum.LoadCheckpoint(ctx, store, scope, tableInfo.ID)
+	c.Assert(err, IsNil)
+	c.Assert(checkpoint.Chunks(), HasLen, 2)
+	exe, err = checksum.NewExecutorBuilder(tableInfo, math.MaxUint64).
+		SetChunks(halfSplitter{}, checkpoint).
+		Build()
+	c.Assert(err, IsNil)
+	resp, err = exe.Execute(ctx, s.mock.Storage.GetClient(), func() {})
+	c.Assert(err, IsNil)
+	// only the second chunk is executed.
+	c.Assert(resp.Checksum, Equals, uint64(7^1), Commentf("%v", resp))
+	c.Assert(resp.TotalKvs, Equals, uint64(11), Commentf("%v", resp))
+	c.Assert(resp.TotalBytes, Equals, uint64(101), Commentf("%v", resp))
+	// the checkpoint is removed once the checksum finishes.
+	exists, err := store.FileExists(ctx, name)
+	c.Assert(err, IsNil)
+	c.Assert(exists, IsFalse)
+}
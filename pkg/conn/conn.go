@@ -31,12 +31,11 @@ import (
 	"github.com/pingcap/br/pkg/glue"
 	"github.com/pingcap/br/pkg/logutil"
 	"github.com/pingcap/br/pkg/pdutil"
+	"github.com/pingcap/br/pkg/utils"
 	"github.com/pingcap/br/pkg/version"
 )
 
 const (
-	dialTimeout = 30 * time.Second
-
 	resetRetryTimes = 3
 )
 
@@ -110,6 +109,7 @@ type Mgr struct {
 		clis map[uint64]*grpc.ClientConn
 	}
 	keepalive   keepalive.ClientParameters
+	dialTimeout time.Duration
 	ownsStorage bool
 }
 
@@ -176,6 +176,7 @@ func NewMgr(
 	tlsConf *tls.Config,
 	securityOption pd.SecurityOption,
 	keepalive keepalive.ClientParameters,
+	timeout utils.TimeoutConfig,
 	storeBehavior StoreBehavior,
 	checkRequirements bool,
 	needDomain bool,
@@ -186,12 +187,15 @@ func NewMgr(
 		ctx = opentracing.ContextWithSpan(ctx, span1)
 	}
 
+	// fill the zero timeouts for the callers not starting from CLI.
+	timeout.Adjust()
+
 	tikvStorage, ok := storage.(tikv.Storage)
 	if !ok {
 		return nil, berrors.ErrKVNotTiKV
 	}
 
-	controller, err := pdutil.NewPdController(ctx, pdAddrs, tlsConf, securityOption)
+	controller, err := pdutil.NewPdController(ctx, pdAddrs, tlsConf, securityOption, timeout.PD)
 	if err != nil {
 		log.Error("fail to create pd controller", zap.Error(err))
 		return nil, errors.Trace(err)
@@ -237,6 +241,7 @@ func NewMgr(
 	}
 	mgr.grpcClis.clis = make(map[uint64]*grpc.ClientConn)
 	mgr.keepalive = keepalive
+	mgr.dialTimeout = timeout.Dial
 	return mgr, nil
 }
 
@@ -263,7 +268,7 @@ func (mgr *Mgr) getGrpcConnLocked(ctx context.Context, storeID uint64) (*grpc.Cl
 	if mgr.tlsConf != nil {
 		opt = grpc.WithTransportCredentials(credentials.NewTLS(mgr.tlsConf))
 	}
-	ctx, cancel := context.WithTimeout(ctx, dialTimeout)
+	ctx, cancel := context.WithTimeout(ctx, mgr.dialTimeout)
 	bfConf := backoff.DefaultConfig
 	bfConf.MaxDelay = time.Second * 3
 	addr := store.GetPeerAddress()
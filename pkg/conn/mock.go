@@ -0,0 +1,53 @@
+// Copyright 2021 PingCAP, Inc. Licensed under Apache-2.0.
+
+package conn
+
+import (
+	"github.com/pingcap/errors"
+	"github.com/pingcap/log"
+	"github.com/pingcap/tidb/session"
+	"github.com/pingcap/tidb/store/mockstore"
+	"github.com/tikv/client-go/v2/testutils"
+	"github.com/tikv/client-go/v2/tikv"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/keepalive"
+
+	"github.com/pingcap/br/pkg/pdutil"
+	"github.com/pingcap/br/pkg/utils"
+)
+
+// NewMockMgr creates a Mgr on an in-process mock cluster of a TiKV store and
+// PD, which lets the tasks run without a real cluster, e.g. in the CI. Only
+// the gRPC APIs of PD are available, and the mgr owns the mock cluster.
+func NewMockMgr(keepalive keepalive.ClientParameters, timeout utils.TimeoutConfig) (*Mgr, error) {
+	storage, err := mockstore.NewMockStore(
+		mockstore.WithClusterInspector(func(c testutils.Cluster) {
+			mockstore.BootstrapWithSingleStore(c)
+		}),
+	)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	session.SetSchemaLease(0)
+	session.DisableStats4Test()
+	dom, err := session.BootstrapSession(storage)
+	if err != nil {
+		storage.Close()
+		return nil, errors.Trace(err)
+	}
+	tikvStorage := storage.(tikv.Storage)
+	log.Info("new mgr on the mock cluster")
+
+	timeout.Adjust()
+	mgr := &Mgr{
+		PdController: pdutil.NewPdControllerWithClient(tikvStorage.GetRegionCache().PDClient()),
+		storage:      storage,
+		tikvStore:    tikvStorage,
+		dom:          dom,
+		ownsStorage:  true,
+	}
+	mgr.grpcClis.clis = make(map[uint64]*grpc.ClientConn)
+	mgr.keepalive = keepalive
+	mgr.dialTimeout = timeout.Dial
+	return mgr, nil
+}
@@ -15,6 +15,20 @@ func Is(err error, is *errors.Error) bool {
 	return errorFound != nil
 }
 
+// Code returns the RFC code of the first normalized error causing the error
+// `err`, e.g. "BR:Common:ErrInvalidArgument". It's the code of ErrUnknown if
+// there isn't any.
+func Code(err error) string {
+	errorFound := errors.Find(err, func(e error) bool {
+		_, ok := e.(*errors.Error)
+		return ok
+	})
+	if errorFound == nil {
+		return string(ErrUnknown.RFCCode())
+	}
+	return string(errorFound.(*errors.Error).RFCCode())
+}
+
 // BR errors.
 var (
 	ErrUnknown                   = errors.Normalize("internal error", errors.RFCCodeText("BR:Common:ErrUnknown"))
@@ -30,22 +44,35 @@ var (
 
 	ErrBackupChecksumMismatch    = errors.Normalize("backup checksum mismatch", errors.RFCCodeText("BR:Backup:ErrBackupChecksumMismatch"))
 	ErrBackupInvalidRange        = errors.Normalize("backup range invalid", errors.RFCCodeText("BR:Backup:ErrBackupInvalidRange"))
+	ErrBackupMissingFile         = errors.Normalize("backup data file missing", errors.RFCCodeText("BR:Backup:ErrBackupMissingFile"))
 	ErrBackupNoLeader            = errors.Normalize("backup no leader", errors.RFCCodeText("BR:Backup:ErrBackupNoLeader"))
 	ErrBackupGCSafepointExceeded = errors.Normalize("backup GC safepoint exceeded", errors.RFCCodeText("BR:Backup:ErrBackupGCSafepointExceeded"))
+	ErrBackupCheckFailed         = errors.Normalize("backup pre-flight check failed", errors.RFCCodeText("BR:Backup:ErrBackupCheckFailed"))
+	ErrBackupIncomplete          = errors.Normalize("backup incomplete", errors.RFCCodeText("BR:Backup:ErrBackupIncomplete"))
+	ErrBackupLocked              = errors.Normalize("backup locked by another task", errors.RFCCodeText("BR:Backup:ErrBackupLocked"))
+	ErrBackupFileCorrupted       = errors.Normalize("backup data file corrupted", errors.RFCCodeText("BR:Backup:ErrBackupFileCorrupted"))
 
-	ErrRestoreModeMismatch     = errors.Normalize("restore mode mismatch", errors.RFCCodeText("BR:Restore:ErrRestoreModeMismatch"))
-	ErrRestoreRangeMismatch    = errors.Normalize("restore range mismatch", errors.RFCCodeText("BR:Restore:ErrRestoreRangeMismatch"))
-	ErrRestoreChecksumMismatch = errors.Normalize("restore checksum mismatch", errors.RFCCodeText("BR:Restore:ErrRestoreChecksumMismatch"))
-	ErrRestoreTableIDMismatch  = errors.Normalize("restore table ID mismatch", errors.RFCCodeText("BR:Restore:ErrRestoreTableIDMismatch"))
-	ErrRestoreRejectStore      = errors.Normalize("failed to restore remove rejected store", errors.RFCCodeText("BR:Restore:ErrRestoreRejectStore"))
-	ErrRestoreNoPeer           = errors.Normalize("region does not have peer", errors.RFCCodeText("BR:Restore:ErrRestoreNoPeer"))
-	ErrRestoreSplitFailed      = errors.Normalize("fail to split region", errors.RFCCodeText("BR:Restore:ErrRestoreSplitFailed"))
-	ErrRestoreInvalidRewrite   = errors.Normalize("invalid rewrite rule", errors.RFCCodeText("BR:Restore:ErrRestoreInvalidRewrite"))
-	ErrRestoreInvalidBackup    = errors.Normalize("invalid backup", errors.RFCCodeText("BR:Restore:ErrRestoreInvalidBackup"))
-	ErrRestoreInvalidRange     = errors.Normalize("invalid restore range", errors.RFCCodeText("BR:Restore:ErrRestoreInvalidRange"))
-	ErrRestoreWriteAndIngest   = errors.Normalize("failed to write and ingest", errors.RFCCodeText("BR:Restore:ErrRestoreWriteAndIngest"))
-	ErrRestoreSchemaNotExists  = errors.Normalize("schema not exists", errors.RFCCodeText("BR:Restore:ErrRestoreSchemaNotExists"))
-	ErrUnsupportedSystemTable  = errors.Normalize("the system table isn't supported for restoring yet", errors.RFCCodeText("BR:Restore:ErrUnsupportedSysTable"))
+	ErrRestoreModeMismatch       = errors.Normalize("restore mode mismatch", errors.RFCCodeText("BR:Restore:ErrRestoreModeMismatch"))
+	ErrRestoreRangeMismatch      = errors.Normalize("restore range mismatch", errors.RFCCodeText("BR:Restore:ErrRestoreRangeMismatch"))
+	ErrRestoreChecksumMismatch   = errors.Normalize("restore checksum mismatch", errors.RFCCodeText("BR:Restore:ErrRestoreChecksumMismatch"))
+	ErrRestoreTableIDMismatch    = errors.Normalize("restore table ID mismatch", errors.RFCCodeText("BR:Restore:ErrRestoreTableIDMismatch"))
+	ErrRestoreRejectStore        = errors.Normalize("failed to restore remove rejected store", errors.RFCCodeText("BR:Restore:ErrRestoreRejectStore"))
+	ErrRestoreNoPeer             = errors.Normalize("region does not have peer", errors.RFCCodeText("BR:Restore:ErrRestoreNoPeer"))
+	ErrRestoreSplitFailed        = errors.Normalize("fail to split region", errors.RFCCodeText("BR:Restore:ErrRestoreSplitFailed"))
+	ErrRestoreInvalidRewrite     = errors.Normalize("invalid rewrite rule", errors.RFCCodeText("BR:Restore:ErrRestoreInvalidRewrite"))
+	ErrRestoreInvalidBackup      = errors.Normalize("invalid backup", errors.RFCCodeText("BR:Restore:ErrRestoreInvalidBackup"))
+	ErrRestoreInvalidRange       = errors.Normalize("invalid restore range", errors.RFCCodeText("BR:Restore:ErrRestoreInvalidRange"))
+	ErrRestoreWriteAndIngest     = errors.Normalize("failed to write and ingest", errors.RFCCodeText("BR:Restore:ErrRestoreWriteAndIngest"))
+	ErrRestoreSchemaNotExists    = errors.Normalize("schema not exists", errors.RFCCodeText("BR:Restore:ErrRestoreSchemaNotExists"))
+	ErrRestoreInvalidJournal     = errors.Normalize("invalid restore journal", errors.RFCCodeText("BR:Restore:ErrRestoreInvalidJournal"))
+	ErrRestoreMergeConflict      = errors.Normalize("conflict tables in schema merge", errors.RFCCodeText("BR:Restore:ErrRestoreMergeConflict"))
+	ErrRestoreShardHandle        = errors.Normalize("invalid shard handle", errors.RFCCodeText("BR:Restore:ErrRestoreShardHandle"))
+	ErrRestoreTableNotCancelable = errors.Normalize("table can't be canceled from the restore", errors.RFCCodeText("BR:Restore:ErrRestoreTableNotCancelable"))
+	ErrRestoreStuck              = errors.Normalize("restore is stuck without progress", errors.RFCCodeText("BR:Restore:ErrRestoreStuck"))
+	ErrRestoreNotConfirmed       = errors.Normalize("restore isn't confirmed", errors.RFCCodeText("BR:Restore:ErrRestoreNotConfirmed"))
+	ErrRestoreVerifyMismatch     = errors.Normalize("restored data mismatches the verification queries", errors.RFCCodeText("BR:Restore:ErrRestoreVerifyMismatch"))
+	ErrRestoreActiveChangefeed   = errors.Normalize("active changefeeds replicate the restored tables", errors.RFCCodeText("BR:Restore:ErrRestoreActiveChangefeed"))
+	ErrUnsupportedSystemTable    = errors.Normalize("the system table isn't supported for restoring yet", errors.RFCCodeText("BR:Restore:ErrUnsupportedSysTable"))
 
 	// TODO maybe it belongs to PiTR.
 	ErrRestoreRTsConstrain = errors.Normalize("resolved ts constrain violation", errors.RFCCodeText("BR:Restore:ErrRestoreResolvedTsConstrain"))
@@ -55,6 +82,8 @@ var (
 	ErrStorageUnknown           = errors.Normalize("unknown external storage error", errors.RFCCodeText("BR:ExternalStorage:ErrStorageUnknown"))
 	ErrStorageInvalidConfig     = errors.Normalize("invalid external storage config", errors.RFCCodeText("BR:ExternalStorage:ErrStorageInvalidConfig"))
 	ErrStorageInvalidPermission = errors.Normalize("external storage permission", errors.RFCCodeText("BR:ExternalStorage:ErrStorageInvalidPermission"))
+	ErrStorageChecksumMismatch  = errors.Normalize("checksum mismatch after uploading to external storage", errors.RFCCodeText("BR:ExternalStorage:ErrStorageChecksumMismatch"))
+	ErrStorageObjectArchived    = errors.Normalize("external storage object is archived", errors.RFCCodeText("BR:ExternalStorage:ErrStorageObjectArchived"))
 
 	// Errors reported from TiKV.
 	ErrKVStorage           = errors.Normalize("tikv storage occur I/O error", errors.RFCCodeText("BR:KV:ErrKVStorage"))
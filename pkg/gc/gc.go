@@ -0,0 +1,188 @@
+// Copyright 2021 PingCAP, Inc. Licensed under Apache-2.0.
+
+// Package gc expires the backups in a directory by the retention policies.
+package gc
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"sort"
+	"time"
+
+	"github.com/gogo/protobuf/proto"
+	"github.com/pingcap/errors"
+	backuppb "github.com/pingcap/kvproto/pkg/backup"
+	"github.com/pingcap/log"
+	"github.com/tikv/client-go/v2/oracle"
+	"go.uber.org/zap"
+
+	berrors "github.com/pingcap/br/pkg/errors"
+	"github.com/pingcap/br/pkg/metautil"
+	"github.com/pingcap/br/pkg/storage"
+)
+
+// Backup is a backup in the sub directory of the storage.
+type Backup struct {
+	// Dir is the sub directory of the backup.
+	Dir          string
+	StartVersion uint64
+	EndVersion   uint64
+	// Locked is whether the backup is under the object lock.
+	Locked bool
+}
+
+// IsIncremental returns whether the backup depends on a base backup.
+func (b *Backup) IsIncremental() bool {
+	return b.StartVersion > 0
+}
+
+// Time returns the time of the backup. It's zero if the backup has no end
+// version, e.g. the raw kv backups.
+func (b *Backup) Time() time.Time {
+	if b.EndVersion == 0 {
+		return time.Time{}
+	}
+	return oracle.GetTimeFromTS(b.EndVersion)
+}
+
+// ListBackups lists the backups in the direct sub directories of the storage,
+// and the ones laid out by the path templates recorded in its backup index.
+func ListBackups(ctx context.Context, s storage.ExternalStorage) ([]*Backup, error) {
+	var dirs []string
+	err := s.WalkDir(ctx, &storage.WalkOption{Glob: "*/" + metautil.MetaFile}, func(name string, _ int64) error {
+		dirs = append(dirs, path.Dir(name))
+		return nil
+	})
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	templated, err := listTemplatedBackups(ctx, s)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	listed := make(map[string]bool, len(dirs))
+	for _, dir := range dirs {
+		listed[dir] = true
+	}
+	for _, dir := range templated {
+		if !listed[dir] {
+			dirs = append(dirs, dir)
+		}
+	}
+	sort.Strings(dirs)
+
+	backups := make([]*Backup, 0, len(dirs))
+	for _, dir := range dirs {
+		name := path.Join(dir, metautil.MetaFile)
+		data, err := s.ReadFile(ctx, name)
+		if err != nil {
+			return nil, errors.Annotatef(err, "failed to read %s", name)
+		}
+		meta := &backuppb.BackupMeta{}
+		if err = proto.Unmarshal(data, meta); err != nil {
+			return nil, errors.Annotatef(berrors.ErrInvalidMetaFile, "failed to parse %s: %v", name, err)
+		}
+		lock, err := metautil.ReadRetentionIn(ctx, s, dir)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		backups = append(backups, &Backup{
+			Dir:          dir,
+			StartVersion: meta.StartVersion,
+			EndVersion:   meta.EndVersion,
+			Locked:       lock.IsLocked(time.Now()),
+		})
+	}
+	return backups, nil
+}
+
+// listTemplatedBackups lists the directories of the backups laid out by the
+// path templates in the backup index, the ones already deleted are skipped.
+func listTemplatedBackups(ctx context.Context, s storage.ExternalStorage) ([]string, error) {
+	index, err := metautil.ReadBackupIndex(ctx, s)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	var dirs []string
+	for _, entry := range index.Backups {
+		if entry.PathTemplate == "" {
+			continue
+		}
+		exists, err := s.FileExists(ctx, path.Join(entry.Name, metautil.MetaFile))
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		if exists {
+			dirs = append(dirs, entry.Name)
+		}
+	}
+	return dirs, nil
+}
+
+// Decision is whether a backup is expired by the policy.
+type Decision struct {
+	Backup  *Backup
+	Expired bool
+	// Reason is why the backup is kept.
+	Reason string
+}
+
+// Plan evaluates the policy over the backups at the time, the decisions are
+// sorted from the latest backup to the earliest one, which is the order to
+// delete them so the incremental backups are deleted before their bases.
+//
+// The base backups of the kept incremental backups are always kept, along
+// with the backups under the object lock and the ones without a time.
+func Plan(backups []*Backup, policy Policy, now time.Time) []*Decision {
+	decisions := make([]*Decision, 0, len(backups))
+	for _, b := range backups {
+		decisions = append(decisions, &Decision{Backup: b, Expired: true})
+	}
+	sort.SliceStable(decisions, func(i, j int) bool {
+		bi, bj := decisions[i].Backup, decisions[j].Backup
+		if bi.EndVersion != bj.EndVersion {
+			return bi.EndVersion > bj.EndVersion
+		}
+		return bi.Dir > bj.Dir
+	})
+
+	keep := func(d *Decision, reason string) {
+		if d.Expired {
+			d.Expired = false
+			d.Reason = reason
+		}
+	}
+	for i, d := range decisions {
+		switch {
+		case d.Backup.Locked:
+			keep(d, "locked")
+		case d.Backup.EndVersion == 0:
+			keep(d, "no timestamp")
+		case i < policy.KeepLast:
+			keep(d, fmt.Sprintf("last %d", policy.KeepLast))
+		case policy.KeepWithin > 0 && now.Sub(d.Backup.Time()) <= policy.KeepWithin:
+			keep(d, fmt.Sprintf("within %s", policy.KeepWithin))
+		}
+	}
+
+	// keep the incremental chains of the kept backups. Since the bases are
+	// earlier than the dependents, a single pass in order is enough.
+	for _, d := range decisions {
+		if d.Expired || !d.Backup.IsIncremental() {
+			continue
+		}
+		found := false
+		for _, base := range decisions {
+			if base.Backup.EndVersion == d.Backup.StartVersion {
+				keep(base, fmt.Sprintf("base of %s", d.Backup.Dir))
+				found = true
+			}
+		}
+		if !found {
+			log.Warn("the base of the incremental backup is not found",
+				zap.String("dir", d.Backup.Dir), zap.Uint64("start-version", d.Backup.StartVersion))
+		}
+	}
+	return decisions
+}
@@ -0,0 +1,124 @@
+// Copyright 2021 PingCAP, Inc. Licensed under Apache-2.0.
+
+package gc
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/gogo/protobuf/proto"
+	. "github.com/pingcap/check"
+	backuppb "github.com/pingcap/kvproto/pkg/backup"
+	"github.com/tikv/client-go/v2/oracle"
+
+	"github.com/pingcap/br/pkg/metautil"
+	"github.com/pingcap/br/pkg/storage"
+)
+
+func TestT(t *testing.T) {
+	TestingT(t)
+}
+
+var _ = Suite(&testGCSuite{})
+
+type testGCSuite struct{}
+
+func (s *testGCSuite) TestParsePolicy(c *C) {
+	policy, err := ParsePolicy("7, 30d")
+	c.Assert(err, IsNil)
+	c.Assert(policy, DeepEquals, Policy{KeepLast: 7, KeepWithin: 30 * 24 * time.Hour})
+	c.Assert(policy.String(), Equals, "last 7, within 720h0m0s")
+
+	policy, err = ParsePolicy("72h")
+	c.Assert(err, IsNil)
+	c.Assert(policy, DeepEquals, Policy{KeepWithin: 72 * time.Hour})
+
+	for _, invalid := range []string{"", "0", "-1d", "xd", "week"} {
+		_, err = ParsePolicy(invalid)
+		c.Assert(err, NotNil, Commentf("%s", invalid))
+	}
+}
+
+func (s *testGCSuite) TestPlan(c *C) {
+	now := time.Date(2021, 10, 1, 0, 0, 0, 0, time.UTC)
+	ts := func(daysAgo int) uint64 {
+		return oracle.ComposeTS(oracle.GetPhysical(now.Add(-time.Duration(daysAgo)*24*time.Hour)), 0)
+	}
+	// full1 <- inc1 <- inc2, full2 <- inc3, and a locked full0.
+	backups := []*Backup{
+		{Dir: "full0", EndVersion: ts(60), Locked: true},
+		{Dir: "full1", EndVersion: ts(50)},
+		{Dir: "inc1", StartVersion: ts(50), EndVersion: ts(40)},
+		{Dir: "inc2", StartVersion: ts(40), EndVersion: ts(20)},
+		{Dir: "full2", EndVersion: ts(10)},
+		{Dir: "inc3", StartVersion: ts(10), EndVersion: ts(5)},
+		{Dir: "raw"},
+	}
+
+	check := func(policy Policy, expected map[string]string) {
+		decisions := Plan(backups, policy, now)
+		c.Assert(decisions, HasLen, len(backups))
+		for i := 1; i < len(decisions); i++ {
+			c.Assert(decisions[i-1].Backup.EndVersion >= decisions[i].Backup.EndVersion, IsTrue)
+		}
+		for _, d := range decisions {
+			reason, kept := expected[d.Backup.Dir]
+			c.Assert(d.Expired, Equals, !kept, Commentf("%s", d.Backup.Dir))
+			c.Assert(d.Reason, Equals, reason, Commentf("%s", d.Backup.Dir))
+		}
+	}
+	check(Policy{KeepLast: 2}, map[string]string{
+		"full0": "locked",
+		"raw":   "no timestamp",
+		"inc3":  "last 2",
+		"full2": "last 2",
+	})
+	// the chain of inc2 is kept.
+	check(Policy{KeepWithin: 30 * 24 * time.Hour}, map[string]string{
+		"full0": "locked",
+		"raw":   "no timestamp",
+		"inc3":  "within 720h0m0s",
+		"full2": "within 720h0m0s",
+		"inc2":  "within 720h0m0s",
+		"inc1":  "base of inc2",
+		"full1": "base of inc1",
+	})
+}
+
+func (s *testGCSuite) TestListBackups(c *C) {
+	ctx := context.Background()
+	store, err := storage.NewLocalStorage(c.MkDir())
+	c.Assert(err, IsNil)
+	write := func(name string, meta *backuppb.BackupMeta) {
+		data, err := proto.Marshal(meta)
+		c.Assert(err, IsNil)
+		c.Assert(store.WriteFile(ctx, name, data), IsNil)
+	}
+	write("full/backupmeta", &backuppb.BackupMeta{EndVersion: 100})
+	write("inc/backupmeta", &backuppb.BackupMeta{StartVersion: 100, EndVersion: 200})
+	// the per-database backupmeta isn't a backup.
+	write("full/db1/backupmeta", &backuppb.BackupMeta{EndVersion: 100})
+	c.Assert(metautil.WriteRetention(ctx, store, &storage.ObjectLockOptions{LegalHold: true}), IsNil)
+	c.Assert(store.Rename(ctx, metautil.RetentionFile, "inc/"+metautil.RetentionFile), IsNil)
+
+	// the backups laid out by a path template are found by the backup index,
+	// the deleted ones are skipped.
+	write("6880/20211001/abc/backupmeta", &backuppb.BackupMeta{EndVersion: 300})
+	entries := []metautil.BackupIndexEntry{
+		{Name: "6880/20211001/abc", EndVersion: 300, PathTemplate: "{cluster_id}/{date}/{backup_id}"},
+		{Name: "6880/20210901/def", EndVersion: 50, PathTemplate: "{cluster_id}/{date}/{backup_id}"},
+		{Name: "inc", StartVersion: 100, EndVersion: 200},
+	}
+	for _, entry := range entries {
+		c.Assert(metautil.RecordBackupIndex(ctx, store, entry), IsNil)
+	}
+
+	backups, err := ListBackups(ctx, store)
+	c.Assert(err, IsNil)
+	c.Assert(backups, DeepEquals, []*Backup{
+		{Dir: "6880/20211001/abc", EndVersion: 300},
+		{Dir: "full", EndVersion: 100},
+		{Dir: "inc", StartVersion: 100, EndVersion: 200, Locked: true},
+	})
+}
@@ -0,0 +1,85 @@
+// Copyright 2021 PingCAP, Inc. Licensed under Apache-2.0.
+
+package gc
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pingcap/errors"
+
+	berrors "github.com/pingcap/br/pkg/errors"
+)
+
+// Policy is the retention policy of the backups. A backup is kept if any rule
+// of the policy keeps it.
+type Policy struct {
+	// KeepLast keeps the latest N backups.
+	KeepLast int
+	// KeepWithin keeps the backups taken within the duration.
+	KeepWithin time.Duration
+}
+
+// ParsePolicy parses the retention policy from a comma separated list of
+// rules. A number N keeps the latest N backups, and a duration such as "30d"
+// or "72h" keeps the backups taken within it, e.g. "7,30d".
+func ParsePolicy(s string) (Policy, error) {
+	var policy Policy
+	for _, rule := range strings.Split(s, ",") {
+		rule = strings.TrimSpace(rule)
+		if rule == "" {
+			continue
+		}
+		if n, err := strconv.Atoi(rule); err == nil {
+			if n <= 0 {
+				return Policy{}, errors.Annotatef(berrors.ErrInvalidArgument,
+					"the number of the backups to keep must be positive, got %d", n)
+			}
+			policy.KeepLast = n
+			continue
+		}
+		within, err := parseDuration(rule)
+		if err != nil {
+			return Policy{}, errors.Trace(err)
+		}
+		policy.KeepWithin = within
+	}
+	if policy.KeepLast == 0 && policy.KeepWithin == 0 {
+		return Policy{}, errors.Annotatef(berrors.ErrInvalidArgument,
+			"the retention policy %q keeps nothing, should be like '7', '30d' or '7,30d'", s)
+	}
+	return policy, nil
+}
+
+// parseDuration parses the duration supporting the days.
+func parseDuration(s string) (time.Duration, error) {
+	var (
+		d   time.Duration
+		err error
+	)
+	if days := strings.TrimSuffix(s, "d"); days != s {
+		var n int
+		n, err = strconv.Atoi(days)
+		d = time.Duration(n) * 24 * time.Hour
+	} else {
+		d, err = time.ParseDuration(s)
+	}
+	if err != nil || d <= 0 {
+		return 0, errors.Annotatef(berrors.ErrInvalidArgument,
+			"invalid retention rule %q, should be a positive number or duration", s)
+	}
+	return d, nil
+}
+
+func (p Policy) String() string {
+	rules := make([]string, 0, 2)
+	if p.KeepLast > 0 {
+		rules = append(rules, fmt.Sprintf("last %d", p.KeepLast))
+	}
+	if p.KeepWithin > 0 {
+		rules = append(rules, fmt.Sprintf("within %s", p.KeepWithin))
+	}
+	return strings.Join(rules, ", ")
+}
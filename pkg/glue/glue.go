@@ -33,11 +33,25 @@ type Glue interface {
 // Session is an abstraction of the session.Session interface.
 type Session interface {
 	Execute(ctx context.Context, sql string) error
+	// ExecuteBatch executes the statements in order and stops at the first
+	// error. The statements are parsed at once if possible to save the
+	// overhead of executing them one by one.
+	ExecuteBatch(ctx context.Context, stmts []string) error
 	CreateDatabase(ctx context.Context, schema *model.DBInfo) error
 	CreateTable(ctx context.Context, dbName model.CIStr, table *model.TableInfo) error
 	Close()
 }
 
+// QuerySession is a Session returning the rows of the queries. It's optional,
+// the features needing the rows, e.g. the verification queries, are
+// unavailable if the session doesn't implement it.
+type QuerySession interface {
+	Session
+	// Query executes the query and returns the rows with the values in the
+	// string form.
+	Query(ctx context.Context, sql string) ([][]string, error)
+}
+
 // Progress is an interface recording the current execution progress.
 type Progress interface {
 	// Inc increases the progress. This method must be goroutine-safe, and can
@@ -5,6 +5,7 @@ package gluetidb
 import (
 	"bytes"
 	"context"
+	"strings"
 
 	"github.com/pingcap/errors"
 	"github.com/pingcap/log"
@@ -19,6 +20,7 @@ import (
 	"github.com/pingcap/tidb/session"
 	"github.com/pingcap/tidb/sessionctx"
 	pd "github.com/tikv/pd/client"
+	"go.uber.org/zap"
 
 	"github.com/pingcap/br/pkg/glue"
 	"github.com/pingcap/br/pkg/gluetikv"
@@ -109,6 +111,61 @@ func (gs *tidbSession) Execute(ctx context.Context, sql string) error {
 	return errors.Trace(err)
 }
 
+// Query implements glue.QuerySession.
+func (gs *tidbSession) Query(ctx context.Context, sql string) ([][]string, error) {
+	rs, err := gs.se.ExecuteInternal(ctx, sql)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	if rs == nil {
+		return nil, nil
+	}
+	defer rs.Close()
+	rows, err := session.ResultSetToStringSlice(ctx, gs.se, rs)
+	return rows, errors.Trace(err)
+}
+
+// ExecuteBatch implements glue.Session.
+func (gs *tidbSession) ExecuteBatch(ctx context.Context, stmts []string) error {
+	if len(stmts) <= 1 {
+		return gs.executeSerially(ctx, stmts)
+	}
+	// nothing is executed if the batch fails to be parsed, so it's safe to
+	// fall back to executing the statements one by one, which also reports the
+	// statement failed to be parsed.
+	var batch strings.Builder
+	for _, sql := range stmts {
+		// the statements may end with semicolons.
+		batch.WriteString(strings.TrimRight(strings.TrimSpace(sql), ";"))
+		batch.WriteString(";\n")
+	}
+	nodes, err := gs.se.Parse(ctx, batch.String())
+	if err != nil {
+		log.Debug("failed to parse the statements in batch, executing them one by one",
+			zap.Int("count", len(stmts)), zap.Error(err))
+		return gs.executeSerially(ctx, stmts)
+	}
+	for _, node := range nodes {
+		rs, err := gs.se.ExecuteStmt(ctx, node)
+		if rs != nil {
+			_ = rs.Close()
+		}
+		if err != nil {
+			return errors.Annotatef(err, "failed to execute %s", node.Text())
+		}
+	}
+	return nil
+}
+
+func (gs *tidbSession) executeSerially(ctx context.Context, stmts []string) error {
+	for _, sql := range stmts {
+		if err := gs.Execute(ctx, sql); err != nil {
+			return errors.Trace(err)
+		}
+	}
+	return nil
+}
+
 // CreateDatabase implements glue.Session.
 func (gs *tidbSession) CreateDatabase(ctx context.Context, schema *model.DBInfo) error {
 	d := domain.GetDomain(gs.se).DDL()
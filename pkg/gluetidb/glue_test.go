@@ -0,0 +1,76 @@
+// Copyright 2021 PingCAP, Inc. Licensed under Apache-2.0.
+
+package gluetidb_test
+
+import (
+	"context"
+	"testing"
+
+	. "github.com/pingcap/check"
+	"github.com/pingcap/tidb/util/testkit"
+	"github.com/pingcap/tidb/util/testleak"
+
+	"github.com/pingcap/br/pkg/gluetidb"
+	"github.com/pingcap/br/pkg/mock"
+)
+
+func TestT(t *testing.T) {
+	TestingT(t)
+}
+
+var _ = Suite(&testGlueSuite{})
+
+type testGlueSuite struct {
+	mock *mock.Cluster
+}
+
+func (s *testGlueSuite) SetUpSuite(c *C) {
+	var err error
+	s.mock, err = mock.NewCluster()
+	c.Assert(err, IsNil)
+	c.Assert(s.mock.Start(), IsNil)
+}
+
+func (s *testGlueSuite) TearDownSuite(c *C) {
+	s.mock.Stop()
+	testleak.AfterTest(c)()
+}
+
+func (s *testGlueSuite) TestExecuteBatch(c *C) {
+	ctx := context.Background()
+	se, err := gluetidb.New().CreateSession(s.mock.Storage)
+	c.Assert(err, IsNil)
+	defer se.Close()
+	tk := testkit.NewTestKit(c, s.mock.Storage)
+
+	// the statements are parsed at once, with or without the semicolons.
+	err = se.ExecuteBatch(ctx, []string{
+		"create table test.batch (a int);",
+		"insert into test.batch values (1)",
+		" insert into test.batch values (2); ",
+	})
+	c.Assert(err, IsNil)
+	tk.MustQuery("select count(*) from test.batch").Check(testkit.Rows("2"))
+
+	// the batch stops at the first statement failed.
+	err = se.ExecuteBatch(ctx, []string{
+		"insert into test.batch values (3)",
+		"insert into test.missing values (1)",
+		"insert into test.batch values (4)",
+	})
+	c.Assert(err, ErrorMatches, "failed to execute insert into test.missing.*")
+	tk.MustQuery("select count(*) from test.batch").Check(testkit.Rows("3"))
+
+	// the batch failed to parse is executed one by one until the statement
+	// failed to parse.
+	err = se.ExecuteBatch(ctx, []string{
+		"create table test.serial (a int)",
+		"insert into test.serial values (1)",
+		"not a statement",
+		"insert into test.serial values (2)",
+	})
+	c.Assert(err, ErrorMatches, ".*You have an error in your SQL syntax.*")
+	tk.MustQuery("select count(*) from test.serial").Check(testkit.Rows("1"))
+
+	c.Assert(se.ExecuteBatch(ctx, nil), IsNil)
+}
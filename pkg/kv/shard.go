@@ -0,0 +1,59 @@
+// Copyright 2021 PingCAP, Inc. Licensed under Apache-2.0.
+
+package kv
+
+import (
+	"github.com/pingcap/errors"
+	"github.com/pingcap/parser/model"
+
+	berrors "github.com/pingcap/br/pkg/errors"
+)
+
+// DefaultShardHandleBits is the default number of bits reserved for the shard
+// ID in the handles.
+const DefaultShardHandleBits = 8
+
+// ShardHandle rewrites the integer handles of the rows from a shard table, so
+// the rows of many shards merged into a single table don't collide.
+//
+// The shard ID replaces the shard bits of an auto_random primary key, or
+// occupies the highest `Bits` bits below the sign bit of the other handles,
+// i.e. the auto-increment primary keys and the `_tidb_rowid`.
+type ShardHandle struct {
+	// ShardID is the ID of the shard, unique among the shards merged into the
+	// same table.
+	ShardID int64
+	// Bits is the number of bits reserved for the shard ID in the handles
+	// other than auto_random.
+	Bits uint64
+}
+
+// Rewrite rewrites a handle of the table.
+func (s *ShardHandle) Rewrite(tbl *model.TableInfo, handle int64) (int64, error) {
+	if tbl.IsCommonHandle {
+		return 0, errors.Annotatef(berrors.ErrRestoreShardHandle,
+			"table %s has a clustered index which isn't integer", tbl.Name)
+	}
+	isAutoRandom := tbl.PKIsHandle && tbl.ContainsAutoRandomBits()
+	bits := s.Bits
+	if isAutoRandom {
+		bits = tbl.AutoRandomBits
+	}
+	if bits == 0 || bits > 62 {
+		return 0, errors.Annotatef(berrors.ErrRestoreShardHandle, "invalid shard bits %d of table %s", bits, tbl.Name)
+	}
+	if s.ShardID < 0 || s.ShardID >= 1<<bits {
+		return 0, errors.Annotatef(berrors.ErrRestoreShardHandle,
+			"shard id %d of table %s doesn't fit in %d bits", s.ShardID, tbl.Name, bits)
+	}
+
+	incrementalBits := 63 - bits
+	mask := int64(1)<<incrementalBits - 1
+	// the shard bits of auto_random are replaced, so only the other handles
+	// may overflow.
+	if !isAutoRandom && (handle < 0 || handle > mask) {
+		return 0, errors.Annotatef(berrors.ErrRestoreShardHandle,
+			"handle %d of table %s overflows %d bits", handle, tbl.Name, incrementalBits)
+	}
+	return s.ShardID<<incrementalBits | handle&mask, nil
+}
@@ -0,0 +1,40 @@
+// Copyright 2021 PingCAP, Inc. Licensed under Apache-2.0.
+
+package kv
+
+import (
+	. "github.com/pingcap/check"
+	"github.com/pingcap/parser/model"
+)
+
+type shardHandleSuite struct{}
+
+var _ = Suite(&shardHandleSuite{})
+
+func (s *shardHandleSuite) TestRewrite(c *C) {
+	tbl := &model.TableInfo{Name: model.NewCIStr("t")}
+	shard := &ShardHandle{ShardID: 3, Bits: 8}
+
+	handle, err := shard.Rewrite(tbl, 42)
+	c.Assert(err, IsNil)
+	c.Assert(handle, Equals, int64(3)<<55|42)
+
+	// the handles using the shard bits overflow.
+	_, err = shard.Rewrite(tbl, int64(1)<<55)
+	c.Assert(err, ErrorMatches, ".*handle 36028797018963968 of table t overflows 55 bits.*")
+	_, err = shard.Rewrite(tbl, -1)
+	c.Assert(err, ErrorMatches, ".*overflows 55 bits.*")
+
+	// the shard bits of auto_random are replaced.
+	tbl.PKIsHandle = true
+	tbl.AutoRandomBits = 5
+	handle, err = shard.Rewrite(tbl, int64(31)<<58|42)
+	c.Assert(err, IsNil)
+	c.Assert(handle, Equals, int64(3)<<58|42)
+	_, err = (&ShardHandle{ShardID: 32, Bits: 8}).Rewrite(tbl, 42)
+	c.Assert(err, ErrorMatches, ".*shard id 32 of table t doesn't fit in 5 bits.*")
+
+	tbl.IsCommonHandle = true
+	_, err = shard.Rewrite(tbl, 42)
+	c.Assert(err, ErrorMatches, ".*clustered index.*")
+}
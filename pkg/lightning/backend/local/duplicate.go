@@ -45,6 +45,7 @@ import (
 	"github.com/pingcap/br/pkg/lightning/common"
 	"github.com/pingcap/br/pkg/lightning/log"
 	"github.com/pingcap/br/pkg/logutil"
+	"github.com/pingcap/br/pkg/regionutil"
 	"github.com/pingcap/br/pkg/restore"
 )
 
@@ -123,7 +124,7 @@ func (manager *DuplicateManager) sendRequestToTiKV(ctx context.Context,
 	startKey := codec.EncodeBytes([]byte{}, req.start)
 	endKey := codec.EncodeBytes([]byte{}, req.end)
 
-	regions, err := paginateScanRegion(ctx, manager.splitCli, startKey, endKey, scanRegionLimit)
+	regions, err := regionutil.PaginateScanRegion(ctx, manager.splitCli, startKey, endKey, scanRegionLimit)
 	if err != nil {
 		return err
 	}
@@ -214,7 +215,7 @@ func (manager *DuplicateManager) sendRequestToTiKV(ctx context.Context,
 						logutil.Region(region.Region), logutil.Leader(region.Leader),
 						zap.String("RegionError", resp.GetRegionError().GetMessage()))
 
-					r, err := paginateScanRegion(ctx, manager.splitCli, watingRegions[idx].Region.GetStartKey(), watingRegions[idx].Region.GetEndKey(), scanRegionLimit)
+					r, err := regionutil.PaginateScanRegion(ctx, manager.splitCli, watingRegions[idx].Region.GetStartKey(), watingRegions[idx].Region.GetEndKey(), scanRegionLimit)
 					if err != nil {
 						unfinishedRegions = append(unfinishedRegions, watingRegions[idx])
 					} else {
@@ -395,7 +396,7 @@ func (manager *DuplicateManager) getValues(
 	l := len(handles)
 	startKey := codec.EncodeBytes([]byte{}, handles[0])
 	endKey := codec.EncodeBytes([]byte{}, nextKey(handles[l-1]))
-	regions, err := paginateScanRegion(ctx, manager.splitCli, startKey, endKey, scanRegionLimit)
+	regions, err := regionutil.PaginateScanRegion(ctx, manager.splitCli, startKey, endKey, scanRegionLimit)
 	if err != nil {
 		log.L().Error("scan regions errors", zap.Error(err))
 		return handles
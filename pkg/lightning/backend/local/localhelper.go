@@ -17,7 +17,6 @@ import (
 	"bytes"
 	"context"
 	"database/sql"
-	"regexp"
 	"runtime"
 	"sort"
 	"strings"
@@ -28,7 +27,6 @@ import (
 	"github.com/pingcap/errors"
 	sst "github.com/pingcap/kvproto/pkg/import_sstpb"
 	"github.com/pingcap/kvproto/pkg/metapb"
-	"github.com/pingcap/kvproto/pkg/pdpb"
 	"github.com/pingcap/tidb/util/codec"
 	"go.uber.org/multierr"
 	"go.uber.org/zap"
@@ -38,6 +36,7 @@ import (
 	"github.com/pingcap/br/pkg/lightning/common"
 	"github.com/pingcap/br/pkg/lightning/log"
 	"github.com/pingcap/br/pkg/logutil"
+	"github.com/pingcap/br/pkg/regionutil"
 	split "github.com/pingcap/br/pkg/restore"
 	"github.com/pingcap/br/pkg/utils"
 )
@@ -56,6 +55,17 @@ var (
 	// the base exponential backoff time
 	// the variable is only changed in unit test for running test faster.
 	splitRegionBaseBackOffTime = time.Second
+
+	splitWaitPolicy = regionutil.RetryPolicy{
+		MaxAttempts: split.SplitCheckMaxRetryTimes,
+		BaseBackoff: time.Second,
+		MaxBackoff:  time.Second,
+	}
+	scatterWaitPolicy = regionutil.RetryPolicy{
+		MaxAttempts: split.ScatterWaitMaxRetryTimes,
+		BaseBackoff: time.Second,
+		MaxBackoff:  time.Second,
+	}
 )
 
 // TODO remove this file and use br internal functions
@@ -103,7 +113,7 @@ func (local *local) SplitAndScatterRegionByRanges(
 			}
 		}
 		var regions []*split.RegionInfo
-		regions, err = paginateScanRegion(ctx, local.splitCli, minKey, maxKey, 128)
+		regions, err = regionutil.PaginateScanRegion(ctx, local.splitCli, minKey, maxKey, 128)
 		log.L().Info("paginate scan regions", zap.Int("count", len(regions)),
 			logutil.Key("start", minKey), logutil.Key("end", maxKey))
 		if err != nil {
@@ -314,7 +324,7 @@ func (local *local) SplitAndScatterRegionByRanges(
 	startTime := time.Now()
 	scatterCount := 0
 	for _, region := range scatterRegions {
-		local.waitForScatterRegion(ctx, region)
+		regionutil.WaitForScatterRegion(ctx, local.splitCli, region, scatterWaitPolicy)
 		if time.Since(startTime) > split.ScatterWaitUpperInterval {
 			break
 		}
@@ -363,40 +373,6 @@ func fetchTableRegionSizeStats(ctx context.Context, db *sql.DB, tableID int64) (
 	return stats, errors.Trace(err)
 }
 
-func paginateScanRegion(
-	ctx context.Context, client split.SplitClient, startKey, endKey []byte, limit int,
-) ([]*split.RegionInfo, error) {
-	if len(endKey) != 0 && bytes.Compare(startKey, endKey) >= 0 {
-		log.L().Error("startKey >= endKey when paginating scan region",
-			logutil.Key("startKey", startKey),
-			logutil.Key("endKey", endKey))
-		return nil, errors.Errorf("startKey >= endKey when paginating scan region")
-	}
-
-	var regions []*split.RegionInfo
-	for {
-		batch, err := client.ScanRegions(ctx, startKey, endKey, limit)
-		if err != nil {
-			return nil, errors.Trace(err)
-		}
-		regions = append(regions, batch...)
-		if len(batch) < limit {
-			// No more region
-			break
-		}
-		startKey = batch[len(batch)-1].Region.GetEndKey()
-		if len(startKey) == 0 ||
-			(len(endKey) > 0 && bytes.Compare(startKey, endKey) >= 0) {
-			// All key space have scanned
-			break
-		}
-	}
-	sort.Slice(regions, func(i, j int) bool {
-		return bytes.Compare(regions[i].Region.StartKey, regions[j].Region.StartKey) < 0
-	})
-	return regions, nil
-}
-
 func (local *local) BatchSplitRegions(ctx context.Context, region *split.RegionInfo, keys [][]byte) (*split.RegionInfo, []*split.RegionInfo, error) {
 	region, newRegions, err := local.splitCli.BatchSplitRegionsWithOrigin(ctx, region, keys)
 	if err != nil {
@@ -409,7 +385,7 @@ func (local *local) BatchSplitRegions(ctx context.Context, region *split.RegionI
 	for i := 0; i < maxRetryTimes; i++ {
 		for _, region := range scatterRegions {
 			// Wait for a while until the regions successfully splits.
-			local.waitForSplit(ctx, region.Region.Id)
+			regionutil.WaitForSplit(ctx, local.splitCli, region.Region.Id, splitWaitPolicy)
 			if err = local.splitCli.ScatterRegion(ctx, region); err != nil {
 				failedErr = err
 				retryRegions = append(retryRegions, region)
@@ -435,76 +411,6 @@ func (local *local) BatchSplitRegions(ctx context.Context, region *split.RegionI
 	return region, newRegions, nil
 }
 
-func (local *local) hasRegion(ctx context.Context, regionID uint64) (bool, error) {
-	regionInfo, err := local.splitCli.GetRegionByID(ctx, regionID)
-	if err != nil {
-		return false, err
-	}
-	return regionInfo != nil, nil
-}
-
-func (local *local) waitForSplit(ctx context.Context, regionID uint64) {
-	for i := 0; i < split.SplitCheckMaxRetryTimes; i++ {
-		ok, err := local.hasRegion(ctx, regionID)
-		if err != nil {
-			log.L().Info("wait for split failed", log.ShortError(err))
-			return
-		}
-		if ok {
-			break
-		}
-		select {
-		case <-time.After(time.Second):
-		case <-ctx.Done():
-			return
-		}
-	}
-}
-
-func (local *local) waitForScatterRegion(ctx context.Context, regionInfo *split.RegionInfo) {
-	regionID := regionInfo.Region.GetId()
-	for i := 0; i < split.ScatterWaitMaxRetryTimes; i++ {
-		ok, err := local.isScatterRegionFinished(ctx, regionID)
-		if err != nil {
-			log.L().Warn("scatter region failed: do not have the region",
-				logutil.Region(regionInfo.Region))
-			return
-		}
-		if ok {
-			break
-		}
-		select {
-		case <-time.After(time.Second):
-		case <-ctx.Done():
-			return
-		}
-	}
-}
-
-func (local *local) isScatterRegionFinished(ctx context.Context, regionID uint64) (bool, error) {
-	resp, err := local.splitCli.GetOperator(ctx, regionID)
-	if err != nil {
-		return false, err
-	}
-	// Heartbeat may not be sent to PD
-	if respErr := resp.GetHeader().GetError(); respErr != nil {
-		if respErr.GetType() == pdpb.ErrorType_REGION_NOT_FOUND {
-			return true, nil
-		}
-		// don't return error if region replicate not complete
-		// TODO: should add a new error type to avoid this check by string matching
-		matches, _ := regexp.MatchString("region \\d+ is not fully replicated", respErr.Message)
-		if matches {
-			return false, nil
-		}
-		return false, errors.Errorf("get operator error: %s", respErr.GetType())
-	}
-	// If the current operator of the region is not 'scatter-region', we could assume
-	// that 'scatter-operator' has finished or timeout
-	ok := string(resp.GetDesc()) != "scatter-region" || resp.GetStatus() != pdpb.OperatorStatus_RUNNING
-	return ok, nil
-}
-
 func getSplitKeysByRanges(ranges []Range, regions []*split.RegionInfo) map[uint64][][]byte {
 	checkKeys := make([][]byte, 0)
 	var lastEnd []byte
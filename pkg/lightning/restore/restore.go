@@ -1785,7 +1785,7 @@ func (rc *Controller) preCheckRequirements(ctx context.Context) error {
 		}
 
 		pdController, err := pdutil.NewPdController(ctx, rc.cfg.TiDB.PdAddr,
-			rc.tls.TLSConfig(), rc.tls.ToPDSecurityOption())
+			rc.tls.TLSConfig(), rc.tls.ToPDSecurityOption(), utils.DefaultPDTimeout)
 		if err != nil {
 			return errors.Trace(err)
 		}
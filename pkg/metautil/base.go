@@ -0,0 +1,138 @@
+// Copyright 2021 PingCAP, Inc. Licensed under Apache-2.0.
+
+package metautil
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/pingcap/errors"
+	backuppb "github.com/pingcap/kvproto/pkg/backup"
+	"github.com/pingcap/log"
+	"go.uber.org/zap"
+
+	berrors "github.com/pingcap/br/pkg/errors"
+	"github.com/pingcap/br/pkg/storage"
+)
+
+// BaseBackupFile is the name of the file of the data only incremental backup,
+// which references the base backup holding the schemas of the tables not
+// changed since it.
+const BaseBackupFile = "base_backup.json"
+
+// BaseBackup is the base backup of a data only incremental backup.
+type BaseBackup struct {
+	// Name is the name of the base backup in the parent directory, the same
+	// as its name in the backup index.
+	Name       string `json:"name"`
+	EndVersion uint64 `json:"end-version"`
+	// Depth is the number of the directories between the incremental backup
+	// and the directory of the backup index, which is the depth of the path
+	// template laying out the backup. 0 means 1, i.e. the parent directory.
+	Depth int `json:"depth,omitempty"`
+	// TableIDs are the IDs of all tables of the incremental backup. The
+	// schemas of the tables not in its backupmeta are read from the base
+	// backup, and the tables dropped since the base backup are skipped.
+	TableIDs []int64 `json:"table-ids"`
+}
+
+// WriteBaseBackup writes the reference to the base backup to the storage.
+func WriteBaseBackup(ctx context.Context, s storage.ExternalStorage, base *BaseBackup) error {
+	data, err := json.Marshal(base)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if err = s.WriteFile(ctx, BaseBackupFile, data); err != nil {
+		return errors.Trace(err)
+	}
+	log.Info("base backup recorded", zap.String("name", base.Name),
+		zap.Uint64("end-version", base.EndVersion), zap.Int("tables", len(base.TableIDs)))
+	return nil
+}
+
+// ReadBaseBackup reads the reference to the base backup from the storage. It
+// returns nil if the backup has all schemas in its backupmeta.
+func ReadBaseBackup(ctx context.Context, s storage.ExternalStorage) (*BaseBackup, error) {
+	exists, err := s.FileExists(ctx, BaseBackupFile)
+	if err != nil || !exists {
+		return nil, errors.Trace(err)
+	}
+	data, err := s.ReadFile(ctx, BaseBackupFile)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	base := &BaseBackup{}
+	if err = json.Unmarshal(data, base); err != nil {
+		return nil, errors.Annotatef(berrors.ErrInvalidMetaFile, "failed to parse %s: %v", BaseBackupFile, err)
+	}
+	return base, nil
+}
+
+// SetBase sets the reader of the base backup, and the schemas of the tables
+// which aren't in the backupmeta but in tableIDs are read from it. The base
+// may have its own base.
+func (reader *MetaReader) SetBase(base *MetaReader, tableIDs []int64) {
+	reader.base = base
+	reader.baseTables = make(map[int64]struct{}, len(tableIDs))
+	for _, id := range tableIDs {
+		reader.baseTables[id] = struct{}{}
+	}
+}
+
+// readSchemasWithBase reads the schemas of the backupmeta, then the schemas of
+// the other tables from the base backup. The checksums and the statistics of
+// the base schemas are dropped, since the data of the tables is changed.
+func (reader *MetaReader) readSchemasWithBase(ctx context.Context, output func(*backuppb.Schema)) error {
+	own := make(map[int64]struct{})
+	var decodeErr error
+	err := reader.readOwnSchemas(ctx, func(s *backuppb.Schema) {
+		id, err := schemaTableID(s)
+		if err != nil && decodeErr == nil {
+			decodeErr = err
+		}
+		own[id] = struct{}{}
+		output(s)
+	})
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if decodeErr != nil {
+		return errors.Trace(decodeErr)
+	}
+
+	err = reader.base.readSchemas(ctx, func(s *backuppb.Schema) {
+		id, err := schemaTableID(s)
+		if err != nil {
+			if decodeErr == nil {
+				decodeErr = err
+			}
+			return
+		}
+		if _, ok := own[id]; ok {
+			return
+		}
+		if _, ok := reader.baseTables[id]; !ok {
+			return
+		}
+		output(&backuppb.Schema{
+			Db:              s.Db,
+			Table:           s.Table,
+			TiflashReplicas: s.TiflashReplicas,
+		})
+	})
+	if err != nil {
+		return errors.Trace(err)
+	}
+	return errors.Trace(decodeErr)
+}
+
+// schemaTableID decodes the ID of the table of the schema.
+func schemaTableID(s *backuppb.Schema) (int64, error) {
+	var table struct {
+		ID int64 `json:"id"`
+	}
+	if err := json.Unmarshal(s.Table, &table); err != nil {
+		return 0, errors.Annotatef(berrors.ErrInvalidMetaFile, "failed to parse the table of schema: %v", err)
+	}
+	return table.ID, nil
+}
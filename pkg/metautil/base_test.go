@@ -0,0 +1,74 @@
+// Copyright 2021 PingCAP, Inc. Licensed under Apache-2.0.
+
+package metautil
+
+import (
+	"context"
+	"fmt"
+
+	. "github.com/pingcap/check"
+	backuppb "github.com/pingcap/kvproto/pkg/backup"
+
+	"github.com/pingcap/br/pkg/storage"
+)
+
+func testSchema(id int64, name string, checksum uint64) *backuppb.Schema {
+	return &backuppb.Schema{
+		Db:       []byte(`{"id":1,"db_name":{"O":"test","L":"test"}}`),
+		Table:    []byte(fmt.Sprintf(`{"id":%d,"name":{"O":"%s","L":"%s"}}`, id, name, name)),
+		Crc64Xor: checksum,
+		TotalKvs: checksum,
+		Stats:    []byte("{}"),
+	}
+}
+
+func (m *metaSuit) TestBaseBackup(c *C) {
+	ctx := context.Background()
+	s, err := storage.NewLocalStorage(c.MkDir())
+	c.Assert(err, IsNil)
+
+	base, err := ReadBaseBackup(ctx, s)
+	c.Assert(err, IsNil)
+	c.Assert(base, IsNil)
+
+	expected := &BaseBackup{Name: "full", EndVersion: 100, TableIDs: []int64{10, 11}}
+	c.Assert(WriteBaseBackup(ctx, s, expected), IsNil)
+	base, err = ReadBaseBackup(ctx, s)
+	c.Assert(err, IsNil)
+	c.Assert(base, DeepEquals, expected)
+
+	c.Assert(s.WriteFile(ctx, BaseBackupFile, []byte("{")), IsNil)
+	_, err = ReadBaseBackup(ctx, s)
+	c.Assert(err, ErrorMatches, ".*failed to parse.*")
+}
+
+func (m *metaSuit) TestReadSchemasWithBase(c *C) {
+	ctx := context.Background()
+	full := NewMetaReader(&backuppb.BackupMeta{Schemas: []*backuppb.Schema{
+		testSchema(10, "t1", 1), testSchema(11, "t2", 2), testSchema(12, "t3", 3),
+	}}, nil)
+	// t1 is altered, t3 is dropped and t4 is created since the full backup.
+	inc1 := NewMetaReader(&backuppb.BackupMeta{Schemas: []*backuppb.Schema{
+		testSchema(10, "t1_new", 0), testSchema(13, "t4", 0),
+	}}, nil)
+	inc1.SetBase(full, []int64{10, 11, 13})
+	// nothing is changed since the first incremental backup.
+	inc2 := NewMetaReader(&backuppb.BackupMeta{}, nil)
+	inc2.SetBase(inc1, []int64{10, 11, 13})
+
+	var schemas []*backuppb.Schema
+	c.Assert(inc2.readSchemas(ctx, func(s *backuppb.Schema) { schemas = append(schemas, s) }), IsNil)
+	c.Assert(schemas, HasLen, 3)
+	names := make(map[string]*backuppb.Schema)
+	for _, s := range schemas {
+		names[string(s.Table)] = s
+	}
+	c.Assert(names, HasKey, `{"id":10,"name":{"O":"t1_new","L":"t1_new"}}`)
+	c.Assert(names, HasKey, `{"id":13,"name":{"O":"t4","L":"t4"}}`)
+	t2 := names[`{"id":11,"name":{"O":"t2","L":"t2"}}`]
+	c.Assert(t2, NotNil)
+	// the checksum and the stats of the base backup don't match the data.
+	c.Assert(t2.Crc64Xor, Equals, uint64(0))
+	c.Assert(t2.TotalKvs, Equals, uint64(0))
+	c.Assert(t2.Stats, IsNil)
+}
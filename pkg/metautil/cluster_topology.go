@@ -0,0 +1,82 @@
+// Copyright 2021 PingCAP, Inc. Licensed under Apache-2.0.
+
+package metautil
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/pingcap/errors"
+	"github.com/pingcap/log"
+	"go.uber.org/zap"
+
+	berrors "github.com/pingcap/br/pkg/errors"
+	"github.com/pingcap/br/pkg/storage"
+)
+
+// ClusterTopologyFile is the name of the file recording the stores and the
+// versions of the cluster at backup time.
+const ClusterTopologyFile = "cluster_topology.json"
+
+// StoreTopology is a store of the cluster at backup time.
+type StoreTopology struct {
+	ID      uint64            `json:"id"`
+	Address string            `json:"address"`
+	Version string            `json:"version"`
+	Labels  map[string]string `json:"labels,omitempty"`
+	TiFlash bool              `json:"tiflash,omitempty"`
+	// RegionCount is the number of the region peers on the store.
+	RegionCount int `json:"region-count"`
+}
+
+// ClusterTopology is the snapshot of the cluster at backup time, which is
+// compared with the cluster to restore.
+type ClusterTopology struct {
+	PDVersion      string          `json:"pd-version"`
+	ClusterVersion string          `json:"cluster-version"`
+	RegionCount    int             `json:"region-count"`
+	Stores         []StoreTopology `json:"stores"`
+}
+
+// TiKVStores returns the number of the TiKV stores.
+func (t *ClusterTopology) TiKVStores() int {
+	n := 0
+	for _, store := range t.Stores {
+		if !store.TiFlash {
+			n++
+		}
+	}
+	return n
+}
+
+// WriteClusterTopology writes the cluster topology to the storage.
+func WriteClusterTopology(ctx context.Context, s storage.ExternalStorage, topology *ClusterTopology) error {
+	data, err := json.Marshal(topology)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if err = s.WriteFile(ctx, ClusterTopologyFile, data); err != nil {
+		return errors.Trace(err)
+	}
+	log.Info("cluster topology written", zap.Int("stores", len(topology.Stores)),
+		zap.Int("regions", topology.RegionCount), zap.String("cluster-version", topology.ClusterVersion))
+	return nil
+}
+
+// ReadClusterTopology reads the cluster topology from the storage. It returns
+// nil if the backup doesn't record it.
+func ReadClusterTopology(ctx context.Context, s storage.ExternalStorage) (*ClusterTopology, error) {
+	exists, err := s.FileExists(ctx, ClusterTopologyFile)
+	if err != nil || !exists {
+		return nil, errors.Trace(err)
+	}
+	data, err := s.ReadFile(ctx, ClusterTopologyFile)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	topology := &ClusterTopology{}
+	if err = json.Unmarshal(data, topology); err != nil {
+		return nil, errors.Annotatef(berrors.ErrInvalidMetaFile, "failed to parse %s: %v", ClusterTopologyFile, err)
+	}
+	return topology, nil
+}
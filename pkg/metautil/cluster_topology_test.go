@@ -0,0 +1,41 @@
+// Copyright 2021 PingCAP, Inc. Licensed under Apache-2.0.
+
+package metautil
+
+import (
+	"context"
+
+	. "github.com/pingcap/check"
+
+	"github.com/pingcap/br/pkg/storage"
+)
+
+func (m *metaSuit) TestClusterTopology(c *C) {
+	ctx := context.Background()
+	s, err := storage.NewLocalStorage(c.MkDir())
+	c.Assert(err, IsNil)
+
+	topology, err := ReadClusterTopology(ctx, s)
+	c.Assert(err, IsNil)
+	c.Assert(topology, IsNil)
+
+	expected := &ClusterTopology{
+		PDVersion:      "v5.1.0",
+		ClusterVersion: "5.1.0",
+		RegionCount:    30,
+		Stores: []StoreTopology{
+			{ID: 1, Address: "tikv1:20160", Version: "5.1.0", Labels: map[string]string{"zone": "z1"}, RegionCount: 20},
+			{ID: 2, Address: "tikv2:20160", Version: "5.1.0", Labels: map[string]string{"zone": "z2"}, RegionCount: 10},
+			{ID: 3, Address: "tiflash:3930", Version: "v5.1.0", TiFlash: true, RegionCount: 2},
+		},
+	}
+	c.Assert(WriteClusterTopology(ctx, s, expected), IsNil)
+	topology, err = ReadClusterTopology(ctx, s)
+	c.Assert(err, IsNil)
+	c.Assert(topology, DeepEquals, expected)
+	c.Assert(topology.TiKVStores(), Equals, 2)
+
+	c.Assert(s.WriteFile(ctx, ClusterTopologyFile, []byte("{")), IsNil)
+	_, err = ReadClusterTopology(ctx, s)
+	c.Assert(err, ErrorMatches, ".*failed to parse.*")
+}
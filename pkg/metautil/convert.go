@@ -0,0 +1,101 @@
+// Copyright 2021 PingCAP, Inc. Licensed under Apache-2.0.
+
+package metautil
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/gogo/protobuf/proto"
+	"github.com/pingcap/errors"
+	backuppb "github.com/pingcap/kvproto/pkg/backup"
+	"github.com/pingcap/log"
+	"go.uber.org/zap"
+
+	berrors "github.com/pingcap/br/pkg/errors"
+	"github.com/pingcap/br/pkg/storage"
+)
+
+const (
+	// MaxMetaVersion is the latest version of backupmeta this BR can read.
+	MaxMetaVersion = MetaV2
+	// MetaV1File is the name of the v1 backupmeta kept by ConvertToMetaV2.
+	MetaV1File = "backupmeta.v1"
+)
+
+// CheckMetaVersion checks whether the version of the backupmeta is readable,
+// the backupmetas written by a newer BR may keep the files and the schemas
+// where this BR never looks at.
+func CheckMetaVersion(meta *backuppb.BackupMeta) error {
+	if meta.GetVersion() > MaxMetaVersion {
+		return errors.Annotatef(berrors.ErrInvalidMetaFile,
+			"the version %d of backupmeta is newer than the version %d supported, please upgrade BR",
+			meta.GetVersion(), MaxMetaVersion)
+	}
+	return nil
+}
+
+// ConvertToMetaV2 converts the v1 backupmeta of the storage into v2, whose
+// data files, schemas and DDLs are sharded into the metafiles of the size
+// limit and read lazily, so a backup of millions of files can be restored
+// without loading a huge backupmeta at once. The v1 backupmeta is kept as
+// MetaV1File.
+func ConvertToMetaV2(
+	ctx context.Context, s storage.ExternalStorage, meta *backuppb.BackupMeta, sizeLimit int,
+) (*backuppb.BackupMeta, error) {
+	if meta.GetVersion() != MetaV1 {
+		return nil, errors.Annotatef(berrors.ErrInvalidArgument,
+			"only the v1 backupmeta can be converted, the version is %d", meta.GetVersion())
+	}
+	var ddls []json.RawMessage
+	if len(meta.Ddls) != 0 {
+		if err := json.Unmarshal(meta.Ddls, &ddls); err != nil {
+			return nil, errors.Annotatef(berrors.ErrInvalidMetaFile, "failed to parse the DDLs: %v", err)
+		}
+	}
+	data, err := proto.Marshal(meta)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	if err = s.WriteFile(ctx, MetaV1File, data); err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	writer := NewMetaWriter(s, sizeLimit, true)
+	base := proto.Clone(meta).(*backuppb.BackupMeta)
+	base.Files, base.Schemas, base.Ddls = nil, nil, nil
+	writer.Update(func(m *backuppb.BackupMeta) { *m = *base })
+
+	writer.StartWriteMetasAsync(ctx, AppendDataFile)
+	for _, file := range meta.Files {
+		if err = writer.Send([]*backuppb.File{file}, AppendDataFile); err != nil {
+			return nil, errors.Trace(err)
+		}
+	}
+	if err = writer.FinishWriteMetas(ctx, AppendDataFile); err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	writer.StartWriteMetasAsync(ctx, AppendSchema)
+	for _, schema := range meta.Schemas {
+		if err = writer.Send(schema, AppendSchema); err != nil {
+			return nil, errors.Trace(err)
+		}
+	}
+	if err = writer.FinishWriteMetas(ctx, AppendSchema); err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	writer.StartWriteMetasAsync(ctx, AppendDDL)
+	for _, ddl := range ddls {
+		if err = writer.Send([]byte(ddl), AppendDDL); err != nil {
+			return nil, errors.Trace(err)
+		}
+	}
+	if err = writer.FinishWriteMetas(ctx, AppendDDL); err != nil {
+		return nil, errors.Trace(err)
+	}
+	log.Info("backupmeta converted to v2", zap.Int("files", len(meta.Files)),
+		zap.Int("schemas", len(meta.Schemas)), zap.Int("ddls", len(ddls)))
+	return writer.Backupmeta(), nil
+}
@@ -0,0 +1,346 @@
+// Copyright 2021 PingCAP, Inc. Licensed under Apache-2.0.
+
+package metautil
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"path"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/pingcap/errors"
+	"github.com/pingcap/log"
+	"go.uber.org/zap"
+
+	berrors "github.com/pingcap/br/pkg/errors"
+	"github.com/pingcap/br/pkg/storage"
+)
+
+// CopyResult is the result of CopyBackup.
+type CopyResult struct {
+	// Copied is the number of the files copied.
+	Copied int
+	// CopiedSize is the total size of the copied files.
+	CopiedSize int64
+	// Skipped is the number of the files which already exist in the
+	// destination with the same size and checksum, e.g. copied by an
+	// interrupted copy.
+	Skipped int
+	// Verified is the number of the files verified with the checksum
+	// manifest, 0 if the backup has no manifest.
+	Verified int
+	// Resolved is the number of the data files referenced from the other
+	// backups by FileRefsFile, which are copied from them, so the copied
+	// backup doesn't depend on its chain.
+	Resolved int
+}
+
+// FileRefOpener opens the storage of the backup referenced by the data files
+// of the copied backup, by the depth and the backup name of FileRefs.
+type FileRefOpener func(ctx context.Context, depth int, backup string) (storage.ExternalStorage, error)
+
+// CopyBackup copies the files of the backup in the source storage to the
+// destination storage. The backupmetas are copied after all other files, so
+// the destination isn't a complete backup until the copy finishes, and an
+// interrupted copy can be run again, the files already in the destination are
+// skipped only if their checksums are the same as the source. The copied files
+// are verified by their sizes, and by the checksum manifest if the backup has
+// one. The data files the backup references from the other backups of its
+// chain are copied from them by openRef into the destination, which doesn't
+// reference them any more. The backup with references fails to copy if openRef
+// is nil.
+func CopyBackup(
+	ctx context.Context,
+	src, dst storage.ExternalStorage,
+	copier storage.FileCopier,
+	openRef FileRefOpener,
+	concurrency uint,
+) (*CopyResult, error) {
+	start := time.Now()
+	refs, err := ReadFileRefs(ctx, src)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	if refs != nil && openRef == nil {
+		return nil, errors.Annotatef(berrors.ErrInvalidArgument,
+			"the backup in %s references the data files of the other backups by %s, "+
+				"which can't be copied without them", src.URI(), FileRefsFile)
+	}
+	files, err := BackupFiles(ctx, src)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	if refs != nil {
+		// the referenced files are copied instead of the references.
+		files = removeFile(files, FileRefsFile)
+	}
+	srcSizes, err := fileSizes(ctx, src)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	dstSizes, err := fileSizes(ctx, dst)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	var sameSize []string
+	for _, name := range files {
+		size, ok := srcSizes[name]
+		if !ok {
+			if isSideFile(name) {
+				continue
+			}
+			return nil, errors.Annotatef(berrors.ErrBackupMissingFile, "%s not found in %s", name, src.URI())
+		}
+		if dstSize, ok := dstSizes[name]; ok && dstSize == size {
+			sameSize = append(sameSize, name)
+		}
+	}
+	copied, err := copiedFiles(ctx, src, dst, sameSize, concurrency)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	result := &CopyResult{}
+	var names, metas []string
+	for _, name := range files {
+		if _, ok := srcSizes[name]; !ok {
+			continue
+		}
+		if _, ok := copied[name]; ok {
+			result.Skipped++
+			continue
+		}
+		if path.Base(name) == MetaFile {
+			metas = append(metas, name)
+		} else {
+			names = append(names, name)
+		}
+	}
+
+	var copiedSize int64
+	copyFile := func(ctx context.Context, _ int, name string) error {
+		if err := copier.CopyFile(ctx, name, srcSizes[name]); err != nil {
+			return errors.Trace(err)
+		}
+		atomic.AddInt64(&copiedSize, srcSizes[name])
+		return nil
+	}
+	if err = forEachObject(ctx, names, concurrency, copyFile); err != nil {
+		return nil, errors.Trace(err)
+	}
+	if refs != nil {
+		resolvedSize, err := copyFileRefs(ctx, dst, refs, openRef, concurrency)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		result.Resolved = len(refs.Refs)
+		copiedSize += resolvedSize
+	}
+	// the backupmetas of the sub directories before the root one.
+	for i, name := range metas {
+		if err = copyFile(ctx, i, name); err != nil {
+			return nil, errors.Trace(err)
+		}
+	}
+	result.Copied = len(names) + len(metas) + result.Resolved
+	result.CopiedSize = copiedSize
+
+	if err = checkCopiedSizes(ctx, dst, files, srcSizes); err != nil {
+		return result, errors.Trace(err)
+	}
+	if _, ok := srcSizes[ManifestFile]; ok {
+		verified, err := VerifyChecksumManifest(ctx, dst, concurrency)
+		if err != nil && !onlyUnrelatedMissing(verified, files) {
+			return result, errors.Annotatef(err, "failed to verify the copied backup in %s", dst.URI())
+		}
+		result.Verified = verified.Verified
+	}
+	log.Info("backup copied",
+		zap.String("from", src.URI()),
+		zap.String("to", dst.URI()),
+		zap.Int("copied", result.Copied),
+		zap.Int64("size", result.CopiedSize),
+		zap.Int("skipped", result.Skipped),
+		zap.Int("verified", result.Verified),
+		zap.Int("resolved", result.Resolved),
+		zap.Duration("take", time.Since(start)))
+	return result, nil
+}
+
+// copyFileRefs copies the data files referenced from the other backups to the
+// destination by their names in the backupmeta, and returns the copied bytes.
+func copyFileRefs(
+	ctx context.Context,
+	dst storage.ExternalStorage,
+	refs *FileRefs,
+	openRef FileRefOpener,
+	concurrency uint,
+) (int64, error) {
+	sources := make(map[string]storage.ExternalStorage)
+	names := make([]string, 0, len(refs.Refs))
+	for _, ref := range refs.Refs {
+		names = append(names, ref.Name)
+		if _, ok := sources[ref.Backup]; ok {
+			continue
+		}
+		s, err := openRef(ctx, refs.Depth, ref.Backup)
+		if err != nil {
+			return 0, errors.Annotatef(err, "failed to open the backup %s referenced by %s", ref.Backup, FileRefsFile)
+		}
+		sources[ref.Backup] = s
+	}
+	var size int64
+	err := forEachObject(ctx, names, concurrency, func(ctx context.Context, i int, name string) error {
+		ref := refs.Refs[i]
+		n, err := storage.CopyFileAs(ctx, sources[ref.Backup], ref.File, dst, name)
+		if err != nil {
+			return errors.Annotatef(err, "failed to copy %s of the backup %s", ref.File, ref.Backup)
+		}
+		atomic.AddInt64(&size, n)
+		return nil
+	})
+	return size, errors.Trace(err)
+}
+
+// removeFile returns the files without the name.
+func removeFile(files []string, name string) []string {
+	kept := files[:0]
+	for _, file := range files {
+		if file != name {
+			kept = append(kept, file)
+		}
+	}
+	return kept
+}
+
+// copiedFiles returns the files already copied to the destination, whose
+// checksums are the same as the source. The checksums of the source are taken
+// from its checksum manifest if any, otherwise the source files are read.
+func copiedFiles(
+	ctx context.Context,
+	src, dst storage.ExternalStorage,
+	names []string,
+	concurrency uint,
+) (map[string]struct{}, error) {
+	copied := make(map[string]struct{}, len(names))
+	if len(names) == 0 {
+		return copied, nil
+	}
+	digests, err := manifestDigests(ctx, src)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	var mu sync.Mutex
+	err = forEachObject(ctx, names, concurrency, func(ctx context.Context, _ int, name string) error {
+		expected, ok := digests[name]
+		if !ok {
+			sum, err := objectSha256(ctx, src, name)
+			if err != nil {
+				return errors.Trace(err)
+			}
+			expected = sum
+		}
+		sum, err := objectSha256(ctx, dst, name)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		if !bytes.Equal(sum, expected) {
+			log.Info("the file in the destination differs from the source, copy it again",
+				zap.String("name", name),
+				zap.String("calculated", hex.EncodeToString(sum)),
+				zap.String("expected", hex.EncodeToString(expected)))
+			return nil
+		}
+		mu.Lock()
+		copied[name] = struct{}{}
+		mu.Unlock()
+		return nil
+	})
+	return copied, errors.Trace(err)
+}
+
+// manifestDigests returns the checksums of the objects in the checksum
+// manifest of the storage, or nothing if it has no manifest.
+func manifestDigests(ctx context.Context, s storage.ExternalStorage) (map[string][]byte, error) {
+	exists, err := s.FileExists(ctx, ManifestFile)
+	if err != nil || !exists {
+		return nil, errors.Trace(err)
+	}
+	data, err := s.ReadFile(ctx, ManifestFile)
+	if err != nil {
+		return nil, errors.Annotatef(err, "failed to read the checksum manifest %s", ManifestFile)
+	}
+	entries, err := DecodeManifest(data)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	digests := make(map[string][]byte, len(entries))
+	for _, entry := range entries {
+		digests[path.Clean(entry.Name)] = entry.Sha256
+	}
+	return digests, nil
+}
+
+// checkCopiedSizes checks the files of the backup in the destination have the
+// same sizes as the source.
+func checkCopiedSizes(ctx context.Context, dst storage.ExternalStorage, files []string, srcSizes map[string]int64) error {
+	dstSizes, err := fileSizes(ctx, dst)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	for _, name := range files {
+		size, ok := srcSizes[name]
+		if !ok {
+			continue
+		}
+		if dstSize, ok := dstSizes[name]; !ok || dstSize != size {
+			return errors.Annotatef(berrors.ErrBackupMissingFile,
+				"%s copied to %s has the size %d, expect %d", name, dst.URI(), dstSize, size)
+		}
+	}
+	return nil
+}
+
+// onlyUnrelatedMissing returns whether the manifest verification only fails
+// with the missing files not belonging to the backup, e.g. the other files
+// under the prefix of the source when the manifest is written, which aren't
+// copied.
+func onlyUnrelatedMissing(result *ManifestVerifyResult, files []string) bool {
+	if result == nil || len(result.Mismatched) > 0 {
+		return false
+	}
+	backupFiles := make(map[string]struct{}, len(files))
+	for _, name := range files {
+		backupFiles[name] = struct{}{}
+	}
+	for _, name := range result.Missing {
+		if _, ok := backupFiles[name]; ok {
+			return false
+		}
+	}
+	return true
+}
+
+func fileSizes(ctx context.Context, s storage.ExternalStorage) (map[string]int64, error) {
+	sizes := make(map[string]int64)
+	err := s.WalkDir(ctx, &storage.WalkOption{}, func(name string, size int64) error {
+		sizes[path.Clean(filepath.ToSlash(name))] = size
+		return nil
+	})
+	return sizes, errors.Trace(err)
+}
+
+func isSideFile(name string) bool {
+	base := path.Base(name)
+	for _, side := range sideFiles {
+		if base == side {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,142 @@
+// Copyright 2021 PingCAP, Inc. Licensed under Apache-2.0.
+
+package metautil
+
+import (
+	"context"
+
+	"github.com/gogo/protobuf/proto"
+	. "github.com/pingcap/check"
+	backuppb "github.com/pingcap/kvproto/pkg/backup"
+
+	"github.com/pingcap/br/pkg/storage"
+)
+
+func (m *metaSuit) TestCopyBackup(c *C) {
+	ctx := context.Background()
+	src, err := storage.NewLocalStorage(c.MkDir())
+	c.Assert(err, IsNil)
+	dst, err := storage.NewLocalStorage(c.MkDir())
+	c.Assert(err, IsNil)
+
+	meta := &backuppb.BackupMeta{Files: []*backuppb.File{{Name: "1.sst"}, {Name: "db1/2.sst"}}}
+	metaData, err := proto.Marshal(meta)
+	c.Assert(err, IsNil)
+	files := map[string]string{
+		"1.sst":      "data1",
+		"db1/2.sst":  "data2",
+		"unrelated":  "other",
+		"backupmeta": string(metaData),
+	}
+	for name, content := range files {
+		c.Assert(src.WriteFile(ctx, name, []byte(content)), IsNil)
+	}
+	c.Assert(WriteChecksumManifest(ctx, src, meta, 2), IsNil)
+	// copied by an interrupted copy.
+	c.Assert(dst.WriteFile(ctx, "1.sst", []byte("data1")), IsNil)
+	// the file of the same size but a different checksum is copied again.
+	c.Assert(dst.WriteFile(ctx, "db1/2.sst", []byte("dataX")), IsNil)
+
+	result, err := CopyBackup(ctx, src, dst, storage.NewStreamCopier(src, dst), nil, 2)
+	c.Assert(err, IsNil)
+	c.Assert(result.Copied, Equals, 3)
+	c.Assert(result.CopiedSize, Equals, int64(len("data2")+len(metaData))+sizeOf(ctx, c, src, ManifestFile))
+	c.Assert(result.Skipped, Equals, 1)
+	c.Assert(result.Verified, Equals, 3)
+	for name, content := range files {
+		data, err := dst.ReadFile(ctx, name)
+		if name == "unrelated" {
+			c.Assert(err, NotNil)
+			continue
+		}
+		c.Assert(err, IsNil)
+		c.Assert(string(data), Equals, content)
+	}
+
+	// the missing data file fails the copy.
+	c.Assert(src.DeleteFile(ctx, "db1/2.sst"), IsNil)
+	_, err = CopyBackup(ctx, src, dst, storage.NewStreamCopier(src, dst), nil, 2)
+	c.Assert(err, ErrorMatches, ".*db1/2.sst not found.*")
+}
+
+func (m *metaSuit) TestCopyBackupWithoutManifest(c *C) {
+	ctx := context.Background()
+	src, err := storage.NewLocalStorage(c.MkDir())
+	c.Assert(err, IsNil)
+	dst, err := storage.NewLocalStorage(c.MkDir())
+	c.Assert(err, IsNil)
+
+	meta := &backuppb.BackupMeta{Files: []*backuppb.File{{Name: "1.sst"}, {Name: "2.sst"}}}
+	metaData, err := proto.Marshal(meta)
+	c.Assert(err, IsNil)
+	files := map[string]string{
+		"1.sst":      "data1",
+		"2.sst":      "data2",
+		"backupmeta": string(metaData),
+	}
+	for name, content := range files {
+		c.Assert(src.WriteFile(ctx, name, []byte(content)), IsNil)
+	}
+	// without the manifest, the source files are read to compare the checksums.
+	c.Assert(dst.WriteFile(ctx, "1.sst", []byte("data1")), IsNil)
+	c.Assert(dst.WriteFile(ctx, "2.sst", []byte("dataX")), IsNil)
+
+	result, err := CopyBackup(ctx, src, dst, storage.NewStreamCopier(src, dst), nil, 2)
+	c.Assert(err, IsNil)
+	c.Assert(result.Copied, Equals, 2)
+	c.Assert(result.Skipped, Equals, 1)
+	c.Assert(result.Verified, Equals, 0)
+	for name, content := range files {
+		data, err := dst.ReadFile(ctx, name)
+		c.Assert(err, IsNil)
+		c.Assert(string(data), Equals, content)
+	}
+}
+
+func (m *metaSuit) TestCopyBackupWithFileRefs(c *C) {
+	ctx := context.Background()
+	base, err := storage.NewLocalStorage(c.MkDir())
+	c.Assert(err, IsNil)
+	src, err := storage.NewLocalStorage(c.MkDir())
+	c.Assert(err, IsNil)
+	dst, err := storage.NewLocalStorage(c.MkDir())
+	c.Assert(err, IsNil)
+
+	meta := &backuppb.BackupMeta{Files: []*backuppb.File{{Name: "1.sst"}, {Name: "2.sst"}}}
+	metaData, err := proto.Marshal(meta)
+	c.Assert(err, IsNil)
+	c.Assert(src.WriteFile(ctx, "2.sst", []byte("data2")), IsNil)
+	c.Assert(src.WriteFile(ctx, MetaFile, metaData), IsNil)
+	c.Assert(base.WriteFile(ctx, "5.sst", []byte("data1")), IsNil)
+	refs := &FileRefs{Depth: 1, Refs: []FileRef{{Name: "1.sst", Backup: "full", File: "5.sst"}}}
+	c.Assert(WriteFileRefs(ctx, src, refs), IsNil)
+
+	// the referenced files can't be copied without the backups holding them.
+	_, err = CopyBackup(ctx, src, dst, storage.NewStreamCopier(src, dst), nil, 2)
+	c.Assert(err, ErrorMatches, ".*references the data files of the other backups.*")
+
+	openRef := func(_ context.Context, depth int, backup string) (storage.ExternalStorage, error) {
+		c.Assert(depth, Equals, 1)
+		c.Assert(backup, Equals, "full")
+		return base, nil
+	}
+	result, err := CopyBackup(ctx, src, dst, storage.NewStreamCopier(src, dst), openRef, 2)
+	c.Assert(err, IsNil)
+	c.Assert(result.Resolved, Equals, 1)
+	c.Assert(result.Copied, Equals, 3)
+	c.Assert(result.CopiedSize, Equals, int64(len("data1")+len("data2")+len(metaData)))
+	data, err := dst.ReadFile(ctx, "1.sst")
+	c.Assert(err, IsNil)
+	c.Assert(string(data), Equals, "data1")
+	// the copied backup holds all its data files.
+	copiedRefs, err := ReadFileRefs(ctx, dst)
+	c.Assert(err, IsNil)
+	c.Assert(copiedRefs, IsNil)
+	c.Assert(CheckDataFiles(ctx, dst, meta), IsNil)
+}
+
+func sizeOf(ctx context.Context, c *C, s storage.ExternalStorage, name string) int64 {
+	data, err := s.ReadFile(ctx, name)
+	c.Assert(err, IsNil)
+	return int64(len(data))
+}
@@ -0,0 +1,179 @@
+// Copyright 2021 PingCAP, Inc. Licensed under Apache-2.0.
+
+package metautil
+
+import (
+	"context"
+	"encoding/json"
+	"path"
+	"sync/atomic"
+	"time"
+
+	"github.com/pingcap/errors"
+	"github.com/pingcap/log"
+	"go.uber.org/zap"
+
+	berrors "github.com/pingcap/br/pkg/errors"
+	"github.com/pingcap/br/pkg/storage"
+)
+
+// EncryptionFile is the name of the file recording the master keys the files
+// of the backup are encrypted by.
+const EncryptionFile = "encryption.json"
+
+// EncryptionKey is a master key the files of the backup are encrypted by.
+type EncryptionKey struct {
+	// Method is the server-side encryption of the storage, e.g. aws:kms.
+	Method string `json:"method"`
+	// KeyID is the ID of the master key, empty if the key is managed by the
+	// storage service, e.g. AES256 of S3.
+	KeyID string `json:"key-id,omitempty"`
+	// Version starts from 1 and is increased by every rotation.
+	Version int       `json:"version"`
+	Time    time.Time `json:"time"`
+}
+
+// Encryption is the master keys of the backup, the current one last.
+type Encryption struct {
+	Keys []EncryptionKey `json:"keys"`
+}
+
+// Current returns the master key the files are encrypted by now.
+func (e *Encryption) Current() EncryptionKey {
+	if e == nil || len(e.Keys) == 0 {
+		return EncryptionKey{}
+	}
+	return e.Keys[len(e.Keys)-1]
+}
+
+// WriteEncryption writes the master keys of the backup to the storage.
+func WriteEncryption(ctx context.Context, s storage.ExternalStorage, encryption *Encryption) error {
+	data, err := json.Marshal(encryption)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if err = s.WriteFile(ctx, EncryptionFile, data); err != nil {
+		return errors.Trace(err)
+	}
+	key := encryption.Current()
+	log.Info("encryption key recorded", zap.String("method", key.Method),
+		zap.String("key-id", key.KeyID), zap.Int("version", key.Version))
+	return nil
+}
+
+// ReadEncryption reads the master keys of the backup from the storage. It
+// returns nil if the backup doesn't record them.
+func ReadEncryption(ctx context.Context, s storage.ExternalStorage) (*Encryption, error) {
+	exists, err := s.FileExists(ctx, EncryptionFile)
+	if err != nil || !exists {
+		return nil, errors.Trace(err)
+	}
+	data, err := s.ReadFile(ctx, EncryptionFile)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	encryption := &Encryption{}
+	if err = json.Unmarshal(data, encryption); err != nil {
+		return nil, errors.Annotatef(berrors.ErrInvalidMetaFile, "failed to parse %s: %v", EncryptionFile, err)
+	}
+	return encryption, nil
+}
+
+// RotateResult is the result of RotateEncryptionKey.
+type RotateResult struct {
+	// Rotated is the number of the files encrypted by the new key.
+	Rotated int
+	// RotatedSize is the total size of the rotated files.
+	RotatedSize int64
+	// Key is the new master key.
+	Key EncryptionKey
+}
+
+// RotateEncryptionKey encrypts the files of the backup in the storage by the
+// new master key and records it. The copier copies every file to itself in
+// the storage rotated, which is the same location as s with the new key, so
+// the storage service re-encrypts the file without changing its content. The
+// backupmetas are rotated last and the new key is recorded after all files,
+// an interrupted rotation is continued by running it again with the same key.
+func RotateEncryptionKey(
+	ctx context.Context,
+	s, rotated storage.ExternalStorage,
+	copier storage.FileCopier,
+	key EncryptionKey,
+	concurrency uint,
+) (*RotateResult, error) {
+	start := time.Now()
+	encryption, err := ReadEncryption(ctx, s)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	if encryption == nil {
+		encryption = &Encryption{}
+	}
+	current := encryption.Current()
+	if current.Method == key.Method && current.KeyID == key.KeyID && current.Version > 0 {
+		return nil, errors.Annotatef(berrors.ErrInvalidArgument,
+			"the backup in %s is already encrypted by the key %q", s.URI(), key.KeyID)
+	}
+	files, err := BackupFiles(ctx, s)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	sizes, err := fileSizes(ctx, s)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	var names, metas []string
+	for _, name := range files {
+		if _, ok := sizes[name]; !ok {
+			if isSideFile(name) {
+				continue
+			}
+			return nil, errors.Annotatef(berrors.ErrBackupMissingFile, "%s not found in %s", name, s.URI())
+		}
+		switch {
+		case name == EncryptionFile:
+			// rewritten with the new key at last.
+		case path.Base(name) == MetaFile:
+			metas = append(metas, name)
+		default:
+			names = append(names, name)
+		}
+	}
+
+	result := &RotateResult{}
+	rotateFile := func(ctx context.Context, _ int, name string) error {
+		if err := copier.CopyFile(ctx, name, sizes[name]); err != nil {
+			return errors.Annotatef(err, "failed to rotate the key of %s", name)
+		}
+		atomic.AddInt64(&result.RotatedSize, sizes[name])
+		return nil
+	}
+	if err = forEachObject(ctx, names, concurrency, rotateFile); err != nil {
+		return nil, errors.Trace(err)
+	}
+	// the backupmetas of the sub directories before the root one.
+	for i, name := range metas {
+		if err = rotateFile(ctx, i, name); err != nil {
+			return nil, errors.Trace(err)
+		}
+	}
+	result.Rotated = len(names) + len(metas)
+
+	key.Version = current.Version + 1
+	key.Time = time.Now()
+	encryption.Keys = append(encryption.Keys, key)
+	if err = WriteEncryption(ctx, rotated, encryption); err != nil {
+		return nil, errors.Trace(err)
+	}
+	result.Key = key
+	log.Info("encryption key rotated",
+		zap.String("storage", s.URI()),
+		zap.String("from", current.KeyID),
+		zap.String("to", key.KeyID),
+		zap.Int("files", result.Rotated),
+		zap.Int64("size", result.RotatedSize),
+		zap.Duration("take", time.Since(start)))
+	return result, nil
+}
@@ -0,0 +1,71 @@
+// Copyright 2021 PingCAP, Inc. Licensed under Apache-2.0.
+
+package metautil
+
+import (
+	"context"
+	"sync"
+
+	"github.com/gogo/protobuf/proto"
+	. "github.com/pingcap/check"
+	backuppb "github.com/pingcap/kvproto/pkg/backup"
+
+	"github.com/pingcap/br/pkg/storage"
+)
+
+// recordCopier records the files copied.
+type recordCopier struct {
+	mu    sync.Mutex
+	files []string
+}
+
+func (r *recordCopier) CopyFile(_ context.Context, name string, _ int64) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.files = append(r.files, name)
+	return nil
+}
+
+func (m *metaSuit) TestRotateEncryptionKey(c *C) {
+	ctx := context.Background()
+	s, err := storage.NewLocalStorage(c.MkDir())
+	c.Assert(err, IsNil)
+
+	encryption, err := ReadEncryption(ctx, s)
+	c.Assert(err, IsNil)
+	c.Assert(encryption, IsNil)
+	c.Assert(encryption.Current(), DeepEquals, EncryptionKey{})
+
+	meta := &backuppb.BackupMeta{Files: []*backuppb.File{{Name: "1.sst"}, {Name: "2.sst"}}}
+	data, err := proto.Marshal(meta)
+	c.Assert(err, IsNil)
+	c.Assert(s.WriteFile(ctx, MetaFile, data), IsNil)
+	c.Assert(s.WriteFile(ctx, "1.sst", []byte("1")), IsNil)
+	c.Assert(s.WriteFile(ctx, "2.sst", []byte("22")), IsNil)
+	c.Assert(WriteEncryption(ctx, s, &Encryption{
+		Keys: []EncryptionKey{{Method: "aws:kms", KeyID: "key1", Version: 1}},
+	}), IsNil)
+
+	copier := &recordCopier{}
+	result, err := RotateEncryptionKey(ctx, s, s, copier, EncryptionKey{Method: "aws:kms", KeyID: "key2"}, 2)
+	c.Assert(err, IsNil)
+	c.Assert(result.Rotated, Equals, 3)
+	c.Assert(result.RotatedSize, Equals, int64(3+len(data)))
+	c.Assert(result.Key.Version, Equals, 2)
+	// the backupmeta is rotated last, the record of the keys isn't copied.
+	c.Assert(copier.files, HasLen, 3)
+	c.Assert(copier.files[2], Equals, MetaFile)
+
+	encryption, err = ReadEncryption(ctx, s)
+	c.Assert(err, IsNil)
+	c.Assert(encryption.Keys, HasLen, 2)
+	c.Assert(encryption.Current().KeyID, Equals, "key2")
+	c.Assert(encryption.Keys[0].KeyID, Equals, "key1")
+
+	_, err = RotateEncryptionKey(ctx, s, s, copier, EncryptionKey{Method: "aws:kms", KeyID: "key2"}, 2)
+	c.Assert(err, ErrorMatches, ".*already encrypted by the key.*")
+
+	c.Assert(s.WriteFile(ctx, EncryptionFile, []byte("{")), IsNil)
+	_, err = ReadEncryption(ctx, s)
+	c.Assert(err, ErrorMatches, ".*failed to parse.*")
+}
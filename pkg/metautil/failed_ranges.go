@@ -0,0 +1,85 @@
+// Copyright 2021 PingCAP, Inc. Licensed under Apache-2.0.
+
+package metautil
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/gogo/protobuf/proto"
+	"github.com/pingcap/errors"
+	backuppb "github.com/pingcap/kvproto/pkg/backup"
+	"github.com/pingcap/log"
+	"go.uber.org/zap"
+
+	berrors "github.com/pingcap/br/pkg/errors"
+	"github.com/pingcap/br/pkg/storage"
+)
+
+// FailedRangesFile is the name of the file recording the ranges failed to be
+// backed up after all retries, the backup is incomplete until they are filled.
+const FailedRangesFile = "failed_ranges.json"
+
+// FailedRange is a range failed to be backed up.
+type FailedRange struct {
+	StartKey []byte `json:"start-key"`
+	EndKey   []byte `json:"end-key"`
+	Attempts int    `json:"attempts"`
+	Error    string `json:"error"`
+}
+
+// FailedRanges are the gaps of an incomplete backup.
+type FailedRanges struct {
+	StartVersion uint64        `json:"start-version"`
+	EndVersion   uint64        `json:"end-version"`
+	Ranges       []FailedRange `json:"ranges"`
+}
+
+// WriteFailedRanges writes the failed ranges to the storage.
+func WriteFailedRanges(ctx context.Context, s storage.ExternalStorage, failed *FailedRanges) error {
+	data, err := json.Marshal(failed)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if err = s.WriteFile(ctx, FailedRangesFile, data); err != nil {
+		return errors.Trace(err)
+	}
+	log.Info("failed ranges recorded", zap.Int("ranges", len(failed.Ranges)))
+	return nil
+}
+
+// ReadFailedRanges reads the failed ranges from the storage. It returns nil
+// if the backup is complete.
+func ReadFailedRanges(ctx context.Context, s storage.ExternalStorage) (*FailedRanges, error) {
+	exists, err := s.FileExists(ctx, FailedRangesFile)
+	if err != nil || !exists {
+		return nil, errors.Trace(err)
+	}
+	data, err := s.ReadFile(ctx, FailedRangesFile)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	failed := &FailedRanges{}
+	if err = json.Unmarshal(data, failed); err != nil {
+		return nil, errors.Annotatef(berrors.ErrInvalidMetaFile, "failed to parse %s: %v", FailedRangesFile, err)
+	}
+	return failed, nil
+}
+
+// NewFillGapsMetaWriter creates a writer rewriting the data files of the
+// backupmeta, after the failed ranges are backed up again. The other parts of
+// the backupmeta are kept, and the new metafiles don't overwrite the ones
+// referenced by it, so the backupmeta stays valid until it's rewritten.
+func NewFillGapsMetaWriter(s storage.ExternalStorage, metafileSizeLimit int, old *backuppb.BackupMeta) *MetaWriter {
+	writer := NewMetaWriter(s, metafileSizeLimit, old.Version == MetaV2)
+	meta := proto.Clone(old).(*backuppb.BackupMeta)
+	meta.Files = nil
+	meta.FileIndex = nil
+	writer.backupMeta = meta
+	for _, index := range []*backuppb.MetaFile{old.FileIndex, old.SchemaIndex, old.DdlIndexes} {
+		if index != nil {
+			writer.metafileSeqNum["metafiles"] += len(index.MetaFiles)
+		}
+	}
+	return writer
+}
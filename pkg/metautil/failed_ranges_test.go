@@ -0,0 +1,73 @@
+// Copyright 2021 PingCAP, Inc. Licensed under Apache-2.0.
+
+package metautil
+
+import (
+	"context"
+
+	. "github.com/pingcap/check"
+	backuppb "github.com/pingcap/kvproto/pkg/backup"
+
+	"github.com/pingcap/br/pkg/storage"
+)
+
+func (m *metaSuit) TestFailedRanges(c *C) {
+	ctx := context.Background()
+	s, err := storage.NewLocalStorage(c.MkDir())
+	c.Assert(err, IsNil)
+
+	failed, err := ReadFailedRanges(ctx, s)
+	c.Assert(err, IsNil)
+	c.Assert(failed, IsNil)
+
+	expected := &FailedRanges{
+		EndVersion: 100,
+		Ranges:     []FailedRange{{StartKey: []byte("a"), EndKey: []byte("b"), Attempts: 4, Error: "store 1 is down"}},
+	}
+	c.Assert(WriteFailedRanges(ctx, s, expected), IsNil)
+	failed, err = ReadFailedRanges(ctx, s)
+	c.Assert(err, IsNil)
+	c.Assert(failed, DeepEquals, expected)
+}
+
+func (m *metaSuit) TestFillGapsMetaWriter(c *C) {
+	ctx := context.Background()
+	s, err := storage.NewLocalStorage(c.MkDir())
+	c.Assert(err, IsNil)
+
+	v1 := &backuppb.BackupMeta{
+		EndVersion: 100,
+		Files:      []*backuppb.File{{Name: "1.sst", Size_: 10}, {Name: "2.sst", Size_: 10}},
+		Schemas:    []*backuppb.Schema{testSchema(10, "t1", 1)},
+		Ddls:       []byte(`[{"id":1}]`),
+	}
+	old, err := ConvertToMetaV2(ctx, s, v1, 15)
+	c.Assert(err, IsNil)
+	oldIndex := old.FileIndex.MetaFiles
+
+	writer := NewFillGapsMetaWriter(s, 15, old)
+	writer.StartWriteMetasAsync(ctx, AppendDataFile)
+	for _, file := range append(v1.Files, &backuppb.File{Name: "3.sst", Size_: 10}) {
+		c.Assert(writer.Send([]*backuppb.File{file}, AppendDataFile), IsNil)
+	}
+	c.Assert(writer.FinishWriteMetas(ctx, AppendDataFile), IsNil)
+
+	meta := writer.Backupmeta()
+	c.Assert(meta.EndVersion, Equals, uint64(100))
+	// the metafiles of the old backupmeta aren't overwritten.
+	for _, newFile := range meta.FileIndex.MetaFiles {
+		for _, oldFile := range oldIndex {
+			c.Assert(newFile.Name, Not(Equals), oldFile.Name)
+		}
+	}
+	reader := NewMetaReader(meta, s)
+	files, err := reader.ReadDataFiles(ctx)
+	c.Assert(err, IsNil)
+	c.Assert(files, HasLen, 3)
+	var schemas int
+	c.Assert(reader.readSchemas(ctx, func(*backuppb.Schema) { schemas++ }), IsNil)
+	c.Assert(schemas, Equals, 1)
+	ddls, err := reader.ReadDDLs(ctx)
+	c.Assert(err, IsNil)
+	c.Assert(string(ddls), Equals, `[{"id":1}]`)
+}
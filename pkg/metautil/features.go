@@ -0,0 +1,68 @@
+// Copyright 2021 PingCAP, Inc. Licensed under Apache-2.0.
+
+package metautil
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/pingcap/errors"
+	"github.com/pingcap/log"
+	"go.uber.org/zap"
+
+	berrors "github.com/pingcap/br/pkg/errors"
+	"github.com/pingcap/br/pkg/storage"
+)
+
+// FeaturesFile is the name of the file recording the features of the backup
+// requests used by the stores.
+const FeaturesFile = "backup_features.json"
+
+// BackupFeatures is the features of the backup requests.
+type BackupFeatures struct {
+	// Requested are the features requested by the backup.
+	Requested []string `json:"requested"`
+	// Downgraded are the features disabled on the stores of old versions, by
+	// the store IDs. The files backed up by these stores don't use them.
+	Downgraded map[uint64][]string `json:"downgraded,omitempty"`
+	// Compression is the codec of the SST files, e.g. zstd, and
+	// CompressionLevel is its level, 0 means the default one of TiKV. Empty
+	// means the SST files aren't compressed by the request.
+	Compression      string `json:"compression,omitempty"`
+	CompressionLevel int32  `json:"compression-level,omitempty"`
+	// SchemaOnly is whether the backup is taken by --schema-only, which has
+	// the schemas without the data of the tables.
+	SchemaOnly bool `json:"schema-only,omitempty"`
+}
+
+// WriteBackupFeatures writes the features to the storage.
+func WriteBackupFeatures(ctx context.Context, s storage.ExternalStorage, features *BackupFeatures) error {
+	data, err := json.Marshal(features)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if err = s.WriteFile(ctx, FeaturesFile, data); err != nil {
+		return errors.Trace(err)
+	}
+	log.Info("backup features written", zap.Strings("requested", features.Requested),
+		zap.Any("downgraded", features.Downgraded), zap.Bool("schema only", features.SchemaOnly))
+	return nil
+}
+
+// ReadBackupFeatures reads the features from the storage. It returns nil if
+// the backup doesn't record them.
+func ReadBackupFeatures(ctx context.Context, s storage.ExternalStorage) (*BackupFeatures, error) {
+	exists, err := s.FileExists(ctx, FeaturesFile)
+	if err != nil || !exists {
+		return nil, errors.Trace(err)
+	}
+	data, err := s.ReadFile(ctx, FeaturesFile)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	features := &BackupFeatures{}
+	if err = json.Unmarshal(data, features); err != nil {
+		return nil, errors.Annotatef(berrors.ErrInvalidMetaFile, "failed to parse %s: %v", FeaturesFile, err)
+	}
+	return features, nil
+}
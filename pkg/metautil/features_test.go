@@ -0,0 +1,42 @@
+// Copyright 2021 PingCAP, Inc. Licensed under Apache-2.0.
+
+package metautil
+
+import (
+	"context"
+
+	. "github.com/pingcap/check"
+
+	"github.com/pingcap/br/pkg/storage"
+)
+
+func (m *metaSuit) TestBackupFeatures(c *C) {
+	ctx := context.Background()
+	s, err := storage.NewLocalStorage(c.MkDir())
+	c.Assert(err, IsNil)
+
+	features, err := ReadBackupFeatures(ctx, s)
+	c.Assert(err, IsNil)
+	c.Assert(features, IsNil)
+
+	expected := &BackupFeatures{
+		Requested:  []string{"compression"},
+		Downgraded: map[uint64][]string{2: {"compression"}},
+
+		Compression:      "zstd",
+		CompressionLevel: 3,
+	}
+	c.Assert(WriteBackupFeatures(ctx, s, expected), IsNil)
+	features, err = ReadBackupFeatures(ctx, s)
+	c.Assert(err, IsNil)
+	c.Assert(features, DeepEquals, expected)
+
+	c.Assert(WriteBackupFeatures(ctx, s, &BackupFeatures{SchemaOnly: true}), IsNil)
+	features, err = ReadBackupFeatures(ctx, s)
+	c.Assert(err, IsNil)
+	c.Assert(features.SchemaOnly, IsTrue)
+
+	c.Assert(s.WriteFile(ctx, FeaturesFile, []byte("{")), IsNil)
+	_, err = ReadBackupFeatures(ctx, s)
+	c.Assert(err, ErrorMatches, ".*failed to parse.*")
+}
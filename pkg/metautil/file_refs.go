@@ -0,0 +1,72 @@
+// Copyright 2021 PingCAP, Inc. Licensed under Apache-2.0.
+
+package metautil
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/pingcap/errors"
+	"github.com/pingcap/log"
+	"go.uber.org/zap"
+
+	berrors "github.com/pingcap/br/pkg/errors"
+	"github.com/pingcap/br/pkg/storage"
+)
+
+// FileRefsFile is the name of the file of the incremental backup referencing
+// the data files of the other backups of its chain, whose contents are the
+// same as its own data files removed from the storage.
+const FileRefsFile = "file_refs.json"
+
+// FileRef references the data file of another backup holding the content of
+// a data file of the backupmeta.
+type FileRef struct {
+	// Name is the name of the data file in the backupmeta.
+	Name string `json:"name"`
+	// Backup is the name of the backup holding the content in the directory of
+	// the backup index, the same as its name in the backup index.
+	Backup string `json:"backup"`
+	// File is the name of the data file in that backup.
+	File string `json:"file"`
+}
+
+// FileRefs are the data files of an incremental backup deduplicated against
+// the backups of its chain.
+type FileRefs struct {
+	// Depth is the number of the directories between the backup and the
+	// directory of the backup index like BaseBackup.Depth.
+	Depth int       `json:"depth,omitempty"`
+	Refs  []FileRef `json:"refs"`
+}
+
+// WriteFileRefs writes the references of the data files to the storage.
+func WriteFileRefs(ctx context.Context, s storage.ExternalStorage, refs *FileRefs) error {
+	data, err := json.Marshal(refs)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if err = s.WriteFile(ctx, FileRefsFile, data); err != nil {
+		return errors.Trace(err)
+	}
+	log.Info("file references recorded", zap.Int("files", len(refs.Refs)))
+	return nil
+}
+
+// ReadFileRefs reads the references of the data files from the storage. It
+// returns nil if the backup holds all its data files.
+func ReadFileRefs(ctx context.Context, s storage.ExternalStorage) (*FileRefs, error) {
+	exists, err := s.FileExists(ctx, FileRefsFile)
+	if err != nil || !exists {
+		return nil, errors.Trace(err)
+	}
+	data, err := s.ReadFile(ctx, FileRefsFile)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	refs := &FileRefs{}
+	if err = json.Unmarshal(data, refs); err != nil {
+		return nil, errors.Annotatef(berrors.ErrInvalidMetaFile, "failed to parse %s: %v", FileRefsFile, err)
+	}
+	return refs, nil
+}
@@ -0,0 +1,45 @@
+// Copyright 2021 PingCAP, Inc. Licensed under Apache-2.0.
+
+package metautil
+
+import (
+	"context"
+
+	"github.com/gogo/protobuf/proto"
+	. "github.com/pingcap/check"
+	backuppb "github.com/pingcap/kvproto/pkg/backup"
+
+	"github.com/pingcap/br/pkg/storage"
+)
+
+func (m *metaSuit) TestFileRefs(c *C) {
+	ctx := context.Background()
+	s, err := storage.NewLocalStorage(c.MkDir())
+	c.Assert(err, IsNil)
+
+	refs, err := ReadFileRefs(ctx, s)
+	c.Assert(err, IsNil)
+	c.Assert(refs, IsNil)
+
+	meta := &backuppb.BackupMeta{Files: []*backuppb.File{{Name: "1.sst"}, {Name: "2.sst"}}}
+	data, err := proto.Marshal(meta)
+	c.Assert(err, IsNil)
+	c.Assert(s.WriteFile(ctx, MetaFile, data), IsNil)
+	c.Assert(WriteFileRefs(ctx, s, &FileRefs{
+		Depth: 2,
+		Refs:  []FileRef{{Name: "1.sst", Backup: "2021/full", File: "3.sst"}},
+	}), IsNil)
+	refs, err = ReadFileRefs(ctx, s)
+	c.Assert(err, IsNil)
+	c.Assert(refs.Depth, Equals, 2)
+	c.Assert(refs.Refs, DeepEquals, []FileRef{{Name: "1.sst", Backup: "2021/full", File: "3.sst"}})
+
+	// the referenced data files aren't in the storage of the backup.
+	files, err := BackupFiles(ctx, s)
+	c.Assert(err, IsNil)
+	c.Assert(files[0], Equals, "2.sst")
+
+	c.Assert(s.WriteFile(ctx, FileRefsFile, []byte("{")), IsNil)
+	_, err = ReadFileRefs(ctx, s)
+	c.Assert(err, ErrorMatches, ".*failed to parse.*")
+}
@@ -0,0 +1,213 @@
+// Copyright 2021 PingCAP, Inc. Licensed under Apache-2.0.
+
+package metautil
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"path"
+	"sort"
+
+	"github.com/gogo/protobuf/proto"
+	"github.com/pingcap/errors"
+	backuppb "github.com/pingcap/kvproto/pkg/backup"
+	"github.com/pingcap/log"
+	"go.uber.org/zap"
+
+	berrors "github.com/pingcap/br/pkg/errors"
+	"github.com/pingcap/br/pkg/storage"
+)
+
+// sideFiles are the files written along with the backupmeta.
+var sideFiles = []string{
+	LockFile,
+	MetaJSONFile,
+	RebuiltMetaFile,
+	ManifestFile,
+	RetentionFile,
+	RegionTopologyFile,
+	FeaturesFile,
+	BaseBackupFile,
+	PlacementRulesFile,
+	VerifyQueriesFile,
+	FileRefsFile,
+	SkippedIndexesFile,
+	ClusterTopologyFile,
+	EncryptionFile,
+	PartialReportFile,
+}
+
+// BackupFiles lists the files belonging to the backup in the storage. They
+// are the data files and the metafiles referenced by the backupmeta, the
+// backupmetas of the sub directories of the per-database backups, and the
+// side files written along with the backupmeta. The backupmeta is the last
+// one, so that a partial deletion in order can be continued. The data files
+// referencing the other backups by FileRefsFile aren't in the storage, so
+// they aren't listed.
+//
+// The files may not exist, e.g. the side files aren't always written.
+func BackupFiles(ctx context.Context, s storage.ExternalStorage) ([]string, error) {
+	exists, err := s.FileExists(ctx, MetaFile)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	if !exists {
+		return nil, errors.Annotatef(berrors.ErrInvalidMetaFile, "%s not found in %s", MetaFile, s.URI())
+	}
+	collector := &backupFileCollector{storage: s, files: make(map[string]struct{})}
+	if err = collector.collect(ctx, ""); err != nil {
+		return nil, errors.Trace(err)
+	}
+	refs, err := ReadFileRefs(ctx, s)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	if refs != nil {
+		for _, ref := range refs.Refs {
+			delete(collector.files, ref.Name)
+		}
+	}
+
+	// the backupmetas of the sub directories before the root one.
+	var metas []string
+	files := make([]string, 0, len(collector.files))
+	for name := range collector.files {
+		if path.Base(name) == MetaFile {
+			metas = append(metas, name)
+		} else {
+			files = append(files, name)
+		}
+	}
+	sort.Strings(files)
+	sort.Slice(metas, func(i, j int) bool {
+		if len(metas[i]) != len(metas[j]) {
+			return len(metas[i]) > len(metas[j])
+		}
+		return metas[i] < metas[j]
+	})
+	return append(files, metas...), nil
+}
+
+type backupFileCollector struct {
+	storage storage.ExternalStorage
+	files   map[string]struct{}
+}
+
+// collect adds the files of the backup in the directory.
+func (c *backupFileCollector) collect(ctx context.Context, dir string) error {
+	metaName := path.Join(dir, MetaFile)
+	data, err := c.storage.ReadFile(ctx, metaName)
+	if err != nil {
+		return errors.Annotatef(err, "failed to read %s", metaName)
+	}
+	meta := &backuppb.BackupMeta{}
+	if err = proto.Unmarshal(data, meta); err != nil {
+		return errors.Annotatef(berrors.ErrInvalidMetaFile, "failed to parse %s: %v", metaName, err)
+	}
+	c.files[metaName] = struct{}{}
+	for _, name := range sideFiles {
+		c.files[path.Join(dir, name)] = struct{}{}
+	}
+
+	for _, f := range meta.Files {
+		c.files[path.Join(dir, f.Name)] = struct{}{}
+	}
+	for _, index := range []*backuppb.MetaFile{meta.FileIndex, meta.SchemaIndex, meta.DdlIndexes} {
+		if err = c.collectMetaFile(ctx, dir, index); err != nil {
+			return errors.Trace(err)
+		}
+	}
+
+	// the data files of the per-database backups are in the sub directories,
+	// which have their own backupmetas.
+	if dir != "" {
+		return nil
+	}
+	subDirs := make(map[string]struct{})
+	for name := range c.files {
+		if sub := path.Dir(name); sub != "." {
+			subDirs[sub] = struct{}{}
+		}
+	}
+	for sub := range subDirs {
+		exists, err := c.storage.FileExists(ctx, path.Join(sub, MetaFile))
+		if err != nil {
+			return errors.Trace(err)
+		}
+		if !exists {
+			continue
+		}
+		if err = c.collect(ctx, sub); err != nil {
+			return errors.Trace(err)
+		}
+	}
+	return nil
+}
+
+// collectMetaFile adds the data files of the metafile and the files of its
+// children. The missing children are skipped, since they may have been
+// deleted by an interrupted cleanup after their data files.
+func (c *backupFileCollector) collectMetaFile(ctx context.Context, dir string, file *backuppb.MetaFile) error {
+	if file == nil {
+		return nil
+	}
+	for _, f := range file.DataFiles {
+		c.files[path.Join(dir, f.Name)] = struct{}{}
+	}
+	for _, node := range file.MetaFiles {
+		name := path.Join(dir, node.Name)
+		c.files[name] = struct{}{}
+		exists, err := c.storage.FileExists(ctx, name)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		if !exists {
+			log.Warn("metafile not found, the files referenced by it are skipped", zap.String("name", name))
+			continue
+		}
+		content, err := c.storage.ReadFile(ctx, name)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		checksum := sha256.Sum256(content)
+		if !bytes.Equal(node.Sha256, checksum[:]) {
+			return errors.Annotatef(berrors.ErrInvalidMetaFile,
+				"checksum mismatch of %s expect %x, got %x", name, node.Sha256, checksum[:])
+		}
+		child := &backuppb.MetaFile{}
+		if err = proto.Unmarshal(content, child); err != nil {
+			return errors.Trace(err)
+		}
+		if err = c.collectMetaFile(ctx, dir, child); err != nil {
+			return errors.Trace(err)
+		}
+	}
+	return nil
+}
+
+// CheckDataFiles checks the data files referenced by the backupmeta exist in
+// the storage and aren't empty. The writes of TiKV to the shared file system
+// may be lost silently, e.g. by the close-to-open consistency of NFS, while
+// the backup succeeds.
+func CheckDataFiles(ctx context.Context, s storage.ExternalStorage, backupMeta *backuppb.BackupMeta) error {
+	sizes, err := fileSizes(ctx, s)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	var missing []string
+	err = NewMetaReader(backupMeta, s).readDataFiles(ctx, func(file *backuppb.File) {
+		if size, ok := sizes[file.Name]; !ok || (size == 0 && file.Size_ > 0) {
+			missing = append(missing, file.Name)
+		}
+	})
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if len(missing) > 0 {
+		log.Error("data files missing from the storage", zap.Strings("files", missing))
+		return errors.Annotatef(berrors.ErrBackupMissingFile,
+			"%d data files are missing or empty in %s, e.g. %s", len(missing), s.URI(), missing[0])
+	}
+	return nil
+}
@@ -0,0 +1,122 @@
+// Copyright 2021 PingCAP, Inc. Licensed under Apache-2.0.
+
+package metautil
+
+import (
+	"context"
+	"crypto/sha256"
+
+	"github.com/gogo/protobuf/proto"
+	. "github.com/pingcap/check"
+	backuppb "github.com/pingcap/kvproto/pkg/backup"
+
+	"github.com/pingcap/br/pkg/storage"
+)
+
+func (m *metaSuit) TestBackupFiles(c *C) {
+	ctx := context.Background()
+	s, err := storage.NewLocalStorage(c.MkDir())
+	c.Assert(err, IsNil)
+
+	_, err = BackupFiles(ctx, s)
+	c.Assert(err, ErrorMatches, ".*backupmeta not found.*")
+
+	index := &backuppb.MetaFile{DataFiles: []*backuppb.File{{Name: "2.sst"}}}
+	indexData, err := proto.Marshal(index)
+	c.Assert(err, IsNil)
+	c.Assert(s.WriteFile(ctx, "backupmeta.datafile.000000001", indexData), IsNil)
+	checksum := sha256.Sum256(indexData)
+	meta := &backuppb.BackupMeta{
+		Files: []*backuppb.File{{Name: "1.sst"}, {Name: "db1/3.sst"}},
+		FileIndex: &backuppb.MetaFile{
+			MetaFiles: []*backuppb.File{{Name: "backupmeta.datafile.000000001", Sha256: checksum[:]}},
+		},
+	}
+	metaData, err := proto.Marshal(meta)
+	c.Assert(err, IsNil)
+	c.Assert(s.WriteFile(ctx, MetaFile, metaData), IsNil)
+	subMeta := &backuppb.BackupMeta{Files: []*backuppb.File{{Name: "3.sst"}, {Name: "4.sst"}}}
+	subMetaData, err := proto.Marshal(subMeta)
+	c.Assert(err, IsNil)
+	c.Assert(s.WriteFile(ctx, "db1/backupmeta", subMetaData), IsNil)
+
+	files, err := BackupFiles(ctx, s)
+	c.Assert(err, IsNil)
+	c.Assert(files, DeepEquals, []string{
+		"1.sst",
+		"2.sst",
+		"SHA256SUMS",
+		"backup.lock",
+		"backup_features.json",
+		"backupmeta.datafile.000000001",
+		"backupmeta.json",
+		"backupmeta_rebuilt",
+		"base_backup.json",
+		"cluster_topology.json",
+		"db1/3.sst",
+		"db1/4.sst",
+		"db1/SHA256SUMS",
+		"db1/backup.lock",
+		"db1/backup_features.json",
+		"db1/backupmeta.json",
+		"db1/backupmeta_rebuilt",
+		"db1/base_backup.json",
+		"db1/cluster_topology.json",
+		"db1/encryption.json",
+		"db1/file_refs.json",
+		"db1/partial_report.json",
+		"db1/placement_rules.json",
+		"db1/region_topology.json",
+		"db1/retention.json",
+		"db1/skipped_indexes.json",
+		"db1/verify_queries.json",
+		"encryption.json",
+		"file_refs.json",
+		"partial_report.json",
+		"placement_rules.json",
+		"region_topology.json",
+		"retention.json",
+		"skipped_indexes.json",
+		"verify_queries.json",
+		"db1/backupmeta",
+		"backupmeta",
+	})
+
+	// the missing metafile is skipped, the corrupted one is rejected.
+	c.Assert(s.DeleteFile(ctx, "backupmeta.datafile.000000001"), IsNil)
+	files, err = BackupFiles(ctx, s)
+	c.Assert(err, IsNil)
+	c.Assert(files, HasLen, 36)
+	c.Assert(s.WriteFile(ctx, "backupmeta.datafile.000000001", []byte("corrupted")), IsNil)
+	_, err = BackupFiles(ctx, s)
+	c.Assert(err, ErrorMatches, ".*checksum mismatch.*")
+}
+
+func (m *metaSuit) TestCheckDataFiles(c *C) {
+	ctx := context.Background()
+	s, err := storage.NewLocalStorage(c.MkDir())
+	c.Assert(err, IsNil)
+
+	index := &backuppb.MetaFile{DataFiles: []*backuppb.File{{Name: "2.sst", Size_: 3}}}
+	indexData, err := proto.Marshal(index)
+	c.Assert(err, IsNil)
+	c.Assert(s.WriteFile(ctx, "backupmeta.datafile.000000001", indexData), IsNil)
+	checksum := sha256.Sum256(indexData)
+	meta := &backuppb.BackupMeta{
+		Files: []*backuppb.File{{Name: "1.sst", Size_: 3}},
+		FileIndex: &backuppb.MetaFile{
+			MetaFiles: []*backuppb.File{{Name: "backupmeta.datafile.000000001", Sha256: checksum[:]}},
+		},
+	}
+	c.Assert(s.WriteFile(ctx, "1.sst", []byte("sst")), IsNil)
+
+	err = CheckDataFiles(ctx, s, meta)
+	c.Assert(err, ErrorMatches, ".*1 data files are missing or empty.*2.sst.*")
+
+	c.Assert(s.WriteFile(ctx, "2.sst", nil), IsNil)
+	err = CheckDataFiles(ctx, s, meta)
+	c.Assert(err, ErrorMatches, ".*1 data files are missing or empty.*2.sst.*")
+
+	c.Assert(s.WriteFile(ctx, "2.sst", []byte("sst")), IsNil)
+	c.Assert(CheckDataFiles(ctx, s, meta), IsNil)
+}
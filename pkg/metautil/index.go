@@ -0,0 +1,129 @@
+// Copyright 2021 PingCAP, Inc. Licensed under Apache-2.0.
+
+package metautil
+
+import (
+	"context"
+	"encoding/json"
+	"sort"
+	"time"
+
+	"github.com/pingcap/errors"
+	"github.com/pingcap/log"
+	"go.uber.org/zap"
+
+	berrors "github.com/pingcap/br/pkg/errors"
+	"github.com/pingcap/br/pkg/storage"
+)
+
+// BackupIndexFile is the name of the file in the parent directory of the
+// backups, which records the versions of the backups in its sub directories,
+// so the next incremental backup can find the end version of the last one.
+// The backups laid out by a path template are indexed in the root of the
+// template instead.
+const BackupIndexFile = "backup.index"
+
+// BackupIndexEntry is a backup recorded in the index.
+type BackupIndexEntry struct {
+	// Name is the name of the sub directory of the backup, or the rendered
+	// path of the backup laid out by PathTemplate.
+	Name         string    `json:"name"`
+	StartVersion uint64    `json:"start-version"`
+	EndVersion   uint64    `json:"end-version"`
+	Time         time.Time `json:"time"`
+	// PathTemplate is the template laying out the backup, empty if the
+	// backup is in a direct sub directory.
+	PathTemplate PathTemplate `json:"path-template,omitempty"`
+	// KeyID and KeyVersion are the master key the backup is encrypted by,
+	// empty if the backup isn't encrypted or its key isn't recorded.
+	KeyID      string `json:"key-id,omitempty"`
+	KeyVersion int    `json:"key-version,omitempty"`
+}
+
+// BackupIndex is the index of the backups in the sub directories of a
+// storage, sorted by their end versions.
+type BackupIndex struct {
+	Backups []BackupIndexEntry `json:"backups"`
+}
+
+// ReadBackupIndex reads the index from the storage. It returns an empty index
+// if the storage has no index.
+func ReadBackupIndex(ctx context.Context, s storage.ExternalStorage) (*BackupIndex, error) {
+	index := &BackupIndex{}
+	exists, err := s.FileExists(ctx, BackupIndexFile)
+	if err != nil || !exists {
+		return index, errors.Trace(err)
+	}
+	data, err := s.ReadFile(ctx, BackupIndexFile)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	if err = json.Unmarshal(data, index); err != nil {
+		return nil, errors.Annotatef(berrors.ErrInvalidMetaFile, "failed to parse %s: %v", BackupIndexFile, err)
+	}
+	return index, nil
+}
+
+// Add records the backup in the index, replacing the one of the same name.
+func (index *BackupIndex) Add(entry BackupIndexEntry) {
+	backups := index.Backups[:0]
+	for _, backup := range index.Backups {
+		if backup.Name != entry.Name {
+			backups = append(backups, backup)
+		}
+	}
+	index.Backups = append(backups, entry)
+	sort.SliceStable(index.Backups, func(i, j int) bool {
+		return index.Backups[i].EndVersion < index.Backups[j].EndVersion
+	})
+}
+
+// Last returns the backup of the max end version, nil if the index is empty.
+func (index *BackupIndex) Last() *BackupIndexEntry {
+	if len(index.Backups) == 0 {
+		return nil
+	}
+	return &index.Backups[len(index.Backups)-1]
+}
+
+// Find returns the last backup of the end version, nil if not found.
+func (index *BackupIndex) Find(endVersion uint64) *BackupIndexEntry {
+	for i := len(index.Backups) - 1; i >= 0; i-- {
+		if index.Backups[i].EndVersion == endVersion {
+			return &index.Backups[i]
+		}
+	}
+	return nil
+}
+
+// Chain returns the backup of the end version and its bases back to the full
+// backup, the newest first. The chain stops at the base not in the index.
+func (index *BackupIndex) Chain(endVersion uint64) []BackupIndexEntry {
+	var chain []BackupIndexEntry
+	for entry := index.Find(endVersion); entry != nil; entry = index.Find(entry.StartVersion) {
+		chain = append(chain, *entry)
+		if entry.StartVersion == 0 || entry.StartVersion >= entry.EndVersion {
+			break
+		}
+	}
+	return chain
+}
+
+// RecordBackupIndex adds the backup to the index in the storage.
+func RecordBackupIndex(ctx context.Context, s storage.ExternalStorage, entry BackupIndexEntry) error {
+	index, err := ReadBackupIndex(ctx, s)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	index.Add(entry)
+	data, err := json.Marshal(index)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if err = s.WriteFile(ctx, BackupIndexFile, data); err != nil {
+		return errors.Trace(err)
+	}
+	log.Info("backup index recorded", zap.String("name", entry.Name),
+		zap.Uint64("start-version", entry.StartVersion), zap.Uint64("end-version", entry.EndVersion))
+	return nil
+}
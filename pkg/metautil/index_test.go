@@ -0,0 +1,52 @@
+// Copyright 2021 PingCAP, Inc. Licensed under Apache-2.0.
+
+package metautil
+
+import (
+	"context"
+
+	. "github.com/pingcap/check"
+
+	"github.com/pingcap/br/pkg/storage"
+)
+
+func (m *metaSuit) TestBackupIndex(c *C) {
+	ctx := context.Background()
+	s, err := storage.NewLocalStorage(c.MkDir())
+	c.Assert(err, IsNil)
+
+	index, err := ReadBackupIndex(ctx, s)
+	c.Assert(err, IsNil)
+	c.Assert(index.Last(), IsNil)
+
+	c.Assert(RecordBackupIndex(ctx, s, BackupIndexEntry{Name: "full", EndVersion: 100}), IsNil)
+	c.Assert(RecordBackupIndex(ctx, s, BackupIndexEntry{Name: "inc2", StartVersion: 200, EndVersion: 300}), IsNil)
+	c.Assert(RecordBackupIndex(ctx, s, BackupIndexEntry{Name: "inc1", StartVersion: 100, EndVersion: 200}), IsNil)
+	index, err = ReadBackupIndex(ctx, s)
+	c.Assert(err, IsNil)
+	c.Assert(index.Backups, HasLen, 3)
+	c.Assert(index.Last().Name, Equals, "inc2")
+	c.Assert(index.Last().EndVersion, Equals, uint64(300))
+
+	// the backup written to the same directory again replaces the old one.
+	c.Assert(RecordBackupIndex(ctx, s, BackupIndexEntry{Name: "inc2", StartVersion: 200, EndVersion: 400}), IsNil)
+	index, err = ReadBackupIndex(ctx, s)
+	c.Assert(err, IsNil)
+	c.Assert(index.Backups, HasLen, 3)
+	c.Assert(index.Last().EndVersion, Equals, uint64(400))
+	c.Assert(index.Find(200).Name, Equals, "inc1")
+	c.Assert(index.Find(300), IsNil)
+
+	chain := index.Chain(400)
+	c.Assert(chain, HasLen, 3)
+	c.Assert([]string{chain[0].Name, chain[1].Name, chain[2].Name}, DeepEquals, []string{"inc2", "inc1", "full"})
+	c.Assert(index.Chain(300), HasLen, 0)
+	c.Assert(RecordBackupIndex(ctx, s, BackupIndexEntry{Name: "inc3", StartVersion: 500, EndVersion: 600}), IsNil)
+	index, err = ReadBackupIndex(ctx, s)
+	c.Assert(err, IsNil)
+	c.Assert(index.Chain(600), HasLen, 1)
+
+	c.Assert(s.WriteFile(ctx, BackupIndexFile, []byte("{")), IsNil)
+	_, err = ReadBackupIndex(ctx, s)
+	c.Assert(err, ErrorMatches, ".*failed to parse.*")
+}
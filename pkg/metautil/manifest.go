@@ -0,0 +1,253 @@
+// Copyright 2021 PingCAP, Inc. Licensed under Apache-2.0.
+
+package metautil
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/pingcap/errors"
+	backuppb "github.com/pingcap/kvproto/pkg/backup"
+	"github.com/pingcap/log"
+	"go.uber.org/zap"
+	"golang.org/x/sync/errgroup"
+
+	berrors "github.com/pingcap/br/pkg/errors"
+	"github.com/pingcap/br/pkg/storage"
+)
+
+// ManifestFile is the name of the checksum manifest, which lists the sha256
+// digests of all objects of the backup in the format of sha256sum.
+const ManifestFile = "SHA256SUMS"
+
+// ManifestEntry is a line of the checksum manifest.
+type ManifestEntry struct {
+	Name   string
+	Sha256 []byte
+}
+
+// ManifestVerifyResult is the result of verifying a backup with its manifest.
+type ManifestVerifyResult struct {
+	// Verified is the number of the objects whose digests match the manifest.
+	Verified int
+	// Mismatched are the objects whose digests don't match the manifest.
+	Mismatched []string
+	// Missing are the objects listed in the manifest but not in the storage.
+	Missing []string
+	// Unlisted are the objects in the storage but not listed in the manifest.
+	Unlisted []string
+}
+
+// WriteChecksumManifest computes the digests of all objects in the storage
+// and writes the manifest. The digests of the data files are taken from the
+// backupmeta to avoid reading them again.
+func WriteChecksumManifest(
+	ctx context.Context,
+	s storage.ExternalStorage,
+	backupMeta *backuppb.BackupMeta,
+	concurrency uint,
+) error {
+	known := make(map[string][]byte)
+	reader := NewMetaReader(backupMeta, s)
+	err := reader.readDataFiles(ctx, func(file *backuppb.File) {
+		if len(file.Sha256) > 0 {
+			known[file.Name] = file.Sha256
+		}
+	})
+	if err != nil {
+		return errors.Trace(err)
+	}
+
+	names, err := listObjects(ctx, s)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	entries := make([]ManifestEntry, len(names))
+	err = forEachObject(ctx, names, concurrency, func(ctx context.Context, i int, name string) error {
+		entries[i].Name = name
+		if sum, ok := known[name]; ok {
+			entries[i].Sha256 = sum
+			return nil
+		}
+		sum, err := objectSha256(ctx, s, name)
+		entries[i].Sha256 = sum
+		return errors.Trace(err)
+	})
+	if err != nil {
+		return errors.Trace(err)
+	}
+
+	if err = s.WriteFile(ctx, ManifestFile, EncodeManifest(entries)); err != nil {
+		return errors.Trace(err)
+	}
+	log.Info("checksum manifest written", zap.Int("objects", len(entries)))
+	return nil
+}
+
+// VerifyChecksumManifest reads all objects listed in the manifest, and checks
+// their digests against the manifest.
+func VerifyChecksumManifest(
+	ctx context.Context,
+	s storage.ExternalStorage,
+	concurrency uint,
+) (*ManifestVerifyResult, error) {
+	data, err := s.ReadFile(ctx, ManifestFile)
+	if err != nil {
+		return nil, errors.Annotatef(err, "failed to read the checksum manifest %s", ManifestFile)
+	}
+	entries, err := DecodeManifest(data)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	names, err := listObjects(ctx, s)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	exists := make(map[string]struct{}, len(names))
+	for _, name := range names {
+		exists[name] = struct{}{}
+	}
+
+	result := &ManifestVerifyResult{}
+	var mu sync.Mutex
+	listed := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		listed = append(listed, entry.Name)
+	}
+	err = forEachObject(ctx, listed, concurrency, func(ctx context.Context, i int, name string) error {
+		if _, ok := exists[name]; !ok {
+			mu.Lock()
+			result.Missing = append(result.Missing, name)
+			mu.Unlock()
+			return nil
+		}
+		sum, err := objectSha256(ctx, s, name)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		if !bytes.Equal(sum, entries[i].Sha256) {
+			log.Error("object checksum mismatch", zap.String("name", name),
+				zap.String("calculated", hex.EncodeToString(sum)),
+				zap.String("expected", hex.EncodeToString(entries[i].Sha256)))
+			result.Mismatched = append(result.Mismatched, name)
+			return nil
+		}
+		result.Verified++
+		return nil
+	})
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	for _, entry := range entries {
+		delete(exists, entry.Name)
+	}
+	for name := range exists {
+		result.Unlisted = append(result.Unlisted, name)
+	}
+	sort.Strings(result.Mismatched)
+	sort.Strings(result.Missing)
+	sort.Strings(result.Unlisted)
+	if len(result.Mismatched) > 0 || len(result.Missing) > 0 {
+		return result, errors.Annotatef(berrors.ErrBackupChecksumMismatch,
+			"%d objects mismatched and %d objects missing", len(result.Mismatched), len(result.Missing))
+	}
+	return result, nil
+}
+
+// EncodeManifest encodes the entries in the format of sha256sum.
+func EncodeManifest(entries []ManifestEntry) []byte {
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name < entries[j].Name })
+	var buf bytes.Buffer
+	for _, entry := range entries {
+		fmt.Fprintf(&buf, "%s  %s\n", hex.EncodeToString(entry.Sha256), entry.Name)
+	}
+	return buf.Bytes()
+}
+
+// DecodeManifest decodes the manifest in the format of sha256sum.
+func DecodeManifest(data []byte) ([]ManifestEntry, error) {
+	var entries []ManifestEntry
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for line := 1; scanner.Scan(); line++ {
+		text := scanner.Text()
+		if text == "" {
+			continue
+		}
+		parts := strings.SplitN(text, "  ", 2)
+		if len(parts) != 2 || parts[1] == "" {
+			return nil, errors.Annotatef(berrors.ErrInvalidArgument, "invalid manifest line %d: %q", line, text)
+		}
+		sum, err := hex.DecodeString(parts[0])
+		if err != nil || len(sum) != sha256.Size {
+			return nil, errors.Annotatef(berrors.ErrInvalidArgument, "invalid sha256 at manifest line %d: %q", line, parts[0])
+		}
+		entries = append(entries, ManifestEntry{Name: parts[1], Sha256: sum})
+	}
+	return entries, errors.Trace(scanner.Err())
+}
+
+// listObjects lists all objects in the storage except the manifest.
+func listObjects(ctx context.Context, s storage.ExternalStorage) ([]string, error) {
+	var names []string
+	err := s.WalkDir(ctx, &storage.WalkOption{}, func(name string, _ int64) error {
+		if name != ManifestFile {
+			names = append(names, name)
+		}
+		return nil
+	})
+	return names, errors.Trace(err)
+}
+
+func objectSha256(ctx context.Context, s storage.ExternalStorage, name string) ([]byte, error) {
+	reader, err := s.Open(ctx, name)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	defer reader.Close()
+	h := sha256.New()
+	if _, err = io.Copy(h, reader); err != nil {
+		return nil, errors.Annotatef(err, "failed to read %s", name)
+	}
+	return h.Sum(nil), nil
+}
+
+// forEachObject calls fn on the objects with at most `concurrency` goroutines,
+// and returns the first error.
+func forEachObject(
+	ctx context.Context,
+	names []string,
+	concurrency uint,
+	fn func(ctx context.Context, i int, name string) error,
+) error {
+	if concurrency == 0 {
+		concurrency = 1
+	}
+	eg, ectx := errgroup.WithContext(ctx)
+	workers := make(chan struct{}, concurrency)
+	for i, name := range names {
+		i, name := i, name
+		select {
+		case <-ectx.Done():
+			if err := eg.Wait(); err != nil {
+				return errors.Trace(err)
+			}
+			return errors.Trace(ctx.Err())
+		case workers <- struct{}{}:
+		}
+		eg.Go(func() error {
+			defer func() { <-workers }()
+			return fn(ectx, i, name)
+		})
+	}
+	return errors.Trace(eg.Wait())
+}
@@ -0,0 +1,70 @@
+// Copyright 2021 PingCAP, Inc. Licensed under Apache-2.0.
+
+package metautil
+
+import (
+	"context"
+	"crypto/sha256"
+	"os"
+	"path/filepath"
+
+	. "github.com/pingcap/check"
+	"github.com/pingcap/errors"
+	backuppb "github.com/pingcap/kvproto/pkg/backup"
+
+	berrors "github.com/pingcap/br/pkg/errors"
+	"github.com/pingcap/br/pkg/storage"
+)
+
+func (m *metaSuit) TestChecksumManifest(c *C) {
+	ctx := context.Background()
+	dir := c.MkDir()
+	s, err := storage.NewLocalStorage(dir)
+	c.Assert(err, IsNil)
+
+	files := map[string]string{
+		"1.sst":      "data1",
+		"2.sst":      "data2",
+		"backupmeta": "meta",
+	}
+	for name, content := range files {
+		c.Assert(s.WriteFile(ctx, name, []byte(content)), IsNil)
+	}
+	sum := sha256.Sum256([]byte("data1"))
+	backupMeta := &backuppb.BackupMeta{
+		Files: []*backuppb.File{{Name: "1.sst", Sha256: sum[:]}},
+	}
+	c.Assert(WriteChecksumManifest(ctx, s, backupMeta, 2), IsNil)
+
+	data, err := s.ReadFile(ctx, ManifestFile)
+	c.Assert(err, IsNil)
+	entries, err := DecodeManifest(data)
+	c.Assert(err, IsNil)
+	c.Assert(entries, HasLen, 3)
+	for _, entry := range entries {
+		sum := sha256.Sum256([]byte(files[entry.Name]))
+		c.Assert(entry.Sha256, DeepEquals, sum[:])
+	}
+	c.Assert(EncodeManifest(entries), DeepEquals, data)
+
+	result, err := VerifyChecksumManifest(ctx, s, 2)
+	c.Assert(err, IsNil)
+	c.Assert(result.Verified, Equals, 3)
+
+	c.Assert(s.WriteFile(ctx, "1.sst", []byte("corrupted")), IsNil)
+	c.Assert(os.Remove(filepath.Join(dir, "2.sst")), IsNil)
+	c.Assert(s.WriteFile(ctx, "3.sst", []byte("data3")), IsNil)
+	result, err = VerifyChecksumManifest(ctx, s, 2)
+	c.Assert(errors.Cause(err), Equals, berrors.ErrBackupChecksumMismatch)
+	c.Assert(result.Verified, Equals, 1)
+	c.Assert(result.Mismatched, DeepEquals, []string{"1.sst"})
+	c.Assert(result.Missing, DeepEquals, []string{"2.sst"})
+	c.Assert(result.Unlisted, DeepEquals, []string{"3.sst"})
+}
+
+func (m *metaSuit) TestDecodeInvalidManifest(c *C) {
+	_, err := DecodeManifest([]byte("abcd 1.sst\n"))
+	c.Assert(err, ErrorMatches, ".*invalid manifest line 1.*")
+	_, err = DecodeManifest([]byte("abcd  1.sst\n"))
+	c.Assert(err, ErrorMatches, ".*invalid sha256 at manifest line 1.*")
+}
@@ -8,6 +8,8 @@ import (
 	"crypto/sha256"
 	"encoding/json"
 	"fmt"
+	"path"
+	"sort"
 	"sync"
 	"time"
 
@@ -102,6 +104,11 @@ func (tbl *Table) NoChecksum() bool {
 type MetaReader struct {
 	storage    storage.ExternalStorage
 	backupMeta *backuppb.BackupMeta
+
+	// base is the reader of the base backup of the data only incremental
+	// backup, see SetBase.
+	base       *MetaReader
+	baseTables map[int64]struct{}
 }
 
 // NewMetaReader creates MetaReader.
@@ -129,6 +136,13 @@ func (reader *MetaReader) readDDLs(ctx context.Context, output func([]byte)) err
 }
 
 func (reader *MetaReader) readSchemas(ctx context.Context, output func(*backuppb.Schema)) error {
+	if reader.base != nil {
+		return errors.Trace(reader.readSchemasWithBase(ctx, output))
+	}
+	return errors.Trace(reader.readOwnSchemas(ctx, output))
+}
+
+func (reader *MetaReader) readOwnSchemas(ctx context.Context, output func(*backuppb.Schema)) error {
 	// Read backupmeta v1 metafiles.
 	for _, s := range reader.backupMeta.Schemas {
 		output(s)
@@ -165,6 +179,16 @@ func (reader *MetaReader) ArchiveSize(ctx context.Context, files []*backuppb.Fil
 	return total
 }
 
+// ReadDataFiles reads all data files from the backupmeta.
+// This function is compatible with the old backupmeta.
+func (reader *MetaReader) ReadDataFiles(ctx context.Context) ([]*backuppb.File, error) {
+	var files []*backuppb.File
+	err := reader.readDataFiles(ctx, func(file *backuppb.File) {
+		files = append(files, file)
+	})
+	return files, errors.Trace(err)
+}
+
 // ReadDDLs reads the ddls from the backupmeta.
 // This function is compatible with the old backupmeta.
 func (reader *MetaReader) ReadDDLs(ctx context.Context) ([]byte, error) {
@@ -206,6 +230,58 @@ func (reader *MetaReader) ReadDDLs(ctx context.Context) ([]byte, error) {
 	}
 }
 
+// MergeSubMetas writes the data files and schemas of the backupmetas in the
+// sub directories to the writer, so that the root backupmeta covers the
+// backups of all sub directories. The data files are renamed with the prefix
+// of their sub directories.
+func MergeSubMetas(ctx context.Context, writer *MetaWriter, subs map[string]*MetaReader) error {
+	dirs := make([]string, 0, len(subs))
+	for dir := range subs {
+		dirs = append(dirs, dir)
+	}
+	sort.Strings(dirs)
+
+	writer.StartWriteMetasAsync(ctx, AppendDataFile)
+	for _, dir := range dirs {
+		var sendErr error
+		err := subs[dir].readDataFiles(ctx, func(file *backuppb.File) {
+			if sendErr != nil {
+				return
+			}
+			f := *file
+			f.Name = path.Join(dir, file.Name)
+			sendErr = writer.Send([]*backuppb.File{&f}, AppendDataFile)
+		})
+		if err != nil {
+			return errors.Trace(err)
+		}
+		if sendErr != nil {
+			return errors.Trace(sendErr)
+		}
+	}
+	if err := writer.FinishWriteMetas(ctx, AppendDataFile); err != nil {
+		return errors.Trace(err)
+	}
+
+	writer.StartWriteMetasAsync(ctx, AppendSchema)
+	for _, dir := range dirs {
+		var sendErr error
+		err := subs[dir].readSchemas(ctx, func(schema *backuppb.Schema) {
+			if sendErr != nil {
+				return
+			}
+			sendErr = writer.Send(schema, AppendSchema)
+		})
+		if err != nil {
+			return errors.Trace(err)
+		}
+		if sendErr != nil {
+			return errors.Trace(sendErr)
+		}
+	}
+	return writer.FinishWriteMetas(ctx, AppendSchema)
+}
+
 // ReadSchemasFiles reads the schema and datafiles from the backupmeta.
 // This function is compatible with the old backupmeta.
 func (reader *MetaReader) ReadSchemasFiles(ctx context.Context, output chan<- *Table) error {
@@ -12,6 +12,7 @@ import (
 	backuppb "github.com/pingcap/kvproto/pkg/backup"
 
 	mockstorage "github.com/pingcap/br/pkg/mock/storage"
+	"github.com/pingcap/br/pkg/storage"
 )
 
 type metaSuit struct{}
@@ -133,3 +134,72 @@ func (m *metaSuit) TestWalkMetaFile(c *C) {
 		c.Assert(files[i], DeepEquals, expect[i])
 	}
 }
+
+func (m *metaSuit) TestMergeSubMetas(c *C) {
+	ctx := context.Background()
+	dir := c.MkDir()
+	root, err := storage.NewLocalStorage(dir)
+	c.Assert(err, IsNil)
+
+	subs := make(map[string]*MetaReader)
+	for _, db := range []string{"db2", "db1"} {
+		subs[db] = NewMetaReader(&backuppb.BackupMeta{
+			Files:   []*backuppb.File{{Name: "1.sst"}, {Name: "2.sst"}},
+			Schemas: []*backuppb.Schema{{Db: []byte(db)}},
+		}, root)
+	}
+	writer := NewMetaWriter(root, MetaFileSize, false)
+	c.Assert(MergeSubMetas(ctx, writer, subs), IsNil)
+
+	meta := writer.Backupmeta()
+	names := make([]string, 0, len(meta.Files))
+	for _, file := range meta.Files {
+		names = append(names, file.Name)
+	}
+	c.Assert(names, DeepEquals, []string{"db1/1.sst", "db1/2.sst", "db2/1.sst", "db2/2.sst"})
+	c.Assert(meta.Schemas, HasLen, 2)
+	c.Assert(meta.Schemas[0].Db, DeepEquals, []byte("db1"))
+	// the sub metas are not changed.
+	c.Assert(subs["db1"].backupMeta.Files[0].Name, Equals, "1.sst")
+}
+
+func (m *metaSuit) TestConvertToMetaV2(c *C) {
+	ctx := context.Background()
+	s, err := storage.NewLocalStorage(c.MkDir())
+	c.Assert(err, IsNil)
+
+	v1 := &backuppb.BackupMeta{
+		EndVersion: 100,
+		Files:      []*backuppb.File{{Name: "1.sst", Size_: 10}, {Name: "2.sst", Size_: 10}, {Name: "3.sst", Size_: 10}},
+		Schemas:    []*backuppb.Schema{testSchema(10, "t1", 1), testSchema(11, "t2", 2)},
+		Ddls:       []byte(`[{"id":1},{"id":2}]`),
+	}
+	// every metafile holds at most 2 data files.
+	v2, err := ConvertToMetaV2(ctx, s, v1, 15)
+	c.Assert(err, IsNil)
+	c.Assert(v2.Version, Equals, int32(MetaV2))
+	c.Assert(v2.EndVersion, Equals, uint64(100))
+	c.Assert(v2.Files, HasLen, 0)
+	c.Assert(v2.FileIndex.MetaFiles, HasLen, 2)
+	c.Assert(CheckMetaVersion(v2), IsNil)
+
+	exists, err := s.FileExists(ctx, MetaV1File)
+	c.Assert(err, IsNil)
+	c.Assert(exists, IsTrue)
+
+	reader := NewMetaReader(v2, s)
+	var names []string
+	c.Assert(reader.readDataFiles(ctx, func(f *backuppb.File) { names = append(names, f.Name) }), IsNil)
+	c.Assert(names, DeepEquals, []string{"1.sst", "2.sst", "3.sst"})
+	var schemas int
+	c.Assert(reader.readSchemas(ctx, func(*backuppb.Schema) { schemas++ }), IsNil)
+	c.Assert(schemas, Equals, 2)
+	ddls, err := reader.ReadDDLs(ctx)
+	c.Assert(err, IsNil)
+	c.Assert(string(ddls), Equals, `[{"id":1},{"id":2}]`)
+
+	_, err = ConvertToMetaV2(ctx, s, v2, MetaFileSize)
+	c.Assert(err, ErrorMatches, ".*only the v1 backupmeta can be converted.*")
+	v2.Version = MaxMetaVersion + 1
+	c.Assert(CheckMetaVersion(v2), ErrorMatches, ".*please upgrade BR.*")
+}
@@ -0,0 +1,69 @@
+// Copyright 2021 PingCAP, Inc. Licensed under Apache-2.0.
+
+package metautil
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/pingcap/errors"
+	"github.com/pingcap/log"
+	"go.uber.org/zap"
+
+	berrors "github.com/pingcap/br/pkg/errors"
+	"github.com/pingcap/br/pkg/storage"
+)
+
+// PartialReportFile is the name of the report of the backup finished without
+// the ranges failed to be backed up, which are recorded by FailedRangesFile
+// as well so they can be filled later.
+const PartialReportFile = "partial_report.json"
+
+// MissingTable is a table whose data is partially missing from the backup.
+type MissingTable struct {
+	DB    string `json:"db"`
+	Table string `json:"table"`
+	// Ranges is the number of the missing ranges of the table.
+	Ranges int `json:"ranges"`
+}
+
+// PartialReport is the report of the data missing from the backup.
+type PartialReport struct {
+	EndVersion    uint64         `json:"end-version"`
+	Time          time.Time      `json:"time"`
+	MissingRanges []FailedRange  `json:"missing-ranges"`
+	Tables        []MissingTable `json:"tables"`
+}
+
+// WritePartialReport writes the report of the partial backup to the storage.
+func WritePartialReport(ctx context.Context, s storage.ExternalStorage, report *PartialReport) error {
+	data, err := json.Marshal(report)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if err = s.WriteFile(ctx, PartialReportFile, data); err != nil {
+		return errors.Trace(err)
+	}
+	log.Info("partial backup report written", zap.Int("missing-ranges", len(report.MissingRanges)),
+		zap.Int("tables", len(report.Tables)))
+	return nil
+}
+
+// ReadPartialReport reads the report of the partial backup from the storage.
+// It returns nil if the backup is complete.
+func ReadPartialReport(ctx context.Context, s storage.ExternalStorage) (*PartialReport, error) {
+	exists, err := s.FileExists(ctx, PartialReportFile)
+	if err != nil || !exists {
+		return nil, errors.Trace(err)
+	}
+	data, err := s.ReadFile(ctx, PartialReportFile)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	report := &PartialReport{}
+	if err = json.Unmarshal(data, report); err != nil {
+		return nil, errors.Annotatef(berrors.ErrInvalidMetaFile, "failed to parse %s: %v", PartialReportFile, err)
+	}
+	return report, nil
+}
@@ -0,0 +1,35 @@
+// Copyright 2021 PingCAP, Inc. Licensed under Apache-2.0.
+
+package metautil
+
+import (
+	"context"
+
+	. "github.com/pingcap/check"
+
+	"github.com/pingcap/br/pkg/storage"
+)
+
+func (m *metaSuit) TestPartialReport(c *C) {
+	ctx := context.Background()
+	s, err := storage.NewLocalStorage(c.MkDir())
+	c.Assert(err, IsNil)
+
+	report, err := ReadPartialReport(ctx, s)
+	c.Assert(err, IsNil)
+	c.Assert(report, IsNil)
+
+	expected := &PartialReport{
+		EndVersion:    100,
+		MissingRanges: []FailedRange{{StartKey: []byte("a"), EndKey: []byte("b"), Attempts: 4, Error: "corrupted"}},
+		Tables:        []MissingTable{{DB: "test", Table: "t", Ranges: 1}},
+	}
+	c.Assert(WritePartialReport(ctx, s, expected), IsNil)
+	report, err = ReadPartialReport(ctx, s)
+	c.Assert(err, IsNil)
+	c.Assert(report, DeepEquals, expected)
+
+	c.Assert(s.WriteFile(ctx, PartialReportFile, []byte("{")), IsNil)
+	_, err = ReadPartialReport(ctx, s)
+	c.Assert(err, ErrorMatches, ".*failed to parse.*")
+}
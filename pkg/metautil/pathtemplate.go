@@ -0,0 +1,129 @@
+// Copyright 2021 PingCAP, Inc. Licensed under Apache-2.0.
+
+package metautil
+
+import (
+	"context"
+	"encoding/json"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pingcap/errors"
+	"github.com/pingcap/log"
+	"go.uber.org/zap"
+
+	berrors "github.com/pingcap/br/pkg/errors"
+	"github.com/pingcap/br/pkg/storage"
+)
+
+// BackupPathFile is the name of the file of the backup laid out by a path
+// template, which records the template and the values of its placeholders.
+const BackupPathFile = "backup_path.json"
+
+// the placeholders of the path templates.
+const (
+	PlaceholderClusterID = "cluster_id"
+	PlaceholderDate      = "date"
+	PlaceholderTime      = "time"
+	PlaceholderBackupID  = "backup_id"
+)
+
+var placeholderPattern = regexp.MustCompile(`\{([^{}]*)\}`)
+
+// PathTemplate is the layout of the backups under the root of the storage,
+// e.g. "{cluster_id}/{date}/{backup_id}", the placeholders are resolved when
+// the backup starts.
+type PathTemplate string
+
+// Validate checks the placeholders and the directories of the template.
+func (t PathTemplate) Validate() error {
+	if t == "" {
+		return errors.Annotate(berrors.ErrInvalidArgument, "the path template is empty")
+	}
+	for _, dir := range strings.Split(string(t), "/") {
+		if dir == "" || dir == "." || dir == ".." {
+			return errors.Annotatef(berrors.ErrInvalidArgument,
+				"invalid path template %q, the directory %q isn't allowed", t, dir)
+		}
+	}
+	for _, match := range placeholderPattern.FindAllStringSubmatch(string(t), -1) {
+		switch match[1] {
+		case PlaceholderClusterID, PlaceholderDate, PlaceholderTime, PlaceholderBackupID:
+		default:
+			return errors.Annotatef(berrors.ErrInvalidArgument,
+				"unknown placeholder %q in the path template, should be one of '{%s}|{%s}|{%s}|{%s}'",
+				match[0], PlaceholderClusterID, PlaceholderDate, PlaceholderTime, PlaceholderBackupID)
+		}
+	}
+	if strings.ContainsAny(placeholderPattern.ReplaceAllString(string(t), ""), "{}") {
+		return errors.Annotatef(berrors.ErrInvalidArgument, "unbalanced braces in the path template %q", t)
+	}
+	return nil
+}
+
+// Depth returns the number of the directories of the template.
+func (t PathTemplate) Depth() int {
+	return strings.Count(string(t), "/") + 1
+}
+
+// Render resolves the placeholders by the values.
+func (t PathTemplate) Render(values map[string]string) string {
+	return placeholderPattern.ReplaceAllStringFunc(string(t), func(placeholder string) string {
+		return values[placeholder[1:len(placeholder)-1]]
+	})
+}
+
+// PathTemplateValues returns the values of the placeholders of a backup of
+// the cluster started at the time, the date and time are in UTC.
+func PathTemplateValues(clusterID uint64, now time.Time, backupID string) map[string]string {
+	now = now.UTC()
+	return map[string]string{
+		PlaceholderClusterID: strconv.FormatUint(clusterID, 10),
+		PlaceholderDate:      now.Format("20060102"),
+		PlaceholderTime:      now.Format("150405"),
+		PlaceholderBackupID:  backupID,
+	}
+}
+
+// BackupPath is the path of a backup laid out by a path template.
+type BackupPath struct {
+	Template PathTemplate `json:"template"`
+	// Path is the rendered template, which is the directory of the backup
+	// relative to the root of the template.
+	Path         string            `json:"path"`
+	Placeholders map[string]string `json:"placeholders"`
+}
+
+// WriteBackupPath records the path of the backup in the storage.
+func WriteBackupPath(ctx context.Context, s storage.ExternalStorage, backupPath *BackupPath) error {
+	data, err := json.Marshal(backupPath)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if err = s.WriteFile(ctx, BackupPathFile, data); err != nil {
+		return errors.Trace(err)
+	}
+	log.Info("backup path recorded", zap.String("template", string(backupPath.Template)),
+		zap.String("path", backupPath.Path))
+	return nil
+}
+
+// ReadBackupPath reads the path of the backup from the storage. It returns
+// nil if the backup isn't laid out by a path template.
+func ReadBackupPath(ctx context.Context, s storage.ExternalStorage) (*BackupPath, error) {
+	exists, err := s.FileExists(ctx, BackupPathFile)
+	if err != nil || !exists {
+		return nil, errors.Trace(err)
+	}
+	data, err := s.ReadFile(ctx, BackupPathFile)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	backupPath := &BackupPath{}
+	if err = json.Unmarshal(data, backupPath); err != nil {
+		return nil, errors.Annotatef(berrors.ErrInvalidMetaFile, "failed to parse %s: %v", BackupPathFile, err)
+	}
+	return backupPath, nil
+}
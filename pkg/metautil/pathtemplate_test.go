@@ -0,0 +1,47 @@
+// Copyright 2021 PingCAP, Inc. Licensed under Apache-2.0.
+
+package metautil
+
+import (
+	"context"
+	"time"
+
+	. "github.com/pingcap/check"
+
+	"github.com/pingcap/br/pkg/storage"
+)
+
+func (m *metaSuit) TestPathTemplate(c *C) {
+	tmpl := PathTemplate("{cluster_id}/{date}/full-{time}-{backup_id}")
+	c.Assert(tmpl.Validate(), IsNil)
+	c.Assert(tmpl.Depth(), Equals, 3)
+
+	now := time.Date(2021, 10, 1, 8, 30, 5, 0, time.FixedZone("UTC+8", 8*3600))
+	values := PathTemplateValues(6880, now, "abc")
+	c.Assert(tmpl.Render(values), Equals, "6880/20211001/full-003005-abc")
+	c.Assert(PathTemplate("backups").Render(values), Equals, "backups")
+
+	for _, invalid := range []string{"", "/{date}", "{date}/", "a//b", "../{date}", "{date}/.", "{day}", "{date", "date}"} {
+		c.Assert(PathTemplate(invalid).Validate(), NotNil, Commentf("%s", invalid))
+	}
+}
+
+func (m *metaSuit) TestBackupPath(c *C) {
+	ctx := context.Background()
+	s, err := storage.NewLocalStorage(c.MkDir())
+	c.Assert(err, IsNil)
+
+	backupPath, err := ReadBackupPath(ctx, s)
+	c.Assert(err, IsNil)
+	c.Assert(backupPath, IsNil)
+
+	expected := &BackupPath{
+		Template:     "{cluster_id}/{backup_id}",
+		Path:         "6880/abc",
+		Placeholders: map[string]string{PlaceholderClusterID: "6880", PlaceholderBackupID: "abc"},
+	}
+	c.Assert(WriteBackupPath(ctx, s, expected), IsNil)
+	backupPath, err = ReadBackupPath(ctx, s)
+	c.Assert(err, IsNil)
+	c.Assert(backupPath, DeepEquals, expected)
+}
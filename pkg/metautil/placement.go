@@ -0,0 +1,60 @@
+// Copyright 2021 PingCAP, Inc. Licensed under Apache-2.0.
+
+package metautil
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/pingcap/errors"
+	"github.com/pingcap/log"
+	"github.com/tikv/pd/server/schedule/placement"
+	"go.uber.org/zap"
+
+	berrors "github.com/pingcap/br/pkg/errors"
+	"github.com/pingcap/br/pkg/storage"
+)
+
+// PlacementRulesFile is the name of the file recording the placement rules
+// of the tables in PD when backing up.
+const PlacementRulesFile = "placement_rules.json"
+
+// TablePlacementRules is the placement rules of a table and its partitions.
+type TablePlacementRules struct {
+	DB      string `json:"db"`
+	Table   string `json:"table"`
+	TableID int64  `json:"table-id"`
+	// Rules are bound to the table or its partitions by their key ranges.
+	Rules []placement.Rule `json:"rules"`
+}
+
+// WritePlacementRules writes the placement rules of the tables to the storage.
+func WritePlacementRules(ctx context.Context, s storage.ExternalStorage, tables []TablePlacementRules) error {
+	data, err := json.Marshal(tables)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if err = s.WriteFile(ctx, PlacementRulesFile, data); err != nil {
+		return errors.Trace(err)
+	}
+	log.Info("placement rules written", zap.Int("tables", len(tables)))
+	return nil
+}
+
+// ReadPlacementRules reads the placement rules of the tables from the
+// storage. It returns nil if the backup doesn't record them.
+func ReadPlacementRules(ctx context.Context, s storage.ExternalStorage) ([]TablePlacementRules, error) {
+	exists, err := s.FileExists(ctx, PlacementRulesFile)
+	if err != nil || !exists {
+		return nil, errors.Trace(err)
+	}
+	data, err := s.ReadFile(ctx, PlacementRulesFile)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	var tables []TablePlacementRules
+	if err = json.Unmarshal(data, &tables); err != nil {
+		return nil, errors.Annotatef(berrors.ErrInvalidMetaFile, "failed to parse %s: %v", PlacementRulesFile, err)
+	}
+	return tables, nil
+}
@@ -0,0 +1,47 @@
+// Copyright 2021 PingCAP, Inc. Licensed under Apache-2.0.
+
+package metautil
+
+import (
+	"context"
+
+	. "github.com/pingcap/check"
+	"github.com/tikv/pd/server/schedule/placement"
+
+	"github.com/pingcap/br/pkg/storage"
+)
+
+func (m *metaSuit) TestPlacementRules(c *C) {
+	ctx := context.Background()
+	s, err := storage.NewLocalStorage(c.MkDir())
+	c.Assert(err, IsNil)
+
+	tables, err := ReadPlacementRules(ctx, s)
+	c.Assert(err, IsNil)
+	c.Assert(tables, IsNil)
+
+	expected := []TablePlacementRules{{
+		DB:      "test",
+		Table:   "t",
+		TableID: 42,
+		Rules: []placement.Rule{{
+			GroupID:     "TiDB_DDL_42",
+			ID:          "42_voter",
+			StartKeyHex: "7480000000000000ff2a00000000000000f8",
+			EndKeyHex:   "7480000000000000ff2b00000000000000f8",
+			Role:        placement.Voter,
+			Count:       3,
+			LabelConstraints: []placement.LabelConstraint{
+				{Key: "zone", Op: placement.In, Values: []string{"bj"}},
+			},
+		}},
+	}}
+	c.Assert(WritePlacementRules(ctx, s, expected), IsNil)
+	tables, err = ReadPlacementRules(ctx, s)
+	c.Assert(err, IsNil)
+	c.Assert(tables, DeepEquals, expected)
+
+	c.Assert(s.WriteFile(ctx, PlacementRulesFile, []byte("[")), IsNil)
+	_, err = ReadPlacementRules(ctx, s)
+	c.Assert(err, ErrorMatches, ".*failed to parse.*")
+}
@@ -0,0 +1,93 @@
+// Copyright 2021 PingCAP, Inc. Licensed under Apache-2.0.
+
+package metautil
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/pingcap/errors"
+
+	berrors "github.com/pingcap/br/pkg/errors"
+	"github.com/pingcap/br/pkg/storage"
+)
+
+// BackupProgressFile is the name of the file of the snapshot of the backup
+// progress, which is rewritten periodically during the backup, so the backup
+// can be monitored from other hosts by `br backup status`.
+const BackupProgressFile = "backup_progress.json"
+
+// the states of the backup progress.
+const (
+	ProgressRunning  = "running"
+	ProgressFinished = "finished"
+	ProgressFailed   = "failed"
+)
+
+// staleProgressIntervals is the number of the intervals after which the
+// snapshot of a running backup is stale, e.g. BR is killed.
+const staleProgressIntervals = 3
+
+// BackupProgress is a snapshot of the progress of a backup.
+type BackupProgress struct {
+	State string `json:"state"`
+	// Phase is the current phase of the backup, e.g. backing up the ranges
+	// and checksumming the tables.
+	Phase string `json:"phase"`
+	// Unit is the unit of Total and Completed, i.e. range or region.
+	Unit      string `json:"unit"`
+	Total     int64  `json:"total"`
+	Completed int64  `json:"completed"`
+	// Size is the size of the files of the backed up ranges.
+	Size       uint64    `json:"size"`
+	StartTime  time.Time `json:"start-time"`
+	UpdateTime time.Time `json:"update-time"`
+	// Interval is the interval of the snapshots.
+	Interval time.Duration `json:"interval"`
+	// ETA is the estimated time to back up the rest ranges, 0 if unknown.
+	ETA   time.Duration `json:"eta"`
+	Error string        `json:"error,omitempty"`
+}
+
+// IsStale checks whether the snapshot of the running backup isn't updated in
+// a few intervals, e.g. BR has been killed.
+func (p *BackupProgress) IsStale(now time.Time) bool {
+	return p.State == ProgressRunning && p.Interval > 0 && now.Sub(p.UpdateTime) > staleProgressIntervals*p.Interval
+}
+
+// EstimateETA estimates the time to complete the rest units by the rate of
+// the completed ones, 0 if nothing is completed yet.
+func EstimateETA(elapsed time.Duration, completed, total int64) time.Duration {
+	if completed <= 0 || total <= completed {
+		return 0
+	}
+	return time.Duration(float64(elapsed) / float64(completed) * float64(total-completed)).Round(time.Second)
+}
+
+// WriteBackupProgress writes the snapshot of the progress to the storage.
+func WriteBackupProgress(ctx context.Context, s storage.ExternalStorage, progress *BackupProgress) error {
+	data, err := json.Marshal(progress)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	return errors.Trace(s.WriteFile(ctx, BackupProgressFile, data))
+}
+
+// ReadBackupProgress reads the snapshot of the progress from the storage. It
+// returns nil if the backup has no snapshot, e.g. it's taken by old versions.
+func ReadBackupProgress(ctx context.Context, s storage.ExternalStorage) (*BackupProgress, error) {
+	exists, err := s.FileExists(ctx, BackupProgressFile)
+	if err != nil || !exists {
+		return nil, errors.Trace(err)
+	}
+	data, err := s.ReadFile(ctx, BackupProgressFile)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	progress := &BackupProgress{}
+	if err = json.Unmarshal(data, progress); err != nil {
+		return nil, errors.Annotatef(berrors.ErrInvalidMetaFile, "failed to parse %s: %v", BackupProgressFile, err)
+	}
+	return progress, nil
+}
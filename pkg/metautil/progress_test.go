@@ -0,0 +1,49 @@
+// Copyright 2021 PingCAP, Inc. Licensed under Apache-2.0.
+
+package metautil
+
+import (
+	"context"
+	"time"
+
+	. "github.com/pingcap/check"
+
+	"github.com/pingcap/br/pkg/storage"
+)
+
+func (m *metaSuit) TestBackupProgress(c *C) {
+	ctx := context.Background()
+	s, err := storage.NewLocalStorage(c.MkDir())
+	c.Assert(err, IsNil)
+
+	progress, err := ReadBackupProgress(ctx, s)
+	c.Assert(err, IsNil)
+	c.Assert(progress, IsNil)
+
+	now := time.Date(2021, 10, 1, 0, 0, 0, 0, time.UTC)
+	expected := &BackupProgress{
+		State:      ProgressRunning,
+		Phase:      "backup",
+		Unit:       "range",
+		Total:      10,
+		Completed:  4,
+		Size:       1024,
+		StartTime:  now.Add(-time.Minute),
+		UpdateTime: now,
+		Interval:   10 * time.Second,
+		ETA:        EstimateETA(time.Minute, 4, 10),
+	}
+	c.Assert(expected.ETA, Equals, 90*time.Second)
+	c.Assert(WriteBackupProgress(ctx, s, expected), IsNil)
+	progress, err = ReadBackupProgress(ctx, s)
+	c.Assert(err, IsNil)
+	c.Assert(progress, DeepEquals, expected)
+
+	c.Assert(progress.IsStale(now.Add(30*time.Second)), IsFalse)
+	c.Assert(progress.IsStale(now.Add(31*time.Second)), IsTrue)
+	progress.State = ProgressFinished
+	c.Assert(progress.IsStale(now.Add(time.Hour)), IsFalse)
+
+	c.Assert(EstimateETA(time.Minute, 0, 10), Equals, time.Duration(0))
+	c.Assert(EstimateETA(time.Minute, 10, 10), Equals, time.Duration(0))
+}
@@ -0,0 +1,320 @@
+// Copyright 2021 PingCAP, Inc. Licensed under Apache-2.0.
+
+package metautil
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/cockroachdb/pebble/sstable"
+	"github.com/cockroachdb/pebble/vfs"
+	"github.com/pingcap/errors"
+	backuppb "github.com/pingcap/kvproto/pkg/backup"
+	"github.com/pingcap/log"
+	"github.com/pingcap/parser/model"
+	"github.com/pingcap/tidb/tablecodec"
+	"github.com/pingcap/tidb/util/codec"
+	"go.uber.org/zap"
+
+	berrors "github.com/pingcap/br/pkg/errors"
+	"github.com/pingcap/br/pkg/logutil"
+	"github.com/pingcap/br/pkg/storage"
+)
+
+const (
+	// RebuiltMetaFile is the name of the backupmeta rebuilt from SST files.
+	RebuiltMetaFile = "backupmeta_rebuilt"
+	// RebuiltBrVersion is set as the BrVersion of the rebuilt backupmeta, so
+	// it can be told apart from the origin ones.
+	RebuiltBrVersion = "rebuilt by br debug rebuild-meta"
+
+	sstSuffix     = ".sst"
+	writeCFSuffix = "_write" + sstSuffix
+	dataKeyPrefix = 'z'
+	// tsLen is the length of the timestamp appended to the txn keys.
+	tsLen = 8
+)
+
+// The fields of the rebuilt backupmeta which can't be recovered from SST files.
+// They are either zero or estimated.
+var rebuiltUncertainFields = []string{
+	"ClusterId",
+	"ClusterVersion",
+	"StartVersion",
+	"EndVersion",
+	"Files.Crc64Xor",
+	"Files.TotalKvs",
+	"Files.TotalBytes",
+	"Files.EndKey",
+	"Schemas",
+}
+
+// RebuildOptions is the options of RebuildBackupMeta.
+type RebuildOptions struct {
+	// IsRawKv indicates the SST files are backed up by raw backup.
+	IsRawKv bool
+	// SchemaReference is the reader of another backupmeta of the same cluster,
+	// e.g. an earlier backup. The schemas of the tables found in the SST
+	// files are copied from it. Only raw restore is possible without it.
+	SchemaReference *MetaReader
+}
+
+// RebuildReport describes how the backupmeta is rebuilt.
+type RebuildReport struct {
+	// Files is the number of SST files added to the backupmeta.
+	Files int `json:"files"`
+	// SkippedFiles are the SST files which can't be parsed, with the reasons.
+	SkippedFiles map[string]string `json:"skipped-files,omitempty"`
+	// MissingSchemas are the ids of the tables found in the SST files but
+	// not in the schema reference. Their data can't be restored.
+	MissingSchemas []int64 `json:"missing-schemas,omitempty"`
+	// UncertainFields are the fields which are zero or estimated.
+	UncertainFields []string `json:"uncertain-fields"`
+}
+
+// RebuildBackupMeta scans the SST files in the storage, parses their key
+// ranges and properties, and reconstructs a best-effort backupmeta, which is
+// sufficient for raw restore, and for table restore if a schema reference is
+// provided. The checksum of the restored data can't be verified.
+func RebuildBackupMeta(
+	ctx context.Context,
+	s storage.ExternalStorage,
+	opts RebuildOptions,
+) (*backuppb.BackupMeta, *RebuildReport, error) {
+	report := &RebuildReport{
+		SkippedFiles:    make(map[string]string),
+		UncertainFields: rebuiltUncertainFields,
+	}
+	meta := &backuppb.BackupMeta{
+		BrVersion: RebuiltBrVersion,
+		IsRawKv:   opts.IsRawKv,
+		Ddls:      []byte("[]"),
+	}
+
+	err := s.WalkDir(ctx, &storage.WalkOption{}, func(name string, _ int64) error {
+		if !strings.HasSuffix(name, sstSuffix) {
+			return nil
+		}
+		data, err := s.ReadFile(ctx, name)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		file, maxTS, err := parseBackupFile(name, data, opts.IsRawKv)
+		if err != nil {
+			log.Warn("skip the SST file which can't be parsed", zap.String("file", name), zap.Error(err))
+			report.SkippedFiles[name] = err.Error()
+			return nil
+		}
+		meta.Files = append(meta.Files, file)
+		if maxTS > meta.EndVersion {
+			meta.EndVersion = maxTS
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, nil, errors.Trace(err)
+	}
+	if len(meta.Files) == 0 {
+		return nil, nil, errors.Annotate(berrors.ErrInvalidArgument, "no valid SST file found")
+	}
+	sort.Slice(meta.Files, func(i, j int) bool {
+		return bytes.Compare(meta.Files[i].StartKey, meta.Files[j].StartKey) < 0
+	})
+	for _, file := range meta.Files {
+		file.EndVersion = meta.EndVersion
+	}
+	report.Files = len(meta.Files)
+
+	if opts.IsRawKv {
+		meta.StartKey = meta.Files[0].StartKey
+		for _, file := range meta.Files {
+			if bytes.Compare(file.EndKey, meta.EndKey) > 0 {
+				meta.EndKey = file.EndKey
+			}
+		}
+		return meta, report, nil
+	}
+
+	meta.Schemas, report.MissingSchemas, err = rebuildSchemas(ctx, meta.Files, opts.SchemaReference)
+	if err != nil {
+		return nil, nil, errors.Trace(err)
+	}
+	return meta, report, nil
+}
+
+// parseBackupFile parses the key range and properties of a backup SST file,
+// and returns the max commit ts of the txn keys.
+func parseBackupFile(name string, data []byte, isRawKv bool) (*backuppb.File, uint64, error) {
+	cf := "default"
+	if !isRawKv && strings.HasSuffix(name, writeCFSuffix) {
+		cf = "write"
+	}
+
+	// pebble can only read SST files from a file system.
+	fs := vfs.NewMem()
+	f, err := fs.Create(path.Base(name))
+	if err != nil {
+		return nil, 0, errors.Trace(err)
+	}
+	if _, err = f.Write(data); err != nil {
+		return nil, 0, errors.Trace(err)
+	}
+	if err = f.Close(); err != nil {
+		return nil, 0, errors.Trace(err)
+	}
+	if f, err = fs.Open(path.Base(name)); err != nil {
+		return nil, 0, errors.Trace(err)
+	}
+	reader, err := sstable.NewReader(f, sstable.ReaderOptions{})
+	if err != nil {
+		return nil, 0, errors.Trace(err)
+	}
+	defer reader.Close()
+	iter, err := reader.NewIter(nil, nil)
+	if err != nil {
+		return nil, 0, errors.Trace(err)
+	}
+	defer iter.Close()
+
+	var (
+		startKey, lastKey []byte
+		totalKvs          uint64
+		totalBytes        uint64
+		maxTS             uint64
+	)
+	for key, value := iter.First(); key != nil; key, value = iter.Next() {
+		rawKey, ts, err := decodeBackupKey(key.UserKey, isRawKv)
+		if err != nil {
+			return nil, 0, errors.Trace(err)
+		}
+		if startKey == nil {
+			startKey = rawKey
+		}
+		lastKey = rawKey
+		totalKvs++
+		totalBytes += uint64(len(key.UserKey) + len(value))
+		// the ts of the default cf keys is the start ts of the txn.
+		if cf == "write" && ts > maxTS {
+			maxTS = ts
+		}
+	}
+	if err = iter.Error(); err != nil {
+		return nil, 0, errors.Trace(err)
+	}
+	if startKey == nil {
+		return nil, 0, errors.Annotate(berrors.ErrInvalidArgument, "empty SST file")
+	}
+
+	sum := sha256.Sum256(data)
+	file := &backuppb.File{
+		Name:     name,
+		Sha256:   sum[:],
+		StartKey: startKey,
+		// the end key of the backup range is unknown, use the smallest key
+		// after the last key instead.
+		EndKey:     append(lastKey, 0),
+		TotalKvs:   totalKvs,
+		TotalBytes: totalBytes,
+		Cf:         cf,
+		Size_:      uint64(len(data)),
+	}
+	log.Debug("parse SST file", logutil.File(file))
+	return file, maxTS, nil
+}
+
+// decodeBackupKey decodes the key in the backup SST files, i.e.
+// 'z' + memcomparable-encoded key + ts for txn keys, and 'z' + key for raw keys.
+func decodeBackupKey(key []byte, isRawKv bool) ([]byte, uint64, error) {
+	if len(key) == 0 || key[0] != dataKeyPrefix {
+		return nil, 0, errors.Annotatef(berrors.ErrInvalidArgument, "invalid data key %x", key)
+	}
+	key = key[1:]
+	if isRawKv {
+		return append([]byte{}, key...), 0, nil
+	}
+	if len(key) < tsLen {
+		return nil, 0, errors.Annotatef(berrors.ErrInvalidArgument, "invalid txn key %x", key)
+	}
+	_, rawKey, err := codec.DecodeBytes(key[:len(key)-tsLen], nil)
+	if err != nil {
+		return nil, 0, errors.Trace(err)
+	}
+	_, ts, err := codec.DecodeUintDesc(key[len(key)-tsLen:])
+	if err != nil {
+		return nil, 0, errors.Trace(err)
+	}
+	return rawKey, ts, nil
+}
+
+// rebuildSchemas copies the schemas of the tables in the files from the
+// reference, and returns the ids of the tables without schema.
+func rebuildSchemas(
+	ctx context.Context,
+	files []*backuppb.File,
+	reference *MetaReader,
+) ([]*backuppb.Schema, []int64, error) {
+	tableIDs := make(map[int64]bool)
+	for _, file := range files {
+		tableIDs[tablecodec.DecodeTableID(file.StartKey)] = false
+	}
+	if reference != nil {
+		var schemas []*backuppb.Schema
+		err := reference.readSchemas(ctx, func(schema *backuppb.Schema) {
+			if len(schema.Table) == 0 {
+				return
+			}
+			tableInfo := &model.TableInfo{}
+			if err := json.Unmarshal(schema.Table, tableInfo); err != nil {
+				log.Warn("skip the invalid schema in reference", zap.Error(err))
+				return
+			}
+			found := false
+			for _, id := range physicalIDs(tableInfo) {
+				if _, ok := tableIDs[id]; ok {
+					tableIDs[id] = true
+					found = true
+				}
+			}
+			if !found {
+				return
+			}
+			// the checksum and stats of the reference don't match the data,
+			// drop them so that the checksum is skipped when restoring.
+			schemas = append(schemas, &backuppb.Schema{
+				Db:    schema.Db,
+				Table: schema.Table,
+			})
+		})
+		if err != nil {
+			return nil, nil, errors.Trace(err)
+		}
+		return schemas, missingTableIDs(tableIDs), nil
+	}
+	return nil, missingTableIDs(tableIDs), nil
+}
+
+func physicalIDs(tableInfo *model.TableInfo) []int64 {
+	ids := []int64{tableInfo.ID}
+	if partitions := tableInfo.GetPartitionInfo(); partitions != nil {
+		for _, def := range partitions.Definitions {
+			ids = append(ids, def.ID)
+		}
+	}
+	return ids
+}
+
+func missingTableIDs(tableIDs map[int64]bool) []int64 {
+	missing := make([]int64, 0)
+	for id, found := range tableIDs {
+		if !found {
+			missing = append(missing, id)
+		}
+	}
+	sort.Slice(missing, func(i, j int) bool { return missing[i] < missing[j] })
+	return missing
+}
@@ -0,0 +1,89 @@
+// Copyright 2021 PingCAP, Inc. Licensed under Apache-2.0.
+
+package metautil
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+
+	"github.com/cockroachdb/pebble/sstable"
+	. "github.com/pingcap/check"
+	backuppb "github.com/pingcap/kvproto/pkg/backup"
+	"github.com/pingcap/parser/model"
+	"github.com/pingcap/tidb/kv"
+	"github.com/pingcap/tidb/tablecodec"
+	"github.com/pingcap/tidb/util/codec"
+
+	"github.com/pingcap/br/pkg/storage"
+)
+
+func writeBackupSST(c *C, path string, keys [][]byte, tss []uint64) {
+	f, err := os.Create(path)
+	c.Assert(err, IsNil)
+	w := sstable.NewWriter(f, sstable.WriterOptions{})
+	for i, key := range keys {
+		dataKey := append([]byte{dataKeyPrefix}, codec.EncodeBytes(nil, key)...)
+		dataKey = codec.EncodeUintDesc(dataKey, tss[i])
+		c.Assert(w.Set(dataKey, []byte("value")), IsNil)
+	}
+	c.Assert(w.Close(), IsNil)
+}
+
+func (m *metaSuit) TestRebuildBackupMeta(c *C) {
+	ctx := context.Background()
+	dir := c.MkDir()
+	s, err := storage.NewLocalStorage(dir)
+	c.Assert(err, IsNil)
+
+	// the keys are sorted by the encoded keys, i.e. the larger ts first.
+	keys := [][]byte{
+		tablecodec.EncodeRowKeyWithHandle(100, kv.IntHandle(1)),
+		tablecodec.EncodeRowKeyWithHandle(100, kv.IntHandle(1)),
+		tablecodec.EncodeRowKeyWithHandle(100, kv.IntHandle(5)),
+	}
+	writeBackupSST(c, filepath.Join(dir, "1_2_3_write.sst"), keys, []uint64{30, 20, 40})
+	writeBackupSST(c, filepath.Join(dir, "1_2_3_default.sst"), keys[:1], []uint64{50})
+	c.Assert(os.WriteFile(filepath.Join(dir, "4_5_6_write.sst"), []byte("corrupted"), 0o644), IsNil)
+
+	tableInfo, err := json.Marshal(&model.TableInfo{ID: 100, Name: model.NewCIStr("t")})
+	c.Assert(err, IsNil)
+	dbInfo, err := json.Marshal(&model.DBInfo{ID: 1, Name: model.NewCIStr("test")})
+	c.Assert(err, IsNil)
+	reference := NewMetaReader(&backuppb.BackupMeta{
+		Schemas: []*backuppb.Schema{{Db: dbInfo, Table: tableInfo, Crc64Xor: 1, TotalKvs: 1}},
+	}, s)
+
+	meta, report, err := RebuildBackupMeta(ctx, s, RebuildOptions{SchemaReference: reference})
+	c.Assert(err, IsNil)
+	c.Assert(meta.BrVersion, Equals, RebuiltBrVersion)
+	c.Assert(meta.EndVersion, Equals, uint64(40))
+	c.Assert(meta.Files, HasLen, 2)
+	for _, file := range meta.Files {
+		c.Assert(file.StartKey, DeepEquals, keys[0])
+		c.Assert(file.EndVersion, Equals, uint64(40))
+		switch file.Cf {
+		case "write":
+			c.Assert(file.TotalKvs, Equals, uint64(3))
+			c.Assert(file.EndKey, DeepEquals, append(append([]byte{}, keys[2]...), 0))
+		case "default":
+			c.Assert(file.TotalKvs, Equals, uint64(1))
+			c.Assert(file.EndKey, DeepEquals, append(append([]byte{}, keys[0]...), 0))
+		default:
+			c.Fatalf("unexpected cf %s", file.Cf)
+		}
+	}
+	c.Assert(meta.Schemas, HasLen, 1)
+	c.Assert(meta.Schemas[0].Crc64Xor, Equals, uint64(0))
+	c.Assert(meta.Schemas[0].TotalKvs, Equals, uint64(0))
+	c.Assert(report.Files, Equals, 2)
+	c.Assert(report.SkippedFiles, HasLen, 1)
+	c.Assert(report.MissingSchemas, HasLen, 0)
+
+	// without the reference, the tables are reported missing.
+	meta, report, err = RebuildBackupMeta(ctx, s, RebuildOptions{})
+	c.Assert(err, IsNil)
+	c.Assert(meta.Schemas, HasLen, 0)
+	c.Assert(report.MissingSchemas, DeepEquals, []int64{100})
+}
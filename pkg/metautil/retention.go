@@ -0,0 +1,59 @@
+// Copyright 2021 PingCAP, Inc. Licensed under Apache-2.0.
+
+package metautil
+
+import (
+	"context"
+	"encoding/json"
+	"path"
+
+	"github.com/pingcap/errors"
+	"github.com/pingcap/log"
+	"go.uber.org/zap"
+
+	berrors "github.com/pingcap/br/pkg/errors"
+	"github.com/pingcap/br/pkg/storage"
+)
+
+// RetentionFile is the name of the file recording the object lock retention
+// of the backup, which is written along with the backupmeta.
+const RetentionFile = "retention.json"
+
+// WriteRetention records the object lock of the backup to the storage.
+func WriteRetention(ctx context.Context, s storage.ExternalStorage, lock *storage.ObjectLockOptions) error {
+	data, err := json.Marshal(lock)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if err = s.WriteFile(ctx, RetentionFile, data); err != nil {
+		return errors.Trace(err)
+	}
+	log.Info("retention written", zap.String("mode", lock.Mode),
+		zap.Time("retain-until", lock.RetainUntil), zap.Bool("legal-hold", lock.LegalHold))
+	return nil
+}
+
+// ReadRetention reads the object lock of the backup from the storage. It
+// returns nil if the backup isn't locked.
+func ReadRetention(ctx context.Context, s storage.ExternalStorage) (*storage.ObjectLockOptions, error) {
+	return ReadRetentionIn(ctx, s, "")
+}
+
+// ReadRetentionIn reads the object lock of the backup in the sub directory
+// of the storage.
+func ReadRetentionIn(ctx context.Context, s storage.ExternalStorage, dir string) (*storage.ObjectLockOptions, error) {
+	name := path.Join(dir, RetentionFile)
+	exists, err := s.FileExists(ctx, name)
+	if err != nil || !exists {
+		return nil, errors.Trace(err)
+	}
+	data, err := s.ReadFile(ctx, name)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	lock := &storage.ObjectLockOptions{}
+	if err = json.Unmarshal(data, lock); err != nil {
+		return nil, errors.Annotatef(berrors.ErrInvalidMetaFile, "failed to parse %s: %v", name, err)
+	}
+	return lock, nil
+}
@@ -0,0 +1,36 @@
+// Copyright 2021 PingCAP, Inc. Licensed under Apache-2.0.
+
+package metautil
+
+import (
+	"context"
+	"time"
+
+	. "github.com/pingcap/check"
+
+	"github.com/pingcap/br/pkg/storage"
+)
+
+func (m *metaSuit) TestRetention(c *C) {
+	ctx := context.Background()
+	s, err := storage.NewLocalStorage(c.MkDir())
+	c.Assert(err, IsNil)
+
+	lock, err := ReadRetention(ctx, s)
+	c.Assert(err, IsNil)
+	c.Assert(lock, IsNil)
+
+	expected := &storage.ObjectLockOptions{
+		Mode:        "COMPLIANCE",
+		RetainUntil: time.Date(2021, 11, 1, 0, 0, 0, 0, time.UTC),
+		LegalHold:   true,
+	}
+	c.Assert(WriteRetention(ctx, s, expected), IsNil)
+	lock, err = ReadRetention(ctx, s)
+	c.Assert(err, IsNil)
+	c.Assert(lock, DeepEquals, expected)
+
+	c.Assert(s.WriteFile(ctx, RetentionFile, []byte("{")), IsNil)
+	_, err = ReadRetention(ctx, s)
+	c.Assert(err, ErrorMatches, ".*failed to parse.*")
+}
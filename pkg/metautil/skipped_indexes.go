@@ -0,0 +1,58 @@
+// Copyright 2021 PingCAP, Inc. Licensed under Apache-2.0.
+
+package metautil
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/pingcap/errors"
+	"github.com/pingcap/log"
+	"go.uber.org/zap"
+
+	berrors "github.com/pingcap/br/pkg/errors"
+	"github.com/pingcap/br/pkg/storage"
+)
+
+// SkippedIndexesFile is the name of the file recording the indexes whose
+// data isn't backed up, they're rebuilt by ADD INDEX after restore.
+const SkippedIndexesFile = "skipped_indexes.json"
+
+// TableSkippedIndexes is the indexes of a table whose data isn't backed up.
+type TableSkippedIndexes struct {
+	DB    string `json:"db"`
+	Table string `json:"table"`
+	// Indexes are the names of the indexes in the schema of the table.
+	Indexes []string `json:"indexes"`
+}
+
+// WriteSkippedIndexes writes the skipped indexes of the tables to the storage.
+func WriteSkippedIndexes(ctx context.Context, s storage.ExternalStorage, tables []TableSkippedIndexes) error {
+	data, err := json.Marshal(tables)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if err = s.WriteFile(ctx, SkippedIndexesFile, data); err != nil {
+		return errors.Trace(err)
+	}
+	log.Info("skipped indexes written", zap.Int("tables", len(tables)))
+	return nil
+}
+
+// ReadSkippedIndexes reads the skipped indexes of the tables from the
+// storage. It returns nil if the backup has the data of all indexes.
+func ReadSkippedIndexes(ctx context.Context, s storage.ExternalStorage) ([]TableSkippedIndexes, error) {
+	exists, err := s.FileExists(ctx, SkippedIndexesFile)
+	if err != nil || !exists {
+		return nil, errors.Trace(err)
+	}
+	data, err := s.ReadFile(ctx, SkippedIndexesFile)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	var tables []TableSkippedIndexes
+	if err = json.Unmarshal(data, &tables); err != nil {
+		return nil, errors.Annotatef(berrors.ErrInvalidMetaFile, "failed to parse %s: %v", SkippedIndexesFile, err)
+	}
+	return tables, nil
+}
@@ -0,0 +1,31 @@
+// Copyright 2021 PingCAP, Inc. Licensed under Apache-2.0.
+
+package metautil
+
+import (
+	"context"
+
+	. "github.com/pingcap/check"
+
+	"github.com/pingcap/br/pkg/storage"
+)
+
+func (m *metaSuit) TestSkippedIndexes(c *C) {
+	ctx := context.Background()
+	s, err := storage.NewLocalStorage(c.MkDir())
+	c.Assert(err, IsNil)
+
+	tables, err := ReadSkippedIndexes(ctx, s)
+	c.Assert(err, IsNil)
+	c.Assert(tables, IsNil)
+
+	expected := []TableSkippedIndexes{{DB: "test", Table: "t", Indexes: []string{"idx_a", "uk_b"}}}
+	c.Assert(WriteSkippedIndexes(ctx, s, expected), IsNil)
+	tables, err = ReadSkippedIndexes(ctx, s)
+	c.Assert(err, IsNil)
+	c.Assert(tables, DeepEquals, expected)
+
+	c.Assert(s.WriteFile(ctx, SkippedIndexesFile, []byte("[")), IsNil)
+	_, err = ReadSkippedIndexes(ctx, s)
+	c.Assert(err, ErrorMatches, ".*failed to parse.*")
+}
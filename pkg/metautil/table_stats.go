@@ -0,0 +1,51 @@
+// Copyright 2021 PingCAP, Inc. Licensed under Apache-2.0.
+
+package metautil
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/pingcap/errors"
+	"github.com/pingcap/log"
+	"go.uber.org/zap"
+
+	berrors "github.com/pingcap/br/pkg/errors"
+	"github.com/pingcap/br/pkg/storage"
+	"github.com/pingcap/br/pkg/summary"
+)
+
+// TableStatsFile is the name of the file recording the size and the duration
+// of backing up each table.
+const TableStatsFile = "table_stats.json"
+
+// WriteTableStats writes the stats of the tables to the storage.
+func WriteTableStats(ctx context.Context, s storage.ExternalStorage, stats []summary.TableStat) error {
+	data, err := json.Marshal(stats)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if err = s.WriteFile(ctx, TableStatsFile, data); err != nil {
+		return errors.Trace(err)
+	}
+	log.Info("table stats written", zap.Int("tables", len(stats)))
+	return nil
+}
+
+// ReadTableStats reads the stats of the tables from the storage. It returns
+// nil if the backup doesn't record them.
+func ReadTableStats(ctx context.Context, s storage.ExternalStorage) ([]summary.TableStat, error) {
+	exists, err := s.FileExists(ctx, TableStatsFile)
+	if err != nil || !exists {
+		return nil, errors.Trace(err)
+	}
+	data, err := s.ReadFile(ctx, TableStatsFile)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	var stats []summary.TableStat
+	if err = json.Unmarshal(data, &stats); err != nil {
+		return nil, errors.Annotatef(berrors.ErrInvalidMetaFile, "failed to parse %s: %v", TableStatsFile, err)
+	}
+	return stats, nil
+}
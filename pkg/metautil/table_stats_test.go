@@ -0,0 +1,32 @@
+// Copyright 2021 PingCAP, Inc. Licensed under Apache-2.0.
+
+package metautil
+
+import (
+	"context"
+	"time"
+
+	. "github.com/pingcap/check"
+
+	"github.com/pingcap/br/pkg/storage"
+	"github.com/pingcap/br/pkg/summary"
+)
+
+func (m *metaSuit) TestTableStats(c *C) {
+	ctx := context.Background()
+	s, err := storage.NewLocalStorage(c.MkDir())
+	c.Assert(err, IsNil)
+
+	stats, err := ReadTableStats(ctx, s)
+	c.Assert(err, IsNil)
+	c.Assert(stats, IsNil)
+
+	expected := []summary.TableStat{
+		{DB: "test", Table: "t1", KVs: 100, Size: 4096, Duration: time.Second},
+		{DB: "test", Table: "t2", KVs: 10, Size: 512, Duration: time.Millisecond},
+	}
+	c.Assert(WriteTableStats(ctx, s, expected), IsNil)
+	stats, err = ReadTableStats(ctx, s)
+	c.Assert(err, IsNil)
+	c.Assert(stats, DeepEquals, expected)
+}
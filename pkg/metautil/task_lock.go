@@ -0,0 +1,65 @@
+// Copyright 2021 PingCAP, Inc. Licensed under Apache-2.0.
+
+package metautil
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/pingcap/errors"
+
+	berrors "github.com/pingcap/br/pkg/errors"
+	"github.com/pingcap/br/pkg/storage"
+)
+
+// TaskLockFile is the name of the file held by the running task writing the
+// storage, so the concurrent tasks, e.g. two cron jobs, don't write the same
+// backup. Unlike LockFile, it's removed when the task exits.
+const TaskLockFile = "task.lock"
+
+// TaskLock is the holder of the storage and its heartbeat.
+type TaskLock struct {
+	// ID identifies the holder, which is unique among the tasks.
+	ID string `json:"id"`
+	// Holder is the host and the pid of the holder.
+	Holder    string    `json:"holder"`
+	Command   string    `json:"command"`
+	StartTime time.Time `json:"start-time"`
+	Heartbeat time.Time `json:"heartbeat"`
+	// TTL is the time after the last heartbeat the lock is held for, the
+	// lock of a killed task expires after it.
+	TTL time.Duration `json:"ttl"`
+}
+
+// IsLive checks whether the lock is held by a task still sending heartbeats.
+func (l *TaskLock) IsLive(now time.Time) bool {
+	return now.Sub(l.Heartbeat) <= l.TTL
+}
+
+// WriteTaskLock writes the lock to the storage.
+func WriteTaskLock(ctx context.Context, s storage.ExternalStorage, lock *TaskLock) error {
+	data, err := json.Marshal(lock)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	return errors.Trace(s.WriteFile(ctx, TaskLockFile, data))
+}
+
+// ReadTaskLock reads the lock from the storage. It returns nil if the storage
+// isn't locked.
+func ReadTaskLock(ctx context.Context, s storage.ExternalStorage) (*TaskLock, error) {
+	exists, err := s.FileExists(ctx, TaskLockFile)
+	if err != nil || !exists {
+		return nil, errors.Trace(err)
+	}
+	data, err := s.ReadFile(ctx, TaskLockFile)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	lock := &TaskLock{}
+	if err = json.Unmarshal(data, lock); err != nil {
+		return nil, errors.Annotatef(berrors.ErrInvalidMetaFile, "failed to parse %s: %v", TaskLockFile, err)
+	}
+	return lock, nil
+}
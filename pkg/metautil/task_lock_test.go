@@ -0,0 +1,38 @@
+// Copyright 2021 PingCAP, Inc. Licensed under Apache-2.0.
+
+package metautil
+
+import (
+	"context"
+	"time"
+
+	. "github.com/pingcap/check"
+
+	"github.com/pingcap/br/pkg/storage"
+)
+
+func (m *metaSuit) TestTaskLock(c *C) {
+	ctx := context.Background()
+	s, err := storage.NewLocalStorage(c.MkDir())
+	c.Assert(err, IsNil)
+
+	lock, err := ReadTaskLock(ctx, s)
+	c.Assert(err, IsNil)
+	c.Assert(lock, IsNil)
+
+	now := time.Now().UTC().Round(time.Second)
+	expected := &TaskLock{
+		ID:        "1",
+		Holder:    "host:1",
+		Command:   "Full backup",
+		StartTime: now,
+		Heartbeat: now,
+		TTL:       time.Minute,
+	}
+	c.Assert(WriteTaskLock(ctx, s, expected), IsNil)
+	lock, err = ReadTaskLock(ctx, s)
+	c.Assert(err, IsNil)
+	c.Assert(lock, DeepEquals, expected)
+	c.Assert(lock.IsLive(now.Add(time.Minute)), IsTrue)
+	c.Assert(lock.IsLive(now.Add(time.Minute+time.Second)), IsFalse)
+}
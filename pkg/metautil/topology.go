@@ -0,0 +1,62 @@
+// Copyright 2021 PingCAP, Inc. Licensed under Apache-2.0.
+
+package metautil
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"sort"
+
+	"github.com/pingcap/errors"
+	"github.com/pingcap/log"
+	"go.uber.org/zap"
+
+	berrors "github.com/pingcap/br/pkg/errors"
+	"github.com/pingcap/br/pkg/storage"
+)
+
+// RegionTopologyFile is the name of the file recording the region boundaries
+// of the backed up ranges.
+const RegionTopologyFile = "region_topology.json"
+
+// RegionTopology is the region boundaries of the cluster at backup time.
+type RegionTopology struct {
+	// Boundaries are the start keys of the regions inside the backed up
+	// ranges, which are raw keys in ascending order.
+	Boundaries [][]byte `json:"boundaries"`
+}
+
+// WriteRegionTopology writes the region boundaries to the storage.
+func WriteRegionTopology(ctx context.Context, s storage.ExternalStorage, boundaries [][]byte) error {
+	sort.Slice(boundaries, func(i, j int) bool {
+		return bytes.Compare(boundaries[i], boundaries[j]) < 0
+	})
+	data, err := json.Marshal(&RegionTopology{Boundaries: boundaries})
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if err = s.WriteFile(ctx, RegionTopologyFile, data); err != nil {
+		return errors.Trace(err)
+	}
+	log.Info("region topology written", zap.Int("boundaries", len(boundaries)))
+	return nil
+}
+
+// ReadRegionTopology reads the region boundaries from the storage. It returns
+// nil if the backup doesn't record them.
+func ReadRegionTopology(ctx context.Context, s storage.ExternalStorage) ([][]byte, error) {
+	exists, err := s.FileExists(ctx, RegionTopologyFile)
+	if err != nil || !exists {
+		return nil, errors.Trace(err)
+	}
+	data, err := s.ReadFile(ctx, RegionTopologyFile)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	topology := &RegionTopology{}
+	if err = json.Unmarshal(data, topology); err != nil {
+		return nil, errors.Annotatef(berrors.ErrInvalidMetaFile, "failed to parse %s: %v", RegionTopologyFile, err)
+	}
+	return topology.Boundaries, nil
+}
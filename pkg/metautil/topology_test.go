@@ -0,0 +1,30 @@
+// Copyright 2021 PingCAP, Inc. Licensed under Apache-2.0.
+
+package metautil
+
+import (
+	"context"
+
+	. "github.com/pingcap/check"
+
+	"github.com/pingcap/br/pkg/storage"
+)
+
+func (m *metaSuit) TestRegionTopology(c *C) {
+	ctx := context.Background()
+	s, err := storage.NewLocalStorage(c.MkDir())
+	c.Assert(err, IsNil)
+
+	boundaries, err := ReadRegionTopology(ctx, s)
+	c.Assert(err, IsNil)
+	c.Assert(boundaries, IsNil)
+
+	c.Assert(WriteRegionTopology(ctx, s, [][]byte{[]byte("b"), []byte("a"), []byte("c")}), IsNil)
+	boundaries, err = ReadRegionTopology(ctx, s)
+	c.Assert(err, IsNil)
+	c.Assert(boundaries, DeepEquals, [][]byte{[]byte("a"), []byte("b"), []byte("c")})
+
+	c.Assert(s.WriteFile(ctx, RegionTopologyFile, []byte("{")), IsNil)
+	_, err = ReadRegionTopology(ctx, s)
+	c.Assert(err, ErrorMatches, ".*failed to parse.*")
+}
@@ -0,0 +1,62 @@
+// Copyright 2021 PingCAP, Inc. Licensed under Apache-2.0.
+
+package metautil
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/pingcap/errors"
+	"github.com/pingcap/log"
+	"go.uber.org/zap"
+
+	berrors "github.com/pingcap/br/pkg/errors"
+	"github.com/pingcap/br/pkg/storage"
+)
+
+// TxnKvFile is the name of the file marking the backup of a TiKV cluster used
+// via the TxnKV client, which has the data files but no schemas.
+const TxnKvFile = "backup_txnkv.json"
+
+// TxnKvRange is a key range of the txn kv backup, the keys aren't encoded.
+type TxnKvRange struct {
+	StartKey []byte `json:"start-key"`
+	EndKey   []byte `json:"end-key"`
+}
+
+// TxnKvMeta is the meta of the txn kv backup.
+type TxnKvMeta struct {
+	// Ranges are the backed up ranges sorted by the start keys.
+	Ranges []TxnKvRange `json:"ranges"`
+}
+
+// WriteTxnKvMeta writes the meta of the txn kv backup to the storage.
+func WriteTxnKvMeta(ctx context.Context, s storage.ExternalStorage, meta *TxnKvMeta) error {
+	data, err := json.Marshal(meta)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if err = s.WriteFile(ctx, TxnKvFile, data); err != nil {
+		return errors.Trace(err)
+	}
+	log.Info("txn kv meta written", zap.Int("ranges", len(meta.Ranges)))
+	return nil
+}
+
+// ReadTxnKvMeta reads the meta of the txn kv backup from the storage. It
+// returns nil if the backup isn't a txn kv backup.
+func ReadTxnKvMeta(ctx context.Context, s storage.ExternalStorage) (*TxnKvMeta, error) {
+	exists, err := s.FileExists(ctx, TxnKvFile)
+	if err != nil || !exists {
+		return nil, errors.Trace(err)
+	}
+	data, err := s.ReadFile(ctx, TxnKvFile)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	meta := &TxnKvMeta{}
+	if err = json.Unmarshal(data, meta); err != nil {
+		return nil, errors.Annotatef(berrors.ErrInvalidMetaFile, "failed to parse %s: %v", TxnKvFile, err)
+	}
+	return meta, nil
+}
@@ -0,0 +1,34 @@
+// Copyright 2021 PingCAP, Inc. Licensed under Apache-2.0.
+
+package metautil
+
+import (
+	"context"
+
+	. "github.com/pingcap/check"
+
+	"github.com/pingcap/br/pkg/storage"
+)
+
+func (m *metaSuit) TestTxnKvMeta(c *C) {
+	ctx := context.Background()
+	s, err := storage.NewLocalStorage(c.MkDir())
+	c.Assert(err, IsNil)
+
+	meta, err := ReadTxnKvMeta(ctx, s)
+	c.Assert(err, IsNil)
+	c.Assert(meta, IsNil)
+
+	expected := &TxnKvMeta{Ranges: []TxnKvRange{
+		{StartKey: []byte("a"), EndKey: []byte("b")},
+		{StartKey: []byte("c"), EndKey: []byte{}},
+	}}
+	c.Assert(WriteTxnKvMeta(ctx, s, expected), IsNil)
+	meta, err = ReadTxnKvMeta(ctx, s)
+	c.Assert(err, IsNil)
+	c.Assert(meta, DeepEquals, expected)
+
+	c.Assert(s.WriteFile(ctx, TxnKvFile, []byte("{")), IsNil)
+	_, err = ReadTxnKvMeta(ctx, s)
+	c.Assert(err, ErrorMatches, ".*failed to parse.*")
+}
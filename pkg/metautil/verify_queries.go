@@ -0,0 +1,57 @@
+// Copyright 2021 PingCAP, Inc. Licensed under Apache-2.0.
+
+package metautil
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/pingcap/errors"
+	"github.com/pingcap/log"
+	"go.uber.org/zap"
+
+	berrors "github.com/pingcap/br/pkg/errors"
+	"github.com/pingcap/br/pkg/storage"
+)
+
+// VerifyQueriesFile is the name of the file recording the queries verifying
+// the restored data and their results at the backup ts.
+const VerifyQueriesFile = "verify_queries.json"
+
+// VerifyQuery is a query verifying the restored data, and its expected rows
+// with the values in the string form.
+type VerifyQuery struct {
+	SQL  string     `json:"sql"`
+	Rows [][]string `json:"rows"`
+}
+
+// WriteVerifyQueries writes the verification queries to the storage.
+func WriteVerifyQueries(ctx context.Context, s storage.ExternalStorage, queries []VerifyQuery) error {
+	data, err := json.Marshal(queries)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if err = s.WriteFile(ctx, VerifyQueriesFile, data); err != nil {
+		return errors.Trace(err)
+	}
+	log.Info("verification queries written", zap.Int("queries", len(queries)))
+	return nil
+}
+
+// ReadVerifyQueries reads the verification queries from the storage. It
+// returns nil if the backup doesn't record them.
+func ReadVerifyQueries(ctx context.Context, s storage.ExternalStorage) ([]VerifyQuery, error) {
+	exists, err := s.FileExists(ctx, VerifyQueriesFile)
+	if err != nil || !exists {
+		return nil, errors.Trace(err)
+	}
+	data, err := s.ReadFile(ctx, VerifyQueriesFile)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	var queries []VerifyQuery
+	if err = json.Unmarshal(data, &queries); err != nil {
+		return nil, errors.Annotatef(berrors.ErrInvalidMetaFile, "failed to parse %s: %v", VerifyQueriesFile, err)
+	}
+	return queries, nil
+}
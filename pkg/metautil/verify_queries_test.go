@@ -0,0 +1,34 @@
+// Copyright 2021 PingCAP, Inc. Licensed under Apache-2.0.
+
+package metautil
+
+import (
+	"context"
+
+	. "github.com/pingcap/check"
+
+	"github.com/pingcap/br/pkg/storage"
+)
+
+func (m *metaSuit) TestVerifyQueries(c *C) {
+	ctx := context.Background()
+	s, err := storage.NewLocalStorage(c.MkDir())
+	c.Assert(err, IsNil)
+
+	queries, err := ReadVerifyQueries(ctx, s)
+	c.Assert(err, IsNil)
+	c.Assert(queries, IsNil)
+
+	expected := []VerifyQuery{
+		{SQL: "SELECT COUNT(*) FROM test.t", Rows: [][]string{{"42"}}},
+		{SQL: "SELECT id, name FROM test.t WHERE id < 3", Rows: [][]string{{"1", "a"}, {"2", "<nil>"}}},
+	}
+	c.Assert(WriteVerifyQueries(ctx, s, expected), IsNil)
+	queries, err = ReadVerifyQueries(ctx, s)
+	c.Assert(err, IsNil)
+	c.Assert(queries, DeepEquals, expected)
+
+	c.Assert(s.WriteFile(ctx, VerifyQueriesFile, []byte("[")), IsNil)
+	_, err = ReadVerifyQueries(ctx, s)
+	c.Assert(err, ErrorMatches, ".*failed to parse.*")
+}
@@ -0,0 +1,189 @@
+// Copyright 2021 PingCAP, Inc. Licensed under Apache-2.0.
+
+package pdutil
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/pingcap/errors"
+	"github.com/pingcap/kvproto/pkg/metapb"
+	"github.com/pingcap/kvproto/pkg/pdpb"
+	"github.com/pingcap/log"
+	pd "github.com/tikv/pd/client"
+	"go.uber.org/zap"
+
+	"github.com/pingcap/br/pkg/utils"
+)
+
+const (
+	leaderSwitchRetryTimes       = 10
+	leaderSwitchWaitInterval     = 500 * time.Millisecond
+	leaderSwitchMaxWaitInterval  = 3 * time.Second
+	leaderSwitchWaitIntervalRate = 2
+)
+
+// leaderSwitchErrors are the messages of the errors returned during the switch
+// of the PD leader, the PD client reconnects the new leader in background.
+var leaderSwitchErrors = []string{
+	"not leader",
+	"no leader",
+	"mismatch leader id",
+	"etcdserver: leader changed",
+	"errclientgetleader",
+	"tso stream",
+}
+
+// IsLeaderSwitchError checks whether the error is caused by the switch of the
+// PD leader, which can be retried after the PD client reconnects.
+func IsLeaderSwitchError(err error) bool {
+	if err == nil {
+		return false
+	}
+	msg := strings.ToLower(err.Error())
+	for _, s := range leaderSwitchErrors {
+		if strings.Contains(msg, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// leaderSwitchBackoffer retries the errors caused by the switch of the PD
+// leader only.
+type leaderSwitchBackoffer struct {
+	attempt   int
+	delayTime time.Duration
+}
+
+// NewLeaderSwitchBackoffer creates a backoffer retrying the errors caused by
+// the switch of the PD leader, the other errors aren't retried.
+func NewLeaderSwitchBackoffer() utils.Backoffer {
+	return &leaderSwitchBackoffer{attempt: leaderSwitchRetryTimes, delayTime: leaderSwitchWaitInterval}
+}
+
+func (bo *leaderSwitchBackoffer) NextBackoff(err error) time.Duration {
+	if !IsLeaderSwitchError(err) {
+		bo.attempt = 0
+		return 0
+	}
+	bo.attempt--
+	delay := bo.delayTime
+	bo.delayTime *= leaderSwitchWaitIntervalRate
+	if bo.delayTime > leaderSwitchMaxWaitInterval {
+		bo.delayTime = leaderSwitchMaxWaitInterval
+	}
+	log.Warn("PD leader is switching, retry the request", zap.Duration("backoff", delay), zap.Error(err))
+	return delay
+}
+
+func (bo *leaderSwitchBackoffer) Attempt() int {
+	return bo.attempt
+}
+
+// WithLeaderSwitchRetry retries the function if it fails by the switch of the
+// PD leader. It returns the last error as is if all attempts fail, so the
+// callers can inspect it like the error of the PD client.
+func WithLeaderSwitchRetry(ctx context.Context, fn func() error) error {
+	var lastErr error
+	err := utils.WithRetry(ctx, func() error {
+		lastErr = fn()
+		return lastErr
+	}, NewLeaderSwitchBackoffer())
+	if err == nil {
+		return nil
+	}
+	if lastErr == nil {
+		// canceled before any attempt.
+		return errors.Trace(ctx.Err())
+	}
+	return lastErr // nolint:wrapcheck
+}
+
+// leaderSwitchClient is a PD client retrying the requests of BR failed by the
+// switch of the PD leader, instead of failing the long running tasks.
+type leaderSwitchClient struct {
+	pd.Client
+}
+
+// NewLeaderSwitchClient wraps the PD client to retry the requests failed by
+// the switch of the PD leader.
+func NewLeaderSwitchClient(client pd.Client) pd.Client {
+	if _, ok := client.(leaderSwitchClient); ok {
+		return client
+	}
+	return leaderSwitchClient{Client: client}
+}
+
+func (c leaderSwitchClient) GetTS(ctx context.Context) (physical int64, logical int64, err error) {
+	err = WithLeaderSwitchRetry(ctx, func() error {
+		physical, logical, err = c.Client.GetTS(ctx)
+		return err
+	})
+	return physical, logical, err
+}
+
+func (c leaderSwitchClient) GetRegion(ctx context.Context, key []byte) (region *pd.Region, err error) {
+	err = WithLeaderSwitchRetry(ctx, func() error {
+		region, err = c.Client.GetRegion(ctx, key)
+		return err
+	})
+	return region, err
+}
+
+func (c leaderSwitchClient) GetRegionByID(ctx context.Context, regionID uint64) (region *pd.Region, err error) {
+	err = WithLeaderSwitchRetry(ctx, func() error {
+		region, err = c.Client.GetRegionByID(ctx, regionID)
+		return err
+	})
+	return region, err
+}
+
+func (c leaderSwitchClient) ScanRegions(ctx context.Context, key, endKey []byte, limit int) (regions []*pd.Region, err error) {
+	err = WithLeaderSwitchRetry(ctx, func() error {
+		regions, err = c.Client.ScanRegions(ctx, key, endKey, limit)
+		return err
+	})
+	return regions, err
+}
+
+func (c leaderSwitchClient) GetStore(ctx context.Context, storeID uint64) (store *metapb.Store, err error) {
+	err = WithLeaderSwitchRetry(ctx, func() error {
+		store, err = c.Client.GetStore(ctx, storeID)
+		return err
+	})
+	return store, err
+}
+
+func (c leaderSwitchClient) GetAllStores(ctx context.Context, opts ...pd.GetStoreOption) (stores []*metapb.Store, err error) {
+	err = WithLeaderSwitchRetry(ctx, func() error {
+		stores, err = c.Client.GetAllStores(ctx, opts...)
+		return err
+	})
+	return stores, err
+}
+
+func (c leaderSwitchClient) ScatterRegion(ctx context.Context, regionID uint64) error {
+	return WithLeaderSwitchRetry(ctx, func() error {
+		return c.Client.ScatterRegion(ctx, regionID)
+	})
+}
+
+func (c leaderSwitchClient) GetOperator(ctx context.Context, regionID uint64) (resp *pdpb.GetOperatorResponse, err error) {
+	err = WithLeaderSwitchRetry(ctx, func() error {
+		resp, err = c.Client.GetOperator(ctx, regionID)
+		return err
+	})
+	return resp, err
+}
+
+func (c leaderSwitchClient) UpdateServiceGCSafePoint(
+	ctx context.Context, serviceID string, ttl int64, safePoint uint64,
+) (minSafePoint uint64, err error) {
+	err = WithLeaderSwitchRetry(ctx, func() error {
+		minSafePoint, err = c.Client.UpdateServiceGCSafePoint(ctx, serviceID, ttl, safePoint)
+		return err
+	})
+	return minSafePoint, err
+}
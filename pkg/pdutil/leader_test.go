@@ -0,0 +1,60 @@
+// Copyright 2021 PingCAP, Inc. Licensed under Apache-2.0.
+
+package pdutil
+
+import (
+	"context"
+	"errors"
+
+	. "github.com/pingcap/check"
+	pd "github.com/tikv/pd/client"
+)
+
+type flakyTSClient struct {
+	pd.Client
+	errs  []error
+	calls int
+}
+
+func (f *flakyTSClient) GetTS(context.Context) (int64, int64, error) {
+	f.calls++
+	if len(f.errs) > 0 {
+		err := f.errs[0]
+		f.errs = f.errs[1:]
+		return 0, 0, err
+	}
+	return 100, 1, nil
+}
+
+func (s *testPDControllerSuite) TestIsLeaderSwitchError(c *C) {
+	for _, msg := range []string{
+		"rpc error: code = Unknown desc = not leader",
+		"etcdserver: leader changed",
+		"[PD:client:ErrClientGetLeader]get leader from [http://127.0.0.1:2379] error",
+		"[PD:client:ErrClientTSOStreamClosed]encountered TSO stream being closed unexpectedly",
+	} {
+		c.Assert(IsLeaderSwitchError(errors.New(msg)), IsTrue, Commentf("%s", msg))
+	}
+	c.Assert(IsLeaderSwitchError(errors.New("region not found")), IsFalse)
+	c.Assert(IsLeaderSwitchError(nil), IsFalse)
+}
+
+func (s *testPDControllerSuite) TestLeaderSwitchClient(c *C) {
+	ctx := context.Background()
+	flaky := &flakyTSClient{errs: []error{errors.New("rpc error: code = Unknown desc = not leader")}}
+	client := NewLeaderSwitchClient(flaky)
+	c.Assert(NewLeaderSwitchClient(client), Equals, client)
+
+	physical, logical, err := client.GetTS(ctx)
+	c.Assert(err, IsNil)
+	c.Assert(physical, Equals, int64(100))
+	c.Assert(logical, Equals, int64(1))
+	c.Assert(flaky.calls, Equals, 2)
+
+	// the other errors are returned as is without retrying.
+	other := errors.New("invalid argument")
+	flaky = &flakyTSClient{errs: []error{other}}
+	_, _, err = NewLeaderSwitchClient(flaky).GetTS(ctx)
+	c.Assert(err, Equals, other)
+	c.Assert(flaky.calls, Equals, 1)
+}
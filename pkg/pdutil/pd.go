@@ -24,6 +24,7 @@ import (
 	"github.com/pingcap/tidb/util/codec"
 	pd "github.com/tikv/pd/client"
 	pdapi "github.com/tikv/pd/server/api"
+	"github.com/tikv/pd/server/statistics"
 	"go.uber.org/zap"
 	"google.golang.org/grpc"
 
@@ -34,6 +35,7 @@ import (
 
 const (
 	clusterVersionPrefix = "pd/api/v1/config/cluster-version"
+	pdVersionPrefix      = "pd/api/v1/version"
 	regionCountPrefix    = "pd/api/v1/stats/region"
 	storePrefix          = "pd/api/v1/store"
 	schedulerPrefix      = "pd/api/v1/schedulers"
@@ -192,6 +194,7 @@ func NewPdController(
 	pdAddrs string,
 	tlsConf *tls.Config,
 	securityOption pd.SecurityOption,
+	timeout time.Duration,
 ) (*PdController, error) {
 	cli := httputil.NewClient(tlsConf)
 
@@ -225,7 +228,7 @@ func NewPdController(
 	pdClient, err := pd.NewClientWithContext(
 		ctx, addrs, securityOption,
 		pd.WithGRPCDialOptions(maxCallMsgSize...),
-		pd.WithCustomTimeoutOption(10*time.Second),
+		pd.WithCustomTimeoutOption(timeout),
 	)
 	if err != nil {
 		log.Error("fail to create pd client", zap.Error(err))
@@ -233,9 +236,10 @@ func NewPdController(
 	}
 
 	return &PdController{
-		addrs:    processedAddrs,
-		cli:      cli,
-		pdClient: pdClient,
+		addrs: processedAddrs,
+		cli:   cli,
+		// the long running tasks shouldn't fail by the switch of the leader.
+		pdClient: NewLeaderSwitchClient(pdClient),
 		version:  version,
 		// We should make a buffered channel here otherwise when context canceled,
 		// gracefully shutdown will stick at resuming schedulers.
@@ -243,6 +247,15 @@ func NewPdController(
 	}, nil
 }
 
+// NewPdControllerWithClient creates a PdController on the PD client only,
+// e.g. the client of a mock cluster, the HTTP APIs of PD are unavailable.
+func NewPdControllerWithClient(pdClient pd.Client) *PdController {
+	return &PdController{
+		pdClient:         pdClient,
+		schedulerPauseCh: make(chan struct{}, 1),
+	}
+}
+
 func parseVersion(versionBytes []byte) *semver.Version {
 	// we need trim space or semver will parse failed
 	v := strings.TrimSpace(string(versionBytes))
@@ -302,6 +315,30 @@ func (p *PdController) getClusterVersionWith(ctx context.Context, get pdHTTPRequ
 	return "", errors.Trace(err)
 }
 
+// GetPDVersion returns the version of the PD server.
+func (p *PdController) GetPDVersion(ctx context.Context) (string, error) {
+	return p.getPDVersionWith(ctx, pdRequest)
+}
+
+func (p *PdController) getPDVersionWith(ctx context.Context, get pdHTTPRequest) (string, error) {
+	var err error
+	for _, addr := range p.addrs {
+		v, e := get(ctx, addr, pdVersionPrefix, p.cli, http.MethodGet, nil)
+		if e != nil {
+			err = e
+			continue
+		}
+		var version struct {
+			Version string `json:"version"`
+		}
+		if err = json.Unmarshal(v, &version); err != nil {
+			return "", errors.Trace(err)
+		}
+		return version.Version, nil
+	}
+	return "", errors.Trace(err)
+}
+
 // GetRegionCount returns the region count in the specified range.
 func (p *PdController) GetRegionCount(ctx context.Context, startKey, endKey []byte) (int, error) {
 	return p.getRegionCountWith(ctx, pdRequest, startKey, endKey)
@@ -310,6 +347,22 @@ func (p *PdController) GetRegionCount(ctx context.Context, startKey, endKey []by
 func (p *PdController) getRegionCountWith(
 	ctx context.Context, get pdHTTPRequest, startKey, endKey []byte,
 ) (int, error) {
+	stats, err := p.getRegionStatsWith(ctx, get, startKey, endKey)
+	if err != nil {
+		return 0, errors.Trace(err)
+	}
+	return stats.Count, nil
+}
+
+// GetRegionStats returns the statistics of the regions in the specified
+// range, the storage size is the approximate size of a replica in MiB.
+func (p *PdController) GetRegionStats(ctx context.Context, startKey, endKey []byte) (*statistics.RegionStats, error) {
+	return p.getRegionStatsWith(ctx, pdRequest, startKey, endKey)
+}
+
+func (p *PdController) getRegionStatsWith(
+	ctx context.Context, get pdHTTPRequest, startKey, endKey []byte,
+) (*statistics.RegionStats, error) {
 	// TiKV reports region start/end keys to PD in memcomparable-format.
 	var start, end string
 	start = url.QueryEscape(string(codec.EncodeBytes(nil, startKey)))
@@ -326,14 +379,13 @@ func (p *PdController) getRegionCountWith(
 			err = e
 			continue
 		}
-		regionsMap := make(map[string]interface{})
-		err = json.Unmarshal(v, &regionsMap)
-		if err != nil {
-			return 0, errors.Trace(err)
+		stats := &statistics.RegionStats{}
+		if err = json.Unmarshal(v, stats); err != nil {
+			return nil, errors.Trace(err)
 		}
-		return int(regionsMap["count"].(float64)), nil
+		return stats, nil
 	}
-	return 0, errors.Trace(err)
+	return nil, errors.Trace(err)
 }
 
 // GetStoreInfo returns the info of store with the specified id.
@@ -479,9 +531,22 @@ func (p *PdController) ListSchedulers(ctx context.Context) ([]string, error) {
 }
 
 func (p *PdController) listSchedulersWith(ctx context.Context, get pdHTTPRequest) ([]string, error) {
+	return p.listSchedulersByStatusWith(ctx, "", get)
+}
+
+// ListPausedSchedulers lists the paused pd schedulers.
+func (p *PdController) ListPausedSchedulers(ctx context.Context) ([]string, error) {
+	return p.listSchedulersByStatusWith(ctx, "paused", pdRequest)
+}
+
+func (p *PdController) listSchedulersByStatusWith(ctx context.Context, status string, get pdHTTPRequest) ([]string, error) {
+	prefix := schedulerPrefix
+	if status != "" {
+		prefix = fmt.Sprintf("%s?status=%s", schedulerPrefix, status)
+	}
 	var err error
 	for _, addr := range p.addrs {
-		v, e := get(ctx, addr, schedulerPrefix, p.cli, http.MethodGet, nil)
+		v, e := get(ctx, addr, prefix, p.cli, http.MethodGet, nil)
 		if e != nil {
 			err = e
 			continue
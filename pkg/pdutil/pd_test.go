@@ -84,6 +84,14 @@ func (s *testPDControllerSuite) TestScheduler(c *C) {
 	c.Assert(err, IsNil)
 	c.Assert(schedulers, HasLen, 1)
 	c.Assert(schedulers[0], Equals, scheduler)
+
+	mock = func(_ context.Context, _ string, prefix string, _ *http.Client, _ string, _ io.Reader) ([]byte, error) {
+		c.Assert(prefix, Equals, schedulerPrefix+"?status=paused")
+		return []byte(`["` + scheduler + `"]`), nil
+	}
+	schedulers, err = pdController.listSchedulersByStatusWith(ctx, "paused", mock)
+	c.Assert(err, IsNil)
+	c.Assert(schedulers, DeepEquals, []string{scheduler})
 }
 
 func (s *testPDControllerSuite) TestGetClusterVersion(c *C) {
@@ -109,6 +117,17 @@ func (s *testPDControllerSuite) TestGetClusterVersion(c *C) {
 	c.Assert(err, NotNil)
 }
 
+func (s *testPDControllerSuite) TestGetPDVersion(c *C) {
+	pdController := &PdController{addrs: []string{"http://mock"}}
+	mock := func(_ context.Context, addr string, prefix string, _ *http.Client, _ string, _ io.Reader) ([]byte, error) {
+		c.Assert(prefix, Equals, "pd/api/v1/version")
+		return []byte(`{"version": "v5.1.0"}`), nil
+	}
+	version, err := pdController.getPDVersionWith(context.Background(), mock)
+	c.Assert(err, IsNil)
+	c.Assert(version, Equals, "v5.1.0")
+}
+
 func (s *testPDControllerSuite) TestRegionCount(c *C) {
 	regions := core.NewRegionsInfo()
 	regions.SetRegion(core.NewRegionInfo(&metapb.Region{
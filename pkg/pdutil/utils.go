@@ -31,6 +31,7 @@ var Nop UndoFunc = func(context.Context) error { return nil }
 const (
 	resetTSURL       = "/pd/api/v1/admin/reset-ts"
 	placementRuleURL = "/pd/api/v1/config/rules"
+	setRuleURL       = "/pd/api/v1/config/rule"
 )
 
 // ResetTS resets the timestamp of PD to a bigger value.
@@ -101,18 +102,71 @@ func GetPlacementRules(ctx context.Context, pdAddr string, tlsConf *tls.Config)
 	return rules, nil
 }
 
+// SetPlacementRule creates or updates the placement rule.
+func SetPlacementRule(ctx context.Context, pdAddr string, rule *placement.Rule, tlsConf *tls.Config) error {
+	payload, err := json.Marshal(rule)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	cli := httputil.NewClient(tlsConf)
+	prefix := "http://"
+	if tlsConf != nil {
+		prefix = "https://"
+	}
+	reqURL := prefix + pdAddr + setRuleURL
+	req, err := http.NewRequestWithContext(ctx, "POST", reqURL, bytes.NewReader(payload))
+	if err != nil {
+		return errors.Trace(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := cli.Do(req)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		buf := new(bytes.Buffer)
+		_, _ = buf.ReadFrom(resp.Body)
+		return errors.Annotatef(berrors.ErrPDInvalidResponse, "set placement rule failed: req=%v, resp=%v, code=%d",
+			string(payload), buf.String(), resp.StatusCode)
+	}
+	return nil
+}
+
+// DecodePlacementRuleKey decodes the hex encoded start or end key of the
+// placement rule into the raw key.
+func DecodePlacementRuleKey(keyHex string) ([]byte, error) {
+	key, err := hex.DecodeString(keyHex)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	_, decoded, err := codec.DecodeBytes(key)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	return decoded, nil
+}
+
+// EncodePlacementRuleKey encodes the raw key into the start or end key of the
+// placement rule.
+func EncodePlacementRuleKey(key []byte) string {
+	return hex.EncodeToString(codec.EncodeBytes(key))
+}
+
+// PlacementRuleTableID returns the ID of the table the placement rule is
+// bound to, 0 if it isn't bound to a table.
+func PlacementRuleTableID(rule *placement.Rule) int64 {
+	key, err := DecodePlacementRuleKey(rule.StartKeyHex)
+	if err != nil {
+		return 0
+	}
+	return tablecodec.DecodeTableID(key)
+}
+
 // SearchPlacementRule returns the placement rule matched to the table or nil.
 func SearchPlacementRule(tableID int64, placementRules []placement.Rule, role placement.PeerRoleType) *placement.Rule {
 	for _, rule := range placementRules {
-		key, err := hex.DecodeString(rule.StartKeyHex)
-		if err != nil {
-			continue
-		}
-		_, decoded, err := codec.DecodeBytes(key)
-		if err != nil {
-			continue
-		}
-		if rule.Role == role && tableID == tablecodec.DecodeTableID(decoded) {
+		if rule.Role == role && tableID == PlacementRuleTableID(&rule) {
 			return &rule
 		}
 	}
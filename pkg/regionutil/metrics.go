@@ -0,0 +1,50 @@
+// Copyright 2021 PingCAP, Inc. Licensed under Apache-2.0.
+
+package regionutil
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	scanRegionHistogram = prometheus.NewHistogram(
+		prometheus.HistogramOpts{
+			Namespace: "br",
+			Subsystem: "region",
+			Name:      "scan_duration_seconds",
+			Help:      "The latency distributions of scanning a page of regions.",
+			Buckets:   prometheus.ExponentialBuckets(0.001, 2, 16),
+		})
+
+	scanRegionRetryCounter = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Namespace: "br",
+			Subsystem: "region",
+			Name:      "scan_retries_total",
+			Help:      "The retries of scanning the regions.",
+		})
+
+	scatterRegionCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "br",
+			Subsystem: "region",
+			Name:      "scatter_total",
+			Help:      "The regions scattered by the results.",
+		}, []string{"result"})
+
+	scatterWaitHistogram = prometheus.NewHistogram(
+		prometheus.HistogramOpts{
+			Namespace: "br",
+			Subsystem: "region",
+			Name:      "scatter_wait_seconds",
+			Help:      "The latency distributions of waiting for a region scattered.",
+			Buckets:   prometheus.ExponentialBuckets(0.01, 2, 16),
+		})
+)
+
+func init() { // nolint:gochecknoinits
+	prometheus.MustRegister(scanRegionHistogram)
+	prometheus.MustRegister(scanRegionRetryCounter)
+	prometheus.MustRegister(scatterRegionCounter)
+	prometheus.MustRegister(scatterWaitHistogram)
+}
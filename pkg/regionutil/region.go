@@ -0,0 +1,94 @@
+// Copyright 2021 PingCAP, Inc. Licensed under Apache-2.0.
+
+// Package regionutil provides the utilities of scanning, splitting and
+// scattering the regions of TiKV shared by BR, lightning and external tools.
+package regionutil
+
+import (
+	"bytes"
+	"context"
+	"time"
+
+	"github.com/pingcap/errors"
+	"github.com/pingcap/kvproto/pkg/metapb"
+	"github.com/pingcap/kvproto/pkg/pdpb"
+)
+
+// RegionInfo includes a region and the leader of the region.
+type RegionInfo struct {
+	Region *metapb.Region
+	Leader *metapb.Peer
+}
+
+// ContainsInterior returns whether the region contains the given key, and also
+// that the key does not fall on the boundary (start key) of the region.
+func (region *RegionInfo) ContainsInterior(key []byte) bool {
+	return bytes.Compare(key, region.Region.GetStartKey()) > 0 &&
+		(len(region.Region.GetEndKey()) == 0 ||
+			bytes.Compare(key, region.Region.GetEndKey()) < 0)
+}
+
+// Scanner scans the regions.
+type Scanner interface {
+	// ScanRegions gets a list of regions, starts from the region that contains
+	// key. Limit limits the maximum number of regions returned.
+	ScanRegions(ctx context.Context, key, endKey []byte, limit int) ([]*RegionInfo, error)
+}
+
+// Client is the client of PD used by the region utilities.
+type Client interface {
+	Scanner
+	// GetRegionByID gets a region by a region id.
+	GetRegionByID(ctx context.Context, regionID uint64) (*RegionInfo, error)
+	// ScatterRegion scatters a specified region.
+	ScatterRegion(ctx context.Context, regionInfo *RegionInfo) error
+	// GetOperator gets the status of operator of the specified region.
+	GetOperator(ctx context.Context, regionID uint64) (*pdpb.GetOperatorResponse, error)
+}
+
+// RetryPolicy is how an operation is retried or polled.
+type RetryPolicy struct {
+	// MaxAttempts is the max times of trying, 0 or 1 means no retry.
+	MaxAttempts int
+	// BaseBackoff is the wait before the first retry, it doubles every retry.
+	BaseBackoff time.Duration
+	// MaxBackoff caps the wait between two retries, 0 means no cap.
+	MaxBackoff time.Duration
+}
+
+// Backoff returns the wait after the attempt, which starts from 0.
+func (p RetryPolicy) Backoff(attempt int) time.Duration {
+	d := p.BaseBackoff
+	for i := 0; i < attempt && d > 0; i++ {
+		if p.MaxBackoff > 0 && d >= p.MaxBackoff {
+			break
+		}
+		d *= 2
+	}
+	if p.MaxBackoff > 0 && d > p.MaxBackoff {
+		d = p.MaxBackoff
+	}
+	return d
+}
+
+func (p RetryPolicy) attempts() int {
+	if p.MaxAttempts < 1 {
+		return 1
+	}
+	return p.MaxAttempts
+}
+
+// sleep waits for the duration unless the context is done first.
+func sleep(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return errors.Trace(ctx.Err())
+	case <-timer.C:
+		return nil
+	}
+}
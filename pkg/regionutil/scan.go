@@ -0,0 +1,107 @@
+// Copyright 2021 PingCAP, Inc. Licensed under Apache-2.0.
+
+package regionutil
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"sort"
+	"time"
+
+	"github.com/pingcap/errors"
+	"github.com/pingcap/log"
+	"go.uber.org/zap"
+
+	berrors "github.com/pingcap/br/pkg/errors"
+)
+
+// DefaultPageSize is the default count of the regions scanned by a request.
+const DefaultPageSize = 128
+
+// ScanOptions is the options of scanning the regions page by page.
+type ScanOptions struct {
+	// PageSize is the count of the regions scanned by a request, which keeps
+	// the gRPC messages small, 0 means DefaultPageSize.
+	PageSize int
+	// MaxRegions stops the scan once the count of the regions reaches it, 0
+	// means scanning the whole range.
+	MaxRegions int
+	// Retry is how a failed page is retried.
+	Retry RetryPolicy
+}
+
+// PaginateScanRegion scans the regions in [startKey, endKey) by pages of the
+// limit, and returns all regions at once sorted by the start keys.
+func PaginateScanRegion(ctx context.Context, client Scanner, startKey, endKey []byte, limit int) ([]*RegionInfo, error) {
+	return PaginateScanRegionWithOptions(ctx, client, startKey, endKey, ScanOptions{PageSize: limit})
+}
+
+// PaginateScanRegionWithOptions scans the regions in [startKey, endKey) page
+// by page, and returns all regions at once sorted by the start keys. The
+// context is checked between the pages, so a long scan can be canceled.
+func PaginateScanRegionWithOptions(
+	ctx context.Context, client Scanner, startKey, endKey []byte, opts ScanOptions,
+) ([]*RegionInfo, error) {
+	if len(endKey) != 0 && bytes.Compare(startKey, endKey) >= 0 {
+		return nil, errors.Annotatef(berrors.ErrInvalidArgument, "startKey >= endKey, startKey %s, endkey %s",
+			hex.EncodeToString(startKey), hex.EncodeToString(endKey))
+	}
+	limit := opts.PageSize
+	if limit <= 0 {
+		limit = DefaultPageSize
+	}
+
+	regions := []*RegionInfo{}
+	for {
+		if err := ctx.Err(); err != nil {
+			return nil, errors.Trace(err)
+		}
+		pageLimit := limit
+		if opts.MaxRegions > 0 && opts.MaxRegions-len(regions) < pageLimit {
+			pageLimit = opts.MaxRegions - len(regions)
+		}
+		batch, err := scanPage(ctx, client, startKey, endKey, pageLimit, opts.Retry)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		regions = append(regions, batch...)
+		if len(batch) < pageLimit || (opts.MaxRegions > 0 && len(regions) >= opts.MaxRegions) {
+			// No more region
+			break
+		}
+		startKey = batch[len(batch)-1].Region.GetEndKey()
+		if len(startKey) == 0 ||
+			(len(endKey) > 0 && bytes.Compare(startKey, endKey) >= 0) {
+			// All key space have scanned
+			break
+		}
+	}
+	sort.Slice(regions, func(i, j int) bool {
+		return bytes.Compare(regions[i].Region.GetStartKey(), regions[j].Region.GetStartKey()) < 0
+	})
+	return regions, nil
+}
+
+func scanPage(
+	ctx context.Context, client Scanner, startKey, endKey []byte, limit int, retry RetryPolicy,
+) ([]*RegionInfo, error) {
+	var err error
+	for attempt := 0; attempt < retry.attempts(); attempt++ {
+		if attempt > 0 {
+			scanRegionRetryCounter.Inc()
+			if err := sleep(ctx, retry.Backoff(attempt-1)); err != nil {
+				return nil, errors.Trace(err)
+			}
+		}
+		start := time.Now()
+		var batch []*RegionInfo
+		batch, err = client.ScanRegions(ctx, startKey, endKey, limit)
+		scanRegionHistogram.Observe(time.Since(start).Seconds())
+		if err == nil {
+			return batch, nil
+		}
+		log.Warn("scan regions failed", zap.Int("attempt", attempt), zap.Error(err))
+	}
+	return nil, errors.Trace(err)
+}
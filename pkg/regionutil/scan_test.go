@@ -0,0 +1,116 @@
+// Copyright 2021 PingCAP, Inc. Licensed under Apache-2.0.
+
+package regionutil_test
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	. "github.com/pingcap/check"
+	"github.com/pingcap/errors"
+	"github.com/pingcap/kvproto/pkg/metapb"
+
+	"github.com/pingcap/br/pkg/regionutil"
+)
+
+func Test(t *testing.T) {
+	TestingT(t)
+}
+
+var _ = Suite(&testScanSuite{})
+
+type testScanSuite struct{}
+
+// fakeScanner holds the regions split by the keys, and fails the first
+// failures scans.
+type fakeScanner struct {
+	regions  []*regionutil.RegionInfo
+	failures int
+	scans    int
+}
+
+func newFakeScanner(keys ...string) *fakeScanner {
+	s := &fakeScanner{}
+	var start []byte
+	for i, key := range append(keys, "") {
+		s.regions = append(s.regions, &regionutil.RegionInfo{
+			Region: &metapb.Region{Id: uint64(i + 1), StartKey: start, EndKey: []byte(key)},
+		})
+		start = []byte(key)
+	}
+	return s
+}
+
+func (s *fakeScanner) ScanRegions(_ context.Context, key, endKey []byte, limit int) ([]*regionutil.RegionInfo, error) {
+	s.scans++
+	if s.failures > 0 {
+		s.failures--
+		return nil, errors.New("scan regions failed")
+	}
+	var regions []*regionutil.RegionInfo
+	for _, region := range s.regions {
+		if len(region.Region.EndKey) != 0 && bytes.Compare(region.Region.EndKey, key) <= 0 {
+			continue
+		}
+		if len(endKey) != 0 && bytes.Compare(region.Region.StartKey, endKey) >= 0 {
+			break
+		}
+		regions = append(regions, region)
+		if len(regions) == limit {
+			break
+		}
+	}
+	return regions, nil
+}
+
+func (s *testScanSuite) TestPaginateScanRegion(c *C) {
+	ctx := context.Background()
+	scanner := newFakeScanner("b", "c", "d", "e")
+
+	regions, err := regionutil.PaginateScanRegion(ctx, scanner, nil, nil, 2)
+	c.Assert(err, IsNil)
+	c.Assert(regions, DeepEquals, scanner.regions)
+	c.Assert(scanner.scans, Equals, 3)
+
+	regions, err = regionutil.PaginateScanRegion(ctx, scanner, []byte("bb"), []byte("d"), 2)
+	c.Assert(err, IsNil)
+	c.Assert(regions, DeepEquals, scanner.regions[1:3])
+
+	regions, err = regionutil.PaginateScanRegionWithOptions(ctx, scanner, nil, nil,
+		regionutil.ScanOptions{PageSize: 2, MaxRegions: 3})
+	c.Assert(err, IsNil)
+	c.Assert(regions, DeepEquals, scanner.regions[:3])
+
+	_, err = regionutil.PaginateScanRegion(ctx, scanner, []byte("c"), []byte("b"), 2)
+	c.Assert(err, ErrorMatches, ".*startKey >= endKey.*")
+}
+
+func (s *testScanSuite) TestPaginateScanRegionRetry(c *C) {
+	ctx := context.Background()
+	scanner := newFakeScanner("b", "c")
+	scanner.failures = 2
+
+	_, err := regionutil.PaginateScanRegion(ctx, scanner, nil, nil, 2)
+	c.Assert(err, ErrorMatches, ".*scan regions failed.*")
+
+	retry := regionutil.RetryPolicy{MaxAttempts: 3, BaseBackoff: time.Millisecond}
+	regions, err := regionutil.PaginateScanRegionWithOptions(ctx, scanner, nil, nil,
+		regionutil.ScanOptions{PageSize: 2, Retry: retry})
+	c.Assert(err, IsNil)
+	c.Assert(regions, DeepEquals, scanner.regions)
+
+	canceled, cancel := context.WithCancel(ctx)
+	cancel()
+	_, err = regionutil.PaginateScanRegion(canceled, scanner, nil, nil, 2)
+	c.Assert(errors.Cause(err), Equals, context.Canceled)
+}
+
+func (s *testScanSuite) TestRetryPolicyBackoff(c *C) {
+	policy := regionutil.RetryPolicy{MaxAttempts: 10, BaseBackoff: 10 * time.Millisecond, MaxBackoff: 50 * time.Millisecond}
+	c.Assert(policy.Backoff(0), Equals, 10*time.Millisecond)
+	c.Assert(policy.Backoff(2), Equals, 40*time.Millisecond)
+	c.Assert(policy.Backoff(3), Equals, 50*time.Millisecond)
+	c.Assert(policy.Backoff(100), Equals, 50*time.Millisecond)
+}
@@ -0,0 +1,171 @@
+// Copyright 2021 PingCAP, Inc. Licensed under Apache-2.0.
+
+package regionutil
+
+import (
+	"context"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/pingcap/errors"
+	"github.com/pingcap/kvproto/pkg/pdpb"
+	"github.com/pingcap/log"
+	"go.uber.org/zap"
+	"google.golang.org/grpc/status"
+
+	berrors "github.com/pingcap/br/pkg/errors"
+	"github.com/pingcap/br/pkg/logutil"
+	"github.com/pingcap/br/pkg/utils"
+)
+
+// The default policies of waiting for the split and the scatter.
+var (
+	// DefaultSplitWait polls whether the region is split.
+	DefaultSplitWait = RetryPolicy{MaxAttempts: 64, BaseBackoff: 8 * time.Millisecond, MaxBackoff: time.Second}
+	// DefaultScatterWait polls whether the region is scattered.
+	DefaultScatterWait = RetryPolicy{MaxAttempts: 64, BaseBackoff: 50 * time.Millisecond, MaxBackoff: time.Second}
+	// DefaultScatterRetry retries the scatter rejected by PD for about 6s.
+	DefaultScatterRetry = RetryPolicy{MaxAttempts: 7, BaseBackoff: 100 * time.Millisecond}
+)
+
+var notFullyReplicated = regexp.MustCompile(`region \d+ is not fully replicated`)
+
+// WaitForSplit waits until the region split out can be found in PD.
+func WaitForSplit(ctx context.Context, client Client, regionID uint64, policy RetryPolicy) {
+	for attempt := 0; attempt < policy.attempts(); attempt++ {
+		regionInfo, err := client.GetRegionByID(ctx, regionID)
+		if err != nil {
+			log.Warn("wait for split failed", zap.Uint64("region", regionID), zap.Error(err))
+			return
+		}
+		if regionInfo != nil {
+			return
+		}
+		if sleep(ctx, policy.Backoff(attempt)) != nil {
+			return
+		}
+	}
+}
+
+// IsScatterRegionFinished returns whether the region has no running scatter
+// operator.
+func IsScatterRegionFinished(ctx context.Context, client Client, regionID uint64) (bool, error) {
+	resp, err := client.GetOperator(ctx, regionID)
+	if err != nil {
+		return false, errors.Trace(err)
+	}
+	// Heartbeat may not be sent to PD
+	if respErr := resp.GetHeader().GetError(); respErr != nil {
+		if respErr.GetType() == pdpb.ErrorType_REGION_NOT_FOUND {
+			return true, nil
+		}
+		// the scatter isn't started until the region is fully replicated.
+		if notFullyReplicated.MatchString(respErr.GetMessage()) {
+			return false, nil
+		}
+		return false, errors.Annotatef(berrors.ErrPDInvalidResponse, "get operator error: %s", respErr.GetType())
+	}
+	// If the current operator of the region is not 'scatter-region', we could assume
+	// that 'scatter-operator' has finished or timeout
+	ok := string(resp.GetDesc()) != "scatter-region" || resp.GetStatus() != pdpb.OperatorStatus_RUNNING
+	return ok, nil
+}
+
+// WaitForScatterRegion waits until the region is scattered, or the policy or
+// the context gives up.
+func WaitForScatterRegion(ctx context.Context, client Client, regionInfo *RegionInfo, policy RetryPolicy) {
+	start := time.Now()
+	defer func() {
+		scatterWaitHistogram.Observe(time.Since(start).Seconds())
+	}()
+	regionID := regionInfo.Region.GetId()
+	for attempt := 0; attempt < policy.attempts(); attempt++ {
+		ok, err := IsScatterRegionFinished(ctx, client, regionID)
+		if err != nil {
+			log.Warn("scatter region failed: do not have the region",
+				logutil.Region(regionInfo.Region), zap.Error(err))
+			return
+		}
+		if ok {
+			return
+		}
+		if sleep(ctx, policy.Backoff(attempt)) != nil {
+			return
+		}
+	}
+}
+
+// ScatterRegion scatters the region, retries if PD rejects it because the
+// region has no leader or isn't fully replicated yet.
+func ScatterRegion(ctx context.Context, client Client, regionInfo *RegionInfo, policy RetryPolicy) error {
+	err := utils.WithRetry(ctx,
+		func() error { return client.ScatterRegion(ctx, regionInfo) },
+		&scatterBackoffer{attempt: policy.attempts(), policy: policy},
+	)
+	if err != nil {
+		scatterRegionCounter.WithLabelValues("failed").Inc()
+		return errors.Trace(err)
+	}
+	scatterRegionCounter.WithLabelValues("success").Inc()
+	return nil
+}
+
+// ScatterRegions waits for the regions split out and scatters them, the
+// regions failed to scatter are skipped with a warning.
+func ScatterRegions(ctx context.Context, client Client, regions []*RegionInfo, splitWait, retry RetryPolicy) {
+	for _, region := range regions {
+		// Wait for a while until the regions successfully split.
+		WaitForSplit(ctx, client, region.Region.GetId(), splitWait)
+		if err := ScatterRegion(ctx, client, region, retry); err != nil {
+			log.Warn("scatter region failed, stop retry", logutil.Region(region.Region), zap.Error(err))
+		}
+	}
+}
+
+type scatterBackoffer struct {
+	attempt int
+	tried   int
+	policy  RetryPolicy
+}
+
+func (b *scatterBackoffer) exponentialBackoff() time.Duration {
+	b.attempt--
+	if b.attempt == 0 {
+		return 0
+	}
+	bo := b.policy.Backoff(b.tried)
+	b.tried++
+	return bo
+}
+
+func (b *scatterBackoffer) giveUp() time.Duration {
+	b.attempt = 0
+	return 0
+}
+
+// NextBackoff returns a duration to wait before retrying again
+func (b *scatterBackoffer) NextBackoff(err error) time.Duration {
+	// There are 3 type of reason that PD would reject a `scatter` request:
+	// (1) region %d has no leader
+	// (2) region %d is hot
+	// (3) region %d is not fully replicated
+	//
+	// (2) shouldn't happen in a recently splitted region.
+	// (1) and (3) might happen, and should be retried.
+	grpcErr := status.Convert(err)
+	if grpcErr == nil {
+		return b.giveUp()
+	}
+	if strings.Contains(grpcErr.Message(), "is not fully replicated") ||
+		strings.Contains(grpcErr.Message(), "has no leader") {
+		log.Info("scatter region failed, retring", logutil.ShortError(err), zap.Int("attempt-remain", b.attempt))
+		return b.exponentialBackoff()
+	}
+	return b.giveUp()
+}
+
+// Attempt returns the remain attempt times
+func (b *scatterBackoffer) Attempt() int {
+	return b.attempt
+}
@@ -55,6 +55,9 @@ type Batcher struct {
 	manager            ContextManager
 	batchSizeThreshold int
 	size               int32
+	// canceler tracks the tables canceled from the restore, whose pending
+	// ranges are dropped.
+	canceler *TableCanceler
 }
 
 // Len calculate the current size of this batcher.
@@ -266,6 +269,17 @@ func (b *Batcher) drainRanges() DrainResult {
 	defer b.cachedTablesMu.Unlock()
 
 	for offset, thisTable := range b.cachedTables {
+		if len(thisTable.Range) > 0 && b.canceler.IsCanceled(thisTable.OldTable) {
+			// the table still goes through the batch, so its context is cleaned.
+			log.Info("dropping ranges of canceled table",
+				zap.Stringer("db", thisTable.OldTable.DB.Name),
+				zap.Stringer("table", thisTable.Table.Name),
+				zap.Int("size", len(thisTable.Range)),
+			)
+			atomic.AddInt32(&b.size, -int32(len(thisTable.Range)))
+			b.cachedTables[offset].Range = []rtree.Range{}
+			thisTable.Range = []rtree.Range{}
+		}
 		thisTableLen := len(thisTable.Range)
 		collected := len(result.Ranges)
 
@@ -367,6 +381,12 @@ func (b *Batcher) Close() {
 	close(b.sendCh)
 }
 
+// SetTableCanceler sets the canceler of the tables, the pending ranges of the
+// canceled tables are dropped instead of being restored.
+func (b *Batcher) SetTableCanceler(canceler *TableCanceler) {
+	b.canceler = canceler
+}
+
 // SetThreshold sets the threshold that how big the batch size reaching need to send batch.
 // note this function isn't goroutine safe yet,
 // just set threshold before anything starts(e.g. EnableAutoCommit), please.
@@ -12,6 +12,7 @@ import (
 	"sort"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/pingcap/br/pkg/metautil"
@@ -28,6 +29,7 @@ import (
 	"github.com/pingcap/tidb/statistics/handle"
 	"github.com/pingcap/tidb/tablecodec"
 	"github.com/pingcap/tidb/util/codec"
+	"github.com/pingcap/tipb/go-tipb"
 	"github.com/tikv/client-go/v2/oracle"
 	pd "github.com/tikv/pd/client"
 	"github.com/tikv/pd/server/schedule/placement"
@@ -45,6 +47,7 @@ import (
 	"github.com/pingcap/br/pkg/logutil"
 	"github.com/pingcap/br/pkg/pdutil"
 	"github.com/pingcap/br/pkg/redact"
+	"github.com/pingcap/br/pkg/rtree"
 	"github.com/pingcap/br/pkg/storage"
 	"github.com/pingcap/br/pkg/summary"
 	"github.com/pingcap/br/pkg/utils"
@@ -62,6 +65,7 @@ type Client struct {
 	workerPool    *utils.WorkerPool
 	tlsConf       *tls.Config
 	keepaliveConf keepalive.ClientParameters
+	timeout       utils.TimeoutConfig
 
 	databases  map[string]*utils.Database
 	ddlJobs    []*model.Job
@@ -75,18 +79,60 @@ type Client struct {
 	// Before you do it, you can firstly read discussions at
 	// https://github.com/pingcap/br/pull/377#discussion_r446594501,
 	// this probably isn't as easy as it seems like (however, not hard, too :D)
-	db              *DB
-	rateLimit       uint64
-	isOnline        bool
-	noSchema        bool
+	db        *DB
+	rateLimit uint64
+	isOnline  bool
+	noSchema  bool
+	// speedLimitMu guards hasSpeedLimited, the files may be restored
+	// concurrently.
+	speedLimitMu    sync.Mutex
 	hasSpeedLimited bool
 
 	restoreStores []uint64
+	// extraRewriteRules are user provided rules applied besides the table rules.
+	extraRewriteRules *RewriteRules
+	// journal records the cluster-mutating operations, nil if disabled.
+	journal *Journal
+	// mergedTables maps the IDs of the source tables to the tables restored
+	// from many sources.
+	mergedTables map[int64]*MergedTable
+	// tableCanceler tracks the tables canceled from the restore, nil if the
+	// tables can't be canceled.
+	tableCanceler *TableCanceler
+	// checksumSplitter splits the checksum of each table into chunks, whose
+	// checkpoints of checksumScope are written to checksumStorage, nil if
+	// disabled.
+	checksumSplitter checksum.RangeSplitter
+	checksumStorage  storage.ExternalStorage
+	checksumScope    checksum.CheckpointScope
+	// granularity is the unit scheduled to the workers of the file restore.
+	granularity Granularity
+	// skippedIndexes are the names of the indexes whose data isn't backed up
+	// by the tables, they're rebuilt after the data is restored.
+	skippedIndexes map[string][]string
 
 	storage            storage.ExternalStorage
 	backend            *backuppb.StorageBackend
 	switchModeInterval time.Duration
 	switchCh           chan struct{}
+	switchOnce         sync.Once
+	// importing is whether the client holds the import mode of the cluster.
+	importing bool
+	// abnormalStores are the stores failed to be switched to the normal mode,
+	// and abnormalErr is the error failing to list the stores to switch.
+	abnormalStores []string
+	abnormalErr    error
+	// mockCluster is whether the client restores into a mock cluster, whose
+	// regions and imports live in memory only.
+	mockCluster bool
+	// regionCache caches the regions of toolClient scanned by the warm up.
+	regionCache *RegionCache
+	// isTxnKvMode is whether the backup is of a cluster used via the TxnKV
+	// client, whose files are restored to the same keys without schemas.
+	isTxnKvMode bool
+	// systemTablesPolicy is how the users, the privileges and the global
+	// variables are restored, empty means they aren't restored.
+	systemTablesPolicy SystemTablesPolicy
 
 	// statHandler and dom are used for analyze table after restore.
 	// it will backup stats with #dump.DumpStatsToJSON
@@ -118,16 +164,155 @@ func NewRestoreClient(
 		statsHandle = dom.StatsHandle()
 	}
 
-	return &Client{
+	rc := &Client{
 		pdClient:      pdClient,
-		toolClient:    NewSplitClient(pdClient, tlsConf),
 		db:            db,
 		tlsConf:       tlsConf,
 		keepaliveConf: keepaliveConf,
+		timeout:       utils.DefaultTimeoutConfig(),
 		switchCh:      make(chan struct{}),
 		dom:           dom,
 		statsHandler:  statsHandle,
-	}, nil
+	}
+	rc.setSplitClient(NewSplitClient(pdClient, tlsConf, utils.DefaultDialTimeout))
+	return rc, nil
+}
+
+// setSplitClient makes the client split and scan the regions by the client,
+// through the region cache fed by the warm up.
+func (rc *Client) setSplitClient(client SplitClient) {
+	rc.regionCache = NewRegionCache(client, defaultRegionCacheTTL)
+	rc.toolClient = rc.regionCache
+}
+
+// SetTimeoutConfig sets the timeouts of the RPCs sent by the client.
+func (rc *Client) SetTimeoutConfig(timeout utils.TimeoutConfig) {
+	timeout.Adjust()
+	rc.timeout = timeout
+	if !rc.mockCluster {
+		rc.setSplitClient(NewSplitClient(rc.pdClient, rc.tlsConf, timeout.Dial))
+	}
+}
+
+// UseMockCluster makes the client restore into a mock cluster of the stores:
+// the regions are split and scattered in memory, the files are imported
+// without touching any data, and the modes of the stores are never switched.
+// It must be called before InitBackupMeta.
+func (rc *Client) UseMockCluster(stores []*metapb.Store) {
+	rc.mockCluster = true
+	rc.setSplitClient(NewMockSplitClient(stores))
+}
+
+// IsMockCluster returns whether the client restores into a mock cluster.
+func (rc *Client) IsMockCluster() bool {
+	return rc.mockCluster
+}
+
+// SetJournal sets the journal to record the cluster-mutating operations.
+func (rc *Client) SetJournal(journal *Journal) {
+	rc.journal = journal
+}
+
+// SetChecksumChunks makes the checksum of each table executed in the chunks of
+// the regions, and the checksums of the finished chunks are recorded in the
+// storage, so a failed checksum of a large table resumes from the unfinished
+// chunks by the next restore of the same scope.
+func (rc *Client) SetChecksumChunks(regionsPerChunk uint, s storage.ExternalStorage, scope checksum.CheckpointScope) {
+	rc.checksumSplitter = checksum.NewRegionSplitter(rc.pdClient, int(regionsPerChunk))
+	rc.checksumStorage = s
+	rc.checksumScope = scope
+}
+
+// buildChecksumExecutor builds the checksum executor of the table, which is
+// executed chunk by chunk if enabled.
+func (rc *Client) buildChecksumExecutor(
+	ctx context.Context,
+	builder *checksum.ExecutorBuilder,
+	tableID int64,
+) (*checksum.Executor, error) {
+	if rc.checksumSplitter != nil {
+		checkpoint, err := checksum.LoadCheckpoint(ctx, rc.checksumStorage, rc.checksumScope, tableID)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		builder.SetChunks(rc.checksumSplitter, checkpoint)
+	}
+	exe, err := builder.Build()
+	return exe, errors.Trace(err)
+}
+
+// SetMergedTables sets the tables restored from many sources, whose checksums
+// are validated against all the sources once.
+func (rc *Client) SetMergedTables(tables []*MergedTable) {
+	rc.mergedTables = make(map[int64]*MergedTable)
+	for _, table := range tables {
+		for _, source := range table.Sources {
+			rc.mergedTables[source.Info.ID] = table
+		}
+	}
+}
+
+// SetTableCanceler sets the canceler of the tables, the checksums of the
+// canceled tables are skipped.
+func (rc *Client) SetTableCanceler(canceler *TableCanceler) {
+	rc.tableCanceler = canceler
+}
+
+// SetSystemTablesPolicy enables restoring PrivilegeSystemTables by the policy.
+func (rc *Client) SetSystemTablesPolicy(policy SystemTablesPolicy) {
+	rc.systemTablesPolicy = policy
+}
+
+// SetWatchdog sets the watchdog tracking the download and ingest requests,
+// it must be called after InitBackupMeta.
+func (rc *Client) SetWatchdog(watchdog *Watchdog) {
+	rc.fileImporter.watchdog = watchdog
+}
+
+// LimitStoreImportConcurrency caps the in-flight download and ingest requests
+// of each store, it must be called after InitBackupMeta. The limit is read
+// from `import.num-threads` of each store if n is 0, otherwise n applies to
+// all stores. A negative n disables the limit.
+func (rc *Client) LimitStoreImportConcurrency(ctx context.Context, n int) error {
+	if n < 0 {
+		return nil
+	}
+	if n > 0 {
+		rc.fileImporter.storeLimiter = newStoreLimiter(nil, n)
+		return nil
+	}
+	if rc.mockCluster {
+		rc.fileImporter.storeLimiter = newStoreLimiter(nil, DefaultStoreImportConcurrency)
+		return nil
+	}
+	stores, err := conn.GetAllTiKVStores(ctx, rc.pdClient, conn.SkipTiFlash)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	limits := fetchStoreImportConcurrencies(ctx, rc.tlsConf, stores)
+	log.Info("limit the import concurrency of stores", zap.Any("limits", limits))
+	rc.fileImporter.storeLimiter = newStoreLimiter(limits, DefaultStoreImportConcurrency)
+	return nil
+}
+
+// LimitClusterWriteSpeed caps the bytes downloaded to all stores of the
+// cluster per second, so the restore into a live cluster has a total budget
+// besides the download speed limit of each store. It must be called after
+// InitBackupMeta. 0 means unlimited.
+func (rc *Client) LimitClusterWriteSpeed(bytesPerSec uint64) {
+	if bytesPerSec == 0 {
+		rc.fileImporter.writeLimiter = nil
+		return
+	}
+	log.Info("limit the write speed of the cluster", zap.Uint64("bytes-per-sec", bytesPerSec))
+	rc.fileImporter.writeLimiter = newClusterWriteLimiter(bytesPerSec)
+}
+
+// SetFileSources sets where the contents of the data files deduplicated
+// against the other backups are, by their names in the backupmeta. It must be
+// called after InitBackupMeta.
+func (rc *Client) SetFileSources(sources map[string]FileSource) {
+	rc.fileImporter.fileSources = sources
 }
 
 // SetRateLimit to set rateLimit.
@@ -146,6 +331,21 @@ func (rc *Client) SetStorage(ctx context.Context, backend *backuppb.StorageBacke
 	return nil
 }
 
+// SetExtraRewriteRules sets the user provided rewrite rules, which take
+// precedence over the rules generated from table IDs, see mergeRewriteRules.
+// They're applied the same way when splitting the regions and importing the
+// files of the tables or the raw kv, and when writing the kvs of the logs.
+func (rc *Client) SetExtraRewriteRules(rules *RewriteRules) {
+	rc.extraRewriteRules = rules
+}
+
+// withExtraRewriteRules returns the rules splitting the regions and importing
+// the files rewritten by the table rules, with the user provided rules first.
+func (rc *Client) withExtraRewriteRules(rewriteRules *RewriteRules) (*RewriteRules, error) {
+	rules, err := MergeRewriteRules(rewriteRules, rc.extraRewriteRules)
+	return rules, errors.Trace(err)
+}
+
 // GetPDClient returns a pd client.
 func (rc *Client) GetPDClient() pd.Client {
 	return rc.pdClient
@@ -171,8 +371,16 @@ func (rc *Client) Close() {
 }
 
 // InitBackupMeta loads schemas from BackupMeta to initialize RestoreClient.
-func (rc *Client) InitBackupMeta(c context.Context, backupMeta *backuppb.BackupMeta, backend *backuppb.StorageBackend, externalStorage storage.ExternalStorage, reader *metautil.MetaReader) error {
-	if !backupMeta.IsRawKv {
+// The schemas and the DDLs are read by the reader, and the data files are
+// downloaded from the backend, so the backupmeta can be staged apart from the
+// data.
+func (rc *Client) InitBackupMeta(
+	c context.Context,
+	backupMeta *backuppb.BackupMeta,
+	backend *backuppb.StorageBackend,
+	reader *metautil.MetaReader,
+) error {
+	if !backupMeta.IsRawKv && !rc.isTxnKvMode {
 		databases, err := utils.LoadBackupTables(c, reader)
 		if err != nil {
 			return errors.Trace(err)
@@ -196,9 +404,14 @@ func (rc *Client) InitBackupMeta(c context.Context, backupMeta *backuppb.BackupM
 	rc.backupMeta = backupMeta
 	log.Info("load backupmeta", zap.Int("databases", len(rc.databases)), zap.Int("jobs", len(rc.ddlJobs)))
 
-	metaClient := NewSplitClient(rc.pdClient, rc.tlsConf)
-	importCli := NewImportClient(metaClient, rc.tlsConf, rc.keepaliveConf)
-	rc.fileImporter = NewFileImporter(metaClient, importCli, backend, rc.backupMeta.IsRawKv, rc.rateLimit)
+	var importCli ImporterClient
+	if rc.mockCluster {
+		importCli = NewMockImportClient()
+	} else {
+		importCli = NewImportClient(rc.toolClient, rc.tlsConf, rc.keepaliveConf, rc.timeout)
+	}
+	rc.fileImporter = NewFileImporter(rc.toolClient, importCli, backend, rc.backupMeta.IsRawKv, rc.rateLimit)
+	rc.fileImporter.isTxnKvMode = rc.isTxnKvMode
 	return rc.fileImporter.CheckMultiIngestSupport(c, rc.pdClient)
 }
 
@@ -207,31 +420,40 @@ func (rc *Client) IsRawKvMode() bool {
 	return rc.backupMeta.IsRawKv
 }
 
+// EnableTxnKvMode restores the backup of a cluster used via the TxnKV client,
+// it must be called before InitBackupMeta.
+func (rc *Client) EnableTxnKvMode() {
+	rc.isTxnKvMode = true
+}
+
+// IsTxnKvMode checks whether the backup data is restored in txn kv mode.
+func (rc *Client) IsTxnKvMode() bool {
+	return rc.isTxnKvMode
+}
+
 // GetFilesInRawRange gets all files that are in the given range or intersects with the given range.
+// The backup may record many ranges, the given range can be any subset of
+// them as long as the adjacent ranges cover it without gaps.
 func (rc *Client) GetFilesInRawRange(startKey []byte, endKey []byte, cf string) ([]*backuppb.File, error) {
 	if !rc.IsRawKvMode() {
 		return nil, errors.Annotate(berrors.ErrRestoreModeMismatch, "the backup data is not in raw kv mode")
 	}
 
-	for _, rawRange := range rc.backupMeta.RawRanges {
-		// First check whether the given range is backup-ed. If not, we cannot perform the restore.
-		if rawRange.Cf != cf {
-			continue
-		}
-
-		if (len(rawRange.EndKey) > 0 && bytes.Compare(startKey, rawRange.EndKey) >= 0) ||
-			(len(endKey) > 0 && bytes.Compare(rawRange.StartKey, endKey) >= 0) {
+	spans := mergeRawRanges(rc.backupMeta.RawRanges, cf)
+	for _, span := range spans {
+		if (len(span.EndKey) > 0 && bytes.Compare(startKey, span.EndKey) >= 0) ||
+			(len(endKey) > 0 && bytes.Compare(span.StartKey, endKey) >= 0) {
 			// The restoring range is totally out of the current range. Skip it.
 			continue
 		}
 
-		if bytes.Compare(startKey, rawRange.StartKey) < 0 ||
-			utils.CompareEndKey(endKey, rawRange.EndKey) > 0 {
+		if bytes.Compare(startKey, span.StartKey) < 0 ||
+			utils.CompareEndKey(endKey, span.EndKey) > 0 {
 			// Only partial of the restoring range is in the current backup-ed range. So the given range can't be fully
 			// restored.
 			return nil, errors.Annotatef(berrors.ErrRestoreRangeMismatch,
 				"the given range to restore [%s, %s) is not fully covered by the range that was backed up [%s, %s)",
-				redact.Key(startKey), redact.Key(endKey), redact.Key(rawRange.StartKey), redact.Key(rawRange.EndKey),
+				redact.Key(startKey), redact.Key(endKey), redact.Key(span.StartKey), redact.Key(span.EndKey),
 			)
 		}
 
@@ -256,18 +478,51 @@ func (rc *Client) GetFilesInRawRange(startKey []byte, endKey []byte, cf string)
 			files = append(files, file)
 		}
 
-		// There should be at most one backed up range that covers the restoring range.
+		// There should be at most one merged range that covers the restoring range.
 		return files, nil
 	}
 
 	return nil, errors.Annotate(berrors.ErrRestoreRangeMismatch, "no backup data in the range")
 }
 
+// mergeRawRanges returns the backed up ranges of the column family sorted by
+// the start keys, with the adjacent and overlapping ones merged.
+func mergeRawRanges(rawRanges []*backuppb.RawRange, cf string) []rtree.Range {
+	ranges := make([]rtree.Range, 0, len(rawRanges))
+	for _, rawRange := range rawRanges {
+		if rawRange.Cf == cf {
+			ranges = append(ranges, rtree.Range{StartKey: rawRange.StartKey, EndKey: rawRange.EndKey})
+		}
+	}
+	sort.Slice(ranges, func(i, j int) bool { return bytes.Compare(ranges[i].StartKey, ranges[j].StartKey) < 0 })
+
+	merged := make([]rtree.Range, 0, len(ranges))
+	for _, rg := range ranges {
+		if n := len(merged); n > 0 {
+			last := &merged[n-1]
+			// the range starts within or right at the end of the last one.
+			if len(last.EndKey) == 0 || bytes.Compare(rg.StartKey, last.EndKey) <= 0 {
+				if utils.CompareEndKey(rg.EndKey, last.EndKey) > 0 {
+					last.EndKey = rg.EndKey
+				}
+				continue
+			}
+		}
+		merged = append(merged, rg)
+	}
+	return merged
+}
+
 // SetConcurrency sets the concurrency of dbs tables files.
 func (rc *Client) SetConcurrency(c uint) {
 	rc.workerPool = utils.NewWorkerPool(c, "file")
 }
 
+// SetGranularity sets the unit scheduled to the workers of the file restore.
+func (rc *Client) SetGranularity(granularity Granularity) {
+	rc.granularity = granularity
+}
+
 // EnableOnline sets the mode of restore to online.
 func (rc *Client) EnableOnline() {
 	rc.isOnline = true
@@ -353,6 +608,10 @@ func (rc *Client) CreateDatabase(ctx context.Context, db *model.DBInfo) error {
 		log.Info("skip create database", zap.Stringer("database", db.Name))
 		return nil
 	}
+	err := rc.journal.Record(ctx, JournalEntry{Op: JournalOpCreateDatabase, DB: db.Name.O})
+	if err != nil {
+		return errors.Trace(err)
+	}
 	return rc.db.CreateDatabase(ctx, db)
 }
 
@@ -402,7 +661,15 @@ func (rc *Client) createTable(
 	if rc.IsSkipCreateSQL() {
 		log.Info("skip create table and alter autoIncID", zap.Stringer("table", table.Info.Name))
 	} else {
-		err := db.CreateTable(ctx, table)
+		err := rc.journal.Record(ctx, JournalEntry{
+			Op:    JournalOpCreateTable,
+			DB:    table.DB.Name.O,
+			Table: table.Info.Name.O,
+		})
+		if err != nil {
+			return CreatedTable{}, errors.Trace(err)
+		}
+		err = db.CreateTable(ctx, rc.withoutSkippedIndexes(table))
 		if err != nil {
 			return CreatedTable{}, errors.Trace(err)
 		}
@@ -476,14 +743,19 @@ func (rc *Client) GoCreateTables(
 	go func() {
 		defer close(outCh)
 		defer log.Debug("all tables are created")
-		var err error
-		if len(dbPool) > 0 {
-			err = rc.createTablesWithDBPool(ctx, createOneTable, tables, dbPool)
-		} else {
-			err = rc.createTablesWithSoleDB(ctx, createOneTable, tables)
-		}
-		if err != nil {
-			errCh <- err
+		// create the sequences, the tables and the views in the dependency
+		// order, only the tables of the same stage are created concurrently.
+		for _, stage := range SortTablesByDependency(tables) {
+			var err error
+			if len(dbPool) > 0 {
+				err = rc.createTablesWithDBPool(ctx, createOneTable, stage, dbPool)
+			} else {
+				err = rc.createTablesWithSoleDB(ctx, createOneTable, stage)
+			}
+			if err != nil {
+				errCh <- err
+				return
+			}
 		}
 	}()
 	return outCh
@@ -523,7 +795,11 @@ func (rc *Client) ExecDDLs(ctx context.Context, ddlJobs []*model.Job) error {
 	})
 
 	for _, job := range ddlJobs {
-		err := rc.db.ExecDDL(ctx, job)
+		err := rc.journal.Record(ctx, JournalEntry{Op: JournalOpExecDDL, DB: job.SchemaName, Query: job.Query})
+		if err != nil {
+			return errors.Trace(err)
+		}
+		err = rc.db.ExecDDL(ctx, job)
 		if err != nil {
 			return errors.Trace(err)
 		}
@@ -536,6 +812,8 @@ func (rc *Client) ExecDDLs(ctx context.Context, ddlJobs []*model.Job) error {
 }
 
 func (rc *Client) setSpeedLimit(ctx context.Context) error {
+	rc.speedLimitMu.Lock()
+	defer rc.speedLimitMu.Unlock()
 	if !rc.hasSpeedLimited && rc.rateLimit != 0 {
 		stores, err := conn.GetAllTiKVStores(ctx, rc.pdClient, conn.SkipTiFlash)
 		if err != nil {
@@ -584,6 +862,31 @@ func drainFilesByRange(files []*backuppb.File, supportMulti bool) ([]*backuppb.F
 	return files[:idx], files[idx:]
 }
 
+// fileRanges groups the files by their ranges, the files of a range are
+// imported together.
+func (rc *Client) fileRanges(files []*backuppb.File) [][]*backuppb.File {
+	var ranges [][]*backuppb.File
+	for len(files) > 0 {
+		var rangeFiles []*backuppb.File
+		rangeFiles, files = drainFilesByRange(files, rc.fileImporter.supportMultiIngest)
+		ranges = append(ranges, rangeFiles)
+	}
+	return ranges
+}
+
+// importFiles imports the files of a range, the unit scheduled to the workers.
+func (rc *Client) importFiles(
+	ctx context.Context, files []*backuppb.File, rewriteRules *RewriteRules, updateCh glue.Progress,
+) error {
+	fileStart := time.Now()
+	defer func() {
+		log.Info("import files done", logutil.Files(files),
+			zap.Duration("take", time.Since(fileStart)))
+		updateCh.Inc()
+	}()
+	return rc.fileImporter.Import(ctx, files, rewriteRules)
+}
+
 // RestoreFiles tries to restore the files.
 func (rc *Client) RestoreFiles(
 	ctx context.Context,
@@ -596,7 +899,7 @@ func (rc *Client) RestoreFiles(
 		elapsed := time.Since(start)
 		if err == nil {
 			log.Info("Restore files", zap.Duration("take", elapsed), logutil.Files(files))
-			summary.CollectSuccessUnit("files", len(files), elapsed)
+			summary.FromContext(ctx).CollectSuccessUnit("files", len(files), elapsed)
 		}
 	}()
 
@@ -613,25 +916,21 @@ func (rc *Client) RestoreFiles(
 	if err != nil {
 		return errors.Trace(err)
 	}
+	rewriteRules, err = rc.withExtraRewriteRules(rewriteRules)
+	if err != nil {
+		return errors.Trace(err)
+	}
 
-	var rangeFiles []*backuppb.File
-	var leftFiles []*backuppb.File
-	for rangeFiles, leftFiles = drainFilesByRange(files, rc.fileImporter.supportMultiIngest); len(rangeFiles) != 0; rangeFiles, leftFiles = drainFilesByRange(leftFiles, rc.fileImporter.supportMultiIngest) {
+	for _, rangeFiles := range rc.fileRanges(files) {
 		filesReplica := rangeFiles
 		rc.workerPool.ApplyOnErrorGroup(eg,
 			func() error {
-				fileStart := time.Now()
-				defer func() {
-					log.Info("import files done", logutil.Files(filesReplica),
-						zap.Duration("take", time.Since(fileStart)))
-					updateCh.Inc()
-				}()
-				return rc.fileImporter.Import(ectx, filesReplica, rewriteRules)
+				return rc.importFiles(ectx, filesReplica, rewriteRules, updateCh)
 			})
 	}
 
 	if err := eg.Wait(); err != nil {
-		summary.CollectFailureUnit("file", err)
+		summary.FromContext(ctx).CollectFailureUnit("file", err)
 		log.Error(
 			"restore files failed",
 			zap.Error(err),
@@ -661,13 +960,18 @@ func (rc *Client) RestoreRaw(
 	if err != nil {
 		return errors.Trace(err)
 	}
+	// the raw kv is rewritten by the user provided rules only.
+	rewriteRules, err := rc.withExtraRewriteRules(EmptyRewriteRule())
+	if err != nil {
+		return errors.Trace(err)
+	}
 
 	for _, file := range files {
 		fileReplica := file
 		rc.workerPool.ApplyOnErrorGroup(eg,
 			func() error {
 				defer updateCh.Inc()
-				return rc.fileImporter.Import(ectx, []*backuppb.File{fileReplica}, EmptyRewriteRule())
+				return rc.fileImporter.Import(ectx, []*backuppb.File{fileReplica}, rewriteRules)
 			})
 	}
 	if err := eg.Wait(); err != nil {
@@ -687,8 +991,62 @@ func (rc *Client) RestoreRaw(
 	return nil
 }
 
+// RestoreTxn tries to restore the files of the txn kv backup to the same keys.
+// The keys keep the commit ts of the backup, so the timestamp of PD should be
+// reset to the backup ts before the data is read.
+func (rc *Client) RestoreTxn(ctx context.Context, files []*backuppb.File, updateCh glue.Progress) error {
+	if !rc.isTxnKvMode {
+		return errors.Annotate(berrors.ErrRestoreModeMismatch, "the client is not in txn kv mode")
+	}
+	start := time.Now()
+	defer func() {
+		log.Info("Restore Txn", zap.Int("files", len(files)), zap.Duration("take", time.Since(start)))
+	}()
+	eg, ectx := errgroup.WithContext(ctx)
+	if err := rc.setSpeedLimit(ctx); err != nil {
+		return errors.Trace(err)
+	}
+	for _, file := range files {
+		fileReplica := file
+		rc.workerPool.ApplyOnErrorGroup(eg,
+			func() error {
+				defer updateCh.Inc()
+				return rc.fileImporter.Import(ectx, []*backuppb.File{fileReplica}, EmptyRewriteRule())
+			})
+	}
+	if err := eg.Wait(); err != nil {
+		summary.FromContext(ctx).CollectFailureUnit("file", err)
+		log.Error("restore txn files failed", zap.Error(err))
+		return errors.Trace(err)
+	}
+	summary.FromContext(ctx).CollectSuccessUnit("files", len(files), time.Since(start))
+	return nil
+}
+
+var (
+	importModeMu sync.Mutex
+	// importModeHolders counts the restores holding the import mode of each
+	// cluster in the process, the cluster is switched back to the normal mode
+	// only when the last one finishes.
+	importModeHolders = make(map[uint64]int)
+)
+
+// ImportModeHolders returns the number of the restores in the process holding
+// the import mode of the cluster of the client.
+func (rc *Client) ImportModeHolders(ctx context.Context) int {
+	clusterID := rc.pdClient.GetClusterID(ctx)
+	importModeMu.Lock()
+	defer importModeMu.Unlock()
+	return importModeHolders[clusterID]
+}
+
 // SwitchToImportMode switch tikv cluster to import mode.
 func (rc *Client) SwitchToImportMode(ctx context.Context) {
+	clusterID := rc.pdClient.GetClusterID(ctx)
+	importModeMu.Lock()
+	importModeHolders[clusterID]++
+	rc.importing = true
+	importModeMu.Unlock()
 	// tikv automatically switch to normal mode in every 10 minutes
 	// so we need ping tikv in less than 10 minute
 	go func() {
@@ -697,7 +1055,7 @@ func (rc *Client) SwitchToImportMode(ctx context.Context) {
 
 		// [important!] switch tikv mode into import at the beginning
 		log.Info("switch to import mode at beginning")
-		err := rc.switchTiKVMode(ctx, import_sstpb.SwitchMode_Import)
+		_, err := rc.switchTiKVMode(ctx, import_sstpb.SwitchMode_Import)
 		if err != nil {
 			log.Warn("switch to import mode failed", zap.Error(err))
 		}
@@ -708,7 +1066,7 @@ func (rc *Client) SwitchToImportMode(ctx context.Context) {
 				return
 			case <-tick.C:
 				log.Info("switch to import mode")
-				err := rc.switchTiKVMode(ctx, import_sstpb.SwitchMode_Import)
+				_, err := rc.switchTiKVMode(ctx, import_sstpb.SwitchMode_Import)
 				if err != nil {
 					log.Warn("switch to import mode failed", zap.Error(err))
 				}
@@ -720,50 +1078,91 @@ func (rc *Client) SwitchToImportMode(ctx context.Context) {
 	}()
 }
 
-// SwitchToNormalMode switch tikv cluster to normal mode.
+// SwitchToNormalMode switch tikv cluster to normal mode. The cluster is kept in
+// the import mode if other restores of the process are still importing into it.
 func (rc *Client) SwitchToNormalMode(ctx context.Context) error {
-	close(rc.switchCh)
-	return rc.switchTiKVMode(ctx, import_sstpb.SwitchMode_Normal)
+	rc.switchOnce.Do(func() { close(rc.switchCh) })
+	clusterID := rc.pdClient.GetClusterID(ctx)
+	importModeMu.Lock()
+	if rc.importing {
+		rc.importing = false
+		importModeHolders[clusterID]--
+	}
+	holders := importModeHolders[clusterID]
+	if holders <= 0 {
+		delete(importModeHolders, clusterID)
+	}
+	importModeMu.Unlock()
+	if holders > 0 {
+		log.Info("other restores are still importing, keep the import mode", zap.Int("restores", holders))
+		return nil
+	}
+	rc.abnormalStores, rc.abnormalErr = rc.switchTiKVMode(ctx, import_sstpb.SwitchMode_Normal)
+	if rc.abnormalErr == nil && len(rc.abnormalStores) > 0 {
+		return errors.Annotatef(berrors.ErrKVUnknown, "failed to switch the stores %v to normal mode", rc.abnormalStores)
+	}
+	return errors.Trace(rc.abnormalErr)
 }
 
-func (rc *Client) switchTiKVMode(ctx context.Context, mode import_sstpb.SwitchMode) error {
+// AbnormalStores returns the addresses of the TiKV stores which
+// SwitchToNormalMode failed to switch, they may stay in the import mode until
+// TiKV switches them back by itself. It returns the error if the stores
+// couldn't be listed, and never switches the mode of the stores.
+func (rc *Client) AbnormalStores() ([]string, error) {
+	return rc.abnormalStores, rc.abnormalErr
+}
+
+// switchTiKVMode switches all TiKV stores to the mode, and returns the
+// addresses of the stores failed to switch.
+func (rc *Client) switchTiKVMode(ctx context.Context, mode import_sstpb.SwitchMode) ([]string, error) {
+	if rc.mockCluster {
+		return nil, nil
+	}
 	stores, err := conn.GetAllTiKVStores(ctx, rc.pdClient, conn.SkipTiFlash)
 	if err != nil {
-		return errors.Trace(err)
+		return nil, errors.Trace(err)
 	}
-	bfConf := backoff.DefaultConfig
-	bfConf.MaxDelay = time.Second * 3
+	var failed []string
 	for _, store := range stores {
-		opt := grpc.WithInsecure()
-		if rc.tlsConf != nil {
-			opt = grpc.WithTransportCredentials(credentials.NewTLS(rc.tlsConf))
-		}
-		gctx, cancel := context.WithTimeout(ctx, time.Second*5)
-		connection, err := grpc.DialContext(
-			gctx,
-			store.GetAddress(),
-			opt,
-			grpc.WithConnectParams(grpc.ConnectParams{Backoff: bfConf}),
-			// we don't need to set keepalive timeout here, because the connection lives
-			// at most 5s. (shorter than minimal value for keepalive time!)
-		)
-		cancel()
-		if err != nil {
-			return errors.Trace(err)
-		}
-		client := import_sstpb.NewImportSSTClient(connection)
-		_, err = client.SwitchMode(ctx, &import_sstpb.SwitchModeRequest{
-			Mode: mode,
-		})
-		if err != nil {
-			return errors.Trace(err)
-		}
-		err = connection.Close()
-		if err != nil {
-			log.Error("close grpc connection failed in switch mode", zap.Error(err))
-			continue
+		if err := rc.switchStoreMode(ctx, store, mode); err != nil {
+			log.Warn("failed to switch the mode of the store",
+				zap.Uint64("store", store.GetId()), zap.Stringer("mode", mode), zap.Error(err))
+			failed = append(failed, store.GetAddress())
 		}
 	}
+	return failed, nil
+}
+
+func (rc *Client) switchStoreMode(ctx context.Context, store *metapb.Store, mode import_sstpb.SwitchMode) error {
+	bfConf := backoff.DefaultConfig
+	bfConf.MaxDelay = time.Second * 3
+	opt := grpc.WithInsecure()
+	if rc.tlsConf != nil {
+		opt = grpc.WithTransportCredentials(credentials.NewTLS(rc.tlsConf))
+	}
+	gctx, cancel := context.WithTimeout(ctx, rc.timeout.Dial)
+	connection, err := grpc.DialContext(
+		gctx,
+		store.GetAddress(),
+		opt,
+		grpc.WithConnectParams(grpc.ConnectParams{Backoff: bfConf}),
+		// we don't need to set keepalive timeout here, because the connection lives
+		// at most 5s. (shorter than minimal value for keepalive time!)
+	)
+	cancel()
+	if err != nil {
+		return errors.Trace(err)
+	}
+	client := import_sstpb.NewImportSSTClient(connection)
+	_, err = client.SwitchMode(ctx, &import_sstpb.SwitchModeRequest{
+		Mode: mode,
+	})
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if err = connection.Close(); err != nil {
+		log.Error("close grpc connection failed in switch mode", zap.Error(err))
+	}
 	return nil
 }
 
@@ -799,12 +1198,19 @@ func (rc *Client) GoValidateChecksum(
 				if !ok {
 					return
 				}
+				if rc.tableCanceler.Finish(tbl.OldTable) {
+					log.Warn("skip checksum of canceled table",
+						zap.String("db", tbl.OldTable.DB.Name.O),
+						zap.String("table", tbl.OldTable.Info.Name.O))
+					updateCh.Inc()
+					continue
+				}
 				workers.ApplyOnErrorGroup(wg, func() error {
 					start := time.Now()
 					defer func() {
 						elapsed := time.Since(start)
-						summary.CollectDuration("restore checksum", elapsed)
-						summary.CollectSuccessUnit("table checksum", 1, elapsed)
+						summary.FromContext(ctx).CollectDuration("restore checksum", elapsed)
+						summary.FromContext(ctx).CollectSuccessUnit("table checksum", 1, elapsed)
 					}()
 					err := rc.execChecksum(ectx, tbl, kvClient, concurrency)
 					if err != nil {
@@ -825,6 +1231,10 @@ func (rc *Client) execChecksum(ctx context.Context, tbl CreatedTable, kvClient k
 		zap.String("table", tbl.OldTable.Info.Name.O),
 	)
 
+	if merged, ok := rc.mergedTables[tbl.OldTable.Info.ID]; ok {
+		return rc.execMergedChecksum(ctx, tbl, merged, kvClient, concurrency)
+	}
+
 	if tbl.OldTable.NoChecksum() {
 		logger.Warn("table has no checksum, skipping checksum")
 		return nil
@@ -836,19 +1246,27 @@ func (rc *Client) execChecksum(ctx context.Context, tbl CreatedTable, kvClient k
 		ctx = opentracing.ContextWithSpan(ctx, span1)
 	}
 
+	ctx, cancel := utils.ContextWithTimeout(ctx, rc.timeout.Checksum)
+	defer cancel()
+
 	startTS, err := rc.GetTS(ctx)
 	if err != nil {
 		return errors.Trace(err)
 	}
-	exe, err := checksum.NewExecutorBuilder(tbl.Table, startTS).
+	exe, err := rc.buildChecksumExecutor(ctx, checksum.NewExecutorBuilder(tbl.Table, startTS).
 		SetOldTable(tbl.OldTable).
-		SetConcurrency(concurrency).
-		Build()
+		SetConcurrency(concurrency), tbl.Table.ID)
 	if err != nil {
 		return errors.Trace(err)
 	}
-	checksumResp, err := exe.Execute(ctx, kvClient, func() {
-		// TODO: update progress here.
+	var checksumResp *tipb.ChecksumResponse
+	// the checksum requests fail when the PD leader switches, retry them
+	// after the PD client reconnects.
+	err = pdutil.WithLeaderSwitchRetry(ctx, func() error {
+		checksumResp, err = exe.Execute(ctx, kvClient, func() {
+			// TODO: update progress here.
+		})
+		return err
 	})
 	if err != nil {
 		return errors.Trace(err)
@@ -941,6 +1359,14 @@ func (rc *Client) SetupPlacementRules(ctx context.Context, tables []*model.Table
 		Op:     "in",
 		Values: []string{restoreLabelValue},
 	})
+	tableIDs := make([]int64, 0, len(tables))
+	for _, t := range tables {
+		tableIDs = append(tableIDs, t.ID)
+	}
+	err = rc.journal.Record(ctx, JournalEntry{Op: JournalOpSetPlacement, TableIDs: tableIDs})
+	if err != nil {
+		return errors.Trace(err)
+	}
 	for _, t := range tables {
 		rule.ID = rc.getRuleID(t.ID)
 		rule.StartKeyHex = hex.EncodeToString(codec.EncodeBytes([]byte{}, tablecodec.EncodeTablePrefix(t.ID)))
@@ -1020,18 +1446,27 @@ func (rc *Client) ResetPlacementRules(ctx context.Context, tables []*model.Table
 		return nil
 	}
 	log.Info("start reseting placement rules")
-	var failedTables []int64
+	tableIDs := make([]int64, 0, len(tables))
 	for _, t := range tables {
-		err := rc.toolClient.DeletePlacementRule(ctx, "pd", rc.getRuleID(t.ID))
+		tableIDs = append(tableIDs, t.ID)
+	}
+	return rc.DeletePlacementRules(ctx, tableIDs)
+}
+
+// DeletePlacementRules removes placement rules set by restore for the tables.
+func (rc *Client) DeletePlacementRules(ctx context.Context, tableIDs []int64) error {
+	var failedTables []int64
+	for _, id := range tableIDs {
+		err := rc.toolClient.DeletePlacementRule(ctx, "pd", rc.getRuleID(id))
 		if err != nil {
-			log.Info("failed to delete placement rule for table", zap.Int64("table-id", t.ID))
-			failedTables = append(failedTables, t.ID)
+			log.Info("failed to delete placement rule for table", zap.Int64("table-id", id))
+			failedTables = append(failedTables, id)
 		}
 	}
 	if len(failedTables) > 0 {
 		return errors.Annotatef(berrors.ErrPDInvalidResponse, "failed to delete placement rules for tables %v", failedTables)
 	}
-	return nil
+	return rc.journal.Record(ctx, JournalEntry{Op: JournalOpResetPlacement, TableIDs: tableIDs})
 }
 
 func (rc *Client) getRuleID(tableID int64) string {
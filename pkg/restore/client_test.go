@@ -21,6 +21,7 @@ import (
 	"google.golang.org/grpc/keepalive"
 
 	"github.com/pingcap/br/pkg/gluetidb"
+	"github.com/pingcap/br/pkg/gluetikv"
 	"github.com/pingcap/br/pkg/mock"
 	"github.com/pingcap/br/pkg/restore"
 )
@@ -186,6 +187,42 @@ func (fpdc fakePDClient) GetAllStores(context.Context, ...pd.GetStoreOption) ([]
 	return append([]*metapb.Store{}, fpdc.stores...), nil
 }
 
+func (fpdc fakePDClient) GetClusterID(context.Context) uint64 {
+	return 1
+}
+
+func (s *testRestoreClientSuite) TestImportModeOfOverlappingRestores(c *C) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	stores := []*metapb.Store{{Id: 1}}
+	newClient := func() *restore.Client {
+		client, err := restore.NewRestoreClient(gluetikv.Glue{}, fakePDClient{stores: stores}, nil, nil, defaultKeepaliveCfg)
+		c.Assert(err, IsNil)
+		client.UseMockCluster(stores)
+		client.SetSwitchModeInterval(time.Minute)
+		return client
+	}
+	job1, job2 := newClient(), newClient()
+
+	job1.SwitchToImportMode(ctx)
+	c.Assert(job1.ImportModeHolders(ctx), Equals, 1)
+	job2.SwitchToImportMode(ctx)
+	c.Assert(job2.ImportModeHolders(ctx), Equals, 2)
+
+	// the first restore finishing keeps the import mode for the second one,
+	// even if it switches to the normal mode again.
+	c.Assert(job1.SwitchToNormalMode(ctx), IsNil)
+	c.Assert(job1.ImportModeHolders(ctx), Equals, 1)
+	c.Assert(job1.SwitchToNormalMode(ctx), IsNil)
+	c.Assert(job2.ImportModeHolders(ctx), Equals, 1)
+
+	c.Assert(job2.SwitchToNormalMode(ctx), IsNil)
+	c.Assert(job2.ImportModeHolders(ctx), Equals, 0)
+	stray, err := job2.AbnormalStores()
+	c.Assert(err, IsNil)
+	c.Assert(stray, HasLen, 0)
+}
+
 func (s *testRestoreClientSuite) TestPreCheckTableTiFlashReplicas(c *C) {
 	c.Assert(s.mock.Start(), IsNil)
 	defer s.mock.Stop()
@@ -0,0 +1,85 @@
+// Copyright 2021 PingCAP, Inc. Licensed under Apache-2.0.
+
+package restore
+
+import (
+	"plugin"
+	"strings"
+
+	"github.com/pingcap/errors"
+	filter "github.com/pingcap/tidb-tools/pkg/table-filter"
+
+	"github.com/pingcap/br/pkg/cdclog"
+	berrors "github.com/pingcap/br/pkg/errors"
+)
+
+// ColumnTransformRule transforms the column of the tables matched by the
+// filter when restoring the log, e.g. masks the sensitive data.
+type ColumnTransformRule struct {
+	Filter    filter.Filter
+	Column    string
+	Transform cdclog.Transform
+}
+
+// ParseColumnTransformRules parses the rules in the form of
+// `pattern:column=transform`, where the pattern is a table filter and the
+// transform is parsed by cdclog.ParseTransform, e.g. `prod.users:email=hash`.
+// The transforms `plugin(name)` are loaded from the Go plugin in pluginPath.
+func ParseColumnTransformRules(specs []string, pluginPath string) ([]ColumnTransformRule, error) {
+	var p *plugin.Plugin
+	if pluginPath != "" {
+		var err error
+		if p, err = cdclog.LoadTransformPlugin(pluginPath); err != nil {
+			return nil, errors.Trace(err)
+		}
+	}
+	rules := make([]ColumnTransformRule, 0, len(specs))
+	for _, spec := range specs {
+		eq := strings.IndexByte(spec, '=')
+		colon := -1
+		if eq >= 0 {
+			colon = strings.LastIndexByte(spec[:eq], ':')
+		}
+		if colon < 0 {
+			return nil, errors.Annotatef(berrors.ErrInvalidArgument,
+				"column transform rule %q should be in the form of pattern:column=transform", spec)
+		}
+		column := strings.TrimSpace(spec[colon+1 : eq])
+		if column == "" {
+			return nil, errors.Annotatef(berrors.ErrInvalidArgument, "empty column in rule %q", spec)
+		}
+		f, err := filter.Parse([]string{strings.TrimSpace(spec[:colon])})
+		if err != nil {
+			return nil, errors.Annotatef(berrors.ErrInvalidArgument, "invalid pattern in rule %q: %v", spec, err)
+		}
+		transform, err := cdclog.ParseTransform(spec[eq+1:], p)
+		if err != nil {
+			return nil, errors.Annotatef(err, "invalid rule %q", spec)
+		}
+		rules = append(rules, ColumnTransformRule{
+			Filter:    filter.CaseInsensitive(f),
+			Column:    column,
+			Transform: transform,
+		})
+	}
+	return rules, nil
+}
+
+// MatchColumnTransforms returns the transforms of the columns of the table by
+// the column names, the first rule matching a column takes effect.
+func MatchColumnTransforms(rules []ColumnTransformRule, schema, table string) map[string]cdclog.Transform {
+	var transforms map[string]cdclog.Transform
+	for _, rule := range rules {
+		if !rule.Filter.MatchTable(schema, table) {
+			continue
+		}
+		if transforms == nil {
+			transforms = make(map[string]cdclog.Transform)
+		}
+		column := strings.ToLower(rule.Column)
+		if _, ok := transforms[column]; !ok {
+			transforms[column] = rule.Transform
+		}
+	}
+	return transforms
+}
@@ -0,0 +1,272 @@
+// Copyright 2021 PingCAP, Inc. Licensed under Apache-2.0.
+
+package restore
+
+import (
+	"bytes"
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/pingcap/errors"
+	"github.com/pingcap/kvproto/pkg/import_sstpb"
+	"github.com/pingcap/log"
+	"github.com/pingcap/tidb/util/codec"
+	"go.uber.org/zap"
+	"golang.org/x/sync/errgroup"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/pingcap/br/pkg/logutil"
+	"github.com/pingcap/br/pkg/regionutil"
+	"github.com/pingcap/br/pkg/rtree"
+)
+
+const (
+	// dataKeyPrefix is the prefix of the keys of the data in the RocksDB of
+	// TiKV, and dataMaxKey is greater than all of them.
+	dataKeyPrefix = 'z'
+	dataMaxKey    = "{"
+
+	// compactTriggerTimeout is how long to wait for a compaction not waited,
+	// the compaction keeps running in TiKV after the request times out.
+	compactTriggerTimeout = 3 * time.Second
+)
+
+// CompactStoreProgress is the progress of the compaction of the restored
+// ranges on a store.
+type CompactStoreProgress struct {
+	StoreID uint64 `json:"store-id"`
+	// Ranges is the number of the ranges to compact on the store.
+	Ranges int `json:"ranges"`
+	// Finished is the number of the ranges whose compaction finished.
+	Finished int `json:"finished"`
+	// Triggered is the number of the ranges whose compaction is triggered
+	// and still running in TiKV, when the compaction isn't waited.
+	Triggered int `json:"triggered"`
+	// Failed is the number of the ranges which failed to compact.
+	Failed int `json:"failed"`
+	// Unsupported is whether the store doesn't support the compaction.
+	Unsupported bool `json:"unsupported,omitempty"`
+}
+
+// Compactor triggers the manual compaction of the restored ranges on the
+// stores holding them, so the read amplification after the massive ingest
+// drops before the compaction of TiKV catches up.
+type Compactor struct {
+	splitClient  SplitClient
+	importClient ImporterClient
+	extraRules   *RewriteRules
+	wait         bool
+
+	mu       sync.Mutex
+	ranges   []rtree.Range
+	progress map[uint64]*CompactStoreProgress
+}
+
+// NewCompactor returns a Compactor of the restore. If wait is false, the
+// compactions are only triggered, and the restore doesn't wait for them.
+func (rc *Client) NewCompactor(wait bool) *Compactor {
+	return &Compactor{
+		splitClient:  rc.toolClient,
+		importClient: rc.fileImporter.importClient,
+		extraRules:   rc.extraRewriteRules,
+		wait:         wait,
+		progress:     make(map[uint64]*CompactStoreProgress),
+	}
+}
+
+// GoCollectRanges passes the tables through, and collects their ranges
+// rewritten to the restored tables to compact.
+func (c *Compactor) GoCollectRanges(
+	ctx context.Context,
+	inCh <-chan TableWithRange,
+	errCh chan<- error,
+) <-chan TableWithRange {
+	outCh := make(chan TableWithRange, cap(inCh))
+	go func() {
+		defer close(outCh)
+		for {
+			select {
+			case <-ctx.Done():
+				errCh <- ctx.Err()
+				return
+			case t, ok := <-inCh:
+				if !ok {
+					return
+				}
+				ranges, err := SortRanges(t.Range, mergeRewriteRules(t.RewriteRule, c.extraRules))
+				if err != nil {
+					errCh <- errors.Trace(err)
+					return
+				}
+				c.mu.Lock()
+				c.ranges = append(c.ranges, ranges...)
+				c.mu.Unlock()
+				outCh <- t
+			}
+		}
+	}()
+	return outCh
+}
+
+// Progress returns the progress of the stores sorted by their IDs.
+func (c *Compactor) Progress() []CompactStoreProgress {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	progress := make([]CompactStoreProgress, 0, len(c.progress))
+	for _, p := range c.progress {
+		progress = append(progress, *p)
+	}
+	sort.Slice(progress, func(i, j int) bool {
+		return progress[i].StoreID < progress[j].StoreID
+	})
+	return progress
+}
+
+// Compact compacts the collected ranges on the stores holding their regions.
+// The ranges of each store are compacted one by one, so the compaction
+// doesn't occupy all threads of TiKV.
+func (c *Compactor) Compact(ctx context.Context) error {
+	start := time.Now()
+	c.mu.Lock()
+	ranges := mergeAdjacentRanges(c.ranges)
+	c.mu.Unlock()
+	storeRanges, err := c.storeRanges(ctx, ranges)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	c.mu.Lock()
+	for storeID, ranges := range storeRanges {
+		c.progress[storeID] = &CompactStoreProgress{StoreID: storeID, Ranges: len(ranges)}
+	}
+	c.mu.Unlock()
+	log.Info("start to compact the restored ranges",
+		zap.Int("ranges", len(ranges)), zap.Int("stores", len(storeRanges)), zap.Bool("wait", c.wait))
+
+	eg, ectx := errgroup.WithContext(ctx)
+	for storeID, ranges := range storeRanges {
+		storeID, ranges := storeID, ranges
+		eg.Go(func() error {
+			return c.compactStore(ectx, storeID, ranges)
+		})
+	}
+	if err = eg.Wait(); err != nil {
+		return errors.Trace(err)
+	}
+	log.Info("compact the restored ranges finished",
+		zap.Any("progress", c.Progress()), zap.Duration("take", time.Since(start)))
+	return nil
+}
+
+// storeRanges groups the ranges by the stores holding their regions.
+func (c *Compactor) storeRanges(ctx context.Context, ranges []rtree.Range) (map[uint64][]rtree.Range, error) {
+	storeRanges := make(map[uint64][]rtree.Range)
+	for _, r := range ranges {
+		startKey := codec.EncodeBytes([]byte{}, r.StartKey)
+		var endKey []byte
+		if len(r.EndKey) > 0 {
+			endKey = codec.EncodeBytes([]byte{}, r.EndKey)
+		}
+		regions, err := regionutil.PaginateScanRegion(ctx, c.splitClient, startKey, endKey, ScanRegionPaginationLimit)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		stores := make(map[uint64]struct{})
+		for _, region := range regions {
+			for _, peer := range region.Region.GetPeers() {
+				stores[peer.GetStoreId()] = struct{}{}
+			}
+		}
+		for storeID := range stores {
+			storeRanges[storeID] = append(storeRanges[storeID], r)
+		}
+	}
+	return storeRanges, nil
+}
+
+func (c *Compactor) compactStore(ctx context.Context, storeID uint64, ranges []rtree.Range) error {
+	client, err := c.importClient.GetImportClient(ctx, storeID)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	for _, r := range ranges {
+		req := &import_sstpb.CompactRequest{
+			Range: &import_sstpb.Range{Start: dataKey(r.StartKey), End: dataEndKey(r.EndKey)},
+			// compact to the bottommost level.
+			OutputLevel: -1,
+		}
+		err = c.sendCompact(ctx, client, req)
+		code := status.Code(errors.Cause(err))
+		switch {
+		case err == nil:
+			c.update(storeID, func(p *CompactStoreProgress) { p.Finished++ })
+		case ctx.Err() != nil:
+			return errors.Trace(ctx.Err())
+		case code == codes.Unimplemented:
+			log.Warn("the store doesn't support compaction, skip it", zap.Uint64("store", storeID))
+			c.update(storeID, func(p *CompactStoreProgress) { p.Unsupported = true })
+			return nil
+		case code == codes.DeadlineExceeded && !c.wait:
+			c.update(storeID, func(p *CompactStoreProgress) { p.Triggered++ })
+		default:
+			// the compaction is an optimization, so the restore doesn't fail.
+			log.Warn("failed to compact the restored range", zap.Uint64("store", storeID),
+				logutil.Key("start", r.StartKey), logutil.Key("end", r.EndKey), zap.Error(err))
+			c.update(storeID, func(p *CompactStoreProgress) { p.Failed++ })
+		}
+	}
+	return nil
+}
+
+// sendCompact sends the compact request, which only waits for the compaction
+// for a while if the compaction isn't waited.
+func (c *Compactor) sendCompact(ctx context.Context, client import_sstpb.ImportSSTClient, req *import_sstpb.CompactRequest) error {
+	if !c.wait {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, compactTriggerTimeout)
+		defer cancel()
+	}
+	_, err := client.Compact(ctx, req)
+	return errors.Trace(err)
+}
+
+func (c *Compactor) update(storeID uint64, fn func(p *CompactStoreProgress)) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	fn(c.progress[storeID])
+}
+
+// mergeAdjacentRanges sorts the ranges and merges the adjacent ones, so the
+// ranges of a table are usually compacted by one request.
+func mergeAdjacentRanges(ranges []rtree.Range) []rtree.Range {
+	sorted := append([]rtree.Range{}, ranges...)
+	sort.Slice(sorted, func(i, j int) bool {
+		return bytes.Compare(sorted[i].StartKey, sorted[j].StartKey) < 0
+	})
+	merged := make([]rtree.Range, 0, len(sorted))
+	for _, r := range sorted {
+		if n := len(merged); n > 0 && len(merged[n-1].EndKey) > 0 &&
+			bytes.Compare(merged[n-1].EndKey, r.StartKey) >= 0 {
+			if len(r.EndKey) == 0 || bytes.Compare(r.EndKey, merged[n-1].EndKey) > 0 {
+				merged[n-1].EndKey = r.EndKey
+			}
+			continue
+		}
+		merged = append(merged, rtree.Range{StartKey: r.StartKey, EndKey: r.EndKey})
+	}
+	return merged
+}
+
+// dataKey returns the key of the RocksDB of TiKV of the raw key.
+func dataKey(key []byte) []byte {
+	return append([]byte{dataKeyPrefix}, codec.EncodeBytes([]byte{}, key)...)
+}
+
+func dataEndKey(key []byte) []byte {
+	if len(key) == 0 {
+		return []byte(dataMaxKey)
+	}
+	return dataKey(key)
+}
@@ -67,18 +67,12 @@ func (db *DB) ExecDDL(ctx context.Context, ddlJob *model.Job) error {
 		return errors.Trace(err)
 	}
 
+	stmts := []string{ddlJob.Query}
 	if tableInfo != nil {
 		switchDBSQL := fmt.Sprintf("use %s;", utils.EncloseName(ddlJob.SchemaName))
-		err = db.se.Execute(ctx, switchDBSQL)
-		if err != nil {
-			log.Error("switch db failed",
-				zap.String("query", switchDBSQL),
-				zap.String("db", ddlJob.SchemaName),
-				zap.Error(err))
-			return errors.Trace(err)
-		}
+		stmts = []string{switchDBSQL, ddlJob.Query}
 	}
-	err = db.se.Execute(ctx, ddlJob.Query)
+	err = db.se.ExecuteBatch(ctx, stmts)
 	if err != nil {
 		log.Error("execute ddl query failed",
 			zap.String("query", ddlJob.Query),
@@ -109,7 +103,7 @@ func (db *DB) CreateTable(ctx context.Context, table *metautil.Table) error {
 		return errors.Trace(err)
 	}
 
-	var restoreMetaSQL string
+	var restoreMetaSQLs []string
 	if table.Info.IsSequence() {
 		setValFormat := fmt.Sprintf("do setval(%s.%s, %%d);",
 			utils.EncloseName(table.DB.Name.O),
@@ -130,29 +124,10 @@ func (db *DB) CreateTable(ctx context.Context, table *metautil.Table) error {
 			} else {
 				setValSQL = fmt.Sprintf(setValFormat, table.Info.Sequence.MaxValue)
 			}
-			err = db.se.Execute(ctx, setValSQL)
-			if err != nil {
-				log.Error("restore meta sql failed",
-					zap.String("query", setValSQL),
-					zap.Stringer("db", table.DB.Name),
-					zap.Stringer("table", table.Info.Name),
-					zap.Error(err))
-				return errors.Trace(err)
-			}
-
 			// trigger cycle round > 0
-			err = db.se.Execute(ctx, nextSeqSQL)
-			if err != nil {
-				log.Error("restore meta sql failed",
-					zap.String("query", nextSeqSQL),
-					zap.Stringer("db", table.DB.Name),
-					zap.Stringer("table", table.Info.Name),
-					zap.Error(err))
-				return errors.Trace(err)
-			}
+			restoreMetaSQLs = append(restoreMetaSQLs, setValSQL, nextSeqSQL)
 		}
-		restoreMetaSQL = fmt.Sprintf(setValFormat, table.Info.AutoIncID)
-		err = db.se.Execute(ctx, restoreMetaSQL)
+		restoreMetaSQLs = append(restoreMetaSQLs, fmt.Sprintf(setValFormat, table.Info.AutoIncID))
 	} else {
 		var alterAutoIncIDFormat string
 		switch {
@@ -161,24 +136,25 @@ func (db *DB) CreateTable(ctx context.Context, table *metautil.Table) error {
 		default:
 			alterAutoIncIDFormat = "alter table %s.%s auto_increment = %d;"
 		}
-		restoreMetaSQL = fmt.Sprintf(
-			alterAutoIncIDFormat,
-			utils.EncloseName(table.DB.Name.O),
-			utils.EncloseName(table.Info.Name.O),
-			table.Info.AutoIncID)
 		if utils.NeedAutoID(table.Info) {
-			err = db.se.Execute(ctx, restoreMetaSQL)
+			restoreMetaSQLs = append(restoreMetaSQLs, fmt.Sprintf(
+				alterAutoIncIDFormat,
+				utils.EncloseName(table.DB.Name.O),
+				utils.EncloseName(table.Info.Name.O),
+				table.Info.AutoIncID))
 		}
 	}
 
+	err = db.se.ExecuteBatch(ctx, restoreMetaSQLs)
 	if err != nil {
 		log.Error("restore meta sql failed",
-			zap.String("query", restoreMetaSQL),
+			zap.Strings("queries", restoreMetaSQLs),
 			zap.Stringer("db", table.DB.Name),
 			zap.Stringer("table", table.Info.Name),
 			zap.Error(err))
 		return errors.Trace(err)
 	}
+
 	if table.Info.PKIsHandle && table.Info.ContainsAutoRandomBits() {
 		// this table has auto random id, we need rebase it
 
@@ -190,6 +166,8 @@ func (db *DB) CreateTable(ctx context.Context, table *metautil.Table) error {
 			utils.EncloseName(table.Info.Name.O),
 			table.Info.AutoRandID)
 
+		// the rebase is best-effort, so it isn't batched with the statements
+		// above, whose failure fails the restore.
 		err = db.se.Execute(ctx, alterAutoRandIDSQL)
 		if err != nil {
 			log.Error("alter AutoRandID failed",
@@ -200,7 +178,7 @@ func (db *DB) CreateTable(ctx context.Context, table *metautil.Table) error {
 		}
 	}
 
-	return errors.Trace(err)
+	return nil
 }
 
 // Close closes the connection.
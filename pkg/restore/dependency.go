@@ -0,0 +1,139 @@
+// Copyright 2021 PingCAP, Inc. Licensed under Apache-2.0.
+
+package restore
+
+import (
+	"github.com/pingcap/errors"
+	"github.com/pingcap/log"
+	"github.com/pingcap/parser"
+	"github.com/pingcap/parser/ast"
+	"go.uber.org/zap"
+
+	"github.com/pingcap/br/pkg/metautil"
+)
+
+// tableRefCollector collects the tables referenced by a statement.
+type tableRefCollector struct {
+	defaultDB string
+	refs      []string
+}
+
+func (c *tableRefCollector) Enter(n ast.Node) (ast.Node, bool) {
+	if name, ok := n.(*ast.TableName); ok {
+		db := name.Schema.L
+		if db == "" {
+			db = c.defaultDB
+		}
+		c.refs = append(c.refs, tableKey(db, name.Name.L))
+	}
+	return n, false
+}
+
+func (c *tableRefCollector) Leave(n ast.Node) (ast.Node, bool) {
+	return n, true
+}
+
+// viewRefs returns the tables referenced by the select statement of the view.
+func viewRefs(p *parser.Parser, table *metautil.Table) ([]string, error) {
+	stmt, err := p.ParseOneStmt(table.Info.View.SelectStmt, "", "")
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	collector := &tableRefCollector{defaultDB: table.DB.Name.L}
+	stmt.Accept(collector)
+	return collector.refs, nil
+}
+
+// SortTablesByDependency groups the tables into the stages created one by
+// one, so every table is created after the tables it depends on. The
+// sequences are created first since the tables may use them as the default
+// values, then the base tables, then the views, where a view is created in a
+// stage after all the views it selects from. The tables of the same stage can
+// be created concurrently.
+func SortTablesByDependency(tables []*metautil.Table) [][]*metautil.Table {
+	var sequences, bases []*metautil.Table
+	views := make(map[string]*metautil.Table)
+	var viewKeys []string
+	for _, table := range tables {
+		switch {
+		case table.Info.IsSequence():
+			sequences = append(sequences, table)
+		case table.Info.IsView():
+			key := tableKey(table.DB.Name.L, table.Info.Name.L)
+			views[key] = table
+			viewKeys = append(viewKeys, key)
+		default:
+			bases = append(bases, table)
+		}
+	}
+	var stages [][]*metautil.Table
+	for _, stage := range [][]*metautil.Table{sequences, bases} {
+		if len(stage) > 0 {
+			stages = append(stages, stage)
+		}
+	}
+	if len(views) == 0 {
+		return stages
+	}
+
+	// the views each view selects from, the views failed to parse are created
+	// in the last stage.
+	p := parser.New()
+	deps := make(map[string][]string, len(views))
+	var unknown []*metautil.Table
+	for _, key := range viewKeys {
+		refs, err := viewRefs(p, views[key])
+		if err != nil {
+			log.Warn("failed to parse the view, create it after the other views",
+				zap.String("view", key), zap.Error(err))
+			unknown = append(unknown, views[key])
+			delete(views, key)
+			continue
+		}
+		for _, ref := range refs {
+			if _, ok := views[ref]; ok && ref != key {
+				deps[key] = append(deps[key], ref)
+			}
+		}
+	}
+
+	created := make(map[string]bool, len(views))
+	remaining := viewKeys
+	for len(remaining) > 0 {
+		var stage []*metautil.Table
+		var next []string
+		for _, key := range remaining {
+			if _, ok := views[key]; !ok {
+				continue
+			}
+			ready := true
+			for _, dep := range deps[key] {
+				if _, ok := views[dep]; ok && !created[dep] {
+					ready = false
+					break
+				}
+			}
+			if ready {
+				stage = append(stage, views[key])
+			} else {
+				next = append(next, key)
+			}
+		}
+		if len(stage) == 0 {
+			// the views depend on each other, which can't be created anyway.
+			for _, key := range next {
+				unknown = append(unknown, views[key])
+			}
+			break
+		}
+		for _, view := range stage {
+			created[tableKey(view.DB.Name.L, view.Info.Name.L)] = true
+		}
+		stages = append(stages, stage)
+		remaining = next
+	}
+	if len(unknown) > 0 {
+		stages = append(stages, unknown)
+	}
+	return stages
+}
@@ -0,0 +1,59 @@
+// Copyright 2021 PingCAP, Inc. Licensed under Apache-2.0.
+
+package restore_test
+
+import (
+	. "github.com/pingcap/check"
+	"github.com/pingcap/parser/model"
+
+	"github.com/pingcap/br/pkg/metautil"
+	"github.com/pingcap/br/pkg/restore"
+)
+
+var _ = Suite(&testDependencySuite{})
+
+type testDependencySuite struct{}
+
+func (s *testDependencySuite) TestSortTablesByDependency(c *C) {
+	db := &model.DBInfo{ID: 1, Name: model.NewCIStr("test")}
+	other := &model.DBInfo{ID: 2, Name: model.NewCIStr("other")}
+	table := func(db *model.DBInfo, name string) *metautil.Table {
+		return &metautil.Table{DB: db, Info: &model.TableInfo{Name: model.NewCIStr(name)}}
+	}
+	view := func(db *model.DBInfo, name, sql string) *metautil.Table {
+		t := table(db, name)
+		t.Info.View = &model.ViewInfo{SelectStmt: sql}
+		return t
+	}
+	seq := table(db, "seq")
+	seq.Info.Sequence = &model.SequenceInfo{Start: 1, Increment: 1}
+
+	tables := []*metautil.Table{
+		view(db, "v3", "SELECT * FROM `test`.`v2` JOIN `other`.`v1`"),
+		table(db, "t1"),
+		view(db, "v2", "SELECT * FROM `v1` UNION SELECT * FROM `t1`"),
+		view(db, "bad", "SELECT FROM"),
+		seq,
+		view(db, "v1", "SELECT * FROM `t1`"),
+		view(other, "v1", "SELECT * FROM `test`.`t1`"),
+	}
+	stages := restore.SortTablesByDependency(tables)
+	names := make([][]string, 0, len(stages))
+	for _, stage := range stages {
+		stageNames := make([]string, 0, len(stage))
+		for _, t := range stage {
+			stageNames = append(stageNames, t.DB.Name.O+"."+t.Info.Name.O)
+		}
+		names = append(names, stageNames)
+	}
+	c.Assert(names, DeepEquals, [][]string{
+		{"test.seq"},
+		{"test.t1"},
+		{"test.v1", "other.v1"},
+		{"test.v2"},
+		{"test.v3"},
+		{"test.bad"},
+	})
+
+	c.Assert(restore.SortTablesByDependency(nil), HasLen, 0)
+}
@@ -0,0 +1,198 @@
+// Copyright 2021 PingCAP, Inc. Licensed under Apache-2.0.
+
+package restore
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/pingcap/errors"
+	backuppb "github.com/pingcap/kvproto/pkg/backup"
+	"github.com/pingcap/log"
+	"go.uber.org/zap"
+	"golang.org/x/sync/errgroup"
+
+	berrors "github.com/pingcap/br/pkg/errors"
+	"github.com/pingcap/br/pkg/rtree"
+	"github.com/pingcap/br/pkg/summary"
+)
+
+// Granularity is the unit scheduled to the workers of the file restore.
+type Granularity string
+
+const (
+	// GranularityTable restores the batches of the tables one by one, only the
+	// files of the same batch are restored concurrently.
+	GranularityTable Granularity = "table"
+	// GranularityRegion restores the files of all in-flight batches in the
+	// global worker pool, so the workers aren't idle while the last files of a
+	// batch of a few large tables are restored.
+	GranularityRegion Granularity = "region"
+)
+
+// Validate checks whether the granularity is supported.
+func (g Granularity) Validate() error {
+	switch g {
+	case GranularityTable, GranularityRegion:
+		return nil
+	default:
+		return errors.Annotatef(berrors.ErrInvalidArgument,
+			"invalid granularity %q, should be one of 'table|region'", g)
+	}
+}
+
+// regionBatch is a batch restored by the region granularity.
+type regionBatch struct {
+	result DrainResult
+	files  int
+	start  time.Time
+	// pending is the file ranges of the batch not finished yet.
+	pending sync.WaitGroup
+	// incomplete is set if any file range of the batch failed or was skipped.
+	incomplete int32
+}
+
+// regionDispatcher records the first failure of the file ranges dispatched by
+// the region granularity, which stops the dispatch.
+type regionDispatcher struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	mu  sync.Mutex
+	err error
+}
+
+func (d *regionDispatcher) fail(err error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.err == nil {
+		d.err = err
+		summary.FromContext(d.ctx).CollectFailureUnit("file", err)
+		log.Error("restore files failed", zap.Error(err))
+	}
+	d.cancel()
+}
+
+func (d *regionDispatcher) failure() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.err == nil {
+		return d.ctx.Err()
+	}
+	return d.err
+}
+
+// restoreRegionWorker dispatches the file ranges of all batches, each range
+// being the files of a region backed up, to the global worker pool of the
+// client, so the workers aren't idle while the last files of a batch of a few
+// large tables are restored. A file range is dispatched only when a worker is
+// free, so the batches in flight are bounded by the worker pool. Once a file
+// range fails, no more file ranges are dispatched.
+//
+// The tables are still emitted in the order of the batches, since a table
+// split into many batches is restored only after all of them are.
+func (b *tikvSender) restoreRegionWorker(ctx context.Context, ranges <-chan DrainResult) {
+	// set the speed limit before restoring the batches concurrently.
+	if err := b.client.setSpeedLimit(ctx); err != nil {
+		b.sink.EmitError(err)
+		b.wg.Done()
+		b.sink.Close()
+		return
+	}
+	dctx, cancel := context.WithCancel(ctx)
+	dispatcher := &regionDispatcher{ctx: dctx, cancel: cancel}
+	eg := new(errgroup.Group)
+	pending := make(chan *regionBatch, defaultChannelSize)
+	emitted := make(chan struct{})
+	go func() {
+		defer close(emitted)
+		failed := false
+		for batch := range pending {
+			batch.pending.Wait()
+			if failed {
+				continue
+			}
+			if atomic.LoadInt32(&batch.incomplete) != 0 {
+				failed = true
+				b.sink.EmitError(dispatcher.failure())
+				continue
+			}
+			log.Info("restore batch done", rtree.ZapRanges(batch.result.Ranges))
+			summary.FromContext(ctx).CollectSuccessUnit("files", batch.files, time.Since(batch.start))
+			b.sink.EmitTables(batch.result.BlankTablesAfterSend...)
+		}
+	}()
+	defer func() {
+		close(pending)
+		<-emitted
+		_ = eg.Wait()
+		cancel()
+		log.Debug("restore worker closed")
+		b.wg.Done()
+		b.sink.Close()
+	}()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case result, ok := <-ranges:
+			if !ok {
+				return
+			}
+			// the batches after the failure are drained without being restored,
+			// so the split worker isn't blocked.
+			if dctx.Err() != nil {
+				continue
+			}
+			b.dispatchRegionBatch(dispatcher, eg, pending, result)
+		}
+	}
+}
+
+// dispatchRegionBatch sends the batch to the emitter, and dispatches its file
+// ranges to the worker pool.
+func (b *tikvSender) dispatchRegionBatch(
+	dispatcher *regionDispatcher, eg *errgroup.Group, pending chan<- *regionBatch, result DrainResult,
+) {
+	files := result.Files()
+	batch := &regionBatch{result: result, files: len(files), start: time.Now()}
+	rewriteRules, err := b.client.withExtraRewriteRules(result.RewriteRules)
+	var fileRanges [][]*backuppb.File
+	if err != nil {
+		atomic.StoreInt32(&batch.incomplete, 1)
+		dispatcher.fail(errors.Trace(err))
+	} else {
+		fileRanges = b.client.fileRanges(files)
+	}
+	batch.pending.Add(len(fileRanges))
+	// the emitter only waits for the file ranges, which never block, so the
+	// batch is always sent.
+	pending <- batch
+
+	for _, rangeFiles := range fileRanges {
+		rangeFiles := rangeFiles
+		if dispatcher.ctx.Err() != nil {
+			// another file range failed, the rest isn't dispatched.
+			atomic.StoreInt32(&batch.incomplete, 1)
+			batch.pending.Done()
+			continue
+		}
+		b.client.workerPool.ApplyOnErrorGroup(eg, func() error {
+			defer batch.pending.Done()
+			// the failure may happen while waiting for the worker.
+			if dispatcher.ctx.Err() != nil {
+				atomic.StoreInt32(&batch.incomplete, 1)
+				return nil
+			}
+			err := b.client.importFiles(dispatcher.ctx, rangeFiles, rewriteRules, b.updateCh)
+			if err != nil {
+				atomic.StoreInt32(&batch.incomplete, 1)
+				dispatcher.fail(errors.Trace(err))
+			}
+			return nil
+		})
+	}
+}
@@ -0,0 +1,148 @@
+// Copyright 2021 PingCAP, Inc. Licensed under Apache-2.0.
+
+package restore_test
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+
+	. "github.com/pingcap/check"
+	backuppb "github.com/pingcap/kvproto/pkg/backup"
+	"github.com/pingcap/kvproto/pkg/import_sstpb"
+	"github.com/pingcap/kvproto/pkg/metapb"
+	"github.com/pingcap/parser/model"
+	"github.com/pingcap/tidb/kv"
+	"github.com/pingcap/tidb/tablecodec"
+
+	"github.com/pingcap/br/pkg/gluetikv"
+	"github.com/pingcap/br/pkg/metautil"
+	"github.com/pingcap/br/pkg/restore"
+	"github.com/pingcap/br/pkg/rtree"
+	"github.com/pingcap/br/pkg/storage"
+)
+
+var _ = Suite(&testGranularitySuite{})
+
+type testGranularitySuite struct{}
+
+type atomicProgress struct {
+	count int64
+}
+
+func (p *atomicProgress) Inc() {
+	atomic.AddInt64(&p.count, 1)
+}
+
+func (p *atomicProgress) Close() {}
+
+// recordSink records the tables and the errors emitted by the sender.
+type recordSink struct {
+	mu     sync.Mutex
+	tables []string
+	errs   []error
+	closed chan struct{}
+}
+
+func (s *recordSink) EmitTables(tables ...restore.CreatedTable) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, table := range tables {
+		s.tables = append(s.tables, table.Table.Name.O)
+	}
+}
+
+func (s *recordSink) EmitError(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.errs = append(s.errs, err)
+}
+
+func (s *recordSink) Close() {
+	close(s.closed)
+}
+
+func newRegionGranularitySender(c *C, concurrency uint) (restore.BatchSender, *recordSink) {
+	ctx := context.Background()
+	stores := []*metapb.Store{{Id: 1}, {Id: 2}, {Id: 3}}
+	client, err := restore.NewRestoreClient(gluetikv.Glue{}, fakePDClient{stores: stores}, nil, nil, defaultKeepaliveCfg)
+	c.Assert(err, IsNil)
+	client.UseMockCluster(stores)
+	client.SetConcurrency(concurrency)
+	client.SetGranularity(restore.GranularityRegion)
+	s, err := storage.NewLocalStorage(c.MkDir())
+	c.Assert(err, IsNil)
+	meta := &backuppb.BackupMeta{}
+	c.Assert(client.InitBackupMeta(ctx, meta, &backuppb.StorageBackend{}, metautil.NewMetaReader(meta, s)), IsNil)
+
+	sender, err := restore.NewTiKVSender(ctx, client, &atomicProgress{})
+	c.Assert(err, IsNil)
+	sink := &recordSink{closed: make(chan struct{})}
+	sender.PutSink(sink)
+	return sender, sink
+}
+
+// regionBatch builds the batch of the table, whose files are in the table
+// fileTable, so the files not in the table fail to be rewritten.
+func regionBatch(tableID, fileTableID int64, ranges int) restore.DrainResult {
+	files := make([]*backuppb.File, 0, ranges*2)
+	for i := 0; i < ranges; i++ {
+		for _, cf := range []string{"default", "write"} {
+			files = append(files, &backuppb.File{
+				Name:     fmt.Sprintf("%d_%d_%s.sst", tableID, i, cf),
+				StartKey: tablecodec.EncodeRowKeyWithHandle(fileTableID, kv.IntHandle(i*10)),
+				EndKey:   tablecodec.EncodeRowKeyWithHandle(fileTableID, kv.IntHandle(i*10+10)),
+			})
+		}
+	}
+	rules := &restore.RewriteRules{Data: []*import_sstpb.RewriteRule{{
+		OldKeyPrefix: tablecodec.EncodeTablePrefix(tableID),
+		NewKeyPrefix: tablecodec.EncodeTablePrefix(tableID + 100),
+	}}}
+	table := restore.CreatedTable{
+		RewriteRule: rules,
+		Table:       &model.TableInfo{ID: tableID + 100, Name: model.NewCIStr(fmt.Sprintf("t%d", tableID))},
+	}
+	return restore.DrainResult{
+		BlankTablesAfterSend: []restore.CreatedTable{table},
+		RewriteRules:         rules,
+		Ranges: []rtree.Range{{
+			StartKey: tablecodec.EncodeTablePrefix(tableID),
+			EndKey:   tablecodec.EncodeTablePrefix(tableID + 1),
+			Files:    files,
+		}},
+	}
+}
+
+func (s *testGranularitySuite) TestRegionGranularity(c *C) {
+	c.Assert(restore.Granularity("file").Validate(), ErrorMatches, ".*invalid granularity.*")
+
+	sender, sink := newRegionGranularitySender(c, 4)
+	for id := int64(1); id <= 5; id++ {
+		sender.RestoreBatch(regionBatch(id, id, 3))
+	}
+	sender.Close()
+	<-sink.closed
+	c.Assert(sink.errs, HasLen, 0)
+	// the file ranges of the batches are restored concurrently, but the tables
+	// are emitted in the order of the batches.
+	c.Assert(sink.tables, DeepEquals, []string{"t1", "t2", "t3", "t4", "t5"})
+}
+
+func (s *testGranularitySuite) TestRegionGranularityFailure(c *C) {
+	sender, sink := newRegionGranularitySender(c, 1)
+	sender.RestoreBatch(regionBatch(1, 1, 2))
+	// the files of the second batch cannot be rewritten.
+	sender.RestoreBatch(regionBatch(2, 52, 2))
+	// the batches after the failure are drained, the sender is never blocked.
+	for id := int64(3); id <= 10; id++ {
+		sender.RestoreBatch(regionBatch(id, id, 2))
+	}
+	sender.Close()
+	<-sink.closed
+	c.Assert(sink.errs, HasLen, 1)
+	c.Assert(sink.errs[0], ErrorMatches, ".*cannot find rewrite rule.*")
+	// neither the failed batch nor the batches after it are emitted.
+	c.Assert(sink.tables, DeepEquals, []string{"t1"})
+}
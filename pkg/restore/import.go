@@ -30,6 +30,7 @@ import (
 	"github.com/pingcap/br/pkg/conn"
 	berrors "github.com/pingcap/br/pkg/errors"
 	"github.com/pingcap/br/pkg/logutil"
+	"github.com/pingcap/br/pkg/regionutil"
 	"github.com/pingcap/br/pkg/summary"
 	"github.com/pingcap/br/pkg/utils"
 )
@@ -79,15 +80,22 @@ type importClient struct {
 	tlsConf    *tls.Config
 
 	keepaliveConf keepalive.ClientParameters
+	timeout       utils.TimeoutConfig
 }
 
 // NewImportClient returns a new ImporterClient.
-func NewImportClient(metaClient SplitClient, tlsConf *tls.Config, keepaliveConf keepalive.ClientParameters) ImporterClient {
+func NewImportClient(
+	metaClient SplitClient,
+	tlsConf *tls.Config,
+	keepaliveConf keepalive.ClientParameters,
+	timeout utils.TimeoutConfig,
+) ImporterClient {
 	return &importClient{
 		metaClient:    metaClient,
 		clients:       make(map[uint64]import_sstpb.ImportSSTClient),
 		tlsConf:       tlsConf,
 		keepaliveConf: keepaliveConf,
+		timeout:       timeout,
 	}
 }
 
@@ -124,6 +132,8 @@ func (ic *importClient) IngestSST(
 	if err != nil {
 		return nil, errors.Trace(err)
 	}
+	ctx, cancel := utils.ContextWithTimeout(ctx, ic.timeout.Ingest)
+	defer cancel()
 	return client.Ingest(ctx, req)
 }
 
@@ -136,6 +146,8 @@ func (ic *importClient) MultiIngest(
 	if err != nil {
 		return nil, errors.Trace(err)
 	}
+	ctx, cancel := utils.ContextWithTimeout(ctx, ic.timeout.Ingest)
+	defer cancel()
 	return client.MultiIngest(ctx, req)
 }
 
@@ -144,11 +156,13 @@ func (ic *importClient) GetImportClient(
 	storeID uint64,
 ) (import_sstpb.ImportSSTClient, error) {
 	ic.mu.Lock()
-	defer ic.mu.Unlock()
 	client, ok := ic.clients[storeID]
+	ic.mu.Unlock()
 	if ok {
 		return client, nil
 	}
+	// dial without the lock, so the connections to different stores can be
+	// established concurrently.
 	store, err := ic.metaClient.GetStore(ctx, storeID)
 	if err != nil {
 		return nil, errors.Trace(err)
@@ -163,19 +177,29 @@ func (ic *importClient) GetImportClient(
 	}
 	bfConf := backoff.DefaultConfig
 	bfConf.MaxDelay = gRPCBackOffMaxDelay
+	dialCtx, cancel := utils.ContextWithTimeout(ctx, ic.timeout.Dial)
 	conn, err := grpc.DialContext(
-		ctx,
+		dialCtx,
 		addr,
 		opt,
+		grpc.WithBlock(),
 		grpc.WithConnectParams(grpc.ConnectParams{Backoff: bfConf}),
 		grpc.WithKeepaliveParams(ic.keepaliveConf),
 	)
+	cancel()
 	if err != nil {
 		return nil, errors.Trace(err)
 	}
+	ic.mu.Lock()
+	defer ic.mu.Unlock()
+	if client, ok = ic.clients[storeID]; ok {
+		// another request has connected to the store.
+		_ = conn.Close()
+		return client, nil
+	}
 	client = import_sstpb.NewImportSSTClient(conn)
 	ic.clients[storeID] = client
-	return client, errors.Trace(err)
+	return client, nil
 }
 
 func (ic *importClient) SupportMultiIngest(ctx context.Context, stores []uint64) (bool, error) {
@@ -201,9 +225,36 @@ type FileImporter struct {
 	rateLimit    uint64
 
 	isRawKvMode        bool
+	isTxnKvMode        bool
 	rawStartKey        []byte
 	rawEndKey          []byte
 	supportMultiIngest bool
+
+	watchdog *Watchdog
+	// storeLimiter limits the in-flight requests of each store, nil means no
+	// limit.
+	storeLimiter *storeLimiter
+	// writeLimiter caps the bytes written to the cluster per second, nil
+	// means no limit.
+	writeLimiter *clusterWriteLimiter
+	// fileSources are the data files held by the other backups by their names
+	// in the backupmeta.
+	fileSources map[string]FileSource
+}
+
+// FileSource is where the content of a data file deduplicated against another
+// backup is.
+type FileSource struct {
+	Backend *backuppb.StorageBackend
+	Name    string
+}
+
+// source returns the backend and the name to download the file from.
+func (importer *FileImporter) source(file *backuppb.File) (*backuppb.StorageBackend, string) {
+	if src, ok := importer.fileSources[file.GetName()]; ok {
+		return src.Backend, src.Name
+	}
+	return importer.backend, file.GetName()
 }
 
 // NewFileImporter returns a new file importClient.
@@ -266,6 +317,17 @@ func (importer *FileImporter) Import(
 	if importer.isRawKvMode {
 		startKey = files[0].StartKey
 		endKey = files[0].EndKey
+		rule, err := rawFileRule(files[0], rewriteRules)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		if rule != nil {
+			startKey = rewriteRawRangeKey(startKey, rule)
+			endKey = rewriteRawRangeKey(endKey, rule)
+		}
+	} else if importer.isTxnKvMode {
+		startKey = encodeTxnKey(files[0].StartKey)
+		endKey = encodeTxnKey(files[0].EndKey)
 	} else {
 		for _, f := range files {
 			start, end, err := rewriteFileKeys(f, rewriteRules)
@@ -286,11 +348,17 @@ func (importer *FileImporter) Import(
 		logutil.Key("startKey", startKey),
 		logutil.Key("endKey", endKey))
 
+	attempted := false
 	err := utils.WithRetry(ctx, func() error {
+		if attempted {
+			// the cached regions may be the stale ones failing the last attempt.
+			invalidateRegionCache(importer.metaClient, startKey, endKey)
+		}
+		attempted = true
 		tctx, cancel := context.WithTimeout(ctx, importScanRegionTime)
 		defer cancel()
 		// Scan regions covered by the file range
-		regionInfos, errScanRegion := PaginateScanRegion(
+		regionInfos, errScanRegion := regionutil.PaginateScanRegion(
 			tctx, importer.metaClient, startKey, endKey, ScanRegionPaginationLimit)
 		if errScanRegion != nil {
 			return errors.Trace(errScanRegion)
@@ -308,10 +376,13 @@ func (importer *FileImporter) Import(
 				var e error
 				for i, f := range remainFiles {
 					var downloadMeta *import_sstpb.SSTMeta
+					size := regionWriteSize(f, len(regionInfos))
 					if importer.isRawKvMode {
-						downloadMeta, e = importer.downloadRawKVSST(ctx, info, f)
+						downloadMeta, e = importer.downloadRawKVSST(ctx, info, f, rewriteRules, size)
+					} else if importer.isTxnKvMode {
+						downloadMeta, e = importer.downloadTxnKVSST(ctx, info, f, size)
 					} else {
-						downloadMeta, e = importer.downloadSST(ctx, info, f, rewriteRules)
+						downloadMeta, e = importer.downloadSST(ctx, info, f, rewriteRules, size)
 					}
 					failpoint.Inject("restore-storage-error", func(val failpoint.Value) {
 						msg := val.(string)
@@ -416,8 +487,8 @@ func (importer *FileImporter) Import(
 			}
 		}
 		for _, f := range files {
-			summary.CollectSuccessUnit(summary.TotalKV, 1, f.TotalKvs)
-			summary.CollectSuccessUnit(summary.TotalBytes, 1, f.TotalBytes)
+			summary.FromContext(ctx).CollectSuccessUnit(summary.TotalKV, 1, f.TotalKvs)
+			summary.FromContext(ctx).CollectSuccessUnit(summary.TotalBytes, 1, f.TotalBytes)
 		}
 
 		return nil
@@ -438,6 +509,7 @@ func (importer *FileImporter) downloadSST(
 	regionInfo *RegionInfo,
 	file *backuppb.File,
 	rewriteRules *RewriteRules,
+	size uint64,
 ) (*import_sstpb.SSTMeta, error) {
 	uid := uuid.New()
 	id := uid[:]
@@ -456,10 +528,11 @@ func (importer *FileImporter) downloadSST(
 	}
 	sstMeta := GetSSTMetaFromFile(id, file, regionInfo.Region, &rule)
 
+	backend, name := importer.source(file)
 	req := &import_sstpb.DownloadRequest{
 		Sst:            sstMeta,
-		StorageBackend: importer.backend,
-		Name:           file.GetName(),
+		StorageBackend: backend,
+		Name:           name,
 		RewriteRule:    rule,
 	}
 	log.Debug("download SST",
@@ -469,7 +542,7 @@ func (importer *FileImporter) downloadSST(
 	)
 	var resp *import_sstpb.DownloadResponse
 	for _, peer := range regionInfo.Region.GetPeers() {
-		resp, err = importer.importClient.DownloadSST(ctx, peer.GetStoreId(), req)
+		resp, err = importer.download(ctx, regionInfo, peer.GetStoreId(), req, size)
 		if err != nil {
 			return nil, errors.Trace(err)
 		}
@@ -485,42 +558,133 @@ func (importer *FileImporter) downloadSST(
 	return &sstMeta, nil
 }
 
+// download sends the download request writing about size bytes to the store.
+func (importer *FileImporter) download(
+	ctx context.Context,
+	regionInfo *RegionInfo,
+	storeID uint64,
+	req *import_sstpb.DownloadRequest,
+	size uint64,
+) (*import_sstpb.DownloadResponse, error) {
+	// the bytes are charged by the cluster limit before they're written. It's
+	// charged before acquiring the slot of the store, so the other requests
+	// of the store aren't blocked by the slot while waiting.
+	if err := importer.writeLimiter.wait(ctx, size); err != nil {
+		return nil, errors.Trace(err)
+	}
+	release, err := importer.storeLimiter.acquire(ctx, storeID)
+	if err != nil {
+		importer.writeLimiter.refund(size)
+		return nil, errors.Trace(err)
+	}
+	reqCtx, finish := importer.watchdog.track(ctx, watchdogOpDownload, regionInfo, storeID)
+	resp, err := importer.importClient.DownloadSST(reqCtx, storeID, req)
+	err = finish(err)
+	release()
+	if err != nil || resp.GetError() != nil || resp.GetIsEmpty() {
+		// nothing is written by the failed and empty downloads, so the bytes
+		// of a range aren't charged again by its retries.
+		importer.writeLimiter.refund(size)
+		return resp, errors.Trace(err)
+	}
+	return resp, nil
+}
+
 func (importer *FileImporter) downloadRawKVSST(
 	ctx context.Context,
 	regionInfo *RegionInfo,
 	file *backuppb.File,
+	rewriteRules *RewriteRules,
+	size uint64,
 ) (*import_sstpb.SSTMeta, error) {
 	uid := uuid.New()
 	id := uid[:]
-	// Empty rule
+	// Empty rule unless the file is rewritten by a user provided rule, then
+	// the restoring range is rewritten as well.
 	var rule import_sstpb.RewriteRule
+	rawStartKey, rawEndKey := importer.rawStartKey, importer.rawEndKey
+	fileRule, err := rawFileRule(file, rewriteRules)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	if fileRule != nil {
+		rule = import_sstpb.RewriteRule{
+			OldKeyPrefix: fileRule.GetOldKeyPrefix(),
+			NewKeyPrefix: fileRule.GetNewKeyPrefix(),
+		}
+		rawStartKey = rewriteRawRangeKey(rawStartKey, fileRule)
+		if len(rawEndKey) > 0 {
+			rawEndKey = rewriteRawRangeKey(rawEndKey, fileRule)
+		}
+	}
 	sstMeta := GetSSTMetaFromFile(id, file, regionInfo.Region, &rule)
 
 	// Cut the SST file's range to fit in the restoring range.
-	if bytes.Compare(importer.rawStartKey, sstMeta.Range.GetStart()) > 0 {
-		sstMeta.Range.Start = importer.rawStartKey
+	if bytes.Compare(rawStartKey, sstMeta.Range.GetStart()) > 0 {
+		sstMeta.Range.Start = rawStartKey
 	}
-	if len(importer.rawEndKey) > 0 &&
-		(len(sstMeta.Range.GetEnd()) == 0 || bytes.Compare(importer.rawEndKey, sstMeta.Range.GetEnd()) <= 0) {
-		sstMeta.Range.End = importer.rawEndKey
+	if len(rawEndKey) > 0 &&
+		(len(sstMeta.Range.GetEnd()) == 0 || bytes.Compare(rawEndKey, sstMeta.Range.GetEnd()) <= 0) {
+		sstMeta.Range.End = rawEndKey
 		sstMeta.EndKeyExclusive = true
 	}
 	if bytes.Compare(sstMeta.Range.GetStart(), sstMeta.Range.GetEnd()) > 0 {
 		return nil, errors.Trace(berrors.ErrKVRangeIsEmpty)
 	}
 
+	backend, name := importer.source(file)
 	req := &import_sstpb.DownloadRequest{
 		Sst:            sstMeta,
-		StorageBackend: importer.backend,
-		Name:           file.GetName(),
+		StorageBackend: backend,
+		Name:           name,
 		RewriteRule:    rule,
 		IsRawKv:        true,
 	}
 	log.Debug("download SST", logutil.SSTMeta(&sstMeta), logutil.Region(regionInfo.Region))
+	var resp *import_sstpb.DownloadResponse
+	for _, peer := range regionInfo.Region.GetPeers() {
+		resp, err = importer.download(ctx, regionInfo, peer.GetStoreId(), req, size)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		if resp.GetError() != nil {
+			return nil, errors.Annotate(berrors.ErrKVDownloadFailed, resp.GetError().GetMessage())
+		}
+		if resp.GetIsEmpty() {
+			return nil, errors.Trace(berrors.ErrKVRangeIsEmpty)
+		}
+	}
+	sstMeta.Range.Start = resp.Range.GetStart()
+	sstMeta.Range.End = resp.Range.GetEnd()
+	return &sstMeta, nil
+}
+
+// downloadTxnKVSST downloads the SST file of the txn kv backup without
+// rewriting the keys, so the versions of the keys are kept as they were.
+func (importer *FileImporter) downloadTxnKVSST(
+	ctx context.Context,
+	regionInfo *RegionInfo,
+	file *backuppb.File,
+	size uint64,
+) (*import_sstpb.SSTMeta, error) {
+	uid := uuid.New()
+	id := uid[:]
+	// Empty rule
+	var rule import_sstpb.RewriteRule
+	sstMeta := GetSSTMetaFromFile(id, file, regionInfo.Region, &rule)
+
+	backend, name := importer.source(file)
+	req := &import_sstpb.DownloadRequest{
+		Sst:            sstMeta,
+		StorageBackend: backend,
+		Name:           name,
+		RewriteRule:    rule,
+	}
+	log.Debug("download SST", logutil.SSTMeta(&sstMeta), logutil.Region(regionInfo.Region))
 	var err error
 	var resp *import_sstpb.DownloadResponse
 	for _, peer := range regionInfo.Region.GetPeers() {
-		resp, err = importer.importClient.DownloadSST(ctx, peer.GetStoreId(), req)
+		resp, err = importer.download(ctx, regionInfo, peer.GetStoreId(), req, size)
 		if err != nil {
 			return nil, errors.Trace(err)
 		}
@@ -536,6 +700,15 @@ func (importer *FileImporter) downloadRawKVSST(
 	return &sstMeta, nil
 }
 
+// encodeTxnKey encodes the key of the txn kv file to compare with the keys of
+// the regions, the empty key means the unbounded end and is kept empty.
+func encodeTxnKey(key []byte) []byte {
+	if len(key) == 0 {
+		return key
+	}
+	return codec.EncodeBytes(key)
+}
+
 func (importer *FileImporter) ingestSSTs(
 	ctx context.Context,
 	sstMetas []*import_sstpb.SSTMeta,
@@ -561,8 +734,14 @@ func (importer *FileImporter) ingestSSTs(
 			Sst:     sstMetas[0],
 		}
 		log.Debug("ingest SST", logutil.SSTMeta(sstMetas[0]), logutil.Leader(leader))
-		resp, err := importer.importClient.IngestSST(ctx, leader.GetStoreId(), req)
-		return resp, errors.Trace(err)
+		release, err := importer.storeLimiter.acquire(ctx, leader.GetStoreId())
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		defer release()
+		ingestCtx, finish := importer.watchdog.track(ctx, watchdogOpIngest, regionInfo, leader.GetStoreId())
+		resp, err := importer.importClient.IngestSST(ingestCtx, leader.GetStoreId(), req)
+		return resp, errors.Trace(finish(err))
 	}
 
 	req := &import_sstpb.MultiIngestRequest{
@@ -570,6 +749,12 @@ func (importer *FileImporter) ingestSSTs(
 		Ssts:    sstMetas,
 	}
 	log.Debug("ingest SSTs", logutil.SSTMetas(sstMetas), logutil.Leader(leader))
-	resp, err := importer.importClient.MultiIngest(ctx, leader.GetStoreId(), req)
-	return resp, errors.Trace(err)
+	release, err := importer.storeLimiter.acquire(ctx, leader.GetStoreId())
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	defer release()
+	ingestCtx, finish := importer.watchdog.track(ctx, watchdogOpIngest, regionInfo, leader.GetStoreId())
+	resp, err := importer.importClient.MultiIngest(ingestCtx, leader.GetStoreId(), req)
+	return resp, errors.Trace(finish(err))
 }
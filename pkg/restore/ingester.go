@@ -41,12 +41,11 @@ import (
 	"github.com/pingcap/br/pkg/kv"
 	"github.com/pingcap/br/pkg/logutil"
 	"github.com/pingcap/br/pkg/membuf"
+	"github.com/pingcap/br/pkg/regionutil"
 	"github.com/pingcap/br/pkg/utils"
 )
 
 const (
-	dialTimeout = 5 * time.Second
-
 	gRPCKeepAliveTime    = 10 * time.Second
 	gRPCKeepAliveTimeout = 3 * time.Second
 
@@ -87,6 +86,7 @@ type Ingester struct {
 
 	tlsConf *tls.Config
 	conns   gRPCConns
+	timeout utils.TimeoutConfig
 
 	splitCli   SplitClient
 	WorkerPool *utils.WorkerPool
@@ -97,11 +97,12 @@ type Ingester struct {
 
 // NewIngester creates Ingester.
 func NewIngester(
-	splitCli SplitClient, cfg concurrencyCfg, commitTS uint64, tlsConf *tls.Config,
+	splitCli SplitClient, cfg concurrencyCfg, commitTS uint64, tlsConf *tls.Config, timeout utils.TimeoutConfig,
 ) *Ingester {
 	workerPool := utils.NewWorkerPool(cfg.IngestConcurrency, "ingest worker")
 	return &Ingester{
 		tlsConf: tlsConf,
+		timeout: timeout,
 		conns: gRPCConns{
 			tcpConcurrency: cfg.TCPConcurrency,
 			conns:          make(map[uint64]*conn.Pool),
@@ -123,7 +124,7 @@ func (i *Ingester) makeConn(ctx context.Context, storeID uint64) (*grpc.ClientCo
 	if i.tlsConf != nil {
 		opt = grpc.WithTransportCredentials(credentials.NewTLS(i.tlsConf))
 	}
-	ctx, cancel := context.WithTimeout(ctx, dialTimeout)
+	ctx, cancel := utils.ContextWithTimeout(ctx, i.timeout.Dial)
 
 	bfConf := backoff.DefaultConfig
 	bfConf.MaxDelay = gRPCBackOffMaxDelay
@@ -189,7 +190,7 @@ WriteAndIngest:
 		}
 		startKey := codec.EncodeBytes(pairStart)
 		endKey := codec.EncodeBytes(kv.NextKey(pairEnd))
-		regions, err = PaginateScanRegion(ctx, i.splitCli, startKey, endKey, 128)
+		regions, err = regionutil.PaginateScanRegion(ctx, i.splitCli, startKey, endKey, 128)
 		if err != nil || len(regions) == 0 {
 			log.Warn("scan region failed", zap.Error(err), zap.Int("region_len", len(regions)),
 				logutil.Key("startKey", startKey), logutil.Key("endKey", endKey), zap.Int("retry", retry))
@@ -346,6 +347,13 @@ func (i *Ingester) writeToTiKV(
 		},
 	}
 
+	// cancel the write streams if nothing is sent for a while, so that a stuck
+	// stream won't block the restore forever.
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	watchdog := startIdleWatchdog(i.timeout.WriteStreamIdle, cancel)
+	defer watchdog.stop()
+
 	leaderID := region.Leader.GetId()
 	clients := make([]sst.ImportSST_WriteClient, 0, len(region.Region.GetPeers()))
 	requests := make([]*sst.WriteRequest, 0, len(region.Region.GetPeers()))
@@ -369,6 +377,7 @@ func (i *Ingester) writeToTiKV(
 		if err = wstream.Send(req); err != nil {
 			return nil, nil, errors.Trace(err)
 		}
+		watchdog.reset()
 		req.Chunk = &sst.WriteRequest_Batch{
 			Batch: &sst.WriteBatch{
 				CommitTs: i.TS,
@@ -410,6 +419,7 @@ func (i *Ingester) writeToTiKV(
 				if err := clients[i].Send(requests[i]); err != nil {
 					return nil, nil, errors.Trace(err)
 				}
+				watchdog.reset()
 			}
 			count = 0
 			bytesBuf.Reset()
@@ -426,6 +436,7 @@ func (i *Ingester) writeToTiKV(
 			if err := clients[i].Send(requests[i]); err != nil {
 				return nil, nil, errors.Trace(err)
 			}
+			watchdog.reset()
 		}
 	}
 
@@ -491,6 +502,8 @@ func (i *Ingester) ingest(ctx context.Context, meta *sst.SSTMeta, region *Region
 		Context: reqCtx,
 		Sst:     meta,
 	}
+	ctx, cancel := utils.ContextWithTimeout(ctx, i.timeout.Ingest)
+	defer cancel()
 	resp, err := cli.Ingest(ctx, req)
 	if err != nil {
 		return nil, errors.Trace(err)
@@ -498,6 +511,40 @@ func (i *Ingester) ingest(ctx context.Context, meta *sst.SSTMeta, region *Region
 	return resp, nil
 }
 
+// idleWatchdog calls the cancel function if it isn't reset within the idle
+// timeout. A nil *idleWatchdog never fires.
+type idleWatchdog struct {
+	idle  time.Duration
+	timer *time.Timer
+}
+
+func startIdleWatchdog(idle time.Duration, cancel context.CancelFunc) *idleWatchdog {
+	if idle <= 0 {
+		return nil
+	}
+	return &idleWatchdog{
+		idle: idle,
+		timer: time.AfterFunc(idle, func() {
+			log.Warn("write stream is idle for too long, cancel it", zap.Duration("idle", idle))
+			cancel()
+		}),
+	}
+}
+
+func (w *idleWatchdog) reset() {
+	if w == nil {
+		return
+	}
+	w.timer.Reset(w.idle)
+}
+
+func (w *idleWatchdog) stop() {
+	if w == nil {
+		return
+	}
+	w.timer.Stop()
+}
+
 func (i *Ingester) getImportClient(ctx context.Context, peer *metapb.Peer) (sst.ImportSSTClient, error) {
 	i.conns.mu.Lock()
 	defer i.conns.mu.Unlock()
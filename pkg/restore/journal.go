@@ -0,0 +1,243 @@
+// Copyright 2021 PingCAP, Inc. Licensed under Apache-2.0.
+
+package restore
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pingcap/errors"
+	"github.com/pingcap/log"
+	"go.uber.org/zap"
+
+	berrors "github.com/pingcap/br/pkg/errors"
+	"github.com/pingcap/br/pkg/pdutil"
+	"github.com/pingcap/br/pkg/storage"
+	"github.com/pingcap/br/pkg/utils"
+)
+
+// JournalFilePrefix is the prefix of the journal entry files in the storage.
+// Every entry is stored as `restore.journal.<job-id>.<seq>`, because the
+// external storage doesn't support appending to an existing file.
+const JournalFilePrefix = "restore.journal."
+
+// JournalOp is the kind of a cluster-mutating operation recorded in the journal.
+type JournalOp string
+
+// The operations recorded in the journal.
+const (
+	JournalOpStart             JournalOp = "start"
+	JournalOpSetSafePoint      JournalOp = "set-safe-point"
+	JournalOpSwitchImportMode  JournalOp = "switch-import-mode"
+	JournalOpSwitchNormalMode  JournalOp = "switch-normal-mode"
+	JournalOpRemoveSchedulers  JournalOp = "remove-schedulers"
+	JournalOpRestoreSchedulers JournalOp = "restore-schedulers"
+	JournalOpSetPlacement      JournalOp = "set-placement-rules"
+	JournalOpResetPlacement    JournalOp = "reset-placement-rules"
+	JournalOpExecDDL           JournalOp = "exec-ddl"
+	JournalOpCreateDatabase    JournalOp = "create-database"
+	JournalOpCreateTable       JournalOp = "create-table"
+	JournalOpFinish            JournalOp = "finish"
+	JournalOpRecovered         JournalOp = "recovered"
+)
+
+// JournalEntry is a record of the journal. Only the fields related to the
+// operation are set.
+type JournalEntry struct {
+	Seq  int       `json:"seq"`
+	Op   JournalOp `json:"op"`
+	Time time.Time `json:"time"`
+
+	DB         string                    `json:"db,omitempty"`
+	Table      string                    `json:"table,omitempty"`
+	Query      string                    `json:"query,omitempty"`
+	TableIDs   []int64                   `json:"table-ids,omitempty"`
+	SafePoint  *utils.BRServiceSafePoint `json:"safe-point,omitempty"`
+	Schedulers *pdutil.ClusterConfig     `json:"schedulers,omitempty"`
+}
+
+// Journal is a write-ahead log of the cluster-mutating operations of a restore
+// job. A crashed job can be rolled back by a new process with the journal.
+//
+// All methods of a nil *Journal are no-op, so callers needn't check whether
+// the journal is enabled.
+type Journal struct {
+	storage storage.ExternalStorage
+	jobID   string
+
+	mu  sync.Mutex
+	seq int
+}
+
+// NewJournal creates a journal for a new restore job.
+func NewJournal(s storage.ExternalStorage) *Journal {
+	return &Journal{
+		storage: s,
+		jobID:   strconv.FormatInt(time.Now().UnixNano(), 10),
+	}
+}
+
+// OpenJournal opens the journal of an existing restore job to append entries.
+func OpenJournal(s storage.ExternalStorage, jobID string, entries []JournalEntry) *Journal {
+	j := &Journal{storage: s, jobID: jobID}
+	if len(entries) > 0 {
+		j.seq = entries[len(entries)-1].Seq
+	}
+	return j
+}
+
+// JobID returns the id of the restore job.
+func (j *Journal) JobID() string {
+	if j == nil {
+		return ""
+	}
+	return j.jobID
+}
+
+// Record appends an entry to the journal. It should be called before the
+// operation is executed.
+func (j *Journal) Record(ctx context.Context, entry JournalEntry) error {
+	if j == nil {
+		return nil
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	entry.Seq = j.seq + 1
+	entry.Time = time.Now()
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	name := fmt.Sprintf("%s%s.%08d", JournalFilePrefix, j.jobID, entry.Seq)
+	if err = j.storage.WriteFile(ctx, name, data); err != nil {
+		return errors.Annotatef(err, "failed to write restore journal %s", name)
+	}
+	j.seq = entry.Seq
+	log.Debug("record restore journal", zap.String("job", j.jobID), zap.Int("seq", entry.Seq), zap.String("op", string(entry.Op)))
+	return nil
+}
+
+// LoadJournals loads the journals of all restore jobs from the storage, and
+// returns the entries of each job sorted by their sequence numbers.
+func LoadJournals(ctx context.Context, s storage.ExternalStorage) (map[string][]JournalEntry, error) {
+	journals := make(map[string][]JournalEntry)
+	err := s.WalkDir(ctx, &storage.WalkOption{}, func(filePath string, _ int64) error {
+		name := path.Base(filePath)
+		if !strings.HasPrefix(name, JournalFilePrefix) {
+			return nil
+		}
+		parts := strings.Split(strings.TrimPrefix(name, JournalFilePrefix), ".")
+		if len(parts) != 2 {
+			return errors.Annotatef(berrors.ErrRestoreInvalidJournal, "invalid journal file name %s", name)
+		}
+		data, err := s.ReadFile(ctx, filePath)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		var entry JournalEntry
+		if err = json.Unmarshal(data, &entry); err != nil {
+			return errors.Annotatef(berrors.ErrRestoreInvalidJournal, "failed to decode %s: %v", name, err)
+		}
+		journals[parts[0]] = append(journals[parts[0]], entry)
+		return nil
+	})
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	for jobID, entries := range journals {
+		sort.Slice(entries, func(i, j int) bool { return entries[i].Seq < entries[j].Seq })
+		for i, entry := range entries {
+			if entry.Seq != i+1 {
+				return nil, errors.Annotatef(berrors.ErrRestoreInvalidJournal,
+					"entry #%d of job %s is missing", i+1, jobID)
+			}
+		}
+	}
+	return journals, nil
+}
+
+// JournalState is the cluster state left by a restore job, which is replayed
+// from the journal.
+type JournalState struct {
+	// Finished is true if the job has restored all data successfully.
+	Finished bool
+	// Recovered is true if the job has been rolled back by recover-journal.
+	Recovered bool
+	// ImportMode is true if TiKV may be still in import mode.
+	ImportMode bool
+	// Schedulers is the origin config of the removed schedulers if they
+	// haven't been restored.
+	Schedulers *pdutil.ClusterConfig
+	// SafePoint is the service safe point registered by the job.
+	SafePoint *utils.BRServiceSafePoint
+	// PlacementTableIDs are the tables whose placement rules haven't been reset.
+	PlacementTableIDs []int64
+	// SchemaChanges are the DDLs executed, and the databases and tables
+	// created by the job. They can't be rolled back automatically.
+	SchemaChanges []JournalEntry
+}
+
+// ReplayJournal replays the entries to get the cluster state left by the job.
+func ReplayJournal(entries []JournalEntry) *JournalState {
+	state := &JournalState{}
+	placements := make(map[int64]struct{})
+	for i := range entries {
+		entry := entries[i]
+		switch entry.Op {
+		case JournalOpSetSafePoint:
+			state.SafePoint = entry.SafePoint
+		case JournalOpSwitchImportMode:
+			state.ImportMode = true
+		case JournalOpSwitchNormalMode:
+			state.ImportMode = false
+		case JournalOpRemoveSchedulers:
+			state.Schedulers = entry.Schedulers
+		case JournalOpRestoreSchedulers:
+			state.Schedulers = nil
+		case JournalOpSetPlacement:
+			for _, id := range entry.TableIDs {
+				placements[id] = struct{}{}
+			}
+		case JournalOpResetPlacement:
+			for _, id := range entry.TableIDs {
+				delete(placements, id)
+			}
+		case JournalOpExecDDL, JournalOpCreateDatabase, JournalOpCreateTable:
+			state.SchemaChanges = append(state.SchemaChanges, entry)
+		case JournalOpFinish:
+			state.Finished = true
+			// the safe point expires soon after the job exits normally.
+			state.SafePoint = nil
+		case JournalOpRecovered:
+			state.Recovered = true
+			state.ImportMode = false
+			state.Schedulers = nil
+			state.SafePoint = nil
+			placements = make(map[int64]struct{})
+		}
+	}
+	for id := range placements {
+		state.PlacementTableIDs = append(state.PlacementTableIDs, id)
+	}
+	sort.Slice(state.PlacementTableIDs, func(i, j int) bool {
+		return state.PlacementTableIDs[i] < state.PlacementTableIDs[j]
+	})
+	return state
+}
+
+// NeedRecover returns whether the job left any change to be rolled back.
+func (state *JournalState) NeedRecover() bool {
+	if state.Recovered {
+		return false
+	}
+	return !state.Finished || state.ImportMode || state.Schedulers != nil ||
+		state.SafePoint != nil || len(state.PlacementTableIDs) > 0
+}
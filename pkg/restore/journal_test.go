@@ -0,0 +1,67 @@
+// Copyright 2021 PingCAP, Inc. Licensed under Apache-2.0.
+
+package restore_test
+
+import (
+	"context"
+
+	. "github.com/pingcap/check"
+
+	"github.com/pingcap/br/pkg/pdutil"
+	"github.com/pingcap/br/pkg/restore"
+	"github.com/pingcap/br/pkg/storage"
+	"github.com/pingcap/br/pkg/utils"
+)
+
+type testJournalSuite struct{}
+
+var _ = Suite(&testJournalSuite{})
+
+func (s *testJournalSuite) TestJournalRecordAndReplay(c *C) {
+	ctx := context.Background()
+	store, err := storage.NewLocalStorage(c.MkDir())
+	c.Assert(err, IsNil)
+
+	journal := restore.NewJournal(store)
+	entries := []restore.JournalEntry{
+		{Op: restore.JournalOpStart},
+		{Op: restore.JournalOpSetSafePoint, SafePoint: &utils.BRServiceSafePoint{ID: "br-1", TTL: 300, BackupTS: 42}},
+		{Op: restore.JournalOpCreateDatabase, DB: "test"},
+		{Op: restore.JournalOpCreateTable, DB: "test", Table: "t"},
+		{Op: restore.JournalOpSwitchImportMode},
+		{Op: restore.JournalOpRemoveSchedulers, Schedulers: &pdutil.ClusterConfig{Schedulers: []string{"balance-leader-scheduler"}}},
+		{Op: restore.JournalOpSetPlacement, TableIDs: []int64{3, 1, 2}},
+		{Op: restore.JournalOpResetPlacement, TableIDs: []int64{2}},
+	}
+	for _, entry := range entries {
+		c.Assert(journal.Record(ctx, entry), IsNil)
+	}
+	// another job which has finished normally.
+	finished := restore.NewJournal(store)
+	c.Assert(finished.Record(ctx, restore.JournalEntry{Op: restore.JournalOpStart}), IsNil)
+	c.Assert(finished.Record(ctx, restore.JournalEntry{Op: restore.JournalOpFinish}), IsNil)
+
+	journals, err := restore.LoadJournals(ctx, store)
+	c.Assert(err, IsNil)
+	c.Assert(journals, HasLen, 2)
+	c.Assert(restore.ReplayJournal(journals[finished.JobID()]).NeedRecover(), IsFalse)
+
+	loaded := journals[journal.JobID()]
+	c.Assert(loaded, HasLen, len(entries))
+	state := restore.ReplayJournal(loaded)
+	c.Assert(state.NeedRecover(), IsTrue)
+	c.Assert(state.Finished, IsFalse)
+	c.Assert(state.ImportMode, IsTrue)
+	c.Assert(state.SafePoint.ID, Equals, "br-1")
+	c.Assert(state.Schedulers.Schedulers, DeepEquals, []string{"balance-leader-scheduler"})
+	c.Assert(state.PlacementTableIDs, DeepEquals, []int64{1, 3})
+	c.Assert(state.SchemaChanges, HasLen, 2)
+
+	// appending to the existing journal continues the sequence.
+	reopened := restore.OpenJournal(store, journal.JobID(), loaded)
+	c.Assert(reopened.Record(ctx, restore.JournalEntry{Op: restore.JournalOpRecovered}), IsNil)
+	journals, err = restore.LoadJournals(ctx, store)
+	c.Assert(err, IsNil)
+	c.Assert(journals[journal.JobID()], HasLen, len(entries)+1)
+	c.Assert(restore.ReplayJournal(journals[journal.JobID()]).NeedRecover(), IsFalse)
+}
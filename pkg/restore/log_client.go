@@ -71,6 +71,14 @@ type LogClient struct {
 	splitClient    SplitClient
 	importerClient ImporterClient
 
+	// storage reads the log backup, decompressing the files by their
+	// extensions, so the compression used by the backup needn't be known.
+	storage storage.ExternalStorage
+	// ddlStorage reads the ddl files, which are read by the pullers of all
+	// tables, so it may cache them by ddlCache.
+	ddlStorage storage.ExternalStorage
+	ddlCache   *cdclog.DDLFileCache
+
 	// ingester is used to write and ingest kvs to tikv.
 	// lightning has the simlar logic and can reuse it.
 	ingester *Ingester
@@ -86,6 +94,16 @@ type LogClient struct {
 	tableBuffers map[int64]*cdclog.TableBuffer
 
 	tableFilter filter.Filter
+	// shardHandleRules rewrite the handles of the shard tables merged into
+	// one table.
+	shardHandleRules []ShardHandleRule
+	// columnTransformRules transform the columns of the decoded rows, e.g.
+	// mask the sensitive data.
+	columnTransformRules []ColumnTransformRule
+
+	// flushPolicy decides when to flush the table buffers, nil means the
+	// default policy on the kv count and size.
+	flushPolicy cdclog.FlushPolicy
 
 	// a map to store all drop schema ts, use it as a filter
 	dropTSMap sync.Map
@@ -114,8 +132,8 @@ func NewLogRestoreClient(
 	}
 
 	tlsConf := restoreClient.GetTLSConfig()
-	splitClient := NewSplitClient(restoreClient.GetPDClient(), tlsConf)
-	importClient := NewImportClient(splitClient, tlsConf, restoreClient.keepaliveConf)
+	splitClient := NewSplitClient(restoreClient.GetPDClient(), tlsConf, restoreClient.timeout.Dial)
+	importClient := NewImportClient(splitClient, tlsConf, restoreClient.keepaliveConf, restoreClient.timeout)
 
 	cfg := concurrencyCfg{
 		Concurrency:       concurrency,
@@ -128,8 +146,11 @@ func NewLogRestoreClient(
 
 	// commitTS append into encode key. we use a unified ts for once log restore.
 	commitTS := oracle.ComposeTS(time.Now().Unix()*1000, 0)
+	logStorage := storage.WithDecompression(restoreClient.storage)
 	lc := &LogClient{
 		restoreClient:  restoreClient,
+		storage:        logStorage,
+		ddlStorage:     logStorage,
 		splitClient:    splitClient,
 		importerClient: importClient,
 		startTS:        startTS,
@@ -139,11 +160,53 @@ func NewLogRestoreClient(
 		eventPullers:   make(map[int64]*cdclog.EventPuller),
 		tableBuffers:   make(map[int64]*cdclog.TableBuffer),
 		tableFilter:    tableFilter,
-		ingester:       NewIngester(splitClient, cfg, commitTS, tlsConf),
+		ingester:       NewIngester(splitClient, cfg, commitTS, tlsConf, restoreClient.timeout),
 	}
 	return lc, nil
 }
 
+// SetReaderPool reads the log backup through the pool shared by the pullers
+// of all tables, which bounds their parallel reads and bandwidth. The pool
+// accounts the compressed bytes. It must be set before SetDDLCacheSize, so
+// the cached ddl files aren't read through the pool again.
+func (l *LogClient) SetReaderPool(pool *storage.ReaderPool) {
+	l.storage = storage.WithDecompression(pool.Wrap(l.restoreClient.storage))
+	l.ddlStorage = l.storage
+}
+
+// SetDDLCacheSize sets the capacity in bytes of the cache of the ddl files
+// shared by all tables, so each ddl file is fetched once. The ddl files are
+// cached by their names and the sizes listed, see cdclog.DDLFileCache.
+func (l *LogClient) SetDDLCacheSize(size int64) {
+	if size <= 0 {
+		l.ddlCache = nil
+		l.ddlStorage = l.storage
+		return
+	}
+	l.ddlCache = cdclog.NewDDLFileCache(l.storage, size)
+	l.ddlStorage = l.ddlCache
+}
+
+// SetFlushPolicy sets the policy deciding when to flush the buffered kvs of
+// each table to TiKV.
+func (l *LogClient) SetFlushPolicy(policy cdclog.FlushPolicy) {
+	l.flushPolicy = policy
+}
+
+// SetShardHandleRules sets the rules to rewrite the handles of the shard
+// tables, so their rows don't collide when merged into one table by the extra
+// rewrite rules.
+func (l *LogClient) SetShardHandleRules(rules []ShardHandleRule) {
+	l.shardHandleRules = rules
+}
+
+// SetColumnTransformRules sets the rules to transform the columns of the
+// restored rows, e.g. mask the sensitive data when restoring the production
+// backups into staging environments.
+func (l *LogClient) SetColumnTransformRules(rules []ColumnTransformRule) {
+	l.columnTransformRules = rules
+}
+
 // ResetTSRange used for test.
 func (l *LogClient) ResetTSRange(startTS uint64, endTS uint64) {
 	l.startTS = startTS
@@ -173,7 +236,7 @@ func (l *LogClient) shouldFilter(item *cdclog.SortItem) bool {
 
 // NeedRestoreDDL determines whether to collect ddl file by ts range.
 func (l *LogClient) NeedRestoreDDL(fileName string) (bool, error) {
-	names := strings.Split(fileName, ".")
+	names := strings.Split(storage.TrimCompressExt(fileName), ".")
 	if len(names) != 2 {
 		log.Warn("found wrong format of ddl file", zap.String("file", fileName))
 		return false, nil
@@ -203,13 +266,31 @@ func (l *LogClient) NeedRestoreDDL(fileName string) (bool, error) {
 	return false, nil
 }
 
+// ddlFilesMarker returns the marker to skip the DDL files after endTS when
+// listing. The DDL files are named by maxUint64 - ts, which isn't zero-padded,
+// so the marker only works if all the files to restore have as many digits as
+// maxUint64, which is true for any TSO.
+func (l *LogClient) ddlFilesMarker() string {
+	minName := maxUint64 - l.endTS
+	if minName == 0 {
+		return ""
+	}
+	marker := strconv.FormatUint(minName-1, 10)
+	if len(marker) != len(strconv.FormatUint(maxUint64, 10)) {
+		return ""
+	}
+	return ddlFilePrefix + "." + marker
+}
+
 func (l *LogClient) collectDDLFiles(ctx context.Context) ([]string, error) {
 	ddlFiles := make([]string, 0)
 	opt := &storage.WalkOption{
-		SubDir:    ddlEventsDir,
-		ListCount: -1,
+		SubDir:     ddlEventsDir,
+		ListCount:  -1,
+		Glob:       ddlFilePrefix + ".*",
+		StartAfter: l.ddlFilesMarker(),
 	}
-	err := l.restoreClient.storage.WalkDir(ctx, opt, func(path string, size int64) error {
+	err := l.storage.WalkDir(ctx, opt, func(path string, size int64) error {
 		fileName := filepath.Base(path)
 		shouldRestore, err := l.NeedRestoreDDL(fileName)
 		if err != nil {
@@ -217,6 +298,9 @@ func (l *LogClient) collectDDLFiles(ctx context.Context) ([]string, error) {
 		}
 		if shouldRestore {
 			ddlFiles = append(ddlFiles, path)
+			if l.ddlCache != nil {
+				l.ddlCache.Record(path, size)
+			}
 		}
 		return nil
 	})
@@ -247,7 +331,7 @@ func (l *LogClient) doDBDDLJob(ctx context.Context, ddls []string) error {
 	}
 
 	for _, path := range ddls {
-		data, err := l.restoreClient.storage.ReadFile(ctx, path)
+		data, err := l.ddlStorage.ReadFile(ctx, path)
 		if err != nil {
 			return errors.Trace(err)
 		}
@@ -281,6 +365,7 @@ func (l *LogClient) doDBDDLJob(ctx context.Context, ddls []string) error {
 
 // NeedRestoreRowChange determine whether to collect this file by ts range.
 func (l *LogClient) NeedRestoreRowChange(fileName string) (bool, error) {
+	fileName = storage.TrimCompressExt(fileName)
 	if fileName == logPrefix {
 		// this file name appeared when file sink enabled
 		return true, nil
@@ -305,6 +390,21 @@ func (l *LogClient) NeedRestoreRowChange(fileName string) (bool, error) {
 	return false, nil
 }
 
+// rowChangeFilesMarker returns the marker to skip the row change files before
+// startTS when listing. The ts in the file names isn't zero-padded, so the
+// marker only works if all the files to restore have as many digits as it,
+// i.e. startTS - 1 has as many digits as the global resolved ts.
+func (l *LogClient) rowChangeFilesMarker() string {
+	if l.startTS == 0 {
+		return ""
+	}
+	marker := strconv.FormatUint(l.startTS-1, 10)
+	if len(marker) != len(strconv.FormatUint(l.meta.GlobalResolvedTS, 10)) {
+		return ""
+	}
+	return logPrefix + "." + marker
+}
+
 func (l *LogClient) collectRowChangeFiles(ctx context.Context) (map[int64][]string, error) {
 	// we should collect all related tables row change files
 	// by log meta info and by given table filter
@@ -337,15 +437,29 @@ func (l *LogClient) collectRowChangeFiles(ctx context.Context) (map[int64][]stri
 		tableIDs = append(tableIDs, tableID)
 	}
 
+	marker := l.rowChangeFilesMarker()
 	for _, tID := range tableIDs {
 		tableID := tID
 		// FIXME update log meta logic here
 		dir := fmt.Sprintf("%s%d", tableLogPrefix, tableID)
 		opt := &storage.WalkOption{
-			SubDir:    dir,
-			ListCount: -1,
+			SubDir:     dir,
+			ListCount:  -1,
+			Glob:       logPrefix + "*",
+			StartAfter: marker,
 		}
-		err := l.restoreClient.storage.WalkDir(ctx, opt, func(path string, size int64) error {
+		if marker != "" {
+			// the file appeared when file sink enabled sorts before the marker.
+			path := dir + "/" + logPrefix
+			exists, err := l.storage.FileExists(ctx, path)
+			if err != nil {
+				return nil, errors.Trace(err)
+			}
+			if exists {
+				rowChangeFiles[tableID] = append(rowChangeFiles[tableID], path)
+			}
+		}
+		err := l.storage.WalkDir(ctx, opt, func(path string, size int64) error {
 			fileName := filepath.Base(path)
 			shouldRestore, err := l.NeedRestoreRowChange(fileName)
 			if err != nil {
@@ -365,7 +479,7 @@ func (l *LogClient) collectRowChangeFiles(ctx context.Context) (map[int64][]stri
 	for tID, files := range rowChangeFiles {
 		sortFiles := files
 		sort.Slice(sortFiles, func(i, j int) bool {
-			if filepath.Base(sortFiles[j]) == logPrefix {
+			if storage.TrimCompressExt(filepath.Base(sortFiles[j])) == logPrefix {
 				return true
 			}
 			return sortFiles[i] < sortFiles[j]
@@ -506,6 +620,17 @@ func (l *LogClient) applyKVChanges(ctx context.Context, tableID int64) error {
 	for _, p := range tableBuffer.KvPairs {
 		p.ClassifyAndAppend(&dataKVs, &dataChecksum, &indexKVs, &indexChecksum)
 	}
+	// the kvs are encoded by the restored table, the user provided rules are
+	// matched against them as they were in the backed up table.
+	if extraRules := l.restoreClient.extraRewriteRules; extraRules != nil {
+		newTableID := tableBuffer.TableID()
+		for i := range dataKVs {
+			dataKVs[i].Key = rewriteRestoredKey(dataKVs[i].Key, tableID, newTableID, extraRules)
+		}
+		for i := range indexKVs {
+			indexKVs[i].Key = rewriteRestoredKey(indexKVs[i].Key, tableID, newTableID, extraRules)
+		}
+	}
 
 	err := l.writeRows(ctx, dataKVs)
 	if err != nil {
@@ -611,16 +736,11 @@ func (l *LogClient) restoreTableFromPuller(
 			log.Debug("[restoreFromPuller] execute ddl", zap.String("ddl", ddl.Query))
 
 			l.ddlLock.Lock()
-			err = l.restoreClient.db.se.Execute(ctx, fmt.Sprintf("use %s", item.Schema))
-			if err != nil {
-				return errors.Trace(err)
-			}
-
-			err = l.restoreClient.db.se.Execute(ctx, ddl.Query)
+			err = l.restoreClient.db.se.ExecuteBatch(ctx, []string{fmt.Sprintf("use %s", item.Schema), ddl.Query})
+			l.ddlLock.Unlock()
 			if err != nil {
 				return errors.Trace(err)
 			}
-			l.ddlLock.Unlock()
 
 			// if table dropped, we will pull next event to see if this table will create again.
 			// with next create table ddl, we can do reloadTableMeta.
@@ -680,8 +800,17 @@ func (l *LogClient) RestoreLogData(ctx context.Context, dom *domain.Domain) erro
 	// 2. Find proper data by TS range
 	// 3. Encode and ingest data to tikv
 
+	format, err := DetectLogFormat(ctx, l.storage)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	log.Info("detect log backup format", zap.String("format", string(format)))
+	if format == LogFormatNative {
+		return l.restoreNativeLogData(ctx, dom)
+	}
+
 	// parse meta file
-	data, err := l.restoreClient.storage.ReadFile(ctx, metaFile)
+	data, err := l.storage.ReadFile(ctx, metaFile)
 	if err != nil {
 		return errors.Trace(err)
 	}
@@ -734,7 +863,7 @@ func (l *LogClient) RestoreLogData(ctx context.Context, dom *domain.Domain) erro
 			zap.String("schema", schema),
 			zap.String("table", table),
 		)
-		l.eventPullers[tableID], err = cdclog.NewEventPuller(ctx, schema, table, ddlFiles, files, l.restoreClient.storage)
+		l.eventPullers[tableID], err = cdclog.NewEventPuller(ctx, schema, table, ddlFiles, files, l.storage, l.ddlStorage)
 		if err != nil {
 			return errors.Trace(err)
 		}
@@ -756,6 +885,29 @@ func (l *LogClient) RestoreLogData(ctx context.Context, dom *domain.Domain) erro
 
 		l.tableBuffers[tableID] = cdclog.NewTableBuffer(tableInfo, allocs,
 			l.concurrencyCfg.BatchFlushKVPairs, l.concurrencyCfg.BatchFlushKVSize)
+		if l.flushPolicy != nil {
+			l.tableBuffers[tableID].SetFlushPolicy(l.flushPolicy)
+		}
+		if shardHandle := MatchShardHandle(l.shardHandleRules, schema, table); shardHandle != nil {
+			log.Info("rewrite the handles of table with shard id",
+				zap.String("schema", schema),
+				zap.String("table", table),
+				zap.Int64("shard id", shardHandle.ShardID),
+			)
+			l.tableBuffers[tableID].SetShardHandle(shardHandle)
+		}
+		if transforms := MatchColumnTransforms(l.columnTransformRules, schema, table); transforms != nil {
+			columns := make([]string, 0, len(transforms))
+			for column := range transforms {
+				columns = append(columns, column)
+			}
+			log.Info("transform the columns of table",
+				zap.String("schema", schema),
+				zap.String("table", table),
+				zap.Strings("columns", columns),
+			)
+			l.tableBuffers[tableID].SetColumnTransforms(transforms)
+		}
 	}
 	// restore files
 	return l.restoreTables(ctx, dom)
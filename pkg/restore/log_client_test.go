@@ -76,7 +76,16 @@ func (s *testLogRestoreSuite) TestTsInRange(c *C) {
 	c.Assert(err, IsNil)
 	c.Assert(collected, IsTrue)
 
-	for _, fileName := range []string{"cdclog.3.1", "cdclo.3"} {
+	// the files compressed by the log backup are collected by their names
+	// without the compression extensions.
+	for _, fileName := range []string{"cdclog.gz", "cdclog.1.gz", "cdclog.1.zst"} {
+		s.client.ResetTSRange(1, 2)
+		collected, err = s.client.NeedRestoreRowChange(fileName)
+		c.Assert(err, IsNil)
+		c.Assert(collected, IsTrue)
+	}
+
+	for _, fileName := range []string{"cdclog.3.1", "cdclo.3", "cdclog.3.1.gz"} {
 		// wrong format won't collect
 		collected, err = s.client.NeedRestoreRowChange(fileName)
 		c.Assert(err, IsNil)
@@ -89,6 +98,10 @@ func (s *testLogRestoreSuite) TestTsInRange(c *C) {
 	c.Assert(err, IsNil)
 	c.Assert(collected, IsTrue)
 
+	collected, err = s.client.NeedRestoreDDL(ddlFile + ".gz")
+	c.Assert(err, IsNil)
+	c.Assert(collected, IsTrue)
+
 	for _, fileName := range []string{"ddl", "dld.1"} {
 		// wrong format won't collect
 		collected, err = s.client.NeedRestoreDDL(fileName)
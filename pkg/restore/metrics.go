@@ -0,0 +1,39 @@
+// Copyright 2021 PingCAP, Inc. Licensed under Apache-2.0.
+
+package restore
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	storeImportPendingGauge = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: "br",
+			Subsystem: "restore",
+			Name:      "tikv_import_pending_tasks",
+			Help:      "The pending tasks of the import thread pool of the TiKV stores.",
+		}, []string{"store"})
+
+	storeIngestDurationGauge = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: "br",
+			Subsystem: "restore",
+			Name:      "tikv_ingest_duration_seconds",
+			Help:      "The average duration of the ingest requests handled by the TiKV stores in the last interval.",
+		}, []string{"store"})
+
+	storeApplyDurationGauge = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: "br",
+			Subsystem: "restore",
+			Name:      "tikv_apply_duration_seconds",
+			Help:      "The average duration of applying the raft logs of the TiKV stores in the last interval.",
+		}, []string{"store"})
+)
+
+func init() { // nolint:gochecknoinits
+	prometheus.MustRegister(storeImportPendingGauge)
+	prometheus.MustRegister(storeIngestDurationGauge)
+	prometheus.MustRegister(storeApplyDurationGauge)
+}
@@ -0,0 +1,309 @@
+// Copyright 2021 PingCAP, Inc. Licensed under Apache-2.0.
+
+package restore
+
+import (
+	"bytes"
+	"context"
+	"sort"
+	"sync"
+
+	"github.com/gogo/protobuf/proto"
+	"github.com/pingcap/errors"
+	"github.com/pingcap/kvproto/pkg/import_sstpb"
+	"github.com/pingcap/kvproto/pkg/metapb"
+	"github.com/pingcap/kvproto/pkg/pdpb"
+	"github.com/pingcap/tidb/ddl/placement"
+	"github.com/pingcap/tidb/util/codec"
+
+	berrors "github.com/pingcap/br/pkg/errors"
+)
+
+// mockSplitClient keeps the regions of a mock cluster in memory, the splits
+// and the scatters take effect at once.
+type mockSplitClient struct {
+	mu      sync.Mutex
+	stores  map[uint64]*metapb.Store
+	regions []*RegionInfo // sorted by the start keys
+	rules   map[string]placement.Rule
+	nextID  uint64
+}
+
+// NewMockSplitClient returns a SplitClient of a mock cluster, which starts
+// with a region covering the whole key space and having a peer on each store.
+func NewMockSplitClient(stores []*metapb.Store) SplitClient {
+	c := &mockSplitClient{
+		stores: make(map[uint64]*metapb.Store, len(stores)),
+		rules:  make(map[string]placement.Rule),
+		nextID: 1,
+	}
+	for _, store := range stores {
+		c.stores[store.GetId()] = store
+	}
+	c.regions = []*RegionInfo{c.newRegion(nil, nil, &metapb.RegionEpoch{ConfVer: 1, Version: 1})}
+	return c
+}
+
+func (c *mockSplitClient) allocID() uint64 {
+	id := c.nextID
+	c.nextID++
+	return id
+}
+
+func (c *mockSplitClient) newRegion(startKey, endKey []byte, epoch *metapb.RegionEpoch) *RegionInfo {
+	region := &metapb.Region{
+		Id:          c.allocID(),
+		StartKey:    startKey,
+		EndKey:      endKey,
+		RegionEpoch: epoch,
+	}
+	ids := make([]uint64, 0, len(c.stores))
+	for id := range c.stores {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	for _, id := range ids {
+		region.Peers = append(region.Peers, &metapb.Peer{Id: c.allocID(), StoreId: id})
+	}
+	info := &RegionInfo{Region: region}
+	if len(region.Peers) > 0 {
+		info.Leader = region.Peers[0]
+	}
+	return info
+}
+
+func cloneRegionInfo(info *RegionInfo) *RegionInfo {
+	clone := &RegionInfo{Region: proto.Clone(info.Region).(*metapb.Region)}
+	if info.Leader != nil {
+		clone.Leader = proto.Clone(info.Leader).(*metapb.Peer)
+	}
+	return clone
+}
+
+// locate returns the index of the region containing the encoded key.
+func (c *mockSplitClient) locate(key []byte) int {
+	return sort.Search(len(c.regions), func(i int) bool {
+		end := c.regions[i].Region.GetEndKey()
+		return len(end) == 0 || bytes.Compare(key, end) < 0
+	})
+}
+
+func (c *mockSplitClient) GetStore(_ context.Context, storeID uint64) (*metapb.Store, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	store, ok := c.stores[storeID]
+	if !ok {
+		return nil, errors.Annotatef(berrors.ErrInvalidArgument, "store %d not found in the mock cluster", storeID)
+	}
+	return store, nil
+}
+
+func (c *mockSplitClient) GetRegion(_ context.Context, key []byte) (*RegionInfo, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return cloneRegionInfo(c.regions[c.locate(key)]), nil
+}
+
+func (c *mockSplitClient) GetRegionByID(_ context.Context, regionID uint64) (*RegionInfo, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, region := range c.regions {
+		if region.Region.GetId() == regionID {
+			return cloneRegionInfo(region), nil
+		}
+	}
+	// PD returns nothing for the unknown regions.
+	return nil, nil
+}
+
+func (c *mockSplitClient) SplitRegion(ctx context.Context, regionInfo *RegionInfo, key []byte) (*RegionInfo, error) {
+	_, newRegions, err := c.BatchSplitRegionsWithOrigin(ctx, regionInfo, [][]byte{key})
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	if len(newRegions) == 0 {
+		return nil, errors.Annotate(berrors.ErrRestoreSplitFailed, "new region is nil")
+	}
+	return newRegions[0], nil
+}
+
+// BatchSplitRegionsWithOrigin splits the region like TiKV does: the origin
+// region keeps the rightmost part, and the new regions take the left parts.
+func (c *mockSplitClient) BatchSplitRegionsWithOrigin(
+	_ context.Context, regionInfo *RegionInfo, keys [][]byte,
+) (*RegionInfo, []*RegionInfo, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	idx := -1
+	for i, region := range c.regions {
+		if region.Region.GetId() == regionInfo.Region.GetId() {
+			idx = i
+			break
+		}
+	}
+	if idx < 0 {
+		return nil, nil, errors.Annotatef(berrors.ErrRestoreSplitFailed,
+			"region %d not found in the mock cluster", regionInfo.Region.GetId())
+	}
+	origin := c.regions[idx]
+	if origin.Region.GetRegionEpoch().GetVersion() != regionInfo.Region.GetRegionEpoch().GetVersion() {
+		return nil, nil, errors.Annotatef(berrors.ErrRestoreSplitFailed,
+			"epoch not match, region %d", regionInfo.Region.GetId())
+	}
+
+	splitKeys := make([][]byte, 0, len(keys))
+	for _, key := range keys {
+		splitKey := codec.EncodeBytes([]byte{}, key)
+		if origin.ContainsInterior(splitKey) {
+			splitKeys = append(splitKeys, splitKey)
+		}
+	}
+	sort.Slice(splitKeys, func(i, j int) bool { return bytes.Compare(splitKeys[i], splitKeys[j]) < 0 })
+
+	newRegions := make([]*RegionInfo, 0, len(splitKeys))
+	startKey := origin.Region.GetStartKey()
+	for _, splitKey := range splitKeys {
+		if bytes.Equal(splitKey, startKey) {
+			continue
+		}
+		epoch := proto.Clone(origin.Region.GetRegionEpoch()).(*metapb.RegionEpoch)
+		epoch.Version++
+		newRegions = append(newRegions, c.newRegion(startKey, splitKey, epoch))
+		startKey = splitKey
+	}
+	origin.Region.StartKey = startKey
+	origin.Region.RegionEpoch.Version += uint64(len(newRegions))
+
+	regions := make([]*RegionInfo, 0, len(c.regions)+len(newRegions))
+	regions = append(regions, c.regions[:idx]...)
+	regions = append(regions, newRegions...)
+	c.regions = append(regions, c.regions[idx:]...)
+
+	result := make([]*RegionInfo, 0, len(newRegions))
+	for _, region := range newRegions {
+		result = append(result, cloneRegionInfo(region))
+	}
+	return cloneRegionInfo(origin), result, nil
+}
+
+func (c *mockSplitClient) BatchSplitRegions(
+	ctx context.Context, regionInfo *RegionInfo, keys [][]byte,
+) ([]*RegionInfo, error) {
+	_, newRegions, err := c.BatchSplitRegionsWithOrigin(ctx, regionInfo, keys)
+	return newRegions, err
+}
+
+func (c *mockSplitClient) ScatterRegion(ctx context.Context, regionInfo *RegionInfo) error {
+	region, err := c.GetRegionByID(ctx, regionInfo.Region.GetId())
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if region == nil {
+		return errors.Annotatef(berrors.ErrRestoreSplitFailed,
+			"region %d not found in the mock cluster", regionInfo.Region.GetId())
+	}
+	return nil
+}
+
+// GetOperator reports the scatters finished at once.
+func (c *mockSplitClient) GetOperator(_ context.Context, regionID uint64) (*pdpb.GetOperatorResponse, error) {
+	return &pdpb.GetOperatorResponse{
+		Header:   &pdpb.ResponseHeader{},
+		RegionId: regionID,
+		Desc:     []byte("scatter-region"),
+		Status:   pdpb.OperatorStatus_SUCCESS,
+	}, nil
+}
+
+func (c *mockSplitClient) ScanRegions(_ context.Context, key, endKey []byte, limit int) ([]*RegionInfo, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	regions := []*RegionInfo{}
+	for i := c.locate(key); i < len(c.regions) && (limit <= 0 || len(regions) < limit); i++ {
+		region := c.regions[i]
+		if len(endKey) != 0 && bytes.Compare(region.Region.GetStartKey(), endKey) >= 0 {
+			break
+		}
+		regions = append(regions, cloneRegionInfo(region))
+	}
+	return regions, nil
+}
+
+func (c *mockSplitClient) GetPlacementRule(_ context.Context, groupID, ruleID string) (placement.Rule, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.rules[groupID+"/"+ruleID], nil
+}
+
+func (c *mockSplitClient) SetPlacementRule(_ context.Context, rule placement.Rule) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.rules[rule.GroupID+"/"+rule.ID] = rule
+	return nil
+}
+
+func (c *mockSplitClient) DeletePlacementRule(_ context.Context, groupID, ruleID string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.rules, groupID+"/"+ruleID)
+	return nil
+}
+
+func (c *mockSplitClient) SetStoresLabel(_ context.Context, _ []uint64, _, _ string) error {
+	return nil
+}
+
+// mockImportClient accepts all the requests without touching any data, the
+// downloads report the rewritten ranges as TiKV does.
+type mockImportClient struct{}
+
+// NewMockImportClient returns an ImporterClient of a mock cluster.
+func NewMockImportClient() ImporterClient {
+	return mockImportClient{}
+}
+
+// withTS appends a zero timestamp to the key, TiKV returns the keys of the
+// downloaded SST with the timestamps.
+func withTS(key []byte) []byte {
+	if len(key) == 0 {
+		return nil
+	}
+	return append(append([]byte{}, key...), make([]byte, 8)...)
+}
+
+func (mockImportClient) DownloadSST(
+	_ context.Context, _ uint64, req *import_sstpb.DownloadRequest,
+) (*import_sstpb.DownloadResponse, error) {
+	return &import_sstpb.DownloadResponse{
+		Range: import_sstpb.Range{
+			Start: withTS(req.GetSst().GetRange().GetStart()),
+			End:   withTS(req.GetSst().GetRange().GetEnd()),
+		},
+	}, nil
+}
+
+func (mockImportClient) IngestSST(
+	context.Context, uint64, *import_sstpb.IngestRequest,
+) (*import_sstpb.IngestResponse, error) {
+	return &import_sstpb.IngestResponse{}, nil
+}
+
+func (mockImportClient) MultiIngest(
+	context.Context, uint64, *import_sstpb.MultiIngestRequest,
+) (*import_sstpb.IngestResponse, error) {
+	return &import_sstpb.IngestResponse{}, nil
+}
+
+func (mockImportClient) SetDownloadSpeedLimit(
+	context.Context, uint64, *import_sstpb.SetDownloadSpeedLimitRequest,
+) (*import_sstpb.SetDownloadSpeedLimitResponse, error) {
+	return &import_sstpb.SetDownloadSpeedLimitResponse{}, nil
+}
+
+func (mockImportClient) GetImportClient(_ context.Context, storeID uint64) (import_sstpb.ImportSSTClient, error) {
+	return nil, errors.Annotatef(berrors.ErrInvalidArgument, "store %d of the mock cluster has no import service", storeID)
+}
+
+func (mockImportClient) SupportMultiIngest(context.Context, []uint64) (bool, error) {
+	return true, nil
+}
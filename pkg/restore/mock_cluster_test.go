@@ -0,0 +1,76 @@
+// Copyright 2021 PingCAP, Inc. Licensed under Apache-2.0.
+
+package restore_test
+
+import (
+	"context"
+
+	. "github.com/pingcap/check"
+	"github.com/pingcap/kvproto/pkg/import_sstpb"
+	"github.com/pingcap/kvproto/pkg/metapb"
+	"github.com/pingcap/tidb/util/codec"
+
+	"github.com/pingcap/br/pkg/regionutil"
+	"github.com/pingcap/br/pkg/restore"
+)
+
+var _ = Suite(&testMockClusterSuite{})
+
+type testMockClusterSuite struct{}
+
+func (s *testMockClusterSuite) TestMockSplitClient(c *C) {
+	ctx := context.Background()
+	client := restore.NewMockSplitClient([]*metapb.Store{{Id: 1}, {Id: 2}})
+
+	regions, err := client.ScanRegions(ctx, nil, nil, 10)
+	c.Assert(err, IsNil)
+	c.Assert(regions, HasLen, 1)
+	c.Assert(regions[0].Region.Peers, HasLen, 2)
+	c.Assert(regions[0].Leader.GetStoreId(), Equals, uint64(1))
+
+	origin, newRegions, err := client.BatchSplitRegionsWithOrigin(ctx, regions[0],
+		[][]byte{[]byte("d"), []byte("b"), []byte("b")})
+	c.Assert(err, IsNil)
+	c.Assert(newRegions, HasLen, 2)
+	c.Assert(newRegions[0].Region.EndKey, DeepEquals, codec.EncodeBytes(nil, []byte("b")))
+	c.Assert(newRegions[1].Region.StartKey, DeepEquals, codec.EncodeBytes(nil, []byte("b")))
+	c.Assert(origin.Region.Id, Equals, regions[0].Region.Id)
+	c.Assert(origin.Region.StartKey, DeepEquals, codec.EncodeBytes(nil, []byte("d")))
+
+	// the stale epoch is rejected like TiKV does.
+	_, err = client.SplitRegion(ctx, regions[0], []byte("f"))
+	c.Assert(err, ErrorMatches, ".*epoch not match.*")
+
+	all, err := regionutil.PaginateScanRegion(ctx, client, nil, nil, 2)
+	c.Assert(err, IsNil)
+	c.Assert(all, HasLen, 3)
+	region, err := client.GetRegion(ctx, codec.EncodeBytes(nil, []byte("c")))
+	c.Assert(err, IsNil)
+	c.Assert(region.Region.Id, Equals, newRegions[1].Region.Id)
+	region, err = client.GetRegionByID(ctx, 100)
+	c.Assert(err, IsNil)
+	c.Assert(region, IsNil)
+	c.Assert(client.ScatterRegion(ctx, newRegions[0]), IsNil)
+	finished, err := regionutil.IsScatterRegionFinished(ctx, client, newRegions[0].Region.Id)
+	c.Assert(err, IsNil)
+	c.Assert(finished, IsTrue)
+}
+
+func (s *testMockClusterSuite) TestMockImportClient(c *C) {
+	ctx := context.Background()
+	client := restore.NewMockImportClient()
+	req := &import_sstpb.DownloadRequest{
+		Sst: import_sstpb.SSTMeta{Range: &import_sstpb.Range{Start: []byte("a"), End: []byte("b")}},
+	}
+	resp, err := client.DownloadSST(ctx, 1, req)
+	c.Assert(err, IsNil)
+	c.Assert(resp.GetError(), IsNil)
+	c.Assert(resp.Range.Start, DeepEquals, append([]byte("a"), make([]byte, 8)...))
+
+	ingest, err := client.MultiIngest(ctx, 1, &import_sstpb.MultiIngestRequest{})
+	c.Assert(err, IsNil)
+	c.Assert(ingest.GetError(), IsNil)
+	ok, err := client.SupportMultiIngest(ctx, []uint64{1})
+	c.Assert(err, IsNil)
+	c.Assert(ok, IsTrue)
+}
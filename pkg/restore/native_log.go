@@ -0,0 +1,412 @@
+// Copyright 2021 PingCAP, Inc. Licensed under Apache-2.0.
+
+package restore
+
+import (
+	"context"
+	"encoding/binary"
+	"path"
+	"strings"
+
+	"github.com/pingcap/errors"
+	"github.com/pingcap/tidb/util/codec"
+
+	berrors "github.com/pingcap/br/pkg/errors"
+	"github.com/pingcap/br/pkg/kv"
+	"github.com/pingcap/br/pkg/storage"
+)
+
+// LogFormat is the format of the log backup in the storage.
+type LogFormat string
+
+const (
+	// LogFormatCDCLog is the log backup written by the file sink of TiCDC.
+	LogFormatCDCLog LogFormat = "cdclog"
+	// LogFormatNative is the log backup written by TiKV itself.
+	LogFormatNative LogFormat = "native"
+
+	// nativeLogMetaDir is the directory of the metadata files of the native
+	// log backup, each of which lists the data files flushed by a store.
+	nativeLogMetaDir    = "v1/backupmeta"
+	nativeLogMetaSuffix = ".meta"
+
+	defaultCF = "default"
+	writeCF   = "write"
+
+	writeTypePut      = 'P'
+	writeTypeDelete   = 'D'
+	shortValuePrefix  = 'v'
+	tsLen             = 8
+	nativeLogLenBytes = 4
+)
+
+// DetectLogFormat detects the format of the log backup from the files in the
+// storage.
+func DetectLogFormat(ctx context.Context, s storage.ExternalStorage) (LogFormat, error) {
+	exists, err := s.FileExists(ctx, metaFile)
+	if err != nil {
+		return "", errors.Trace(err)
+	}
+	if exists {
+		return LogFormatCDCLog, nil
+	}
+	metas, err := nativeLogMetaFiles(ctx, s)
+	if err != nil {
+		return "", errors.Trace(err)
+	}
+	if len(metas) > 0 {
+		return LogFormatNative, nil
+	}
+	return "", errors.Annotatef(berrors.ErrInvalidArgument,
+		"neither %s nor %s/*%s is found in %s", metaFile, nativeLogMetaDir, nativeLogMetaSuffix, s.URI())
+}
+
+func nativeLogMetaFiles(ctx context.Context, s storage.ExternalStorage) ([]string, error) {
+	var names []string
+	err := s.WalkDir(ctx, &storage.WalkOption{SubDir: nativeLogMetaDir}, func(name string, _ int64) error {
+		if strings.HasSuffix(name, nativeLogMetaSuffix) {
+			names = append(names, path.Clean(name))
+		}
+		return nil
+	})
+	return names, errors.Trace(err)
+}
+
+// NativeLogFile is a data file of the native log backup, decoded from the
+// DataFileInfo of TiKV.
+type NativeLogFile struct {
+	Sha256          []byte
+	Path            string
+	NumberOfEntries int64
+	MinTS           uint64
+	MaxTS           uint64
+	ResolvedTS      uint64
+	RegionID        int64
+	StartKey        []byte
+	EndKey          []byte
+	CF              string
+	// IsDelete is whether the file records the deletions of the CF, e.g. the
+	// write records cleaned by GC, instead of the writes of users.
+	IsDelete bool
+	// IsMeta is whether the file records the meta keys of TiDB.
+	IsMeta  bool
+	TableID int64
+	Length  uint64
+}
+
+// NativeLogMetadata is a metadata file of the native log backup, decoded from
+// the Metadata of TiKV.
+type NativeLogMetadata struct {
+	Files      []*NativeLogFile
+	StoreID    int64
+	ResolvedTS uint64
+	MaxTS      uint64
+	MinTS      uint64
+}
+
+// protoField is a field of a protobuf message, the varint holds the fixed
+// numbers as well.
+type protoField struct {
+	num    int
+	varint uint64
+	bytes  []byte
+}
+
+// decodeProtoFields decodes the fields of a protobuf message in the wire
+// format, so the messages not in the vendored kvproto can be read.
+func decodeProtoFields(data []byte, fn func(f *protoField) error) error {
+	for len(data) > 0 {
+		tag, n := binary.Uvarint(data)
+		if n <= 0 {
+			return errors.Annotate(berrors.ErrInvalidMetaFile, "invalid protobuf tag")
+		}
+		data = data[n:]
+		f := &protoField{num: int(tag >> 3)}
+		switch tag & 7 {
+		case 0:
+			f.varint, n = binary.Uvarint(data)
+			if n <= 0 {
+				return errors.Annotatef(berrors.ErrInvalidMetaFile, "invalid varint of field %d", f.num)
+			}
+			data = data[n:]
+		case 1:
+			if len(data) < 8 {
+				return errors.Annotatef(berrors.ErrInvalidMetaFile, "truncated fixed64 of field %d", f.num)
+			}
+			f.varint = binary.LittleEndian.Uint64(data)
+			data = data[8:]
+		case 2:
+			l, n := binary.Uvarint(data)
+			if n <= 0 || uint64(len(data)-n) < l {
+				return errors.Annotatef(berrors.ErrInvalidMetaFile, "truncated bytes of field %d", f.num)
+			}
+			f.bytes = data[n : n+int(l)]
+			data = data[n+int(l):]
+		case 5:
+			if len(data) < 4 {
+				return errors.Annotatef(berrors.ErrInvalidMetaFile, "truncated fixed32 of field %d", f.num)
+			}
+			f.varint = uint64(binary.LittleEndian.Uint32(data))
+			data = data[4:]
+		default:
+			return errors.Annotatef(berrors.ErrInvalidMetaFile, "unsupported wire type %d of field %d", tag&7, f.num)
+		}
+		if err := fn(f); err != nil {
+			return errors.Trace(err)
+		}
+	}
+	return nil
+}
+
+// DecodeNativeLogMetadata decodes a metadata file of the native log backup.
+// The field numbers are the ones of the Metadata and DataFileInfo messages of
+// the backup proto of TiKV, the unknown fields are ignored.
+func DecodeNativeLogMetadata(data []byte) (*NativeLogMetadata, error) {
+	meta := &NativeLogMetadata{}
+	err := decodeProtoFields(data, func(f *protoField) error {
+		switch f.num {
+		case 1:
+			file, err := decodeNativeLogFile(f.bytes)
+			if err != nil {
+				return errors.Trace(err)
+			}
+			meta.Files = append(meta.Files, file)
+		case 2:
+			meta.StoreID = int64(f.varint)
+		case 3:
+			meta.ResolvedTS = f.varint
+		case 4:
+			meta.MaxTS = f.varint
+		case 5:
+			meta.MinTS = f.varint
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	return meta, nil
+}
+
+func decodeNativeLogFile(data []byte) (*NativeLogFile, error) {
+	file := &NativeLogFile{}
+	err := decodeProtoFields(data, func(f *protoField) error {
+		switch f.num {
+		case 1:
+			file.Sha256 = f.bytes
+		case 2:
+			file.Path = string(f.bytes)
+		case 3:
+			file.NumberOfEntries = int64(f.varint)
+		case 4:
+			file.MinTS = f.varint
+		case 5:
+			file.MaxTS = f.varint
+		case 6:
+			file.ResolvedTS = f.varint
+		case 7:
+			file.RegionID = int64(f.varint)
+		case 8:
+			file.StartKey = f.bytes
+		case 9:
+			file.EndKey = f.bytes
+		case 10:
+			file.CF = string(f.bytes)
+		case 11:
+			// FileType Delete = 0, Put = 1.
+			file.IsDelete = f.varint == 0
+		case 12:
+			file.IsMeta = f.varint != 0
+		case 13:
+			file.TableID = int64(f.varint)
+		case 14:
+			file.Length = f.varint
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	if file.Path == "" {
+		return nil, errors.Annotate(berrors.ErrInvalidMetaFile, "data file without path")
+	}
+	return file, nil
+}
+
+// NativeLogEvent is a KV event in a data file of the native log backup. The
+// key is the MVCC key, and the value is the value of the CF.
+type NativeLogEvent struct {
+	Key   []byte
+	Value []byte
+}
+
+// DecodeNativeLogEvents decodes the events of a data file of the native log
+// backup, each of which is the key and the value prefixed by their lengths in
+// 32-bit little endian.
+func DecodeNativeLogEvents(data []byte) ([]NativeLogEvent, error) {
+	var events []NativeLogEvent
+	next := func() ([]byte, error) {
+		if len(data) < nativeLogLenBytes {
+			return nil, errors.Annotate(berrors.ErrInvalidMetaFile, "truncated event length")
+		}
+		l := int(binary.LittleEndian.Uint32(data))
+		data = data[nativeLogLenBytes:]
+		if len(data) < l {
+			return nil, errors.Annotate(berrors.ErrInvalidMetaFile, "truncated event")
+		}
+		b := data[:l]
+		data = data[l:]
+		return b, nil
+	}
+	for len(data) > 0 {
+		key, err := next()
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		value, err := next()
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		events = append(events, NativeLogEvent{Key: key, Value: value})
+	}
+	return events, nil
+}
+
+// decodeMvccKey splits the MVCC key into the raw key and the timestamp.
+func decodeMvccKey(key []byte) ([]byte, uint64, error) {
+	// the data keys of the engine have the prefix 'z'.
+	if len(key) > 0 && key[0] == 'z' {
+		key = key[1:]
+	}
+	if len(key) < tsLen {
+		return nil, 0, errors.Annotatef(berrors.ErrInvalidMetaFile, "invalid mvcc key %x", key)
+	}
+	_, ts, err := codec.DecodeUintDesc(key[len(key)-tsLen:])
+	if err != nil {
+		return nil, 0, errors.Trace(err)
+	}
+	_, raw, err := codec.DecodeBytes(key[:len(key)-tsLen], nil)
+	if err != nil {
+		return nil, 0, errors.Annotatef(err, "invalid mvcc key %x", key)
+	}
+	return raw, ts, nil
+}
+
+// nativeLogWrite is the value of the write CF.
+type nativeLogWrite struct {
+	writeType  byte
+	startTS    uint64
+	shortValue []byte
+}
+
+func decodeNativeLogWrite(value []byte) (*nativeLogWrite, error) {
+	if len(value) == 0 {
+		return nil, errors.Annotate(berrors.ErrInvalidMetaFile, "empty write record")
+	}
+	w := &nativeLogWrite{writeType: value[0]}
+	startTS, n := binary.Uvarint(value[1:])
+	if n <= 0 {
+		return nil, errors.Annotate(berrors.ErrInvalidMetaFile, "invalid start ts of write record")
+	}
+	w.startTS = startTS
+	rest := value[1+n:]
+	if len(rest) > 0 && rest[0] == shortValuePrefix {
+		if len(rest) < 2 || len(rest) < 2+int(rest[1]) {
+			return nil, errors.Annotate(berrors.ErrInvalidMetaFile, "truncated short value of write record")
+		}
+		w.shortValue = rest[2 : 2+int(rest[1])]
+	}
+	return w, nil
+}
+
+type nativeLogChange struct {
+	commitTS uint64
+	write    *nativeLogWrite
+}
+
+// NativeLogChanges collects the latest change of each key committed in the
+// ts range from the events of the write and default CFs.
+type NativeLogChanges struct {
+	startTS uint64
+	endTS   uint64
+	// changes are keyed by the raw keys.
+	changes map[string]nativeLogChange
+	// values are keyed by the raw keys with the start ts.
+	values map[string][]byte
+}
+
+// NewNativeLogChanges returns the NativeLogChanges of the ts range.
+func NewNativeLogChanges(startTS, endTS uint64) *NativeLogChanges {
+	return &NativeLogChanges{
+		startTS: startTS,
+		endTS:   endTS,
+		changes: make(map[string]nativeLogChange),
+		values:  make(map[string][]byte),
+	}
+}
+
+func valueKey(raw []byte, startTS uint64) string {
+	var ts [tsLen]byte
+	binary.BigEndian.PutUint64(ts[:], startTS)
+	return string(raw) + string(ts[:])
+}
+
+// Add adds the event of the CF.
+func (c *NativeLogChanges) Add(cf string, event NativeLogEvent) error {
+	raw, ts, err := decodeMvccKey(event.Key)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	switch cf {
+	case defaultCF:
+		c.values[valueKey(raw, ts)] = event.Value
+	case writeCF:
+		if ts < c.startTS || ts > c.endTS {
+			return nil
+		}
+		w, err := decodeNativeLogWrite(event.Value)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		if w.writeType != writeTypePut && w.writeType != writeTypeDelete {
+			// locks and rollbacks don't change the data.
+			return nil
+		}
+		if change, ok := c.changes[string(raw)]; ok && change.commitTS > ts {
+			return nil
+		}
+		c.changes[string(raw)] = nativeLogChange{commitTS: ts, write: w}
+	default:
+		return errors.Annotatef(berrors.ErrInvalidMetaFile, "unknown cf %s", cf)
+	}
+	return nil
+}
+
+// Len returns the number of the changed keys.
+func (c *NativeLogChanges) Len() int {
+	return len(c.changes)
+}
+
+// Pairs returns the latest changes of the keys, the deleted keys are the
+// pairs with IsDelete.
+func (c *NativeLogChanges) Pairs() (kv.Pairs, error) {
+	pairs := make(kv.Pairs, 0, len(c.changes))
+	for key, change := range c.changes {
+		pair := kv.Pair{Key: []byte(key)}
+		switch {
+		case change.write.writeType == writeTypeDelete:
+			pair.IsDelete = true
+		case change.write.shortValue != nil:
+			pair.Val = change.write.shortValue
+		default:
+			value, ok := c.values[valueKey(pair.Key, change.write.startTS)]
+			if !ok {
+				return nil, errors.Annotatef(berrors.ErrInvalidMetaFile,
+					"the value of key %x committed at %d is not found in the default cf", pair.Key, change.commitTS)
+			}
+			pair.Val = value
+		}
+		pairs = append(pairs, pair)
+	}
+	return pairs, nil
+}
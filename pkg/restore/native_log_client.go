@@ -0,0 +1,318 @@
+// Copyright 2021 PingCAP, Inc. Licensed under Apache-2.0.
+
+package restore
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"strconv"
+	"strings"
+
+	"github.com/pingcap/errors"
+	"github.com/pingcap/kvproto/pkg/import_sstpb"
+	"github.com/pingcap/log"
+	"github.com/pingcap/parser/model"
+	"github.com/pingcap/tidb/domain"
+	"github.com/pingcap/tidb/tablecodec"
+	"github.com/pingcap/tidb/util/codec"
+	"go.uber.org/zap"
+	"golang.org/x/sync/errgroup"
+
+	berrors "github.com/pingcap/br/pkg/errors"
+	"github.com/pingcap/br/pkg/kv"
+	"github.com/pingcap/br/pkg/utils"
+)
+
+const (
+	metaHashData    = 'h'
+	metaDBsKey      = "DBs"
+	metaDBPrefix    = "DB:"
+	metaTablePrefix = "Table:"
+)
+
+// nativeLogTable is the name of a physical table in the native log backup.
+type nativeLogTable struct {
+	schema    string
+	table     string
+	partition string
+}
+
+// readNativeLogMetas reads the metadata files of the native log backup, and
+// returns the global resolved ts, the min of the resolved ts of the stores.
+func (l *LogClient) readNativeLogMetas(ctx context.Context) ([]*NativeLogMetadata, uint64, error) {
+	names, err := nativeLogMetaFiles(ctx, l.storage)
+	if err != nil {
+		return nil, 0, errors.Trace(err)
+	}
+	metas := make([]*NativeLogMetadata, 0, len(names))
+	storeResolvedTS := make(map[int64]uint64)
+	for _, name := range names {
+		data, err := l.storage.ReadFile(ctx, name)
+		if err != nil {
+			return nil, 0, errors.Trace(err)
+		}
+		meta, err := DecodeNativeLogMetadata(data)
+		if err != nil {
+			return nil, 0, errors.Annotatef(err, "failed to decode %s", name)
+		}
+		metas = append(metas, meta)
+		if meta.ResolvedTS > storeResolvedTS[meta.StoreID] {
+			storeResolvedTS[meta.StoreID] = meta.ResolvedTS
+		}
+	}
+	resolvedTS := maxUint64
+	for _, ts := range storeResolvedTS {
+		if ts < resolvedTS {
+			resolvedTS = ts
+		}
+	}
+	return metas, resolvedTS, nil
+}
+
+// readNativeLogFile reads the events of the data file into the changes.
+func (l *LogClient) readNativeLogFile(ctx context.Context, file *NativeLogFile, changes *NativeLogChanges) error {
+	data, err := l.storage.ReadFile(ctx, file.Path)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if file.Length > 0 && uint64(len(data)) != file.Length {
+		return errors.Annotatef(berrors.ErrInvalidMetaFile,
+			"the size of %s is %d, expect %d", file.Path, len(data), file.Length)
+	}
+	events, err := DecodeNativeLogEvents(data)
+	if err != nil {
+		return errors.Annotatef(err, "failed to decode %s", file.Path)
+	}
+	for _, event := range events {
+		if err = changes.Add(file.CF, event); err != nil {
+			return errors.Annotatef(err, "failed to decode %s", file.Path)
+		}
+	}
+	return nil
+}
+
+// nativeLogTables decodes the names of the physical tables from the schemas
+// changed in the meta keys. The tables whose schemas aren't changed during the
+// log backup aren't found.
+func nativeLogTables(pairs kv.Pairs) map[int64]nativeLogTable {
+	dbNames := make(map[int64]string)
+	var tables []*model.TableInfo
+	var tableDBs []int64
+	for _, pair := range pairs {
+		if pair.IsDelete {
+			continue
+		}
+		key, field, ok := decodeMetaHashKey(pair.Key)
+		if !ok {
+			continue
+		}
+		switch {
+		case string(key) == metaDBsKey && strings.HasPrefix(string(field), metaDBPrefix):
+			dbInfo := &model.DBInfo{}
+			if err := json.Unmarshal(pair.Val, dbInfo); err == nil {
+				dbNames[dbInfo.ID] = dbInfo.Name.O
+			}
+		case strings.HasPrefix(string(key), metaDBPrefix) && strings.HasPrefix(string(field), metaTablePrefix):
+			dbID, err := strconv.ParseInt(strings.TrimPrefix(string(key), metaDBPrefix), 10, 64)
+			if err != nil {
+				continue
+			}
+			tableInfo := &model.TableInfo{}
+			if err = json.Unmarshal(pair.Val, tableInfo); err == nil {
+				tables = append(tables, tableInfo)
+				tableDBs = append(tableDBs, dbID)
+			}
+		}
+	}
+
+	names := make(map[int64]nativeLogTable)
+	for i, tableInfo := range tables {
+		schema, ok := dbNames[tableDBs[i]]
+		if !ok {
+			continue
+		}
+		names[tableInfo.ID] = nativeLogTable{schema: schema, table: tableInfo.Name.O}
+		if tableInfo.Partition != nil {
+			for _, def := range tableInfo.Partition.Definitions {
+				names[def.ID] = nativeLogTable{schema: schema, table: tableInfo.Name.O, partition: def.Name.L}
+			}
+		}
+	}
+	return names
+}
+
+// decodeMetaHashKey decodes the key and the field of the hash data key of
+// the meta structure of TiDB.
+func decodeMetaHashKey(raw []byte) ([]byte, []byte, bool) {
+	if len(raw) == 0 || raw[0] != 'm' {
+		return nil, nil, false
+	}
+	rest, key, err := codec.DecodeBytes(raw[1:], nil)
+	if err != nil {
+		return nil, nil, false
+	}
+	rest, flag, err := codec.DecodeUint(rest)
+	if err != nil || flag != metaHashData {
+		return nil, nil, false
+	}
+	_, field, err := codec.DecodeBytes(rest, nil)
+	if err != nil {
+		return nil, nil, false
+	}
+	return key, field, true
+}
+
+// resolveNativeLogTable returns the ID of the physical table in the cluster
+// restored to. The table is found by its name if its schema is changed during
+// the log backup, otherwise by the same ID, e.g. the cluster is restored with
+// the IDs preserved. 0 means the table is filtered out or not found.
+func (l *LogClient) resolveNativeLogTable(dom *domain.Domain, tableID int64, names map[int64]nativeLogTable) int64 {
+	infoSchema := dom.InfoSchema()
+	name, ok := names[tableID]
+	if !ok {
+		table, ok := infoSchema.TableByID(tableID)
+		if !ok {
+			log.Warn("table of native log not found, skip it", zap.Int64("table id", tableID))
+			return 0
+		}
+		dbInfo, ok := infoSchema.SchemaByTable(table.Meta())
+		if !ok || !l.tableFilter.MatchTable(dbInfo.Name.O, table.Meta().Name.O) {
+			return 0
+		}
+		return tableID
+	}
+	if !l.tableFilter.MatchTable(name.schema, name.table) {
+		return 0
+	}
+	table, err := infoSchema.TableByName(model.NewCIStr(name.schema), model.NewCIStr(name.table))
+	if err != nil {
+		log.Warn("table of native log not found, skip it",
+			zap.Int64("table id", tableID),
+			zap.String("schema", name.schema),
+			zap.String("table", name.table))
+		return 0
+	}
+	tableInfo := table.Meta()
+	if name.partition == "" {
+		return tableInfo.ID
+	}
+	if tableInfo.Partition != nil {
+		for _, def := range tableInfo.Partition.Definitions {
+			if def.Name.L == name.partition {
+				return def.ID
+			}
+		}
+	}
+	log.Warn("partition of native log not found, skip it",
+		zap.Int64("table id", tableID),
+		zap.String("schema", name.schema),
+		zap.String("table", name.table),
+		zap.String("partition", name.partition))
+	return 0
+}
+
+// restoreNativeLogTable applies the latest changes of the physical table in
+// the files to the table of the new ID.
+func (l *LogClient) restoreNativeLogTable(ctx context.Context, oldID, newID int64, files []*NativeLogFile) error {
+	changes := NewNativeLogChanges(l.startTS, l.endTS)
+	for _, file := range files {
+		if err := l.readNativeLogFile(ctx, file, changes); err != nil {
+			return errors.Trace(err)
+		}
+	}
+	pairs, err := changes.Pairs()
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if len(pairs) == 0 {
+		return nil
+	}
+	oldPrefix := tablecodec.EncodeTablePrefix(oldID)
+	// the user provided rules are matched before the table rule, the same as
+	// the keys of the files.
+	rewriteRules := mergeRewriteRules(&RewriteRules{Data: []*import_sstpb.RewriteRule{{
+		OldKeyPrefix: oldPrefix,
+		NewKeyPrefix: tablecodec.EncodeTablePrefix(newID),
+	}}}, l.restoreClient.extraRewriteRules)
+	for i := range pairs {
+		if !bytes.HasPrefix(pairs[i].Key, oldPrefix) {
+			return errors.Annotatef(berrors.ErrInvalidMetaFile,
+				"key %x doesn't belong to table %d", pairs[i].Key, oldID)
+		}
+		pairs[i].Key = rewriteKey(pairs[i].Key, rewriteRules)
+	}
+	log.Info("apply native log changes to tikv",
+		zap.Int64("table id", oldID),
+		zap.Int64("restore table id", newID),
+		zap.Int("files", len(files)),
+		zap.Int("keys", len(pairs)))
+	return errors.Trace(l.writeRows(ctx, pairs))
+}
+
+// restoreNativeLogData restores the log backup written by TiKV. The changes
+// of the data keys are applied to the tables which already exist, while the
+// DDLs in the meta keys aren't replayed.
+func (l *LogClient) restoreNativeLogData(ctx context.Context, dom *domain.Domain) error {
+	metas, resolvedTS, err := l.readNativeLogMetas(ctx)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if l.startTS > resolvedTS {
+		return errors.Annotatef(berrors.ErrRestoreRTsConstrain,
+			"start ts:%d is greater than resolved ts:%d", l.startTS, resolvedTS)
+	}
+	if l.endTS > resolvedTS {
+		log.Info("end ts is greater than resolved ts,"+
+			" to keep consistency we only recover data until resolved ts",
+			zap.Uint64("end ts", l.endTS),
+			zap.Uint64("resolved ts", resolvedTS))
+		l.endTS = resolvedTS
+	}
+
+	metaChanges := NewNativeLogChanges(0, l.endTS)
+	tableFiles := make(map[int64][]*NativeLogFile)
+	for _, meta := range metas {
+		for _, file := range meta.Files {
+			if file.IsDelete || file.MinTS > l.endTS {
+				continue
+			}
+			// the schemas changed before the start ts name the tables as well.
+			if file.IsMeta {
+				if err = l.readNativeLogFile(ctx, file, metaChanges); err != nil {
+					return errors.Trace(err)
+				}
+				continue
+			}
+			// the values in the default cf are written at the start ts, which
+			// may be earlier than the start ts of the restore.
+			if file.CF == writeCF && file.MaxTS < l.startTS {
+				continue
+			}
+			tableFiles[file.TableID] = append(tableFiles[file.TableID], file)
+		}
+	}
+	metaPairs, err := metaChanges.Pairs()
+	if err != nil {
+		return errors.Trace(err)
+	}
+	names := nativeLogTables(metaPairs)
+	log.Info("collect native log files",
+		zap.Int("metas", len(metas)),
+		zap.Int("tables", len(tableFiles)),
+		zap.Int("tables with schema", len(names)))
+
+	workerPool := utils.NewWorkerPool(l.concurrencyCfg.Concurrency, "native log restore")
+	eg, ectx := errgroup.WithContext(ctx)
+	for tableID, files := range tableFiles {
+		oldID, files := tableID, files
+		newID := l.resolveNativeLogTable(dom, oldID, names)
+		if newID == 0 {
+			continue
+		}
+		workerPool.ApplyOnErrorGroup(eg, func() error {
+			return l.restoreNativeLogTable(ectx, oldID, newID, files)
+		})
+	}
+	return errors.Trace(eg.Wait())
+}
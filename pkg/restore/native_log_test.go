@@ -0,0 +1,155 @@
+// Copyright 2021 PingCAP, Inc. Licensed under Apache-2.0.
+
+package restore_test
+
+import (
+	"context"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/gogo/protobuf/proto"
+	. "github.com/pingcap/check"
+	"github.com/pingcap/tidb/tablecodec"
+	"github.com/pingcap/tidb/util/codec"
+
+	"github.com/pingcap/br/pkg/restore"
+	"github.com/pingcap/br/pkg/storage"
+)
+
+var _ = Suite(&testNativeLogSuite{})
+
+type testNativeLogSuite struct{}
+
+func encodeNativeLogFile(path, cf string, tableID int64, minTS, maxTS uint64) []byte {
+	b := proto.NewBuffer(nil)
+	_ = b.EncodeVarint(2<<3 | 2)
+	_ = b.EncodeStringBytes(path)
+	_ = b.EncodeVarint(4 << 3)
+	_ = b.EncodeVarint(minTS)
+	_ = b.EncodeVarint(5 << 3)
+	_ = b.EncodeVarint(maxTS)
+	_ = b.EncodeVarint(10<<3 | 2)
+	_ = b.EncodeStringBytes(cf)
+	_ = b.EncodeVarint(11 << 3)
+	_ = b.EncodeVarint(1)
+	_ = b.EncodeVarint(13 << 3)
+	_ = b.EncodeVarint(uint64(tableID))
+	// an unknown field.
+	_ = b.EncodeVarint(99<<3 | 2)
+	_ = b.EncodeStringBytes("ignored")
+	return b.Bytes()
+}
+
+func (s *testNativeLogSuite) TestDecodeNativeLogMetadata(c *C) {
+	b := proto.NewBuffer(nil)
+	_ = b.EncodeVarint(1<<3 | 2)
+	_ = b.EncodeRawBytes(encodeNativeLogFile("v1/1.log", "write", 42, 10, 20))
+	_ = b.EncodeVarint(2 << 3)
+	_ = b.EncodeVarint(3)
+	_ = b.EncodeVarint(3 << 3)
+	_ = b.EncodeVarint(25)
+
+	meta, err := restore.DecodeNativeLogMetadata(b.Bytes())
+	c.Assert(err, IsNil)
+	c.Assert(meta.StoreID, Equals, int64(3))
+	c.Assert(meta.ResolvedTS, Equals, uint64(25))
+	c.Assert(meta.Files, HasLen, 1)
+	c.Assert(*meta.Files[0], DeepEquals, restore.NativeLogFile{
+		Path: "v1/1.log", CF: "write", TableID: 42, MinTS: 10, MaxTS: 20,
+	})
+
+	_, err = restore.DecodeNativeLogMetadata(b.Bytes()[:len(b.Bytes())-1])
+	c.Assert(err, ErrorMatches, ".*invalid varint.*")
+}
+
+func mvccKey(raw []byte, ts uint64) []byte {
+	return codec.EncodeUintDesc(codec.EncodeBytes(nil, raw), ts)
+}
+
+func writeRecord(writeType byte, startTS uint64, shortValue []byte) []byte {
+	var ts [binary.MaxVarintLen64]byte
+	b := append([]byte{writeType}, ts[:binary.PutUvarint(ts[:], startTS)]...)
+	if shortValue != nil {
+		b = append(b, 'v', byte(len(shortValue)))
+		b = append(b, shortValue...)
+	}
+	return b
+}
+
+func encodeNativeLogEvents(events []restore.NativeLogEvent) []byte {
+	var data []byte
+	var l [4]byte
+	for _, e := range events {
+		binary.LittleEndian.PutUint32(l[:], uint32(len(e.Key)))
+		data = append(append(data, l[:]...), e.Key...)
+		binary.LittleEndian.PutUint32(l[:], uint32(len(e.Value)))
+		data = append(append(data, l[:]...), e.Value...)
+	}
+	return data
+}
+
+func (s *testNativeLogSuite) TestNativeLogChanges(c *C) {
+	key1 := tablecodec.EncodeRowKey(42, codec.EncodeInt(nil, 1))
+	key2 := tablecodec.EncodeRowKey(42, codec.EncodeInt(nil, 2))
+	key3 := tablecodec.EncodeRowKey(42, codec.EncodeInt(nil, 3))
+	writes := []restore.NativeLogEvent{
+		// the short value overwritten by the later put.
+		{Key: mvccKey(key1, 11), Value: writeRecord('P', 10, []byte("v1"))},
+		{Key: mvccKey(key1, 21), Value: writeRecord('P', 20, nil)},
+		{Key: mvccKey(key2, 12), Value: writeRecord('P', 11, []byte("v2"))},
+		{Key: mvccKey(key2, 13), Value: writeRecord('D', 12, nil)},
+		// rollbacks and the changes out of the range are ignored.
+		{Key: mvccKey(key3, 14), Value: writeRecord('R', 14, nil)},
+		{Key: mvccKey(key3, 40), Value: writeRecord('P', 39, []byte("v3"))},
+	}
+	defaults := []restore.NativeLogEvent{
+		{Key: mvccKey(key1, 20), Value: []byte("long value")},
+	}
+	writeEvents, err := restore.DecodeNativeLogEvents(encodeNativeLogEvents(writes))
+	c.Assert(err, IsNil)
+	c.Assert(writeEvents, DeepEquals, writes)
+
+	changes := restore.NewNativeLogChanges(10, 30)
+	for _, e := range writeEvents {
+		c.Assert(changes.Add("write", e), IsNil)
+	}
+	_, err = changes.Pairs()
+	c.Assert(err, ErrorMatches, ".*not found in the default cf.*")
+	for _, e := range defaults {
+		c.Assert(changes.Add("default", e), IsNil)
+	}
+	c.Assert(changes.Len(), Equals, 2)
+	pairs, err := changes.Pairs()
+	c.Assert(err, IsNil)
+	sort.Slice(pairs, func(i, j int) bool { return string(pairs[i].Key) < string(pairs[j].Key) })
+	c.Assert(pairs, HasLen, 2)
+	c.Assert(pairs[0].Key, DeepEquals, []byte(key1))
+	c.Assert(pairs[0].Val, DeepEquals, []byte("long value"))
+	c.Assert(pairs[1].Key, DeepEquals, []byte(key2))
+	c.Assert(pairs[1].IsDelete, IsTrue)
+
+	_, err = restore.DecodeNativeLogEvents([]byte{1, 0})
+	c.Assert(err, ErrorMatches, ".*truncated event length.*")
+}
+
+func (s *testNativeLogSuite) TestDetectLogFormat(c *C) {
+	ctx := context.Background()
+	dir := c.MkDir()
+	st, err := storage.NewLocalStorage(dir)
+	c.Assert(err, IsNil)
+	_, err = restore.DetectLogFormat(ctx, st)
+	c.Assert(err, ErrorMatches, ".*neither log.meta nor.*")
+
+	c.Assert(os.MkdirAll(filepath.Join(dir, "v1", "backupmeta"), 0o755), IsNil)
+	c.Assert(st.WriteFile(ctx, "v1/backupmeta/1-abc.meta", nil), IsNil)
+	format, err := restore.DetectLogFormat(ctx, st)
+	c.Assert(err, IsNil)
+	c.Assert(format, Equals, restore.LogFormatNative)
+
+	c.Assert(st.WriteFile(ctx, "log.meta", []byte("{}")), IsNil)
+	format, err = restore.DetectLogFormat(ctx, st)
+	c.Assert(err, IsNil)
+	c.Assert(format, Equals, restore.LogFormatCDCLog)
+}
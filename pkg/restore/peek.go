@@ -0,0 +1,312 @@
+// Copyright 2021 PingCAP, Inc. Licensed under Apache-2.0.
+
+package restore
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"path"
+	"sort"
+	"time"
+
+	"github.com/cockroachdb/pebble/sstable"
+	"github.com/cockroachdb/pebble/vfs"
+	"github.com/pingcap/errors"
+	backuppb "github.com/pingcap/kvproto/pkg/backup"
+	"github.com/pingcap/parser/model"
+	"github.com/pingcap/parser/mysql"
+	"github.com/pingcap/tidb/tablecodec"
+	"github.com/pingcap/tidb/types"
+
+	"github.com/pingcap/br/pkg/cdclog"
+	berrors "github.com/pingcap/br/pkg/errors"
+	"github.com/pingcap/br/pkg/metautil"
+	"github.com/pingcap/br/pkg/storage"
+)
+
+// PeekRow is a row of a table decoded from the backup.
+type PeekRow struct {
+	// Handle is the handle of the row in the SST files, or the row id in the
+	// cdclog files.
+	Handle string
+	// Values are the values of the columns in the order of PeekResult.Columns,
+	// nil means NULL.
+	Values []*string
+}
+
+// PeekResult is the sample rows of a table.
+type PeekResult struct {
+	Columns []string
+	Rows    []PeekRow
+}
+
+func publicColumns(tableInfo *model.TableInfo) []*model.ColumnInfo {
+	cols := make([]*model.ColumnInfo, 0, len(tableInfo.Columns))
+	for _, col := range tableInfo.Columns {
+		if col.State == model.StatePublic {
+			cols = append(cols, col)
+		}
+	}
+	return cols
+}
+
+func datumString(d types.Datum) (*string, error) {
+	if d.IsNull() {
+		return nil, nil
+	}
+	s, err := d.ToString()
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	return &s, nil
+}
+
+// PeekTableRows decodes at most limit rows of the table from the SST files of
+// the backup, without restoring them. The rows are in the order of their keys.
+func PeekTableRows(
+	ctx context.Context,
+	s storage.ExternalStorage,
+	table *metautil.Table,
+	limit int,
+) (*PeekResult, error) {
+	cols := publicColumns(table.Info)
+	result := &PeekResult{Columns: make([]string, 0, len(cols))}
+	fieldTypes := make(map[int64]*types.FieldType, len(cols))
+	for _, col := range cols {
+		result.Columns = append(result.Columns, col.Name.O)
+		fieldTypes[col.ID] = &col.FieldType
+	}
+
+	// the write and default SST files of a range share the start key.
+	files := append([]*backuppb.File(nil), table.Files...)
+	sort.SliceStable(files, func(i, j int) bool {
+		return bytes.Compare(files[i].StartKey, files[j].StartKey) < 0
+	})
+	for i := 0; i < len(files) && len(result.Rows) < limit; {
+		j := i + 1
+		for j < len(files) && bytes.Equal(files[j].StartKey, files[i].StartKey) {
+			j++
+		}
+		changes := NewNativeLogChanges(0, maxUint64)
+		for _, file := range files[i:j] {
+			if err := addSSTChanges(ctx, s, file, changes); err != nil {
+				return nil, errors.Trace(err)
+			}
+		}
+		i = j
+
+		pairs, err := changes.Pairs()
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		sort.Slice(pairs, func(a, b int) bool {
+			return bytes.Compare(pairs[a].Key, pairs[b].Key) < 0
+		})
+		for _, pair := range pairs {
+			if len(result.Rows) >= limit {
+				break
+			}
+			if pair.IsDelete || !tablecodec.IsRecordKey(pair.Key) {
+				// the index keys don't contain the whole rows.
+				continue
+			}
+			row, err := decodePeekRow(table.Info, cols, fieldTypes, pair.Key, pair.Val)
+			if err != nil {
+				return nil, errors.Trace(err)
+			}
+			result.Rows = append(result.Rows, row)
+		}
+	}
+	return result, nil
+}
+
+func decodePeekRow(
+	tableInfo *model.TableInfo,
+	cols []*model.ColumnInfo,
+	fieldTypes map[int64]*types.FieldType,
+	key, value []byte,
+) (PeekRow, error) {
+	_, handle, err := tablecodec.DecodeRecordKey(key)
+	if err != nil {
+		return PeekRow{}, errors.Annotatef(berrors.ErrInvalidMetaFile, "invalid record key %x: %v", key, err)
+	}
+	datums, err := tablecodec.DecodeRowToDatumMap(value, fieldTypes, time.UTC)
+	if err != nil {
+		return PeekRow{}, errors.Annotatef(berrors.ErrInvalidMetaFile, "invalid row of key %x: %v", key, err)
+	}
+	row := PeekRow{Handle: handle.String(), Values: make([]*string, 0, len(cols))}
+	for _, col := range cols {
+		d, ok := datums[col.ID]
+		if !ok {
+			if tableInfo.PKIsHandle && mysql.HasPriKeyFlag(col.Flag) {
+				// the integer primary key is stored in the key only.
+				d = types.NewIntDatum(handle.IntValue())
+			} else {
+				// the columns added after the row is written.
+				d = types.NewDatum(col.GetOriginDefaultValue())
+			}
+		}
+		v, err := datumString(d)
+		if err != nil {
+			return PeekRow{}, errors.Trace(err)
+		}
+		row.Values = append(row.Values, v)
+	}
+	return row, nil
+}
+
+// addSSTChanges adds the KVs of the SST file to the changes by its CF.
+func addSSTChanges(ctx context.Context, s storage.ExternalStorage, file *backuppb.File, changes *NativeLogChanges) error {
+	cf := file.Cf
+	if cf == "" {
+		cf = defaultCF
+	}
+	data, err := s.ReadFile(ctx, file.Name)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	// pebble can only read SST files from a file system.
+	fs := vfs.NewMem()
+	name := path.Base(file.Name)
+	f, err := fs.Create(name)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if _, err = f.Write(data); err != nil {
+		return errors.Trace(err)
+	}
+	if err = f.Close(); err != nil {
+		return errors.Trace(err)
+	}
+	if f, err = fs.Open(name); err != nil {
+		return errors.Trace(err)
+	}
+	reader, err := sstable.NewReader(f, sstable.ReaderOptions{})
+	if err != nil {
+		return errors.Annotatef(berrors.ErrInvalidMetaFile, "failed to read SST file %s: %v", file.Name, err)
+	}
+	defer reader.Close()
+	iter, err := reader.NewIter(nil, nil)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	defer iter.Close()
+	for key, value := iter.First(); key != nil; key, value = iter.Next() {
+		event := NativeLogEvent{
+			Key:   append([]byte(nil), key.UserKey...),
+			Value: append([]byte(nil), value...),
+		}
+		if err = changes.Add(cf, event); err != nil {
+			return errors.Annotatef(err, "file %s", file.Name)
+		}
+	}
+	return errors.Trace(iter.Error())
+}
+
+// PeekLogTableRows decodes at most limit rows of the table from the cdclog
+// files, the rows are the new values of the upserted rows in the order of the
+// files.
+func PeekLogTableRows(
+	ctx context.Context,
+	s storage.ExternalStorage,
+	dbName, tableName string,
+	limit int,
+) (*PeekResult, error) {
+	data, err := s.ReadFile(ctx, metaFile)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	meta := &LogMeta{}
+	if err = json.Unmarshal(data, meta); err != nil {
+		return nil, errors.Annotatef(berrors.ErrInvalidMetaFile, "invalid %s: %v", metaFile, err)
+	}
+	// the table may be recreated, keep the latest one like restore.
+	tableID := int64(-1)
+	for id, name := range meta.Names {
+		db, table := ParseQuoteName(name)
+		if db == dbName && table == tableName && id > tableID {
+			tableID = id
+		}
+	}
+	if tableID < 0 {
+		return nil, errors.Annotatef(berrors.ErrInvalidArgument, "table %s.%s not found in the log backup", dbName, tableName)
+	}
+
+	var files []string
+	err = s.WalkDir(ctx, &storage.WalkOption{
+		SubDir:    fmt.Sprintf("%s%d", tableLogPrefix, tableID),
+		ListCount: -1,
+		Glob:      logPrefix + "*",
+	}, func(name string, _ int64) error {
+		files = append(files, name)
+		return nil
+	})
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	// the file being written by the file sink is the latest one.
+	sort.Slice(files, func(i, j int) bool {
+		if storage.TrimCompressExt(path.Base(files[j])) == logPrefix {
+			return true
+		}
+		return files[i] < files[j]
+	})
+
+	result := &PeekResult{}
+	colIndex := make(map[string]int)
+	for _, file := range files {
+		if len(result.Rows) >= limit {
+			break
+		}
+		data, err := s.ReadFile(ctx, file)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		decoder, err := cdclog.NewJSONEventBatchDecoder(data)
+		if err != nil {
+			return nil, errors.Annotatef(err, "file %s", file)
+		}
+		for decoder != nil && decoder.HasNext() && len(result.Rows) < limit {
+			item, err := decoder.NextEvent(cdclog.RowChanged)
+			if err != nil {
+				return nil, errors.Annotatef(err, "file %s", file)
+			}
+			msg := item.Data.(*cdclog.MessageRow)
+			if len(msg.Update) == 0 {
+				continue
+			}
+			names := make([]string, 0, len(msg.Update))
+			for name := range msg.Update {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+			row := PeekRow{Handle: fmt.Sprintf("%d", item.RowID), Values: make([]*string, len(result.Columns))}
+			for _, name := range names {
+				idx, ok := colIndex[name]
+				if !ok {
+					idx = len(result.Columns)
+					colIndex[name] = idx
+					result.Columns = append(result.Columns, name)
+					row.Values = append(row.Values, nil)
+				}
+				d, err := msg.Update[name].ToDatum()
+				if err != nil {
+					return nil, errors.Trace(err)
+				}
+				if row.Values[idx], err = datumString(d); err != nil {
+					return nil, errors.Trace(err)
+				}
+			}
+			result.Rows = append(result.Rows, row)
+		}
+	}
+	// the rows decoded before the new columns appear have fewer values.
+	for i := range result.Rows {
+		for len(result.Rows[i].Values) < len(result.Columns) {
+			result.Rows[i].Values = append(result.Rows[i].Values, nil)
+		}
+	}
+	return result, nil
+}
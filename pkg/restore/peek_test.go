@@ -0,0 +1,118 @@
+// Copyright 2021 PingCAP, Inc. Licensed under Apache-2.0.
+
+package restore_test
+
+import (
+	"context"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/cockroachdb/pebble/sstable"
+	. "github.com/pingcap/check"
+	backuppb "github.com/pingcap/kvproto/pkg/backup"
+	"github.com/pingcap/parser/model"
+	"github.com/pingcap/parser/mysql"
+	"github.com/pingcap/tidb/kv"
+	"github.com/pingcap/tidb/sessionctx/stmtctx"
+	"github.com/pingcap/tidb/tablecodec"
+	"github.com/pingcap/tidb/types"
+	"github.com/pingcap/tidb/util/codec"
+	"github.com/pingcap/tidb/util/rowcodec"
+
+	"github.com/pingcap/br/pkg/metautil"
+	"github.com/pingcap/br/pkg/restore"
+	"github.com/pingcap/br/pkg/storage"
+)
+
+var _ = Suite(&testPeekSuite{})
+
+type testPeekSuite struct{}
+
+type peekKV struct {
+	key   []byte
+	ts    uint64
+	value []byte
+}
+
+func writePeekSST(c *C, path string, kvs []peekKV) {
+	f, err := os.Create(path)
+	c.Assert(err, IsNil)
+	w := sstable.NewWriter(f, sstable.WriterOptions{})
+	for _, pair := range kvs {
+		key := append([]byte{'z'}, codec.EncodeBytes(nil, pair.key)...)
+		key = codec.EncodeUintDesc(key, pair.ts)
+		c.Assert(w.Set(key, pair.value), IsNil)
+	}
+	c.Assert(w.Close(), IsNil)
+}
+
+func putRecord(startTS uint64, shortValue []byte) []byte {
+	var buf [binary.MaxVarintLen64]byte
+	value := append([]byte{'P'}, buf[:binary.PutUvarint(buf[:], startTS)]...)
+	if shortValue != nil {
+		value = append(value, 'v', byte(len(shortValue)))
+		value = append(value, shortValue...)
+	}
+	return value
+}
+
+func (s *testPeekSuite) TestPeekTableRows(c *C) {
+	ctx := context.Background()
+	dir := c.MkDir()
+	store, err := storage.NewLocalStorage(dir)
+	c.Assert(err, IsNil)
+
+	id := &model.ColumnInfo{ID: 1, Name: model.NewCIStr("id"), Offset: 0, State: model.StatePublic}
+	id.FieldType = *types.NewFieldType(mysql.TypeLonglong)
+	id.Flag = mysql.PriKeyFlag
+	name := &model.ColumnInfo{ID: 2, Name: model.NewCIStr("name"), Offset: 1, State: model.StatePublic}
+	name.FieldType = *types.NewFieldType(mysql.TypeVarchar)
+	tableInfo := &model.TableInfo{
+		ID:         100,
+		Name:       model.NewCIStr("t"),
+		Columns:    []*model.ColumnInfo{id, name},
+		PKIsHandle: true,
+	}
+
+	encode := func(v interface{}) []byte {
+		value, err := tablecodec.EncodeRow(&stmtctx.StatementContext{}, []types.Datum{types.NewDatum(v)},
+			[]int64{name.ID}, nil, nil, &rowcodec.Encoder{Enable: true})
+		c.Assert(err, IsNil)
+		return value
+	}
+	long := strings.Repeat("x", 300)
+	key1 := tablecodec.EncodeRowKeyWithHandle(100, kv.IntHandle(1))
+	key2 := tablecodec.EncodeRowKeyWithHandle(100, kv.IntHandle(2))
+	key3 := tablecodec.EncodeRowKeyWithHandle(100, kv.IntHandle(3))
+	writePeekSST(c, filepath.Join(dir, "1_write.sst"), []peekKV{
+		{key: key1, ts: 20, value: putRecord(10, encode("a"))},
+		{key: key2, ts: 20, value: putRecord(10, nil)},
+		{key: key3, ts: 20, value: putRecord(10, encode(nil))},
+	})
+	writePeekSST(c, filepath.Join(dir, "1_default.sst"), []peekKV{
+		{key: key2, ts: 10, value: encode(long)},
+	})
+	table := &metautil.Table{
+		Info: tableInfo,
+		Files: []*backuppb.File{
+			{Name: "1_write.sst", Cf: "write", StartKey: key1},
+			{Name: "1_default.sst", Cf: "default", StartKey: key1},
+		},
+	}
+
+	result, err := restore.PeekTableRows(ctx, store, table, 10)
+	c.Assert(err, IsNil)
+	c.Assert(result.Columns, DeepEquals, []string{"id", "name"})
+	c.Assert(result.Rows, HasLen, 3)
+	c.Assert(*result.Rows[0].Values[0], Equals, "1")
+	c.Assert(*result.Rows[0].Values[1], Equals, "a")
+	c.Assert(*result.Rows[1].Values[1], Equals, long)
+	c.Assert(result.Rows[2].Values[1], IsNil)
+
+	result, err = restore.PeekTableRows(ctx, store, table, 1)
+	c.Assert(err, IsNil)
+	c.Assert(result.Rows, HasLen, 1)
+	c.Assert(result.Rows[0].Handle, Equals, "1")
+}
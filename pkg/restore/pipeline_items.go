@@ -243,6 +243,10 @@ func (b *tikvSender) splitWorker(ctx context.Context, ranges <-chan DrainResult,
 }
 
 func (b *tikvSender) restoreWorker(ctx context.Context, ranges <-chan DrainResult) {
+	if b.client.granularity == GranularityRegion {
+		b.restoreRegionWorker(ctx, ranges)
+		return
+	}
 	defer func() {
 		log.Debug("restore worker closed")
 		b.wg.Done()
@@ -0,0 +1,248 @@
+// Copyright 2021 PingCAP, Inc. Licensed under Apache-2.0.
+
+package restore
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/pingcap/errors"
+	"github.com/pingcap/log"
+	"github.com/pingcap/tidb/domain"
+	"github.com/pingcap/tidb/tablecodec"
+	"github.com/tikv/pd/server/schedule/placement"
+	"go.uber.org/zap"
+
+	berrors "github.com/pingcap/br/pkg/errors"
+	"github.com/pingcap/br/pkg/metautil"
+	"github.com/pingcap/br/pkg/pdutil"
+	"github.com/pingcap/br/pkg/utils"
+)
+
+type placementLabel struct {
+	key   string
+	value string
+}
+
+// PlacementLabelMapping maps the labels used by the placement rules of the
+// backup cluster to the labels of the restore cluster, e.g. the zones of the
+// two clusters are named differently.
+type PlacementLabelMapping struct {
+	labels map[placementLabel]placementLabel
+	keys   map[string]string
+}
+
+// ParsePlacementLabelMapping parses the entries of the mapping. An entry is
+// either `key=value:new-key=new-value` mapping a label, or `key:new-key`
+// renaming the key of all labels, the former takes precedence.
+func ParsePlacementLabelMapping(entries []string) (*PlacementLabelMapping, error) {
+	mapping := &PlacementLabelMapping{
+		labels: make(map[placementLabel]placementLabel),
+		keys:   make(map[string]string),
+	}
+	for _, entry := range entries {
+		parts := strings.Split(entry, ":")
+		if len(parts) != 2 {
+			return nil, errors.Annotatef(berrors.ErrInvalidArgument,
+				"invalid placement label mapping %q, should be key=value:new-key=new-value or key:new-key", entry)
+		}
+		from := strings.Split(parts[0], "=")
+		to := strings.Split(parts[1], "=")
+		switch {
+		case len(from) == 1 && len(to) == 1 && from[0] != "" && to[0] != "":
+			mapping.keys[from[0]] = to[0]
+		case len(from) == 2 && len(to) == 2 && from[0] != "" && to[0] != "":
+			mapping.labels[placementLabel{key: from[0], value: from[1]}] = placementLabel{key: to[0], value: to[1]}
+		default:
+			return nil, errors.Annotatef(berrors.ErrInvalidArgument,
+				"invalid placement label mapping %q, should be key=value:new-key=new-value or key:new-key", entry)
+		}
+	}
+	return mapping, nil
+}
+
+func (m *PlacementLabelMapping) mapKey(key string) string {
+	if m != nil {
+		if newKey, ok := m.keys[key]; ok {
+			return newKey
+		}
+	}
+	return key
+}
+
+func (m *PlacementLabelMapping) mapLabel(key, value string) placementLabel {
+	if m != nil {
+		if label, ok := m.labels[placementLabel{key: key, value: value}]; ok {
+			return label
+		}
+	}
+	return placementLabel{key: m.mapKey(key), value: value}
+}
+
+// mapConstraint maps the key and the values of the label constraint, all the
+// values must be mapped to the same key.
+func (m *PlacementLabelMapping) mapConstraint(c placement.LabelConstraint) (placement.LabelConstraint, error) {
+	mapped := placement.LabelConstraint{Key: m.mapKey(c.Key), Op: c.Op}
+	for i, value := range c.Values {
+		label := m.mapLabel(c.Key, value)
+		if i == 0 {
+			mapped.Key = label.key
+		} else if label.key != mapped.Key {
+			return mapped, errors.Annotatef(berrors.ErrInvalidArgument,
+				"the values of the label constraint %s are mapped to both %s and %s", c.Key, mapped.Key, label.key)
+		}
+		mapped.Values = append(mapped.Values, label.value)
+	}
+	return mapped, nil
+}
+
+// rewriteRuleKey rewrites the start or end key of the placement rule of the
+// old table to the new table.
+func rewriteRuleKey(keyHex string, oldID, newID int64) (string, error) {
+	if keyHex == "" {
+		return keyHex, nil
+	}
+	key, err := pdutil.DecodePlacementRuleKey(keyHex)
+	if err != nil {
+		return "", errors.Trace(err)
+	}
+	prefix := tablecodec.GenTablePrefix(oldID)
+	switch {
+	case bytes.HasPrefix(key, prefix):
+		return pdutil.EncodePlacementRuleKey(append(tablecodec.GenTablePrefix(newID), key[len(prefix):]...)), nil
+	case bytes.Equal(key, tablecodec.GenTablePrefix(oldID+1)):
+		// the end key of the rule of the whole table.
+		return pdutil.EncodePlacementRuleKey(tablecodec.GenTablePrefix(newID + 1)), nil
+	default:
+		return "", errors.Annotatef(berrors.ErrRestoreInvalidRewrite,
+			"the key %s of the placement rule doesn't belong to table %d", keyHex, oldID)
+	}
+}
+
+// rewritePlacementRule rewrites the placement rule of the old table to the new
+// table, the IDs of the table in the group and the ID of the rule are
+// replaced too, so the rule doesn't override the one of the old table.
+func rewritePlacementRule(
+	rule placement.Rule, oldID, newID int64, mapping *PlacementLabelMapping,
+) (placement.Rule, error) {
+	var err error
+	if rule.StartKeyHex, err = rewriteRuleKey(rule.StartKeyHex, oldID, newID); err != nil {
+		return rule, errors.Trace(err)
+	}
+	if rule.EndKeyHex, err = rewriteRuleKey(rule.EndKeyHex, oldID, newID); err != nil {
+		return rule, errors.Trace(err)
+	}
+
+	oldStr, newStr := strconv.FormatInt(oldID, 10), strconv.FormatInt(newID, 10)
+	rule.GroupID = strings.ReplaceAll(rule.GroupID, oldStr, newStr)
+	if strings.Contains(rule.ID, oldStr) {
+		rule.ID = strings.ReplaceAll(rule.ID, oldStr, newStr)
+	} else {
+		rule.ID = fmt.Sprintf("%s_%s", rule.ID, newStr)
+	}
+
+	constraints := make([]placement.LabelConstraint, 0, len(rule.LabelConstraints))
+	for _, c := range rule.LabelConstraints {
+		mapped, err := mapping.mapConstraint(c)
+		if err != nil {
+			return rule, errors.Trace(err)
+		}
+		constraints = append(constraints, mapped)
+	}
+	rule.LabelConstraints = constraints
+	locationLabels := make([]string, 0, len(rule.LocationLabels))
+	for _, label := range rule.LocationLabels {
+		locationLabels = append(locationLabels, mapping.mapKey(label))
+	}
+	rule.LocationLabels = locationLabels
+	return rule, nil
+}
+
+// RewritePlacementRules rewrites the placement rules of the backup tables to
+// the restored tables. The tableIDs map the IDs of the backup tables and
+// their partitions to the restored ones, and the rules of the tables not
+// restored are skipped.
+func RewritePlacementRules(
+	tables []metautil.TablePlacementRules,
+	tableIDs map[int64]int64,
+	mapping *PlacementLabelMapping,
+) ([]placement.Rule, error) {
+	var rules []placement.Rule
+	for _, table := range tables {
+		if _, ok := tableIDs[table.TableID]; !ok {
+			log.Info("the table isn't restored, skip its placement rules",
+				zap.String("table", utils.EncloseDBAndTable(table.DB, table.Table)))
+			continue
+		}
+		for _, rule := range table.Rules {
+			oldID := pdutil.PlacementRuleTableID(&rule)
+			newID, ok := tableIDs[oldID]
+			if !ok {
+				log.Warn("the partition of the placement rule isn't restored, skip it",
+					zap.String("table", utils.EncloseDBAndTable(table.DB, table.Table)),
+					zap.String("group", rule.GroupID), zap.String("rule", rule.ID))
+				continue
+			}
+			rewritten, err := rewritePlacementRule(rule, oldID, newID, mapping)
+			if err != nil {
+				return nil, errors.Annotatef(err, "rewrite the placement rule %s/%s of %s",
+					rule.GroupID, rule.ID, utils.EncloseDBAndTable(table.DB, table.Table))
+			}
+			rules = append(rules, rewritten)
+		}
+	}
+	return rules, nil
+}
+
+// RestorePlacementRules re-applies the placement rules of the backup tables
+// to the restored tables.
+func (rc *Client) RestorePlacementRules(
+	ctx context.Context,
+	dom *domain.Domain,
+	pdAddrs []string,
+	tables []*metautil.Table,
+	tableRules []metautil.TablePlacementRules,
+	mapping *PlacementLabelMapping,
+) error {
+	tableIDs := make(map[int64]int64, len(tables))
+	for _, table := range tables {
+		newTable, err := rc.GetTableSchema(dom, table.DB.Name, table.Info.Name)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		tableIDs[table.Info.ID] = newTable.ID
+		oldPartitions, newPartitions := table.Info.GetPartitionInfo(), newTable.GetPartitionInfo()
+		if oldPartitions == nil || newPartitions == nil {
+			continue
+		}
+		// the partitions are matched by their names.
+		for _, oldDef := range oldPartitions.Definitions {
+			for _, newDef := range newPartitions.Definitions {
+				if oldDef.Name.L == newDef.Name.L {
+					tableIDs[oldDef.ID] = newDef.ID
+				}
+			}
+		}
+	}
+	rules, err := RewritePlacementRules(tableRules, tableIDs, mapping)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	for i := range rules {
+		rule := &rules[i]
+		j := 0
+		err = utils.WithRetry(ctx, func() error {
+			pdAddr := pdAddrs[j%len(pdAddrs)]
+			j++
+			return errors.Trace(pdutil.SetPlacementRule(ctx, pdAddr, rule, rc.tlsConf))
+		}, newPDReqBackoffer())
+		if err != nil {
+			return errors.Annotatef(err, "set the placement rule %s/%s", rule.GroupID, rule.ID)
+		}
+		log.Info("placement rule restored", zap.String("group", rule.GroupID), zap.String("rule", rule.ID))
+	}
+	return nil
+}
@@ -0,0 +1,86 @@
+// Copyright 2021 PingCAP, Inc. Licensed under Apache-2.0.
+
+package restore_test
+
+import (
+	. "github.com/pingcap/check"
+	"github.com/pingcap/tidb/tablecodec"
+	"github.com/tikv/pd/server/schedule/placement"
+
+	"github.com/pingcap/br/pkg/metautil"
+	"github.com/pingcap/br/pkg/pdutil"
+	"github.com/pingcap/br/pkg/restore"
+)
+
+var _ = Suite(&testPlacementSuite{})
+
+type testPlacementSuite struct{}
+
+func tablePlacementRule(tableID int64, id string, constraints ...placement.LabelConstraint) placement.Rule {
+	return placement.Rule{
+		GroupID:          "TiDB_DDL_42",
+		ID:               id,
+		StartKeyHex:      pdutil.EncodePlacementRuleKey(tablecodec.GenTablePrefix(tableID)),
+		EndKeyHex:        pdutil.EncodePlacementRuleKey(tablecodec.GenTablePrefix(tableID + 1)),
+		Role:             placement.Voter,
+		Count:            3,
+		LabelConstraints: constraints,
+		LocationLabels:   []string{"zone", "host"},
+	}
+}
+
+func (s *testPlacementSuite) TestParsePlacementLabelMapping(c *C) {
+	_, err := restore.ParsePlacementLabelMapping([]string{"zone=bj:zone=sh"})
+	c.Assert(err, IsNil)
+	for _, entry := range []string{"zone", "zone=bj:zone", "zone:zone=sh", ":zone", "zone=bj:zone=sh:x"} {
+		_, err = restore.ParsePlacementLabelMapping([]string{entry})
+		c.Assert(err, ErrorMatches, ".*invalid placement label mapping.*")
+	}
+}
+
+func (s *testPlacementSuite) TestRewritePlacementRules(c *C) {
+	tables := []metautil.TablePlacementRules{{
+		DB:      "test",
+		Table:   "t",
+		TableID: 42,
+		Rules: []placement.Rule{
+			tablePlacementRule(42, "42_voter",
+				placement.LabelConstraint{Key: "zone", Op: placement.In, Values: []string{"bj", "sz"}}),
+			// the partition isn't restored.
+			tablePlacementRule(43, "43_voter"),
+			tablePlacementRule(44, "voter",
+				placement.LabelConstraint{Key: "disk", Op: placement.NotIn, Values: []string{"hdd"}}),
+		},
+	}, {
+		// the table isn't restored.
+		DB:      "test",
+		Table:   "t2",
+		TableID: 50,
+		Rules:   []placement.Rule{tablePlacementRule(50, "50_voter")},
+	}}
+	tableIDs := map[int64]int64{42: 142, 44: 144}
+	mapping, err := restore.ParsePlacementLabelMapping([]string{"zone=bj:region=east", "zone=sz:region=south", "zone:region"})
+	c.Assert(err, IsNil)
+
+	rules, err := restore.RewritePlacementRules(tables, tableIDs, mapping)
+	c.Assert(err, IsNil)
+	expected0 := tablePlacementRule(142, "142_voter",
+		placement.LabelConstraint{Key: "region", Op: placement.In, Values: []string{"east", "south"}})
+	expected0.GroupID = "TiDB_DDL_142"
+	expected0.LocationLabels = []string{"region", "host"}
+	expected1 := tablePlacementRule(144, "voter_144",
+		placement.LabelConstraint{Key: "disk", Op: placement.NotIn, Values: []string{"hdd"}})
+	expected1.LocationLabels = []string{"region", "host"}
+	c.Assert(rules, DeepEquals, []placement.Rule{expected0, expected1})
+
+	// the values of a constraint are mapped to different keys.
+	mapping, err = restore.ParsePlacementLabelMapping([]string{"zone=bj:region=east"})
+	c.Assert(err, IsNil)
+	_, err = restore.RewritePlacementRules(tables, tableIDs, mapping)
+	c.Assert(err, ErrorMatches, ".*mapped to both.*")
+
+	// the key doesn't belong to the table.
+	tables[0].Rules[0].EndKeyHex = pdutil.EncodePlacementRuleKey(tablecodec.GenTablePrefix(100))
+	_, err = restore.RewritePlacementRules(tables, tableIDs, nil)
+	c.Assert(err, ErrorMatches, ".*doesn't belong to table 42.*")
+}
@@ -0,0 +1,113 @@
+// Copyright 2021 PingCAP, Inc. Licensed under Apache-2.0.
+
+package restore
+
+import (
+	"github.com/pingcap/errors"
+	backuppb "github.com/pingcap/kvproto/pkg/backup"
+
+	berrors "github.com/pingcap/br/pkg/errors"
+)
+
+// PlanOptions is the options of the simulated restore.
+type PlanOptions struct {
+	// SplitSizeBytes and SplitKeyCount are the thresholds of merging the small
+	// ranges, see MergeFileRanges.
+	SplitSizeBytes uint64
+	SplitKeyCount  uint64
+	// BatchSize is the number of the ranges in a batch of the batcher.
+	BatchSize int
+	// Stores is the number of the TiKV stores.
+	Stores int
+	// Replicas is the number of the replicas of a region.
+	Replicas int
+}
+
+// StoreLoad is the expected load of a store in the restore.
+type StoreLoad struct {
+	StoreID uint64
+	// Regions is the number of the peers of the new regions on the store.
+	Regions int
+	Leaders int
+	// Bytes and Kvs are the data downloaded and ingested by the store.
+	Bytes uint64
+	Kvs   uint64
+}
+
+// RestorePlan is the result of the simulated restore.
+type RestorePlan struct {
+	Tables int
+	Files  int
+	Bytes  uint64
+	Kvs    uint64
+	// Ranges is the number of the ranges before merging, one range per region
+	// of the backup cluster.
+	Ranges int
+	// Regions is the number of the new regions, one per merged range.
+	Regions int
+	// SplitKeys is the number of the keys to split at.
+	SplitKeys int
+	Batches   int
+	Stores    []StoreLoad
+}
+
+// PlanRestore simulates the restore of the files in memory without any
+// cluster. The files are grouped by tables in the restore order. It merges
+// the ranges by MergeFileRanges, assigns them to the batches like Batcher,
+// and scatters the new regions evenly to the stores, which is the ideal case
+// of the scatter.
+func PlanRestore(filesOfTables [][]*backuppb.File, opts PlanOptions) (*RestorePlan, error) {
+	if opts.BatchSize <= 0 || opts.Stores <= 0 || opts.Replicas <= 0 {
+		return nil, errors.Annotatef(berrors.ErrInvalidArgument,
+			"batch size %d, stores %d and replicas %d must be positive", opts.BatchSize, opts.Stores, opts.Replicas)
+	}
+	replicas := opts.Replicas
+	if replicas > opts.Stores {
+		replicas = opts.Stores
+	}
+	plan := &RestorePlan{Stores: make([]StoreLoad, opts.Stores)}
+	for i := range plan.Stores {
+		plan.Stores[i].StoreID = uint64(i + 1)
+	}
+
+	collected := 0
+	for _, files := range filesOfTables {
+		plan.Tables++
+		if len(files) == 0 {
+			continue
+		}
+		ranges, stat, err := MergeFileRanges(files, opts.SplitSizeBytes, opts.SplitKeyCount)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		plan.Files += stat.TotalFiles
+		plan.Ranges += stat.TotalRegions
+		plan.SplitKeys += len(ranges)
+
+		for i := range ranges {
+			bytes, kvs := ranges[i].BytesAndKeys()
+			plan.Bytes += bytes
+			plan.Kvs += kvs
+			region := plan.Regions
+			plan.Regions++
+			plan.Stores[region%opts.Stores].Leaders++
+			for r := 0; r < replicas; r++ {
+				store := &plan.Stores[(region+r)%opts.Stores]
+				store.Regions++
+				store.Bytes += bytes
+				store.Kvs += kvs
+			}
+
+			// the batcher sends a batch once it collects enough ranges.
+			collected++
+			if collected == opts.BatchSize {
+				plan.Batches++
+				collected = 0
+			}
+		}
+	}
+	if collected > 0 {
+		plan.Batches++
+	}
+	return plan, nil
+}
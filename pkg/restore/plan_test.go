@@ -0,0 +1,54 @@
+// Copyright 2021 PingCAP, Inc. Licensed under Apache-2.0.
+
+package restore_test
+
+import (
+	"github.com/docker/go-units"
+	. "github.com/pingcap/check"
+	backuppb "github.com/pingcap/kvproto/pkg/backup"
+
+	"github.com/pingcap/br/pkg/restore"
+)
+
+var _ = Suite(&testPlanSuite{})
+
+type testPlanSuite struct{}
+
+func (s *testPlanSuite) TestPlanRestore(c *C) {
+	fb := fileBulder{}
+	// the small files of table 1 are merged into a range.
+	var table1, table2 []*backuppb.File
+	for i := 0; i < 3; i++ {
+		table1 = append(table1, fb.build(1, 0, 1, 1, 1)...)
+	}
+	for i := 0; i < 4; i++ {
+		table2 = append(table2, fb.build(2, 0, 1, 60*units.MiB, 1)...)
+	}
+
+	opts := restore.PlanOptions{
+		SplitSizeBytes: restore.DefaultMergeRegionSizeBytes,
+		SplitKeyCount:  restore.DefaultMergeRegionKeyCount,
+		BatchSize:      2,
+		Stores:         4,
+		Replicas:       3,
+	}
+	plan, err := restore.PlanRestore([][]*backuppb.File{table1, nil, table2}, opts)
+	c.Assert(err, IsNil)
+	c.Assert(plan.Tables, Equals, 3)
+	c.Assert(plan.Files, Equals, 7)
+	c.Assert(plan.Ranges, Equals, 7)
+	c.Assert(plan.Regions, Equals, 5)
+	c.Assert(plan.SplitKeys, Equals, 5)
+	c.Assert(plan.Batches, Equals, 3)
+	c.Assert(plan.Bytes, Equals, uint64(3+4*60*units.MiB))
+	c.Assert(plan.Stores, DeepEquals, []restore.StoreLoad{
+		{StoreID: 1, Regions: 4, Leaders: 2, Bytes: 3 + 3*60*units.MiB, Kvs: 6},
+		{StoreID: 2, Regions: 4, Leaders: 1, Bytes: 3 + 3*60*units.MiB, Kvs: 6},
+		{StoreID: 3, Regions: 4, Leaders: 1, Bytes: 3 + 3*60*units.MiB, Kvs: 6},
+		{StoreID: 4, Regions: 3, Leaders: 1, Bytes: 3 * 60 * units.MiB, Kvs: 3},
+	})
+
+	opts.Replicas = 0
+	_, err = restore.PlanRestore(nil, opts)
+	c.Assert(err, ErrorMatches, ".*must be positive.*")
+}
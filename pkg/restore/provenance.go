@@ -0,0 +1,129 @@
+// Copyright 2021 PingCAP, Inc. Licensed under Apache-2.0.
+
+package restore
+
+import (
+	"encoding/json"
+	"strings"
+
+	"github.com/pingcap/errors"
+	"github.com/pingcap/log"
+	"github.com/pingcap/parser/model"
+	"go.uber.org/zap"
+
+	berrors "github.com/pingcap/br/pkg/errors"
+	"github.com/pingcap/br/pkg/metautil"
+	"github.com/pingcap/br/pkg/utils"
+)
+
+const (
+	// provenanceMarker starts the provenance appended to the table comments.
+	provenanceMarker = " br-restore:"
+	// maxTableCommentLength is the max length of the table comments of TiDB.
+	maxTableCommentLength = 2048
+)
+
+// Provenance is the metadata of the restore job which restored a table. It's
+// recorded in the comment of the restored table, so the origin of the data
+// can be traced by `br restore provenance` later.
+type Provenance struct {
+	JobID      string `json:"job"`
+	BackupTS   uint64 `json:"backup-ts"`
+	ClusterID  uint64 `json:"cluster-id"`
+	Version    string `json:"br"`
+	RestoredAt string `json:"restored-at"`
+	// Inherited is the provenance of the backed up table, i.e. the table was
+	// restored by another job before the backup.
+	Inherited *Provenance `json:"inherited,omitempty"`
+}
+
+// TableProvenance is the provenance of a table in the cluster.
+type TableProvenance struct {
+	Schema     string
+	Table      string
+	Provenance *Provenance
+}
+
+// SplitProvenance splits the table comment into the user comment and the
+// provenance, which is nil if the comment has no provenance.
+func SplitProvenance(comment string) (string, *Provenance, error) {
+	i := strings.LastIndex(comment, provenanceMarker)
+	if i < 0 {
+		return comment, nil, nil
+	}
+	p := new(Provenance)
+	if err := json.Unmarshal([]byte(comment[i+len(provenanceMarker):]), p); err != nil {
+		return comment, nil, errors.Annotatef(berrors.ErrInvalidArgument, "invalid provenance in comment %q: %v", comment, err)
+	}
+	return comment[:i], p, nil
+}
+
+// tagComment appends the provenance to the table comment. The provenance in
+// the comment is moved into the Inherited field. The inherited provenance is
+// dropped if the comment is too long, and false is returned if it's still too
+// long.
+func (p *Provenance) tagComment(comment string) (string, bool) {
+	userComment, inherited, err := SplitProvenance(comment)
+	if err != nil {
+		// the comment happens to contain the marker, keep it as is.
+		userComment, inherited = comment, nil
+	}
+	tagged := *p
+	tagged.Inherited = inherited
+	for {
+		data, err := json.Marshal(&tagged)
+		if err != nil {
+			return comment, false
+		}
+		if result := userComment + provenanceMarker + string(data); len(result) <= maxTableCommentLength {
+			return result, true
+		}
+		if tagged.Inherited == nil {
+			return comment, false
+		}
+		tagged.Inherited = nil
+	}
+}
+
+// TagProvenance records the provenance into the comments of the tables. The
+// tables of the system databases aren't tagged.
+func TagProvenance(tables []*metautil.Table, p *Provenance) {
+	for _, table := range tables {
+		if _, ok := utils.GetSysDBName(table.DB.Name); ok || utils.IsSysDB(table.DB.Name.L) {
+			continue
+		}
+		comment, ok := p.tagComment(table.Info.Comment)
+		if !ok {
+			log.Warn("the table comment is too long to record the restore provenance",
+				zap.Stringer("db", table.DB.Name), zap.Stringer("table", table.Info.Name))
+			continue
+		}
+		table.Info.Comment = comment
+	}
+}
+
+// ListProvenance returns the provenance of the tables matched by the filter
+// function. The tables without provenance are skipped.
+func ListProvenance(dbs []*model.DBInfo, match func(schema, table string) bool) []TableProvenance {
+	var result []TableProvenance
+	for _, db := range dbs {
+		if utils.IsSysDB(db.Name.L) {
+			continue
+		}
+		for _, table := range db.Tables {
+			if !match(db.Name.O, table.Name.O) {
+				continue
+			}
+			_, p, err := SplitProvenance(table.Comment)
+			if err != nil {
+				log.Warn("skip the table with invalid provenance", zap.Stringer("db", db.Name),
+					zap.Stringer("table", table.Name), zap.Error(err))
+				continue
+			}
+			if p != nil {
+				result = append(result, TableProvenance{Schema: db.Name.O, Table: table.Name.O, Provenance: p})
+			}
+		}
+	}
+	return result
+}
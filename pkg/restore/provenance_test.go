@@ -0,0 +1,52 @@
+// Copyright 2021 PingCAP, Inc. Licensed under Apache-2.0.
+
+package restore_test
+
+import (
+	"strings"
+
+	. "github.com/pingcap/check"
+	"github.com/pingcap/parser/model"
+
+	"github.com/pingcap/br/pkg/metautil"
+	"github.com/pingcap/br/pkg/restore"
+)
+
+var _ = Suite(&testProvenanceSuite{})
+
+type testProvenanceSuite struct{}
+
+func (s *testProvenanceSuite) TestProvenance(c *C) {
+	db := &model.DBInfo{Name: model.NewCIStr("test")}
+	sys := &model.DBInfo{Name: model.NewCIStr("__TiDB_BR_Temporary_mysql")}
+	tables := []*metautil.Table{
+		{DB: db, Info: &model.TableInfo{Name: model.NewCIStr("t1"), Comment: "user comment"}},
+		{DB: db, Info: &model.TableInfo{Name: model.NewCIStr("t2"), Comment: strings.Repeat("x", 2000)}},
+		{DB: sys, Info: &model.TableInfo{Name: model.NewCIStr("user")}},
+	}
+	first := &restore.Provenance{JobID: "job1", BackupTS: 1, Version: "v1"}
+	restore.TagProvenance(tables, first)
+
+	comment, p, err := restore.SplitProvenance(tables[0].Info.Comment)
+	c.Assert(err, IsNil)
+	c.Assert(comment, Equals, "user comment")
+	c.Assert(p, DeepEquals, first)
+	// the comments too long and the system tables aren't tagged.
+	c.Assert(tables[1].Info.Comment, HasLen, 2000)
+	c.Assert(tables[2].Info.Comment, Equals, "")
+
+	// the provenance of the backed up table is inherited.
+	second := &restore.Provenance{JobID: "job2", BackupTS: 2, Version: "v2"}
+	restore.TagProvenance(tables[:1], second)
+	db.Tables = []*model.TableInfo{tables[0].Info, tables[1].Info}
+	list := restore.ListProvenance([]*model.DBInfo{db}, func(string, string) bool { return true })
+	c.Assert(list, HasLen, 1)
+	c.Assert(list[0].Table, Equals, "t1")
+	c.Assert(list[0].Provenance.JobID, Equals, "job2")
+	c.Assert(list[0].Provenance.Inherited, DeepEquals, first)
+
+	comment, p, err = restore.SplitProvenance("no provenance")
+	c.Assert(err, IsNil)
+	c.Assert(comment, Equals, "no provenance")
+	c.Assert(p, IsNil)
+}
@@ -9,13 +9,13 @@ import (
 
 	"github.com/pingcap/errors"
 	"github.com/pingcap/kvproto/pkg/import_sstpb"
-	"github.com/pingcap/kvproto/pkg/metapb"
 	"github.com/pingcap/log"
 	"github.com/pingcap/tidb/tablecodec"
 	"go.uber.org/zap"
 
 	berrors "github.com/pingcap/br/pkg/errors"
 	"github.com/pingcap/br/pkg/logutil"
+	"github.com/pingcap/br/pkg/regionutil"
 	"github.com/pingcap/br/pkg/rtree"
 )
 
@@ -105,18 +105,7 @@ func SortRanges(ranges []rtree.Range, rewriteRules *RewriteRules) ([]rtree.Range
 }
 
 // RegionInfo includes a region and the leader of the region.
-type RegionInfo struct {
-	Region *metapb.Region
-	Leader *metapb.Peer
-}
-
-// ContainsInterior returns whether the region contains the given key, and also
-// that the key does not fall on the boundary (start key) of the region.
-func (region *RegionInfo) ContainsInterior(key []byte) bool {
-	return bytes.Compare(key, region.Region.GetStartKey()) > 0 &&
-		(len(region.Region.GetEndKey()) == 0 ||
-			bytes.Compare(key, region.Region.GetEndKey()) < 0)
-}
+type RegionInfo = regionutil.RegionInfo
 
 // RewriteRules contains rules for rewriting keys of tables.
 type RewriteRules struct {
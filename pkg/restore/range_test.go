@@ -83,3 +83,38 @@ func (s *testRangeSuite) TestSortRange(c *C) {
 		{StartKey: []byte("xxe"), EndKey: []byte("xxz"), Files: nil},
 	})
 }
+
+func (s *testRangeSuite) TestTopologyRanges(c *C) {
+	rewriteRules := &restore.RewriteRules{
+		Data: []*import_sstpb.RewriteRule{
+			{OldKeyPrefix: tablecodec.GenTableRecordPrefix(1), NewKeyPrefix: tablecodec.GenTableRecordPrefix(4)},
+			{OldKeyPrefix: tablecodec.GenTableRecordPrefix(2), NewKeyPrefix: tablecodec.GenTableRecordPrefix(5)},
+		},
+	}
+	key := func(tableID int64, suffix string) []byte {
+		return append(tablecodec.GenTableRecordPrefix(tableID), suffix...)
+	}
+	boundaries := [][]byte{
+		key(1, "aaa"),
+		key(1, "bbb"),
+		tablecodec.GenTableRecordPrefix(2),
+		key(2, "ccc"),
+		// no rewrite rule for table 3.
+		key(3, "ddd"),
+	}
+	ranges := restore.TopologyRanges(boundaries, rewriteRules)
+	c.Assert(ranges, RangeEquals, []rtree.Range{
+		{StartKey: tablecodec.GenTableRecordPrefix(1), EndKey: key(1, "aaa")},
+		{StartKey: key(1, "aaa"), EndKey: key(1, "bbb")},
+		{StartKey: tablecodec.GenTableRecordPrefix(2), EndKey: key(2, "ccc")},
+	})
+
+	// the ranges can be rewritten by SortRanges.
+	sorted, err := restore.SortRanges(ranges, rewriteRules)
+	c.Assert(err, IsNil)
+	c.Assert(sorted, RangeEquals, []rtree.Range{
+		{StartKey: tablecodec.GenTableRecordPrefix(4), EndKey: key(4, "aaa")},
+		{StartKey: key(4, "aaa"), EndKey: key(4, "bbb")},
+		{StartKey: tablecodec.GenTableRecordPrefix(5), EndKey: key(5, "ccc")},
+	})
+}
@@ -0,0 +1,182 @@
+// Copyright 2021 PingCAP, Inc. Licensed under Apache-2.0.
+
+package restore
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/pingcap/errors"
+	"github.com/pingcap/log"
+	"github.com/pingcap/parser/model"
+	"github.com/pingcap/parser/types"
+	"go.uber.org/zap"
+
+	berrors "github.com/pingcap/br/pkg/errors"
+	"github.com/pingcap/br/pkg/metautil"
+	"github.com/pingcap/br/pkg/summary"
+	"github.com/pingcap/br/pkg/utils"
+)
+
+// SetSkippedIndexes sets the indexes whose data isn't in the backup. The
+// tables are created without them, and they're rebuilt by GoRebuildIndexes
+// after the data is restored.
+func (rc *Client) SetSkippedIndexes(tables []metautil.TableSkippedIndexes) {
+	rc.skippedIndexes = make(map[string][]string, len(tables))
+	for _, table := range tables {
+		key := utils.EncloseDBAndTable(strings.ToLower(table.DB), strings.ToLower(table.Table))
+		rc.skippedIndexes[key] = table.Indexes
+	}
+}
+
+func (rc *Client) tableSkippedIndexes(table *metautil.Table) []string {
+	return rc.skippedIndexes[utils.EncloseDBAndTable(table.DB.Name.L, table.Info.Name.L)]
+}
+
+// withoutSkippedIndexes returns the table to create without the skipped
+// indexes, the table of the backup is kept for the checksum.
+func (rc *Client) withoutSkippedIndexes(table *metautil.Table) *metautil.Table {
+	skipped := rc.tableSkippedIndexes(table)
+	if len(skipped) == 0 {
+		return table
+	}
+	info := table.Info.Clone()
+	info.Indices = info.Indices[:0]
+	for _, index := range table.Info.Indices {
+		if !containsName(skipped, index.Name.O) {
+			info.Indices = append(info.Indices, index)
+		}
+	}
+	created := *table
+	created.Info = info
+	return &created
+}
+
+func containsName(names []string, name string) bool {
+	for _, n := range names {
+		if strings.EqualFold(n, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// RebuildIndexSQLs returns the statements adding the skipped indexes of the
+// table of the backup to the restored table. The indexes of the same names in
+// the restored table have no data, so they're dropped first.
+func RebuildIndexSQLs(db string, oldTable, newTable *model.TableInfo, indexes []string) ([]string, error) {
+	table := utils.EncloseDBAndTable(db, newTable.Name.O)
+	var sqls []string
+	for _, name := range indexes {
+		index := oldTable.FindIndexByName(strings.ToLower(name))
+		if index == nil {
+			return nil, errors.Annotatef(berrors.ErrRestoreSchemaNotExists,
+				"skipped index %s not found in table %s", name, table)
+		}
+		if newTable.FindIndexByName(index.Name.L) != nil {
+			sqls = append(sqls, fmt.Sprintf("ALTER TABLE %s DROP INDEX %s", table, utils.EncloseName(index.Name.O)))
+		}
+		sqls = append(sqls, fmt.Sprintf("ALTER TABLE %s ADD %s", table, indexDefinition(index)))
+	}
+	return sqls, nil
+}
+
+// indexDefinition returns the definition of the index in ADD INDEX.
+func indexDefinition(index *model.IndexInfo) string {
+	var b strings.Builder
+	if index.Unique {
+		b.WriteString("UNIQUE ")
+	}
+	b.WriteString("INDEX ")
+	b.WriteString(utils.EncloseName(index.Name.O))
+	b.WriteString("(")
+	for i, col := range index.Columns {
+		if i > 0 {
+			b.WriteString(", ")
+		}
+		b.WriteString(utils.EncloseName(col.Name.O))
+		if col.Length != types.UnspecifiedLength {
+			fmt.Fprintf(&b, "(%d)", col.Length)
+		}
+	}
+	b.WriteString(")")
+	if index.Comment != "" {
+		fmt.Fprintf(&b, " COMMENT '%s'", strings.NewReplacer(`\`, `\\`, `'`, `''`).Replace(index.Comment))
+	}
+	if index.Invisible {
+		b.WriteString(" INVISIBLE")
+	}
+	return b.String()
+}
+
+// GoRebuildIndexes adds the skipped indexes to the restored tables before
+// they're sent to the next stage, e.g. the checksum which covers the data of
+// the indexes. The indexes are added one by one, since the DDL owner runs the
+// jobs adding indexes in order anyway.
+func (rc *Client) GoRebuildIndexes(
+	ctx context.Context,
+	tableStream <-chan CreatedTable,
+	errCh chan<- error,
+) <-chan CreatedTable {
+	if len(rc.skippedIndexes) == 0 {
+		return tableStream
+	}
+	outCh := make(chan CreatedTable, cap(tableStream))
+	go func() {
+		defer close(outCh)
+		for {
+			select {
+			case <-ctx.Done():
+				errCh <- ctx.Err()
+				return
+			case tbl, ok := <-tableStream:
+				if !ok {
+					return
+				}
+				if !rc.tableCanceler.IsCanceled(tbl.OldTable) {
+					if err := rc.rebuildIndexes(ctx, &tbl); err != nil {
+						errCh <- err
+						return
+					}
+				}
+				outCh <- tbl
+			}
+		}
+	}()
+	return outCh
+}
+
+func (rc *Client) rebuildIndexes(ctx context.Context, tbl *CreatedTable) error {
+	skipped := rc.tableSkippedIndexes(tbl.OldTable)
+	if len(skipped) == 0 {
+		return nil
+	}
+	logger := log.With(
+		zap.String("db", tbl.OldTable.DB.Name.O),
+		zap.String("table", tbl.OldTable.Info.Name.O),
+	)
+	sqls, err := RebuildIndexSQLs(tbl.OldTable.DB.Name.O, tbl.OldTable.Info, tbl.Table, skipped)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	start := time.Now()
+	for _, sql := range sqls {
+		logger.Info("rebuild the index skipped by backup", zap.String("query", sql))
+		if err = rc.db.se.Execute(ctx, sql); err != nil {
+			logger.Error("failed to rebuild the index", zap.String("query", sql), zap.Error(err))
+			return errors.Trace(err)
+		}
+	}
+	// the checksum needs the IDs of the added indexes.
+	tbl.Table, err = rc.GetTableSchema(rc.dom, tbl.OldTable.DB.Name, tbl.Table.Name)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	elapsed := time.Since(start)
+	logger.Info("indexes rebuilt", zap.Strings("indexes", skipped), zap.Duration("take", elapsed))
+	summary.FromContext(ctx).CollectDuration("rebuild indexes", elapsed)
+	summary.FromContext(ctx).CollectInt("rebuilt indexes", len(skipped))
+	return nil
+}
@@ -0,0 +1,58 @@
+// Copyright 2021 PingCAP, Inc. Licensed under Apache-2.0.
+
+package restore_test
+
+import (
+	. "github.com/pingcap/check"
+	"github.com/pingcap/parser/model"
+	"github.com/pingcap/parser/types"
+
+	"github.com/pingcap/br/pkg/restore"
+)
+
+var _ = Suite(&testRebuildIndexSuite{})
+
+type testRebuildIndexSuite struct{}
+
+func (s *testRebuildIndexSuite) TestRebuildIndexSQLs(c *C) {
+	oldTable := &model.TableInfo{
+		Name: model.NewCIStr("t"),
+		Indices: []*model.IndexInfo{
+			{
+				ID:      1,
+				Name:    model.NewCIStr("idx_a"),
+				Columns: []*model.IndexColumn{{Name: model.NewCIStr("a"), Length: types.UnspecifiedLength}},
+			},
+			{
+				ID:     2,
+				Name:   model.NewCIStr("UK_b"),
+				Unique: true,
+				Columns: []*model.IndexColumn{
+					{Name: model.NewCIStr("b"), Length: 10},
+					{Name: model.NewCIStr("c`"), Length: types.UnspecifiedLength},
+				},
+				Comment:   "it's b",
+				Invisible: true,
+			},
+		},
+	}
+	newTable := &model.TableInfo{Name: model.NewCIStr("t")}
+	sqls, err := restore.RebuildIndexSQLs("test", oldTable, newTable, []string{"idx_a", "uk_b"})
+	c.Assert(err, IsNil)
+	c.Assert(sqls, DeepEquals, []string{
+		"ALTER TABLE `test`.`t` ADD INDEX `idx_a`(`a`)",
+		"ALTER TABLE `test`.`t` ADD UNIQUE INDEX `UK_b`(`b`(10), `c```) COMMENT 'it''s b' INVISIBLE",
+	})
+
+	// the existing index has no data.
+	newTable.Indices = []*model.IndexInfo{{ID: 5, Name: model.NewCIStr("idx_a")}}
+	sqls, err = restore.RebuildIndexSQLs("test", oldTable, newTable, []string{"idx_a"})
+	c.Assert(err, IsNil)
+	c.Assert(sqls, DeepEquals, []string{
+		"ALTER TABLE `test`.`t` DROP INDEX `idx_a`",
+		"ALTER TABLE `test`.`t` ADD INDEX `idx_a`(`a`)",
+	})
+
+	_, err = restore.RebuildIndexSQLs("test", oldTable, newTable, []string{"idx_x"})
+	c.Assert(err, ErrorMatches, ".*idx_x not found.*")
+}
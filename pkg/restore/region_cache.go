@@ -0,0 +1,152 @@
+// Copyright 2021 PingCAP, Inc. Licensed under Apache-2.0.
+
+package restore
+
+import (
+	"bytes"
+	"context"
+	"sort"
+	"sync"
+	"time"
+)
+
+// defaultRegionCacheTTL is how long the regions fed into the RegionCache are
+// served, the first splits and imports of the restore are expected to use
+// them in time.
+const defaultRegionCacheTTL = 5 * time.Minute
+
+// RegionCache is a SplitClient serving the scans of the regions by the regions
+// scanned by the warm up, so the first splits and imports of the ranges
+// needn't scan them from PD again. A scan is only served if the cached
+// regions cover its whole range, otherwise it's sent to PD.
+//
+// The cached regions are dropped once they expire, once they're split by this
+// client, or once invalidated by a failed import, so a stale region isn't used
+// again by the retries.
+type RegionCache struct {
+	SplitClient
+	ttl time.Duration
+
+	mu      sync.Mutex
+	expire  time.Time
+	regions []*RegionInfo // sorted by the start keys, not overlapped.
+}
+
+// NewRegionCache returns a RegionCache of the client, whose regions are served
+// for the ttl after being fed.
+func NewRegionCache(client SplitClient, ttl time.Duration) *RegionCache {
+	return &RegionCache{SplitClient: client, ttl: ttl}
+}
+
+// regionEndBefore returns whether the region ends before or at the key.
+func regionEndBefore(region *RegionInfo, key []byte) bool {
+	end := region.Region.GetEndKey()
+	return len(end) > 0 && bytes.Compare(end, key) <= 0
+}
+
+// overlapped returns whether the region overlaps [startKey, endKey).
+func overlapped(region *RegionInfo, startKey, endKey []byte) bool {
+	return !regionEndBefore(region, startKey) &&
+		(len(endKey) == 0 || bytes.Compare(region.Region.GetStartKey(), endKey) < 0)
+}
+
+// Feed caches the regions of a scan, which are sorted and contiguous,
+// replacing the cached regions overlapped with them.
+func (c *RegionCache) Feed(regions []*RegionInfo) {
+	if len(regions) == 0 {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.invalidateLocked(regions[0].Region.GetStartKey(), regions[len(regions)-1].Region.GetEndKey())
+	for _, region := range regions {
+		c.regions = append(c.regions, cloneRegionInfo(region))
+	}
+	sort.Slice(c.regions, func(i, j int) bool {
+		return bytes.Compare(c.regions[i].Region.GetStartKey(), c.regions[j].Region.GetStartKey()) < 0
+	})
+	c.expire = time.Now().Add(c.ttl)
+}
+
+// Invalidate drops the cached regions overlapped with [startKey, endKey).
+func (c *RegionCache) Invalidate(startKey, endKey []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.invalidateLocked(startKey, endKey)
+}
+
+func (c *RegionCache) invalidateLocked(startKey, endKey []byte) {
+	kept := c.regions[:0]
+	for _, region := range c.regions {
+		if !overlapped(region, startKey, endKey) {
+			kept = append(kept, region)
+		}
+	}
+	c.regions = kept
+}
+
+// scan returns the cached regions covering [key, endKey) from the key, at most
+// limit regions if limit is positive, or false if they don't cover it.
+func (c *RegionCache) scan(key, endKey []byte, limit int) ([]*RegionInfo, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if time.Now().After(c.expire) {
+		c.regions = nil
+	}
+	i := sort.Search(len(c.regions), func(i int) bool {
+		return !regionEndBefore(c.regions[i], key)
+	})
+	if i == len(c.regions) || bytes.Compare(c.regions[i].Region.GetStartKey(), key) > 0 {
+		return nil, false
+	}
+	var result []*RegionInfo
+	for ; i < len(c.regions); i++ {
+		region := c.regions[i]
+		if n := len(result); n > 0 &&
+			!bytes.Equal(result[n-1].Region.GetEndKey(), region.Region.GetStartKey()) {
+			// there is a hole not cached.
+			return nil, false
+		}
+		result = append(result, cloneRegionInfo(region))
+		end := region.Region.GetEndKey()
+		if len(end) == 0 || (len(endKey) > 0 && bytes.Compare(end, endKey) >= 0) ||
+			(limit > 0 && len(result) >= limit) {
+			return result, true
+		}
+	}
+	return nil, false
+}
+
+func (c *RegionCache) ScanRegions(ctx context.Context, key, endKey []byte, limit int) ([]*RegionInfo, error) {
+	if regions, ok := c.scan(key, endKey, limit); ok {
+		return regions, nil
+	}
+	return c.SplitClient.ScanRegions(ctx, key, endKey, limit)
+}
+
+func (c *RegionCache) SplitRegion(ctx context.Context, regionInfo *RegionInfo, key []byte) (*RegionInfo, error) {
+	c.Invalidate(regionInfo.Region.GetStartKey(), regionInfo.Region.GetEndKey())
+	return c.SplitClient.SplitRegion(ctx, regionInfo, key)
+}
+
+func (c *RegionCache) BatchSplitRegions(
+	ctx context.Context, regionInfo *RegionInfo, keys [][]byte,
+) ([]*RegionInfo, error) {
+	c.Invalidate(regionInfo.Region.GetStartKey(), regionInfo.Region.GetEndKey())
+	return c.SplitClient.BatchSplitRegions(ctx, regionInfo, keys)
+}
+
+func (c *RegionCache) BatchSplitRegionsWithOrigin(
+	ctx context.Context, regionInfo *RegionInfo, keys [][]byte,
+) (*RegionInfo, []*RegionInfo, error) {
+	c.Invalidate(regionInfo.Region.GetStartKey(), regionInfo.Region.GetEndKey())
+	return c.SplitClient.BatchSplitRegionsWithOrigin(ctx, regionInfo, keys)
+}
+
+// invalidateRegionCache drops the regions of [startKey, endKey) cached by the
+// client if it's a RegionCache.
+func invalidateRegionCache(client SplitClient, startKey, endKey []byte) {
+	if cache, ok := client.(*RegionCache); ok {
+		cache.Invalidate(startKey, endKey)
+	}
+}
@@ -0,0 +1,127 @@
+// Copyright 2021 PingCAP, Inc. Licensed under Apache-2.0.
+
+package restore_test
+
+import (
+	"context"
+	"time"
+
+	. "github.com/pingcap/check"
+	"github.com/pingcap/kvproto/pkg/metapb"
+	"github.com/pingcap/parser/model"
+	"github.com/pingcap/tidb/kv"
+	"github.com/pingcap/tidb/tablecodec"
+	"github.com/pingcap/tidb/util/codec"
+
+	"github.com/pingcap/br/pkg/metautil"
+	"github.com/pingcap/br/pkg/regionutil"
+	"github.com/pingcap/br/pkg/restore"
+	"github.com/pingcap/br/pkg/rtree"
+)
+
+var _ = Suite(&testRegionCacheSuite{})
+
+type testRegionCacheSuite struct{}
+
+// scanCounter counts the scans of the regions sent to the client.
+type scanCounter struct {
+	restore.SplitClient
+	scans int
+}
+
+func (s *scanCounter) ScanRegions(ctx context.Context, key, endKey []byte, limit int) ([]*restore.RegionInfo, error) {
+	s.scans++
+	return s.SplitClient.ScanRegions(ctx, key, endKey, limit)
+}
+
+func encodeKey(key string) []byte {
+	return codec.EncodeBytes(nil, []byte(key))
+}
+
+func (s *testRegionCacheSuite) TestRegionCache(c *C) {
+	ctx := context.Background()
+	counter := &scanCounter{SplitClient: restore.NewMockSplitClient([]*metapb.Store{{Id: 1}})}
+	regions, err := counter.ScanRegions(ctx, nil, nil, 1)
+	c.Assert(err, IsNil)
+	_, _, err = counter.BatchSplitRegionsWithOrigin(ctx, regions[0], [][]byte{[]byte("b"), []byte("d")})
+	c.Assert(err, IsNil)
+
+	cache := restore.NewRegionCache(counter, time.Minute)
+	regions, err = regionutil.PaginateScanRegion(ctx, cache, nil, nil, 10)
+	c.Assert(err, IsNil)
+	c.Assert(regions, HasLen, 3)
+	cache.Feed(regions)
+	counter.scans = 0
+
+	cached, err := cache.ScanRegions(ctx, encodeKey("a"), encodeKey("c"), -1)
+	c.Assert(err, IsNil)
+	c.Assert(cached, HasLen, 2)
+	c.Assert(cached[1].Region.Id, Equals, regions[1].Region.Id)
+	cached, err = cache.ScanRegions(ctx, encodeKey("a"), nil, 1)
+	c.Assert(err, IsNil)
+	c.Assert(cached, HasLen, 1)
+	cached, err = cache.ScanRegions(ctx, encodeKey("e"), nil, -1)
+	c.Assert(err, IsNil)
+	c.Assert(cached, HasLen, 1)
+	c.Assert(counter.scans, Equals, 0)
+
+	// the range isn't served with a hole in the cached regions.
+	cache.Invalidate(encodeKey("b"), encodeKey("c"))
+	scanned, err := cache.ScanRegions(ctx, encodeKey("a"), encodeKey("c"), -1)
+	c.Assert(err, IsNil)
+	c.Assert(scanned, HasLen, 2)
+	c.Assert(counter.scans, Equals, 1)
+
+	// the split regions are dropped from the cache.
+	_, _, err = cache.BatchSplitRegionsWithOrigin(ctx, cached[0], [][]byte{[]byte("e")})
+	c.Assert(err, IsNil)
+	scanned, err = cache.ScanRegions(ctx, encodeKey("d"), nil, -1)
+	c.Assert(err, IsNil)
+	c.Assert(scanned, HasLen, 2)
+	c.Assert(counter.scans, Equals, 2)
+	cached, err = cache.ScanRegions(ctx, nil, encodeKey("a"), -1)
+	c.Assert(err, IsNil)
+	c.Assert(cached, HasLen, 1)
+	c.Assert(counter.scans, Equals, 2)
+
+	// the regions expire after the ttl.
+	expiring := restore.NewRegionCache(counter, 0)
+	expiring.Feed(regions[:1])
+	time.Sleep(time.Millisecond)
+	_, err = expiring.ScanRegions(ctx, nil, encodeKey("a"), -1)
+	c.Assert(err, IsNil)
+	c.Assert(counter.scans, Equals, 3)
+}
+
+func (s *testRegionCacheSuite) TestWarmUpRanges(c *C) {
+	dbs := []*model.DBInfo{
+		{
+			Name: model.NewCIStr("test"),
+			Tables: []*model.TableInfo{{
+				ID:   10,
+				Name: model.NewCIStr("t1"),
+				Partition: &model.PartitionInfo{
+					Enable:      true,
+					Definitions: []model.PartitionDefinition{{ID: 11}, {ID: 12}},
+				},
+			}},
+		},
+		{
+			Name:   model.NewCIStr("INFORMATION_SCHEMA"),
+			Tables: []*model.TableInfo{{ID: 1 << 60, Name: model.NewCIStr("TABLES")}},
+		},
+	}
+	db := &model.DBInfo{Name: model.NewCIStr("test")}
+	tables := []*metautil.Table{
+		{DB: db, Info: &model.TableInfo{ID: 1, Name: model.NewCIStr("t1")}},
+		{DB: db, Info: &model.TableInfo{ID: 2, Name: model.NewCIStr("t2")}},
+	}
+
+	tableRange := func(id int64) rtree.Range {
+		return rtree.Range{StartKey: tablecodec.EncodeTablePrefix(id), EndKey: tablecodec.EncodeTablePrefix(id + 1)}
+	}
+	c.Assert(restore.WarmUpRanges(dbs, tables), DeepEquals, []rtree.Range{
+		tableRange(10), tableRange(11), tableRange(12),
+		{StartKey: tablecodec.EncodeTablePrefix(13), EndKey: kv.Key(tablecodec.TablePrefix()).PrefixNext()},
+	})
+}
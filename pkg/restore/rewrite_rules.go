@@ -0,0 +1,187 @@
+// Copyright 2021 PingCAP, Inc. Licensed under Apache-2.0.
+
+package restore
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+
+	"github.com/pingcap/errors"
+	backuppb "github.com/pingcap/kvproto/pkg/backup"
+	"github.com/pingcap/kvproto/pkg/import_sstpb"
+	"github.com/pingcap/tidb/kv"
+	"github.com/pingcap/tidb/tablecodec"
+
+	berrors "github.com/pingcap/br/pkg/errors"
+)
+
+// customRewriteRule is a user provided rewrite rule in the mapping file.
+// Both prefixes are hex encoded raw (not memcomparable encoded) keys.
+type customRewriteRule struct {
+	OldKeyPrefix string `json:"old-key-prefix"`
+	NewKeyPrefix string `json:"new-key-prefix"`
+}
+
+// ParseRewriteRules parses user provided rewrite rules from a JSON mapping
+// file, which looks like:
+//
+//	[
+//	  {"old-key-prefix": "74800000000000002a", "new-key-prefix": "74800000000000102a"}
+//	]
+//
+// These rules take precedence over the rules generated from table IDs, see
+// mergeRewriteRules.
+func ParseRewriteRules(data []byte) (*RewriteRules, error) {
+	var customRules []customRewriteRule
+	if err := json.Unmarshal(data, &customRules); err != nil {
+		return nil, errors.Annotate(berrors.ErrRestoreInvalidRewrite, err.Error())
+	}
+	rules := EmptyRewriteRule()
+	for i, r := range customRules {
+		oldPrefix, err := hex.DecodeString(r.OldKeyPrefix)
+		if err != nil {
+			return nil, errors.Annotatef(berrors.ErrRestoreInvalidRewrite,
+				"invalid old-key-prefix of rule #%d: %v", i, err)
+		}
+		newPrefix, err := hex.DecodeString(r.NewKeyPrefix)
+		if err != nil {
+			return nil, errors.Annotatef(berrors.ErrRestoreInvalidRewrite,
+				"invalid new-key-prefix of rule #%d: %v", i, err)
+		}
+		if len(oldPrefix) == 0 {
+			return nil, errors.Annotatef(berrors.ErrRestoreInvalidRewrite,
+				"old-key-prefix of rule #%d must not be empty", i)
+		}
+		rules.Data = append(rules.Data, &import_sstpb.RewriteRule{
+			OldKeyPrefix: oldPrefix,
+			NewKeyPrefix: newPrefix,
+		})
+	}
+	return rules, nil
+}
+
+// mergeRewriteRules returns a new RewriteRules contains rules of `extra`
+// followed by rules of `base`. The keys are rewritten by the first matched
+// rule, so the user provided rules in `extra` take precedence over the table
+// rules in `base`: they're matched against the keys as they are in the backup,
+// and a key matched by a user rule isn't rewritten by the table rules. `base`
+// is returned directly if `extra` is empty.
+func mergeRewriteRules(base, extra *RewriteRules) *RewriteRules {
+	if extra == nil || len(extra.Data) == 0 {
+		return base
+	}
+	merged := EmptyRewriteRule()
+	merged.Data = append(merged.Data, extra.Data...)
+	if base != nil {
+		merged.Data = append(merged.Data, base.Data...)
+	}
+	return merged
+}
+
+// MergeRewriteRules returns the rules rewriting the files restored by the
+// table rules `base` and the user provided rules `extra`, see
+// mergeRewriteRules. An error is returned if the user rules can't be applied
+// with the table rules.
+func MergeRewriteRules(base, extra *RewriteRules) (*RewriteRules, error) {
+	if err := checkExtraRewriteRules(base, extra); err != nil {
+		return nil, errors.Trace(err)
+	}
+	return mergeRewriteRules(base, extra), nil
+}
+
+// checkExtraRewriteRules checks the user provided rules can be applied to the
+// files with the table rules. TiKV rewrites the keys of a file downloaded into
+// a region by one rule, so a user rule must not take only a part of the keys
+// of a table rule, i.e. its old prefix mustn't extend the old prefix of any
+// table rule.
+func checkExtraRewriteRules(base, extra *RewriteRules) error {
+	if base == nil || extra == nil {
+		return nil
+	}
+	for _, rule := range extra.Data {
+		for _, tableRule := range base.Data {
+			if len(rule.GetOldKeyPrefix()) > len(tableRule.GetOldKeyPrefix()) &&
+				bytes.HasPrefix(rule.GetOldKeyPrefix(), tableRule.GetOldKeyPrefix()) {
+				return errors.Annotatef(berrors.ErrRestoreInvalidRewrite,
+					"the rewrite rule of old prefix %x takes a part of the table of prefix %x, "+
+						"which can't be rewritten by TiKV", rule.GetOldKeyPrefix(), tableRule.GetOldKeyPrefix())
+			}
+		}
+	}
+	return nil
+}
+
+// rewriteRestoredKey rewrites the key encoded by newID, the ID of the table
+// restored from the table oldID in the backup, by the user provided rules. The
+// rules are matched against the key as it is in the backup, the same as the
+// keys of the files, see mergeRewriteRules. The key is returned unchanged if
+// no rule matches.
+func rewriteRestoredKey(key []byte, oldID, newID int64, extra *RewriteRules) []byte {
+	if extra == nil || len(extra.Data) == 0 {
+		return key
+	}
+	newPrefix := tablecodec.EncodeTablePrefix(newID)
+	if !bytes.HasPrefix(key, newPrefix) {
+		return key
+	}
+	oldKey := append(tablecodec.EncodeTablePrefix(oldID), key[len(newPrefix):]...)
+	if matchOldPrefix(oldKey, extra) == nil {
+		return key
+	}
+	return rewriteKey(oldKey, extra)
+}
+
+// rawFileRule returns the rule rewriting the keys of the raw kv file, nil if
+// no rule matches the file. TiKV rewrites all the keys of the file by one
+// rule, so the file must not be covered by a rule partially.
+func rawFileRule(file *backuppb.File, rewriteRules *RewriteRules) (*import_sstpb.RewriteRule, error) {
+	if rewriteRules == nil {
+		return nil, nil
+	}
+	start, end := file.GetStartKey(), file.GetEndKey()
+	for _, rule := range rewriteRules.Data {
+		prefix := rule.GetOldKeyPrefix()
+		prefixEnd := kv.Key(prefix).PrefixNext()
+		if bytes.Compare(start, prefixEnd) >= 0 || (len(end) > 0 && bytes.Compare(end, prefix) <= 0) {
+			continue
+		}
+		if bytes.Compare(start, prefix) >= 0 && len(end) > 0 && bytes.Compare(end, prefixEnd) <= 0 {
+			return rule, nil
+		}
+		return nil, errors.Annotatef(berrors.ErrRestoreInvalidRewrite,
+			"the raw kv file %s is partially covered by the rewrite rule of old prefix %x", file.GetName(), prefix)
+	}
+	return nil, nil
+}
+
+// rewriteRawRangeKey rewrites the start or end key of a raw kv range by the
+// rule of the files in the range, the keys before the old prefix are moved to
+// the new prefix and the keys after it to the end of the new prefix.
+func rewriteRawRangeKey(key []byte, rule *import_sstpb.RewriteRule) []byte {
+	prefix := rule.GetOldKeyPrefix()
+	switch {
+	case bytes.HasPrefix(key, prefix):
+		return append(append([]byte{}, rule.GetNewKeyPrefix()...), key[len(prefix):]...)
+	case bytes.Compare(key, prefix) < 0:
+		return append([]byte{}, rule.GetNewKeyPrefix()...)
+	default:
+		return kv.Key(rule.GetNewKeyPrefix()).PrefixNext()
+	}
+}
+
+// rewriteKey rewrites the raw key with the first matched rule.
+// The key is returned unchanged if no rule matches.
+func rewriteKey(key []byte, rewriteRules *RewriteRules) []byte {
+	if rewriteRules == nil {
+		return key
+	}
+	for _, rule := range rewriteRules.Data {
+		if bytes.HasPrefix(key, rule.GetOldKeyPrefix()) {
+			newKey := make([]byte, 0, len(key)-len(rule.GetOldKeyPrefix())+len(rule.GetNewKeyPrefix()))
+			newKey = append(newKey, rule.GetNewKeyPrefix()...)
+			return append(newKey, key[len(rule.GetOldKeyPrefix()):]...)
+		}
+	}
+	return key
+}
@@ -0,0 +1,148 @@
+// Copyright 2021 PingCAP, Inc. Licensed under Apache-2.0.
+
+package restore_test
+
+import (
+	"bytes"
+	"context"
+	"sync"
+
+	. "github.com/pingcap/check"
+	backuppb "github.com/pingcap/kvproto/pkg/backup"
+	"github.com/pingcap/kvproto/pkg/import_sstpb"
+	"github.com/pingcap/kvproto/pkg/metapb"
+	"github.com/pingcap/tidb/kv"
+	"github.com/pingcap/tidb/tablecodec"
+	"github.com/pingcap/tidb/util/codec"
+
+	"github.com/pingcap/br/pkg/restore"
+)
+
+type testRewriteRulesSuite struct{}
+
+var _ = Suite(&testRewriteRulesSuite{})
+
+func (s *testRewriteRulesSuite) TestParseRewriteRules(c *C) {
+	rules, err := restore.ParseRewriteRules([]byte(`[
+		{"old-key-prefix": "7480000000000000ff", "new-key-prefix": "74800000000000ffff"},
+		{"old-key-prefix": "01", "new-key-prefix": ""}
+	]`))
+	c.Assert(err, IsNil)
+	c.Assert(rules.Data, HasLen, 2)
+	c.Assert(rules.Data[0].OldKeyPrefix, DeepEquals, []byte{0x74, 0x80, 0, 0, 0, 0, 0, 0, 0xff})
+	c.Assert(rules.Data[1].NewKeyPrefix, HasLen, 0)
+
+	_, err = restore.ParseRewriteRules([]byte(`[{"old-key-prefix": "zz", "new-key-prefix": "01"}]`))
+	c.Assert(err, ErrorMatches, ".*invalid old-key-prefix.*")
+	_, err = restore.ParseRewriteRules([]byte(`[{"old-key-prefix": "", "new-key-prefix": "01"}]`))
+	c.Assert(err, ErrorMatches, ".*must not be empty.*")
+	_, err = restore.ParseRewriteRules([]byte(`{}`))
+	c.Assert(err, NotNil)
+}
+
+func (s *testRewriteRulesSuite) TestMergeRewriteRules(c *C) {
+	tableRules := &restore.RewriteRules{Data: []*import_sstpb.RewriteRule{{
+		OldKeyPrefix: tablecodec.EncodeTablePrefix(1),
+		NewKeyPrefix: tablecodec.EncodeTablePrefix(101),
+	}}}
+	userRule := &import_sstpb.RewriteRule{
+		OldKeyPrefix: tablecodec.EncodeTablePrefix(1),
+		NewKeyPrefix: tablecodec.EncodeTablePrefix(201),
+	}
+	merged, err := restore.MergeRewriteRules(tableRules, &restore.RewriteRules{Data: []*import_sstpb.RewriteRule{userRule}})
+	c.Assert(err, IsNil)
+	c.Assert(merged.Data, HasLen, 2)
+	c.Assert(merged.Data[0], Equals, userRule)
+
+	merged, err = restore.MergeRewriteRules(tableRules, restore.EmptyRewriteRule())
+	c.Assert(err, IsNil)
+	c.Assert(merged, Equals, tableRules)
+
+	// a user rule taking a part of a table can't be applied by TiKV.
+	_, err = restore.MergeRewriteRules(tableRules, &restore.RewriteRules{Data: []*import_sstpb.RewriteRule{{
+		OldKeyPrefix: tablecodec.GenTableRecordPrefix(1),
+		NewKeyPrefix: tablecodec.GenTableRecordPrefix(201),
+	}}})
+	c.Assert(err, ErrorMatches, ".*takes a part of the table.*")
+}
+
+// recordImportClient records the rewrite rules of the downloads.
+type recordImportClient struct {
+	restore.ImporterClient
+
+	mu    sync.Mutex
+	rules []import_sstpb.RewriteRule
+}
+
+func (r *recordImportClient) DownloadSST(
+	ctx context.Context, storeID uint64, req *import_sstpb.DownloadRequest,
+) (*import_sstpb.DownloadResponse, error) {
+	r.mu.Lock()
+	r.rules = append(r.rules, req.RewriteRule)
+	r.mu.Unlock()
+	return r.ImporterClient.DownloadSST(ctx, storeID, req)
+}
+
+func (s *testRewriteRulesSuite) TestImportByUserRewriteRules(c *C) {
+	ctx := context.Background()
+	metaClient := restore.NewMockSplitClient([]*metapb.Store{{Id: 1}})
+	regions, err := metaClient.ScanRegions(ctx, nil, nil, 1)
+	c.Assert(err, IsNil)
+	_, _, err = metaClient.BatchSplitRegionsWithOrigin(ctx, regions[0],
+		[][]byte{tablecodec.EncodeTablePrefix(201), tablecodec.EncodeTablePrefix(202)})
+	c.Assert(err, IsNil)
+
+	importClient := &recordImportClient{ImporterClient: restore.NewMockImportClient()}
+	importer := restore.NewFileImporter(metaClient, importClient, &backuppb.StorageBackend{}, false, 0)
+	tableRules := &restore.RewriteRules{Data: []*import_sstpb.RewriteRule{{
+		OldKeyPrefix: tablecodec.EncodeTablePrefix(1),
+		NewKeyPrefix: tablecodec.EncodeTablePrefix(101),
+	}}}
+	userRules := &restore.RewriteRules{Data: []*import_sstpb.RewriteRule{{
+		OldKeyPrefix: tablecodec.EncodeTablePrefix(1),
+		NewKeyPrefix: tablecodec.EncodeTablePrefix(201),
+	}}}
+	rules, err := restore.MergeRewriteRules(tableRules, userRules)
+	c.Assert(err, IsNil)
+	file := &backuppb.File{
+		Name:     "1_write.sst",
+		StartKey: tablecodec.EncodeRowKeyWithHandle(1, kv.IntHandle(1)),
+		EndKey:   tablecodec.EncodeRowKeyWithHandle(1, kv.IntHandle(10)),
+	}
+	c.Assert(importer.Import(ctx, []*backuppb.File{file}, rules), IsNil)
+	// the file is downloaded into the region of the user rule, and rewritten
+	// by the user rule instead of the table rule.
+	c.Assert(importClient.rules, HasLen, 1)
+	newKey := codec.EncodeBytes(nil, tablecodec.EncodeRowKeyWithHandle(201, kv.IntHandle(1)))
+	c.Assert(bytes.HasPrefix(newKey, importClient.rules[0].NewKeyPrefix), IsTrue)
+	oldKey := codec.EncodeBytes(nil, file.StartKey)
+	c.Assert(bytes.HasPrefix(oldKey, importClient.rules[0].OldKeyPrefix), IsTrue)
+}
+
+func (s *testRewriteRulesSuite) TestImportRawByUserRewriteRules(c *C) {
+	ctx := context.Background()
+	metaClient := restore.NewMockSplitClient([]*metapb.Store{{Id: 1}})
+	importClient := &recordImportClient{ImporterClient: restore.NewMockImportClient()}
+	importer := restore.NewFileImporter(metaClient, importClient, &backuppb.StorageBackend{}, true, 0)
+	rules, err := restore.MergeRewriteRules(restore.EmptyRewriteRule(), &restore.RewriteRules{
+		Data: []*import_sstpb.RewriteRule{{OldKeyPrefix: []byte("a"), NewKeyPrefix: []byte("b")}},
+	})
+	c.Assert(err, IsNil)
+
+	file := &backuppb.File{Name: "1_default.sst", StartKey: []byte("a1"), EndKey: []byte("a9")}
+	c.Assert(importer.Import(ctx, []*backuppb.File{file}, rules), IsNil)
+	c.Assert(importClient.rules, HasLen, 1)
+	c.Assert(importClient.rules[0].OldKeyPrefix, DeepEquals, []byte("a"))
+	c.Assert(importClient.rules[0].NewKeyPrefix, DeepEquals, []byte("b"))
+
+	// the files not matched by any rule are restored as they are.
+	file = &backuppb.File{Name: "2_default.sst", StartKey: []byte("c1"), EndKey: []byte("c9")}
+	c.Assert(importer.Import(ctx, []*backuppb.File{file}, rules), IsNil)
+	c.Assert(importClient.rules, HasLen, 2)
+	c.Assert(importClient.rules[1].OldKeyPrefix, HasLen, 0)
+
+	// TiKV rewrites a file by one rule, so a file partially matched is rejected.
+	file = &backuppb.File{Name: "3_default.sst", StartKey: []byte("0"), EndKey: []byte("a5")}
+	err = importer.Import(ctx, []*backuppb.File{file}, rules)
+	c.Assert(err, ErrorMatches, ".*partially covered by the rewrite rule.*")
+}
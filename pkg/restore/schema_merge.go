@@ -0,0 +1,299 @@
+// Copyright 2021 PingCAP, Inc. Licensed under Apache-2.0.
+
+package restore
+
+import (
+	"context"
+	"strings"
+	"sync/atomic"
+
+	"github.com/pingcap/errors"
+	"github.com/pingcap/log"
+	"github.com/pingcap/parser/model"
+	"github.com/pingcap/tidb/kv"
+	"go.uber.org/zap"
+
+	"github.com/pingcap/br/pkg/checksum"
+	berrors "github.com/pingcap/br/pkg/errors"
+	"github.com/pingcap/br/pkg/metautil"
+	"github.com/pingcap/br/pkg/utils"
+)
+
+// MergeConflictPolicy decides how to handle the tables of different source
+// schemas mapped to the same name in the target schema.
+type MergeConflictPolicy string
+
+const (
+	// MergeConflictError fails the restore.
+	MergeConflictError MergeConflictPolicy = "error"
+	// MergeConflictRename appends the source schema name to the table name.
+	MergeConflictRename MergeConflictPolicy = "rename"
+	// MergeConflictMerge restores the tables into a single table, the tables
+	// must have the same columns and indices.
+	MergeConflictMerge MergeConflictPolicy = "merge"
+
+	// DefaultMergeTableName is the default template of the table names in the
+	// target schema, which keeps the table names.
+	DefaultMergeTableName = "{table}"
+)
+
+// SchemaMerge is the configuration of mapping the tables of many source
+// schemas into a single target schema.
+type SchemaMerge struct {
+	// Target is the name of the target schema.
+	Target string
+	// TableName is the template of the table names in the target schema, the
+	// `{schema}` and `{table}` are replaced by the source names.
+	TableName string
+	// Conflict is the policy of the identically-named tables.
+	Conflict MergeConflictPolicy
+}
+
+// MergedTable is a table of the target schema restored from many source tables.
+type MergedTable struct {
+	Name    string
+	Sources []*metautil.Table
+
+	// pending is the number of sources not checksummed yet.
+	pending int32
+}
+
+// Crc64Xor returns the xor of the checksums of the sources. It can't be
+// compared with the target table, since the keys of the sources have
+// different table IDs.
+func (t *MergedTable) Crc64Xor() uint64 {
+	var crc uint64
+	for _, source := range t.Sources {
+		crc ^= source.Crc64Xor
+	}
+	return crc
+}
+
+// TotalKvs returns the number of kvs of all sources.
+func (t *MergedTable) TotalKvs() uint64 {
+	var kvs uint64
+	for _, source := range t.Sources {
+		kvs += source.TotalKvs
+	}
+	return kvs
+}
+
+// TotalBytes returns the size of all sources.
+func (t *MergedTable) TotalBytes() uint64 {
+	var size uint64
+	for _, source := range t.Sources {
+		size += source.TotalBytes
+	}
+	return size
+}
+
+// NoChecksum checks whether any source has no calculated checksum.
+func (t *MergedTable) NoChecksum() bool {
+	for _, source := range t.Sources {
+		if source.NoChecksum() {
+			return true
+		}
+	}
+	return false
+}
+
+// MergeSchemas maps the tables into the target schema. It returns the target
+// schema, the tables to restore, and the tables restored from many sources.
+// The tables of the system databases are not mapped.
+func MergeSchemas(
+	tables []*metautil.Table,
+	merge SchemaMerge,
+) (*model.DBInfo, []*metautil.Table, []*MergedTable, error) {
+	template := merge.TableName
+	if template == "" {
+		template = DefaultMergeTableName
+	}
+	conflict := merge.Conflict
+	if conflict == "" {
+		conflict = MergeConflictError
+	}
+
+	var target *model.DBInfo
+	result := make([]*metautil.Table, 0, len(tables))
+	byName := make(map[string]*MergedTable)
+	var names []string
+	for _, table := range tables {
+		if _, ok := utils.GetSysDBName(table.DB.Name); ok || utils.IsSysDB(table.DB.Name.L) {
+			result = append(result, table)
+			continue
+		}
+		if target == nil {
+			target = table.DB.Clone()
+			target.Name = model.NewCIStr(merge.Target)
+		}
+		name := strings.NewReplacer("{schema}", table.DB.Name.O, "{table}", table.Info.Name.O).Replace(template)
+		merged, ok := byName[strings.ToLower(name)]
+		if ok {
+			switch conflict {
+			case MergeConflictMerge:
+				if err := checkMergeable(merged.Sources[0], table); err != nil {
+					return nil, nil, nil, errors.Trace(err)
+				}
+			case MergeConflictRename:
+				name = name + "_" + table.DB.Name.O
+				if _, ok = byName[strings.ToLower(name)]; ok {
+					return nil, nil, nil, errors.Annotatef(berrors.ErrRestoreMergeConflict,
+						"table %s.%s is renamed to an existing table %s", table.DB.Name, table.Info.Name, name)
+				}
+				merged = nil
+			default:
+				return nil, nil, nil, errors.Annotatef(berrors.ErrRestoreMergeConflict,
+					"tables %s.%s and %s.%s are both mapped to %s", merged.Sources[0].DB.Name,
+					merged.Sources[0].Info.Name, table.DB.Name, table.Info.Name, name)
+			}
+		}
+		if merged == nil {
+			merged = &MergedTable{Name: name}
+			byName[strings.ToLower(name)] = merged
+			names = append(names, strings.ToLower(name))
+		}
+		merged.Sources = append(merged.Sources, table)
+	}
+
+	var mergedTables []*MergedTable
+	for _, key := range names {
+		merged := byName[key]
+		var autoIncID int64
+		for _, source := range merged.Sources {
+			if source.Info.AutoIncID > autoIncID {
+				autoIncID = source.Info.AutoIncID
+			}
+		}
+		for _, source := range merged.Sources {
+			table := *source
+			table.DB = target
+			table.Info = source.Info.Clone()
+			table.Info.Name = model.NewCIStr(merged.Name)
+			table.Info.AutoIncID = autoIncID
+			if len(merged.Sources) > 1 {
+				// The statistics of a source don't describe the merged table.
+				table.Stats = nil
+			} else if source.Stats != nil {
+				stats := *source.Stats
+				stats.DatabaseName = target.Name.O
+				stats.TableName = merged.Name
+				table.Stats = &stats
+			}
+			result = append(result, &table)
+		}
+		if len(merged.Sources) > 1 {
+			merged.pending = int32(len(merged.Sources))
+			mergedTables = append(mergedTables, merged)
+			if info := merged.Sources[0].Info; !info.IsCommonHandle && !info.ContainsAutoRandomBits() {
+				// The SST files are restored without re-encoding, so the integer
+				// handles can't be rewritten to avoid collisions.
+				log.Warn("the rows of the merged table may collide on handles, "+
+					"consider auto_random primary keys or restoring the shards by log restore with --shard-handle",
+					zap.String("schema", merge.Target), zap.String("table", merged.Name))
+			}
+		}
+		log.Info("map tables to the target schema", zap.String("schema", merge.Target),
+			zap.String("table", merged.Name), zap.Int("sources", len(merged.Sources)))
+	}
+	return target, result, mergedTables, nil
+}
+
+// checkMergeable checks whether the two tables have the same columns and
+// indices, so the data of both can be restored into a single table.
+func checkMergeable(a, b *metautil.Table) error {
+	mismatch := func(reason string) error {
+		return errors.Annotatef(berrors.ErrRestoreMergeConflict, "cannot merge %s.%s and %s.%s: %s",
+			a.DB.Name, a.Info.Name, b.DB.Name, b.Info.Name, reason)
+	}
+	if a.Info.IsView() || b.Info.IsView() || a.Info.IsSequence() || b.Info.IsSequence() {
+		return mismatch("views and sequences cannot be merged")
+	}
+	if a.Info.PKIsHandle != b.Info.PKIsHandle || a.Info.IsCommonHandle != b.Info.IsCommonHandle {
+		return mismatch("different primary keys")
+	}
+	if len(a.Info.Columns) != len(b.Info.Columns) {
+		return mismatch("different columns")
+	}
+	for i, col := range a.Info.Columns {
+		other := b.Info.Columns[i]
+		if col.Name.L != other.Name.L || col.FieldType.Tp != other.FieldType.Tp {
+			return mismatch("different column " + col.Name.O)
+		}
+	}
+	indices := make(map[string]struct{}, len(a.Info.Indices))
+	for _, index := range a.Info.Indices {
+		indices[index.Name.L] = struct{}{}
+	}
+	if len(a.Info.Indices) != len(b.Info.Indices) {
+		return mismatch("different indices")
+	}
+	for _, index := range b.Info.Indices {
+		if _, ok := indices[index.Name.L]; !ok {
+			return mismatch("different index " + index.Name.O)
+		}
+	}
+	return nil
+}
+
+// execMergedChecksum validates the checksum of a table restored from many
+// sources after all of them are restored. Only the number of kvs and bytes
+// are compared, since the crc64 of the sources is computed with their table
+// IDs.
+func (rc *Client) execMergedChecksum(
+	ctx context.Context,
+	tbl CreatedTable,
+	merged *MergedTable,
+	kvClient kv.Client,
+	concurrency uint,
+) error {
+	logger := log.With(
+		zap.String("db", tbl.OldTable.DB.Name.O),
+		zap.String("table", merged.Name),
+	)
+	if atomic.AddInt32(&merged.pending, -1) > 0 {
+		logger.Debug("wait for the other sources to validate the merged checksum",
+			zap.Int64("source table id", tbl.OldTable.Info.ID))
+		return nil
+	}
+	if merged.NoChecksum() {
+		logger.Warn("some sources of the merged table have no checksum, skipping checksum")
+		return nil
+	}
+
+	ctx, cancel := utils.ContextWithTimeout(ctx, rc.timeout.Checksum)
+	defer cancel()
+
+	startTS, err := rc.GetTS(ctx)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	exe, err := rc.buildChecksumExecutor(ctx, checksum.NewExecutorBuilder(tbl.Table, startTS).
+		SetConcurrency(concurrency), tbl.Table.ID)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	checksumResp, err := exe.Execute(ctx, kvClient, func() {})
+	if err != nil {
+		return errors.Trace(err)
+	}
+
+	sources := make([]string, 0, len(merged.Sources))
+	for _, source := range merged.Sources {
+		sources = append(sources, source.DB.Name.O+"."+source.Info.Name.O)
+	}
+	fields := []zap.Field{
+		zap.Strings("sources", sources),
+		zap.Uint64("sources crc64 xor", merged.Crc64Xor()),
+		zap.Uint64("calculated crc64", checksumResp.Checksum),
+		zap.Uint64("sources total kvs", merged.TotalKvs()),
+		zap.Uint64("calculated total kvs", checksumResp.TotalKvs),
+		zap.Uint64("sources total bytes", merged.TotalBytes()),
+		zap.Uint64("calculated total bytes", checksumResp.TotalBytes),
+	}
+	if checksumResp.TotalKvs != merged.TotalKvs() || checksumResp.TotalBytes != merged.TotalBytes() {
+		logger.Error("failed in validate merged checksum", fields...)
+		return errors.Annotate(berrors.ErrRestoreChecksumMismatch, "failed to validate merged checksum")
+	}
+	logger.Info("merged checksum validated", fields...)
+	return nil
+}
@@ -0,0 +1,97 @@
+// Copyright 2021 PingCAP, Inc. Licensed under Apache-2.0.
+
+package restore_test
+
+import (
+	. "github.com/pingcap/check"
+	"github.com/pingcap/parser/model"
+	"github.com/pingcap/parser/mysql"
+	"github.com/pingcap/parser/types"
+
+	"github.com/pingcap/br/pkg/metautil"
+	"github.com/pingcap/br/pkg/restore"
+)
+
+var _ = Suite(&testSchemaMergeSuite{})
+
+type testSchemaMergeSuite struct{}
+
+func mockShardTable(db *model.DBInfo, id int64, name string, autoIncID int64, cols ...string) *metautil.Table {
+	info := &model.TableInfo{ID: id, Name: model.NewCIStr(name), AutoIncID: autoIncID}
+	for _, col := range cols {
+		info.Columns = append(info.Columns, &model.ColumnInfo{
+			Name:      model.NewCIStr(col),
+			FieldType: *types.NewFieldType(mysql.TypeLong),
+		})
+	}
+	return &metautil.Table{DB: db, Info: info, TotalKvs: 10, TotalBytes: 100}
+}
+
+func (s *testSchemaMergeSuite) TestMergeSchemas(c *C) {
+	shard1 := &model.DBInfo{ID: 1, Name: model.NewCIStr("shard_0001")}
+	shard2 := &model.DBInfo{ID: 2, Name: model.NewCIStr("shard_0002")}
+	sys := &model.DBInfo{ID: 3, Name: model.NewCIStr("__TiDB_BR_Temporary_mysql")}
+	tables := []*metautil.Table{
+		mockShardTable(shard1, 11, "orders", 100, "id", "v"),
+		mockShardTable(shard1, 12, "users", 0, "id"),
+		mockShardTable(shard2, 21, "orders", 200, "id", "v"),
+		mockShardTable(sys, 31, "user", 0, "host"),
+	}
+
+	_, _, _, err := restore.MergeSchemas(tables, restore.SchemaMerge{Target: "merged"})
+	c.Assert(err, ErrorMatches, ".*tables shard_0001.orders and shard_0002.orders are both mapped to orders.*")
+
+	// rename the conflict tables.
+	target, result, merged, err := restore.MergeSchemas(tables, restore.SchemaMerge{
+		Target:   "merged",
+		Conflict: restore.MergeConflictRename,
+	})
+	c.Assert(err, IsNil)
+	c.Assert(target.Name.O, Equals, "merged")
+	c.Assert(merged, HasLen, 0)
+	names := make([]string, 0, len(result))
+	for _, table := range result {
+		names = append(names, table.DB.Name.O+"."+table.Info.Name.O)
+	}
+	c.Assert(names, DeepEquals, []string{
+		"__TiDB_BR_Temporary_mysql.user", "merged.orders", "merged.users", "merged.orders_shard_0002",
+	})
+	// the source tables aren't modified.
+	c.Assert(tables[0].DB.Name.O, Equals, "shard_0001")
+
+	// name the tables by the template.
+	_, result, _, err = restore.MergeSchemas(tables, restore.SchemaMerge{
+		Target:    "merged",
+		TableName: "{schema}_{table}",
+	})
+	c.Assert(err, IsNil)
+	c.Assert(result[1].Info.Name.O, Equals, "shard_0001_orders")
+	c.Assert(result[3].Info.Name.O, Equals, "shard_0002_orders")
+
+	// merge the conflict tables.
+	_, result, merged, err = restore.MergeSchemas(tables, restore.SchemaMerge{
+		Target:   "merged",
+		Conflict: restore.MergeConflictMerge,
+	})
+	c.Assert(err, IsNil)
+	c.Assert(result, HasLen, 4)
+	c.Assert(merged, HasLen, 1)
+	c.Assert(merged[0].Name, Equals, "orders")
+	c.Assert(merged[0].Sources, HasLen, 2)
+	c.Assert(merged[0].TotalKvs(), Equals, uint64(20))
+	c.Assert(merged[0].TotalBytes(), Equals, uint64(200))
+	for _, table := range result[1:3] {
+		c.Assert(table.Info.Name.O, Equals, "orders")
+		c.Assert(table.Info.AutoIncID, Equals, int64(200))
+	}
+	c.Assert(result[1].Info.ID, Equals, int64(11))
+	c.Assert(result[2].Info.ID, Equals, int64(21))
+
+	// the tables with different columns can't be merged.
+	tables[2] = mockShardTable(shard2, 21, "orders", 200, "id", "w")
+	_, _, _, err = restore.MergeSchemas(tables, restore.SchemaMerge{
+		Target:   "merged",
+		Conflict: restore.MergeConflictMerge,
+	})
+	c.Assert(err, ErrorMatches, ".*cannot merge shard_0001.orders and shard_0002.orders: different column v.*")
+}
@@ -0,0 +1,65 @@
+// Copyright 2021 PingCAP, Inc. Licensed under Apache-2.0.
+
+package restore
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/pingcap/errors"
+	filter "github.com/pingcap/tidb-tools/pkg/table-filter"
+
+	berrors "github.com/pingcap/br/pkg/errors"
+	"github.com/pingcap/br/pkg/kv"
+)
+
+// ShardHandleRule assigns a shard ID to the tables matched by the filter, so
+// their handles are rewritten when the rows are encoded.
+type ShardHandleRule struct {
+	Filter filter.Filter
+	Shard  kv.ShardHandle
+}
+
+// ParseShardHandleRules parses the rules in the form of `pattern=shard-id`,
+// where the pattern is a table filter, e.g. `shard_0001.*=1`.
+func ParseShardHandleRules(specs []string, bits uint64) ([]ShardHandleRule, error) {
+	if len(specs) > 0 && (bits == 0 || bits > 62) {
+		return nil, errors.Annotatef(berrors.ErrInvalidArgument, "shard handle bits %d should be in [1, 62]", bits)
+	}
+	rules := make([]ShardHandleRule, 0, len(specs))
+	for _, spec := range specs {
+		i := strings.LastIndexByte(spec, '=')
+		if i < 0 {
+			return nil, errors.Annotatef(berrors.ErrInvalidArgument,
+				"shard handle rule %q should be in the form of pattern=shard-id", spec)
+		}
+		shardID, err := strconv.ParseInt(strings.TrimSpace(spec[i+1:]), 10, 64)
+		if err != nil {
+			return nil, errors.Annotatef(berrors.ErrInvalidArgument, "invalid shard id in rule %q", spec)
+		}
+		if shardID < 0 || shardID >= 1<<bits {
+			return nil, errors.Annotatef(berrors.ErrInvalidArgument,
+				"shard id in rule %q doesn't fit in %d bits", spec, bits)
+		}
+		f, err := filter.Parse([]string{strings.TrimSpace(spec[:i])})
+		if err != nil {
+			return nil, errors.Annotatef(berrors.ErrInvalidArgument, "invalid pattern in rule %q: %v", spec, err)
+		}
+		rules = append(rules, ShardHandleRule{
+			Filter: filter.CaseInsensitive(f),
+			Shard:  kv.ShardHandle{ShardID: shardID, Bits: bits},
+		})
+	}
+	return rules, nil
+}
+
+// MatchShardHandle returns the shard handle of the first rule matching the
+// table, or nil if none matches.
+func MatchShardHandle(rules []ShardHandleRule, schema, table string) *kv.ShardHandle {
+	for i := range rules {
+		if rules[i].Filter.MatchTable(schema, table) {
+			return &rules[i].Shard
+		}
+	}
+	return nil
+}
@@ -5,7 +5,6 @@ package restore
 import (
 	"bytes"
 	"context"
-	"encoding/hex"
 	"strings"
 	"time"
 
@@ -13,15 +12,13 @@ import (
 	"github.com/pingcap/errors"
 	sst "github.com/pingcap/kvproto/pkg/import_sstpb"
 	"github.com/pingcap/kvproto/pkg/metapb"
-	"github.com/pingcap/kvproto/pkg/pdpb"
 	"github.com/pingcap/log"
 	"github.com/tikv/pd/pkg/codec"
 	"go.uber.org/zap"
 
-	berrors "github.com/pingcap/br/pkg/errors"
 	"github.com/pingcap/br/pkg/logutil"
+	"github.com/pingcap/br/pkg/regionutil"
 	"github.com/pingcap/br/pkg/rtree"
-	"github.com/pingcap/br/pkg/utils"
 )
 
 // Constants for split retry machinery.
@@ -39,13 +36,26 @@ const (
 	ScatterMaxWaitInterval   = time.Second
 	ScatterWaitUpperInterval = 180 * time.Second
 
-	ScanRegionPaginationLimit = 128
+	ScanRegionPaginationLimit = regionutil.DefaultPageSize
 
 	RejectStoreCheckRetryTimes  = 64
 	RejectStoreCheckInterval    = 100 * time.Millisecond
 	RejectStoreMaxCheckInterval = 2 * time.Second
 )
 
+var (
+	splitWaitPolicy = regionutil.RetryPolicy{
+		MaxAttempts: SplitCheckMaxRetryTimes,
+		BaseBackoff: SplitCheckInterval,
+		MaxBackoff:  SplitMaxCheckInterval,
+	}
+	scatterWaitPolicy = regionutil.RetryPolicy{
+		MaxAttempts: ScatterWaitMaxRetryTimes,
+		BaseBackoff: ScatterWaitInterval,
+		MaxBackoff:  ScatterMaxWaitInterval,
+	}
+)
+
 // RegionSplitter is a executor of region split by rules.
 type RegionSplitter struct {
 	client SplitClient
@@ -111,7 +121,7 @@ func (rs *RegionSplitter) Split(
 	scatterRegions := make([]*RegionInfo, 0)
 SplitRegions:
 	for i := 0; i < SplitRetryTimes; i++ {
-		regions, errScan := PaginateScanRegion(ctx, rs.client, minKey, maxKey, ScanRegionPaginationLimit)
+		regions, errScan := regionutil.PaginateScanRegion(ctx, rs.client, minKey, maxKey, ScanRegionPaginationLimit)
 		if errScan != nil {
 			return errors.Trace(errScan)
 		}
@@ -173,7 +183,7 @@ SplitRegions:
 	startTime = time.Now()
 	scatterCount := 0
 	for _, region := range scatterRegions {
-		rs.waitForScatterRegion(ctx, region)
+		regionutil.WaitForScatterRegion(ctx, rs.client, region, scatterWaitPolicy)
 		if time.Since(startTime) > ScatterWaitUpperInterval {
 			break
 		}
@@ -191,81 +201,6 @@ SplitRegions:
 	return nil
 }
 
-func (rs *RegionSplitter) hasRegion(ctx context.Context, regionID uint64) (bool, error) {
-	regionInfo, err := rs.client.GetRegionByID(ctx, regionID)
-	if err != nil {
-		return false, errors.Trace(err)
-	}
-	return regionInfo != nil, nil
-}
-
-func (rs *RegionSplitter) isScatterRegionFinished(ctx context.Context, regionID uint64) (bool, error) {
-	resp, err := rs.client.GetOperator(ctx, regionID)
-	if err != nil {
-		return false, errors.Trace(err)
-	}
-	// Heartbeat may not be sent to PD
-	if respErr := resp.GetHeader().GetError(); respErr != nil {
-		if respErr.GetType() == pdpb.ErrorType_REGION_NOT_FOUND {
-			return true, nil
-		}
-		return false, errors.Annotatef(berrors.ErrPDInvalidResponse, "get operator error: %s", respErr.GetType())
-	}
-	retryTimes := ctx.Value(retryTimes).(int)
-	if retryTimes > 3 {
-		log.Info("get operator", zap.Uint64("regionID", regionID), zap.Stringer("resp", resp))
-	}
-	// If the current operator of the region is not 'scatter-region', we could assume
-	// that 'scatter-operator' has finished or timeout
-	ok := string(resp.GetDesc()) != "scatter-region" || resp.GetStatus() != pdpb.OperatorStatus_RUNNING
-	return ok, nil
-}
-
-func (rs *RegionSplitter) waitForSplit(ctx context.Context, regionID uint64) {
-	interval := SplitCheckInterval
-	for i := 0; i < SplitCheckMaxRetryTimes; i++ {
-		ok, err := rs.hasRegion(ctx, regionID)
-		if err != nil {
-			log.Warn("wait for split failed", zap.Error(err))
-			return
-		}
-		if ok {
-			break
-		}
-		interval = 2 * interval
-		if interval > SplitMaxCheckInterval {
-			interval = SplitMaxCheckInterval
-		}
-		time.Sleep(interval)
-	}
-}
-
-type retryTimeKey struct{}
-
-var retryTimes = new(retryTimeKey)
-
-func (rs *RegionSplitter) waitForScatterRegion(ctx context.Context, regionInfo *RegionInfo) {
-	interval := ScatterWaitInterval
-	regionID := regionInfo.Region.GetId()
-	for i := 0; i < ScatterWaitMaxRetryTimes; i++ {
-		ctx1 := context.WithValue(ctx, retryTimes, i)
-		ok, err := rs.isScatterRegionFinished(ctx1, regionID)
-		if err != nil {
-			log.Warn("scatter region failed: do not have the region",
-				logutil.Region(regionInfo.Region))
-			return
-		}
-		if ok {
-			break
-		}
-		interval = 2 * interval
-		if interval > ScatterMaxWaitInterval {
-			interval = ScatterMaxWaitInterval
-		}
-		time.Sleep(interval)
-	}
-}
-
 func (rs *RegionSplitter) splitAndScatterRegions(
 	ctx context.Context, regionInfo *RegionInfo, keys [][]byte,
 ) ([]*RegionInfo, error) {
@@ -279,52 +214,7 @@ func (rs *RegionSplitter) splitAndScatterRegions(
 
 // ScatterRegions scatter the regions.
 func (rs *RegionSplitter) ScatterRegions(ctx context.Context, newRegions []*RegionInfo) {
-	for _, region := range newRegions {
-		// Wait for a while until the regions successfully split.
-		rs.waitForSplit(ctx, region.Region.Id)
-		if err := utils.WithRetry(ctx,
-			func() error { return rs.client.ScatterRegion(ctx, region) },
-			// backoff about 6s, or we give up scattering this region.
-			&scatterBackoffer{
-				attempt:     7,
-				baseBackoff: 100 * time.Millisecond,
-			},
-		); err != nil {
-			log.Warn("scatter region failed, stop retry", logutil.Region(region.Region), zap.Error(err))
-		}
-	}
-}
-
-// PaginateScanRegion scan regions with a limit pagination and
-// return all regions at once.
-// It reduces max gRPC message size.
-func PaginateScanRegion(
-	ctx context.Context, client SplitClient, startKey, endKey []byte, limit int,
-) ([]*RegionInfo, error) {
-	if len(endKey) != 0 && bytes.Compare(startKey, endKey) >= 0 {
-		return nil, errors.Annotatef(berrors.ErrRestoreInvalidRange, "startKey >= endKey, startKey %s, endkey %s",
-			hex.EncodeToString(startKey), hex.EncodeToString(endKey))
-	}
-
-	regions := []*RegionInfo{}
-	for {
-		batch, err := client.ScanRegions(ctx, startKey, endKey, limit)
-		if err != nil {
-			return nil, errors.Trace(err)
-		}
-		regions = append(regions, batch...)
-		if len(batch) < limit {
-			// No more region
-			break
-		}
-		startKey = batch[len(batch)-1].Region.GetEndKey()
-		if len(startKey) == 0 ||
-			(len(endKey) > 0 && bytes.Compare(startKey, endKey) >= 0) {
-			// All key space have scanned
-			break
-		}
-	}
-	return regions, nil
+	regionutil.ScatterRegions(ctx, rs.client, newRegions, splitWaitPolicy, regionutil.DefaultScatterRetry)
 }
 
 // getSplitKeys checks if the regions should be split by the new prefix of the rewrites rule and the end key of
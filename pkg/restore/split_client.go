@@ -30,11 +30,11 @@ import (
 	"go.uber.org/zap"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/credentials"
-	"google.golang.org/grpc/status"
 
 	berrors "github.com/pingcap/br/pkg/errors"
 	"github.com/pingcap/br/pkg/httputil"
 	"github.com/pingcap/br/pkg/logutil"
+	"github.com/pingcap/br/pkg/utils"
 )
 
 const (
@@ -81,17 +81,26 @@ type pdClient struct {
 	client     pd.Client
 	tlsConf    *tls.Config
 	storeCache map[uint64]*metapb.Store
+
+	dialTimeout time.Duration
 }
 
 // NewSplitClient returns a client used by RegionSplitter.
-func NewSplitClient(client pd.Client, tlsConf *tls.Config) SplitClient {
+func NewSplitClient(client pd.Client, tlsConf *tls.Config, dialTimeout time.Duration) SplitClient {
 	return &pdClient{
-		client:     client,
-		tlsConf:    tlsConf,
-		storeCache: make(map[uint64]*metapb.Store),
+		client:      client,
+		tlsConf:     tlsConf,
+		storeCache:  make(map[uint64]*metapb.Store),
+		dialTimeout: dialTimeout,
 	}
 }
 
+func (c *pdClient) dial(ctx context.Context, addr string, opt grpc.DialOption) (*grpc.ClientConn, error) {
+	ctx, cancel := utils.ContextWithTimeout(ctx, c.dialTimeout)
+	defer cancel()
+	return grpc.DialContext(ctx, addr, opt, grpc.WithBlock())
+}
+
 func (c *pdClient) GetStore(ctx context.Context, storeID uint64) (*metapb.Store, error) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
@@ -150,7 +159,7 @@ func (c *pdClient) SplitRegion(ctx context.Context, regionInfo *RegionInfo, key
 	if err != nil {
 		return nil, errors.Trace(err)
 	}
-	conn, err := grpc.Dial(store.GetAddress(), grpc.WithInsecure())
+	conn, err := c.dial(ctx, store.GetAddress(), grpc.WithInsecure())
 	if err != nil {
 		return nil, errors.Trace(err)
 	}
@@ -272,7 +281,7 @@ func (c *pdClient) sendSplitRegionRequest(
 		if c.tlsConf != nil {
 			opt = grpc.WithTransportCredentials(credentials.NewTLS(c.tlsConf))
 		}
-		conn, err := grpc.Dial(store.GetAddress(), opt)
+		conn, err := c.dial(ctx, store.GetAddress(), opt)
 		if err != nil {
 			return nil, multierr.Append(splitErrors, err)
 		}
@@ -500,52 +509,3 @@ func checkRegionEpoch(new, old *RegionInfo) bool {
 		new.Region.GetRegionEpoch().GetVersion() == old.Region.GetRegionEpoch().GetVersion() &&
 		new.Region.GetRegionEpoch().GetConfVer() == old.Region.GetRegionEpoch().GetConfVer()
 }
-
-type scatterBackoffer struct {
-	attempt     int
-	baseBackoff time.Duration
-}
-
-func (b *scatterBackoffer) exponentialBackoff() time.Duration {
-	bo := b.baseBackoff
-	b.attempt--
-	if b.attempt == 0 {
-		return 0
-	}
-	b.baseBackoff *= 2
-	return bo
-}
-
-func (b *scatterBackoffer) giveUp() time.Duration {
-	b.attempt = 0
-	return 0
-}
-
-// NextBackoff returns a duration to wait before retrying again
-func (b *scatterBackoffer) NextBackoff(err error) time.Duration {
-	// There are 3 type of reason that PD would reject a `scatter` request:
-	// (1) region %d has no leader
-	// (2) region %d is hot
-	// (3) region %d is not fully replicated
-	//
-	// (2) shouldn't happen in a recently splitted region.
-	// (1) and (3) might happen, and should be retried.
-	grpcErr := status.Convert(err)
-	if grpcErr == nil {
-		return b.giveUp()
-	}
-	if strings.Contains(grpcErr.Message(), "is not fully replicated") {
-		log.Info("scatter region failed, retring", logutil.ShortError(err), zap.Int("attempt-remain", b.attempt))
-		return b.exponentialBackoff()
-	}
-	if strings.Contains(grpcErr.Message(), "has no leader") {
-		log.Info("scatter region failed, retring", logutil.ShortError(err), zap.Int("attempt-remain", b.attempt))
-		return b.exponentialBackoff()
-	}
-	return b.giveUp()
-}
-
-// Attempt returns the remain attempt times
-func (b *scatterBackoffer) Attempt() int {
-	return b.attempt
-}
@@ -0,0 +1,110 @@
+// Copyright 2021 PingCAP, Inc. Licensed under Apache-2.0.
+
+package restore
+
+import (
+	"context"
+	"crypto/tls"
+
+	"github.com/docker/go-units"
+	"github.com/pingcap/errors"
+	"github.com/pingcap/kvproto/pkg/metapb"
+	"github.com/pingcap/log"
+	"go.uber.org/zap"
+
+	"github.com/pingcap/br/pkg/conn"
+	berrors "github.com/pingcap/br/pkg/errors"
+)
+
+// SplitConfig is the size and the key count at which TiKV splits a region.
+// The small ranges of the backup are merged up to them before splitting, so
+// the restored regions are about as large as the regions TiKV would split.
+type SplitConfig struct {
+	SplitSizeBytes uint64
+	SplitKeyCount  uint64
+}
+
+// DefaultSplitConfig is the split config of TiKV by default, which is used
+// when the config of the stores can't be read.
+var DefaultSplitConfig = SplitConfig{
+	SplitSizeBytes: DefaultMergeRegionSizeBytes,
+	SplitKeyCount:  DefaultMergeRegionKeyCount,
+}
+
+// FetchStoreSplitConfig reads `coprocessor.region-split-size` and
+// `coprocessor.region-split-keys` of the TiKV store from the config exposed by
+// its status address. The split keys are derived from `region-max-keys` if
+// they are missing, as TiKV splits at 2/3 of the max keys by default.
+func FetchStoreSplitConfig(ctx context.Context, tlsConf *tls.Config, store *metapb.Store) (SplitConfig, error) {
+	var config struct {
+		Coprocessor struct {
+			RegionSplitSize string `json:"region-split-size"`
+			RegionSplitKeys uint64 `json:"region-split-keys"`
+			RegionMaxKeys   uint64 `json:"region-max-keys"`
+		} `json:"coprocessor"`
+	}
+	if err := fetchStoreConfig(ctx, tlsConf, store, &config); err != nil {
+		return SplitConfig{}, errors.Trace(err)
+	}
+	if config.Coprocessor.RegionSplitSize == "" {
+		return SplitConfig{}, errors.Annotatef(berrors.ErrUnknown,
+			"store %d has no coprocessor.region-split-size", store.GetId())
+	}
+	size, err := units.RAMInBytes(config.Coprocessor.RegionSplitSize)
+	if err != nil || size <= 0 {
+		return SplitConfig{}, errors.Annotatef(berrors.ErrUnknown,
+			"store %d has invalid coprocessor.region-split-size %q", store.GetId(), config.Coprocessor.RegionSplitSize)
+	}
+	keys := config.Coprocessor.RegionSplitKeys
+	if keys == 0 {
+		keys = config.Coprocessor.RegionMaxKeys / 3 * 2
+	}
+	if keys == 0 {
+		return SplitConfig{}, errors.Annotatef(berrors.ErrUnknown,
+			"store %d has no coprocessor.region-split-keys", store.GetId())
+	}
+	return SplitConfig{SplitSizeBytes: uint64(size), SplitKeyCount: keys}, nil
+}
+
+// FetchSplitConfig reads the split config of the stores, and returns the
+// smallest one, so the merged ranges aren't split again by any store. It
+// returns DefaultSplitConfig if the config of no store can be read.
+func FetchSplitConfig(ctx context.Context, tlsConf *tls.Config, stores []*metapb.Store) SplitConfig {
+	var result SplitConfig
+	for _, store := range stores {
+		config, err := FetchStoreSplitConfig(ctx, tlsConf, store)
+		if err != nil {
+			log.Warn("failed to read the split config of store, skip it",
+				zap.Uint64("store", store.GetId()), zap.Error(err))
+			continue
+		}
+		if result.SplitSizeBytes == 0 || config.SplitSizeBytes < result.SplitSizeBytes {
+			result.SplitSizeBytes = config.SplitSizeBytes
+		}
+		if result.SplitKeyCount == 0 || config.SplitKeyCount < result.SplitKeyCount {
+			result.SplitKeyCount = config.SplitKeyCount
+		}
+	}
+	if result.SplitSizeBytes == 0 {
+		log.Warn("failed to read the split config of all stores, use the default",
+			zap.Uint64("split-size", DefaultSplitConfig.SplitSizeBytes),
+			zap.Uint64("split-keys", DefaultSplitConfig.SplitKeyCount))
+		return DefaultSplitConfig
+	}
+	return result
+}
+
+// GetSplitConfig reads the split config of the TiKV stores of the cluster.
+func (rc *Client) GetSplitConfig(ctx context.Context) (SplitConfig, error) {
+	if rc.mockCluster {
+		return DefaultSplitConfig, nil
+	}
+	stores, err := conn.GetAllTiKVStores(ctx, rc.pdClient, conn.SkipTiFlash)
+	if err != nil {
+		return SplitConfig{}, errors.Trace(err)
+	}
+	config := FetchSplitConfig(ctx, rc.tlsConf, stores)
+	log.Info("read the split config of stores",
+		zap.Uint64("split-size", config.SplitSizeBytes), zap.Uint64("split-keys", config.SplitKeyCount))
+	return config, nil
+}
@@ -0,0 +1,136 @@
+// Copyright 2021 PingCAP, Inc. Licensed under Apache-2.0.
+
+package restore
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+
+	"github.com/pingcap/errors"
+	"github.com/pingcap/kvproto/pkg/metapb"
+	"github.com/pingcap/log"
+	"go.uber.org/zap"
+
+	berrors "github.com/pingcap/br/pkg/errors"
+	"github.com/pingcap/br/pkg/httputil"
+)
+
+// DefaultStoreImportConcurrency is the default `import.num-threads` of TiKV,
+// which is used when the config of a store can't be read.
+const DefaultStoreImportConcurrency = 8
+
+// storeLimiter limits the in-flight download and ingest requests of each
+// store, so they don't queue up in the import thread pool of TiKV.
+type storeLimiter struct {
+	mu           sync.Mutex
+	slots        map[uint64]chan struct{}
+	limits       map[uint64]int
+	defaultLimit int
+}
+
+func newStoreLimiter(limits map[uint64]int, defaultLimit int) *storeLimiter {
+	return &storeLimiter{
+		slots:        make(map[uint64]chan struct{}),
+		limits:       limits,
+		defaultLimit: defaultLimit,
+	}
+}
+
+func (l *storeLimiter) storeSlots(storeID uint64) chan struct{} {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	slots, ok := l.slots[storeID]
+	if !ok {
+		limit, ok := l.limits[storeID]
+		if !ok || limit <= 0 {
+			// the store joins after the limits are read.
+			limit = l.defaultLimit
+		}
+		slots = make(chan struct{}, limit)
+		l.slots[storeID] = slots
+	}
+	return slots
+}
+
+// acquire waits for a slot of the store, and returns the function releasing
+// it. The nil limiter doesn't limit anything.
+func (l *storeLimiter) acquire(ctx context.Context, storeID uint64) (func(), error) {
+	if l == nil {
+		return func() {}, nil
+	}
+	slots := l.storeSlots(storeID)
+	select {
+	case <-ctx.Done():
+		return nil, errors.Trace(ctx.Err())
+	case slots <- struct{}{}:
+	}
+	return func() { <-slots }, nil
+}
+
+// fetchStoreConfig decodes the config exposed by the status address of the
+// TiKV store into config.
+func fetchStoreConfig(ctx context.Context, tlsConf *tls.Config, store *metapb.Store, config interface{}) error {
+	if store.GetStatusAddress() == "" {
+		return errors.Annotatef(berrors.ErrInvalidArgument, "store %d has no status address", store.GetId())
+	}
+	scheme := "http"
+	if tlsConf != nil {
+		scheme = "https"
+	}
+	url := fmt.Sprintf("%s://%s/config", scheme, store.GetStatusAddress())
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	resp, err := httputil.NewClient(tlsConf).Do(req)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return errors.Annotatef(berrors.ErrUnknown, "get %s: %s", url, resp.Status)
+	}
+	if err = json.NewDecoder(resp.Body).Decode(config); err != nil {
+		return errors.Annotatef(err, "decode the config of store %d", store.GetId())
+	}
+	return nil
+}
+
+// FetchStoreImportConcurrency reads `import.num-threads` of the TiKV store
+// from the config exposed by its status address.
+func FetchStoreImportConcurrency(ctx context.Context, tlsConf *tls.Config, store *metapb.Store) (int, error) {
+	var config struct {
+		Import struct {
+			NumThreads int `json:"num-threads"`
+		} `json:"import"`
+	}
+	if err := fetchStoreConfig(ctx, tlsConf, store, &config); err != nil {
+		return 0, errors.Trace(err)
+	}
+	if config.Import.NumThreads <= 0 {
+		return 0, errors.Annotatef(berrors.ErrUnknown, "store %d has no import.num-threads", store.GetId())
+	}
+	return config.Import.NumThreads, nil
+}
+
+// fetchStoreImportConcurrencies reads the import concurrency of the stores,
+// the stores whose config can't be read use DefaultStoreImportConcurrency.
+func fetchStoreImportConcurrencies(ctx context.Context, tlsConf *tls.Config, stores []*metapb.Store) map[uint64]int {
+	limits := make(map[uint64]int, len(stores))
+	for _, store := range stores {
+		n, err := FetchStoreImportConcurrency(ctx, tlsConf, store)
+		if err != nil {
+			log.Warn("failed to read the import concurrency of store, use the default",
+				zap.Uint64("store", store.GetId()),
+				zap.Int("default", DefaultStoreImportConcurrency),
+				zap.Error(err))
+			n = DefaultStoreImportConcurrency
+		}
+		limits[store.GetId()] = n
+	}
+	return limits
+}
@@ -0,0 +1,80 @@
+// Copyright 2021 PingCAP, Inc. Licensed under Apache-2.0.
+
+package restore_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+
+	"github.com/docker/go-units"
+	. "github.com/pingcap/check"
+	"github.com/pingcap/kvproto/pkg/metapb"
+
+	"github.com/pingcap/br/pkg/restore"
+)
+
+var _ = Suite(&testStoreLimitSuite{})
+
+type testStoreLimitSuite struct{}
+
+func (s *testStoreLimitSuite) TestFetchStoreImportConcurrency(c *C) {
+	config := `{"import": {"num-threads": 4}}`
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		c.Assert(req.URL.Path, Equals, "/config")
+		_, _ = w.Write([]byte(config))
+	}))
+	defer server.Close()
+
+	ctx := context.Background()
+	store := &metapb.Store{Id: 1, StatusAddress: strings.TrimPrefix(server.URL, "http://")}
+	n, err := restore.FetchStoreImportConcurrency(ctx, nil, store)
+	c.Assert(err, IsNil)
+	c.Assert(n, Equals, 4)
+
+	config = `{"import": {}}`
+	_, err = restore.FetchStoreImportConcurrency(ctx, nil, store)
+	c.Assert(err, ErrorMatches, ".*no import.num-threads.*")
+
+	_, err = restore.FetchStoreImportConcurrency(ctx, nil, &metapb.Store{Id: 2})
+	c.Assert(err, ErrorMatches, ".*no status address.*")
+}
+
+func (s *testStoreLimitSuite) TestFetchSplitConfig(c *C) {
+	configs := map[uint64]string{
+		1: `{"coprocessor": {"region-split-size": "96MiB", "region-split-keys": 960000}}`,
+		2: `{"coprocessor": {"region-split-size": "64MiB", "region-max-keys": 1440000}}`,
+		3: `{"coprocessor": {}}`,
+	}
+	servers := make(map[uint64]*httptest.Server)
+	for id, config := range configs {
+		config := config
+		servers[id] = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			c.Assert(req.URL.Path, Equals, "/config")
+			_, _ = w.Write([]byte(config))
+		}))
+		defer servers[id].Close()
+	}
+	ctx := context.Background()
+	store := func(id uint64) *metapb.Store {
+		return &metapb.Store{Id: id, StatusAddress: strings.TrimPrefix(servers[id].URL, "http://")}
+	}
+
+	config, err := restore.FetchStoreSplitConfig(ctx, nil, store(1))
+	c.Assert(err, IsNil)
+	c.Assert(config, Equals, restore.SplitConfig{SplitSizeBytes: 96 * units.MiB, SplitKeyCount: 960000})
+	config, err = restore.FetchStoreSplitConfig(ctx, nil, store(2))
+	c.Assert(err, IsNil)
+	c.Assert(config, Equals, restore.SplitConfig{SplitSizeBytes: 64 * units.MiB, SplitKeyCount: 960000})
+	_, err = restore.FetchStoreSplitConfig(ctx, nil, store(3))
+	c.Assert(err, ErrorMatches, ".*no coprocessor.region-split-size.*")
+
+	// the smallest config is used, and the stores failed to read are skipped.
+	config = restore.FetchSplitConfig(ctx, nil, []*metapb.Store{
+		store(1), store(2), store(3), {Id: 4},
+	})
+	c.Assert(config, Equals, restore.SplitConfig{SplitSizeBytes: 64 * units.MiB, SplitKeyCount: 960000})
+	config = restore.FetchSplitConfig(ctx, nil, []*metapb.Store{store(3), {Id: 4}})
+	c.Assert(config, Equals, restore.DefaultSplitConfig)
+}
@@ -0,0 +1,282 @@
+// Copyright 2021 PingCAP, Inc. Licensed under Apache-2.0.
+
+package restore
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pingcap/errors"
+	"github.com/pingcap/kvproto/pkg/metapb"
+	"github.com/pingcap/log"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+	"go.uber.org/zap"
+
+	berrors "github.com/pingcap/br/pkg/errors"
+	"github.com/pingcap/br/pkg/httputil"
+	"github.com/pingcap/br/pkg/summary"
+)
+
+// The metrics of TiKV read from its status address.
+const (
+	// tikvPendingTaskMetric is the gauge of the pending tasks of the thread
+	// pools, the import thread pool is named by tikvImportPoolPrefix.
+	tikvPendingTaskMetric = "tikv_futurepool_pending_task_total"
+	tikvImportPoolPrefix  = "sst-importer"
+	// tikvImportRPCMetric is the histogram of the import RPCs, the ingest
+	// requests are labeled by tikvIngestRequest.
+	tikvImportRPCMetric = "tikv_import_rpc_duration"
+	tikvIngestRequest   = "ingest"
+	// tikvApplyLogMetric is the histogram of applying the raft logs.
+	tikvApplyLogMetric = "tikv_raftstore_apply_log_duration_seconds"
+)
+
+// StoreImportMetrics is a sample of the import metrics of a TiKV store. The
+// durations are cumulative, so the average duration of a period is the
+// difference of the seconds divided by the difference of the counts.
+type StoreImportMetrics struct {
+	StoreID      uint64
+	PendingTasks float64
+
+	IngestCount   uint64
+	IngestSeconds float64
+	ApplyCount    uint64
+	ApplySeconds  float64
+}
+
+// ParseStoreImportMetrics parses the import metrics from the metrics of a
+// TiKV store in the prometheus text format. The missing metrics are zero.
+func ParseStoreImportMetrics(r io.Reader) (*StoreImportMetrics, error) {
+	var parser expfmt.TextParser
+	families, err := parser.TextToMetricFamilies(r)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	metrics := &StoreImportMetrics{}
+	for _, m := range families[tikvPendingTaskMetric].GetMetric() {
+		if strings.HasPrefix(metricLabel(m, "name"), tikvImportPoolPrefix) {
+			metrics.PendingTasks += m.GetGauge().GetValue()
+		}
+	}
+	for _, m := range families[tikvImportRPCMetric].GetMetric() {
+		if metricLabel(m, "request") == tikvIngestRequest {
+			metrics.IngestCount += m.GetHistogram().GetSampleCount()
+			metrics.IngestSeconds += m.GetHistogram().GetSampleSum()
+		}
+	}
+	for _, m := range families[tikvApplyLogMetric].GetMetric() {
+		metrics.ApplyCount += m.GetHistogram().GetSampleCount()
+		metrics.ApplySeconds += m.GetHistogram().GetSampleSum()
+	}
+	return metrics, nil
+}
+
+func metricLabel(m *dto.Metric, name string) string {
+	for _, label := range m.GetLabel() {
+		if label.GetName() == name {
+			return label.GetValue()
+		}
+	}
+	return ""
+}
+
+// FetchStoreImportMetrics reads the import metrics of the TiKV store from the
+// metrics exposed by its status address.
+func FetchStoreImportMetrics(ctx context.Context, tlsConf *tls.Config, store *metapb.Store) (*StoreImportMetrics, error) {
+	if store.GetStatusAddress() == "" {
+		return nil, errors.Annotatef(berrors.ErrInvalidArgument, "store %d has no status address", store.GetId())
+	}
+	scheme := "http"
+	if tlsConf != nil {
+		scheme = "https"
+	}
+	url := fmt.Sprintf("%s://%s/metrics", scheme, store.GetStatusAddress())
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	resp, err := httputil.NewClient(tlsConf).Do(req)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.Annotatef(berrors.ErrUnknown, "get %s: %s", url, resp.Status)
+	}
+	metrics, err := ParseStoreImportMetrics(resp.Body)
+	if err != nil {
+		return nil, errors.Annotatef(err, "parse the metrics of store %d", store.GetId())
+	}
+	metrics.StoreID = store.GetId()
+	return metrics, nil
+}
+
+// StoreImportSummary is the import metrics of a TiKV store during the
+// restore.
+type StoreImportSummary struct {
+	StoreID           uint64        `json:"store-id"`
+	MaxPendingTasks   float64       `json:"max-pending-tasks"`
+	Ingests           uint64        `json:"ingests"`
+	AvgIngestDuration time.Duration `json:"avg-ingest-duration"`
+	AvgApplyDuration  time.Duration `json:"avg-apply-duration"`
+}
+
+// StoreMetricsCollector samples the import metrics of the TiKV stores during
+// the restore, so the bottleneck in TiKV, e.g. the ingest requests queue up
+// or the raft logs apply slowly, can be told from the one in BR. The samples
+// are exported to the metrics of BR, and summarized after the restore.
+type StoreMetricsCollector struct {
+	tlsConf  *tls.Config
+	stores   []*metapb.Store
+	interval time.Duration
+
+	mu          sync.Mutex
+	first       map[uint64]*StoreImportMetrics
+	last        map[uint64]*StoreImportMetrics
+	maxPending  map[uint64]float64
+	warnedStore map[uint64]bool
+}
+
+// NewStoreMetricsCollector returns the collector sampling the stores every
+// interval.
+func NewStoreMetricsCollector(tlsConf *tls.Config, stores []*metapb.Store, interval time.Duration) *StoreMetricsCollector {
+	return &StoreMetricsCollector{
+		tlsConf:     tlsConf,
+		stores:      stores,
+		interval:    interval,
+		first:       make(map[uint64]*StoreImportMetrics),
+		last:        make(map[uint64]*StoreImportMetrics),
+		maxPending:  make(map[uint64]float64),
+		warnedStore: make(map[uint64]bool),
+	}
+}
+
+// Run samples the stores until the context is done, the last sample is taken
+// when the context is done.
+func (c *StoreMetricsCollector) Run(ctx context.Context) {
+	c.Sample(ctx)
+	ticker := time.NewTicker(c.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			// the context is done, sample with a fresh one.
+			sampleCtx, cancel := context.WithTimeout(context.Background(), c.interval)
+			c.Sample(sampleCtx)
+			cancel()
+			return
+		case <-ticker.C:
+			c.Sample(ctx)
+		}
+	}
+}
+
+// Sample samples the import metrics of all stores once. The stores whose
+// metrics can't be read are skipped with a warning.
+func (c *StoreMetricsCollector) Sample(ctx context.Context) {
+	for _, store := range c.stores {
+		metrics, err := FetchStoreImportMetrics(ctx, c.tlsConf, store)
+		if err != nil {
+			c.mu.Lock()
+			warned := c.warnedStore[store.GetId()]
+			c.warnedStore[store.GetId()] = true
+			c.mu.Unlock()
+			if !warned {
+				log.Warn("failed to read the import metrics of store",
+					zap.Uint64("store", store.GetId()), zap.Error(err))
+			}
+			continue
+		}
+		c.update(metrics)
+	}
+}
+
+func (c *StoreMetricsCollector) update(metrics *StoreImportMetrics) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	storeID := metrics.StoreID
+	if _, ok := c.first[storeID]; !ok {
+		c.first[storeID] = metrics
+	}
+	if metrics.PendingTasks > c.maxPending[storeID] {
+		c.maxPending[storeID] = metrics.PendingTasks
+	}
+	label := strconv.FormatUint(storeID, 10)
+	storeImportPendingGauge.WithLabelValues(label).Set(metrics.PendingTasks)
+	if last, ok := c.last[storeID]; ok {
+		ingest := avgDuration(last.IngestCount, last.IngestSeconds, metrics.IngestCount, metrics.IngestSeconds)
+		storeIngestDurationGauge.WithLabelValues(label).Set(ingest.Seconds())
+		apply := avgDuration(last.ApplyCount, last.ApplySeconds, metrics.ApplyCount, metrics.ApplySeconds)
+		storeApplyDurationGauge.WithLabelValues(label).Set(apply.Seconds())
+	}
+	c.last[storeID] = metrics
+}
+
+// avgDuration returns the average duration between two samples of a
+// cumulative histogram, 0 if nothing is observed, e.g. the store restarts.
+func avgDuration(count1 uint64, seconds1 float64, count2 uint64, seconds2 float64) time.Duration {
+	if count2 <= count1 || seconds2 < seconds1 {
+		return 0
+	}
+	return time.Duration((seconds2 - seconds1) / float64(count2-count1) * float64(time.Second))
+}
+
+// Summary returns the import metrics of the stores between the first and the
+// last samples, sorted by the store IDs.
+func (c *StoreMetricsCollector) Summary() []StoreImportSummary {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	summaries := make([]StoreImportSummary, 0, len(c.last))
+	for storeID, last := range c.last {
+		first := c.first[storeID]
+		s := StoreImportSummary{
+			StoreID:           storeID,
+			MaxPendingTasks:   c.maxPending[storeID],
+			AvgIngestDuration: avgDuration(first.IngestCount, first.IngestSeconds, last.IngestCount, last.IngestSeconds),
+			AvgApplyDuration:  avgDuration(first.ApplyCount, first.ApplySeconds, last.ApplyCount, last.ApplySeconds),
+		}
+		if last.IngestCount > first.IngestCount {
+			s.Ingests = last.IngestCount - first.IngestCount
+		}
+		summaries = append(summaries, s)
+	}
+	sort.Slice(summaries, func(i, j int) bool {
+		return summaries[i].StoreID < summaries[j].StoreID
+	})
+	return summaries
+}
+
+// CollectSummary logs the summary of every store, and collects the slowest
+// store into the summary of the restore.
+func (c *StoreMetricsCollector) CollectSummary(ctx context.Context) {
+	summaries := c.Summary()
+	if len(summaries) == 0 {
+		return
+	}
+	log.Info("the import metrics of the stores during restore", zap.Any("stores", summaries))
+	var maxPending float64
+	var maxIngest, maxApply time.Duration
+	for _, s := range summaries {
+		if s.MaxPendingTasks > maxPending {
+			maxPending = s.MaxPendingTasks
+		}
+		if s.AvgIngestDuration > maxIngest {
+			maxIngest = s.AvgIngestDuration
+		}
+		if s.AvgApplyDuration > maxApply {
+			maxApply = s.AvgApplyDuration
+		}
+	}
+	summary.FromContext(ctx).CollectInt("tikv max import pending tasks", int(maxPending))
+	summary.FromContext(ctx).CollectDuration("tikv slowest avg ingest duration", maxIngest)
+	summary.FromContext(ctx).CollectDuration("tikv slowest avg apply duration", maxApply)
+}
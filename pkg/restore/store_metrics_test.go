@@ -0,0 +1,85 @@
+// Copyright 2021 PingCAP, Inc. Licensed under Apache-2.0.
+
+package restore_test
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"time"
+
+	. "github.com/pingcap/check"
+	"github.com/pingcap/kvproto/pkg/metapb"
+
+	"github.com/pingcap/br/pkg/restore"
+)
+
+var _ = Suite(&testStoreMetricsSuite{})
+
+type testStoreMetricsSuite struct{}
+
+func storeMetricsText(pending, ingests int, ingestSeconds float64, applies int, applySeconds float64) string {
+	return fmt.Sprintf(`# TYPE tikv_futurepool_pending_task_total gauge
+tikv_futurepool_pending_task_total{name="sst-importer"} %d
+tikv_futurepool_pending_task_total{name="store-read"} 100
+# TYPE tikv_import_rpc_duration histogram
+tikv_import_rpc_duration_bucket{request="ingest",le="+Inf"} %d
+tikv_import_rpc_duration_sum{request="ingest"} %f
+tikv_import_rpc_duration_count{request="ingest"} %d
+tikv_import_rpc_duration_bucket{request="download",le="+Inf"} 1000
+tikv_import_rpc_duration_sum{request="download"} 1000
+tikv_import_rpc_duration_count{request="download"} 1000
+# TYPE tikv_raftstore_apply_log_duration_seconds histogram
+tikv_raftstore_apply_log_duration_seconds_bucket{le="+Inf"} %d
+tikv_raftstore_apply_log_duration_seconds_sum %f
+tikv_raftstore_apply_log_duration_seconds_count %d
+`, pending, ingests, ingestSeconds, ingests, applies, applySeconds, applies)
+}
+
+func (s *testStoreMetricsSuite) TestParseStoreImportMetrics(c *C) {
+	metrics, err := restore.ParseStoreImportMetrics(strings.NewReader(storeMetricsText(3, 10, 5, 100, 2)))
+	c.Assert(err, IsNil)
+	c.Assert(metrics.PendingTasks, Equals, float64(3))
+	c.Assert(metrics.IngestCount, Equals, uint64(10))
+	c.Assert(metrics.IngestSeconds, Equals, float64(5))
+	c.Assert(metrics.ApplyCount, Equals, uint64(100))
+	c.Assert(metrics.ApplySeconds, Equals, float64(2))
+
+	metrics, err = restore.ParseStoreImportMetrics(strings.NewReader(""))
+	c.Assert(err, IsNil)
+	c.Assert(*metrics, DeepEquals, restore.StoreImportMetrics{})
+}
+
+func (s *testStoreMetricsSuite) TestStoreMetricsCollector(c *C) {
+	samples := []string{
+		storeMetricsText(1, 10, 5, 100, 2),
+		storeMetricsText(8, 30, 25, 300, 6),
+		storeMetricsText(2, 40, 35, 400, 8),
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		c.Assert(req.URL.Path, Equals, "/metrics")
+		_, _ = w.Write([]byte(samples[0]))
+		samples = samples[1:]
+	}))
+	defer server.Close()
+
+	ctx := context.Background()
+	stores := []*metapb.Store{
+		{Id: 1, StatusAddress: strings.TrimPrefix(server.URL, "http://")},
+		// the store without status address is skipped.
+		{Id: 2},
+	}
+	collector := restore.NewStoreMetricsCollector(nil, stores, time.Second)
+	for i := 0; i < 3; i++ {
+		collector.Sample(ctx)
+	}
+	c.Assert(collector.Summary(), DeepEquals, []restore.StoreImportSummary{{
+		StoreID:           1,
+		MaxPendingTasks:   8,
+		Ingests:           30,
+		AvgIngestDuration: time.Second,
+		AvgApplyDuration:  20 * time.Millisecond,
+	}})
+}
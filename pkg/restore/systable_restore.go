@@ -31,18 +31,7 @@ var statsTables = map[string]struct{}{
 
 var unRecoverableTable = map[string]struct{}{
 	// some variables in tidb (e.g. gc_safe_point) cannot be recovered.
-	"tidb":             {},
-	"global_variables": {},
-
-	// all user related tables cannot be recovered for now.
-	"columns_priv":  {},
-	"db":            {},
-	"default_roles": {},
-	"global_grants": {},
-	"global_priv":   {},
-	"role_edges":    {},
-	"tables_priv":   {},
-	"user":          {},
+	"tidb": {},
 
 	// gc info don't need to recover.
 	"gc_delete_range":      {},
@@ -52,6 +41,53 @@ var unRecoverableTable = map[string]struct{}{
 	"schema_index_usage": {},
 }
 
+// PrivilegeSystemTables are the system tables of the users, the privileges and
+// the global variables, which are only restored with a SystemTablesPolicy.
+var PrivilegeSystemTables = []string{
+	"columns_priv",
+	"db",
+	"default_roles",
+	"global_grants",
+	"global_priv",
+	"global_variables",
+	"role_edges",
+	"tables_priv",
+	"user",
+}
+
+// SystemTablesPolicy is how the backed up rows of PrivilegeSystemTables are
+// restored into the existing tables.
+type SystemTablesPolicy string
+
+const (
+	// SystemTablesMerge keeps the existing rows conflicting with the restored
+	// ones.
+	SystemTablesMerge SystemTablesPolicy = "merge"
+	// SystemTablesReplace overwrites the existing rows conflicting with the
+	// restored ones.
+	SystemTablesReplace SystemTablesPolicy = "replace"
+)
+
+// Validate checks whether the policy is supported.
+func (p SystemTablesPolicy) Validate() error {
+	switch p {
+	case SystemTablesMerge, SystemTablesReplace:
+		return nil
+	default:
+		return errors.Annotatef(berrors.ErrInvalidArgument,
+			"unknown system tables policy %q, should be one of 'merge|replace'", p)
+	}
+}
+
+func isPrivilegeTable(tableName string) bool {
+	for _, t := range PrivilegeSystemTables {
+		if t == tableName {
+			return true
+		}
+	}
+	return false
+}
+
 func isUnrecoverableTable(tableName string) bool {
 	_, ok := unRecoverableTable[tableName]
 	return ok
@@ -86,6 +122,9 @@ func (rc *Client) RestoreSystemSchemas(ctx context.Context, f filter.Filter) {
 		return
 	}
 
+	// the tables are replaced one by one instead of by ExecuteBatch, because a
+	// table failed to be replaced mustn't stop the others, and each statement
+	// copies the whole table, so the overhead per statement doesn't matter.
 	tablesRestored := make([]string, 0, len(originDatabase.Tables))
 	for _, table := range originDatabase.Tables {
 		tableName := table.Info.Name
@@ -140,10 +179,11 @@ func (rc *Client) afterSystemTablesReplaced(ctx context.Context, tables []string
 		switch {
 		case table == "user":
 			// We cannot execute `rc.dom.NotifyUpdatePrivilege` here, because there isn't
-			// sessionctx.Context provided by the glue.
-			// TODO: update the glue type and allow we retrive a session context from it.
-			err = multierr.Append(err, errors.Annotatef(berrors.ErrUnsupportedSystemTable,
-				"restored user info may not take effect, until you should execute `FLUSH PRIVILEGES` manually"))
+			// sessionctx.Context provided by the glue, but the statement reloads them.
+			if e := rc.db.se.Execute(ctx, "FLUSH PRIVILEGES"); e != nil {
+				err = multierr.Append(err, errors.Annotatef(berrors.ErrUnsupportedSystemTable,
+					"restored user info may not take effect, until you should execute `FLUSH PRIVILEGES` manually: %v", e))
+			}
 		}
 	}
 	return err
@@ -187,6 +227,26 @@ func (rc *Client) replaceTemporaryTableToSystable(ctx context.Context, tableName
 		return berrors.ErrUnsupportedSystemTable.GenWithStack("restoring unsupported `mysql` schema table")
 	}
 
+	if isPrivilegeTable(tableName) {
+		if rc.systemTablesPolicy == "" {
+			return berrors.ErrUnsupportedSystemTable.GenWithStack(
+				"restoring the users, the privileges and the global variables needs --include-system-tables")
+		}
+		if db.ExistingTables[tableName] != nil {
+			verb := "REPLACE"
+			if rc.systemTablesPolicy == SystemTablesMerge {
+				verb = "INSERT IGNORE"
+			}
+			log.Info("table existing, restoring by the system tables policy",
+				zap.String("table", tableName),
+				zap.Stringer("schema", db.Name),
+				zap.String("policy", string(rc.systemTablesPolicy)))
+			return execSQL(fmt.Sprintf("%s INTO %s SELECT * FROM %s;", verb,
+				utils.EncloseDBAndTable(db.Name.L, tableName),
+				utils.EncloseDBAndTable(db.TemporaryName.L, tableName)))
+		}
+	}
+
 	if db.ExistingTables[tableName] != nil {
 		log.Info("table existing, using replace into for restore",
 			zap.String("table", tableName),
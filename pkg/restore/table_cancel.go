@@ -0,0 +1,150 @@
+// Copyright 2021 PingCAP, Inc. Licensed under Apache-2.0.
+
+package restore
+
+import (
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/pingcap/errors"
+	"github.com/pingcap/log"
+	"go.uber.org/zap"
+
+	berrors "github.com/pingcap/br/pkg/errors"
+	"github.com/pingcap/br/pkg/metautil"
+	"github.com/pingcap/br/pkg/utils"
+)
+
+// TableState is the state of a table in a running restore.
+type TableState string
+
+// The states of the tables in a running restore.
+const (
+	TableStateRestoring TableState = "restoring"
+	TableStateCanceled  TableState = "canceled"
+	TableStateFinished  TableState = "finished"
+)
+
+// TableStatus is the state of a table in a running restore.
+type TableStatus struct {
+	DB    string     `json:"db"`
+	Table string     `json:"table"`
+	State TableState `json:"state"`
+}
+
+// TableCanceler tracks the tables of a running restore, so a table can be
+// canceled without failing the whole restore. The canceled table is kept as
+// created, but its pending ranges aren't restored and its checksum is
+// skipped, so it's probably incomplete and should be dropped or restored
+// again.
+//
+// All methods of a nil *TableCanceler are no-op, so callers needn't check
+// whether it's enabled.
+type TableCanceler struct {
+	mu     sync.Mutex
+	states map[string]*TableStatus
+}
+
+// NewTableCanceler creates a TableCanceler of the tables to restore.
+func NewTableCanceler(tables []*metautil.Table) *TableCanceler {
+	canceler := &TableCanceler{states: make(map[string]*TableStatus, len(tables))}
+	for _, table := range tables {
+		canceler.states[tableKey(table.DB.Name.L, table.Info.Name.L)] = &TableStatus{
+			DB:    table.DB.Name.O,
+			Table: table.Info.Name.O,
+			State: TableStateRestoring,
+		}
+	}
+	return canceler
+}
+
+func tableKey(db, table string) string {
+	return utils.EncloseDBAndTable(db, table)
+}
+
+func (canceler *TableCanceler) status(table *metautil.Table) *TableStatus {
+	return canceler.states[tableKey(table.DB.Name.L, table.Info.Name.L)]
+}
+
+// Cancel cancels the restore of the table, the names are case-insensitive.
+func (canceler *TableCanceler) Cancel(db, table string) error {
+	if canceler == nil {
+		return errors.Annotate(berrors.ErrRestoreTableNotCancelable, "no running restore")
+	}
+	canceler.mu.Lock()
+	defer canceler.mu.Unlock()
+	status, ok := canceler.states[tableKey(strings.ToLower(db), strings.ToLower(table))]
+	if !ok {
+		return errors.Annotatef(berrors.ErrRestoreTableNotCancelable, "table %s.%s isn't being restored", db, table)
+	}
+	if status.State == TableStateFinished {
+		return errors.Annotatef(berrors.ErrRestoreTableNotCancelable, "table %s.%s is already restored", db, table)
+	}
+	if status.State != TableStateCanceled {
+		status.State = TableStateCanceled
+		log.Warn("table canceled from the restore", zap.String("db", status.DB), zap.String("table", status.Table))
+	}
+	return nil
+}
+
+// IsCanceled checks whether the table is canceled.
+func (canceler *TableCanceler) IsCanceled(table *metautil.Table) bool {
+	if canceler == nil {
+		return false
+	}
+	canceler.mu.Lock()
+	defer canceler.mu.Unlock()
+	status := canceler.status(table)
+	return status != nil && status.State == TableStateCanceled
+}
+
+// Finish marks the table as restored unless it's canceled, and returns
+// whether it's canceled.
+func (canceler *TableCanceler) Finish(table *metautil.Table) bool {
+	if canceler == nil {
+		return false
+	}
+	canceler.mu.Lock()
+	defer canceler.mu.Unlock()
+	status := canceler.status(table)
+	if status == nil {
+		return false
+	}
+	if status.State == TableStateCanceled {
+		return true
+	}
+	status.State = TableStateFinished
+	return false
+}
+
+// List returns the states of all tables, sorted by the names.
+func (canceler *TableCanceler) List() []TableStatus {
+	if canceler == nil {
+		return nil
+	}
+	canceler.mu.Lock()
+	list := make([]TableStatus, 0, len(canceler.states))
+	for _, status := range canceler.states {
+		list = append(list, *status)
+	}
+	canceler.mu.Unlock()
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].DB != list[j].DB {
+			return list[i].DB < list[j].DB
+		}
+		return list[i].Table < list[j].Table
+	})
+	return list
+}
+
+// Canceled returns the canceled tables.
+func (canceler *TableCanceler) Canceled() []TableStatus {
+	var canceled []TableStatus
+	for _, status := range canceler.List() {
+		if status.State == TableStateCanceled {
+			canceled = append(canceled, status)
+		}
+	}
+	return canceled
+}
@@ -0,0 +1,46 @@
+// Copyright 2021 PingCAP, Inc. Licensed under Apache-2.0.
+
+package restore_test
+
+import (
+	. "github.com/pingcap/check"
+	"github.com/pingcap/parser/model"
+
+	"github.com/pingcap/br/pkg/metautil"
+	"github.com/pingcap/br/pkg/restore"
+)
+
+var _ = Suite(&testTableCancelerSuite{})
+
+type testTableCancelerSuite struct{}
+
+func (s *testTableCancelerSuite) TestTableCanceler(c *C) {
+	db := &model.DBInfo{Name: model.NewCIStr("Test")}
+	t1 := &metautil.Table{DB: db, Info: &model.TableInfo{Name: model.NewCIStr("T1")}}
+	t2 := &metautil.Table{DB: db, Info: &model.TableInfo{Name: model.NewCIStr("t2")}}
+	canceler := restore.NewTableCanceler([]*metautil.Table{t2, t1})
+
+	c.Assert(canceler.Cancel("test", "t3"), ErrorMatches, ".*isn't being restored.*")
+	c.Assert(canceler.Cancel("test", "t1"), IsNil)
+	c.Assert(canceler.Cancel("TEST", "T1"), IsNil)
+	c.Assert(canceler.IsCanceled(t1), IsTrue)
+	c.Assert(canceler.IsCanceled(t2), IsFalse)
+
+	c.Assert(canceler.Finish(t1), IsTrue)
+	c.Assert(canceler.Finish(t2), IsFalse)
+	c.Assert(canceler.Cancel("test", "t2"), ErrorMatches, ".*already restored.*")
+	c.Assert(canceler.List(), DeepEquals, []restore.TableStatus{
+		{DB: "Test", Table: "T1", State: restore.TableStateCanceled},
+		{DB: "Test", Table: "t2", State: restore.TableStateFinished},
+	})
+	c.Assert(canceler.Canceled(), DeepEquals, []restore.TableStatus{
+		{DB: "Test", Table: "T1", State: restore.TableStateCanceled},
+	})
+
+	// a nil canceler never cancels the tables.
+	canceler = nil
+	c.Assert(canceler.Cancel("test", "t1"), ErrorMatches, ".*no running restore.*")
+	c.Assert(canceler.IsCanceled(t1), IsFalse)
+	c.Assert(canceler.Finish(t1), IsFalse)
+	c.Assert(canceler.Canceled(), HasLen, 0)
+}
@@ -0,0 +1,142 @@
+// Copyright 2021 PingCAP, Inc. Licensed under Apache-2.0.
+
+package restore
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pingcap/errors"
+	"github.com/pingcap/log"
+	"go.uber.org/zap"
+
+	"github.com/pingcap/br/pkg/conn"
+	"github.com/pingcap/br/pkg/metautil"
+	"github.com/pingcap/br/pkg/summary"
+	"github.com/pingcap/br/pkg/utils"
+)
+
+const tiFlashReadyCheckInterval = 5 * time.Second
+
+// TiFlashReplica is the TiFlash replica setting of a table of the backup.
+type TiFlashReplica struct {
+	Table          *metautil.Table
+	Count          uint64
+	LocationLabels []string
+}
+
+// TakeTiFlashReplicas removes the TiFlash replica settings from the schemas
+// of the tables, so the TiFlash replicas don't replicate the data during the
+// restore, and returns them to be set by RestoreTiFlashReplicas afterwards.
+func TakeTiFlashReplicas(tables []*metautil.Table) []TiFlashReplica {
+	var replicas []TiFlashReplica
+	for _, table := range tables {
+		info := table.Info.TiFlashReplica
+		table.Info.TiFlashReplica = nil
+		if info == nil || info.Count == 0 {
+			continue
+		}
+		replicas = append(replicas, TiFlashReplica{
+			Table:          table,
+			Count:          info.Count,
+			LocationLabels: info.LocationLabels,
+		})
+	}
+	return replicas
+}
+
+// SQL returns the statement setting the TiFlash replicas of the table.
+func (r TiFlashReplica) SQL() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "ALTER TABLE %s SET TIFLASH REPLICA %d",
+		utils.EncloseDBAndTable(r.Table.DB.Name.O, r.Table.Info.Name.O), r.Count)
+	if len(r.LocationLabels) > 0 {
+		labels := make([]string, 0, len(r.LocationLabels))
+		for _, label := range r.LocationLabels {
+			labels = append(labels, strconv.Quote(label))
+		}
+		b.WriteString(" LOCATION LABELS ")
+		b.WriteString(strings.Join(labels, ", "))
+	}
+	return b.String()
+}
+
+// RestoreTiFlashReplicas sets the TiFlash replicas of the restored tables. The
+// replicas more than the TiFlash stores of the cluster can't be satisfied, so
+// they're skipped. If wait is set, it waits for the TiFlash replicas to catch
+// up with the restored data.
+func (rc *Client) RestoreTiFlashReplicas(ctx context.Context, replicas []TiFlashReplica, wait bool) error {
+	tiFlashStores, err := conn.GetAllTiKVStores(ctx, rc.pdClient, conn.TiFlashOnly)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	var set []TiFlashReplica
+	for _, replica := range replicas {
+		logger := log.With(
+			zap.String("db", replica.Table.DB.Name.O),
+			zap.String("table", replica.Table.Info.Name.O),
+			zap.Uint64("replicas", replica.Count),
+		)
+		if rc.tableCanceler.IsCanceled(replica.Table) {
+			logger.Info("skip setting the TiFlash replicas of the canceled table")
+			continue
+		}
+		if replica.Count > uint64(len(tiFlashStores)) {
+			logger.Warn("skip setting the TiFlash replicas more than the TiFlash stores",
+				zap.Int("tiflash-stores", len(tiFlashStores)))
+			continue
+		}
+		sql := replica.SQL()
+		logger.Info("set the TiFlash replicas of the restored table", zap.String("query", sql))
+		if err = rc.db.se.Execute(ctx, sql); err != nil {
+			return errors.Annotatef(err, "failed to set the TiFlash replicas of %s.%s",
+				replica.Table.DB.Name.O, replica.Table.Info.Name.O)
+		}
+		set = append(set, replica)
+	}
+	summary.FromContext(ctx).CollectInt("tables with TiFlash replicas restored", len(set))
+	if skipped := len(replicas) - len(set); skipped > 0 {
+		summary.FromContext(ctx).CollectInt("tables with TiFlash replicas skipped", skipped)
+	}
+	if !wait || len(set) == 0 {
+		return nil
+	}
+	return errors.Trace(rc.waitTiFlashReady(ctx, set))
+}
+
+// waitTiFlashReady waits until the TiFlash replicas of the tables are
+// available, i.e. they have caught up with TiKV.
+func (rc *Client) waitTiFlashReady(ctx context.Context, replicas []TiFlashReplica) error {
+	start := time.Now()
+	ticker := time.NewTicker(tiFlashReadyCheckInterval)
+	defer ticker.Stop()
+	for {
+		pending := replicas[:0]
+		for _, replica := range replicas {
+			info, err := rc.GetTableSchema(rc.dom, replica.Table.DB.Name, replica.Table.Info.Name)
+			if err != nil {
+				return errors.Trace(err)
+			}
+			if info.TiFlashReplica == nil || !info.TiFlashReplica.Available {
+				pending = append(pending, replica)
+			}
+		}
+		replicas = pending
+		if len(replicas) == 0 {
+			log.Info("the TiFlash replicas of the restored tables are available", zap.Duration("take", time.Since(start)))
+			summary.FromContext(ctx).CollectDuration("wait TiFlash replicas", time.Since(start))
+			return nil
+		}
+		log.Info("wait for the TiFlash replicas of the restored tables",
+			zap.Int("pending", len(replicas)),
+			zap.String("table", utils.EncloseDBAndTable(replicas[0].Table.DB.Name.O, replicas[0].Table.Info.Name.O)))
+		select {
+		case <-ctx.Done():
+			return errors.Trace(ctx.Err())
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,36 @@
+// Copyright 2021 PingCAP, Inc. Licensed under Apache-2.0.
+
+package restore_test
+
+import (
+	. "github.com/pingcap/check"
+	"github.com/pingcap/parser/model"
+
+	"github.com/pingcap/br/pkg/metautil"
+	"github.com/pingcap/br/pkg/restore"
+)
+
+var _ = Suite(&testTiFlashReplicaSuite{})
+
+type testTiFlashReplicaSuite struct{}
+
+func (s *testTiFlashReplicaSuite) TestTakeTiFlashReplicas(c *C) {
+	db := &model.DBInfo{Name: model.NewCIStr("test")}
+	t1 := &metautil.Table{DB: db, Info: &model.TableInfo{
+		Name:           model.NewCIStr("t1"),
+		TiFlashReplica: &model.TiFlashReplicaInfo{Count: 2, LocationLabels: []string{"zone", "host"}, Available: true},
+	}}
+	t2 := &metautil.Table{DB: db, Info: &model.TableInfo{Name: model.NewCIStr("t2")}}
+	t3 := &metautil.Table{DB: db, Info: &model.TableInfo{
+		Name:           model.NewCIStr("t3"),
+		TiFlashReplica: &model.TiFlashReplicaInfo{Count: 1},
+	}}
+
+	replicas := restore.TakeTiFlashReplicas([]*metautil.Table{t1, t2, t3})
+	c.Assert(replicas, HasLen, 2)
+	// the tables are created without the TiFlash replicas.
+	c.Assert(t1.Info.TiFlashReplica, IsNil)
+	c.Assert(t3.Info.TiFlashReplica, IsNil)
+	c.Assert(replicas[0].SQL(), Equals, "ALTER TABLE `test`.`t1` SET TIFLASH REPLICA 2 LOCATION LABELS \"zone\", \"host\"")
+	c.Assert(replicas[1].SQL(), Equals, "ALTER TABLE `test`.`t3` SET TIFLASH REPLICA 1")
+}
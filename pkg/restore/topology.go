@@ -0,0 +1,111 @@
+// Copyright 2021 PingCAP, Inc. Licensed under Apache-2.0.
+
+package restore
+
+import (
+	"bytes"
+	"context"
+	"time"
+
+	"github.com/pingcap/kvproto/pkg/import_sstpb"
+	"github.com/pingcap/log"
+	"go.uber.org/zap"
+
+	"github.com/pingcap/br/pkg/rtree"
+	"github.com/pingcap/br/pkg/summary"
+)
+
+// TopologyRanges converts the region boundaries recorded at backup time to
+// the ranges ending at them, which can be split by RegionSplitter.
+// The boundaries must be sorted. The boundaries not covered by any rewrite
+// rule are dropped, and each range lies in the prefix of one rule, so its
+// start key and end key can be rewritten to the same table.
+func TopologyRanges(boundaries [][]byte, rewriteRules *RewriteRules) []rtree.Range {
+	ranges := make([]rtree.Range, 0, len(boundaries))
+	var lastRule *import_sstpb.RewriteRule
+	var lastKey []byte
+	for _, key := range boundaries {
+		rule := matchRewriteRule(key, rewriteRules)
+		if rule == nil {
+			continue
+		}
+		if rule != lastRule {
+			lastRule = rule
+			lastKey = rule.GetOldKeyPrefix()
+		}
+		// The prefix of the rule is always split.
+		if bytes.Equal(key, lastKey) {
+			continue
+		}
+		ranges = append(ranges, rtree.Range{StartKey: lastKey, EndKey: key})
+		lastKey = key
+	}
+	return ranges
+}
+
+func matchRewriteRule(key []byte, rewriteRules *RewriteRules) *import_sstpb.RewriteRule {
+	for _, rule := range rewriteRules.Data {
+		if bytes.HasPrefix(key, rule.GetOldKeyPrefix()) {
+			return rule
+		}
+	}
+	return nil
+}
+
+// GoPreSplitTables waits for all the tables created, and splits their regions
+// by the region boundaries recorded at backup time in one pass, so the batches
+// are restored into a topology similar to the backup cluster instead of
+// splitting the regions iteratively. The tables are sent to the output channel
+// after the split. Failing to pre-split doesn't fail the restore, since the
+// regions are still split by each batch.
+func (rc *Client) GoPreSplitTables(
+	ctx context.Context,
+	tableStream <-chan CreatedTable,
+	boundaries [][]byte,
+	errCh chan<- error,
+) <-chan CreatedTable {
+	outCh := make(chan CreatedTable)
+	go func() {
+		defer close(outCh)
+		tables := make([]CreatedTable, 0)
+		rewriteRules := &RewriteRules{}
+	collect:
+		for {
+			select {
+			case <-ctx.Done():
+				errCh <- ctx.Err()
+				return
+			case t, ok := <-tableStream:
+				if !ok {
+					break collect
+				}
+				tables = append(tables, t)
+				rewriteRules.Append(*t.RewriteRule)
+			}
+		}
+
+		start := time.Now()
+		ranges := TopologyRanges(boundaries, rewriteRules)
+		log.Info("start to pre-split regions by the backup topology",
+			zap.Int("tables", len(tables)), zap.Int("ranges", len(ranges)))
+		splitter := NewRegionSplitter(rc.toolClient)
+		err := splitter.Split(ctx, ranges, rewriteRules, func([][]byte) {})
+		if err != nil {
+			log.Warn("failed to pre-split regions, fallback to split by batches", zap.Error(err))
+		} else {
+			elapsed := time.Since(start)
+			summary.FromContext(ctx).CollectDuration("pre-split region", elapsed)
+			log.Info("pre-split regions done", zap.Duration("take", elapsed))
+		}
+
+		for _, t := range tables {
+			select {
+			case <-ctx.Done():
+				errCh <- ctx.Err()
+				return
+			case outCh <- t:
+			}
+		}
+	}()
+	return outCh
+}
@@ -355,7 +355,7 @@ func truncateTS(key []byte) []byte {
 
 // SplitRanges splits region by
 // 1. data range after rewrite.
-// 2. rewrite rules.
+// 2. rewrite rules, with the user provided rules of the client first.
 func SplitRanges(
 	ctx context.Context,
 	client *Client,
@@ -366,9 +366,13 @@ func SplitRanges(
 	start := time.Now()
 	defer func() {
 		elapsed := time.Since(start)
-		summary.CollectDuration("split region", elapsed)
+		summary.FromContext(ctx).CollectDuration("split region", elapsed)
 	}()
-	splitter := NewRegionSplitter(NewSplitClient(client.GetPDClient(), client.GetTLSConfig()))
+	rewriteRules, err := client.withExtraRewriteRules(rewriteRules)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	splitter := NewRegionSplitter(client.toolClient)
 
 	return splitter.Split(ctx, ranges, rewriteRules, func(keys [][]byte) {
 		for range keys {
@@ -0,0 +1,250 @@
+// Copyright 2021 PingCAP, Inc. Licensed under Apache-2.0.
+
+package restore
+
+import (
+	"bytes"
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/pingcap/errors"
+	"github.com/pingcap/log"
+	"github.com/pingcap/parser/model"
+	"github.com/pingcap/tidb/kv"
+	"github.com/pingcap/tidb/tablecodec"
+	"github.com/pingcap/tidb/util"
+	"github.com/pingcap/tidb/util/codec"
+	"go.uber.org/zap"
+	"golang.org/x/sync/errgroup"
+
+	"github.com/pingcap/br/pkg/conn"
+	"github.com/pingcap/br/pkg/metautil"
+	"github.com/pingcap/br/pkg/regionutil"
+	"github.com/pingcap/br/pkg/rtree"
+	"github.com/pingcap/br/pkg/summary"
+)
+
+// defaultWarmUpConcurrency is the number of the concurrent region scans and
+// connections of the warm up.
+const defaultWarmUpConcurrency = 16
+
+// WarmUp prepares the restore before the first write. It scans the regions
+// of the key ranges from PD in a bulk pass, and connects to the importers of
+// all stores holding them concurrently, instead of discovering the stores one
+// by one with the first requests. Without any range, e.g. the regions of the
+// new tables don't exist yet, it connects to all TiKV stores.
+//
+// The scanned regions are fed into the region cache, so the first splits and
+// imports of the ranges are served without scanning them from PD again.
+//
+// The warm up is best-effort, the failures are only logged. It's skipped on a
+// mock cluster.
+func (rc *Client) WarmUp(ctx context.Context, ranges []rtree.Range) {
+	if rc.mockCluster {
+		return
+	}
+	start := time.Now()
+	storeIDs, regions, err := rc.involvedStores(ctx, ranges)
+	if err != nil {
+		log.Warn("failed to find the stores to warm up, skip it", zap.Error(err))
+		return
+	}
+	scanned := time.Now()
+	connected := rc.fileImporter.connectStores(ctx, storeIDs)
+	summary.FromContext(ctx).CollectDuration("warm up scan regions", scanned.Sub(start))
+	summary.FromContext(ctx).CollectDuration("warm up connect stores", time.Since(scanned))
+	summary.FromContext(ctx).CollectInt("warm up connected stores", connected)
+	log.Info("restore warmed up",
+		zap.Int("ranges", len(ranges)),
+		zap.Int("regions", regions),
+		zap.Int("stores", len(storeIDs)),
+		zap.Int("connected", connected),
+		zap.Duration("take", time.Since(start)))
+}
+
+// WarmUpRanges returns the key ranges the tables are restored into, by the
+// schemas of the cluster before the tables are created: the ranges of the
+// tables which already exist, and the range after all existing tables, where
+// the regions of the new tables are split from.
+func WarmUpRanges(dbs []*model.DBInfo, tables []*metautil.Table) []rtree.Range {
+	existing := make(map[string]map[string]*model.TableInfo, len(dbs))
+	var maxID int64
+	for _, db := range dbs {
+		// the IDs of the memory tables are far beyond the IDs of the others.
+		if util.IsMemDB(db.Name.L) {
+			continue
+		}
+		existing[db.Name.L] = make(map[string]*model.TableInfo, len(db.Tables))
+		for _, table := range db.Tables {
+			existing[db.Name.L][table.Name.L] = table
+			for _, id := range tableIDs(table) {
+				if id > maxID {
+					maxID = id
+				}
+			}
+		}
+	}
+
+	var ranges []rtree.Range
+	for _, table := range tables {
+		if table.DB == nil || table.Info == nil {
+			continue
+		}
+		info, ok := existing[table.DB.Name.L][table.Info.Name.L]
+		if !ok {
+			continue
+		}
+		for _, id := range tableIDs(info) {
+			ranges = append(ranges, rtree.Range{
+				StartKey: tablecodec.EncodeTablePrefix(id),
+				EndKey:   tablecodec.EncodeTablePrefix(id + 1),
+			})
+		}
+	}
+	return append(ranges, rtree.Range{
+		StartKey: tablecodec.EncodeTablePrefix(maxID + 1),
+		EndKey:   kv.Key(tablecodec.TablePrefix()).PrefixNext(),
+	})
+}
+
+// tableIDs returns the IDs of the table and its partitions.
+func tableIDs(table *model.TableInfo) []int64 {
+	ids := []int64{table.ID}
+	if partitions := table.GetPartitionInfo(); partitions != nil {
+		for _, def := range partitions.Definitions {
+			ids = append(ids, def.ID)
+		}
+	}
+	return ids
+}
+
+// involvedStores returns the stores holding the regions of the ranges and the
+// number of the regions, the regions are fed into the region cache.
+func (rc *Client) involvedStores(ctx context.Context, ranges []rtree.Range) ([]uint64, int, error) {
+	if len(ranges) == 0 {
+		stores, err := conn.GetAllTiKVStores(ctx, rc.pdClient, conn.SkipTiFlash)
+		if err != nil {
+			return nil, 0, errors.Trace(err)
+		}
+		storeIDs := make([]uint64, 0, len(stores))
+		for _, store := range stores {
+			storeIDs = append(storeIDs, store.GetId())
+		}
+		return storeIDs, 0, nil
+	}
+
+	var (
+		mu      sync.Mutex
+		regions int
+		stores  = make(map[uint64]struct{})
+	)
+	eg, ectx := errgroup.WithContext(ctx)
+	workers := make(chan struct{}, defaultWarmUpConcurrency)
+	for _, span := range coalesceRanges(ranges) {
+		span := span
+		select {
+		case <-ectx.Done():
+			if err := eg.Wait(); err != nil {
+				return nil, 0, errors.Trace(err)
+			}
+			return nil, 0, errors.Trace(ctx.Err())
+		case workers <- struct{}{}:
+		}
+		eg.Go(func() error {
+			defer func() { <-workers }()
+			startKey := codec.EncodeBytes(span.StartKey)
+			var endKey []byte
+			if len(span.EndKey) > 0 {
+				endKey = codec.EncodeBytes(span.EndKey)
+			}
+			infos, err := regionutil.PaginateScanRegion(ectx, rc.toolClient, startKey, endKey, ScanRegionPaginationLimit)
+			if err != nil {
+				return errors.Trace(err)
+			}
+			if rc.regionCache != nil {
+				rc.regionCache.Feed(infos)
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			regions += len(infos)
+			for _, info := range infos {
+				for _, peer := range info.Region.GetPeers() {
+					stores[peer.GetStoreId()] = struct{}{}
+				}
+			}
+			return nil
+		})
+	}
+	if err := eg.Wait(); err != nil {
+		return nil, 0, errors.Trace(err)
+	}
+	storeIDs := make([]uint64, 0, len(stores))
+	for id := range stores {
+		storeIDs = append(storeIDs, id)
+	}
+	sort.Slice(storeIDs, func(i, j int) bool { return storeIDs[i] < storeIDs[j] })
+	return storeIDs, regions, nil
+}
+
+// coalesceRanges sorts the ranges and merges the overlapping or adjacent ones,
+// so each part of the key space is scanned once.
+func coalesceRanges(ranges []rtree.Range) []rtree.Range {
+	sorted := append([]rtree.Range(nil), ranges...)
+	sort.Slice(sorted, func(i, j int) bool { return bytes.Compare(sorted[i].StartKey, sorted[j].StartKey) < 0 })
+	merged := make([]rtree.Range, 0, len(sorted))
+	for _, rg := range sorted {
+		if n := len(merged); n > 0 {
+			last := &merged[n-1]
+			if len(last.EndKey) == 0 {
+				// the last range is unbounded and covers the rest.
+				continue
+			}
+			if bytes.Compare(rg.StartKey, last.EndKey) <= 0 {
+				if len(rg.EndKey) == 0 || bytes.Compare(rg.EndKey, last.EndKey) > 0 {
+					last.EndKey = rg.EndKey
+				}
+				continue
+			}
+		}
+		merged = append(merged, rtree.Range{StartKey: rg.StartKey, EndKey: rg.EndKey})
+	}
+	return merged
+}
+
+// connectStores connects to the importers of the stores concurrently, and
+// returns the number of the connected stores.
+func (importer *FileImporter) connectStores(ctx context.Context, storeIDs []uint64) int {
+	var (
+		mu        sync.Mutex
+		connected int
+		wg        sync.WaitGroup
+	)
+	workers := make(chan struct{}, defaultWarmUpConcurrency)
+	for _, storeID := range storeIDs {
+		storeID := storeID
+		select {
+		case <-ctx.Done():
+			wg.Wait()
+			return connected
+		case workers <- struct{}{}:
+		}
+		wg.Add(1)
+		go func() {
+			defer func() {
+				<-workers
+				wg.Done()
+			}()
+			if _, err := importer.importClient.GetImportClient(ctx, storeID); err != nil {
+				log.Warn("failed to connect to the store", zap.Uint64("store", storeID), zap.Error(err))
+				return
+			}
+			mu.Lock()
+			connected++
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+	return connected
+}
@@ -0,0 +1,281 @@
+// Copyright 2021 PingCAP, Inc. Licensed under Apache-2.0.
+
+package restore
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime/pprof"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/pingcap/errors"
+	"github.com/pingcap/log"
+	"go.uber.org/zap"
+
+	berrors "github.com/pingcap/br/pkg/errors"
+	"github.com/pingcap/br/pkg/glue"
+	"github.com/pingcap/br/pkg/logutil"
+	"github.com/pingcap/br/pkg/summary"
+)
+
+// WatchdogPolicy is the action taken when the restore is stuck.
+type WatchdogPolicy string
+
+const (
+	// WatchdogPolicyLog only dumps the diagnostic state.
+	WatchdogPolicyLog WatchdogPolicy = "log"
+	// WatchdogPolicyRetry cancels the in-flight download and ingest requests,
+	// which are then retried by the importer.
+	WatchdogPolicyRetry WatchdogPolicy = "retry"
+	// WatchdogPolicyAbort fails the restore.
+	WatchdogPolicyAbort WatchdogPolicy = "abort"
+)
+
+// Validate checks whether the policy is supported.
+func (p WatchdogPolicy) Validate() error {
+	switch p {
+	case WatchdogPolicyLog, WatchdogPolicyRetry, WatchdogPolicyAbort:
+		return nil
+	default:
+		return errors.Annotatef(berrors.ErrInvalidArgument,
+			"unknown watchdog policy %q, should be one of 'log|retry|abort'", p)
+	}
+}
+
+// watchdogOp is the kind of the requests tracked by the watchdog.
+type watchdogOp string
+
+const (
+	watchdogOpDownload watchdogOp = "download"
+	watchdogOpIngest   watchdogOp = "ingest"
+)
+
+// retryableError returns the error making the importer retry the request
+// canceled by the watchdog.
+func (op watchdogOp) retryableError() *errors.Error {
+	if op == watchdogOpIngest {
+		return berrors.ErrKVIngestFailed
+	}
+	return berrors.ErrKVDownloadFailed
+}
+
+type inflightRequest struct {
+	op       watchdogOp
+	region   *RegionInfo
+	storeID  uint64
+	start    time.Time
+	cancel   context.CancelFunc
+	canceled bool
+}
+
+// Watchdog detects the restore making no progress for a while, e.g. the
+// ingest stuck on a single region. The progress events and the finished
+// requests to TiKV are the heartbeats. When no heartbeat occurs within the
+// timeout, it dumps the in-flight requests and the goroutines, and takes the
+// action of the policy.
+//
+// All methods of a nil *Watchdog are no-op.
+type Watchdog struct {
+	timeout time.Duration
+	policy  WatchdogPolicy
+	dumpDir string
+
+	mu            sync.Mutex
+	phase         string
+	lastHeartbeat time.Time
+	nextID        uint64
+	inflight      map[uint64]*inflightRequest
+}
+
+// NewWatchdog creates a Watchdog, the goroutine profiles are dumped into
+// the dumpDir.
+func NewWatchdog(timeout time.Duration, policy WatchdogPolicy, dumpDir string) *Watchdog {
+	return &Watchdog{
+		timeout:       timeout,
+		policy:        policy,
+		dumpDir:       dumpDir,
+		lastHeartbeat: time.Now(),
+		inflight:      make(map[uint64]*inflightRequest),
+	}
+}
+
+// SetPhase sets the current phase of the restore, which restarts the timer.
+func (w *Watchdog) SetPhase(phase string) {
+	if w == nil {
+		return
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.phase = phase
+	w.lastHeartbeat = time.Now()
+}
+
+// Heartbeat records that the restore is making progress.
+func (w *Watchdog) Heartbeat() {
+	if w == nil {
+		return
+	}
+	w.mu.Lock()
+	w.lastHeartbeat = time.Now()
+	w.mu.Unlock()
+}
+
+// Progress returns a progress sending a heartbeat on each increment.
+func (w *Watchdog) Progress(inner glue.Progress) glue.Progress {
+	if w == nil {
+		return inner
+	}
+	return &watchdogProgress{Progress: inner, watchdog: w}
+}
+
+type watchdogProgress struct {
+	glue.Progress
+	watchdog *Watchdog
+}
+
+func (p *watchdogProgress) Inc() {
+	p.watchdog.Heartbeat()
+	p.Progress.Inc()
+}
+
+// track registers an in-flight request to the region, the request should be
+// sent with the returned context, and its error passed to the returned
+// function. The request canceled by the retry policy fails with a retryable
+// error.
+func (w *Watchdog) track(
+	ctx context.Context,
+	op watchdogOp,
+	region *RegionInfo,
+	storeID uint64,
+) (context.Context, func(error) error) {
+	if w == nil {
+		return ctx, func(err error) error { return err }
+	}
+	cctx, cancel := context.WithCancel(ctx)
+	w.mu.Lock()
+	id := w.nextID
+	w.nextID++
+	req := &inflightRequest{op: op, region: region, storeID: storeID, start: time.Now(), cancel: cancel}
+	w.inflight[id] = req
+	w.mu.Unlock()
+
+	return cctx, func(err error) error {
+		cancel()
+		w.mu.Lock()
+		delete(w.inflight, id)
+		canceled := req.canceled
+		w.lastHeartbeat = time.Now()
+		w.mu.Unlock()
+		if err != nil && canceled && ctx.Err() == nil {
+			return errors.Annotatef(op.retryableError(), "%s of region %d is canceled by the watchdog: %v",
+				op, region.Region.GetId(), err)
+		}
+		return err
+	}
+}
+
+// Run checks the heartbeats until the context is done. The error of the abort
+// policy is sent to the errCh.
+func (w *Watchdog) Run(ctx context.Context, errCh chan<- error) {
+	if w == nil {
+		return
+	}
+	log.Info("restore watchdog started",
+		zap.Duration("timeout", w.timeout), zap.String("policy", string(w.policy)))
+	ticker := time.NewTicker(w.checkInterval())
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if err := w.check(); err != nil {
+			select {
+			case <-ctx.Done():
+			case errCh <- err:
+			}
+			return
+		}
+	}
+}
+
+func (w *Watchdog) checkInterval() time.Duration {
+	interval := w.timeout / 10
+	if interval > time.Minute {
+		interval = time.Minute
+	}
+	if interval <= 0 {
+		interval = time.Millisecond
+	}
+	return interval
+}
+
+// check takes the action of the policy if the restore is stuck.
+func (w *Watchdog) check() error {
+	w.mu.Lock()
+	idle := time.Since(w.lastHeartbeat)
+	if idle < w.timeout {
+		w.mu.Unlock()
+		return nil
+	}
+	phase := w.phase
+	// the next check fires after another timeout.
+	w.lastHeartbeat = time.Now()
+	requests := make([]*inflightRequest, 0, len(w.inflight))
+	for _, req := range w.inflight {
+		requests = append(requests, req)
+	}
+	if w.policy == WatchdogPolicyRetry {
+		for _, req := range requests {
+			req.canceled = true
+			req.cancel()
+		}
+	}
+	w.mu.Unlock()
+
+	sort.Slice(requests, func(i, j int) bool { return requests[i].start.Before(requests[j].start) })
+	log.Warn("restore is stuck",
+		zap.String("phase", phase),
+		zap.Duration("idle", idle),
+		zap.Int("inflight-requests", len(requests)),
+		zap.String("policy", string(w.policy)))
+	for _, req := range requests {
+		log.Warn("in-flight request of stuck restore",
+			zap.String("op", string(req.op)),
+			logutil.Region(req.region.Region),
+			logutil.Key("startKey", req.region.Region.GetStartKey()),
+			logutil.Key("endKey", req.region.Region.GetEndKey()),
+			zap.Uint64("store", req.storeID),
+			zap.Duration("elapsed", time.Since(req.start)))
+	}
+	if path, err := w.dumpGoroutines(); err != nil {
+		log.Warn("failed to dump goroutines", zap.Error(err))
+	} else {
+		log.Warn("goroutines of stuck restore dumped", zap.String("path", path))
+		summary.CollectArtifact("goroutines", path)
+	}
+
+	if w.policy == WatchdogPolicyAbort {
+		return errors.Annotatef(berrors.ErrRestoreStuck, "no progress in phase %q for %s", phase, idle)
+	}
+	return nil
+}
+
+func (w *Watchdog) dumpGoroutines() (string, error) {
+	path := filepath.Join(w.dumpDir,
+		fmt.Sprintf("br-goroutines-%s.txt", time.Now().UTC().Format("20060102T150405Z")))
+	file, err := os.Create(path)
+	if err != nil {
+		return "", errors.Trace(err)
+	}
+	defer file.Close()
+	if err = pprof.Lookup("goroutine").WriteTo(file, 2); err != nil {
+		return "", errors.Trace(err)
+	}
+	return path, nil
+}
@@ -0,0 +1,75 @@
+// Copyright 2021 PingCAP, Inc. Licensed under Apache-2.0.
+
+package restore_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"time"
+
+	. "github.com/pingcap/check"
+
+	berrors "github.com/pingcap/br/pkg/errors"
+	"github.com/pingcap/br/pkg/restore"
+)
+
+var _ = Suite(&testWatchdogSuite{})
+
+type testWatchdogSuite struct{}
+
+type countProgress struct {
+	count int
+}
+
+func (p *countProgress) Inc() {
+	p.count++
+}
+
+func (p *countProgress) Close() {}
+
+func (s *testWatchdogSuite) TestWatchdogAbort(c *C) {
+	c.Assert(restore.WatchdogPolicy("unknown").Validate(), ErrorMatches, ".*unknown watchdog policy.*")
+	c.Assert(restore.WatchdogPolicyRetry.Validate(), IsNil)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	dir := c.MkDir()
+	watchdog := restore.NewWatchdog(200*time.Millisecond, restore.WatchdogPolicyAbort, dir)
+	watchdog.SetPhase("restore data")
+	inner := &countProgress{}
+	progress := watchdog.Progress(inner)
+	errCh := make(chan error, 1)
+	go watchdog.Run(ctx, errCh)
+
+	// the heartbeats keep the watchdog quiet.
+	for i := 0; i < 5; i++ {
+		time.Sleep(100 * time.Millisecond)
+		progress.Inc()
+	}
+	c.Assert(inner.count, Equals, 5)
+	select {
+	case err := <-errCh:
+		c.Fatalf("unexpected error %v", err)
+	default:
+	}
+
+	select {
+	case err := <-errCh:
+		c.Assert(berrors.Is(err, berrors.ErrRestoreStuck), IsTrue)
+		c.Assert(err, ErrorMatches, `.*no progress in phase "restore data".*`)
+	case <-time.After(5 * time.Second):
+		c.Fatal("the stuck restore isn't aborted")
+	}
+	dumps, err := filepath.Glob(filepath.Join(dir, "br-goroutines-*.txt"))
+	c.Assert(err, IsNil)
+	c.Assert(dumps, HasLen, 1)
+	data, err := os.ReadFile(dumps[0])
+	c.Assert(err, IsNil)
+	c.Assert(string(data), Matches, "(?s).*goroutine.*")
+
+	// a nil watchdog is disabled.
+	var disabled *restore.Watchdog
+	c.Assert(disabled.Progress(inner), Equals, inner)
+	disabled.Heartbeat()
+}
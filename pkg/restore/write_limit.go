@@ -0,0 +1,96 @@
+// Copyright 2021 PingCAP, Inc. Licensed under Apache-2.0.
+
+package restore
+
+import (
+	"context"
+	"math"
+	"sync"
+
+	"github.com/pingcap/errors"
+	backuppb "github.com/pingcap/kvproto/pkg/backup"
+	"golang.org/x/time/rate"
+)
+
+// clusterWriteLimiter caps the bytes written to all stores of the cluster
+// per second, apart from the download speed limit of each store. A download
+// request is charged before it's sent, by the bytes of the range it requests,
+// which are written once by each replica of the region. The failed and empty
+// downloads write nothing, so their charges are refunded.
+type clusterWriteLimiter struct {
+	limiter *rate.Limiter
+
+	mu sync.Mutex
+	// credit is the refunded bytes, which are granted before waiting on the
+	// limiter. It's at most the burst of the limiter, so the refunds don't
+	// make a burst longer than a second.
+	credit uint64
+}
+
+// newClusterWriteLimiter returns the limiter writing bytesPerSec bytes per
+// second.
+func newClusterWriteLimiter(bytesPerSec uint64) *clusterWriteLimiter {
+	burst := math.MaxInt32
+	if bytesPerSec < uint64(burst) {
+		burst = int(bytesPerSec)
+	}
+	return &clusterWriteLimiter{limiter: rate.NewLimiter(rate.Limit(bytesPerSec), burst)}
+}
+
+// wait blocks until the bytes are allowed to write. The limiter can't grant
+// more than its burst at once, so the large files are split. The nil limiter
+// doesn't limit anything.
+func (l *clusterWriteLimiter) wait(ctx context.Context, n uint64) error {
+	if l == nil {
+		return nil
+	}
+	l.mu.Lock()
+	granted := n
+	if granted > l.credit {
+		granted = l.credit
+	}
+	l.credit -= granted
+	l.mu.Unlock()
+
+	for granted < n {
+		size := n - granted
+		if burst := uint64(l.limiter.Burst()); size > burst {
+			size = burst
+		}
+		if err := l.limiter.WaitN(ctx, int(size)); err != nil {
+			l.refund(granted)
+			return errors.Trace(err)
+		}
+		granted += size
+	}
+	return nil
+}
+
+// refund returns the bytes charged by wait but not written.
+func (l *clusterWriteLimiter) refund(n uint64) {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.credit += n
+	if burst := uint64(l.limiter.Burst()); l.credit > burst {
+		l.credit = burst
+	}
+}
+
+// regionWriteSize is the bytes written by downloading the range of the file in
+// one of the regions overlapping the file. The keys of the file are assumed to
+// spread evenly over the regions, the files of the old backups without the
+// size use the size of their kvs.
+func regionWriteSize(file *backuppb.File, regions int) uint64 {
+	size := file.GetSize_()
+	if size == 0 {
+		size = file.GetTotalBytes()
+	}
+	if regions <= 1 {
+		return size
+	}
+	// round up, so the small files are still charged.
+	return (size + uint64(regions) - 1) / uint64(regions)
+}
@@ -0,0 +1,55 @@
+// Copyright 2021 PingCAP, Inc. Licensed under Apache-2.0.
+
+package restore
+
+import (
+	"context"
+	"time"
+
+	. "github.com/pingcap/check"
+	backuppb "github.com/pingcap/kvproto/pkg/backup"
+)
+
+var _ = Suite(&testWriteLimitSuite{})
+
+type testWriteLimitSuite struct{}
+
+func (s *testWriteLimitSuite) TestClusterWriteLimiter(c *C) {
+	ctx := context.Background()
+	// the nil limiter doesn't limit anything.
+	var unlimited *clusterWriteLimiter
+	c.Assert(unlimited.wait(ctx, 1<<40), IsNil)
+	unlimited.refund(1 << 40)
+
+	limiter := newClusterWriteLimiter(1000)
+	start := time.Now()
+	c.Assert(limiter.wait(ctx, 1000), IsNil)
+	c.Assert(time.Since(start), Less, 500*time.Millisecond)
+	// the bytes more than the burst are split instead of failing.
+	c.Assert(limiter.wait(ctx, 1500), IsNil)
+	c.Assert(time.Since(start), GreaterEqual, 1400*time.Millisecond)
+
+	// the refunded bytes are granted without waiting, at most the burst.
+	limiter.refund(600)
+	limiter.refund(600)
+	c.Assert(limiter.credit, Equals, uint64(1000))
+	start = time.Now()
+	c.Assert(limiter.wait(ctx, 1000), IsNil)
+	c.Assert(time.Since(start), Less, 500*time.Millisecond)
+	c.Assert(limiter.credit, Equals, uint64(0))
+
+	cctx, cancel := context.WithCancel(ctx)
+	cancel()
+	c.Assert(limiter.wait(cctx, 100), NotNil)
+}
+
+func (s *testWriteLimitSuite) TestRegionWriteSize(c *C) {
+	file := &backuppb.File{Size_: 100, TotalBytes: 400}
+	c.Assert(regionWriteSize(file, 1), Equals, uint64(100))
+	c.Assert(regionWriteSize(file, 0), Equals, uint64(100))
+	// each region is charged by its share of the file, rounded up.
+	c.Assert(regionWriteSize(file, 3), Equals, uint64(34))
+	// the files of the old backups use the size of their kvs.
+	c.Assert(regionWriteSize(&backuppb.File{TotalBytes: 400}, 4), Equals, uint64(100))
+	c.Assert(regionWriteSize(&backuppb.File{}, 4), Equals, uint64(0))
+}
@@ -0,0 +1,183 @@
+// Copyright 2021 PingCAP, Inc. Licensed under Apache-2.0.
+
+package storage
+
+import (
+	"container/list"
+	"context"
+	"strings"
+	"sync"
+
+	"golang.org/x/sync/singleflight"
+)
+
+// cacheObjectSizeRatio limits the size of a cached object to 1/16 of the
+// capacity, so a few large objects won't evict all the small ones.
+const cacheObjectSizeRatio = 16
+
+type cacheEntry struct {
+	name string
+	data []byte
+}
+
+type withCache struct {
+	ExternalStorage
+	capacity int64
+
+	mu      sync.Mutex
+	size    int64
+	lru     *list.List
+	entries map[string]*list.Element
+	// version is increased on each write, so the reads started before the
+	// write won't fill the cache with the stale content.
+	version uint64
+
+	group singleflight.Group
+}
+
+// WithCache returns an ExternalStorage which caches the content of ReadFile
+// in an LRU of at most `capacity` bytes, so the small objects read repeatedly,
+// e.g. the metadata, are fetched only once. The concurrent reads of the same
+// object are merged. Writes through the returned storage invalidate the cache,
+// but the modifications by others are not detected.
+func WithCache(inner ExternalStorage, capacity int64) ExternalStorage {
+	if capacity <= 0 {
+		return inner
+	}
+	return &withCache{
+		ExternalStorage: inner,
+		capacity:        capacity,
+		lru:             list.New(),
+		entries:         make(map[string]*list.Element),
+	}
+}
+
+func (c *withCache) get(name string) ([]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	elem, ok := c.entries[name]
+	if !ok {
+		return nil, false
+	}
+	c.lru.MoveToFront(elem)
+	return elem.Value.(*cacheEntry).data, true
+}
+
+func (c *withCache) put(name string, data []byte, version uint64) {
+	if int64(len(data))*cacheObjectSizeRatio > c.capacity {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if version != c.version {
+		return
+	}
+	if _, ok := c.entries[name]; ok {
+		return
+	}
+	c.entries[name] = c.lru.PushFront(&cacheEntry{name: name, data: data})
+	c.size += int64(len(data))
+	for c.size > c.capacity {
+		c.removeElement(c.lru.Back())
+	}
+}
+
+func (c *withCache) invalidate(name string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.version++
+	if elem, ok := c.entries[name]; ok {
+		c.removeElement(elem)
+	}
+}
+
+func (c *withCache) invalidatePrefix(prefix string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.version++
+	for name, elem := range c.entries {
+		if strings.HasPrefix(name, prefix) {
+			c.removeElement(elem)
+		}
+	}
+}
+
+func (c *withCache) removeElement(elem *list.Element) {
+	entry := c.lru.Remove(elem).(*cacheEntry)
+	delete(c.entries, entry.name)
+	c.size -= int64(len(entry.data))
+}
+
+func (c *withCache) ReadFile(ctx context.Context, name string) ([]byte, error) {
+	data, ok := c.get(name)
+	if !ok {
+		c.mu.Lock()
+		version := c.version
+		c.mu.Unlock()
+		v, err, _ := c.group.Do(name, func() (interface{}, error) {
+			data, err := c.ExternalStorage.ReadFile(ctx, name)
+			if err != nil {
+				return nil, err
+			}
+			c.put(name, data, version)
+			return data, nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		data = v.([]byte)
+	}
+	// the callers may modify the returned content.
+	return append([]byte(nil), data...), nil
+}
+
+func (c *withCache) WriteFile(ctx context.Context, name string, data []byte) error {
+	defer c.invalidate(name)
+	return c.ExternalStorage.WriteFile(ctx, name, data)
+}
+
+func (c *withCache) Create(ctx context.Context, path string) (ExternalFileWriter, error) {
+	c.invalidate(path)
+	writer, err := c.ExternalStorage.Create(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+	return &cacheWriter{ExternalFileWriter: writer, name: path, storage: c}, nil
+}
+
+// CreateUploader creates the uploader of the inner storage, see uploaderCreator.
+func (c *withCache) CreateUploader(ctx context.Context, path string) (ExternalFileWriter, error) {
+	c.invalidate(path)
+	writer, err := createUploader(ctx, c.ExternalStorage, path)
+	if err != nil {
+		return nil, err
+	}
+	return &cacheWriter{ExternalFileWriter: writer, name: path, storage: c}, nil
+}
+
+func (c *withCache) Rename(ctx context.Context, oldName, newName string) error {
+	defer c.invalidate(oldName)
+	defer c.invalidate(newName)
+	return c.ExternalStorage.Rename(ctx, oldName, newName)
+}
+
+func (c *withCache) DeleteFile(ctx context.Context, name string) error {
+	defer c.invalidate(name)
+	return c.ExternalStorage.DeleteFile(ctx, name)
+}
+
+func (c *withCache) DeletePrefix(ctx context.Context, prefix string) error {
+	defer c.invalidatePrefix(prefix)
+	return c.ExternalStorage.DeletePrefix(ctx, prefix)
+}
+
+type cacheWriter struct {
+	ExternalFileWriter
+	name    string
+	storage *withCache
+}
+
+func (w *cacheWriter) Close(ctx context.Context) error {
+	defer w.storage.invalidate(w.name)
+	return w.ExternalFileWriter.Close(ctx)
+}
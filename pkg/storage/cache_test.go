@@ -0,0 +1,78 @@
+// Copyright 2021 PingCAP, Inc. Licensed under Apache-2.0.
+
+package storage
+
+import (
+	"context"
+
+	. "github.com/pingcap/check"
+)
+
+// countingStorage counts the calls of ReadFile.
+type countingStorage struct {
+	ExternalStorage
+	reads map[string]int
+}
+
+func (s *countingStorage) ReadFile(ctx context.Context, name string) ([]byte, error) {
+	s.reads[name]++
+	return s.ExternalStorage.ReadFile(ctx, name)
+}
+
+func (r *testStorageSuite) TestCache(c *C) {
+	ctx := context.Background()
+	local, err := NewLocalStorage(c.MkDir())
+	c.Assert(err, IsNil)
+	inner := &countingStorage{ExternalStorage: local, reads: make(map[string]int)}
+	s := WithCache(inner, 64)
+
+	c.Assert(s.WriteFile(ctx, "a", []byte("1")), IsNil)
+	c.Assert(s.WriteFile(ctx, "b", []byte("2")), IsNil)
+	c.Assert(s.WriteFile(ctx, "large", make([]byte, 8)), IsNil)
+	for i := 0; i < 3; i++ {
+		data, err := s.ReadFile(ctx, "a")
+		c.Assert(err, IsNil)
+		c.Assert(data, DeepEquals, []byte("1"))
+		// modifying the returned content doesn't pollute the cache.
+		data[0] = 'x'
+		_, err = s.ReadFile(ctx, "large")
+		c.Assert(err, IsNil)
+	}
+	c.Assert(inner.reads["a"], Equals, 1)
+	// objects larger than 1/16 of the capacity are not cached.
+	c.Assert(inner.reads["large"], Equals, 3)
+
+	// writes invalidate the cache.
+	c.Assert(s.WriteFile(ctx, "a", []byte("3")), IsNil)
+	data, err := s.ReadFile(ctx, "a")
+	c.Assert(err, IsNil)
+	c.Assert(data, DeepEquals, []byte("3"))
+	c.Assert(inner.reads["a"], Equals, 2)
+
+	w, err := s.Create(ctx, "a")
+	c.Assert(err, IsNil)
+	_, err = w.Write(ctx, []byte("4"))
+	c.Assert(err, IsNil)
+	c.Assert(w.Close(ctx), IsNil)
+	data, err = s.ReadFile(ctx, "a")
+	c.Assert(err, IsNil)
+	c.Assert(data, DeepEquals, []byte("4"))
+	c.Assert(inner.reads["a"], Equals, 3)
+
+	// the least recently used objects are evicted.
+	cache := WithCache(inner, 32).(*withCache)
+	for _, name := range []string{"a", "b"} {
+		cache.put(name, make([]byte, 2), 0)
+	}
+	cache.put("c", make([]byte, 2), 0)
+	_, ok := cache.get("a")
+	c.Assert(ok, IsTrue)
+	for i := 0; i < 15; i++ {
+		cache.put(string(rune('d'+i)), make([]byte, 2), 0)
+	}
+	c.Assert(cache.size, Equals, int64(32))
+	_, ok = cache.get("a")
+	c.Assert(ok, IsTrue)
+	_, ok = cache.get("b")
+	c.Assert(ok, IsFalse)
+}
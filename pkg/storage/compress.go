@@ -6,6 +6,8 @@ import (
 	"bytes"
 	"context"
 	"io"
+	"path"
+	"strings"
 
 	berrors "github.com/pingcap/br/pkg/errors"
 
@@ -26,15 +28,9 @@ func WithCompression(inner ExternalStorage, compressionType CompressType) Extern
 }
 
 func (w *withCompression) Create(ctx context.Context, name string) (ExternalFileWriter, error) {
-	var (
-		writer ExternalFileWriter
-		err    error
-	)
-	if s3Storage, ok := w.ExternalStorage.(*S3Storage); ok {
-		writer, err = s3Storage.CreateUploader(ctx, name)
-	} else {
-		writer, err = w.ExternalStorage.Create(ctx, name)
-	}
+	// the compressed data is buffered here, so it's uploaded directly
+	// instead of being buffered again by the writer of Create.
+	writer, err := createUploader(ctx, w.ExternalStorage, name)
 	if err != nil {
 		return nil, errors.Trace(err)
 	}
@@ -81,8 +77,109 @@ func (w *withCompression) ReadFile(ctx context.Context, name string) ([]byte, er
 	return io.ReadAll(compressBf)
 }
 
+// ReadRange reads a byte range of the uncompressed content. Since compressed
+// streams are not seekable, the skipped prefix is still decompressed.
+func (w *withCompression) ReadRange(ctx context.Context, name string, offset, length int64) ([]byte, error) {
+	reader, err := w.Open(ctx, name)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	defer reader.Close()
+	if _, err = io.CopyN(io.Discard, reader, offset); err != nil {
+		if err == io.EOF { // nolint:errorlint
+			return nil, errors.Annotatef(io.ErrUnexpectedEOF, "offset %d exceeds %s", offset, name)
+		}
+		return nil, errors.Trace(err)
+	}
+	return readRange(reader, length)
+}
+
+// CompressTypeFromName returns the compression of the file by its extension,
+// `.gz` for Gzip and `.zst` or `.zstd` for Zstd.
+func CompressTypeFromName(name string) CompressType {
+	switch strings.ToLower(path.Ext(name)) {
+	case ".gz":
+		return Gzip
+	case ".zst", ".zstd":
+		return Zstd
+	default:
+		return NoCompression
+	}
+}
+
+// TrimCompressExt removes the compression extension from the file name, e.g.
+// `cdclog.gz` is trimmed to `cdclog`.
+func TrimCompressExt(name string) string {
+	if CompressTypeFromName(name) == NoCompression {
+		return name
+	}
+	return strings.TrimSuffix(name, path.Ext(name))
+}
+
+type withDecompression struct {
+	ExternalStorage
+}
+
+// WithDecompression returns an ExternalStorage decompressing the files on read
+// by their extensions, see CompressTypeFromName. The files without these
+// extensions and the writes are passed through.
+func WithDecompression(inner ExternalStorage) ExternalStorage {
+	if _, ok := inner.(*withDecompression); ok {
+		return inner
+	}
+	return &withDecompression{ExternalStorage: inner}
+}
+
+func (w *withDecompression) compression(name string) *withCompression {
+	compressType := CompressTypeFromName(name)
+	if compressType == NoCompression {
+		return nil
+	}
+	return &withCompression{ExternalStorage: w.ExternalStorage, compressType: compressType}
+}
+
+func (w *withDecompression) Open(ctx context.Context, path string) (ExternalFileReader, error) {
+	if c := w.compression(path); c != nil {
+		return c.Open(ctx, path)
+	}
+	return w.ExternalStorage.Open(ctx, path)
+}
+
+func (w *withDecompression) ReadFile(ctx context.Context, name string) ([]byte, error) {
+	if c := w.compression(name); c != nil {
+		data, err := c.ReadFile(ctx, name)
+		return data, errors.Annotatef(err, "failed to decompress %s", name)
+	}
+	return w.ExternalStorage.ReadFile(ctx, name)
+}
+
+func (w *withDecompression) ReadRange(ctx context.Context, name string, offset, length int64) ([]byte, error) {
+	if c := w.compression(name); c != nil {
+		return c.ReadRange(ctx, name, offset, length)
+	}
+	return w.ExternalStorage.ReadRange(ctx, name, offset, length)
+}
+
+// uploaderCreator is implemented by the storages creating the writers which
+// upload the written data directly without buffering, e.g. S3Storage. The
+// decorators wrapped by New implement it by the storage they wrap, so the
+// uploader of the inner storage is still found through them.
+type uploaderCreator interface {
+	CreateUploader(ctx context.Context, name string) (ExternalFileWriter, error)
+}
+
+// createUploader creates an uploader of the storage, or a writer by Create if
+// the storage doesn't support uploaders.
+func createUploader(ctx context.Context, s ExternalStorage, name string) (ExternalFileWriter, error) {
+	if u, ok := s.(uploaderCreator); ok {
+		return u.CreateUploader(ctx, name)
+	}
+	return s.Create(ctx, name)
+}
+
 type compressReader struct {
 	io.ReadCloser
+	file io.Closer
 }
 
 // nolint:interfacer
@@ -96,9 +193,19 @@ func newInterceptReader(fileReader ExternalFileReader, compressType CompressType
 	}
 	return &compressReader{
 		ReadCloser: r,
+		file:       fileReader,
 	}, nil
 }
 
+// Close closes the decompressor and the underlying file.
+func (r *compressReader) Close() error {
+	err := r.ReadCloser.Close()
+	if e := r.file.Close(); err == nil {
+		err = e
+	}
+	return errors.Trace(err)
+}
+
 func (r *compressReader) Seek(_ int64, _ int) (int64, error) {
 	return int64(0), errors.Annotatef(berrors.ErrStorageInvalidConfig, "compressReader doesn't support Seek now")
 }
@@ -3,13 +3,21 @@
 package storage
 
 import (
+	"bytes"
 	"context"
 	"io"
 	"os"
 	"path/filepath"
 	"strings"
+	"time"
 
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
 	. "github.com/pingcap/check"
+	"github.com/pingcap/errors"
+	backuppb "github.com/pingcap/kvproto/pkg/backup"
 )
 
 func (r *testStorageSuite) TestWithCompressReadWriteFile(c *C) {
@@ -40,3 +48,133 @@ func (r *testStorageSuite) TestWithCompressReadWriteFile(c *C) {
 	c.Assert(err, IsNil)
 	c.Assert(string(newContent), Equals, content)
 }
+
+func (r *testStorageSuite) TestWithDecompression(c *C) {
+	dir := c.MkDir()
+	backend, err := ParseBackend("local://"+filepath.ToSlash(dir), nil)
+	c.Assert(err, IsNil)
+	ctx := context.Background()
+	inner, err := Create(ctx, backend, true)
+	c.Assert(err, IsNil)
+	content := "hello,world!"
+	files := map[string]CompressType{
+		"cdclog":        NoCompression,
+		"cdclog.1.gz":   Gzip,
+		"cdclog.2.zst":  Zstd,
+		"cdclog.3.zstd": Zstd,
+	}
+	for name, compressType := range files {
+		c.Assert(CompressTypeFromName(name), Equals, compressType)
+		err = WithCompression(inner, compressType).WriteFile(ctx, name, []byte(content))
+		c.Assert(err, IsNil)
+	}
+	c.Assert(TrimCompressExt("cdclog.1.gz"), Equals, "cdclog.1")
+	c.Assert(TrimCompressExt("cdclog.3.zstd"), Equals, "cdclog.3")
+	c.Assert(TrimCompressExt("cdclog.1"), Equals, "cdclog.1")
+
+	storage := WithDecompression(inner)
+	c.Assert(WithDecompression(storage), Equals, storage)
+	for name := range files {
+		data, err := storage.ReadFile(ctx, name)
+		c.Assert(err, IsNil, Commentf("%s", name))
+		c.Assert(string(data), Equals, content)
+
+		reader, err := storage.Open(ctx, name)
+		c.Assert(err, IsNil)
+		data, err = io.ReadAll(reader)
+		c.Assert(err, IsNil)
+		c.Assert(string(data), Equals, content)
+		c.Assert(reader.Close(), IsNil)
+
+		data, err = storage.ReadRange(ctx, name, 6, 5)
+		c.Assert(err, IsNil)
+		c.Assert(string(data), Equals, "world")
+
+		data, err = storage.ReadRange(ctx, name, int64(len(content)), -1)
+		c.Assert(err, IsNil)
+		c.Assert(data, HasLen, 0)
+		_, err = storage.ReadRange(ctx, name, int64(len(content))+1, -1)
+		c.Assert(errors.Cause(err), Equals, io.ErrUnexpectedEOF)
+	}
+
+	// the files not compressed as their extensions fail to read.
+	err = inner.WriteFile(ctx, "cdclog.4.gz", []byte(content))
+	c.Assert(err, IsNil)
+	_, err = storage.ReadFile(ctx, "cdclog.4.gz")
+	c.Assert(err, ErrorMatches, ".*failed to decompress cdclog.4.gz.*")
+}
+
+// multipartS3 records the parts of the multipart uploads.
+type multipartS3 struct {
+	s3iface.S3API
+	parts     [][]byte
+	completed bool
+}
+
+func (m *multipartS3) CreateMultipartUploadWithContext(
+	_ aws.Context, input *s3.CreateMultipartUploadInput, _ ...request.Option,
+) (*s3.CreateMultipartUploadOutput, error) {
+	return &s3.CreateMultipartUploadOutput{Bucket: input.Bucket, Key: input.Key, UploadId: aws.String("1")}, nil
+}
+
+func (m *multipartS3) UploadPartWithContext(
+	_ aws.Context, input *s3.UploadPartInput, _ ...request.Option,
+) (*s3.UploadPartOutput, error) {
+	data, err := io.ReadAll(input.Body)
+	if err != nil {
+		return nil, err
+	}
+	m.parts = append(m.parts, data)
+	return &s3.UploadPartOutput{}, nil
+}
+
+func (m *multipartS3) CompleteMultipartUploadWithContext(
+	aws.Context, *s3.CompleteMultipartUploadInput, ...request.Option,
+) (*s3.CompleteMultipartUploadOutput, error) {
+	m.completed = true
+	return &s3.CompleteMultipartUploadOutput{}, nil
+}
+
+// createCounter counts the writers created by Create and CreateUploader.
+type createCounter struct {
+	*S3Storage
+	creates, uploads int
+}
+
+func (s *createCounter) Create(ctx context.Context, name string) (ExternalFileWriter, error) {
+	s.creates++
+	return s.S3Storage.Create(ctx, name)
+}
+
+func (s *createCounter) CreateUploader(ctx context.Context, name string) (ExternalFileWriter, error) {
+	s.uploads++
+	return s.S3Storage.CreateUploader(ctx, name)
+}
+
+func (r *testStorageSuite) TestCompressS3Uploader(c *C) {
+	ctx := context.Background()
+	svc := &multipartS3{}
+	counter := &createCounter{S3Storage: NewS3StorageForTest(svc, &backuppb.S3{Bucket: "bucket", Prefix: "prefix/"})}
+	// the decorators wrapped by New.
+	var inner ExternalStorage = WithMetrics(counter, "s3")
+	inner = WithRateLimit(inner, 1<<30)
+	inner = WithRetry(inner, RetryOptions{MaxAttempts: 3, Backoff: time.Millisecond, MaxBackoff: time.Millisecond})
+	inner = WithCache(inner, 1<<20)
+
+	content := "hello world"
+	writer, err := WithCompression(inner, Gzip).Create(ctx, "file.gz")
+	c.Assert(err, IsNil)
+	_, err = writer.Write(ctx, []byte(content))
+	c.Assert(err, IsNil)
+	c.Assert(writer.Close(ctx), IsNil)
+	c.Assert(counter.uploads, Equals, 1)
+	c.Assert(counter.creates, Equals, 0)
+	c.Assert(svc.completed, IsTrue)
+	c.Assert(svc.parts, HasLen, 1)
+
+	reader, err := newCompressReader(Gzip, bytes.NewReader(svc.parts[0]))
+	c.Assert(err, IsNil)
+	data, err := io.ReadAll(reader)
+	c.Assert(err, IsNil)
+	c.Assert(string(data), Equals, content)
+}
@@ -0,0 +1,138 @@
+// Copyright 2021 PingCAP, Inc. Licensed under Apache-2.0.
+
+package storage
+
+import (
+	"context"
+	"sync"
+
+	"github.com/pingcap/errors"
+	"golang.org/x/sync/errgroup"
+)
+
+// PartUploader uploads a file in numbered parts, e.g. the S3 multipart upload.
+type PartUploader interface {
+	// UploadPart uploads a part of the file, the part numbers start from 1.
+	// It's called concurrently for different parts.
+	UploadPart(ctx context.Context, partNumber int, data []byte) error
+	// Close makes the uploaded parts a complete file in the order of the part
+	// numbers.
+	Close(ctx context.Context) error
+}
+
+type concurrentWriter struct {
+	uploader PartUploader
+	partSize int
+
+	// buf is the part being filled by the caller.
+	buf   []byte
+	parts int
+
+	eg      errgroup.Group
+	workers chan struct{}
+	// free holds the buffers of the uploaded parts for reuse, so at most
+	// concurrency+1 buffers are allocated.
+	free chan []byte
+
+	mu  sync.Mutex
+	err error
+}
+
+// NewConcurrentWriter returns a writer filling the buffers of partSize and
+// uploading up to concurrency of them at the same time, while the caller keeps
+// writing the next part. So the speed of the producer isn't bounded by the
+// round trip of each upload.
+func NewConcurrentWriter(uploader PartUploader, partSize int, concurrency int) ExternalFileWriter {
+	if concurrency <= 0 {
+		concurrency = 1
+	}
+	return &concurrentWriter{
+		uploader: uploader,
+		partSize: partSize,
+		workers:  make(chan struct{}, concurrency),
+		free:     make(chan []byte, concurrency+1),
+	}
+}
+
+func (w *concurrentWriter) firstError() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.err
+}
+
+func (w *concurrentWriter) setError(err error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.err == nil {
+		w.err = err
+	}
+}
+
+func (w *concurrentWriter) getBuffer() []byte {
+	select {
+	case buf := <-w.free:
+		return buf[:0]
+	default:
+		return make([]byte, 0, w.partSize)
+	}
+}
+
+func (w *concurrentWriter) Write(ctx context.Context, p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		if err := w.firstError(); err != nil {
+			return written, errors.Trace(err)
+		}
+		if w.buf == nil {
+			w.buf = w.getBuffer()
+		}
+		n := copy(w.buf[len(w.buf):cap(w.buf)], p)
+		w.buf = w.buf[:len(w.buf)+n]
+		p = p[n:]
+		written += n
+		if len(w.buf) == cap(w.buf) {
+			if err := w.uploadPart(ctx); err != nil {
+				return written, errors.Trace(err)
+			}
+		}
+	}
+	return written, nil
+}
+
+// uploadPart uploads the filled buffer in the background, it blocks while
+// there are already concurrency parts being uploaded.
+func (w *concurrentWriter) uploadPart(ctx context.Context) error {
+	select {
+	case <-ctx.Done():
+		return errors.Trace(ctx.Err())
+	case w.workers <- struct{}{}:
+	}
+	w.parts++
+	part, data := w.parts, w.buf
+	w.buf = nil
+	w.eg.Go(func() error {
+		defer func() {
+			w.free <- data
+			<-w.workers
+		}()
+		if err := w.uploader.UploadPart(ctx, part, data); err != nil {
+			err = errors.Annotatef(err, "upload part %d", part)
+			w.setError(err)
+			return err
+		}
+		return nil
+	})
+	return nil
+}
+
+func (w *concurrentWriter) Close(ctx context.Context) error {
+	if len(w.buf) > 0 && w.firstError() == nil {
+		if err := w.uploadPart(ctx); err != nil {
+			return errors.Trace(err)
+		}
+	}
+	if err := w.eg.Wait(); err != nil {
+		return errors.Trace(err)
+	}
+	return errors.Trace(w.uploader.Close(ctx))
+}
@@ -0,0 +1,87 @@
+// Copyright 2021 PingCAP, Inc. Licensed under Apache-2.0.
+
+package storage
+
+import (
+	"bytes"
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	. "github.com/pingcap/check"
+	"github.com/pingcap/errors"
+)
+
+// mockPartUploader records the uploaded parts, the parts are slowed down in
+// the reverse order, so they complete out of order.
+type mockPartUploader struct {
+	mu       sync.Mutex
+	parts    map[int][]byte
+	inflight int32
+	maxSeen  int32
+	failPart int
+	closed   bool
+}
+
+func (u *mockPartUploader) UploadPart(ctx context.Context, partNumber int, data []byte) error {
+	n := atomic.AddInt32(&u.inflight, 1)
+	defer atomic.AddInt32(&u.inflight, -1)
+	for {
+		seen := atomic.LoadInt32(&u.maxSeen)
+		if n <= seen || atomic.CompareAndSwapInt32(&u.maxSeen, seen, n) {
+			break
+		}
+	}
+	time.Sleep(time.Duration(10-partNumber%10) * time.Millisecond)
+	if partNumber == u.failPart {
+		return errors.New("injected")
+	}
+	u.mu.Lock()
+	u.parts[partNumber] = append([]byte(nil), data...)
+	u.mu.Unlock()
+	return nil
+}
+
+func (u *mockPartUploader) Close(ctx context.Context) error {
+	u.closed = true
+	return nil
+}
+
+func (r *testStorageSuite) TestConcurrentWriter(c *C) {
+	ctx := context.Background()
+	uploader := &mockPartUploader{parts: make(map[int][]byte)}
+	w := NewConcurrentWriter(uploader, 4, 3)
+	var expected []byte
+	for i := 0; i < 20; i++ {
+		p := bytes.Repeat([]byte{byte('a' + i)}, i%7)
+		expected = append(expected, p...)
+		n, err := w.Write(ctx, p)
+		c.Assert(err, IsNil)
+		c.Assert(n, Equals, len(p))
+	}
+	c.Assert(w.Close(ctx), IsNil)
+	c.Assert(uploader.closed, IsTrue)
+	c.Assert(atomic.LoadInt32(&uploader.maxSeen) <= 3, IsTrue)
+
+	var actual []byte
+	for i := 1; i <= len(uploader.parts); i++ {
+		part, ok := uploader.parts[i]
+		c.Assert(ok, IsTrue)
+		if i < len(uploader.parts) {
+			c.Assert(part, HasLen, 4)
+		}
+		actual = append(actual, part...)
+	}
+	c.Assert(actual, DeepEquals, expected)
+
+	// the failed part fails the following writes and the close.
+	uploader = &mockPartUploader{parts: make(map[int][]byte), failPart: 2}
+	w = NewConcurrentWriter(uploader, 4, 2)
+	var err error
+	for i := 0; i < 10 && err == nil; i++ {
+		_, err = w.Write(ctx, []byte("12345678"))
+	}
+	c.Assert(w.Close(ctx), ErrorMatches, ".*upload part 2.*injected.*")
+	c.Assert(uploader.closed, IsFalse)
+}
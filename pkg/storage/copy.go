@@ -0,0 +1,115 @@
+// Copyright 2021 PingCAP, Inc. Licensed under Apache-2.0.
+
+package storage
+
+import (
+	"context"
+	"io"
+
+	"github.com/pingcap/errors"
+	backuppb "github.com/pingcap/kvproto/pkg/backup"
+	"github.com/pingcap/log"
+	"go.uber.org/zap"
+)
+
+// maxS3CopyObjectSize is the max size of the objects copied by CopyObject.
+const maxS3CopyObjectSize = 5 * 1024 * 1024 * 1024
+
+// FileCopier copies the files from a storage to another.
+type FileCopier interface {
+	// CopyFile copies the file of the size to the file of the same name.
+	CopyFile(ctx context.Context, name string, size int64) error
+}
+
+type streamCopier struct {
+	src ExternalStorage
+	dst ExternalStorage
+}
+
+// NewStreamCopier returns the FileCopier reading the files from the source
+// storage and writing them to the destination storage through BR.
+func NewStreamCopier(src, dst ExternalStorage) FileCopier {
+	return &streamCopier{src: src, dst: dst}
+}
+
+func (c *streamCopier) CopyFile(ctx context.Context, name string, size int64) error {
+	_, err := CopyFileAs(ctx, c.src, name, c.dst, name)
+	return errors.Trace(err)
+}
+
+// CopyFileAs copies the file of the source storage to the file of another
+// name in the destination storage through BR, and returns the copied bytes.
+func CopyFileAs(
+	ctx context.Context,
+	src ExternalStorage, srcName string,
+	dst ExternalStorage, dstName string,
+) (int64, error) {
+	r, err := src.Open(ctx, srcName)
+	if err != nil {
+		return 0, errors.Trace(err)
+	}
+	defer r.Close()
+	w, err := dst.Create(ctx, dstName)
+	if err != nil {
+		return 0, errors.Trace(err)
+	}
+	counter := &countingReader{Reader: r}
+	if err = copyAndClose(ctx, counter, w); err != nil {
+		return 0, errors.Trace(err)
+	}
+	return counter.n, nil
+}
+
+// countingReader counts the bytes read from the reader.
+type countingReader struct {
+	io.Reader
+	n int64
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.Reader.Read(p)
+	r.n += int64(n)
+	return n, err
+}
+
+type s3Copier struct {
+	src      *S3Storage
+	dst      *S3Storage
+	fallback FileCopier
+}
+
+func (c *s3Copier) CopyFile(ctx context.Context, name string, size int64) error {
+	if size > maxS3CopyObjectSize {
+		return errors.Trace(c.fallback.CopyFile(ctx, name, size))
+	}
+	return errors.Annotatef(c.dst.CopyFrom(ctx, c.src, name, name), "failed to copy %s", name)
+}
+
+// NewServerSideCopier returns the FileCopier copying the files inside the
+// storage service without passing through BR, which is only supported when
+// both backends are the buckets of the same S3 endpoint. Otherwise, and for
+// the files too large to copy inside S3, the fallback is used.
+func NewServerSideCopier(
+	srcBackend, dstBackend *backuppb.StorageBackend,
+	opts *ExternalStorageOptions,
+	fallback FileCopier,
+) (FileCopier, error) {
+	srcS3, dstS3 := srcBackend.GetS3(), dstBackend.GetS3()
+	if srcS3 == nil || dstS3 == nil || srcS3.Endpoint != dstS3.Endpoint {
+		return fallback, nil
+	}
+	copyOpts := *opts
+	copyOpts.SkipCheckPath = true
+	copyOpts.CheckPermissions = nil
+	src, err := newS3Storage(srcS3, &copyOpts)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	dst, err := newS3Storage(dstS3, &copyOpts)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	log.Info("copy the files inside S3",
+		zap.String("from", src.URI()), zap.String("to", dst.URI()), zap.String("endpoint", dstS3.Endpoint))
+	return &s3Copier{src: src, dst: dst, fallback: fallback}, nil
+}
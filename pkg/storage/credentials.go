@@ -0,0 +1,226 @@
+// Copyright 2021 PingCAP, Inc. Licensed under Apache-2.0.
+
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"github.com/aws/aws-sdk-go/aws/client"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/credentials/processcreds"
+	"github.com/aws/aws-sdk-go/aws/credentials/stscreds"
+	"github.com/pingcap/errors"
+	"github.com/spf13/pflag"
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/google"
+
+	berrors "github.com/pingcap/br/pkg/errors"
+)
+
+const (
+	storageCredentialProviderOption = "storage.credential-provider"
+	storageCredentialProcessOption  = "storage.credential-process"
+	s3RoleARNOption                 = "s3.role-arn"
+	s3WebIdentityTokenFileOption    = "s3.web-identity-token-file"
+
+	// the environment variables set by EKS for IAM roles for service accounts.
+	awsRoleARNEnv              = "AWS_ROLE_ARN"
+	awsWebIdentityTokenFileEnv = "AWS_WEB_IDENTITY_TOKEN_FILE"
+
+	webIdentityRoleSessionName = "br"
+	credentialProcessTimeout   = time.Minute
+)
+
+// CredentialProvider is the source of the cloud credentials of BR.
+type CredentialProvider string
+
+const (
+	// CredentialProviderDefault uses the static keys if provided, or the
+	// default credential chain of the cloud SDKs.
+	CredentialProviderDefault CredentialProvider = ""
+	// CredentialProviderWebIdentity assumes an AWS role with the web identity
+	// token, e.g. the IAM roles for service accounts (IRSA) of EKS.
+	CredentialProviderWebIdentity CredentialProvider = "web-identity"
+	// CredentialProviderWorkloadIdentity uses the GCP workload identity of the
+	// service account bound to the pod or instance via the metadata server.
+	CredentialProviderWorkloadIdentity CredentialProvider = "workload-identity"
+	// CredentialProviderProcess runs an external command to get the
+	// credentials, like the `credential_process` of AWS CLI.
+	CredentialProviderProcess CredentialProvider = "process"
+)
+
+// CredentialOptions configures how BR obtains the cloud credentials. The
+// credentials of the providers other than the default one are refreshed
+// before they expire, so they can't be sent to TiKV.
+type CredentialOptions struct {
+	Provider CredentialProvider `json:"provider" toml:"provider"`
+	// Process is the command run by the process provider. For S3, it prints
+	// the credentials in the format of AWS CLI `credential_process`. For GCS,
+	// it prints a JSON object with `access_token` and `expires_in` (seconds)
+	// or `expiry` (RFC 3339).
+	Process string `json:"process" toml:"process"`
+	// RoleARN and WebIdentityTokenFile are used by the web-identity provider,
+	// which default to the environment variables set by EKS.
+	RoleARN              string `json:"role-arn" toml:"role-arn"`
+	WebIdentityTokenFile string `json:"web-identity-token-file" toml:"web-identity-token-file"`
+}
+
+func defineCredentialFlags(flags *pflag.FlagSet) {
+	flags.String(storageCredentialProviderOption, "",
+		"(experimental) the provider of the credentials of BR accessing the storage, "+
+			"one of web-identity (S3), workload-identity (GCS) and process. "+
+			"Empty means the static keys or the default credential chain")
+	flags.String(storageCredentialProcessOption, "",
+		"(experimental) the command printing the credentials, used by the process credential provider")
+	flags.String(s3RoleARNOption, "",
+		"(experimental) the role assumed by the web-identity credential provider, default to $"+awsRoleARNEnv)
+	flags.String(s3WebIdentityTokenFileOption, "",
+		"(experimental) the token file of the web-identity credential provider, default to $"+awsWebIdentityTokenFileEnv)
+}
+
+func (options *CredentialOptions) parseFromFlags(flags *pflag.FlagSet) error {
+	provider, err := flags.GetString(storageCredentialProviderOption)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	options.Provider = CredentialProvider(provider)
+	options.Process, err = flags.GetString(storageCredentialProcessOption)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	options.RoleARN, err = flags.GetString(s3RoleARNOption)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	options.WebIdentityTokenFile, err = flags.GetString(s3WebIdentityTokenFileOption)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	return errors.Trace(options.adjust())
+}
+
+// adjust fills the defaults from the environment variables and validates the
+// options.
+func (options *CredentialOptions) adjust() error {
+	switch options.Provider {
+	case CredentialProviderDefault, CredentialProviderWorkloadIdentity:
+	case CredentialProviderWebIdentity:
+		if options.RoleARN == "" {
+			options.RoleARN = os.Getenv(awsRoleARNEnv)
+		}
+		if options.WebIdentityTokenFile == "" {
+			options.WebIdentityTokenFile = os.Getenv(awsWebIdentityTokenFileEnv)
+		}
+		if options.RoleARN == "" || options.WebIdentityTokenFile == "" {
+			return errors.Annotatef(berrors.ErrStorageInvalidConfig,
+				"--%s and --%s are required by the web-identity credential provider",
+				s3RoleARNOption, s3WebIdentityTokenFileOption)
+		}
+	case CredentialProviderProcess:
+		if strings.TrimSpace(options.Process) == "" {
+			return errors.Annotatef(berrors.ErrStorageInvalidConfig,
+				"--%s is required by the process credential provider", storageCredentialProcessOption)
+		}
+	default:
+		return errors.Annotatef(berrors.ErrStorageInvalidConfig, "unknown credential provider %q", options.Provider)
+	}
+	return nil
+}
+
+// refreshable checks whether the credentials are provided by a provider
+// refreshing them instead of the static keys or the default chain.
+func (options *CredentialOptions) refreshable() bool {
+	return options != nil && options.Provider != CredentialProviderDefault
+}
+
+// checkRefreshableCredentials checks the credentials from the provider don't
+// conflict with the static keys, and aren't sent to TiKV since they expire.
+func checkRefreshableCredentials(opts *ExternalStorageOptions, hasStaticKeys bool) error {
+	if hasStaticKeys {
+		return errors.Annotatef(berrors.ErrStorageInvalidConfig,
+			"the static keys conflict with the credential provider %s", opts.Credentials.Provider)
+	}
+	if opts.SendCredentials {
+		return errors.Annotatef(berrors.ErrStorageInvalidConfig,
+			"the credentials of provider %s expire and can't be sent to TiKV, "+
+				"please set '--send-credentials-to-tikv=false' and configure the credentials of TiKV",
+			opts.Credentials.Provider)
+	}
+	return nil
+}
+
+// s3Credentials returns the credentials of S3 from the provider, the config
+// provider is used to create the STS client of the web identity.
+func (options *CredentialOptions) s3Credentials(c client.ConfigProvider) (*credentials.Credentials, error) {
+	switch options.Provider {
+	case CredentialProviderWebIdentity:
+		return stscreds.NewWebIdentityCredentials(c, options.RoleARN, webIdentityRoleSessionName,
+			options.WebIdentityTokenFile), nil
+	case CredentialProviderProcess:
+		return processcreds.NewCredentialsTimeout(options.Process, credentialProcessTimeout), nil
+	default:
+		return nil, errors.Annotatef(berrors.ErrStorageInvalidConfig,
+			"credential provider %s isn't supported by s3", options.Provider)
+	}
+}
+
+// gcsTokenSource returns the token source of GCS from the provider.
+func (options *CredentialOptions) gcsTokenSource() (oauth2.TokenSource, error) {
+	switch options.Provider {
+	case CredentialProviderWorkloadIdentity:
+		return google.ComputeTokenSource("", storage.ScopeReadWrite), nil
+	case CredentialProviderProcess:
+		return oauth2.ReuseTokenSource(nil, processTokenSource(options.Process)), nil
+	default:
+		return nil, errors.Annotatef(berrors.ErrStorageInvalidConfig,
+			"credential provider %s isn't supported by gcs", options.Provider)
+	}
+}
+
+// processTokenSource is the command printing the OAuth2 token.
+type processTokenSource string
+
+type processToken struct {
+	AccessToken string    `json:"access_token"`
+	TokenType   string    `json:"token_type"`
+	ExpiresIn   int64     `json:"expires_in"`
+	Expiry      time.Time `json:"expiry"`
+}
+
+// Token implements oauth2.TokenSource.
+func (command processTokenSource) Token() (*oauth2.Token, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), credentialProcessTimeout)
+	defer cancel()
+	output, err := credentialCommand(ctx, string(command)).Output()
+	if err != nil {
+		return nil, errors.Annotatef(berrors.ErrStorageInvalidConfig, "failed to run credential process: %v", err)
+	}
+	var token processToken
+	if err = json.Unmarshal(output, &token); err != nil {
+		return nil, errors.Annotatef(berrors.ErrStorageInvalidConfig, "invalid output of credential process: %v", err)
+	}
+	if token.AccessToken == "" {
+		return nil, errors.Annotate(berrors.ErrStorageInvalidConfig, "access_token not found in the output of credential process")
+	}
+	expiry := token.Expiry
+	if token.ExpiresIn > 0 {
+		expiry = time.Now().Add(time.Duration(token.ExpiresIn) * time.Second)
+	}
+	return &oauth2.Token{AccessToken: token.AccessToken, TokenType: token.TokenType, Expiry: expiry}, nil
+}
+
+// credentialCommand runs the command by the shell, the same as the
+// credential process of AWS SDK.
+func credentialCommand(ctx context.Context, command string) *exec.Cmd {
+	if runtime.GOOS == "windows" {
+		return exec.CommandContext(ctx, "cmd.exe", "/C", command)
+	}
+	return exec.CommandContext(ctx, "sh", "-c", command)
+}
@@ -0,0 +1,53 @@
+// Copyright 2021 PingCAP, Inc. Licensed under Apache-2.0.
+
+package storage
+
+import (
+	"os"
+	"time"
+
+	. "github.com/pingcap/check"
+)
+
+func (r *testStorageSuite) TestCredentialOptions(c *C) {
+	options := &CredentialOptions{Provider: "unknown"}
+	c.Assert(options.adjust(), ErrorMatches, `unknown credential provider "unknown".*`)
+
+	options = &CredentialOptions{Provider: CredentialProviderProcess}
+	c.Assert(options.adjust(), ErrorMatches, ".*--storage.credential-process is required.*")
+
+	c.Assert(os.Setenv(awsRoleARNEnv, "arn:aws:iam::123456789012:role/br"), IsNil)
+	c.Assert(os.Setenv(awsWebIdentityTokenFileEnv, "/var/run/secrets/token"), IsNil)
+	defer func() {
+		_ = os.Unsetenv(awsRoleARNEnv)
+		_ = os.Unsetenv(awsWebIdentityTokenFileEnv)
+	}()
+	options = &CredentialOptions{Provider: CredentialProviderWebIdentity}
+	c.Assert(options.adjust(), IsNil)
+	c.Assert(options.RoleARN, Equals, "arn:aws:iam::123456789012:role/br")
+	c.Assert(options.WebIdentityTokenFile, Equals, "/var/run/secrets/token")
+	c.Assert(options.refreshable(), IsTrue)
+
+	opts := &ExternalStorageOptions{Credentials: options, SendCredentials: true}
+	c.Assert(checkRefreshableCredentials(opts, true), ErrorMatches, ".*conflict with the credential provider.*")
+	c.Assert(checkRefreshableCredentials(opts, false), ErrorMatches, ".*can't be sent to TiKV.*")
+	opts.SendCredentials = false
+	c.Assert(checkRefreshableCredentials(opts, false), IsNil)
+
+	_, err := options.gcsTokenSource()
+	c.Assert(err, ErrorMatches, ".*isn't supported by gcs.*")
+	options = nil
+	c.Assert(options.refreshable(), IsFalse)
+}
+
+func (r *testStorageSuite) TestProcessTokenSource(c *C) {
+	token, err := processTokenSource(`echo '{"access_token": "token", "expires_in": 3600}'`).Token()
+	c.Assert(err, IsNil)
+	c.Assert(token.AccessToken, Equals, "token")
+	c.Assert(token.Expiry.After(time.Now().Add(59*time.Minute)), IsTrue)
+
+	_, err = processTokenSource(`echo '{}'`).Token()
+	c.Assert(err, ErrorMatches, ".*access_token not found.*")
+	_, err = processTokenSource("exit 1").Token()
+	c.Assert(err, ErrorMatches, ".*failed to run credential process.*")
+}
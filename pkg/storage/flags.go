@@ -11,6 +11,14 @@ import (
 func DefineFlags(flags *pflag.FlagSet) {
 	defineS3Flags(flags)
 	defineGCSFlags(flags)
+	defineGCSUploadFlags(flags)
+	defineS3UploadFlags(flags)
+	defineS3RequestBudgetFlags(flags)
+	defineLocalFlags(flags)
+	defineRetryFlags(flags)
+	defineRateLimitFlags(flags)
+	defineProxyFlags(flags)
+	defineCredentialFlags(flags)
 }
 
 // ParseFromFlags obtains the backend options from the flag set.
@@ -18,5 +26,32 @@ func (options *BackendOptions) ParseFromFlags(flags *pflag.FlagSet) error {
 	if err := options.S3.parseFromFlags(flags); err != nil {
 		return errors.Trace(err)
 	}
-	return options.GCS.parseFromFlags(flags)
+	if err := options.GCS.parseFromFlags(flags); err != nil {
+		return errors.Trace(err)
+	}
+	if err := options.GCSUpload.parseFromFlags(flags); err != nil {
+		return errors.Trace(err)
+	}
+	if err := options.S3Upload.parseFromFlags(flags); err != nil {
+		return errors.Trace(err)
+	}
+	if err := options.S3RequestBudget.parseFromFlags(flags); err != nil {
+		return errors.Trace(err)
+	}
+	if err := options.Local.parseFromFlags(flags); err != nil {
+		return errors.Trace(err)
+	}
+	if err := options.Credentials.parseFromFlags(flags); err != nil {
+		return errors.Trace(err)
+	}
+	if err := options.Retry.parseFromFlags(flags); err != nil {
+		return errors.Trace(err)
+	}
+	var err error
+	options.RateLimit, err = parseRateLimit(flags)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	options.Proxy, err = parseProxy(flags)
+	return errors.Trace(err)
 }
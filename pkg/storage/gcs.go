@@ -4,7 +4,10 @@ package storage
 
 import (
 	"context"
+	"hash"
+	"hash/crc32"
 	"io"
+	"net/http"
 	"os"
 	"path"
 	"strings"
@@ -18,6 +21,7 @@ import (
 	"golang.org/x/oauth2/google"
 	"google.golang.org/api/iterator"
 	"google.golang.org/api/option"
+	htransport "google.golang.org/api/transport/http"
 
 	berrors "github.com/pingcap/br/pkg/errors"
 )
@@ -87,6 +91,7 @@ func (options *GCSBackendOptions) parseFromFlags(flags *pflag.FlagSet) error {
 type gcsStorage struct {
 	gcs    *backuppb.GCS
 	bucket *storage.BucketHandle
+	upload *GCSUploadOptions
 }
 
 func (s *gcsStorage) objectName(name string) string {
@@ -99,11 +104,18 @@ func (s *gcsStorage) WriteFile(ctx context.Context, name string, data []byte) er
 	wc := s.bucket.Object(object).NewWriter(ctx)
 	wc.StorageClass = s.gcs.StorageClass
 	wc.PredefinedACL = s.gcs.PredefinedAcl
+	wc.ChunkSize = s.upload.chunkSize(int64(len(data)))
+	// GCS rejects the upload if the content doesn't match the CRC32C.
+	wc.CRC32C = crc32.Checksum(data, crc32cTable)
+	wc.SendCRC32C = true
 	_, err := wc.Write(data)
 	if err != nil {
 		return errors.Trace(err)
 	}
-	return wc.Close()
+	if err = wc.Close(); err != nil {
+		return errors.Trace(err)
+	}
+	return verifyCRC32C(name, reportedCRC32C(wc), wc.CRC32C)
 }
 
 // ReadFile reads the file from the storage and returns the contents.
@@ -115,18 +127,36 @@ func (s *gcsStorage) ReadFile(ctx context.Context, name string) ([]byte, error)
 			"failed to read gcs file, file info: input.bucket='%s', input.key='%s'",
 			s.gcs.Bucket, object)
 	}
-	defer rc.Close()
-
 	size := rc.Attrs.Size
-	var b []byte
-	if size < 0 {
-		// happened when using fake-gcs-server in integration test
-		b, err = io.ReadAll(rc)
-	} else {
-		b = make([]byte, size)
-		_, err = io.ReadFull(rc, b)
-	}
-	return b, errors.Trace(err)
+	b, err := readAllWithResume(name, rc, func(offset int64) (io.ReadCloser, error) {
+		return s.bucket.Object(object).NewRangeReader(ctx, offset, -1)
+	})
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	// size is negative when using fake-gcs-server in integration test
+	if size >= 0 && int64(len(b)) != size {
+		return nil, errors.Annotatef(io.ErrUnexpectedEOF,
+			"failed to read gcs file, file info: input.bucket='%s', input.key='%s', expected %d bytes, got %d",
+			s.gcs.Bucket, object, size, len(b))
+	}
+	return b, nil
+}
+
+// ReadRange reads a byte range of the file from the storage.
+func (s *gcsStorage) ReadRange(ctx context.Context, name string, offset, length int64) ([]byte, error) {
+	object := s.objectName(name)
+	if length < 0 {
+		length = -1
+	}
+	rc, err := s.bucket.Object(object).NewRangeReader(ctx, offset, length)
+	if err != nil {
+		return nil, errors.Annotatef(err,
+			"failed to read gcs file range, file info: input.bucket='%s', input.key='%s', offset=%d, length=%d",
+			s.gcs.Bucket, object, offset, length)
+	}
+	defer rc.Close()
+	return readRange(rc, length)
 }
 
 // FileExists return true if file exists.
@@ -179,7 +209,7 @@ func (s *gcsStorage) WalkDir(ctx context.Context, opt *WalkOption, fn func(strin
 		prefix += "/"
 	}
 
-	query := &storage.Query{Prefix: prefix}
+	query := &storage.Query{Prefix: prefix + opt.globPrefix()}
 	// only need each object's name and size
 	query.SetAttrSelection([]string{"Name", "Size"})
 	iter := s.bucket.Objects(ctx, query)
@@ -195,6 +225,13 @@ func (s *gcsStorage) WalkDir(ctx context.Context, opt *WalkOption, fn func(strin
 		// which can not be reuse in other API(Open/Read) directly.
 		// so we use TrimPrefix to filter Prefix for next Open/Read.
 		path := strings.TrimPrefix(attrs.Name, s.gcs.Prefix)
+		matched, err := opt.match(strings.TrimPrefix(attrs.Name, prefix))
+		if err != nil {
+			return errors.Trace(err)
+		}
+		if !matched {
+			continue
+		}
 		if err = fn(path, attrs.Size); err != nil {
 			return errors.Trace(err)
 		}
@@ -202,6 +239,52 @@ func (s *gcsStorage) WalkDir(ctx context.Context, opt *WalkOption, fn func(strin
 	return nil
 }
 
+// Rename copies the object to the new name and deletes the old one, since
+// GCS doesn't support renaming.
+func (s *gcsStorage) Rename(ctx context.Context, oldName, newName string) error {
+	src := s.bucket.Object(s.objectName(oldName))
+	copier := s.bucket.Object(s.objectName(newName)).CopierFrom(src)
+	copier.StorageClass = s.gcs.StorageClass
+	copier.PredefinedACL = s.gcs.PredefinedAcl
+	if _, err := copier.Run(ctx); err != nil {
+		return errors.Annotatef(err, "failed to copy %s to %s", oldName, newName)
+	}
+	return s.DeleteFile(ctx, oldName)
+}
+
+// DeleteFile deletes the object.
+func (s *gcsStorage) DeleteFile(ctx context.Context, name string) error {
+	err := s.bucket.Object(s.objectName(name)).Delete(ctx)
+	if err != nil && errors.Cause(err) != storage.ErrObjectNotExist { // nolint:errorlint
+		return errors.Trace(err)
+	}
+	return nil
+}
+
+// DeletePrefix deletes the objects whose names start with the prefix.
+func (s *gcsStorage) DeletePrefix(ctx context.Context, prefix string) error {
+	objectPrefix := s.gcs.Prefix
+	if len(objectPrefix) > 0 && !strings.HasSuffix(objectPrefix, "/") {
+		objectPrefix += "/"
+	}
+	query := &storage.Query{Prefix: objectPrefix + prefix}
+	query.SetAttrSelection([]string{"Name"})
+	iter := s.bucket.Objects(ctx, query)
+	for {
+		attrs, err := iter.Next()
+		if err == iterator.Done {
+			return nil
+		}
+		if err != nil {
+			return errors.Trace(err)
+		}
+		err = s.bucket.Object(attrs.Name).Delete(ctx)
+		if err != nil && errors.Cause(err) != storage.ErrObjectNotExist { // nolint:errorlint
+			return errors.Trace(err)
+		}
+	}
+}
+
 func (s *gcsStorage) URI() string {
 	return "gcs://" + s.gcs.Bucket + "/" + s.gcs.Prefix
 }
@@ -212,14 +295,54 @@ func (s *gcsStorage) Create(ctx context.Context, name string) (ExternalFileWrite
 	wc := s.bucket.Object(object).NewWriter(ctx)
 	wc.StorageClass = s.gcs.StorageClass
 	wc.PredefinedACL = s.gcs.PredefinedAcl
-	return newFlushStorageWriter(wc, &emptyFlusher{}, wc), nil
+	wc.ChunkSize = s.upload.chunkSize(-1)
+	w := &gcsObjectWriter{name: name, Writer: wc, crc: newCRC32C()}
+	return newFlushStorageWriter(w, &emptyFlusher{}, w), nil
+}
+
+func reportedCRC32C(wc *storage.Writer) uint32 {
+	if attrs := wc.Attrs(); attrs != nil {
+		return attrs.CRC32C
+	}
+	return 0
+}
+
+// gcsObjectWriter computes the CRC32C of the written content, and checks it
+// against the one reported by GCS after the upload completes.
+type gcsObjectWriter struct {
+	*storage.Writer
+	name string
+	crc  hash.Hash32
+}
+
+func (w *gcsObjectWriter) Write(p []byte) (int, error) {
+	n, err := w.Writer.Write(p)
+	_, _ = w.crc.Write(p[:n])
+	return n, err
+}
+
+func (w *gcsObjectWriter) Close() error {
+	if err := w.Writer.Close(); err != nil {
+		return errors.Trace(err)
+	}
+	return verifyCRC32C(w.name, reportedCRC32C(w.Writer), w.crc.Sum32())
 }
 
 func newGCSStorage(ctx context.Context, gcs *backuppb.GCS, opts *ExternalStorageOptions) (*gcsStorage, error) {
 	var clientOps []option.ClientOption
-	if opts.NoCredentials {
+	switch {
+	case opts.NoCredentials:
 		clientOps = append(clientOps, option.WithoutAuthentication())
-	} else {
+	case opts.Credentials.refreshable():
+		if err := checkRefreshableCredentials(opts, gcs.CredentialsBlob != ""); err != nil {
+			return nil, errors.Trace(err)
+		}
+		tokenSource, err := opts.Credentials.gcsTokenSource()
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		clientOps = append(clientOps, option.WithTokenSource(tokenSource))
+	default:
 		if gcs.CredentialsBlob == "" {
 			creds, err := google.FindDefaultCredentials(ctx, storage.ScopeReadWrite)
 			if err != nil {
@@ -244,7 +367,19 @@ func newGCSStorage(ctx context.Context, gcs *backuppb.GCS, opts *ExternalStorage
 	if gcs.Endpoint != "" {
 		clientOps = append(clientOps, option.WithEndpoint(gcs.Endpoint))
 	}
-	if opts.HTTPClient != nil {
+	if opts.Proxy != "" || opts.GCSUpload.wrapsTransport() {
+		transport, err := gcsTransport(opts)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		// WithHTTPClient skips authentication, so the credentials are applied
+		// on the transport here.
+		authTransport, err := htransport.NewTransport(ctx, transport, clientOps...)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		clientOps = append(clientOps, option.WithHTTPClient(&http.Client{Transport: authTransport}))
+	} else if opts.HTTPClient != nil {
 		clientOps = append(clientOps, option.WithHTTPClient(opts.HTTPClient))
 	}
 	client, err := storage.NewClient(ctx, clientOps...)
@@ -278,7 +413,7 @@ func newGCSStorage(ctx context.Context, gcs *backuppb.GCS, opts *ExternalStorage
 			return nil, errors.Annotatef(err, "gcs://%s/%s", gcs.Bucket, gcs.Prefix)
 		}
 	}
-	return &gcsStorage{gcs: gcs, bucket: bucket}, nil
+	return &gcsStorage{gcs: gcs, bucket: bucket, upload: opts.GCSUpload}, nil
 }
 
 func hasSSTFiles(ctx context.Context, bucket *storage.BucketHandle, prefix string) bool {
@@ -312,7 +447,8 @@ type gcsObjectReader struct {
 	// reader context used for implement `io.Seek`
 	// currently, lightning depends on package `xitongsys/parquet-go` to read parquet file and it needs `io.Seeker`
 	// See: https://github.com/xitongsys/parquet-go/blob/207a3cee75900b2b95213627409b7bac0f190bb3/source/source.go#L9-L10
-	ctx context.Context
+	ctx      context.Context
+	retryCnt int
 }
 
 // Read implement the io.Reader interface.
@@ -328,6 +464,25 @@ func (r *gcsObjectReader) Read(p []byte) (n int, err error) {
 	}
 	n, err = r.reader.Read(p)
 	r.pos += int64(n)
+	if err != nil && errors.Cause(err) != io.EOF && r.retryCnt < maxErrorRetries { //nolint:errorlint
+		// if can retry, reopen a new reader at the current position and try read again
+		_ = r.reader.Close()
+		r.reader = nil
+
+		rc, err1 := r.objHandle.NewRangeReader(r.ctx, r.pos, -1)
+		if err1 != nil {
+			log.Warn("open new gcs reader failed", zap.String("file", r.name), zap.Error(err1))
+			return
+		}
+		r.reader = rc
+		r.retryCnt++
+		if n > 0 {
+			// return the data read so far, the next Read continues from the new reader.
+			return n, nil
+		}
+		n, err = r.reader.Read(p)
+		r.pos += int64(n)
+	}
 	return n, err
 }
 
@@ -369,7 +524,9 @@ func (r *gcsObjectReader) Seek(offset int64, whence int) (int64, error) {
 		return realOffset, nil
 	}
 
-	_ = r.reader.Close()
+	if r.reader != nil {
+		_ = r.reader.Close()
+	}
 	r.pos = realOffset
 	rc, err := r.objHandle.NewRangeReader(r.ctx, r.pos, -1)
 	if err != nil {
@@ -0,0 +1,223 @@
+// Copyright 2021 PingCAP, Inc. Licensed under Apache-2.0.
+
+package storage
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/docker/go-units"
+	"github.com/pingcap/errors"
+	"github.com/pingcap/log"
+	"github.com/spf13/pflag"
+	"go.uber.org/zap"
+	"google.golang.org/api/googleapi"
+
+	berrors "github.com/pingcap/br/pkg/errors"
+)
+
+const (
+	gcsChunkSizeOption            = "gcs.chunk-size"
+	gcsResumableThresholdOption   = "gcs.resumable-threshold"
+	gcsRequestRetryAttemptsOption = "gcs.request-retry-attempts"
+	gcsRequestTimeoutOption       = "gcs.request-timeout"
+
+	// gcsRequestRetryBackoff is the backoff before the first retry of a
+	// request, doubled on each retry.
+	gcsRequestRetryBackoff = 100 * time.Millisecond
+)
+
+// GCSUploadOptions tunes the uploads and requests of BR to GCS. They aren't a
+// part of the backend, so the uploads by TiKV aren't affected.
+type GCSUploadOptions struct {
+	// ChunkSize is the size of the chunks of resumable uploads, 0 means the
+	// default size of the GCS client.
+	ChunkSize int64 `json:"chunk-size" toml:"chunk-size"`
+	// ResumableThreshold is the size under which the files are uploaded in a
+	// single request instead of a resumable upload, which saves a round trip.
+	ResumableThreshold int64 `json:"resumable-threshold" toml:"resumable-threshold"`
+	// RequestRetryAttempts is the max times a request failed with transient
+	// errors is tried, 0 or 1 disables the retry.
+	RequestRetryAttempts int `json:"request-retry-attempts" toml:"request-retry-attempts"`
+	// RequestTimeout is the timeout of each attempt of a request to receive
+	// the response header, 0 means no timeout. Reading the response body isn't
+	// limited, so downloading large files won't time out.
+	RequestTimeout time.Duration `json:"request-timeout" toml:"request-timeout"`
+}
+
+func defineGCSUploadFlags(flags *pflag.FlagSet) {
+	flags.String(gcsChunkSizeOption, units.BytesSize(googleapi.DefaultUploadChunkSize),
+		"(experimental) the chunk size of the resumable uploads of BR to GCS, e.g. 64MiB. "+
+			"Larger chunks save round trips on high-latency links")
+	flags.String(gcsResumableThresholdOption, "0",
+		"(experimental) the files smaller than this size are uploaded by BR to GCS in a single request, e.g. 8MiB")
+	flags.Int(gcsRequestRetryAttemptsOption, 1,
+		"(experimental) the max attempts of a GCS request failed with transient errors, set to 1 to disable retry")
+	flags.Duration(gcsRequestTimeoutOption, 0,
+		"(experimental) the timeout of each attempt of a GCS request to receive the response, 0 means no timeout")
+}
+
+func (options *GCSUploadOptions) parseFromFlags(flags *pflag.FlagSet) error {
+	var err error
+	options.ChunkSize, err = parseSizeFlag(flags, gcsChunkSizeOption)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	options.ResumableThreshold, err = parseSizeFlag(flags, gcsResumableThresholdOption)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	options.RequestRetryAttempts, err = flags.GetInt(gcsRequestRetryAttemptsOption)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	options.RequestTimeout, err = flags.GetDuration(gcsRequestTimeoutOption)
+	return errors.Trace(err)
+}
+
+func parseSizeFlag(flags *pflag.FlagSet, name string) (int64, error) {
+	value, err := flags.GetString(name)
+	if err != nil {
+		return 0, errors.Trace(err)
+	}
+	size, err := units.RAMInBytes(value)
+	if err != nil || size < 0 {
+		return 0, errors.Annotatef(berrors.ErrStorageInvalidConfig, "invalid --%s %q", name, value)
+	}
+	return size, nil
+}
+
+// chunkSize returns the chunk size of uploading a file of the size, -1 means
+// the size is unknown.
+func (options *GCSUploadOptions) chunkSize(size int64) int {
+	if options == nil {
+		return googleapi.DefaultUploadChunkSize
+	}
+	if size >= 0 && size < options.ResumableThreshold {
+		return 0
+	}
+	if options.ChunkSize == 0 {
+		return googleapi.DefaultUploadChunkSize
+	}
+	return int(options.ChunkSize)
+}
+
+// wrapsTransport checks whether the requests are retried or limited by
+// gcsRetryTransport.
+func (options *GCSUploadOptions) wrapsTransport() bool {
+	return options != nil && (options.RequestRetryAttempts > 1 || options.RequestTimeout > 0)
+}
+
+// gcsTransport returns the transport of the GCS client below the
+// authentication, which sends the requests through the proxy and retries
+// them if configured.
+func gcsTransport(opts *ExternalStorageOptions) (http.RoundTripper, error) {
+	transport := http.DefaultTransport
+	if opts.HTTPClient != nil && opts.HTTPClient.Transport != nil {
+		transport = opts.HTTPClient.Transport
+	}
+	if opts.Proxy != "" {
+		proxied, err := proxyTransport(opts.HTTPClient, opts.Proxy)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		transport = proxied
+	}
+	if opts.GCSUpload.wrapsTransport() {
+		transport = &gcsRetryTransport{base: transport, options: *opts.GCSUpload}
+	}
+	return transport, nil
+}
+
+// gcsRetryTransport retries the GCS requests failed with transient errors,
+// and limits the time of each attempt. The resumable uploads by the GCS
+// client retry the chunks infinitely, which are bounded by it.
+type gcsRetryTransport struct {
+	base    http.RoundTripper
+	options GCSUploadOptions
+}
+
+func (t *gcsRetryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	var body []byte
+	if req.Body != nil && req.GetBody == nil && t.options.RequestRetryAttempts > 1 {
+		// the chunks are buffered by the GCS client, so buffering it again
+		// costs at most a chunk per request.
+		var err error
+		body, err = io.ReadAll(req.Body)
+		_ = req.Body.Close()
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+	}
+	backoff := gcsRequestRetryBackoff
+	for attempt := 1; ; attempt++ {
+		resp, err := t.attempt(req, body, attempt)
+		retryable := err != nil && (isRetryableStorageError(err) || errors.Cause(err) == context.DeadlineExceeded) || //nolint:errorlint
+			resp != nil && (resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests)
+		if !retryable || attempt >= t.options.RequestRetryAttempts || req.Context().Err() != nil {
+			return resp, err
+		}
+		if resp != nil {
+			_, _ = io.Copy(io.Discard, resp.Body)
+			_ = resp.Body.Close()
+		}
+		log.Warn("gcs request failed, retrying",
+			zap.String("method", req.Method),
+			zap.Int("attempt", attempt),
+			zap.Duration("backoff", backoff),
+			zap.Error(err))
+		select {
+		case <-req.Context().Done():
+			return nil, errors.Trace(req.Context().Err())
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+func (t *gcsRetryTransport) attempt(req *http.Request, body []byte, attempt int) (*http.Response, error) {
+	ctx, cancel := context.WithCancel(req.Context())
+	stopTimer := func() bool { return true }
+	if t.options.RequestTimeout > 0 {
+		stopTimer = time.AfterFunc(t.options.RequestTimeout, cancel).Stop
+	}
+	r := req.Clone(ctx)
+	switch {
+	case body != nil:
+		r.Body = io.NopCloser(bytes.NewReader(body))
+	case attempt > 1 && req.GetBody != nil:
+		var err error
+		if r.Body, err = req.GetBody(); err != nil {
+			cancel()
+			return nil, errors.Trace(err)
+		}
+	}
+	resp, err := t.base.RoundTrip(r)
+	timedOut := !stopTimer()
+	if err != nil || timedOut {
+		if resp != nil {
+			_ = resp.Body.Close()
+		}
+		cancel()
+		if timedOut {
+			return nil, errors.Annotatef(context.DeadlineExceeded, "gcs request timed out after %s", t.options.RequestTimeout)
+		}
+		return nil, err
+	}
+	// the response body is read with the context of the request.
+	resp.Body = &cancelOnClose{ReadCloser: resp.Body, cancel: cancel}
+	return resp, nil
+}
+
+type cancelOnClose struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (c *cancelOnClose) Close() error {
+	defer c.cancel()
+	return c.ReadCloser.Close()
+}
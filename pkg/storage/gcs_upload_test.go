@@ -0,0 +1,65 @@
+// Copyright 2021 PingCAP, Inc. Licensed under Apache-2.0.
+
+package storage
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"time"
+
+	. "github.com/pingcap/check"
+	"google.golang.org/api/googleapi"
+)
+
+func (r *testStorageSuite) TestGCSChunkSize(c *C) {
+	var options *GCSUploadOptions
+	c.Assert(options.chunkSize(1), Equals, googleapi.DefaultUploadChunkSize)
+
+	options = &GCSUploadOptions{ChunkSize: 64 << 20, ResumableThreshold: 8 << 20}
+	c.Assert(options.chunkSize(1<<20), Equals, 0)
+	c.Assert(options.chunkSize(8<<20), Equals, 64<<20)
+	// the size of the streaming uploads is unknown.
+	c.Assert(options.chunkSize(-1), Equals, 64<<20)
+
+	options.ChunkSize = 0
+	c.Assert(options.chunkSize(-1), Equals, googleapi.DefaultUploadChunkSize)
+}
+
+func (r *testStorageSuite) TestGCSRetryTransport(c *C) {
+	var bodies []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		data, err := io.ReadAll(req.Body)
+		c.Assert(err, IsNil)
+		bodies = append(bodies, string(data))
+		switch len(bodies) {
+		case 1:
+			w.WriteHeader(http.StatusServiceUnavailable)
+		case 2:
+			time.Sleep(200 * time.Millisecond)
+		}
+		_, _ = w.Write([]byte("ok"))
+	}))
+	defer server.Close()
+
+	client := &http.Client{Transport: &gcsRetryTransport{
+		base: http.DefaultTransport,
+		options: GCSUploadOptions{
+			RequestRetryAttempts: 3,
+			RequestTimeout:       100 * time.Millisecond,
+		},
+	}}
+	// the body can't be rewound by GetBody.
+	req, err := http.NewRequest(http.MethodPut, server.URL, io.MultiReader(strings.NewReader("chunk")))
+	c.Assert(err, IsNil)
+	resp, err := client.Do(req)
+	c.Assert(err, IsNil)
+	defer resp.Body.Close()
+	c.Assert(resp.StatusCode, Equals, http.StatusOK)
+	data, err := io.ReadAll(resp.Body)
+	c.Assert(err, IsNil)
+	c.Assert(string(data), Equals, "ok")
+	// retried on the 503 and the timeout.
+	c.Assert(bodies, DeepEquals, []string{"chunk", "chunk", "chunk"})
+}
@@ -0,0 +1,65 @@
+// Copyright 2021 PingCAP, Inc. Licensed under Apache-2.0.
+
+package storage
+
+import (
+	// #nosec
+	// MD5 is required by the Content-MD5 header and ETag of S3.
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/hex"
+	"hash"
+	"hash/crc32"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/pingcap/errors"
+
+	berrors "github.com/pingcap/br/pkg/errors"
+)
+
+var crc32cTable = crc32.MakeTable(crc32.Castagnoli)
+
+// contentMD5 returns the MD5 digest of the data, and its base64 encoding used
+// by the Content-MD5 header.
+func contentMD5(data []byte) ([]byte, string) {
+	sum := md5.Sum(data)
+	return sum[:], base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// etagIsMD5 checks whether the ETag of the uploaded object is the MD5 digest
+// of its content. It isn't for the objects encrypted by SSE-KMS.
+func etagIsMD5(sse string) bool {
+	return sse != s3.ServerSideEncryptionAwsKms
+}
+
+// verifyETag checks the ETag returned by S3 against the MD5 digest computed
+// locally. The ETags which aren't a plain MD5 digest are ignored.
+func verifyETag(name string, etag *string, sum []byte) error {
+	if etag == nil {
+		return nil
+	}
+	tag := strings.Trim(*etag, `"`)
+	if len(tag) != md5.Size*2 {
+		return nil
+	}
+	if expected := hex.EncodeToString(sum); !strings.EqualFold(tag, expected) {
+		return errors.Annotatef(berrors.ErrStorageChecksumMismatch,
+			"file %s: expect md5 %s, but got etag %s", name, expected, tag)
+	}
+	return nil
+}
+
+// verifyCRC32C checks the CRC32C reported by GCS against the one computed
+// locally. Zero means the server doesn't report it.
+func verifyCRC32C(name string, reported, expected uint32) error {
+	if reported == 0 || reported == expected {
+		return nil
+	}
+	return errors.Annotatef(berrors.ErrStorageChecksumMismatch,
+		"file %s: expect crc32c %08x, but got %08x", name, expected, reported)
+}
+
+func newCRC32C() hash.Hash32 {
+	return crc32.New(crc32cTable)
+}
@@ -5,8 +5,11 @@ package storage
 import (
 	"bufio"
 	"context"
+	"io"
 	"os"
+	"path"
 	"path/filepath"
+	"strings"
 
 	"github.com/pingcap/errors"
 )
@@ -23,10 +26,22 @@ const (
 // export for using in tests.
 type LocalStorage struct {
 	base string
+	opts *LocalOptions
 }
 
 // WriteFile writes data to a file to storage.
 func (l *LocalStorage) WriteFile(ctx context.Context, name string, data []byte) error {
+	if l.opts.fsync() || l.opts.directIO() {
+		w, err := l.Create(ctx, name)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		if _, err = w.Write(ctx, data); err != nil {
+			w.Close(ctx)
+			return errors.Trace(err)
+		}
+		return errors.Trace(w.Close(ctx))
+	}
 	path := filepath.Join(l.base, name)
 	return os.WriteFile(path, data, localFilePerm)
 	// the backup meta file _is_ intended to be world-readable.
@@ -38,6 +53,27 @@ func (l *LocalStorage) ReadFile(ctx context.Context, name string) ([]byte, error
 	return os.ReadFile(path)
 }
 
+// ReadRange reads a byte range of the file from the storage.
+func (l *LocalStorage) ReadRange(ctx context.Context, name string, offset, length int64) ([]byte, error) {
+	file, err := os.Open(filepath.Join(l.base, name))
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	// seeking past the end succeeds, so check the range first.
+	if err = checkRange(name, offset, length, info.Size()); err != nil {
+		return nil, errors.Trace(err)
+	}
+	if _, err = file.Seek(offset, io.SeekStart); err != nil {
+		return nil, errors.Trace(err)
+	}
+	return readRange(file, length)
+}
+
 // FileExists implement ExternalStorage.FileExists.
 func (l *LocalStorage) FileExists(ctx context.Context, name string) (bool, error) {
 	path := filepath.Join(l.base, name)
@@ -66,7 +102,11 @@ func (l *LocalStorage) WalkDir(ctx context.Context, opt *WalkOption, fn func(str
 		}
 		// in mac osx, the path parameter is absolute path; in linux, the path is relative path to execution base dir,
 		// so use Rel to convert to relative path to l.base
+		name, _ := filepath.Rel(base, path)
 		path, _ = filepath.Rel(l.base, path)
+		if matched, err := opt.match(filepath.ToSlash(name)); err != nil || !matched {
+			return errors.Trace(err)
+		}
 
 		size := f.Size()
 		// if not a regular file, we need to use os.stat to get the real file size
@@ -91,16 +131,84 @@ func (l *LocalStorage) Open(ctx context.Context, path string) (ExternalFileReade
 	return os.Open(filepath.Join(l.base, path))
 }
 
-// Create implements ExternalStorage interface.
+// Create implements ExternalStorage interface. The parent directories are
+// created if missing.
 func (l *LocalStorage) Create(ctx context.Context, name string) (ExternalFileWriter, error) {
-	file, err := os.Create(filepath.Join(l.base, name))
+	path := filepath.Join(l.base, name)
+	if err := mkdirAll(filepath.Dir(path)); err != nil {
+		return nil, errors.Trace(err)
+	}
+	if l.opts.directIO() {
+		w, err := newDirectFileWriter(path, l.opts.fsync())
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		return w, nil
+	}
+	file, err := os.Create(path)
 	if err != nil {
 		return nil, errors.Trace(err)
 	}
 	buf := bufio.NewWriter(file)
+	if l.opts.fsync() {
+		return newFlushStorageWriter(buf, buf, syncCloser{file: file}), nil
+	}
 	return newFlushStorageWriter(buf, buf, file), nil
 }
 
+// Rename implements ExternalStorage interface.
+func (l *LocalStorage) Rename(ctx context.Context, oldName, newName string) error {
+	newPath := filepath.Join(l.base, newName)
+	if err := mkdirAll(filepath.Dir(newPath)); err != nil {
+		return errors.Trace(err)
+	}
+	if err := os.Rename(filepath.Join(l.base, oldName), newPath); err != nil {
+		return errors.Trace(err)
+	}
+	if l.opts.fsync() {
+		return errors.Trace(syncDir(filepath.Dir(newPath)))
+	}
+	return nil
+}
+
+// DeleteFile implements ExternalStorage interface.
+func (l *LocalStorage) DeleteFile(ctx context.Context, name string) error {
+	err := os.Remove(filepath.Join(l.base, name))
+	if err != nil && !os.IsNotExist(err) {
+		return errors.Trace(err)
+	}
+	return nil
+}
+
+// DeletePrefix implements ExternalStorage interface. The directories are kept
+// even if they become empty.
+func (l *LocalStorage) DeletePrefix(ctx context.Context, prefix string) error {
+	// only the directory containing the prefix needs to be walked.
+	dir := filepath.Join(l.base, filepath.FromSlash(path.Dir(prefix)))
+	return filepath.Walk(dir, func(p string, f os.FileInfo, err error) error {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		if err != nil {
+			return errors.Trace(err)
+		}
+		if f.IsDir() {
+			return nil
+		}
+		name, err := filepath.Rel(l.base, p)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		if !strings.HasPrefix(filepath.ToSlash(name), prefix) {
+			return nil
+		}
+		if err = os.Remove(p); err != nil && !os.IsNotExist(err) {
+			return errors.Trace(err)
+		}
+		return nil
+	})
+}
+
 func pathExists(_path string) (bool, error) {
 	_, err := os.Stat(_path)
 	if err != nil {
@@ -116,6 +224,10 @@ func pathExists(_path string) (bool, error) {
 //
 // export for test.
 func NewLocalStorage(base string) (*LocalStorage, error) {
+	return newLocalStorage(base, nil)
+}
+
+func newLocalStorage(base string, opts *LocalOptions) (*LocalStorage, error) {
 	ok, err := pathExists(base)
 	if err != nil {
 		return nil, errors.Trace(err)
@@ -126,5 +238,5 @@ func NewLocalStorage(base string) (*LocalStorage, error) {
 			return nil, errors.Trace(err)
 		}
 	}
-	return &LocalStorage{base: base}, nil
+	return &LocalStorage{base: base, opts: opts}, nil
 }
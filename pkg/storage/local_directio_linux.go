@@ -0,0 +1,17 @@
+// Copyright 2021 PingCAP, Inc. Licensed under Apache-2.0.
+
+// +build linux
+
+package storage
+
+import (
+	"os"
+	"syscall"
+
+	"github.com/pingcap/errors"
+)
+
+func openDirect(path string) (*os.File, error) {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC|syscall.O_DIRECT, localFilePerm)
+	return file, errors.Trace(err)
+}
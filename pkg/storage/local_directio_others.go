@@ -0,0 +1,17 @@
+// Copyright 2021 PingCAP, Inc. Licensed under Apache-2.0.
+
+// +build !linux
+
+package storage
+
+import (
+	"os"
+
+	"github.com/pingcap/errors"
+
+	berrors "github.com/pingcap/br/pkg/errors"
+)
+
+func openDirect(path string) (*os.File, error) {
+	return nil, errors.Annotate(berrors.ErrStorageInvalidConfig, "O_DIRECT is only supported on Linux")
+}
@@ -0,0 +1,191 @@
+// Copyright 2021 PingCAP, Inc. Licensed under Apache-2.0.
+
+package storage
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"time"
+	"unsafe"
+
+	"github.com/pingcap/errors"
+	"github.com/spf13/pflag"
+
+	berrors "github.com/pingcap/br/pkg/errors"
+)
+
+const (
+	localFsyncOption    = "local.fsync"
+	localDirectIOOption = "local.direct-io"
+
+	// directIOAlignment is the alignment of the buffers and the sizes of the
+	// writes with O_DIRECT.
+	directIOAlignment = 4096
+	// directIOBufferSize is the size of the writes with O_DIRECT.
+	directIOBufferSize = 1024 * 1024
+
+	probeFilePrefix = "br.probe."
+)
+
+// LocalOptions tunes the writes of BR to the local storage, e.g. the NFS
+// mounted by all nodes.
+type LocalOptions struct {
+	// Fsync is whether to fsync each file after written, and the directory
+	// after renaming, so the files are visible to other NFS clients once the
+	// backup finishes. It also means the storage is shared by BR and TiKV, so
+	// the data files written by TiKV are checked by BR after the backup.
+	Fsync bool `json:"fsync" toml:"fsync"`
+	// DirectIO is whether to write the files with O_DIRECT bypassing the page
+	// cache, only supported on Linux.
+	DirectIO bool `json:"direct-io" toml:"direct-io"`
+}
+
+func defineLocalFlags(flags *pflag.FlagSet) {
+	flags.Bool(localFsyncOption, false,
+		"fsync the files written by BR to the local storage and check the files written by TiKV exist after backup, "+
+			"the storage must be shared by BR and TiKV, e.g. NFS")
+	flags.Bool(localDirectIOOption, false,
+		"(experimental) write the files of BR to the local storage with O_DIRECT, only supported on Linux")
+}
+
+func (options *LocalOptions) parseFromFlags(flags *pflag.FlagSet) error {
+	var err error
+	options.Fsync, err = flags.GetBool(localFsyncOption)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	options.DirectIO, err = flags.GetBool(localDirectIOOption)
+	return errors.Trace(err)
+}
+
+func (options *LocalOptions) fsync() bool {
+	return options != nil && options.Fsync
+}
+
+func (options *LocalOptions) directIO() bool {
+	return options != nil && options.DirectIO
+}
+
+// syncCloser fsyncs the file before closing it.
+type syncCloser struct {
+	file *os.File
+}
+
+func (c syncCloser) Close() error {
+	if err := c.file.Sync(); err != nil {
+		c.file.Close()
+		return errors.Trace(err)
+	}
+	return errors.Trace(c.file.Close())
+}
+
+// alignedBuffer returns an empty buffer of the capacity whose address is
+// aligned for O_DIRECT.
+func alignedBuffer(size int) []byte {
+	buf := make([]byte, size+directIOAlignment)
+	offset := 0
+	if rem := int(uintptr(unsafe.Pointer(&buf[0])) & (directIOAlignment - 1)); rem != 0 {
+		offset = directIOAlignment - rem
+	}
+	return buf[offset : offset : offset+size]
+}
+
+// directFileWriter writes the aligned blocks with O_DIRECT, and the unaligned
+// tail through a normal file at last.
+type directFileWriter struct {
+	file   *os.File
+	path   string
+	buf    []byte
+	offset int64
+	fsync  bool
+}
+
+func newDirectFileWriter(path string, fsync bool) (*directFileWriter, error) {
+	file, err := openDirect(path)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	return &directFileWriter{
+		file:  file,
+		path:  path,
+		buf:   alignedBuffer(directIOBufferSize),
+		fsync: fsync,
+	}, nil
+}
+
+func (w *directFileWriter) Write(ctx context.Context, p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		n := copy(w.buf[len(w.buf):cap(w.buf)], p)
+		w.buf = w.buf[:len(w.buf)+n]
+		p = p[n:]
+		written += n
+		if len(w.buf) == cap(w.buf) {
+			if err := w.writeBlocks(len(w.buf)); err != nil {
+				return written, errors.Trace(err)
+			}
+		}
+	}
+	return written, nil
+}
+
+// writeBlocks writes the first n bytes of the buffer, n must be aligned.
+func (w *directFileWriter) writeBlocks(n int) error {
+	if n == 0 {
+		return nil
+	}
+	if _, err := w.file.Write(w.buf[:n]); err != nil {
+		return errors.Trace(err)
+	}
+	w.offset += int64(n)
+	tail := copy(w.buf, w.buf[n:])
+	w.buf = w.buf[:tail]
+	return nil
+}
+
+func (w *directFileWriter) Close(ctx context.Context) error {
+	err := w.writeBlocks(len(w.buf) &^ (directIOAlignment - 1))
+	if e := w.file.Close(); err == nil {
+		err = e
+	}
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if len(w.buf) == 0 && !w.fsync {
+		return nil
+	}
+	file, err := os.OpenFile(w.path, os.O_WRONLY, localFilePerm)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if _, err = file.WriteAt(w.buf, w.offset); err != nil {
+		file.Close()
+		return errors.Trace(err)
+	}
+	if w.fsync {
+		return errors.Trace(syncCloser{file: file}.Close())
+	}
+	return errors.Trace(file.Close())
+}
+
+// ProbeWritable writes a file to the storage, reads it back and deletes it,
+// so a storage which isn't writable, e.g. a stale NFS mount, fails before the
+// task starts.
+func ProbeWritable(ctx context.Context, s ExternalStorage) error {
+	name := fmt.Sprintf("%s%d", probeFilePrefix, time.Now().UnixNano())
+	content := []byte(name)
+	if err := s.WriteFile(ctx, name, content); err != nil {
+		return errors.Annotatef(err, "storage %s is not writable", s.URI())
+	}
+	data, err := s.ReadFile(ctx, name)
+	if err != nil {
+		return errors.Annotatef(err, "failed to read back the probe file of storage %s", s.URI())
+	}
+	if !bytes.Equal(data, content) {
+		return errors.Annotatef(berrors.ErrStorageUnknown,
+			"the probe file of storage %s is read back as %q", s.URI(), data)
+	}
+	return errors.Trace(s.DeleteFile(ctx, name))
+}
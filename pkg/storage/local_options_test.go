@@ -0,0 +1,89 @@
+// Copyright 2021 PingCAP, Inc. Licensed under Apache-2.0.
+
+package storage
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+
+	. "github.com/pingcap/check"
+)
+
+func (r *testStorageSuite) TestLocalStorageFsync(c *C) {
+	ctx := context.Background()
+	dir := c.MkDir()
+	s, err := newLocalStorage(dir, &LocalOptions{Fsync: true})
+	c.Assert(err, IsNil)
+
+	c.Assert(s.WriteFile(ctx, "a", []byte("aaa")), IsNil)
+	w, err := s.Create(ctx, "b.tmp")
+	c.Assert(err, IsNil)
+	_, err = w.Write(ctx, []byte("bbb"))
+	c.Assert(err, IsNil)
+	c.Assert(w.Close(ctx), IsNil)
+	c.Assert(s.Rename(ctx, "b.tmp", "sub/b"), IsNil)
+
+	data, err := os.ReadFile(filepath.Join(dir, "a"))
+	c.Assert(err, IsNil)
+	c.Assert(data, DeepEquals, []byte("aaa"))
+	data, err = os.ReadFile(filepath.Join(dir, "sub", "b"))
+	c.Assert(err, IsNil)
+	c.Assert(data, DeepEquals, []byte("bbb"))
+}
+
+func (r *testStorageSuite) TestLocalStorageDirectIO(c *C) {
+	ctx := context.Background()
+	dir := c.MkDir()
+	if f, err := openDirect(filepath.Join(dir, "probe")); err != nil {
+		// e.g. tmpfs and non-Linux platforms.
+		c.Skip("O_DIRECT is not supported: " + err.Error())
+	} else {
+		f.Close()
+	}
+	s, err := newLocalStorage(dir, &LocalOptions{Fsync: true, DirectIO: true})
+	c.Assert(err, IsNil)
+
+	// both the aligned blocks and the unaligned tail.
+	content := bytes.Repeat([]byte("0123456789"), directIOBufferSize/5+123)
+	w, err := s.Create(ctx, "a")
+	c.Assert(err, IsNil)
+	for p := content; len(p) > 0; {
+		n := 7777
+		if n > len(p) {
+			n = len(p)
+		}
+		_, err = w.Write(ctx, p[:n])
+		c.Assert(err, IsNil)
+		p = p[n:]
+	}
+	c.Assert(w.Close(ctx), IsNil)
+	data, err := s.ReadFile(ctx, "a")
+	c.Assert(err, IsNil)
+	c.Assert(bytes.Equal(data, content), IsTrue)
+
+	c.Assert(s.WriteFile(ctx, "b", []byte("short")), IsNil)
+	data, err = s.ReadFile(ctx, "b")
+	c.Assert(err, IsNil)
+	c.Assert(data, DeepEquals, []byte("short"))
+}
+
+func (r *testStorageSuite) TestProbeWritable(c *C) {
+	ctx := context.Background()
+	dir := c.MkDir()
+	s, err := NewLocalStorage(dir)
+	c.Assert(err, IsNil)
+	c.Assert(ProbeWritable(ctx, s), IsNil)
+	entries, err := os.ReadDir(dir)
+	c.Assert(err, IsNil)
+	c.Assert(entries, HasLen, 0)
+
+	s, err = NewLocalStorage(filepath.Join(dir, "sub"))
+	c.Assert(err, IsNil)
+	c.Assert(os.Chmod(filepath.Join(dir, "sub"), 0o555), IsNil)
+	defer os.Chmod(filepath.Join(dir, "sub"), 0o755)
+	if os.Geteuid() != 0 {
+		c.Assert(ProbeWritable(ctx, s), ErrorMatches, ".*is not writable.*")
+	}
+}
@@ -4,11 +4,14 @@ package storage
 
 import (
 	"context"
+	"io"
 	"os"
 	"path/filepath"
+	"regexp"
 	"runtime"
 
 	. "github.com/pingcap/check"
+	"github.com/pingcap/errors"
 )
 
 type testLocalSuite struct{}
@@ -66,3 +69,97 @@ func (r *testStorageSuite) TestWalkDirWithSoftLinkFile(c *C) {
 	c.Assert(err, IsNil)
 	c.Assert(i, Equals, 2)
 }
+
+func (r *testStorageSuite) TestLocalReadRange(c *C) {
+	ctx := context.Background()
+	store, err := NewLocalStorage(c.MkDir())
+	c.Assert(err, IsNil)
+	err = store.WriteFile(ctx, "range.txt", []byte("0123456789"))
+	c.Assert(err, IsNil)
+
+	data, err := store.ReadRange(ctx, "range.txt", 3, 4)
+	c.Assert(err, IsNil)
+	c.Assert(string(data), Equals, "3456")
+
+	data, err = store.ReadRange(ctx, "range.txt", 7, -1)
+	c.Assert(err, IsNil)
+	c.Assert(string(data), Equals, "789")
+
+	_, err = store.ReadRange(ctx, "range.txt", 8, 5)
+	c.Assert(errors.Cause(err), Equals, io.ErrUnexpectedEOF)
+
+	// the offset after the end is an error, even if nothing is read.
+	_, err = store.ReadRange(ctx, "range.txt", 12, 1)
+	c.Assert(errors.Cause(err), Equals, io.ErrUnexpectedEOF)
+	_, err = store.ReadRange(ctx, "range.txt", 12, -1)
+	c.Assert(errors.Cause(err), Equals, io.ErrUnexpectedEOF)
+	_, err = store.ReadRange(ctx, "range.txt", 12, 0)
+	c.Assert(errors.Cause(err), Equals, io.ErrUnexpectedEOF)
+
+	data, err = store.ReadRange(ctx, "range.txt", 10, -1)
+	c.Assert(err, IsNil)
+	c.Assert(data, HasLen, 0)
+	data, err = store.ReadRange(ctx, "range.txt", 3, 0)
+	c.Assert(err, IsNil)
+	c.Assert(data, HasLen, 0)
+}
+
+func (r *testStorageSuite) TestWalkDirWithFilter(c *C) {
+	ctx := context.Background()
+	dir := c.MkDir()
+	c.Assert(os.MkdirAll(filepath.Join(dir, "t", "sub"), 0o755), IsNil)
+	store, err := NewLocalStorage(dir)
+	c.Assert(err, IsNil)
+	for _, name := range []string{"t/cdclog", "t/cdclog.1", "t/cdclog.2", "t/schema.json", "t/sub/cdclog.3", "cdclog.4"} {
+		c.Assert(store.WriteFile(ctx, name, []byte(name)), IsNil)
+	}
+	walk := func(opt *WalkOption) []string {
+		var paths []string
+		err := store.WalkDir(ctx, opt, func(path string, _ int64) error {
+			paths = append(paths, filepath.ToSlash(path))
+			return nil
+		})
+		c.Assert(err, IsNil)
+		return paths
+	}
+
+	c.Assert(walk(&WalkOption{SubDir: "t", Glob: "cdclog*"}), DeepEquals,
+		[]string{"t/cdclog", "t/cdclog.1", "t/cdclog.2"})
+	c.Assert(walk(&WalkOption{SubDir: "t", Glob: "cdclog*", StartAfter: "cdclog.1"}), DeepEquals,
+		[]string{"t/cdclog.2"})
+	c.Assert(walk(&WalkOption{SubDir: "t", Regexp: regexp.MustCompile(`cdclog\.\d+$`)}), DeepEquals,
+		[]string{"t/cdclog.1", "t/cdclog.2", "t/sub/cdclog.3"})
+
+	err = store.WalkDir(ctx, &WalkOption{Glob: "["}, func(string, int64) error { return nil })
+	c.Assert(err, ErrorMatches, ".*invalid glob pattern.*")
+}
+
+func (r *testStorageSuite) TestLocalRenameAndDelete(c *C) {
+	ctx := context.Background()
+	store, err := NewLocalStorage(c.MkDir())
+	c.Assert(err, IsNil)
+	for _, name := range []string{"meta.tmp", "v1/a", "v1/b", "v10/c"} {
+		c.Assert(store.WriteFile(ctx, name, []byte(name)), IsNil)
+	}
+
+	c.Assert(store.Rename(ctx, "meta.tmp", "sub/meta"), IsNil)
+	data, err := store.ReadFile(ctx, "sub/meta")
+	c.Assert(err, IsNil)
+	c.Assert(string(data), Equals, "meta.tmp")
+	exists, err := store.FileExists(ctx, "meta.tmp")
+	c.Assert(err, IsNil)
+	c.Assert(exists, IsFalse)
+
+	c.Assert(store.DeleteFile(ctx, "sub/meta"), IsNil)
+	c.Assert(store.DeleteFile(ctx, "sub/meta"), IsNil)
+
+	c.Assert(store.DeletePrefix(ctx, "v1/"), IsNil)
+	var paths []string
+	err = store.WalkDir(ctx, &WalkOption{}, func(path string, _ int64) error {
+		paths = append(paths, filepath.ToSlash(path))
+		return nil
+	})
+	c.Assert(err, IsNil)
+	c.Assert(paths, DeepEquals, []string{"v10/c"})
+	c.Assert(store.DeletePrefix(ctx, "missing/"), IsNil)
+}
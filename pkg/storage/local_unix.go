@@ -17,3 +17,17 @@ func mkdirAll(base string) error {
 	syscall.Umask(mask)
 	return errors.Trace(err)
 }
+
+// syncDir fsyncs the directory, so the entries created or renamed in it are
+// durable.
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if err = d.Sync(); err != nil {
+		d.Close()
+		return errors.Trace(err)
+	}
+	return errors.Trace(d.Close())
+}
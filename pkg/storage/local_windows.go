@@ -11,3 +11,8 @@ import (
 func mkdirAll(base string) error {
 	return os.MkdirAll(base, localDirPerm)
 }
+
+// syncDir is a no-op, directories can't be fsynced on Windows.
+func syncDir(dir string) error {
+	return nil
+}
@@ -0,0 +1,250 @@
+// Copyright 2021 PingCAP, Inc. Licensed under Apache-2.0.
+
+package storage
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/pingcap/errors"
+	backuppb "github.com/pingcap/kvproto/pkg/backup"
+	"github.com/prometheus/client_golang/prometheus"
+	"google.golang.org/api/googleapi"
+)
+
+var (
+	storageBytesCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "br",
+			Subsystem: "storage",
+			Name:      "bytes_total",
+			Help:      "The bytes read from and written to the external storage.",
+		}, []string{"backend", "type"})
+
+	storageRequestHistogram = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace: "br",
+			Subsystem: "storage",
+			Name:      "request_duration_seconds",
+			Help:      "The latency distributions of the external storage operations.",
+			Buckets:   prometheus.ExponentialBuckets(0.001, 2, 20),
+		}, []string{"backend", "operation"})
+
+	storageRetryCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "br",
+			Subsystem: "storage",
+			Name:      "retries_total",
+			Help:      "The retries of the external storage operations failed with transient errors.",
+		}, []string{"operation"})
+
+	storageErrorCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "br",
+			Subsystem: "storage",
+			Name:      "errors_total",
+			Help:      "The failed external storage operations by error codes.",
+		}, []string{"backend", "operation", "code"})
+)
+
+func init() { // nolint:gochecknoinits
+	prometheus.MustRegister(storageBytesCounter)
+	prometheus.MustRegister(storageRequestHistogram)
+	prometheus.MustRegister(storageRetryCounter)
+	prometheus.MustRegister(storageErrorCounter)
+}
+
+// backendName returns the name of the backend used as the metrics label.
+func backendName(backend *backuppb.StorageBackend) string {
+	switch backend.Backend.(type) {
+	case *backuppb.StorageBackend_Local:
+		return "local"
+	case *backuppb.StorageBackend_S3:
+		return "s3"
+	case *backuppb.StorageBackend_Gcs:
+		return "gcs"
+	case *backuppb.StorageBackend_Noop:
+		return "noop"
+	default:
+		return "unknown"
+	}
+}
+
+// errorCode returns the error code reported by the backend, which is the
+// HTTP status code if available.
+func errorCode(err error) string {
+	cause := errors.Cause(err)
+	switch cause { // nolint:errorlint
+	case context.Canceled:
+		return "canceled"
+	case context.DeadlineExceeded:
+		return "timeout"
+	}
+	switch e := cause.(type) { // nolint:errorlint
+	case awserr.RequestFailure:
+		return strconv.Itoa(e.StatusCode())
+	case awserr.Error:
+		return e.Code()
+	case *googleapi.Error:
+		return strconv.Itoa(e.Code)
+	}
+	return "unknown"
+}
+
+type withMetrics struct {
+	ExternalStorage
+	backend string
+}
+
+// WithMetrics returns an ExternalStorage which records the bytes, latency and
+// errors of the operations to the prometheus metrics.
+func WithMetrics(inner ExternalStorage, backend string) ExternalStorage {
+	return &withMetrics{ExternalStorage: inner, backend: backend}
+}
+
+func (m *withMetrics) observe(op string, start time.Time, err error) {
+	storageRequestHistogram.WithLabelValues(m.backend, op).Observe(time.Since(start).Seconds())
+	if err != nil {
+		storageErrorCounter.WithLabelValues(m.backend, op, errorCode(err)).Inc()
+	}
+}
+
+func (m *withMetrics) addBytes(typ string, n int) {
+	storageBytesCounter.WithLabelValues(m.backend, typ).Add(float64(n))
+}
+
+func (m *withMetrics) WriteFile(ctx context.Context, name string, data []byte) error {
+	start := time.Now()
+	err := m.ExternalStorage.WriteFile(ctx, name, data)
+	m.observe("WriteFile", start, err)
+	if err == nil {
+		m.addBytes("write", len(data))
+	}
+	return err
+}
+
+func (m *withMetrics) ReadFile(ctx context.Context, name string) ([]byte, error) {
+	start := time.Now()
+	data, err := m.ExternalStorage.ReadFile(ctx, name)
+	m.observe("ReadFile", start, err)
+	m.addBytes("read", len(data))
+	return data, err
+}
+
+func (m *withMetrics) ReadRange(ctx context.Context, name string, offset, length int64) ([]byte, error) {
+	start := time.Now()
+	data, err := m.ExternalStorage.ReadRange(ctx, name, offset, length)
+	m.observe("ReadRange", start, err)
+	m.addBytes("read", len(data))
+	return data, err
+}
+
+func (m *withMetrics) FileExists(ctx context.Context, name string) (bool, error) {
+	start := time.Now()
+	exists, err := m.ExternalStorage.FileExists(ctx, name)
+	m.observe("FileExists", start, err)
+	return exists, err
+}
+
+func (m *withMetrics) Open(ctx context.Context, path string) (ExternalFileReader, error) {
+	start := time.Now()
+	reader, err := m.ExternalStorage.Open(ctx, path)
+	m.observe("Open", start, err)
+	if err != nil {
+		return nil, err
+	}
+	return &metricsReader{ExternalFileReader: reader, storage: m}, nil
+}
+
+func (m *withMetrics) WalkDir(ctx context.Context, opt *WalkOption, fn func(path string, size int64) error) error {
+	start := time.Now()
+	var fnErr error
+	err := m.ExternalStorage.WalkDir(ctx, opt, func(path string, size int64) error {
+		fnErr = fn(path, size)
+		return fnErr
+	})
+	// the errors returned by fn aren't the errors of the storage.
+	if fnErr != nil {
+		m.observe("WalkDir", start, nil)
+	} else {
+		m.observe("WalkDir", start, err)
+	}
+	return err
+}
+
+func (m *withMetrics) Create(ctx context.Context, path string) (ExternalFileWriter, error) {
+	return m.create(ctx, path, m.ExternalStorage.Create)
+}
+
+// CreateUploader creates the uploader of the inner storage, see uploaderCreator.
+func (m *withMetrics) CreateUploader(ctx context.Context, path string) (ExternalFileWriter, error) {
+	return m.create(ctx, path, func(ctx context.Context, path string) (ExternalFileWriter, error) {
+		return createUploader(ctx, m.ExternalStorage, path)
+	})
+}
+
+func (m *withMetrics) create(
+	ctx context.Context,
+	path string,
+	fn func(context.Context, string) (ExternalFileWriter, error),
+) (ExternalFileWriter, error) {
+	start := time.Now()
+	writer, err := fn(ctx, path)
+	m.observe("Create", start, err)
+	if err != nil {
+		return nil, err
+	}
+	return &metricsWriter{ExternalFileWriter: writer, storage: m}, nil
+}
+
+func (m *withMetrics) Rename(ctx context.Context, oldName, newName string) error {
+	start := time.Now()
+	err := m.ExternalStorage.Rename(ctx, oldName, newName)
+	m.observe("Rename", start, err)
+	return err
+}
+
+func (m *withMetrics) DeleteFile(ctx context.Context, name string) error {
+	start := time.Now()
+	err := m.ExternalStorage.DeleteFile(ctx, name)
+	m.observe("DeleteFile", start, err)
+	return err
+}
+
+func (m *withMetrics) DeletePrefix(ctx context.Context, prefix string) error {
+	start := time.Now()
+	err := m.ExternalStorage.DeletePrefix(ctx, prefix)
+	m.observe("DeletePrefix", start, err)
+	return err
+}
+
+type metricsReader struct {
+	ExternalFileReader
+	storage *withMetrics
+}
+
+func (r *metricsReader) Read(p []byte) (int, error) {
+	n, err := r.ExternalFileReader.Read(p)
+	r.storage.addBytes("read", n)
+	return n, err
+}
+
+type metricsWriter struct {
+	ExternalFileWriter
+	storage *withMetrics
+}
+
+func (w *metricsWriter) Write(ctx context.Context, p []byte) (int, error) {
+	n, err := w.ExternalFileWriter.Write(ctx, p)
+	w.storage.addBytes("write", n)
+	return n, err
+}
+
+func (w *metricsWriter) Close(ctx context.Context) error {
+	start := time.Now()
+	err := w.ExternalFileWriter.Close(ctx)
+	w.storage.observe("CloseWriter", start, err)
+	return err
+}
@@ -0,0 +1,49 @@
+// Copyright 2021 PingCAP, Inc. Licensed under Apache-2.0.
+
+package storage
+
+import (
+	"context"
+	"io"
+	"net/http"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	. "github.com/pingcap/check"
+	"github.com/pingcap/errors"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"google.golang.org/api/googleapi"
+)
+
+func (r *testStorageSuite) TestMetrics(c *C) {
+	ctx := context.Background()
+	local, err := NewLocalStorage(c.MkDir())
+	c.Assert(err, IsNil)
+	s := WithMetrics(local, "test")
+
+	written := testutil.ToFloat64(storageBytesCounter.WithLabelValues("test", "write"))
+	read := testutil.ToFloat64(storageBytesCounter.WithLabelValues("test", "read"))
+	failed := testutil.ToFloat64(storageErrorCounter.WithLabelValues("test", "ReadFile", "unknown"))
+
+	c.Assert(s.WriteFile(ctx, "a", []byte("12345")), IsNil)
+	_, err = s.ReadFile(ctx, "a")
+	c.Assert(err, IsNil)
+	reader, err := s.Open(ctx, "a")
+	c.Assert(err, IsNil)
+	_, err = io.ReadAll(reader)
+	c.Assert(err, IsNil)
+	c.Assert(reader.Close(), IsNil)
+	_, err = s.ReadFile(ctx, "missing")
+	c.Assert(err, NotNil)
+
+	c.Assert(testutil.ToFloat64(storageBytesCounter.WithLabelValues("test", "write"))-written, Equals, float64(5))
+	c.Assert(testutil.ToFloat64(storageBytesCounter.WithLabelValues("test", "read"))-read, Equals, float64(10))
+	c.Assert(testutil.ToFloat64(storageErrorCounter.WithLabelValues("test", "ReadFile", "unknown"))-failed, Equals, float64(1))
+}
+
+func (r *testStorageSuite) TestErrorCode(c *C) {
+	c.Assert(errorCode(errors.Trace(context.Canceled)), Equals, "canceled")
+	c.Assert(errorCode(awserr.NewRequestFailure(awserr.New("SlowDown", "", nil), http.StatusServiceUnavailable, "")), Equals, "503")
+	c.Assert(errorCode(awserr.New("NoSuchKey", "", nil)), Equals, "NoSuchKey")
+	c.Assert(errorCode(&googleapi.Error{Code: http.StatusTooManyRequests}), Equals, "429")
+	c.Assert(errorCode(errors.New("other")), Equals, "unknown")
+}
@@ -0,0 +1,266 @@
+// Copyright 2021 PingCAP, Inc. Licensed under Apache-2.0.
+
+package storage
+
+import (
+	"context"
+	"io"
+	"sync/atomic"
+	"time"
+
+	"github.com/pingcap/errors"
+	"github.com/pingcap/log"
+	"go.uber.org/zap"
+	"golang.org/x/sync/errgroup"
+
+	berrors "github.com/pingcap/br/pkg/errors"
+)
+
+// mirrorCopyBufferSize is the size of the chunks copied to the mirror.
+const mirrorCopyBufferSize = 4 * 1024 * 1024
+
+// MirrorPolicy is the action taken when a write to the mirror fails.
+type MirrorPolicy string
+
+const (
+	// MirrorPolicyFail fails the write.
+	MirrorPolicyFail MirrorPolicy = "fail"
+	// MirrorPolicyWarn logs a warning and stops writing to the mirror, so the
+	// incomplete mirror never gets the files written at the end, e.g. the
+	// backupmeta.
+	MirrorPolicyWarn MirrorPolicy = "warn"
+)
+
+// Validate checks whether the policy is supported.
+func (p MirrorPolicy) Validate() error {
+	switch p {
+	case MirrorPolicyFail, MirrorPolicyWarn:
+		return nil
+	default:
+		return errors.Annotatef(berrors.ErrInvalidArgument,
+			"unknown mirror failure policy %q, should be one of 'fail|warn'", p)
+	}
+}
+
+type withMirror struct {
+	ExternalStorage
+	mirror ExternalStorage
+	policy MirrorPolicy
+	// broken is set once a write to the mirror fails with the warn policy.
+	broken int32
+}
+
+// WithMirror returns an ExternalStorage writing every file to both the primary
+// and the mirror storages. The reads are served by the primary. A failed write
+// to the primary always fails, while the failure of the mirror is handled by
+// the policy.
+//
+// The files written by others directly to the primary, e.g. the SST files
+// written by TiKV, are copied to the mirror by SyncMirror.
+func WithMirror(primary, mirror ExternalStorage, policy MirrorPolicy) ExternalStorage {
+	return &withMirror{ExternalStorage: primary, mirror: mirror, policy: policy}
+}
+
+func (m *withMirror) isBroken() bool {
+	return atomic.LoadInt32(&m.broken) != 0
+}
+
+// handle handles the error of the mirror, it returns nil if the error is
+// tolerated by the policy.
+func (m *withMirror) handle(err error, op, name string) error {
+	if err == nil {
+		return nil
+	}
+	if m.policy == MirrorPolicyWarn {
+		if atomic.CompareAndSwapInt32(&m.broken, 0, 1) {
+			log.Warn("failed to write to the mirror storage, stop mirroring",
+				zap.String("mirror", m.mirror.URI()), zap.String("op", op), zap.String("name", name), zap.Error(err))
+		}
+		return nil
+	}
+	return errors.Annotatef(err, "failed to %s %s in the mirror storage %s", op, name, m.mirror.URI())
+}
+
+func (m *withMirror) WriteFile(ctx context.Context, name string, data []byte) error {
+	if err := m.ExternalStorage.WriteFile(ctx, name, data); err != nil {
+		return errors.Trace(err)
+	}
+	if m.isBroken() {
+		return nil
+	}
+	return m.handle(m.mirror.WriteFile(ctx, name, data), "write", name)
+}
+
+func (m *withMirror) Create(ctx context.Context, name string) (ExternalFileWriter, error) {
+	w, err := m.ExternalStorage.Create(ctx, name)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	writer := &mirrorWriter{ExternalFileWriter: w, storage: m, name: name}
+	if !m.isBroken() {
+		writer.mirror, err = m.mirror.Create(ctx, name)
+		if err = m.handle(err, "create", name); err != nil {
+			return nil, errors.Trace(err)
+		}
+	}
+	return writer, nil
+}
+
+func (m *withMirror) Rename(ctx context.Context, oldName, newName string) error {
+	if err := m.ExternalStorage.Rename(ctx, oldName, newName); err != nil {
+		return errors.Trace(err)
+	}
+	if m.isBroken() {
+		return nil
+	}
+	return m.handle(m.mirror.Rename(ctx, oldName, newName), "rename", oldName)
+}
+
+func (m *withMirror) DeleteFile(ctx context.Context, name string) error {
+	if err := m.ExternalStorage.DeleteFile(ctx, name); err != nil {
+		return errors.Trace(err)
+	}
+	if m.isBroken() {
+		return nil
+	}
+	return m.handle(m.mirror.DeleteFile(ctx, name), "delete", name)
+}
+
+func (m *withMirror) DeletePrefix(ctx context.Context, prefix string) error {
+	if err := m.ExternalStorage.DeletePrefix(ctx, prefix); err != nil {
+		return errors.Trace(err)
+	}
+	if m.isBroken() {
+		return nil
+	}
+	return m.handle(m.mirror.DeletePrefix(ctx, prefix), "delete prefix", prefix)
+}
+
+type mirrorWriter struct {
+	ExternalFileWriter
+	// mirror is nil once the mirror is broken.
+	mirror  ExternalFileWriter
+	storage *withMirror
+	name    string
+}
+
+func (w *mirrorWriter) Write(ctx context.Context, p []byte) (int, error) {
+	n, err := w.ExternalFileWriter.Write(ctx, p)
+	if err != nil {
+		return n, errors.Trace(err)
+	}
+	if w.mirror != nil {
+		_, err = w.mirror.Write(ctx, p)
+		if err != nil {
+			w.mirror = nil
+		}
+		if err = w.storage.handle(err, "write", w.name); err != nil {
+			return n, errors.Trace(err)
+		}
+	}
+	return n, nil
+}
+
+func (w *mirrorWriter) Close(ctx context.Context) error {
+	if err := w.ExternalFileWriter.Close(ctx); err != nil {
+		return errors.Trace(err)
+	}
+	if w.mirror == nil || w.storage.isBroken() {
+		return nil
+	}
+	return w.storage.handle(w.mirror.Close(ctx), "write", w.name)
+}
+
+// SyncMirror copies the files missing in the mirror from the primary, if the
+// storage is created by WithMirror. The files are compared by their sizes.
+func SyncMirror(ctx context.Context, s ExternalStorage, concurrency uint) error {
+	m, ok := s.(*withMirror)
+	if !ok || m.isBroken() {
+		return nil
+	}
+	start := time.Now()
+	mirrored := make(map[string]int64)
+	err := m.mirror.WalkDir(ctx, &WalkOption{}, func(name string, size int64) error {
+		mirrored[name] = size
+		return nil
+	})
+	if err != nil {
+		return m.handle(err, "list", "")
+	}
+	var names []string
+	err = m.ExternalStorage.WalkDir(ctx, &WalkOption{}, func(name string, size int64) error {
+		if mirroredSize, ok := mirrored[name]; !ok || mirroredSize != size {
+			names = append(names, name)
+		}
+		return nil
+	})
+	if err != nil {
+		return errors.Trace(err)
+	}
+
+	if concurrency == 0 {
+		concurrency = 1
+	}
+	eg, ectx := errgroup.WithContext(ctx)
+	workers := make(chan struct{}, concurrency)
+	for _, name := range names {
+		name := name
+		select {
+		case <-ectx.Done():
+			if err = eg.Wait(); err != nil {
+				return errors.Trace(err)
+			}
+			return errors.Trace(ctx.Err())
+		case workers <- struct{}{}:
+		}
+		eg.Go(func() error {
+			defer func() { <-workers }()
+			if m.isBroken() {
+				return nil
+			}
+			return m.handle(m.copyToMirror(ectx, name), "copy", name)
+		})
+	}
+	if err = eg.Wait(); err != nil {
+		return errors.Trace(err)
+	}
+	log.Info("mirror storage synced",
+		zap.String("mirror", m.mirror.URI()),
+		zap.Int("files", len(names)),
+		zap.Bool("broken", m.isBroken()),
+		zap.Duration("take", time.Since(start)))
+	return nil
+}
+
+func (m *withMirror) copyToMirror(ctx context.Context, name string) error {
+	r, err := m.ExternalStorage.Open(ctx, name)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	defer r.Close()
+	w, err := m.mirror.Create(ctx, name)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	return errors.Trace(copyAndClose(ctx, r, w))
+}
+
+// copyAndClose copies the content of r to w in chunks and closes w.
+func copyAndClose(ctx context.Context, r io.Reader, w ExternalFileWriter) error {
+	buf := make([]byte, mirrorCopyBufferSize)
+	for {
+		n, err := io.ReadFull(r, buf)
+		if n > 0 {
+			if _, e := w.Write(ctx, buf[:n]); e != nil {
+				return errors.Trace(e)
+			}
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		}
+		if err != nil {
+			return errors.Trace(err)
+		}
+	}
+	return errors.Trace(w.Close(ctx))
+}
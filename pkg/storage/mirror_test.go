@@ -0,0 +1,70 @@
+// Copyright 2021 PingCAP, Inc. Licensed under Apache-2.0.
+
+package storage
+
+import (
+	"context"
+
+	. "github.com/pingcap/check"
+	"github.com/pingcap/errors"
+)
+
+// failingStorage fails all writes.
+type failingStorage struct {
+	ExternalStorage
+}
+
+func (s *failingStorage) WriteFile(ctx context.Context, name string, data []byte) error {
+	return errors.New("injected")
+}
+
+func (r *testStorageSuite) TestMirror(c *C) {
+	ctx := context.Background()
+	primary, err := NewLocalStorage(c.MkDir())
+	c.Assert(err, IsNil)
+	mirror, err := NewLocalStorage(c.MkDir())
+	c.Assert(err, IsNil)
+	s := WithMirror(primary, mirror, MirrorPolicyFail)
+
+	c.Assert(s.WriteFile(ctx, "a", []byte("1")), IsNil)
+	w, err := s.Create(ctx, "b")
+	c.Assert(err, IsNil)
+	_, err = w.Write(ctx, []byte("22"))
+	c.Assert(err, IsNil)
+	c.Assert(w.Close(ctx), IsNil)
+	// the file written directly to the primary is copied by the sync.
+	c.Assert(primary.WriteFile(ctx, "1.sst", []byte("333")), IsNil)
+	c.Assert(SyncMirror(ctx, s, 2), IsNil)
+	for name, content := range map[string]string{"a": "1", "b": "22", "1.sst": "333"} {
+		data, err := mirror.ReadFile(ctx, name)
+		c.Assert(err, IsNil)
+		c.Assert(string(data), Equals, content)
+	}
+	c.Assert(s.DeleteFile(ctx, "a"), IsNil)
+	exists, err := mirror.FileExists(ctx, "a")
+	c.Assert(err, IsNil)
+	c.Assert(exists, IsFalse)
+
+	s = WithMirror(primary, &failingStorage{ExternalStorage: mirror}, MirrorPolicyFail)
+	c.Assert(s.WriteFile(ctx, "c", []byte("4")), ErrorMatches, ".*injected.*")
+
+	// the mirror stops being written after the failure with the warn policy.
+	s = WithMirror(primary, &failingStorage{ExternalStorage: mirror}, MirrorPolicyWarn)
+	c.Assert(s.WriteFile(ctx, "c", []byte("4")), IsNil)
+	w, err = s.Create(ctx, "d")
+	c.Assert(err, IsNil)
+	_, err = w.Write(ctx, []byte("5"))
+	c.Assert(err, IsNil)
+	c.Assert(w.Close(ctx), IsNil)
+	c.Assert(SyncMirror(ctx, s, 2), IsNil)
+	for _, name := range []string{"c", "d"} {
+		exists, err = mirror.FileExists(ctx, name)
+		c.Assert(err, IsNil)
+		c.Assert(exists, IsFalse)
+		exists, err = primary.FileExists(ctx, name)
+		c.Assert(err, IsNil)
+		c.Assert(exists, IsTrue)
+	}
+
+	c.Assert(MirrorPolicy("ignore").Validate(), ErrorMatches, ".*unknown mirror failure policy.*")
+}
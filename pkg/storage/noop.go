@@ -18,6 +18,11 @@ func (*noopStorage) ReadFile(ctx context.Context, name string) ([]byte, error) {
 	return []byte{}, nil
 }
 
+// ReadRange reads a byte range of the storage file.
+func (*noopStorage) ReadRange(ctx context.Context, name string, offset, length int64) ([]byte, error) {
+	return []byte{}, nil
+}
+
 // FileExists return true if file exists.
 func (*noopStorage) FileExists(ctx context.Context, name string) (bool, error) {
 	return false, nil
@@ -42,6 +47,21 @@ func (*noopStorage) Create(ctx context.Context, name string) (ExternalFileWriter
 	return &noopWriter{}, nil
 }
 
+// Rename implements ExternalStorage interface.
+func (*noopStorage) Rename(ctx context.Context, oldName, newName string) error {
+	return nil
+}
+
+// DeleteFile implements ExternalStorage interface.
+func (*noopStorage) DeleteFile(ctx context.Context, name string) error {
+	return nil
+}
+
+// DeletePrefix implements ExternalStorage interface.
+func (*noopStorage) DeletePrefix(ctx context.Context, prefix string) error {
+	return nil
+}
+
 func newNoopStorage() *noopStorage {
 	return &noopStorage{}
 }
@@ -4,6 +4,7 @@ package storage
 
 import (
 	"net/url"
+	"path"
 	"path/filepath"
 	"reflect"
 	"strconv"
@@ -20,6 +21,25 @@ import (
 type BackendOptions struct {
 	S3  S3BackendOptions  `json:"s3" toml:"s3"`
 	GCS GCSBackendOptions `json:"gcs" toml:"gcs"`
+	// GCSUpload tunes the uploads by BR to GCS.
+	GCSUpload GCSUploadOptions `json:"gcs-upload" toml:"gcs-upload"`
+	// S3Upload tunes the multipart uploads by BR to S3.
+	S3Upload S3UploadOptions `json:"s3-upload" toml:"s3-upload"`
+	// S3RequestBudget paces the requests by BR to each prefix of S3.
+	S3RequestBudget S3RequestBudgetOptions `json:"s3-request-budget" toml:"s3-request-budget"`
+	// Local tunes the writes by BR to the local storage.
+	Local LocalOptions `json:"local" toml:"local"`
+	// Retry configures the retry layer wrapped around all backends.
+	Retry RetryOptions `json:"retry" toml:"retry"`
+	// RateLimit is the max bytes per second read from and written to the
+	// backend by BR. 0 means unlimited.
+	RateLimit uint64 `json:"rate-limit" toml:"rate-limit"`
+	// Proxy is the URL of the HTTP or SOCKS5 proxy of BR accessing the
+	// backend, empty means using the environment variables.
+	Proxy string `json:"proxy" toml:"proxy"`
+	// Credentials chooses the provider of the credentials of BR accessing the
+	// backend.
+	Credentials CredentialOptions `json:"credentials" toml:"credentials"`
 }
 
 // ParseRawURL parse raw url to url object.
@@ -71,6 +91,7 @@ func ParseBackend(rawURL string, options *BackendOptions) (*backuppb.StorageBack
 		if options == nil {
 			options = &BackendOptions{S3: S3BackendOptions{ForcePathStyle: true}}
 		}
+		extractProxy(u, options)
 		ExtractQueryParameters(u, &options.S3)
 		if err := options.S3.Apply(s3); err != nil {
 			return nil, errors.Trace(err)
@@ -86,6 +107,7 @@ func ParseBackend(rawURL string, options *BackendOptions) (*backuppb.StorageBack
 		if options == nil {
 			options = &BackendOptions{}
 		}
+		extractProxy(u, options)
 		ExtractQueryParameters(u, &options.GCS)
 		if err := options.GCS.apply(gcs); err != nil {
 			return nil, errors.Trace(err)
@@ -170,3 +192,59 @@ func FormatBackendURL(backend *backuppb.StorageBackend) (u url.URL) {
 	}
 	return
 }
+
+// SubBackend returns a copy of the backend whose root is the sub directory
+// `dir` of the origin one.
+func SubBackend(backend *backuppb.StorageBackend, dir string) (*backuppb.StorageBackend, error) {
+	switch b := backend.Backend.(type) {
+	case *backuppb.StorageBackend_Local:
+		local := *b.Local
+		local.Path = filepath.Join(local.Path, dir)
+		return &backuppb.StorageBackend{Backend: &backuppb.StorageBackend_Local{Local: &local}}, nil
+	case *backuppb.StorageBackend_Noop:
+		return backend, nil
+	case *backuppb.StorageBackend_S3:
+		s3 := *b.S3
+		s3.Prefix = path.Join(s3.Prefix, dir)
+		return &backuppb.StorageBackend{Backend: &backuppb.StorageBackend_S3{S3: &s3}}, nil
+	case *backuppb.StorageBackend_Gcs:
+		gcs := *b.Gcs
+		gcs.Prefix = path.Join(gcs.Prefix, dir)
+		return &backuppb.StorageBackend{Backend: &backuppb.StorageBackend_Gcs{Gcs: &gcs}}, nil
+	default:
+		return nil, errors.Annotatef(berrors.ErrStorageInvalidConfig, "storage %T doesn't support sub directory", b)
+	}
+}
+
+// ParentBackend returns a copy of the backend whose root is the parent
+// directory of the origin one, and the name of the origin one in the parent.
+func ParentBackend(backend *backuppb.StorageBackend) (*backuppb.StorageBackend, string, error) {
+	switch b := backend.Backend.(type) {
+	case *backuppb.StorageBackend_Local:
+		local := *b.Local
+		dir, name := filepath.Split(filepath.Clean(local.Path))
+		if name == "" {
+			break
+		}
+		local.Path = filepath.Clean(dir)
+		return &backuppb.StorageBackend{Backend: &backuppb.StorageBackend_Local{Local: &local}}, name, nil
+	case *backuppb.StorageBackend_S3:
+		s3 := *b.S3
+		dir, name := path.Split(strings.Trim(s3.Prefix, "/"))
+		if name == "" {
+			break
+		}
+		s3.Prefix = strings.TrimSuffix(dir, "/")
+		return &backuppb.StorageBackend{Backend: &backuppb.StorageBackend_S3{S3: &s3}}, name, nil
+	case *backuppb.StorageBackend_Gcs:
+		gcs := *b.Gcs
+		dir, name := path.Split(strings.Trim(gcs.Prefix, "/"))
+		if name == "" {
+			break
+		}
+		gcs.Prefix = strings.TrimSuffix(dir, "/")
+		return &backuppb.StorageBackend{Backend: &backuppb.StorageBackend_Gcs{Gcs: &gcs}}, name, nil
+	}
+	u := FormatBackendURL(backend)
+	return nil, "", errors.Annotatef(berrors.ErrStorageInvalidConfig, "storage %s has no parent directory", u.String())
+}
@@ -79,6 +79,11 @@ func (r *testStorageSuite) TestCreateStorage(c *C) {
 	c.Assert(s3.SecretAccessKey, Equals, "nREY/7Dt+PaIbYKrKlEEMMF/ExCiJEX=XMLPUANw")
 	c.Assert(s3.ForcePathStyle, IsTrue)
 
+	s3opt = &BackendOptions{}
+	_, err = ParseBackend(`s3://bucket5/prefix?requester-pays=true`, s3opt)
+	c.Assert(err, IsNil)
+	c.Assert(s3opt.S3.RequesterPays, IsTrue)
+
 	gcsOpt := &BackendOptions{
 		GCS: GCSBackendOptions{
 			Endpoint: "https://gcs.example.com/",
@@ -174,3 +179,61 @@ func (r *testStorageSuite) TestFormatBackendURL(c *C) {
 	})
 	c.Assert(url.String(), Equals, "gcs://bucket/some%20prefix/")
 }
+
+func (r *testStorageSuite) TestSubBackend(c *C) {
+	origin := &backuppb.StorageBackend{
+		Backend: &backuppb.StorageBackend_S3{
+			S3: &backuppb.S3{Bucket: "bucket", Prefix: "prefix", Endpoint: "https://s3.example.com/"},
+		},
+	}
+	sub, err := SubBackend(origin, "db")
+	c.Assert(err, IsNil)
+	c.Assert(sub.GetS3().Prefix, Equals, "prefix/db")
+	c.Assert(sub.GetS3().Endpoint, Equals, "https://s3.example.com/")
+	c.Assert(origin.GetS3().Prefix, Equals, "prefix")
+
+	sub, err = SubBackend(&backuppb.StorageBackend{
+		Backend: &backuppb.StorageBackend_Local{Local: &backuppb.Local{Path: "/tmp/backup"}},
+	}, "db")
+	c.Assert(err, IsNil)
+	c.Assert(sub.GetLocal().Path, Equals, "/tmp/backup/db")
+
+	sub, err = SubBackend(&backuppb.StorageBackend{
+		Backend: &backuppb.StorageBackend_Gcs{Gcs: &backuppb.GCS{Bucket: "bucket"}},
+	}, "db")
+	c.Assert(err, IsNil)
+	c.Assert(sub.GetGcs().Prefix, Equals, "db")
+}
+
+func (r *testStorageSuite) TestParentBackend(c *C) {
+	origin := &backuppb.StorageBackend{
+		Backend: &backuppb.StorageBackend_S3{
+			S3: &backuppb.S3{Bucket: "bucket", Prefix: "prefix/inc1/", Endpoint: "https://s3.example.com/"},
+		},
+	}
+	parent, name, err := ParentBackend(origin)
+	c.Assert(err, IsNil)
+	c.Assert(name, Equals, "inc1")
+	c.Assert(parent.GetS3().Prefix, Equals, "prefix")
+	c.Assert(parent.GetS3().Endpoint, Equals, "https://s3.example.com/")
+	c.Assert(origin.GetS3().Prefix, Equals, "prefix/inc1/")
+
+	parent, name, err = ParentBackend(&backuppb.StorageBackend{
+		Backend: &backuppb.StorageBackend_Local{Local: &backuppb.Local{Path: "/tmp/backup/"}},
+	})
+	c.Assert(err, IsNil)
+	c.Assert(name, Equals, "backup")
+	c.Assert(parent.GetLocal().Path, Equals, "/tmp")
+
+	parent, name, err = ParentBackend(&backuppb.StorageBackend{
+		Backend: &backuppb.StorageBackend_Gcs{Gcs: &backuppb.GCS{Bucket: "bucket", Prefix: "full"}},
+	})
+	c.Assert(err, IsNil)
+	c.Assert(name, Equals, "full")
+	c.Assert(parent.GetGcs().Prefix, Equals, "")
+
+	_, _, err = ParentBackend(&backuppb.StorageBackend{
+		Backend: &backuppb.StorageBackend_S3{S3: &backuppb.S3{Bucket: "bucket"}},
+	})
+	c.Assert(err, ErrorMatches, ".*has no parent directory.*")
+}
@@ -0,0 +1,86 @@
+// Copyright 2021 PingCAP, Inc. Licensed under Apache-2.0.
+
+package storage
+
+import (
+	"net/http"
+	"net/url"
+
+	"github.com/pingcap/errors"
+	"github.com/spf13/pflag"
+
+	berrors "github.com/pingcap/br/pkg/errors"
+)
+
+const (
+	storageProxyOption = "storage.proxy"
+	// proxyQueryParameter is the query parameter of the storage URL to set
+	// the proxy, e.g. `s3://bucket/prefix?proxy=http://10.0.0.1:3128`.
+	proxyQueryParameter = "proxy"
+)
+
+func defineProxyFlags(flags *pflag.FlagSet) {
+	flags.String(storageProxyOption, "",
+		"the proxy of BR accessing the S3 or GCS storage, e.g. http://10.0.0.1:3128 or socks5://10.0.0.1:1080. "+
+			"It takes precedence over the HTTP_PROXY environment variables, and doesn't affect TiKV")
+}
+
+func parseProxy(flags *pflag.FlagSet) (string, error) {
+	proxy, err := flags.GetString(storageProxyOption)
+	if err != nil {
+		return "", errors.Trace(err)
+	}
+	if proxy != "" {
+		if _, err = parseProxyURL(proxy); err != nil {
+			return "", errors.Trace(err)
+		}
+	}
+	return proxy, nil
+}
+
+// extractProxy moves the proxy query parameter of the URL into the options.
+func extractProxy(u *url.URL, options *BackendOptions) {
+	query := u.Query()
+	if proxy := query.Get(proxyQueryParameter); proxy != "" {
+		options.Proxy = proxy
+		query.Del(proxyQueryParameter)
+		u.RawQuery = query.Encode()
+	}
+}
+
+func parseProxyURL(proxy string) (*url.URL, error) {
+	u, err := url.Parse(proxy)
+	if err != nil {
+		return nil, errors.Annotatef(berrors.ErrStorageInvalidConfig, "invalid proxy %q: %v", proxy, err)
+	}
+	switch u.Scheme {
+	case "http", "https", "socks5":
+	default:
+		return nil, errors.Annotatef(berrors.ErrStorageInvalidConfig,
+			"unsupported scheme of proxy %q, should be http, https or socks5", proxy)
+	}
+	if u.Host == "" {
+		return nil, errors.Annotatef(berrors.ErrStorageInvalidConfig, "host not found in proxy %q", proxy)
+	}
+	return u, nil
+}
+
+// proxyTransport returns a transport sending the requests through the proxy.
+// It's cloned from the transport of the client if any.
+func proxyTransport(client *http.Client, proxy string) (*http.Transport, error) {
+	proxyURL, err := parseProxyURL(proxy)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	base := http.DefaultTransport
+	if client != nil && client.Transport != nil {
+		base = client.Transport
+	}
+	transport, ok := base.(*http.Transport)
+	if !ok {
+		return nil, errors.Annotatef(berrors.ErrStorageInvalidConfig, "cannot set proxy on the transport %T", base)
+	}
+	transport = transport.Clone()
+	transport.Proxy = http.ProxyURL(proxyURL)
+	return transport, nil
+}
@@ -0,0 +1,40 @@
+// Copyright 2021 PingCAP, Inc. Licensed under Apache-2.0.
+
+package storage
+
+import (
+	"net/http"
+
+	. "github.com/pingcap/check"
+)
+
+func (r *testStorageSuite) TestProxy(c *C) {
+	options := &BackendOptions{}
+	s, err := ParseBackend("s3://bucket/prefix?proxy=socks5://10.0.0.1:1080&region=us-west-2", options)
+	c.Assert(err, IsNil)
+	c.Assert(options.Proxy, Equals, "socks5://10.0.0.1:1080")
+	c.Assert(s.GetS3().Region, Equals, "us-west-2")
+
+	transport, err := proxyTransport(nil, options.Proxy)
+	c.Assert(err, IsNil)
+	req, err := http.NewRequest(http.MethodGet, "https://s3.amazonaws.com/bucket", nil)
+	c.Assert(err, IsNil)
+	proxyURL, err := transport.Proxy(req)
+	c.Assert(err, IsNil)
+	c.Assert(proxyURL.String(), Equals, "socks5://10.0.0.1:1080")
+	// the default transport isn't modified.
+	c.Assert(http.DefaultTransport.(*http.Transport).Proxy, Not(IsNil))
+
+	_, err = proxyTransport(nil, "ftp://10.0.0.1")
+	c.Assert(err, ErrorMatches, ".*unsupported scheme of proxy.*")
+	_, err = proxyTransport(nil, "http://")
+	c.Assert(err, ErrorMatches, ".*host not found in proxy.*")
+	_, err = proxyTransport(&http.Client{Transport: roundTripperFunc(nil)}, "http://10.0.0.1:3128")
+	c.Assert(err, ErrorMatches, ".*cannot set proxy on the transport.*")
+}
+
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
@@ -0,0 +1,50 @@
+// Copyright 2021 PingCAP, Inc. Licensed under Apache-2.0.
+
+package storage
+
+import (
+	"context"
+	"io"
+
+	"github.com/pingcap/errors"
+)
+
+type rangeReader struct {
+	ctx       context.Context
+	storage   ExternalStorage
+	name      string
+	chunkSize int64
+
+	offset int64
+	buf    []byte
+	eof    bool
+}
+
+// NewRangeReader returns a reader of the file which fetches the file by
+// ReadRange in chunks of chunkSize, so only a chunk of a large file is held in
+// memory. The size of the file needn't be known: the last chunk shorter than
+// chunkSize is fetched again until the end of the file.
+func NewRangeReader(ctx context.Context, s ExternalStorage, name string, chunkSize int64) io.Reader {
+	return &rangeReader{ctx: ctx, storage: s, name: name, chunkSize: chunkSize}
+}
+
+func (r *rangeReader) Read(p []byte) (int, error) {
+	for len(r.buf) == 0 {
+		if r.eof {
+			return 0, io.EOF
+		}
+		data, err := r.storage.ReadRange(r.ctx, r.name, r.offset, r.chunkSize)
+		if errors.Cause(err) == io.ErrUnexpectedEOF { // nolint:errorlint
+			data, err = r.storage.ReadRange(r.ctx, r.name, r.offset, -1)
+			r.eof = true
+		}
+		if err != nil {
+			return 0, errors.Trace(err)
+		}
+		r.offset += int64(len(data))
+		r.buf = data
+	}
+	n := copy(p, r.buf)
+	r.buf = r.buf[n:]
+	return n, nil
+}
@@ -0,0 +1,44 @@
+// Copyright 2021 PingCAP, Inc. Licensed under Apache-2.0.
+
+package storage
+
+import (
+	"context"
+	"io"
+
+	. "github.com/pingcap/check"
+)
+
+type rangeCounter struct {
+	ExternalStorage
+	lengths []int64
+}
+
+func (r *rangeCounter) ReadRange(ctx context.Context, name string, offset, length int64) ([]byte, error) {
+	r.lengths = append(r.lengths, length)
+	return r.ExternalStorage.ReadRange(ctx, name, offset, length)
+}
+
+func (r *testLocalSuite) TestRangeReader(c *C) {
+	ctx := context.Background()
+	store, err := NewLocalStorage(c.MkDir())
+	c.Assert(err, IsNil)
+	c.Assert(store.WriteFile(ctx, "10", []byte("0123456789")), IsNil)
+	c.Assert(store.WriteFile(ctx, "8", []byte("01234567")), IsNil)
+
+	counter := &rangeCounter{ExternalStorage: store}
+	data, err := io.ReadAll(NewRangeReader(ctx, counter, "10", 4))
+	c.Assert(err, IsNil)
+	c.Assert(string(data), Equals, "0123456789")
+	// the last chunk is shorter, so it's read again until the end.
+	c.Assert(counter.lengths, DeepEquals, []int64{4, 4, 4, -1})
+
+	counter.lengths = nil
+	data, err = io.ReadAll(NewRangeReader(ctx, counter, "8", 4))
+	c.Assert(err, IsNil)
+	c.Assert(string(data), Equals, "01234567")
+	c.Assert(counter.lengths, DeepEquals, []int64{4, 4, 4, -1})
+
+	_, err = io.ReadAll(NewRangeReader(ctx, counter, "missing", 4))
+	c.Assert(err, NotNil)
+}
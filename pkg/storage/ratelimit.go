@@ -0,0 +1,161 @@
+// Copyright 2021 PingCAP, Inc. Licensed under Apache-2.0.
+
+package storage
+
+import (
+	"context"
+	"math"
+
+	"github.com/docker/go-units"
+	"github.com/pingcap/errors"
+	"github.com/spf13/pflag"
+	"golang.org/x/time/rate"
+
+	berrors "github.com/pingcap/br/pkg/errors"
+)
+
+const storageRateLimitOption = "storage.rate-limit"
+
+func defineRateLimitFlags(flags *pflag.FlagSet) {
+	flags.String(storageRateLimitOption, "0",
+		"the max bandwidth per second of BR reading and writing the external storage, e.g. 200MB, 0 means unlimited. "+
+			"It doesn't limit the files uploaded by TiKV, use --ratelimit for that")
+}
+
+// parseRateLimit parses the bandwidth like "200MB" to bytes per second.
+func parseRateLimit(flags *pflag.FlagSet) (uint64, error) {
+	limit, err := flags.GetString(storageRateLimitOption)
+	if err != nil {
+		return 0, errors.Trace(err)
+	}
+	if limit == "" {
+		return 0, nil
+	}
+	bytes, err := units.RAMInBytes(limit)
+	if err != nil || bytes < 0 {
+		return 0, errors.Annotatef(berrors.ErrStorageInvalidConfig, "invalid --%s %q", storageRateLimitOption, limit)
+	}
+	return uint64(bytes), nil
+}
+
+type withRateLimit struct {
+	ExternalStorage
+	limiter *rate.Limiter
+}
+
+// WithRateLimit returns an ExternalStorage whose reads and writes share a
+// token bucket of `bytesPerSec` bytes per second. 0 means unlimited.
+func WithRateLimit(inner ExternalStorage, bytesPerSec uint64) ExternalStorage {
+	if bytesPerSec == 0 {
+		return inner
+	}
+	return &withRateLimit{
+		ExternalStorage: inner,
+		limiter:         newBytesLimiter(bytesPerSec),
+	}
+}
+
+// newBytesLimiter returns a limiter of `bytesPerSec` bytes per second.
+func newBytesLimiter(bytesPerSec uint64) *rate.Limiter {
+	burst := math.MaxInt32
+	if bytesPerSec < uint64(burst) {
+		burst = int(bytesPerSec)
+	}
+	return rate.NewLimiter(rate.Limit(bytesPerSec), burst)
+}
+
+// waitBytes blocks until n bytes are allowed to transfer. The limiter can't
+// grant more than its burst at once, so large requests are split.
+func waitBytes(ctx context.Context, limiter *rate.Limiter, n int) error {
+	for n > 0 {
+		size := n
+		if burst := limiter.Burst(); size > burst {
+			size = burst
+		}
+		if err := limiter.WaitN(ctx, size); err != nil {
+			return errors.Trace(err)
+		}
+		n -= size
+	}
+	return nil
+}
+
+func (l *withRateLimit) wait(ctx context.Context, n int) error {
+	return waitBytes(ctx, l.limiter, n)
+}
+
+func (l *withRateLimit) WriteFile(ctx context.Context, name string, data []byte) error {
+	if err := l.wait(ctx, len(data)); err != nil {
+		return err
+	}
+	return l.ExternalStorage.WriteFile(ctx, name, data)
+}
+
+func (l *withRateLimit) ReadFile(ctx context.Context, name string) ([]byte, error) {
+	data, err := l.ExternalStorage.ReadFile(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	return data, l.wait(ctx, len(data))
+}
+
+func (l *withRateLimit) ReadRange(ctx context.Context, name string, offset, length int64) ([]byte, error) {
+	data, err := l.ExternalStorage.ReadRange(ctx, name, offset, length)
+	if err != nil {
+		return nil, err
+	}
+	return data, l.wait(ctx, len(data))
+}
+
+func (l *withRateLimit) Open(ctx context.Context, path string) (ExternalFileReader, error) {
+	reader, err := l.ExternalStorage.Open(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+	return &rateLimitReader{ExternalFileReader: reader, ctx: ctx, storage: l}, nil
+}
+
+func (l *withRateLimit) Create(ctx context.Context, path string) (ExternalFileWriter, error) {
+	writer, err := l.ExternalStorage.Create(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+	return &rateLimitWriter{ExternalFileWriter: writer, storage: l}, nil
+}
+
+// CreateUploader creates the uploader of the inner storage, see uploaderCreator.
+func (l *withRateLimit) CreateUploader(ctx context.Context, path string) (ExternalFileWriter, error) {
+	writer, err := createUploader(ctx, l.ExternalStorage, path)
+	if err != nil {
+		return nil, err
+	}
+	return &rateLimitWriter{ExternalFileWriter: writer, storage: l}, nil
+}
+
+type rateLimitReader struct {
+	ExternalFileReader
+	ctx     context.Context
+	storage *withRateLimit
+}
+
+func (r *rateLimitReader) Read(p []byte) (int, error) {
+	n, err := r.ExternalFileReader.Read(p)
+	if n > 0 {
+		if waitErr := r.storage.wait(r.ctx, n); waitErr != nil {
+			return n, waitErr
+		}
+	}
+	return n, err
+}
+
+type rateLimitWriter struct {
+	ExternalFileWriter
+	storage *withRateLimit
+}
+
+func (w *rateLimitWriter) Write(ctx context.Context, p []byte) (int, error) {
+	if err := w.storage.wait(ctx, len(p)); err != nil {
+		return 0, err
+	}
+	return w.ExternalFileWriter.Write(ctx, p)
+}
@@ -0,0 +1,58 @@
+// Copyright 2021 PingCAP, Inc. Licensed under Apache-2.0.
+
+package storage
+
+import (
+	"context"
+	"io"
+	"time"
+
+	. "github.com/pingcap/check"
+	"github.com/spf13/pflag"
+)
+
+func (r *testStorageSuite) TestRateLimit(c *C) {
+	ctx := context.Background()
+	local, err := NewLocalStorage(c.MkDir())
+	c.Assert(err, IsNil)
+	c.Assert(WithRateLimit(local, 0), Equals, local)
+
+	s := WithRateLimit(local, 64*1024)
+	data := make([]byte, 64*1024)
+	start := time.Now()
+	// the first write consumes the burst.
+	c.Assert(s.WriteFile(ctx, "a", data), IsNil)
+	c.Assert(time.Since(start), Less, 500*time.Millisecond)
+
+	// the reads share the limiter with the writes.
+	reader, err := s.Open(ctx, "a")
+	c.Assert(err, IsNil)
+	n, err := io.Copy(io.Discard, reader)
+	c.Assert(err, IsNil)
+	c.Assert(n, Equals, int64(len(data)))
+	c.Assert(reader.Close(), IsNil)
+	c.Assert(time.Since(start), GreaterEqual, 900*time.Millisecond)
+
+	// the context is checked when waiting.
+	cctx, cancel := context.WithCancel(ctx)
+	cancel()
+	_, err = s.ReadFile(cctx, "a")
+	c.Assert(err, NotNil)
+}
+
+func (r *testStorageSuite) TestParseRateLimit(c *C) {
+	flags := pflag.NewFlagSet("test", pflag.ContinueOnError)
+	defineRateLimitFlags(flags)
+	limit, err := parseRateLimit(flags)
+	c.Assert(err, IsNil)
+	c.Assert(limit, Equals, uint64(0))
+
+	c.Assert(flags.Set(storageRateLimitOption, "200MB"), IsNil)
+	limit, err = parseRateLimit(flags)
+	c.Assert(err, IsNil)
+	c.Assert(limit, Equals, uint64(200*1024*1024))
+
+	c.Assert(flags.Set(storageRateLimitOption, "fast"), IsNil)
+	_, err = parseRateLimit(flags)
+	c.Assert(err, ErrorMatches, ".*invalid --storage.rate-limit.*")
+}
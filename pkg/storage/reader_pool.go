@@ -0,0 +1,165 @@
+// Copyright 2021 PingCAP, Inc. Licensed under Apache-2.0.
+
+package storage
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/pingcap/errors"
+	"golang.org/x/time/rate"
+)
+
+// ReaderPoolStats is the accounting of the reads through a ReaderPool.
+type ReaderPoolStats struct {
+	// Requests is the count of the GETs, including the failed ones.
+	Requests int64
+	// Bytes is the bytes read by the GETs.
+	Bytes int64
+	// WaitDuration is the total time the GETs waited for a free slot.
+	WaitDuration time.Duration
+}
+
+// ReaderPool bounds the parallel GETs and the read bandwidth of all the
+// storages wrapped by it, so many readers, e.g. the pullers of hundreds of
+// tables, don't overwhelm the backend or the NAT gateways.
+type ReaderPool struct {
+	slots   chan struct{}
+	limiter *rate.Limiter
+
+	requests int64
+	bytes    int64
+	waitNs   int64
+}
+
+// NewReaderPool creates a pool allowing at most `concurrency` parallel GETs
+// reading `bytesPerSec` bytes per second in total. 0 means unlimited.
+func NewReaderPool(concurrency int, bytesPerSec uint64) *ReaderPool {
+	pool := &ReaderPool{}
+	if concurrency > 0 {
+		pool.slots = make(chan struct{}, concurrency)
+	}
+	if bytesPerSec > 0 {
+		pool.limiter = newBytesLimiter(bytesPerSec)
+	}
+	return pool
+}
+
+// Wrap returns an ExternalStorage whose reads go through the pool. The other
+// operations are not limited.
+func (p *ReaderPool) Wrap(inner ExternalStorage) ExternalStorage {
+	return &withReaderPool{ExternalStorage: inner, pool: p}
+}
+
+// Stats returns the accounting of the reads so far.
+func (p *ReaderPool) Stats() ReaderPoolStats {
+	return ReaderPoolStats{
+		Requests:     atomic.LoadInt64(&p.requests),
+		Bytes:        atomic.LoadInt64(&p.bytes),
+		WaitDuration: time.Duration(atomic.LoadInt64(&p.waitNs)),
+	}
+}
+
+// acquire waits for a free slot, the returned function releases it.
+func (p *ReaderPool) acquire(ctx context.Context) (func(), error) {
+	atomic.AddInt64(&p.requests, 1)
+	if p.slots == nil {
+		return func() {}, nil
+	}
+	start := time.Now()
+	select {
+	case p.slots <- struct{}{}:
+	case <-ctx.Done():
+		return nil, errors.Trace(ctx.Err())
+	}
+	atomic.AddInt64(&p.waitNs, int64(time.Since(start)))
+	var once sync.Once
+	return func() { once.Do(func() { <-p.slots }) }, nil
+}
+
+// account charges the bytes read by a request to the pool.
+func (p *ReaderPool) account(ctx context.Context, n int) error {
+	atomic.AddInt64(&p.bytes, int64(n))
+	if p.limiter == nil {
+		return nil
+	}
+	return waitBytes(ctx, p.limiter, n)
+}
+
+type withReaderPool struct {
+	ExternalStorage
+	pool *ReaderPool
+}
+
+func (r *withReaderPool) ReadFile(ctx context.Context, name string) ([]byte, error) {
+	release, err := r.pool.acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+	data, err := r.ExternalStorage.ReadFile(ctx, name)
+	// the slot is held while waiting for the bandwidth, so the waiting
+	// requests don't pile up in the backend.
+	if accountErr := r.pool.account(ctx, len(data)); err == nil {
+		err = accountErr
+	}
+	release()
+	if err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+func (r *withReaderPool) ReadRange(ctx context.Context, name string, offset, length int64) ([]byte, error) {
+	release, err := r.pool.acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+	data, err := r.ExternalStorage.ReadRange(ctx, name, offset, length)
+	if accountErr := r.pool.account(ctx, len(data)); err == nil {
+		err = accountErr
+	}
+	release()
+	if err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// Open holds a slot until the reader is closed, so the readers must be closed
+// and a goroutine shouldn't open more readers than the slots at once.
+func (r *withReaderPool) Open(ctx context.Context, path string) (ExternalFileReader, error) {
+	release, err := r.pool.acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+	reader, err := r.ExternalStorage.Open(ctx, path)
+	if err != nil {
+		release()
+		return nil, err
+	}
+	return &readerPoolReader{ExternalFileReader: reader, ctx: ctx, pool: r.pool, release: release}, nil
+}
+
+type readerPoolReader struct {
+	ExternalFileReader
+	ctx     context.Context
+	pool    *ReaderPool
+	release func()
+}
+
+func (r *readerPoolReader) Read(p []byte) (int, error) {
+	n, err := r.ExternalFileReader.Read(p)
+	if n > 0 {
+		if accountErr := r.pool.account(r.ctx, n); accountErr != nil {
+			return n, accountErr
+		}
+	}
+	return n, err
+}
+
+func (r *readerPoolReader) Close() error {
+	defer r.release()
+	return r.ExternalFileReader.Close()
+}
@@ -0,0 +1,101 @@
+// Copyright 2021 PingCAP, Inc. Licensed under Apache-2.0.
+
+package storage
+
+import (
+	"context"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	. "github.com/pingcap/check"
+)
+
+type concurrencyTracker struct {
+	ExternalStorage
+	active int32
+	max    int32
+}
+
+func (t *concurrencyTracker) ReadFile(ctx context.Context, name string) ([]byte, error) {
+	active := atomic.AddInt32(&t.active, 1)
+	defer atomic.AddInt32(&t.active, -1)
+	for {
+		max := atomic.LoadInt32(&t.max)
+		if active <= max || atomic.CompareAndSwapInt32(&t.max, max, active) {
+			break
+		}
+	}
+	time.Sleep(10 * time.Millisecond)
+	return t.ExternalStorage.ReadFile(ctx, name)
+}
+
+func (r *testStorageSuite) TestReaderPool(c *C) {
+	ctx := context.Background()
+	local, err := NewLocalStorage(c.MkDir())
+	c.Assert(err, IsNil)
+	c.Assert(local.WriteFile(ctx, "a", make([]byte, 100)), IsNil)
+
+	pool := NewReaderPool(2, 0)
+	tracker := &concurrencyTracker{ExternalStorage: local}
+	// the storages wrapped by the same pool share the slots.
+	storages := []ExternalStorage{pool.Wrap(tracker), pool.Wrap(tracker)}
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(s ExternalStorage) {
+			defer wg.Done()
+			_, err := s.ReadFile(ctx, "a")
+			c.Check(err, IsNil)
+		}(storages[i%2])
+	}
+	wg.Wait()
+	c.Assert(atomic.LoadInt32(&tracker.max), Equals, int32(2))
+
+	stats := pool.Stats()
+	c.Assert(stats.Requests, Equals, int64(8))
+	c.Assert(stats.Bytes, Equals, int64(800))
+
+	// the slot of a reader is released once it's closed.
+	s := storages[0]
+	reader, err := s.Open(ctx, "a")
+	c.Assert(err, IsNil)
+	n, err := io.Copy(io.Discard, reader)
+	c.Assert(err, IsNil)
+	c.Assert(n, Equals, int64(100))
+	reader2, err := s.Open(ctx, "a")
+	c.Assert(err, IsNil)
+	cctx, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	_, err = s.ReadFile(cctx, "a")
+	cancel()
+	c.Assert(err, NotNil)
+	c.Assert(reader.Close(), IsNil)
+	c.Assert(reader.Close(), IsNil)
+	_, err = s.ReadFile(ctx, "a")
+	c.Assert(err, IsNil)
+	c.Assert(reader2.Close(), IsNil)
+	c.Assert(pool.Stats().Bytes, Equals, int64(1000))
+}
+
+func (r *testStorageSuite) TestReaderPoolRateLimit(c *C) {
+	ctx := context.Background()
+	local, err := NewLocalStorage(c.MkDir())
+	c.Assert(err, IsNil)
+	data := make([]byte, 64*1024)
+	c.Assert(local.WriteFile(ctx, "a", data), IsNil)
+
+	pool := NewReaderPool(0, 64*1024)
+	s1, s2 := pool.Wrap(local), pool.Wrap(local)
+	start := time.Now()
+	// the first read consumes the burst, the second one waits for it.
+	_, err = s1.ReadFile(ctx, "a")
+	c.Assert(err, IsNil)
+	c.Assert(time.Since(start), Less, 500*time.Millisecond)
+	_, err = s2.ReadRange(ctx, "a", 0, int64(len(data)))
+	c.Assert(err, IsNil)
+	c.Assert(time.Since(start), GreaterEqual, 900*time.Millisecond)
+	// the writes aren't limited.
+	c.Assert(s1.WriteFile(ctx, "b", data), IsNil)
+	c.Assert(time.Since(start), Less, 1500*time.Millisecond)
+}
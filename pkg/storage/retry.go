@@ -0,0 +1,329 @@
+// Copyright 2021 PingCAP, Inc. Licensed under Apache-2.0.
+
+package storage
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/pingcap/errors"
+	"github.com/pingcap/log"
+	"github.com/spf13/pflag"
+	"go.uber.org/zap"
+	"google.golang.org/api/googleapi"
+
+	berrors "github.com/pingcap/br/pkg/errors"
+)
+
+const (
+	storageRetryAttemptsOption   = "storage.retry-attempts"
+	storageRetryBackoffOption    = "storage.retry-backoff"
+	storageRetryMaxBackoffOption = "storage.retry-max-backoff"
+
+	// DefaultRetryAttempts is the default max attempts of a storage operation.
+	// The S3 and GCS clients retry each request by themselves as well, so an
+	// operation on S3 sends up to DefaultRetryAttempts * (maxRetries + 1)
+	// requests. The attempts here are kept few, they're mostly for the errors
+	// the SDK retryers don't retry, e.g. a connection reset in the middle of
+	// reading the body.
+	DefaultRetryAttempts = 3
+	// DefaultRetryBackoff is the default backoff before the first retry.
+	DefaultRetryBackoff = 1 * time.Second
+	// DefaultRetryMaxBackoff is the default max backoff between two retries.
+	DefaultRetryMaxBackoff = 30 * time.Second
+)
+
+var retryableStorageErrors = []string{
+	"connection reset by peer",
+	"broken pipe",
+	"connection refused",
+	"use of closed network connection",
+	"tls handshake timeout",
+	"i/o timeout",
+	"slowdown",
+	"slow down",
+	"internalerror",
+	"service unavailable",
+}
+
+// RetryOptions configures the retry layer wrapped around an ExternalStorage.
+type RetryOptions struct {
+	// MaxAttempts is the max times an operation is tried, 0 or 1 disables retry.
+	MaxAttempts int `json:"max-attempts" toml:"max-attempts"`
+	// Backoff is the time to wait before the first retry, doubled on each retry.
+	Backoff time.Duration `json:"backoff" toml:"backoff"`
+	// MaxBackoff is the upper bound of time to wait between two retries.
+	MaxBackoff time.Duration `json:"max-backoff" toml:"max-backoff"`
+}
+
+func defineRetryFlags(flags *pflag.FlagSet) {
+	flags.Int(storageRetryAttemptsOption, DefaultRetryAttempts,
+		"the max attempts of a storage operation on transient errors, each attempt is retried by the "+
+			"S3 or GCS client itself as well, set to 1 to disable retry")
+	flags.Duration(storageRetryBackoffOption, DefaultRetryBackoff,
+		"the backoff before the first retry of a storage operation")
+	flags.Duration(storageRetryMaxBackoffOption, DefaultRetryMaxBackoff,
+		"the max backoff between two retries of a storage operation")
+	_ = flags.MarkHidden(storageRetryBackoffOption)
+	_ = flags.MarkHidden(storageRetryMaxBackoffOption)
+}
+
+func (options *RetryOptions) parseFromFlags(flags *pflag.FlagSet) error {
+	var err error
+	options.MaxAttempts, err = flags.GetInt(storageRetryAttemptsOption)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	options.Backoff, err = flags.GetDuration(storageRetryBackoffOption)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	options.MaxBackoff, err = flags.GetDuration(storageRetryMaxBackoffOption)
+	return errors.Trace(err)
+}
+
+// Adjust fills the zero fields with default values.
+func (options *RetryOptions) Adjust() {
+	if options.MaxAttempts == 0 {
+		options.MaxAttempts = DefaultRetryAttempts
+	}
+	if options.Backoff == 0 {
+		options.Backoff = DefaultRetryBackoff
+	}
+	if options.MaxBackoff == 0 {
+		options.MaxBackoff = DefaultRetryMaxBackoff
+	}
+}
+
+type withRetry struct {
+	ExternalStorage
+	options RetryOptions
+}
+
+// WithRetry returns an ExternalStorage which retries the operations failed
+// with transient errors, e.g. HTTP 5xx, throttling and connection reset.
+func WithRetry(inner ExternalStorage, options RetryOptions) ExternalStorage {
+	if options.MaxAttempts <= 1 {
+		return inner
+	}
+	return &withRetry{ExternalStorage: inner, options: options}
+}
+
+func (r *withRetry) retry(ctx context.Context, op, name string, fn func() error) error {
+	backoff := r.options.Backoff
+	for attempt := 1; ; attempt++ {
+		err := fn()
+		if err == nil || attempt >= r.options.MaxAttempts || !isRetryableStorageError(err) {
+			return err
+		}
+		storageRetryCounter.WithLabelValues(op).Inc()
+		log.Warn("storage operation failed, retrying",
+			zap.String("operation", op),
+			zap.String("name", name),
+			zap.Int("attempt", attempt),
+			zap.Duration("backoff", backoff),
+			zap.Error(err))
+		select {
+		case <-ctx.Done():
+			return errors.Trace(ctx.Err())
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+		if backoff > r.options.MaxBackoff {
+			backoff = r.options.MaxBackoff
+		}
+	}
+}
+
+func (r *withRetry) WriteFile(ctx context.Context, name string, data []byte) error {
+	return r.retry(ctx, "WriteFile", name, func() error {
+		return r.ExternalStorage.WriteFile(ctx, name, data)
+	})
+}
+
+func (r *withRetry) ReadFile(ctx context.Context, name string) ([]byte, error) {
+	var data []byte
+	err := r.retry(ctx, "ReadFile", name, func() error {
+		var err error
+		data, err = r.ExternalStorage.ReadFile(ctx, name)
+		return err
+	})
+	return data, err
+}
+
+func (r *withRetry) ReadRange(ctx context.Context, name string, offset, length int64) ([]byte, error) {
+	var data []byte
+	err := r.retry(ctx, "ReadRange", name, func() error {
+		var err error
+		data, err = r.ExternalStorage.ReadRange(ctx, name, offset, length)
+		return err
+	})
+	return data, err
+}
+
+func (r *withRetry) FileExists(ctx context.Context, name string) (bool, error) {
+	var exists bool
+	err := r.retry(ctx, "FileExists", name, func() error {
+		var err error
+		exists, err = r.ExternalStorage.FileExists(ctx, name)
+		return err
+	})
+	return exists, err
+}
+
+func (r *withRetry) Open(ctx context.Context, path string) (ExternalFileReader, error) {
+	var reader ExternalFileReader
+	err := r.retry(ctx, "Open", path, func() error {
+		var err error
+		reader, err = r.ExternalStorage.Open(ctx, path)
+		return err
+	})
+	return reader, err
+}
+
+// walkFnError wraps the error returned by the callback of WalkDir, which
+// should never be retried.
+type walkFnError struct {
+	error
+}
+
+// WalkDir restarts the walk on transient errors. The files which have been
+// visited by the failed attempts are skipped, and the errors returned by `fn`
+// are never retried.
+func (r *withRetry) WalkDir(ctx context.Context, opt *WalkOption, fn func(path string, size int64) error) error {
+	visited := make(map[string]struct{})
+	err := r.retry(ctx, "WalkDir", r.URI(), func() error {
+		var fnErr error
+		err := r.ExternalStorage.WalkDir(ctx, opt, func(path string, size int64) error {
+			if _, ok := visited[path]; ok {
+				return nil
+			}
+			visited[path] = struct{}{}
+			fnErr = fn(path, size)
+			return fnErr
+		})
+		if fnErr != nil {
+			return &walkFnError{error: fnErr}
+		}
+		return err
+	})
+	if e, ok := err.(*walkFnError); ok { // nolint:errorlint
+		return e.error
+	}
+	return err
+}
+
+func (r *withRetry) Create(ctx context.Context, path string) (ExternalFileWriter, error) {
+	var writer ExternalFileWriter
+	err := r.retry(ctx, "Create", path, func() error {
+		var err error
+		writer, err = r.ExternalStorage.Create(ctx, path)
+		return err
+	})
+	return writer, err
+}
+
+// CreateUploader creates the uploader of the inner storage, see uploaderCreator.
+func (r *withRetry) CreateUploader(ctx context.Context, path string) (ExternalFileWriter, error) {
+	var writer ExternalFileWriter
+	err := r.retry(ctx, "CreateUploader", path, func() error {
+		var err error
+		writer, err = createUploader(ctx, r.ExternalStorage, path)
+		return err
+	})
+	return writer, err
+}
+
+// Rename retries the rename on transient errors. The rename isn't idempotent:
+// the cloud storages rename by copying then deleting, and a failed attempt may
+// have renamed the file already. So the old file is checked before retrying,
+// and the rename is done if it's gone and the new file exists.
+func (r *withRetry) Rename(ctx context.Context, oldName, newName string) error {
+	attempted := false
+	return r.retry(ctx, "Rename", oldName, func() error {
+		if attempted {
+			exists, err := r.ExternalStorage.FileExists(ctx, oldName)
+			if err != nil {
+				return err
+			}
+			if !exists {
+				renamed, err := r.ExternalStorage.FileExists(ctx, newName)
+				if err != nil {
+					return err
+				}
+				if !renamed {
+					return errors.Annotatef(berrors.ErrStorageUnknown,
+						"the file %s is gone while renaming it to %s", oldName, newName)
+				}
+				return nil
+			}
+		}
+		attempted = true
+		return r.ExternalStorage.Rename(ctx, oldName, newName)
+	})
+}
+
+func (r *withRetry) DeleteFile(ctx context.Context, name string) error {
+	return r.retry(ctx, "DeleteFile", name, func() error {
+		return r.ExternalStorage.DeleteFile(ctx, name)
+	})
+}
+
+func (r *withRetry) DeletePrefix(ctx context.Context, prefix string) error {
+	return r.retry(ctx, "DeletePrefix", prefix, func() error {
+		return r.ExternalStorage.DeletePrefix(ctx, prefix)
+	})
+}
+
+// isRetryableStorageError checks whether the error returned by the storage
+// is transient, so that the operation may succeed after retrying.
+func isRetryableStorageError(err error) bool {
+	if _, ok := err.(*walkFnError); ok { // nolint:errorlint
+		return false
+	}
+	cause := errors.Cause(err)
+	switch cause { // nolint:errorlint
+	case nil, context.Canceled, context.DeadlineExceeded:
+		return false
+	case syscall.ECONNRESET, syscall.ECONNREFUSED, syscall.EPIPE:
+		return true
+	}
+
+	switch e := cause.(type) { // nolint:errorlint
+	case awserr.RequestFailure:
+		if isRetryableStatusCode(e.StatusCode()) {
+			return true
+		}
+		if request.IsErrorThrottle(e) || request.IsErrorRetryable(e) {
+			return true
+		}
+	case awserr.Error:
+		if request.IsErrorThrottle(e) || request.IsErrorRetryable(e) {
+			return true
+		}
+	case *googleapi.Error:
+		return isRetryableStatusCode(e.Code)
+	case net.Error:
+		if e.Timeout() {
+			return true
+		}
+	}
+
+	msg := strings.ToLower(err.Error())
+	for _, errStr := range retryableStorageErrors {
+		if strings.Contains(msg, errStr) {
+			return true
+		}
+	}
+	return false
+}
+
+func isRetryableStatusCode(code int) bool {
+	return code >= http.StatusInternalServerError || code == http.StatusTooManyRequests
+}
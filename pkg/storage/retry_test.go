@@ -0,0 +1,157 @@
+// Copyright 2021 PingCAP, Inc. Licensed under Apache-2.0.
+
+package storage
+
+import (
+	"context"
+	"syscall"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	. "github.com/pingcap/check"
+	"github.com/pingcap/errors"
+	"google.golang.org/api/googleapi"
+)
+
+// flakyStorage fails the first `failures` calls of ReadFile and WalkDir.
+type flakyStorage struct {
+	ExternalStorage
+	failures int
+	err      error
+	calls    int
+}
+
+func (s *flakyStorage) ReadFile(ctx context.Context, name string) ([]byte, error) {
+	s.calls++
+	if s.calls <= s.failures {
+		return nil, errors.Trace(s.err)
+	}
+	return []byte(name), nil
+}
+
+func (s *flakyStorage) WalkDir(ctx context.Context, opt *WalkOption, fn func(string, int64) error) error {
+	s.calls++
+	for _, path := range []string{"a", "b", "c"} {
+		if err := fn(path, 1); err != nil {
+			return err
+		}
+		if path == "b" && s.calls <= s.failures {
+			return errors.Trace(s.err)
+		}
+	}
+	return nil
+}
+
+func (r *testStorageSuite) TestRetryReadFile(c *C) {
+	ctx := context.Background()
+	opts := RetryOptions{MaxAttempts: 3, Backoff: time.Millisecond, MaxBackoff: time.Millisecond}
+
+	inner := &flakyStorage{ExternalStorage: newNoopStorage(), failures: 2, err: syscall.ECONNRESET}
+	data, err := WithRetry(inner, opts).ReadFile(ctx, "file")
+	c.Assert(err, IsNil)
+	c.Assert(data, DeepEquals, []byte("file"))
+	c.Assert(inner.calls, Equals, 3)
+
+	inner = &flakyStorage{ExternalStorage: newNoopStorage(), failures: 3, err: syscall.ECONNRESET}
+	_, err = WithRetry(inner, opts).ReadFile(ctx, "file")
+	c.Assert(errors.Cause(err), Equals, syscall.ECONNRESET)
+	c.Assert(inner.calls, Equals, 3)
+
+	// non-retryable errors fail immediately.
+	inner = &flakyStorage{ExternalStorage: newNoopStorage(), failures: 1, err: errors.New("access denied")}
+	_, err = WithRetry(inner, opts).ReadFile(ctx, "file")
+	c.Assert(err, ErrorMatches, ".*access denied.*")
+	c.Assert(inner.calls, Equals, 1)
+}
+
+func (r *testStorageSuite) TestRetryWalkDir(c *C) {
+	ctx := context.Background()
+	opts := RetryOptions{MaxAttempts: 3, Backoff: time.Millisecond, MaxBackoff: time.Millisecond}
+
+	inner := &flakyStorage{ExternalStorage: newNoopStorage(), failures: 1, err: syscall.ECONNRESET}
+	var visited []string
+	err := WithRetry(inner, opts).WalkDir(ctx, &WalkOption{}, func(path string, _ int64) error {
+		visited = append(visited, path)
+		return nil
+	})
+	c.Assert(err, IsNil)
+	c.Assert(visited, DeepEquals, []string{"a", "b", "c"})
+	c.Assert(inner.calls, Equals, 2)
+
+	// errors returned by the callback are never retried.
+	inner = &flakyStorage{ExternalStorage: newNoopStorage()}
+	err = WithRetry(inner, opts).WalkDir(ctx, &WalkOption{}, func(path string, _ int64) error {
+		return syscall.ECONNRESET
+	})
+	c.Assert(err, Equals, syscall.ECONNRESET)
+	c.Assert(inner.calls, Equals, 1)
+}
+
+func (r *testStorageSuite) TestIsRetryableStorageError(c *C) {
+	c.Assert(isRetryableStorageError(context.Canceled), IsFalse)
+	c.Assert(isRetryableStorageError(errors.Trace(syscall.ECONNRESET)), IsTrue)
+	c.Assert(isRetryableStorageError(awserr.NewRequestFailure(
+		awserr.New("ServiceUnavailable", "", nil), 503, "")), IsTrue)
+	c.Assert(isRetryableStorageError(awserr.NewRequestFailure(
+		awserr.New("AccessDenied", "", nil), 403, "")), IsFalse)
+	c.Assert(isRetryableStorageError(&googleapi.Error{Code: 429}), IsTrue)
+	c.Assert(isRetryableStorageError(&googleapi.Error{Code: 404}), IsFalse)
+	c.Assert(isRetryableStorageError(errors.New("read tcp: connection reset by peer")), IsTrue)
+}
+
+// flakyRenameStorage fails the first `failures` renames, after renaming the
+// file if `renamed` is set.
+type flakyRenameStorage struct {
+	ExternalStorage
+	failures int
+	renamed  bool
+	calls    int
+}
+
+func (s *flakyRenameStorage) Rename(ctx context.Context, oldName, newName string) error {
+	s.calls++
+	if s.calls <= s.failures {
+		if s.renamed {
+			if err := s.ExternalStorage.Rename(ctx, oldName, newName); err != nil {
+				return err
+			}
+		}
+		return errors.Trace(syscall.ECONNRESET)
+	}
+	return s.ExternalStorage.Rename(ctx, oldName, newName)
+}
+
+func (r *testStorageSuite) TestRetryRename(c *C) {
+	ctx := context.Background()
+	opts := RetryOptions{MaxAttempts: 3, Backoff: time.Millisecond, MaxBackoff: time.Millisecond}
+	local, err := NewLocalStorage(c.MkDir())
+	c.Assert(err, IsNil)
+
+	// the rename failed before renaming is retried.
+	c.Assert(local.WriteFile(ctx, "a", []byte("a")), IsNil)
+	inner := &flakyRenameStorage{ExternalStorage: local, failures: 1}
+	c.Assert(WithRetry(inner, opts).Rename(ctx, "a", "b"), IsNil)
+	c.Assert(inner.calls, Equals, 2)
+
+	// the rename failed after renaming isn't done again.
+	inner = &flakyRenameStorage{ExternalStorage: local, failures: 1, renamed: true}
+	c.Assert(WithRetry(inner, opts).Rename(ctx, "b", "c"), IsNil)
+	c.Assert(inner.calls, Equals, 1)
+	exists, err := local.FileExists(ctx, "c")
+	c.Assert(err, IsNil)
+	c.Assert(exists, IsTrue)
+
+	// the file is gone while it isn't renamed.
+	inner = &flakyRenameStorage{ExternalStorage: &deletingStorage{ExternalStorage: local}, failures: 1, renamed: true}
+	err = WithRetry(inner, opts).Rename(ctx, "c", "d")
+	c.Assert(err, ErrorMatches, ".*is gone while renaming.*")
+}
+
+// deletingStorage deletes the file instead of renaming it.
+type deletingStorage struct {
+	ExternalStorage
+}
+
+func (s *deletingStorage) Rename(ctx context.Context, oldName, _ string) error {
+	return s.ExternalStorage.DeleteFile(ctx, oldName)
+}
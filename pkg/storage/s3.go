@@ -7,11 +7,14 @@ import (
 	"context"
 	"fmt"
 	"io"
+	"net/http"
 	"net/url"
 	"path"
 	"regexp"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
@@ -40,7 +43,12 @@ const (
 	s3SseKmsKeyIDOption  = "s3.sse-kms-key-id"
 	s3ACLOption          = "s3.acl"
 	s3ProviderOption     = "s3.provider"
+	s3RequesterPays      = "s3.requester-pays"
 	notFound             = "NotFound"
+	// the error code of reading an object in the archive storage classes.
+	invalidObjectState = "InvalidObjectState"
+	// the error code of reading a range starting after the end of an object.
+	invalidRange = "InvalidRange"
 	// number of retries to make of operations.
 	maxRetries = 7
 	// max number of retries when meets error
@@ -53,6 +61,20 @@ const (
 	hardcodedS3ChunkSize = 5 * 1024 * 1024
 )
 
+// s3StorageClasses are the storage classes of AWS S3. The objects of the
+// archive classes must be restored before being read.
+var s3StorageClasses = map[string]bool{
+	"STANDARD":            false,
+	"REDUCED_REDUNDANCY":  false,
+	"STANDARD_IA":         false,
+	"ONEZONE_IA":          false,
+	"INTELLIGENT_TIERING": false,
+	"GLACIER_IR":          false,
+	"OUTPOSTS":            false,
+	"GLACIER":             true,
+	"DEEP_ARCHIVE":        true,
+}
+
 var permissionCheckFn = map[Permission]func(*s3.S3, *backuppb.S3) error{
 	AccessBuckets: checkS3Bucket,
 	ListObjects:   listObjects,
@@ -61,43 +83,73 @@ var permissionCheckFn = map[Permission]func(*s3.S3, *backuppb.S3) error{
 
 // S3Storage info for s3 storage.
 type S3Storage struct {
-	session *session.Session
-	svc     s3iface.S3API
-	options *backuppb.S3
+	session    *session.Session
+	svc        s3iface.S3API
+	options    *backuppb.S3
+	objectLock *ObjectLockOptions
+	upload     *S3UploadOptions
 }
 
 // S3Uploader does multi-part upload to s3.
 type S3Uploader struct {
-	svc           s3iface.S3API
-	createOutput  *s3.CreateMultipartUploadOutput
+	svc          s3iface.S3API
+	createOutput *s3.CreateMultipartUploadOutput
+	verifyETag   bool
+
+	mu            sync.Mutex
 	completeParts []*s3.CompletedPart
+	// parts is the number of the parts written by Write.
+	parts int
 }
 
 // UploadPart update partial data to s3, we should call CreateMultipartUpload to start it,
 // and call CompleteMultipartUpload to finish it.
 func (u *S3Uploader) Write(ctx context.Context, data []byte) (int, error) {
+	u.parts++
+	if err := u.UploadPart(ctx, u.parts, data); err != nil {
+		return 0, errors.Trace(err)
+	}
+	return len(data), nil
+}
+
+// UploadPart uploads the part of the number, it can be called concurrently.
+func (u *S3Uploader) UploadPart(ctx context.Context, partNumber int, data []byte) error {
+	sum, md5Str := contentMD5(data)
 	partInput := &s3.UploadPartInput{
 		Body:          bytes.NewReader(data),
 		Bucket:        u.createOutput.Bucket,
 		Key:           u.createOutput.Key,
-		PartNumber:    aws.Int64(int64(len(u.completeParts) + 1)),
+		PartNumber:    aws.Int64(int64(partNumber)),
 		UploadId:      u.createOutput.UploadId,
 		ContentLength: aws.Int64(int64(len(data))),
+		ContentMD5:    aws.String(md5Str),
 	}
 
 	uploadResult, err := u.svc.UploadPartWithContext(ctx, partInput)
 	if err != nil {
-		return 0, errors.Trace(err)
+		return errors.Trace(err)
 	}
+	if u.verifyETag {
+		name := fmt.Sprintf("%s (part %d)", aws.StringValue(u.createOutput.Key), partNumber)
+		if err = verifyETag(name, uploadResult.ETag, sum); err != nil {
+			return errors.Trace(err)
+		}
+	}
+	u.mu.Lock()
 	u.completeParts = append(u.completeParts, &s3.CompletedPart{
 		ETag:       uploadResult.ETag,
 		PartNumber: partInput.PartNumber,
 	})
-	return len(data), nil
+	u.mu.Unlock()
+	return nil
 }
 
 // Close complete multi upload request.
 func (u *S3Uploader) Close(ctx context.Context) error {
+	// the parts uploaded concurrently may complete out of order.
+	sort.Slice(u.completeParts, func(i, j int) bool {
+		return aws.Int64Value(u.completeParts[i].PartNumber) < aws.Int64Value(u.completeParts[j].PartNumber)
+	})
 	completeInput := &s3.CompleteMultipartUploadInput{
 		Bucket:   u.createOutput.Bucket,
 		Key:      u.createOutput.Key,
@@ -123,6 +175,15 @@ type S3BackendOptions struct {
 	Provider              string `json:"provider" toml:"provider"`
 	ForcePathStyle        bool   `json:"force-path-style" toml:"force-path-style"`
 	UseAccelerateEndpoint bool   `json:"use-accelerate-endpoint" toml:"use-accelerate-endpoint"`
+	// RequesterPays isn't a part of the backend sent to TiKV, it's passed to
+	// the storage by ExternalStorageOptions.
+	RequesterPays bool `json:"requester-pays" toml:"requester-pays"`
+	// ObjectLockMode, ObjectLockRetention and ObjectLockLegalHold are the
+	// object lock of the backup files. They aren't a part of the backend
+	// either, see ObjectLock.
+	ObjectLockMode      string        `json:"object-lock-mode" toml:"object-lock-mode"`
+	ObjectLockRetention time.Duration `json:"object-lock-retention" toml:"object-lock-retention"`
+	ObjectLockLegalHold bool          `json:"object-lock-legal-hold" toml:"object-lock-legal-hold"`
 }
 
 // Apply apply s3 options on backuppb.S3.
@@ -148,6 +209,20 @@ func (options *S3BackendOptions) Apply(s3 *backuppb.S3) error {
 		options.UseAccelerateEndpoint {
 		options.ForcePathStyle = false
 	}
+	if options.StorageClass != "" && (options.Provider == "" || options.Provider == "aws") {
+		options.StorageClass = strings.ToUpper(options.StorageClass)
+		archived, ok := s3StorageClasses[options.StorageClass]
+		if !ok {
+			return errors.Annotatef(berrors.ErrStorageInvalidConfig, "unknown s3 storage class %s", options.StorageClass)
+		}
+		if archived {
+			log.Warn("the backup files in the archive storage class must be restored by S3 before restoring the backup",
+				zap.String("storage-class", options.StorageClass))
+		}
+	}
+	if err := options.adjustObjectLock(); err != nil {
+		return errors.Trace(err)
+	}
 	if options.AccessKey == "" && options.SecretAccessKey != "" {
 		return errors.Annotate(berrors.ErrStorageInvalidConfig, "access_key not found")
 	}
@@ -174,12 +249,17 @@ func defineS3Flags(flags *pflag.FlagSet) {
 	flags.String(s3EndpointOption, "",
 		"(experimental) Set the S3 endpoint URL, please specify the http or https scheme explicitly")
 	flags.String(s3RegionOption, "", "(experimental) Set the S3 region, e.g. us-east-1")
-	flags.String(s3StorageClassOption, "", "(experimental) Set the S3 storage class, e.g. STANDARD")
+	flags.String(s3StorageClassOption, "",
+		"(experimental) Set the S3 storage class, e.g. STANDARD, STANDARD_IA, INTELLIGENT_TIERING, GLACIER_IR")
 	flags.String(s3SseOption, "", "Set S3 server-side encryption, e.g. aws:kms")
 	flags.String(s3SseKmsKeyIDOption, "", "KMS CMK key id to use with S3 server-side encryption."+
 		"Leave empty to use S3 owned key.")
 	flags.String(s3ACLOption, "", "(experimental) Set the S3 canned ACLs, e.g. authenticated-read")
 	flags.String(s3ProviderOption, "", "(experimental) Set the S3 provider, e.g. aws, alibaba, ceph")
+	flags.Bool(s3RequesterPays, false,
+		"(experimental) Charge the S3 requests sent by BR to the requester, required by the requester-pays buckets. "+
+			"The requests of TiKV aren't charged to the requester, so restore refuses it and backup to such a bucket fails")
+	defineS3ObjectLockFlags(flags)
 }
 
 // parseFromFlags parse S3BackendOptions from command line flags.
@@ -214,7 +294,11 @@ func (options *S3BackendOptions) parseFromFlags(flags *pflag.FlagSet) error {
 	if err != nil {
 		return errors.Trace(err)
 	}
-	return nil
+	options.RequesterPays, err = flags.GetBool(s3RequesterPays)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	return errors.Trace(options.parseObjectLockFromFlags(flags))
 }
 
 // NewS3StorageForTest creates a new S3Storage for testing only.
@@ -248,7 +332,13 @@ func newS3Storage(backend *backuppb.S3, opts *ExternalStorageOptions) (*S3Storag
 	if qs.Endpoint != "" {
 		awsConfig.WithEndpoint(qs.Endpoint)
 	}
-	if opts.HTTPClient != nil {
+	if opts.Proxy != "" {
+		transport, err := proxyTransport(opts.HTTPClient, opts.Proxy)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		awsConfig.WithHTTPClient(&http.Client{Transport: transport})
+	} else if opts.HTTPClient != nil {
 		awsConfig.WithHTTPClient(opts.HTTPClient)
 	}
 	var cred *credentials.Credentials
@@ -258,6 +348,11 @@ func newS3Storage(backend *backuppb.S3, opts *ExternalStorageOptions) (*S3Storag
 	if cred != nil {
 		awsConfig.WithCredentials(cred)
 	}
+	if opts.Credentials.refreshable() {
+		if err := checkRefreshableCredentials(opts, cred != nil); err != nil {
+			return nil, errors.Trace(err)
+		}
+	}
 	// awsConfig.WithLogLevel(aws.LogDebugWithSigning)
 	awsSessionOpts := session.Options{
 		Config: *awsConfig,
@@ -266,6 +361,13 @@ func newS3Storage(backend *backuppb.S3, opts *ExternalStorageOptions) (*S3Storag
 	if err != nil {
 		return nil, errors.Trace(err)
 	}
+	if opts.Credentials.refreshable() {
+		cred, err = opts.Credentials.s3Credentials(ses)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		ses = ses.Copy(aws.NewConfig().WithCredentials(cred))
+	}
 
 	if !opts.SendCredentials {
 		// Clear the credentials if exists so that they will not be sent to TiKV
@@ -283,12 +385,21 @@ func newS3Storage(backend *backuppb.S3, opts *ExternalStorageOptions) (*S3Storag
 	}
 
 	c := s3.New(ses)
+	newS3Pacer(opts.S3RequestBudget).install(&c.Handlers)
+	if opts.RequesterPays {
+		c.Handlers.Build.PushBack(setRequestPayer)
+	}
 	// TODO remove it after BR remove cfg skip-check-path
 	if !opts.SkipCheckPath {
 		err = checkS3Bucket(c, &qs)
 		if err != nil {
 			return nil, errors.Annotatef(berrors.ErrStorageInvalidConfig, "Bucket %s is not accessible: %v", qs.Bucket, err)
 		}
+		if opts.ObjectLock != nil {
+			if err = checkS3ObjectLock(c, &qs); err != nil {
+				return nil, errors.Trace(err)
+			}
+		}
 	}
 
 	if len(qs.Prefix) > 0 && !strings.HasSuffix(qs.Prefix, "/") {
@@ -303,12 +414,21 @@ func newS3Storage(backend *backuppb.S3, opts *ExternalStorageOptions) (*S3Storag
 	}
 
 	return &S3Storage{
-		session: ses,
-		svc:     c,
-		options: &qs,
+		session:    ses,
+		svc:        c,
+		options:    &qs,
+		objectLock: opts.ObjectLock,
+		upload:     opts.S3Upload,
 	}, nil
 }
 
+// setRequestPayer sets the `x-amz-request-payer` header, so the requests to
+// the requester-pays buckets are charged to the requester instead of being
+// rejected with AccessDenied.
+func setRequestPayer(r *request.Request) {
+	r.HTTPRequest.Header.Set("X-Amz-Request-Payer", s3.RequestPayerRequester)
+}
+
 // checkBucket checks if a bucket exists.
 func checkS3Bucket(svc *s3.S3, qs *backuppb.S3) error {
 	input := &s3.HeadBucketInput{
@@ -353,10 +473,14 @@ func getObject(svc *s3.S3, qs *backuppb.S3) error {
 
 // WriteFile writes data to a file to storage.
 func (rs *S3Storage) WriteFile(ctx context.Context, file string, data []byte) error {
+	// S3 rejects the request if the content doesn't match the Content-MD5,
+	// and the ETag is checked again in case the header is ignored.
+	sum, md5Str := contentMD5(data)
 	input := &s3.PutObjectInput{
-		Body:   aws.ReadSeekCloser(bytes.NewReader(data)),
-		Bucket: aws.String(rs.options.Bucket),
-		Key:    aws.String(rs.options.Prefix + file),
+		Body:       aws.ReadSeekCloser(bytes.NewReader(data)),
+		Bucket:     aws.String(rs.options.Bucket),
+		Key:        aws.String(rs.options.Prefix + file),
+		ContentMD5: aws.String(md5Str),
 	}
 	if rs.options.Acl != "" {
 		input = input.SetACL(rs.options.Acl)
@@ -370,11 +494,17 @@ func (rs *S3Storage) WriteFile(ctx context.Context, file string, data []byte) er
 	if rs.options.StorageClass != "" {
 		input = input.SetStorageClass(rs.options.StorageClass)
 	}
+	rs.objectLock.applyToPut(input)
 
-	_, err := rs.svc.PutObjectWithContext(ctx, input)
+	output, err := rs.svc.PutObjectWithContext(ctx, input)
 	if err != nil {
 		return errors.Trace(err)
 	}
+	if etagIsMD5(rs.options.Sse) {
+		if err = verifyETag(file, output.ETag, sum); err != nil {
+			return errors.Trace(err)
+		}
+	}
 	hinput := &s3.HeadObjectInput{
 		Bucket: aws.String(rs.options.Bucket),
 		Key:    aws.String(rs.options.Prefix + file),
@@ -391,18 +521,72 @@ func (rs *S3Storage) ReadFile(ctx context.Context, file string) ([]byte, error)
 	}
 	result, err := rs.svc.GetObjectWithContext(ctx, input)
 	if err != nil {
+		if archivedErr := rs.checkArchived(err, file); archivedErr != nil {
+			return nil, archivedErr
+		}
 		return nil, errors.Annotatef(err,
 			"failed to read s3 file, file info: input.bucket='%s', input.key='%s'",
 			*input.Bucket, *input.Key)
 	}
-	defer result.Body.Close()
-	data, err := io.ReadAll(result.Body)
+	data, err := readAllWithResume(file, result.Body, func(offset int64) (io.ReadCloser, error) {
+		reader, _, err := rs.open(ctx, file, offset, 0)
+		return reader, err
+	})
 	if err != nil {
 		return nil, errors.Trace(err)
 	}
 	return data, nil
 }
 
+// ReadRange reads a byte range of the file from the storage using an HTTP
+// range request, so only the requested bytes are transferred.
+func (rs *S3Storage) ReadRange(ctx context.Context, file string, offset, length int64) ([]byte, error) {
+	if length == 0 {
+		// an empty range can't be requested by the Range header, which would
+		// read until the end instead, so only check the offset.
+		return rs.readEmptyRange(ctx, file, offset, length)
+	}
+	endOffset := int64(0)
+	if length > 0 {
+		endOffset = offset + length
+	}
+	reader, r, err := rs.open(ctx, file, offset, endOffset)
+	if err != nil {
+		if aerr, ok := errors.Cause(err).(awserr.Error); ok && aerr.Code() == invalidRange { // nolint:errorlint
+			// S3 rejects the ranges starting at the end of the object, while
+			// nothing is left to read there, as the local storage does.
+			return rs.readEmptyRange(ctx, file, offset, length)
+		}
+		// the range ending after the end of the object is cut by S3.
+		if r.Size > 0 {
+			if rangeErr := checkRange(file, offset, length, r.Size); rangeErr != nil {
+				return nil, errors.Trace(rangeErr)
+			}
+		}
+		return nil, errors.Annotatef(err,
+			"failed to read s3 file range, file info: input.bucket='%s', input.key='%s', offset=%d, length=%d",
+			rs.options.Bucket, rs.options.Prefix+file, offset, length)
+	}
+	defer reader.Close()
+	return readRange(reader, length)
+}
+
+// readEmptyRange checks the range by the size of the object and returns the
+// empty data if nothing of the object is in the range.
+func (rs *S3Storage) readEmptyRange(ctx context.Context, file string, offset, length int64) ([]byte, error) {
+	output, err := rs.svc.HeadObjectWithContext(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(rs.options.Bucket),
+		Key:    aws.String(rs.options.Prefix + file),
+	})
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	if err := checkRange(file, offset, length, aws.Int64Value(output.ContentLength)); err != nil {
+		return nil, errors.Trace(err)
+	}
+	return []byte{}, nil
+}
+
 // FileExists check if file exists on s3 storage.
 func (rs *S3Storage) FileExists(ctx context.Context, file string) (bool, error) {
 	input := &s3.HeadObjectInput{
@@ -443,9 +627,12 @@ func (rs *S3Storage) WalkDir(ctx context.Context, opt *WalkOption, fn func(strin
 	}
 	req := &s3.ListObjectsInput{
 		Bucket:  aws.String(rs.options.Bucket),
-		Prefix:  aws.String(prefix),
+		Prefix:  aws.String(prefix + opt.globPrefix()),
 		MaxKeys: aws.Int64(maxKeys),
 	}
+	if opt.StartAfter != "" {
+		req.Marker = aws.String(prefix + opt.StartAfter)
+	}
 
 	for {
 		// FIXME: We can't use ListObjectsV2, it is not universally supported.
@@ -460,9 +647,15 @@ func (rs *S3Storage) WalkDir(ctx context.Context, opt *WalkOption, fn func(strin
 			// which can not be reuse in other API(Open/Read) directly.
 			// so we use TrimPrefix to filter Prefix for next Open/Read.
 			path := strings.TrimPrefix(*r.Key, rs.options.Prefix)
-			if err = fn(path, *r.Size); err != nil {
+			matched, err := opt.match(strings.TrimPrefix(*r.Key, prefix))
+			if err != nil {
 				return errors.Trace(err)
 			}
+			if matched {
+				if err = fn(path, *r.Size); err != nil {
+					return errors.Trace(err)
+				}
+			}
 
 			// https://docs.aws.amazon.com/AmazonS3/latest/API/API_ListObjects.html#AmazonS3-ListObjects-response-NextMarker -
 			//
@@ -483,6 +676,88 @@ func (rs *S3Storage) WalkDir(ctx context.Context, opt *WalkOption, fn func(strin
 	return nil
 }
 
+// Rename copies the file to the new name and deletes the old one, since S3
+// doesn't support renaming. The files larger than 5 GiB can't be copied.
+func (rs *S3Storage) Rename(ctx context.Context, oldName, newName string) error {
+	if err := rs.CopyFrom(ctx, rs, oldName, newName); err != nil {
+		return errors.Annotatef(err, "failed to copy %s to %s", oldName, newName)
+	}
+	return rs.DeleteFile(ctx, oldName)
+}
+
+// CopyFrom copies the file of the source storage, which is the same or another
+// bucket of the same endpoint, to the file inside S3. The files larger than
+// 5 GiB can't be copied.
+func (rs *S3Storage) CopyFrom(ctx context.Context, src *S3Storage, srcName, name string) error {
+	input := &s3.CopyObjectInput{
+		Bucket:     aws.String(rs.options.Bucket),
+		CopySource: aws.String(url.PathEscape(src.options.Bucket + "/" + src.options.Prefix + srcName)),
+		Key:        aws.String(rs.options.Prefix + name),
+	}
+	if rs.options.Acl != "" {
+		input = input.SetACL(rs.options.Acl)
+	}
+	if rs.options.Sse != "" {
+		input = input.SetServerSideEncryption(rs.options.Sse)
+	}
+	if rs.options.SseKmsKeyId != "" {
+		input = input.SetSSEKMSKeyId(rs.options.SseKmsKeyId)
+	}
+	if rs.options.StorageClass != "" {
+		input = input.SetStorageClass(rs.options.StorageClass)
+	}
+	rs.objectLock.applyToCopy(input)
+	_, err := rs.svc.CopyObjectWithContext(ctx, input)
+	return errors.Trace(err)
+}
+
+// DeleteFile deletes the file, S3 succeeds even if the file doesn't exist.
+func (rs *S3Storage) DeleteFile(ctx context.Context, name string) error {
+	_, err := rs.svc.DeleteObjectWithContext(ctx, &s3.DeleteObjectInput{
+		Bucket: aws.String(rs.options.Bucket),
+		Key:    aws.String(rs.options.Prefix + name),
+	})
+	return errors.Trace(err)
+}
+
+// DeletePrefix deletes the files whose paths start with the prefix, a batch of
+// files is deleted by each listed page.
+func (rs *S3Storage) DeletePrefix(ctx context.Context, prefix string) error {
+	req := &s3.ListObjectsInput{
+		Bucket: aws.String(rs.options.Bucket),
+		Prefix: aws.String(rs.options.Prefix + prefix),
+	}
+	for {
+		res, err := rs.svc.ListObjectsWithContext(ctx, req)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		if len(res.Contents) == 0 {
+			return nil
+		}
+		objects := make([]*s3.ObjectIdentifier, 0, len(res.Contents))
+		for _, r := range res.Contents {
+			objects = append(objects, &s3.ObjectIdentifier{Key: r.Key})
+		}
+		output, err := rs.svc.DeleteObjectsWithContext(ctx, &s3.DeleteObjectsInput{
+			Bucket: aws.String(rs.options.Bucket),
+			Delete: &s3.Delete{Objects: objects, Quiet: aws.Bool(true)},
+		})
+		if err != nil {
+			return errors.Trace(err)
+		}
+		if len(output.Errors) > 0 {
+			e := output.Errors[0]
+			return errors.Annotatef(berrors.ErrStorageUnknown, "failed to delete %d files, e.g. %s: %s %s", len(output.Errors),
+				aws.StringValue(e.Key), aws.StringValue(e.Code), aws.StringValue(e.Message))
+		}
+		if !aws.BoolValue(res.IsTruncated) {
+			return nil
+		}
+		req.Marker = res.Contents[len(res.Contents)-1].Key
+	}
+}
+
 // URI returns s3://<base>/<prefix>.
 func (rs *S3Storage) URI() string {
 	return "s3://" + rs.options.Bucket + "/" + rs.options.Prefix
@@ -539,6 +814,9 @@ func (rs *S3Storage) open(
 	input.Range = rangeOffset
 	result, err := rs.svc.GetObjectWithContext(ctx, input)
 	if err != nil {
+		if archivedErr := rs.checkArchived(err, path); archivedErr != nil {
+			return nil, RangeInfo{}, archivedErr
+		}
 		return nil, RangeInfo{}, errors.Trace(err)
 	}
 
@@ -555,6 +833,19 @@ func (rs *S3Storage) open(
 	return result.Body, r, nil
 }
 
+// checkArchived returns an actionable error if the object can't be read
+// because it is in an archive storage class.
+func (rs *S3Storage) checkArchived(err error, path string) error {
+	if aerr, ok := errors.Cause(err).(awserr.Error); !ok || aerr.Code() != invalidObjectState { // nolint:errorlint
+		return nil
+	}
+	return errors.Annotatef(berrors.ErrStorageObjectArchived,
+		"s3://%s/%s is in an archive storage class, please restore the objects of the backup by "+
+			"`aws s3api restore-object` (or copy them to a storage class with instant access) and wait for "+
+			"the restoration done before retrying, cause: %v",
+		rs.options.Bucket, rs.options.Prefix+path, err)
+}
+
 var contentRangeRegex = regexp.MustCompile(`bytes (\d+)-(\d+)/(\d+)$`)
 
 // ParseRangeInfo parses the Content-Range header and returns the offsets.
@@ -697,6 +988,10 @@ func (r *s3ObjectReader) Seek(offset int64, whence int) (int64, error) {
 
 // CreateUploader create multi upload request.
 func (rs *S3Storage) CreateUploader(ctx context.Context, name string) (ExternalFileWriter, error) {
+	return rs.createUploader(ctx, name)
+}
+
+func (rs *S3Storage) createUploader(ctx context.Context, name string) (*S3Uploader, error) {
 	input := &s3.CreateMultipartUploadInput{
 		Bucket: aws.String(rs.options.Bucket),
 		Key:    aws.String(rs.options.Prefix + name),
@@ -713,6 +1008,7 @@ func (rs *S3Storage) CreateUploader(ctx context.Context, name string) (ExternalF
 	if rs.options.StorageClass != "" {
 		input = input.SetStorageClass(rs.options.StorageClass)
 	}
+	rs.objectLock.applyToMultipartUpload(input)
 
 	resp, err := rs.svc.CreateMultipartUploadWithContext(ctx, input)
 	if err != nil {
@@ -722,17 +1018,17 @@ func (rs *S3Storage) CreateUploader(ctx context.Context, name string) (ExternalF
 		svc:           rs.svc,
 		createOutput:  resp,
 		completeParts: make([]*s3.CompletedPart, 0, 128),
+		verifyETag:    etagIsMD5(rs.options.Sse),
 	}, nil
 }
 
 // Create creates multi upload request.
 func (rs *S3Storage) Create(ctx context.Context, name string) (ExternalFileWriter, error) {
-	uploader, err := rs.CreateUploader(ctx, name)
+	uploader, err := rs.createUploader(ctx, name)
 	if err != nil {
 		return nil, err
 	}
-	uploaderWriter := newBufferedWriter(uploader, hardcodedS3ChunkSize, NoCompression)
-	return uploaderWriter, nil
+	return rs.upload.newWriter(uploader), nil
 }
 
 // retryerWithLog wrappes the client.DefaultRetryer, and logging when retry triggered.
@@ -0,0 +1,310 @@
+// Copyright 2021 PingCAP, Inc. Licensed under Apache-2.0.
+
+package storage
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/pingcap/errors"
+	"github.com/pingcap/log"
+	"github.com/spf13/pflag"
+	"go.uber.org/zap"
+	"golang.org/x/time/rate"
+
+	berrors "github.com/pingcap/br/pkg/errors"
+)
+
+const (
+	s3RequestRateGetOption = "s3.request-rate-get"
+	s3RequestRatePutOption = "s3.request-rate-put"
+
+	// s3SlowDownCode is the error code of S3 throttling the requests to a
+	// prefix.
+	s3SlowDownCode = "SlowDown"
+	// s3MinRequestRate is the floor of the paced request rate of a prefix.
+	s3MinRequestRate = 1
+	// s3PacingCooldown is how long the paced rate of a prefix stays after the
+	// last SlowDown before it's raised again.
+	s3PacingCooldown = 5 * time.Second
+)
+
+// The classes of the requests to S3. S3 limits the GET/HEAD requests and the
+// PUT/COPY/POST/DELETE requests of each prefix separately, and the LIST
+// requests count against the GET limit.
+const (
+	S3RequestGet  = "GET"
+	S3RequestPut  = "PUT"
+	S3RequestList = "LIST"
+)
+
+// S3RequestCounter counts the requests sent to S3 by the classes, including
+// the retries.
+type S3RequestCounter struct {
+	counts map[string]*uint64
+}
+
+// NewS3RequestCounter returns a counter of the requests sent to S3.
+func NewS3RequestCounter() *S3RequestCounter {
+	return &S3RequestCounter{counts: map[string]*uint64{
+		S3RequestGet:  new(uint64),
+		S3RequestPut:  new(uint64),
+		S3RequestList: new(uint64),
+	}}
+}
+
+// Counts returns the number of the requests counted by the classes.
+func (c *S3RequestCounter) Counts() map[string]uint64 {
+	counts := make(map[string]uint64, len(c.counts))
+	for class, n := range c.counts {
+		counts[class] = atomic.LoadUint64(n)
+	}
+	return counts
+}
+
+func (c *S3RequestCounter) inc(class string) {
+	atomic.AddUint64(c.counts[class], 1)
+}
+
+// s3RequestCounts are the requests sent to S3 in this process by the classes.
+var s3RequestCounts = NewS3RequestCounter()
+
+// S3RequestCounts returns the number of the requests sent to S3 in this
+// process by the classes, including the retries.
+func S3RequestCounts() map[string]uint64 {
+	return s3RequestCounts.Counts()
+}
+
+type s3RequestCounterKey struct{}
+
+// WithS3RequestCounter returns a context whose requests to S3 are counted by
+// the counter besides the counts of the process, so the requests of a job are
+// counted apart from the other jobs running in the process.
+func WithS3RequestCounter(ctx context.Context, counter *S3RequestCounter) context.Context {
+	return context.WithValue(ctx, s3RequestCounterKey{}, counter)
+}
+
+// S3RequestBudgetOptions are the request rates of BR to each prefix of S3.
+// Besides them, the requests to a prefix are paced down adaptively once S3
+// replies SlowDown, and raised back after S3 stops complaining.
+type S3RequestBudgetOptions struct {
+	// GetRate is the max GET, HEAD and LIST requests per second to a prefix,
+	// 0 means unlimited.
+	GetRate int `json:"get-rate" toml:"get-rate"`
+	// PutRate is the max PUT, COPY, POST and DELETE requests per second to a
+	// prefix, 0 means unlimited.
+	PutRate int `json:"put-rate" toml:"put-rate"`
+}
+
+func defineS3RequestBudgetFlags(flags *pflag.FlagSet) {
+	flags.Int(s3RequestRateGetOption, 0,
+		"(experimental) the max GET, HEAD and LIST requests per second of BR to each S3 prefix, 0 means unlimited. "+
+			"The requests are paced down adaptively anyway once S3 replies SlowDown")
+	flags.Int(s3RequestRatePutOption, 0,
+		"(experimental) the max PUT, COPY, POST and DELETE requests per second of BR to each S3 prefix, "+
+			"0 means unlimited")
+}
+
+func (options *S3RequestBudgetOptions) parseFromFlags(flags *pflag.FlagSet) error {
+	var err error
+	if options.GetRate, err = flags.GetInt(s3RequestRateGetOption); err != nil {
+		return errors.Trace(err)
+	}
+	if options.PutRate, err = flags.GetInt(s3RequestRatePutOption); err != nil {
+		return errors.Trace(err)
+	}
+	if options.GetRate < 0 || options.PutRate < 0 {
+		return errors.Annotatef(berrors.ErrStorageInvalidConfig,
+			"--%s and --%s can't be negative", s3RequestRateGetOption, s3RequestRatePutOption)
+	}
+	return nil
+}
+
+// limit returns the configured limit of the class.
+func (options *S3RequestBudgetOptions) limit(class string) rate.Limit {
+	var r int
+	if options != nil {
+		if class == S3RequestPut {
+			r = options.PutRate
+		} else {
+			r = options.GetRate
+		}
+	}
+	if r == 0 {
+		return rate.Inf
+	}
+	return rate.Limit(r)
+}
+
+// prefixBudget paces the requests of a class to a prefix.
+type prefixBudget struct {
+	mu      sync.Mutex
+	limiter *rate.Limiter
+	// max is the configured limit, the paced limit never exceeds it.
+	max          rate.Limit
+	lastSlowDown time.Time
+	lastRaise    time.Time
+	// the requests of the current window, and the rate of the last window,
+	// which is where the pacing starts from when S3 slows down.
+	windowStart time.Time
+	windowCount int
+	observed    float64
+}
+
+func newPrefixBudget(max rate.Limit) *prefixBudget {
+	return &prefixBudget{
+		limiter:     rate.NewLimiter(max, 1),
+		max:         max,
+		windowStart: time.Now(),
+	}
+}
+
+// record counts a request and raises the paced limit if S3 has stopped
+// slowing down for a while.
+func (b *prefixBudget) record(now time.Time) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if elapsed := now.Sub(b.windowStart); elapsed >= time.Second {
+		b.observed = float64(b.windowCount) / elapsed.Seconds()
+		b.windowStart, b.windowCount = now, 0
+	}
+	b.windowCount++
+
+	limit := b.limiter.Limit()
+	if limit >= b.max || b.lastSlowDown.IsZero() ||
+		now.Sub(b.lastSlowDown) < s3PacingCooldown || now.Sub(b.lastRaise) < time.Second {
+		return
+	}
+	limit = limit*1.2 + 1
+	if limit > b.max {
+		limit = b.max
+	}
+	b.limiter.SetLimitAt(now, limit)
+	b.lastRaise = now
+}
+
+// slowDown halves the paced limit, at most once a second since the
+// concurrent requests are throttled together.
+func (b *prefixBudget) slowDown(now time.Time) rate.Limit {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	limit := b.limiter.Limit()
+	if now.Sub(b.lastSlowDown) < time.Second {
+		return limit
+	}
+	if limit == rate.Inf {
+		limit = rate.Limit(b.observed)
+		if current := rate.Limit(b.windowCount); current > limit {
+			limit = current
+		}
+	}
+	limit /= 2
+	if limit < s3MinRequestRate {
+		limit = s3MinRequestRate
+	}
+	b.limiter.SetLimitAt(now, limit)
+	b.lastSlowDown = now
+	return limit
+}
+
+// s3Pacer accounts and paces the requests of an S3 client by the prefixes.
+type s3Pacer struct {
+	options *S3RequestBudgetOptions
+
+	mu      sync.Mutex
+	budgets map[string]*prefixBudget
+}
+
+func newS3Pacer(options *S3RequestBudgetOptions) *s3Pacer {
+	return &s3Pacer{options: options, budgets: make(map[string]*prefixBudget)}
+}
+
+// install paces every attempt of the requests before sending, and slows the
+// prefix down when an attempt fails with SlowDown.
+func (p *s3Pacer) install(handlers *request.Handlers) {
+	handlers.Send.PushFront(p.beforeSend)
+	handlers.Retry.PushFront(p.afterRetryableError)
+}
+
+// s3RequestClass returns the class of the request.
+func s3RequestClass(r *request.Request) string {
+	if strings.HasPrefix(r.Operation.Name, "List") {
+		return S3RequestList
+	}
+	switch r.Operation.HTTPMethod {
+	case http.MethodGet, http.MethodHead:
+		return S3RequestGet
+	default:
+		return S3RequestPut
+	}
+}
+
+// s3RequestPrefix returns the prefix the request is partitioned by, which is
+// the host and the path of the object or the listed prefix up to the last
+// "/", so the objects of a directory share the budget.
+func s3RequestPrefix(r *request.Request, class string) string {
+	u := r.HTTPRequest.URL
+	key := u.Path
+	if class == S3RequestList {
+		key = strings.TrimSuffix(key, "/") + "/" + u.Query().Get("prefix")
+	}
+	if i := strings.LastIndexByte(key, '/'); i >= 0 {
+		key = key[:i]
+	}
+	return u.Host + key
+}
+
+func (p *s3Pacer) budget(r *request.Request) (string, *prefixBudget) {
+	class := s3RequestClass(r)
+	limitClass := class
+	if limitClass == S3RequestList {
+		limitClass = S3RequestGet
+	}
+	prefix := s3RequestPrefix(r, class)
+	key := limitClass + " " + prefix
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	b, ok := p.budgets[key]
+	if !ok {
+		b = newPrefixBudget(p.options.limit(limitClass))
+		p.budgets[key] = b
+	}
+	return prefix, b
+}
+
+func (p *s3Pacer) beforeSend(r *request.Request) {
+	class := s3RequestClass(r)
+	s3RequestCounts.inc(class)
+	if counter, ok := r.Context().Value(s3RequestCounterKey{}).(*S3RequestCounter); ok {
+		counter.inc(class)
+	}
+	_, b := p.budget(r)
+	b.record(time.Now())
+	// the error is only from the canceled context, which fails the request
+	// when it's sent anyway.
+	_ = b.limiter.Wait(r.Context())
+}
+
+func (p *s3Pacer) afterRetryableError(r *request.Request) {
+	if !isS3SlowDown(r) {
+		return
+	}
+	prefix, b := p.budget(r)
+	limit := b.slowDown(time.Now())
+	log.Warn("S3 slows down the requests to the prefix, pacing them",
+		zap.String("prefix", prefix), zap.String("class", s3RequestClass(r)),
+		zap.Float64("requests-per-second", float64(limit)))
+}
+
+func isS3SlowDown(r *request.Request) bool {
+	if aerr, ok := r.Error.(awserr.Error); ok && aerr.Code() == s3SlowDownCode { // nolint:errorlint
+		return true
+	}
+	return r.HTTPResponse != nil && r.HTTPResponse.StatusCode == http.StatusServiceUnavailable
+}
@@ -0,0 +1,97 @@
+// Copyright 2021 PingCAP, Inc. Licensed under Apache-2.0.
+
+package storage
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	. "github.com/pingcap/check"
+	"golang.org/x/time/rate"
+)
+
+func newS3TestRequest(name, method, url string) *request.Request {
+	return &request.Request{
+		Operation:   &request.Operation{Name: name, HTTPMethod: method},
+		HTTPRequest: httptest.NewRequest(method, url, nil),
+	}
+}
+
+func (r *testStorageSuite) TestS3RequestClassAndPrefix(c *C) {
+	get := newS3TestRequest("GetObject", http.MethodGet, "https://s3.amazonaws.com/bucket/backup/sst/1.sst")
+	c.Assert(s3RequestClass(get), Equals, S3RequestGet)
+	c.Assert(s3RequestPrefix(get, S3RequestGet), Equals, "s3.amazonaws.com/bucket/backup/sst")
+
+	put := newS3TestRequest("UploadPart", http.MethodPut, "https://s3.amazonaws.com/bucket/backup/sst/2.sst")
+	c.Assert(s3RequestClass(put), Equals, S3RequestPut)
+	c.Assert(s3RequestPrefix(put, S3RequestPut), Equals, "s3.amazonaws.com/bucket/backup/sst")
+
+	list := newS3TestRequest("ListObjectsV2", http.MethodGet, "https://s3.amazonaws.com/bucket?prefix=backup/sst/")
+	c.Assert(s3RequestClass(list), Equals, S3RequestList)
+	c.Assert(s3RequestPrefix(list, S3RequestList), Equals, "s3.amazonaws.com/bucket/backup/sst")
+
+	// the LIST requests share the budget of the GET requests.
+	p := newS3Pacer(&S3RequestBudgetOptions{GetRate: 100})
+	_, getBudget := p.budget(get)
+	_, listBudget := p.budget(list)
+	_, putBudget := p.budget(put)
+	c.Assert(listBudget, Equals, getBudget)
+	c.Assert(getBudget.limiter.Limit(), Equals, rate.Limit(100))
+	c.Assert(putBudget.limiter.Limit(), Equals, rate.Inf)
+
+	before := S3RequestCounts()
+	p.beforeSend(put)
+	p.beforeSend(list)
+	after := S3RequestCounts()
+	c.Assert(after[S3RequestPut]-before[S3RequestPut], Equals, uint64(1))
+	c.Assert(after[S3RequestList]-before[S3RequestList], Equals, uint64(1))
+	c.Assert(after[S3RequestGet]-before[S3RequestGet], Equals, uint64(0))
+
+	// the requests of a job are counted apart from the other jobs.
+	job1, job2 := NewS3RequestCounter(), NewS3RequestCounter()
+	get.SetContext(WithS3RequestCounter(context.Background(), job1))
+	put.SetContext(WithS3RequestCounter(context.Background(), job2))
+	p.beforeSend(get)
+	p.beforeSend(put)
+	p.beforeSend(put)
+	c.Assert(job1.Counts(), DeepEquals, map[string]uint64{S3RequestGet: 1, S3RequestPut: 0, S3RequestList: 0})
+	c.Assert(job2.Counts(), DeepEquals, map[string]uint64{S3RequestGet: 0, S3RequestPut: 2, S3RequestList: 0})
+	c.Assert(S3RequestCounts()[S3RequestPut]-after[S3RequestPut], Equals, uint64(2))
+}
+
+func (r *testStorageSuite) TestS3SlowDownPacing(c *C) {
+	b := newPrefixBudget(rate.Inf)
+	now := time.Now()
+	for i := 0; i < 100; i++ {
+		b.record(now)
+	}
+	// the pacing starts from half of the observed rate.
+	c.Assert(b.slowDown(now), Equals, rate.Limit(50))
+	// the concurrent SlowDowns halve the rate only once.
+	c.Assert(b.slowDown(now.Add(100*time.Millisecond)), Equals, rate.Limit(50))
+	c.Assert(b.slowDown(now.Add(2*time.Second)), Equals, rate.Limit(25))
+
+	// the rate stays during the cooldown, and is raised after it.
+	b.record(now.Add(3 * time.Second))
+	c.Assert(b.limiter.Limit(), Equals, rate.Limit(25))
+	b.record(now.Add(2*time.Second + s3PacingCooldown))
+	c.Assert(b.limiter.Limit(), Equals, rate.Limit(31))
+
+	limited := newPrefixBudget(10)
+	limited.lastSlowDown = now
+	limited.limiter.SetLimit(9)
+	limited.record(now.Add(s3PacingCooldown))
+	c.Assert(limited.limiter.Limit(), Equals, rate.Limit(10))
+
+	slowDown := newS3TestRequest("PutObject", http.MethodPut, "https://s3.amazonaws.com/bucket/a")
+	slowDown.Error = awserr.New(s3SlowDownCode, "Please reduce your request rate.", nil)
+	c.Assert(isS3SlowDown(slowDown), IsTrue)
+	slowDown.Error = awserr.New("InternalError", "", nil)
+	c.Assert(isS3SlowDown(slowDown), IsFalse)
+	slowDown.HTTPResponse = &http.Response{StatusCode: http.StatusServiceUnavailable}
+	c.Assert(isS3SlowDown(slowDown), IsTrue)
+}
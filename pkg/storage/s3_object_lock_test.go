@@ -0,0 +1,53 @@
+// Copyright 2021 PingCAP, Inc. Licensed under Apache-2.0.
+
+package storage
+
+import (
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	. "github.com/pingcap/check"
+)
+
+func (r *testStorageSuite) TestS3ObjectLockOptions(c *C) {
+	options := &S3BackendOptions{ObjectLockMode: "compliance"}
+	c.Assert(options.adjustObjectLock(), ErrorMatches, ".*--s3.object-lock-retention must be positive.*")
+	options = &S3BackendOptions{ObjectLockRetention: time.Hour}
+	c.Assert(options.adjustObjectLock(), ErrorMatches, ".*--s3.object-lock-mode is required.*")
+	options = &S3BackendOptions{ObjectLockMode: "worm", ObjectLockRetention: time.Hour}
+	c.Assert(options.adjustObjectLock(), ErrorMatches, ".*unknown s3 object lock mode WORM.*")
+
+	options = &S3BackendOptions{}
+	c.Assert(options.adjustObjectLock(), IsNil)
+	c.Assert(options.ObjectLock(time.Now()), IsNil)
+
+	start := time.Date(2021, 8, 1, 0, 0, 0, 0, time.UTC)
+	options = &S3BackendOptions{ObjectLockMode: "compliance", ObjectLockRetention: 24 * time.Hour, ObjectLockLegalHold: true}
+	c.Assert(options.adjustObjectLock(), IsNil)
+	lock := options.ObjectLock(start)
+	c.Assert(lock, DeepEquals, &ObjectLockOptions{
+		Mode:        s3.ObjectLockModeCompliance,
+		RetainUntil: start.Add(24 * time.Hour),
+		LegalHold:   true,
+	})
+
+	put := &s3.PutObjectInput{}
+	lock.applyToPut(put)
+	c.Assert(aws.StringValue(put.ObjectLockMode), Equals, s3.ObjectLockModeCompliance)
+	c.Assert(aws.TimeValue(put.ObjectLockRetainUntilDate), Equals, start.Add(24*time.Hour))
+	c.Assert(aws.StringValue(put.ObjectLockLegalHoldStatus), Equals, s3.ObjectLockLegalHoldStatusOn)
+
+	// only the legal hold.
+	lock = (&S3BackendOptions{ObjectLockLegalHold: true}).ObjectLock(start)
+	upload := &s3.CreateMultipartUploadInput{}
+	lock.applyToMultipartUpload(upload)
+	c.Assert(upload.ObjectLockMode, IsNil)
+	c.Assert(upload.ObjectLockRetainUntilDate, IsNil)
+	c.Assert(aws.StringValue(upload.ObjectLockLegalHoldStatus), Equals, s3.ObjectLockLegalHoldStatusOn)
+
+	var disabled *ObjectLockOptions
+	put = &s3.PutObjectInput{}
+	disabled.applyToPut(put)
+	c.Assert(put.ObjectLockMode, IsNil)
+}
@@ -10,6 +10,7 @@ import (
 	"io"
 	"math/rand"
 	"os"
+	"testing/iotest"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/awserr"
@@ -19,6 +20,7 @@ import (
 	"github.com/pingcap/errors"
 	backuppb "github.com/pingcap/kvproto/pkg/backup"
 
+	berrors "github.com/pingcap/br/pkg/errors"
 	"github.com/pingcap/br/pkg/mock"
 	. "github.com/pingcap/br/pkg/storage"
 )
@@ -118,6 +120,14 @@ func (s *s3Suite) TestApply(c *C) {
 			errMsg:    "parse (.*)!http:12345(.*): first path segment in URL cannot contain colon.*",
 			errReturn: true,
 		},
+		{
+			name: "unknown storage class",
+			options: S3BackendOptions{
+				StorageClass: "glacier_x",
+			},
+			errMsg:    "unknown s3 storage class GLACIER_X.*",
+			errReturn: true,
+		},
 	}
 	for i := range tests {
 		testFn(&tests[i], c)
@@ -167,6 +177,19 @@ func (s *s3Suite) TestApplyUpdate(c *C) {
 				Prefix: "prefix",
 			},
 		},
+		{
+			name: "storage class",
+			options: S3BackendOptions{
+				Region:       "us-west-2",
+				StorageClass: "glacier_ir",
+			},
+			s3: &backuppb.S3{
+				Region:       "us-west-2",
+				Bucket:       "bucket",
+				Prefix:       "prefix",
+				StorageClass: "GLACIER_IR",
+			},
+		},
 		{
 			name: "https endpoint",
 			options: S3BackendOptions{
@@ -466,6 +489,26 @@ func (s *s3Suite) TestWriteNoError(c *C) {
 	c.Assert(err, IsNil)
 }
 
+// TestWriteChecksumMismatch checks that the upload fails if the ETag returned by
+// S3 doesn't match the MD5 digest of the content.
+func (s *s3Suite) TestWriteChecksumMismatch(c *C) {
+	s.setUpTest(c)
+	defer s.tearDownTest()
+	ctx := aws.BackgroundContext()
+
+	s.s3.EXPECT().
+		PutObjectWithContext(ctx, gomock.Any()).
+		DoAndReturn(func(_ context.Context, input *s3.PutObjectInput) (*s3.PutObjectOutput, error) {
+			// the base64-encoded MD5 digest of "test".
+			c.Assert(aws.StringValue(input.ContentMD5), Equals, "CY9rzUYh03PK3k6DJie09g==")
+			return &s3.PutObjectOutput{ETag: aws.String(`"d41d8cd98f00b204e9800998ecf8427e"`)}, nil
+		})
+
+	err := s.storage.WriteFile(ctx, "file", []byte("test"))
+	c.Assert(err, ErrorMatches, ".*expect md5 098f6bcd4621d373cade4e832627b4f6, but got etag d41d8cd98f00b204e9800998ecf8427e.*")
+	c.Assert(errors.Cause(err), Equals, berrors.ErrStorageChecksumMismatch)
+}
+
 // TestReadNoError ensures the ReadFile API issues a GetObject request and correctly
 // read the entire body.
 func (s *s3Suite) TestReadNoError(c *C) {
@@ -524,6 +567,66 @@ func (s *s3Suite) TestFileExistsMissing(c *C) {
 	c.Assert(exists, IsFalse)
 }
 
+func (s *s3Suite) TestRenameAndDelete(c *C) {
+	s.setUpTest(c)
+	defer s.tearDownTest()
+	ctx := aws.BackgroundContext()
+
+	s.s3.EXPECT().
+		CopyObjectWithContext(ctx, gomock.Any()).
+		DoAndReturn(func(_ context.Context, input *s3.CopyObjectInput) (*s3.CopyObjectOutput, error) {
+			c.Assert(aws.StringValue(input.CopySource), Equals, "bucket%2Fprefix%2Fmeta.tmp")
+			c.Assert(aws.StringValue(input.Key), Equals, "prefix/meta")
+			c.Assert(aws.StringValue(input.ServerSideEncryption), Equals, "sse")
+			c.Assert(aws.StringValue(input.StorageClass), Equals, "sc")
+			return &s3.CopyObjectOutput{}, nil
+		})
+	s.s3.EXPECT().
+		DeleteObjectWithContext(ctx, gomock.Any()).
+		DoAndReturn(func(_ context.Context, input *s3.DeleteObjectInput) (*s3.DeleteObjectOutput, error) {
+			c.Assert(aws.StringValue(input.Key), Equals, "prefix/meta.tmp")
+			return &s3.DeleteObjectOutput{}, nil
+		})
+	c.Assert(s.storage.Rename(ctx, "meta.tmp", "meta"), IsNil)
+
+	s.s3.EXPECT().
+		ListObjectsWithContext(ctx, gomock.Any()).
+		DoAndReturn(func(_ context.Context, input *s3.ListObjectsInput) (*s3.ListObjectsOutput, error) {
+			c.Assert(aws.StringValue(input.Prefix), Equals, "prefix/v1/")
+			return &s3.ListObjectsOutput{
+				Contents: []*s3.Object{{Key: aws.String("prefix/v1/a")}, {Key: aws.String("prefix/v1/b")}},
+			}, nil
+		})
+	s.s3.EXPECT().
+		DeleteObjectsWithContext(ctx, gomock.Any()).
+		DoAndReturn(func(_ context.Context, input *s3.DeleteObjectsInput) (*s3.DeleteObjectsOutput, error) {
+			c.Assert(input.Delete.Objects, HasLen, 2)
+			return &s3.DeleteObjectsOutput{
+				Errors: []*s3.Error{{Key: aws.String("prefix/v1/b"), Code: aws.String("AccessDenied")}},
+			}, nil
+		})
+	err := s.storage.DeletePrefix(ctx, "v1/")
+	c.Assert(err, ErrorMatches, ".*failed to delete 1 files, e.g. prefix/v1/b: AccessDenied.*")
+}
+
+func (s *s3Suite) TestCopyFrom(c *C) {
+	s.setUpTest(c)
+	defer s.tearDownTest()
+	ctx := aws.BackgroundContext()
+
+	src := NewS3StorageForTest(s.s3, &backuppb.S3{Bucket: "minio", Prefix: "backup/"})
+	s.s3.EXPECT().
+		CopyObjectWithContext(ctx, gomock.Any()).
+		DoAndReturn(func(_ context.Context, input *s3.CopyObjectInput) (*s3.CopyObjectOutput, error) {
+			c.Assert(aws.StringValue(input.CopySource), Equals, "minio%2Fbackup%2F1.sst")
+			c.Assert(aws.StringValue(input.Bucket), Equals, "bucket")
+			c.Assert(aws.StringValue(input.Key), Equals, "prefix/1.sst")
+			c.Assert(aws.StringValue(input.StorageClass), Equals, "sc")
+			return &s3.CopyObjectOutput{}, nil
+		})
+	c.Assert(s.storage.CopyFrom(ctx, src, "1.sst", "1.sst"), IsNil)
+}
+
 // TestWriteError checks that a PutObject error is propagated.
 func (s *s3Suite) TestWriteError(c *C) {
 	s.setUpTest(c)
@@ -558,6 +661,109 @@ func (s *s3Suite) TestReadError(c *C) {
 		"input.bucket='bucket', input.key='prefix/file-missing': "+expectedErr.Error())
 }
 
+// TestReadArchived checks that reading an archived object returns an
+// actionable error.
+func (s *s3Suite) TestReadArchived(c *C) {
+	s.setUpTest(c)
+	defer s.tearDownTest()
+	ctx := aws.BackgroundContext()
+
+	archivedErr := awserr.NewRequestFailure(
+		awserr.New("InvalidObjectState", "The operation is not valid for the object's storage class", nil),
+		403, "")
+	s.s3.EXPECT().
+		GetObjectWithContext(ctx, gomock.Any()).
+		Return(nil, archivedErr).
+		Times(2)
+
+	_, err := s.storage.ReadFile(ctx, "file-archived")
+	c.Assert(berrors.Is(err, berrors.ErrStorageObjectArchived), IsTrue)
+	c.Assert(err, ErrorMatches, "s3://bucket/prefix/file-archived is in an archive storage class.*")
+
+	_, err = s.storage.ReadRange(ctx, "file-archived", 0, 1)
+	c.Assert(berrors.Is(err, berrors.ErrStorageObjectArchived), IsTrue)
+}
+
+// TestReadRangeOutOfFile checks that the ranges exceeding the object and the
+// empty ranges are handled as the interface describes.
+func (s *s3Suite) TestReadRangeOutOfFile(c *C) {
+	s.setUpTest(c)
+	defer s.tearDownTest()
+	ctx := aws.BackgroundContext()
+
+	// the range starting after the end.
+	s.s3.EXPECT().
+		GetObjectWithContext(ctx, gomock.Any()).
+		Return(nil, awserr.NewRequestFailure(
+			awserr.New("InvalidRange", "The requested range is not satisfiable", nil), 416, "")).
+		Times(2)
+	s.s3.EXPECT().
+		HeadObjectWithContext(ctx, gomock.Any()).
+		Return(&s3.HeadObjectOutput{ContentLength: aws.Int64(10)}, nil).
+		Times(2)
+	_, err := s.storage.ReadRange(ctx, "file", 12, 4)
+	c.Assert(errors.Cause(err), Equals, io.ErrUnexpectedEOF)
+	// the range starting at the end is empty, as the local storage reads.
+	data, err := s.storage.ReadRange(ctx, "file", 10, -1)
+	c.Assert(err, IsNil)
+	c.Assert(data, HasLen, 0)
+
+	// the range ending after the end is cut by S3.
+	s.s3.EXPECT().
+		GetObjectWithContext(ctx, gomock.Any()).
+		DoAndReturn(func(_ context.Context, input *s3.GetObjectInput) (*s3.GetObjectOutput, error) {
+			c.Assert(aws.StringValue(input.Range), Equals, "bytes=8-11")
+			return &s3.GetObjectOutput{
+				Body:         io.NopCloser(bytes.NewReader([]byte("89"))),
+				ContentRange: aws.String("bytes 8-9/10"),
+			}, nil
+		})
+	_, err = s.storage.ReadRange(ctx, "file", 8, 4)
+	c.Assert(errors.Cause(err), Equals, io.ErrUnexpectedEOF)
+
+	// the empty range doesn't open an unbounded range, only the offset is
+	// checked.
+	s.s3.EXPECT().
+		HeadObjectWithContext(ctx, gomock.Any()).
+		Return(&s3.HeadObjectOutput{ContentLength: aws.Int64(10)}, nil).
+		Times(2)
+	data, err = s.storage.ReadRange(ctx, "file", 10, 0)
+	c.Assert(err, IsNil)
+	c.Assert(data, HasLen, 0)
+	_, err = s.storage.ReadRange(ctx, "file", 11, 0)
+	c.Assert(errors.Cause(err), Equals, io.ErrUnexpectedEOF)
+}
+
+// TestRangeReaderChunkSizedFile checks that the range reader reads an object
+// exactly one chunk long, whose next chunk starts at the end of the object.
+func (s *s3Suite) TestRangeReaderChunkSizedFile(c *C) {
+	s.setUpTest(c)
+	defer s.tearDownTest()
+	ctx := aws.BackgroundContext()
+
+	s.s3.EXPECT().
+		GetObjectWithContext(ctx, gomock.Any()).
+		DoAndReturn(func(_ context.Context, input *s3.GetObjectInput) (*s3.GetObjectOutput, error) {
+			if aws.StringValue(input.Range) != "bytes=0-3" {
+				return nil, awserr.NewRequestFailure(
+					awserr.New("InvalidRange", "The requested range is not satisfiable", nil), 416, "")
+			}
+			return &s3.GetObjectOutput{
+				Body:         io.NopCloser(bytes.NewReader([]byte("0123"))),
+				ContentRange: aws.String("bytes 0-3/4"),
+			}, nil
+		}).
+		Times(3)
+	s.s3.EXPECT().
+		HeadObjectWithContext(ctx, gomock.Any()).
+		Return(&s3.HeadObjectOutput{ContentLength: aws.Int64(4)}, nil).
+		Times(2)
+
+	data, err := io.ReadAll(NewRangeReader(ctx, s.storage, "file", 4))
+	c.Assert(err, IsNil)
+	c.Assert(string(data), Equals, "0123")
+}
+
 // TestFileExistsError checks that a HeadObject error is propagated.
 func (s *s3Suite) TestFileExistsError(c *C) {
 	s.setUpTest(c)
@@ -824,6 +1030,41 @@ func (s *s3Suite) TestS3ReaderWithRetryFailed(c *C) {
 	c.Assert(err, ErrorMatches, "read exceeded limit")
 }
 
+// TestReadFileWithResume checks ReadFile resumes from the last offset when
+// the stream breaks halfway.
+func (s *s3Suite) TestReadFileWithResume(c *C) {
+	s.setUpTest(c)
+	defer s.tearDownTest()
+	ctx := aws.BackgroundContext()
+
+	someRandomBytes := make([]byte, 100)
+	rand.Read(someRandomBytes) //nolint:gosec
+	brokenReader := func(offset int) io.ReadCloser {
+		end := offset + 40
+		if end >= len(someRandomBytes) {
+			return io.NopCloser(bytes.NewReader(someRandomBytes[offset:]))
+		}
+		return io.NopCloser(io.MultiReader(
+			bytes.NewReader(someRandomBytes[offset:end]),
+			iotest.ErrReader(errors.New("connection reset by peer")),
+		))
+	}
+
+	s.s3.EXPECT().
+		GetObjectWithContext(ctx, gomock.Any()).
+		DoAndReturn(func(_ context.Context, input *s3.GetObjectInput) (*s3.GetObjectOutput, error) {
+			c.Assert(input.Range, IsNil)
+			return &s3.GetObjectOutput{Body: brokenReader(0)}, nil
+		})
+	s.expectedCalls(ctx, c, someRandomBytes, []int{40, 80}, func(data []byte, offset int) io.ReadCloser {
+		return brokenReader(offset)
+	})
+
+	content, err := s.storage.ReadFile(ctx, "random")
+	c.Assert(err, IsNil)
+	c.Assert(content, DeepEquals, someRandomBytes)
+}
+
 // TestWalkDir checks WalkDir retrieves all directory content under a prefix.
 func (s *s3Suite) TestWalkDir(c *C) {
 	s.setUpTest(c)
@@ -948,6 +1189,39 @@ func (s *s3Suite) TestWalkDir(c *C) {
 	c.Assert(i, Equals, len(contents))
 }
 
+// TestWalkDirWithFilter checks the glob prefix and the marker are sent to S3.
+func (s *s3Suite) TestWalkDirWithFilter(c *C) {
+	s.setUpTest(c)
+	defer s.tearDownTest()
+	ctx := aws.BackgroundContext()
+
+	s.s3.EXPECT().
+		ListObjectsWithContext(ctx, gomock.Any()).
+		DoAndReturn(func(_ context.Context, input *s3.ListObjectsInput) (*s3.ListObjectsOutput, error) {
+			c.Assert(aws.StringValue(input.Prefix), Equals, "prefix/sp/cdclog.")
+			c.Assert(aws.StringValue(input.Marker), Equals, "prefix/sp/cdclog.1")
+			return &s3.ListObjectsOutput{
+				IsTruncated: aws.Bool(false),
+				Contents: []*s3.Object{
+					{Key: aws.String("prefix/sp/cdclog.2"), Size: aws.Int64(2)},
+					{Key: aws.String("prefix/sp/cdclog.2.tmp"), Size: aws.Int64(3)},
+				},
+			}, nil
+		})
+
+	var paths []string
+	err := s.storage.WalkDir(
+		ctx,
+		&WalkOption{SubDir: "sp", Glob: "cdclog.[0-9]", StartAfter: "cdclog.1"},
+		func(path string, size int64) error {
+			paths = append(paths, path)
+			return nil
+		},
+	)
+	c.Assert(err, IsNil)
+	c.Assert(paths, DeepEquals, []string{"sp/cdclog.2"})
+}
+
 // TestWalkDirBucket checks WalkDir retrieves all directory content under a bucket.
 func (s *s3SuiteCustom) TestWalkDirWithEmptyPrefix(c *C) {
 	controller := gomock.NewController(c)
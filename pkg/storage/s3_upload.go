@@ -0,0 +1,69 @@
+// Copyright 2021 PingCAP, Inc. Licensed under Apache-2.0.
+
+package storage
+
+import (
+	"github.com/docker/go-units"
+	"github.com/pingcap/errors"
+	"github.com/spf13/pflag"
+
+	berrors "github.com/pingcap/br/pkg/errors"
+)
+
+const (
+	s3UploadPartSizeOption    = "s3.upload-part-size"
+	s3UploadConcurrencyOption = "s3.upload-concurrency"
+
+	// s3MinPartSize is the minimum size of the parts of a multipart upload
+	// except the last one.
+	s3MinPartSize = 5 * 1024 * 1024
+)
+
+// S3UploadOptions tunes the multipart uploads of BR to S3. They aren't a part
+// of the backend, so the uploads by TiKV aren't affected.
+type S3UploadOptions struct {
+	// PartSize is the size of the parts of multipart uploads, 0 means 5MiB.
+	PartSize int64 `json:"part-size" toml:"part-size"`
+	// Concurrency is the number of the parts of a file uploaded at the same
+	// time while the next part is being filled, 0 or 1 uploads the parts one
+	// by one.
+	Concurrency int `json:"concurrency" toml:"concurrency"`
+}
+
+func defineS3UploadFlags(flags *pflag.FlagSet) {
+	flags.String(s3UploadPartSizeOption, units.BytesSize(s3MinPartSize),
+		"(experimental) the part size of the multipart uploads of BR to S3, e.g. 16MiB, at least 5MiB")
+	flags.Int(s3UploadConcurrencyOption, 1,
+		"(experimental) the number of the parts of a file uploaded by BR to S3 concurrently. "+
+			"More concurrent parts hide the round trips on high-latency links at the cost of part-size memory each")
+}
+
+func (options *S3UploadOptions) parseFromFlags(flags *pflag.FlagSet) error {
+	var err error
+	options.PartSize, err = parseSizeFlag(flags, s3UploadPartSizeOption)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if options.PartSize != 0 && options.PartSize < s3MinPartSize {
+		return errors.Annotatef(berrors.ErrStorageInvalidConfig,
+			"--%s %d is smaller than the minimum part size of S3 5MiB", s3UploadPartSizeOption, options.PartSize)
+	}
+	options.Concurrency, err = flags.GetInt(s3UploadConcurrencyOption)
+	return errors.Trace(err)
+}
+
+// partSize returns the size of the parts of multipart uploads.
+func (options *S3UploadOptions) partSize() int {
+	if options == nil || options.PartSize == 0 {
+		return hardcodedS3ChunkSize
+	}
+	return int(options.PartSize)
+}
+
+// newWriter returns the writer of the multipart upload.
+func (options *S3UploadOptions) newWriter(uploader *S3Uploader) ExternalFileWriter {
+	if options == nil || options.Concurrency <= 1 {
+		return newBufferedWriter(uploader, options.partSize(), NoCompression)
+	}
+	return NewConcurrentWriter(uploader, options.partSize(), options.Concurrency)
+}
@@ -3,12 +3,18 @@
 package storage
 
 import (
+	"bytes"
 	"context"
 	"io"
 	"net/http"
+	"path"
+	"regexp"
+	"strings"
 
 	"github.com/pingcap/errors"
 	backuppb "github.com/pingcap/kvproto/pkg/backup"
+	"github.com/pingcap/log"
+	"go.uber.org/zap"
 
 	berrors "github.com/pingcap/br/pkg/errors"
 )
@@ -45,6 +51,44 @@ type WalkOption struct {
 	// to reduce the possibility of timeout on an extremely slow connection, or
 	// perform testing.
 	ListCount int64
+	// Glob filters the files by a pattern in the syntax of path.Match, which
+	// is matched against the file path relative to SubDir, e.g. "cdclog.*".
+	// The literal part before the first special character is sent to the
+	// cloud storages as the prefix of the listing requests.
+	Glob string
+	// Regexp filters the files by a regular expression, which is matched
+	// against the file path relative to SubDir.
+	Regexp *regexp.Regexp
+	// StartAfter skips the files whose paths relative to SubDir are not
+	// lexicographically greater than it. It is sent to S3 as the marker of
+	// the listing requests.
+	StartAfter string
+}
+
+// globPrefix returns the literal prefix of the Glob.
+func (opt *WalkOption) globPrefix() string {
+	if i := strings.IndexAny(opt.Glob, `*?[\`); i >= 0 {
+		return opt.Glob[:i]
+	}
+	return opt.Glob
+}
+
+// match checks whether the file should be visited by WalkDir, the name is its
+// path relative to SubDir.
+func (opt *WalkOption) match(name string) (bool, error) {
+	if opt.StartAfter != "" && name <= opt.StartAfter {
+		return false, nil
+	}
+	if opt.Glob != "" {
+		matched, err := path.Match(opt.Glob, name)
+		if err != nil {
+			return false, errors.Annotatef(berrors.ErrInvalidArgument, "invalid glob pattern %q", opt.Glob)
+		}
+		if !matched {
+			return false, nil
+		}
+	}
+	return opt.Regexp == nil || opt.Regexp.MatchString(name), nil
 }
 
 // ReadSeekCloser is the interface that groups the basic Read, Seek and Close methods.
@@ -76,6 +120,11 @@ type ExternalStorage interface {
 	WriteFile(ctx context.Context, name string, data []byte) error
 	// ReadFile reads a complete file from storage, similar to os.ReadFile
 	ReadFile(ctx context.Context, name string) ([]byte, error)
+	// ReadRange reads `length` bytes starting at `offset` of a file from
+	// storage. A negative length reads until the end of the file. It returns
+	// an error wrapping io.ErrUnexpectedEOF if the range exceeds the file,
+	// including the offset after the end even if the length is 0.
+	ReadRange(ctx context.Context, name string, offset, length int64) ([]byte, error)
 	// FileExists return true if file exists
 	FileExists(ctx context.Context, name string) (bool, error)
 	// Open a Reader by file path. path is relative path to storage base path
@@ -93,6 +142,70 @@ type ExternalStorage interface {
 
 	// Create opens a file writer by path. path is relative path to storage base path
 	Create(ctx context.Context, path string) (ExternalFileWriter, error)
+	// Rename renames a file, replacing the existing one of the new name. It's
+	// atomic on the local storage, but the cloud storages copy the file then
+	// delete the old one, so the readers may see both files for a while.
+	Rename(ctx context.Context, oldName, newName string) error
+	// DeleteFile deletes a file. It's not an error if the file doesn't exist.
+	DeleteFile(ctx context.Context, name string) error
+	// DeletePrefix deletes all the files whose paths start with the prefix,
+	// e.g. "backupmeta." or "v1/". It's not atomic, a failed deletion may
+	// leave some of the files.
+	DeletePrefix(ctx context.Context, prefix string) error
+}
+
+// readRange reads the byte range [offset, offset+length) from a reader that
+// is positioned at `offset`. A negative length reads all remaining bytes.
+func readRange(r io.Reader, length int64) ([]byte, error) {
+	if length < 0 {
+		data, err := io.ReadAll(r)
+		return data, errors.Trace(err)
+	}
+	data := make([]byte, length)
+	_, err := io.ReadFull(r, data)
+	if err == io.EOF { // nolint:errorlint
+		// nothing is left after the offset.
+		err = io.ErrUnexpectedEOF
+	}
+	return data, errors.Trace(err)
+}
+
+// checkRange returns an error wrapping io.ErrUnexpectedEOF if the range of the
+// length starting at the offset exceeds the file of the size.
+func checkRange(name string, offset, length, size int64) error {
+	if offset > size || (length > 0 && offset+length > size) {
+		return errors.Annotatef(io.ErrUnexpectedEOF,
+			"range of %d bytes at offset %d exceeds %s of %d bytes", length, offset, name, size)
+	}
+	return nil
+}
+
+// readAllWithResume reads all data from the reader and closes it. When the
+// stream breaks halfway, the partial data is kept and `reopen` is called to
+// continue reading from the last successful offset, at most maxErrorRetries
+// times.
+func readAllWithResume(
+	name string,
+	reader io.ReadCloser,
+	reopen func(offset int64) (io.ReadCloser, error),
+) ([]byte, error) {
+	var buf bytes.Buffer
+	for retryCnt := 0; ; retryCnt++ {
+		_, err := buf.ReadFrom(reader)
+		_ = reader.Close()
+		if err == nil {
+			return buf.Bytes(), nil
+		}
+		if retryCnt >= maxErrorRetries {
+			return nil, errors.Trace(err)
+		}
+		log.Warn("read stream broken, resume from the last offset",
+			zap.String("file", name), zap.Int("offset", buf.Len()), zap.Error(err))
+		reader, err = reopen(int64(buf.Len()))
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+	}
 }
 
 // ExternalFileReader represents the streaming external file reader.
@@ -139,6 +252,51 @@ type ExternalStorageOptions struct {
 	// CheckPermissions check the given permission in New() function.
 	// make sure we can access the storage correctly before execute tasks.
 	CheckPermissions []Permission
+
+	// RetryOptions configures retrying the operations failed with transient
+	// errors. Retry is disabled if it is nil.
+	RetryOptions *RetryOptions
+
+	// RateLimit is the max bytes per second of the reads and writes, shared
+	// by all operations of the created storage. 0 means unlimited.
+	RateLimit uint64
+
+	// CacheSize is the capacity in bytes of the cache of the content read by
+	// ReadFile. 0 disables the cache.
+	CacheSize int64
+
+	// RequesterPays marks the S3 requests to be charged to the requester, so
+	// the requester-pays buckets of other accounts can be accessed. It only
+	// affects the requests sent by BR.
+	RequesterPays bool
+
+	// Proxy is the URL of the HTTP or SOCKS5 proxy of the S3 and GCS clients,
+	// which overrides the proxy of the HTTPClient and environment variables.
+	Proxy string
+
+	// GCSUpload tunes the uploads and requests of the GCS storage, nil means
+	// using the defaults of the GCS client.
+	GCSUpload *GCSUploadOptions
+
+	// S3Upload tunes the multipart uploads of the S3 storage, nil means
+	// uploading 5MiB parts one by one.
+	S3Upload *S3UploadOptions
+
+	// S3RequestBudget is the request rates to each prefix of the S3 storage,
+	// nil means only pacing the requests once S3 replies SlowDown.
+	S3RequestBudget *S3RequestBudgetOptions
+
+	// Local tunes the writes of the local storage, nil means writing through
+	// the page cache without fsync.
+	Local *LocalOptions
+
+	// Credentials chooses the provider of the credentials of the S3 and GCS
+	// clients, nil means the static keys or the default credential chain.
+	Credentials *CredentialOptions
+
+	// ObjectLock is the S3 object lock of the objects written by BR, nil
+	// means not locking them.
+	ObjectLock *ObjectLockOptions
 }
 
 // Create creates ExternalStorage.
@@ -154,12 +312,34 @@ func Create(ctx context.Context, backend *backuppb.StorageBackend, sendCreds boo
 
 // New creates an ExternalStorage with options.
 func New(ctx context.Context, backend *backuppb.StorageBackend, opts *ExternalStorageOptions) (ExternalStorage, error) {
+	s, err := newBackend(ctx, backend, opts)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	s = WithMetrics(s, backendName(backend))
+	if opts != nil && opts.RateLimit > 0 {
+		s = WithRateLimit(s, opts.RateLimit)
+	}
+	if opts != nil && opts.RetryOptions != nil {
+		s = WithRetry(s, *opts.RetryOptions)
+	}
+	if opts != nil && opts.CacheSize > 0 {
+		s = WithCache(s, opts.CacheSize)
+	}
+	return s, nil
+}
+
+func newBackend(ctx context.Context, backend *backuppb.StorageBackend, opts *ExternalStorageOptions) (ExternalStorage, error) {
 	switch backend := backend.Backend.(type) {
 	case *backuppb.StorageBackend_Local:
 		if backend.Local == nil {
 			return nil, errors.Annotate(berrors.ErrStorageInvalidConfig, "local config not found")
 		}
-		return NewLocalStorage(backend.Local.Path)
+		var localOpts *LocalOptions
+		if opts != nil {
+			localOpts = opts.Local
+		}
+		return newLocalStorage(backend.Local.Path, localOpts)
 	case *backuppb.StorageBackend_S3:
 		if backend.S3 == nil {
 			return nil, errors.Annotate(berrors.ErrStorageInvalidConfig, "s3 config not found")
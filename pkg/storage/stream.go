@@ -0,0 +1,197 @@
+// Copyright 2021 PingCAP, Inc. Licensed under Apache-2.0.
+
+package storage
+
+import (
+	"archive/tar"
+	"context"
+	"io"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/pingcap/errors"
+	"github.com/pingcap/log"
+	"go.uber.org/zap"
+
+	berrors "github.com/pingcap/br/pkg/errors"
+)
+
+// The stream URLs. A backup written to `stdout://` or `pipe:///path/to/fifo`
+// is streamed as a tar archive, which is read back from `stdin://` or the pipe
+// by restore.
+const (
+	streamStdout = "stdout"
+	streamStdin  = "stdin"
+	streamPipe   = "pipe"
+)
+
+// IsStreamURL returns whether the URL is a stream rather than a storage.
+func IsStreamURL(rawURL string) bool {
+	u, err := ParseRawURL(rawURL)
+	if err != nil {
+		return false
+	}
+	switch u.Scheme {
+	case streamStdout, streamStdin, streamPipe:
+		return true
+	default:
+		return false
+	}
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error {
+	return nil
+}
+
+// OpenStreamWriter opens the stream of `stdout://` or `pipe:///path`.
+func OpenStreamWriter(rawURL string) (io.WriteCloser, error) {
+	u, err := ParseRawURL(rawURL)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	switch u.Scheme {
+	case streamStdout:
+		return nopWriteCloser{Writer: os.Stdout}, nil
+	case streamPipe:
+		f, err := os.OpenFile(u.Path, os.O_WRONLY, 0)
+		if err != nil {
+			return nil, errors.Annotatef(err, "open pipe %s", u.Path)
+		}
+		return f, nil
+	default:
+		return nil, errors.Annotatef(berrors.ErrStorageInvalidConfig,
+			"%s is not writable stream, should be stdout:// or pipe:///path", rawURL)
+	}
+}
+
+// OpenStreamReader opens the stream of `stdin://` or `pipe:///path`.
+func OpenStreamReader(rawURL string) (io.ReadCloser, error) {
+	u, err := ParseRawURL(rawURL)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	switch u.Scheme {
+	case streamStdin:
+		return io.NopCloser(os.Stdin), nil
+	case streamPipe:
+		f, err := os.Open(u.Path)
+		if err != nil {
+			return nil, errors.Annotatef(err, "open pipe %s", u.Path)
+		}
+		return f, nil
+	default:
+		return nil, errors.Annotatef(berrors.ErrStorageInvalidConfig,
+			"%s is not readable stream, should be stdin:// or pipe:///path", rawURL)
+	}
+}
+
+// WriteArchive writes the files of the storage to w as a tar archive in the
+// order of the names. The files are streamed without being buffered in memory.
+func WriteArchive(ctx context.Context, s ExternalStorage, names []string, w io.Writer) error {
+	start := time.Now()
+	tw := tar.NewWriter(w)
+	var total int64
+	for _, name := range names {
+		if err := ctx.Err(); err != nil {
+			return errors.Trace(err)
+		}
+		size, err := writeArchiveFile(ctx, s, name, tw)
+		if err != nil {
+			return errors.Annotatef(err, "archive file %s", name)
+		}
+		total += size
+	}
+	if err := tw.Close(); err != nil {
+		return errors.Trace(err)
+	}
+	log.Info("backup archive written",
+		zap.String("storage", s.URI()),
+		zap.Int("files", len(names)),
+		zap.Int64("size", total),
+		zap.Duration("take", time.Since(start)))
+	return nil
+}
+
+func writeArchiveFile(ctx context.Context, s ExternalStorage, name string, tw *tar.Writer) (int64, error) {
+	r, err := s.Open(ctx, name)
+	if err != nil {
+		return 0, errors.Trace(err)
+	}
+	defer r.Close()
+	size, err := r.Seek(0, io.SeekEnd)
+	if err != nil {
+		return 0, errors.Trace(err)
+	}
+	if _, err = r.Seek(0, io.SeekStart); err != nil {
+		return 0, errors.Trace(err)
+	}
+	err = tw.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     name,
+		Size:     size,
+		Mode:     0o644,
+	})
+	if err != nil {
+		return 0, errors.Trace(err)
+	}
+	if _, err = io.CopyN(tw, r, size); err != nil {
+		return 0, errors.Trace(err)
+	}
+	return size, nil
+}
+
+// ExtractArchive writes the files of the tar archive read from r to the
+// storage, and returns their names in the order of the archive.
+func ExtractArchive(ctx context.Context, r io.Reader, s ExternalStorage) ([]string, error) {
+	start := time.Now()
+	tr := tar.NewReader(r)
+	var (
+		names []string
+		total int64
+	)
+	for {
+		if err := ctx.Err(); err != nil {
+			return nil, errors.Trace(err)
+		}
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+		name := path.Clean(hdr.Name)
+		if path.IsAbs(name) || name == ".." || strings.HasPrefix(name, "../") {
+			return nil, errors.Annotatef(berrors.ErrInvalidArgument,
+				"file %s in the archive is outside of the storage", hdr.Name)
+		}
+		if err = extractArchiveFile(ctx, tr, s, name); err != nil {
+			return nil, errors.Annotatef(err, "extract file %s", name)
+		}
+		names = append(names, name)
+		total += hdr.Size
+	}
+	log.Info("backup archive extracted",
+		zap.String("storage", s.URI()),
+		zap.Int("files", len(names)),
+		zap.Int64("size", total),
+		zap.Duration("take", time.Since(start)))
+	return names, nil
+}
+
+func extractArchiveFile(ctx context.Context, r io.Reader, s ExternalStorage, name string) error {
+	w, err := s.Create(ctx, name)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	return errors.Trace(copyAndClose(ctx, r, w))
+}
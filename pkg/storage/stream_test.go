@@ -0,0 +1,62 @@
+// Copyright 2021 PingCAP, Inc. Licensed under Apache-2.0.
+
+package storage
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+
+	. "github.com/pingcap/check"
+)
+
+func (r *testStorageSuite) TestArchive(c *C) {
+	ctx := context.Background()
+	src, err := NewLocalStorage(c.MkDir())
+	c.Assert(err, IsNil)
+	files := map[string]string{"1.sst": "111", "sub/2.sst": "22", "backupmeta": "meta"}
+	for name, content := range files {
+		c.Assert(src.WriteFile(ctx, name, []byte(content)), IsNil)
+	}
+	order := []string{"1.sst", "sub/2.sst", "backupmeta"}
+
+	// the archive is streamed through a pipe file.
+	pipe := filepath.Join(c.MkDir(), "fifo")
+	c.Assert(os.WriteFile(pipe, nil, 0o644), IsNil)
+	w, err := OpenStreamWriter("pipe://" + pipe)
+	c.Assert(err, IsNil)
+	c.Assert(WriteArchive(ctx, src, order, w), IsNil)
+	c.Assert(w.Close(), IsNil)
+
+	dst, err := NewLocalStorage(c.MkDir())
+	c.Assert(err, IsNil)
+	rd, err := OpenStreamReader("pipe://" + pipe)
+	c.Assert(err, IsNil)
+	names, err := ExtractArchive(ctx, rd, dst)
+	c.Assert(err, IsNil)
+	c.Assert(rd.Close(), IsNil)
+	c.Assert(names, DeepEquals, order)
+	for name, content := range files {
+		data, err := dst.ReadFile(ctx, name)
+		c.Assert(err, IsNil)
+		c.Assert(string(data), Equals, content)
+	}
+
+	// the files outside of the storage are rejected.
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	c.Assert(tw.WriteHeader(&tar.Header{Typeflag: tar.TypeReg, Name: "../evil", Size: 1, Mode: 0o644}), IsNil)
+	_, err = tw.Write([]byte("x"))
+	c.Assert(err, IsNil)
+	c.Assert(tw.Close(), IsNil)
+	_, err = ExtractArchive(ctx, &buf, dst)
+	c.Assert(err, ErrorMatches, ".*outside of the storage.*")
+
+	c.Assert(IsStreamURL("stdout://"), IsTrue)
+	c.Assert(IsStreamURL("pipe:///tmp/fifo"), IsTrue)
+	c.Assert(IsStreamURL("local:///tmp/backup"), IsFalse)
+	_, err = OpenStreamWriter("stdin://")
+	c.Assert(err, ErrorMatches, ".*not writable stream.*")
+}
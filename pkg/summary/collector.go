@@ -187,8 +187,10 @@ func (tc *logCollector) Summary(name string) {
 	for key, val := range tc.uints {
 		logFields = append(logFields, zap.Uint64(logKeyFor(key), val))
 	}
+	logFields = append(logFields, tableStatFields()...)
 
 	if len(tc.failureReasons) != 0 || !tc.successStatus {
+		record(name, false, logFields)
 		for unitName, reason := range tc.failureReasons {
 			logFields = append(logFields, zap.String("unit-name", unitName), zap.Error(reason))
 		}
@@ -226,6 +228,7 @@ func (tc *logCollector) Summary(name string) {
 		logFields = append(logFields, zap.Uint64(logKeyFor(name), data))
 	}
 
+	record(name, true, logFields)
 	tc.log(name+" success summary", logFields...)
 }
 
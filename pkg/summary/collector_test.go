@@ -3,10 +3,12 @@
 package summary
 
 import (
+	"context"
 	"testing"
 	"time"
 
 	. "github.com/pingcap/check"
+	"github.com/pingcap/errors"
 	"go.uber.org/zap"
 )
 
@@ -47,3 +49,91 @@ func (suit *testCollectorSuite) TestSumDurationInt(c *C) {
 	assertContains(zap.Duration("b", 2*time.Second))
 	assertContains(zap.Int("c", 4))
 }
+
+func (suit *testCollectorSuite) TestRecord(c *C) {
+	col := NewLogCollector(func(string, ...zap.Field) {})
+	col.CollectDuration("restore pipeline", time.Second)
+	col.CollectInt("tables", 2)
+	col.CollectFailureUnit("range", errors.New("injected"))
+	col.Summary("foo")
+
+	record := LastRecord()
+	c.Assert(record.Name, Equals, "foo")
+	c.Assert(record.Success, IsFalse)
+	c.Assert(record.Stats, DeepEquals, map[string]interface{}{
+		"total-ranges":     int64(1),
+		"ranges-succeed":   int64(0),
+		"ranges-failed":    int64(1),
+		"restore-pipeline": "1s",
+		"tables":           int64(2),
+	})
+
+	CollectArtifact("log", "/tmp/br.log")
+	c.Assert(Artifacts(), DeepEquals, []Artifact{{Kind: "log", Path: "/tmp/br.log"}})
+}
+
+func (suit *testCollectorSuite) TestTableStats(c *C) {
+	stats := []TableStat{
+		{DB: "db", Table: "a", Size: 300, Duration: time.Second},
+		{DB: "db", Table: "b", Size: 100, Duration: 3 * time.Second},
+		{DB: "db", Table: "c", Size: 200, Duration: 2 * time.Second},
+	}
+	largest := LargestTables(stats, 2)
+	c.Assert(largest, HasLen, 2)
+	c.Assert(largest[0].Table, Equals, "a")
+	c.Assert(largest[1].Table, Equals, "c")
+	slowest := SlowestTables(stats, 5)
+	c.Assert(slowest, HasLen, 3)
+	c.Assert(slowest[0].Table, Equals, "b")
+	c.Assert(stats[0].Table, Equals, "a")
+
+	var fields []zap.Field
+	col := NewLogCollector(func(_ string, fs ...zap.Field) {
+		fields = fs
+	})
+	CollectTableStats(stats)
+	col.SetSuccessStatus(true)
+	col.Summary("foo")
+	found := 0
+	for _, f := range fields {
+		if f.Key == "largest-tables" || f.Key == "slowest-tables" {
+			found++
+		}
+	}
+	c.Assert(found, Equals, 2)
+	c.Assert(LastRecord().Stats["largest-tables"], DeepEquals, []interface{}{
+		"db.a: 300B, 0 kvs, 1s", "db.c: 200B, 0 kvs, 2s", "db.b: 100B, 0 kvs, 3s",
+	})
+
+	// the stats are cleared after the summary.
+	col.Summary("bar")
+	_, ok := LastRecord().Stats["largest-tables"]
+	c.Assert(ok, IsFalse)
+}
+
+func (suit *testCollectorSuite) TestJobCollector(c *C) {
+	origin := collector
+	defer SetLogCollector(origin)
+	summaries := make(map[string][]zap.Field)
+	SetLogCollector(NewLogCollector(func(msg string, fs ...zap.Field) {
+		summaries[msg] = fs
+	}))
+
+	job1 := WithJobCollector(context.Background())
+	job2 := WithJobCollector(context.Background())
+	FromContext(job1).CollectInt("tables", 1)
+	FromContext(job2).CollectInt("tables", 2)
+	FromContext(job2).SetSuccessStatus(true)
+	c.Assert(FromContext(context.Background()), Equals, collector)
+
+	// the jobs running in parallel don't see the summary of each other.
+	FromContext(job1).Summary("job1")
+	FromContext(job2).Summary("job2")
+	c.Assert(summaries["job1 failed summary"][3], DeepEquals, zap.Int("tables", 1))
+	c.Assert(summaries["job2 success summary"][3], DeepEquals, zap.Int("tables", 2))
+
+	// the collector set from outside is shared by the jobs.
+	shared := &logCollector{}
+	SetLogCollector(shared)
+	c.Assert(FromContext(WithJobCollector(context.Background())), Equals, shared)
+}
@@ -0,0 +1,28 @@
+// Copyright 2021 PingCAP, Inc. Licensed under Apache-2.0.
+
+package summary
+
+import "context"
+
+type collectorKey struct{}
+
+// WithJobCollector returns a context whose summary is collected apart from the
+// other jobs of the process, e.g. the restores running in parallel in the
+// server mode. The summary is output with the same logger as the global
+// collector, a collector set by SetLogCollector is shared by all jobs.
+func WithJobCollector(ctx context.Context) context.Context {
+	global, ok := collector.(*logCollector)
+	if !ok {
+		return ctx
+	}
+	return context.WithValue(ctx, collectorKey{}, NewLogCollector(global.log))
+}
+
+// FromContext returns the collector of the job of the context, or the global
+// collector if the job has none.
+func FromContext(ctx context.Context) LogCollector {
+	if c, ok := ctx.Value(collectorKey{}).(LogCollector); ok {
+		return c
+	}
+	return collector
+}
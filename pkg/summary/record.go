@@ -0,0 +1,68 @@
+// Copyright 2021 PingCAP, Inc. Licensed under Apache-2.0.
+
+package summary
+
+import (
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// Record is the machine-readable form of the last summary.
+type Record struct {
+	Name    string                 `json:"name"`
+	Success bool                   `json:"success"`
+	Stats   map[string]interface{} `json:"stats"`
+}
+
+// Artifact is a file produced by the task, e.g. the log file or the backup.
+type Artifact struct {
+	Kind string `json:"kind"`
+	Path string `json:"path"`
+}
+
+var (
+	recordMu   sync.Mutex
+	lastRecord *Record
+	artifacts  []Artifact
+)
+
+// LastRecord returns the record of the last summary, or nil if no summary is
+// output yet.
+func LastRecord() *Record {
+	recordMu.Lock()
+	defer recordMu.Unlock()
+	return lastRecord
+}
+
+// CollectArtifact collects the path of a file produced by the task.
+func CollectArtifact(kind, path string) {
+	recordMu.Lock()
+	defer recordMu.Unlock()
+	artifacts = append(artifacts, Artifact{Kind: kind, Path: path})
+}
+
+// Artifacts returns the collected artifacts in order.
+func Artifacts() []Artifact {
+	recordMu.Lock()
+	defer recordMu.Unlock()
+	return append([]Artifact(nil), artifacts...)
+}
+
+// record saves the summary fields as the last record.
+func record(name string, success bool, fields []zap.Field) {
+	enc := zapcore.NewMapObjectEncoder()
+	for _, f := range fields {
+		f.AddTo(enc)
+	}
+	for key, val := range enc.Fields {
+		if d, ok := val.(time.Duration); ok {
+			enc.Fields[key] = d.String()
+		}
+	}
+	recordMu.Lock()
+	defer recordMu.Unlock()
+	lastRecord = &Record{Name: name, Success: success, Stats: enc.Fields}
+}
@@ -0,0 +1,95 @@
+// Copyright 2021 PingCAP, Inc. Licensed under Apache-2.0.
+
+package summary
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/docker/go-units"
+	"go.uber.org/zap"
+)
+
+// TopTables is the number of the largest and the slowest tables output in the
+// summary.
+const TopTables = 5
+
+// TableStat is the size and the duration of backing up a table.
+type TableStat struct {
+	DB    string `json:"db"`
+	Table string `json:"table"`
+	KVs   uint64 `json:"kvs"`
+	// Size is the size of the backup files of the table.
+	Size uint64 `json:"size"`
+	// Duration is the total time of backing up the ranges of the table, which
+	// overlaps with the other tables backed up concurrently.
+	Duration time.Duration `json:"duration"`
+}
+
+func (s *TableStat) String() string {
+	return fmt.Sprintf("%s.%s: %s, %d kvs, %s",
+		s.DB, s.Table, units.HumanSize(float64(s.Size)), s.KVs, s.Duration.Round(time.Millisecond))
+}
+
+var (
+	tableStatsMu sync.Mutex
+	tableStats   []TableStat
+)
+
+// CollectTableStats collects the stats of the tables, the largest and the
+// slowest ones are output in the summary.
+func CollectTableStats(stats []TableStat) {
+	tableStatsMu.Lock()
+	defer tableStatsMu.Unlock()
+	tableStats = append(tableStats, stats...)
+}
+
+// LargestTables returns the n largest tables.
+func LargestTables(stats []TableStat, n int) []TableStat {
+	return topTables(stats, n, func(a, b *TableStat) bool {
+		return a.Size > b.Size
+	})
+}
+
+// SlowestTables returns the n tables taking the longest time.
+func SlowestTables(stats []TableStat, n int) []TableStat {
+	return topTables(stats, n, func(a, b *TableStat) bool {
+		return a.Duration > b.Duration
+	})
+}
+
+func topTables(stats []TableStat, n int, before func(a, b *TableStat) bool) []TableStat {
+	sorted := append([]TableStat(nil), stats...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return before(&sorted[i], &sorted[j])
+	})
+	if len(sorted) > n {
+		sorted = sorted[:n]
+	}
+	return sorted
+}
+
+// tableStatFields returns the fields of the largest and the slowest tables
+// collected, and clears them for the next summary.
+func tableStatFields() []zap.Field {
+	tableStatsMu.Lock()
+	stats := tableStats
+	tableStats = nil
+	tableStatsMu.Unlock()
+	if len(stats) == 0 {
+		return nil
+	}
+	format := func(stats []TableStat) []string {
+		lines := make([]string, 0, len(stats))
+		for i := range stats {
+			lines = append(lines, stats[i].String())
+		}
+		return lines
+	}
+	return []zap.Field{
+		zap.Strings("largest-tables", format(LargestTables(stats, TopTables))),
+		zap.Strings("slowest-tables", format(SlowestTables(stats, TopTables))),
+	}
+}
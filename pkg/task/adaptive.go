@@ -0,0 +1,105 @@
+// Copyright 2021 PingCAP, Inc. Licensed under Apache-2.0.
+
+package task
+
+import (
+	"context"
+	"time"
+
+	"github.com/pingcap/errors"
+	"github.com/spf13/pflag"
+
+	"github.com/pingcap/br/pkg/backup"
+	"github.com/pingcap/br/pkg/conn"
+	berrors "github.com/pingcap/br/pkg/errors"
+)
+
+const (
+	flagAdaptiveConcurrency    = "adaptive-concurrency"
+	flagAdaptiveMaxConcurrency = "adaptive-max-concurrency"
+	flagAdaptiveMaxCPUUsage    = "adaptive-max-cpu-usage"
+	flagAdaptiveMaxIOLatency   = "adaptive-max-io-latency"
+
+	defaultAdaptiveMaxCPUUsage  = 0.7
+	defaultAdaptiveMaxIOLatency = 50 * time.Millisecond
+	// adaptiveConcurrencyScale is the ratio of the default max concurrency to
+	// the initial one.
+	adaptiveConcurrencyScale = 4
+)
+
+// AdaptiveConfig is the configuration of adjusting the backup concurrency and
+// rate limit by the load of TiKV.
+type AdaptiveConfig struct {
+	AdaptiveConcurrency bool `json:"adaptive-concurrency" toml:"adaptive-concurrency"`
+	// AdaptiveMaxConcurrency is the highest concurrency of the backup ranges,
+	// 0 means 4 times --concurrency.
+	AdaptiveMaxConcurrency uint          `json:"adaptive-max-concurrency" toml:"adaptive-max-concurrency"`
+	AdaptiveMaxCPUUsage    float64       `json:"adaptive-max-cpu-usage" toml:"adaptive-max-cpu-usage"`
+	AdaptiveMaxIOLatency   time.Duration `json:"adaptive-max-io-latency" toml:"adaptive-max-io-latency"`
+}
+
+func defineAdaptiveFlags(flags *pflag.FlagSet) {
+	flags.Bool(flagAdaptiveConcurrency, false,
+		"(experimental) adjust the concurrency of the backup and the rate limit of each TiKV by the CPU usage, "+
+			"the IO latency of TiKV and the backup throughput, --concurrency and --ratelimit become the initial "+
+			"concurrency and the highest rate limit")
+	flags.Uint(flagAdaptiveMaxConcurrency, 0,
+		"the highest concurrency of --adaptive-concurrency, 0 means 4 times the initial concurrency")
+	flags.Float64(flagAdaptiveMaxCPUUsage, defaultAdaptiveMaxCPUUsage,
+		"the CPU usage of TiKV, in the range of (0, 1], above which --adaptive-concurrency slows down the backup")
+	flags.Duration(flagAdaptiveMaxIOLatency, defaultAdaptiveMaxIOLatency,
+		"the average duration of appending the raft logs of TiKV above which --adaptive-concurrency slows down "+
+			"the backup, 0 means ignoring the IO latency")
+}
+
+func (cfg *AdaptiveConfig) parseFromFlags(flags *pflag.FlagSet) error {
+	var err error
+	if cfg.AdaptiveConcurrency, err = flags.GetBool(flagAdaptiveConcurrency); err != nil {
+		return errors.Trace(err)
+	}
+	if cfg.AdaptiveMaxConcurrency, err = flags.GetUint(flagAdaptiveMaxConcurrency); err != nil {
+		return errors.Trace(err)
+	}
+	if cfg.AdaptiveMaxCPUUsage, err = flags.GetFloat64(flagAdaptiveMaxCPUUsage); err != nil {
+		return errors.Trace(err)
+	}
+	if cfg.AdaptiveMaxIOLatency, err = flags.GetDuration(flagAdaptiveMaxIOLatency); err != nil {
+		return errors.Trace(err)
+	}
+	if cfg.AdaptiveMaxCPUUsage <= 0 || cfg.AdaptiveMaxCPUUsage > 1 {
+		return errors.Annotatef(berrors.ErrInvalidArgument,
+			"--%s should be in the range of (0, 1], got %v", flagAdaptiveMaxCPUUsage, cfg.AdaptiveMaxCPUUsage)
+	}
+	return nil
+}
+
+// startAdaptiveController adjusts the backup of the client by the load of the
+// TiKV stores until the context is done.
+func (cfg *AdaptiveConfig) startAdaptiveController(
+	ctx context.Context,
+	client *backup.Client,
+	mgr *conn.Mgr,
+	concurrency uint,
+	rateLimit uint64,
+) error {
+	stores, err := conn.GetAllTiKVStores(ctx, mgr.GetPDClient(), conn.SkipTiFlash)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	maxConcurrency := cfg.AdaptiveMaxConcurrency
+	if maxConcurrency == 0 {
+		maxConcurrency = concurrency * adaptiveConcurrencyScale
+	}
+	if maxConcurrency > maxBackupConcurrency {
+		maxConcurrency = maxBackupConcurrency
+	}
+	controller := backup.NewAdaptiveController(mgr.GetTLSConfig(), stores, concurrency, backup.AdaptiveConfig{
+		MaxConcurrency: maxConcurrency,
+		MaxRateLimit:   rateLimit,
+		MaxCPUUsage:    cfg.AdaptiveMaxCPUUsage,
+		MaxIOLatency:   cfg.AdaptiveMaxIOLatency,
+	})
+	client.SetAdaptiveController(controller)
+	go controller.Run(ctx)
+	return nil
+}
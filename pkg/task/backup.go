@@ -4,13 +4,16 @@ package task
 
 import (
 	"context"
+	"crypto/tls"
 	"fmt"
+	"io"
 	"os"
 	"strconv"
 	"strings"
 	"time"
 
 	"github.com/docker/go-units"
+	"github.com/google/uuid"
 	"github.com/opentracing/opentracing-go"
 	"github.com/pingcap/errors"
 	"github.com/pingcap/failpoint"
@@ -22,6 +25,7 @@ import (
 	"github.com/pingcap/tidb/types"
 	"github.com/spf13/pflag"
 	"github.com/tikv/client-go/v2/oracle"
+	"github.com/tikv/pd/server/schedule/placement"
 	"go.uber.org/zap"
 
 	"github.com/pingcap/br/pkg/backup"
@@ -30,6 +34,8 @@ import (
 	"github.com/pingcap/br/pkg/glue"
 	"github.com/pingcap/br/pkg/logutil"
 	"github.com/pingcap/br/pkg/metautil"
+	"github.com/pingcap/br/pkg/pdutil"
+	"github.com/pingcap/br/pkg/rtree"
 	"github.com/pingcap/br/pkg/storage"
 	"github.com/pingcap/br/pkg/summary"
 	"github.com/pingcap/br/pkg/utils"
@@ -44,9 +50,28 @@ const (
 	flagRemoveSchedulers = "remove-schedulers"
 	flagIgnoreStats      = "ignore-stats"
 	flagUseBackupMetaV2  = "use-backupmeta-v2"
+	flagChecksumManifest = "checksum-manifest"
+	flagVerifyFiles      = "verify-files"
+	flagPerDBMeta        = "per-db-meta"
+	flagRegionTopology   = "record-region-topology"
+	flagSchemaOnly       = "schema-only"
+	flagReuseBaseSchema  = "reuse-base-schema"
+	flagDedupBaseFiles   = "dedup-base-files"
+	flagSkipIndexes      = "skip-indexes"
+	flagSplitRangeSize   = "split-range-size"
+	flagVerifyQueries    = "verify-queries"
+	flagRateLimitWindows = "ratelimit-schedule"
+	flagPathTemplate     = "path-template"
+
+	flagMirrorStorage       = "mirror-storage"
+	flagMirrorFailurePolicy = "mirror-failure-policy"
 
 	flagGCTTL = "gcttl"
 
+	// lastBackupTSAuto is the value of --lastbackupts to find the last backup
+	// ts in the backup index.
+	lastBackupTSAuto = "auto"
+
 	defaultBackupConcurrency = 4
 	maxBackupConcurrency     = 256
 )
@@ -57,6 +82,52 @@ type CompressionConfig struct {
 	CompressionLevel int32                    `json:"compression-level" toml:"compression-level"`
 }
 
+// MirrorConfig is the configuration of the storage mirroring the backup.
+type MirrorConfig struct {
+	MirrorStorage       string               `json:"mirror-storage" toml:"mirror-storage"`
+	MirrorFailurePolicy storage.MirrorPolicy `json:"mirror-failure-policy" toml:"mirror-failure-policy"`
+}
+
+func defineMirrorFlags(flags *pflag.FlagSet) {
+	flags.String(flagMirrorStorage, "",
+		"the URL of another storage to which every backup file is written as well, e.g. 'local:///mnt/nfs/backup'")
+	flags.String(flagMirrorFailurePolicy, string(storage.MirrorPolicyFail),
+		"the action on a failed write to the mirror storage, 'fail' fails the backup, "+
+			"'warn' logs a warning and leaves the mirror incomplete without the backupmeta")
+}
+
+func (cfg *MirrorConfig) parseFromFlags(flags *pflag.FlagSet) error {
+	var err error
+	cfg.MirrorStorage, err = flags.GetString(flagMirrorStorage)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	policy, err := flags.GetString(flagMirrorFailurePolicy)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	cfg.MirrorFailurePolicy = storage.MirrorPolicy(policy)
+	return errors.Trace(cfg.MirrorFailurePolicy.Validate())
+}
+
+// setMirrorStorage mirrors the backup of the client to the mirror storage if
+// it's configured.
+func (cfg *MirrorConfig) setMirrorStorage(
+	ctx context.Context,
+	client *backup.Client,
+	backendOpts *storage.BackendOptions,
+	opts *storage.ExternalStorageOptions,
+) error {
+	if cfg.MirrorStorage == "" {
+		return nil
+	}
+	u, err := storage.ParseBackend(cfg.MirrorStorage, backendOpts)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	return errors.Trace(client.SetMirrorStorage(ctx, u, opts, cfg.MirrorFailurePolicy))
+}
+
 // BackupConfig is the configuration specific for backup tasks.
 type BackupConfig struct {
 	Config
@@ -64,11 +135,50 @@ type BackupConfig struct {
 	TimeAgo          time.Duration `json:"time-ago" toml:"time-ago"`
 	BackupTS         uint64        `json:"backup-ts" toml:"backup-ts"`
 	LastBackupTS     uint64        `json:"last-backup-ts" toml:"last-backup-ts"`
+	LastBackupTSAuto bool          `json:"last-backup-ts-auto" toml:"last-backup-ts-auto"`
 	GCTTL            int64         `json:"gc-ttl" toml:"gc-ttl"`
 	RemoveSchedulers bool          `json:"remove-schedulers" toml:"remove-schedulers"`
 	IgnoreStats      bool          `json:"ignore-stats" toml:"ignore-stats"`
 	UseBackupMetaV2  bool          `json:"use-backupmeta-v2"`
+	ChecksumManifest bool          `json:"checksum-manifest" toml:"checksum-manifest"`
+	VerifyFiles      float64       `json:"verify-files" toml:"verify-files"`
+	PerDBMeta        bool          `json:"per-db-meta" toml:"per-db-meta"`
+	RegionTopology   bool          `json:"record-region-topology" toml:"record-region-topology"`
+	SchemaOnly       bool          `json:"schema-only" toml:"schema-only"`
+	ReuseBaseSchema  bool          `json:"reuse-base-schema" toml:"reuse-base-schema"`
+	// DedupBaseFiles is whether to remove the data files of the incremental
+	// backup whose contents are in the backups of its chain, and reference
+	// them instead.
+	DedupBaseFiles bool `json:"dedup-base-files" toml:"dedup-base-files"`
+	// SkipIndexes is whether to back up the row data only, the indexes which
+	// can be rebuilt are added by ADD INDEX after restore.
+	SkipIndexes bool `json:"skip-indexes" toml:"skip-indexes"`
+	// SplitRangeSize is the estimated size above which a range is split by
+	// the regions before backing up, 0 means the ranges aren't split.
+	SplitRangeSize uint64 `json:"split-range-size" toml:"split-range-size"`
+	// IncludeSystemTables is whether to back up the users, the privileges and
+	// the global variables in the `mysql` schema.
+	IncludeSystemTables bool `json:"include-system-tables" toml:"include-system-tables"`
+	// VerifyQueries is the path of the SQL file of the queries, whose results
+	// at the backup ts are recorded to verify the restored data.
+	VerifyQueries string `json:"verify-queries" toml:"verify-queries"`
+	// RateLimitWindows are the daily time windows with their own rate limits,
+	// the rate limit out of them is RateLimit.
+	RateLimitWindows []backup.RateLimitWindow `json:"ratelimit-schedule" toml:"ratelimit-schedule"`
+	// PathTemplate lays out the backup in a sub directory of the storage,
+	// which is rendered from the template when the backup starts.
+	PathTemplate metautil.PathTemplate `json:"path-template" toml:"path-template"`
+	// ProgressInterval is the interval to write the snapshots of the progress
+	// to the storage, 0 means no snapshot.
+	ProgressInterval time.Duration `json:"progress-interval" toml:"progress-interval"`
+	// TaskLockTTL is the time the lock of the storage is held for after the
+	// last heartbeat of the task, 0 means the storage isn't locked.
+	TaskLockTTL time.Duration `json:"task-lock-ttl" toml:"task-lock-ttl"`
 	CompressionConfig
+	MirrorConfig
+	StreamConfig
+	AdaptiveConfig
+	RangeRetryConfig
 }
 
 // DefineBackupFlags defines common flags for the backup command.
@@ -78,14 +188,17 @@ func DefineBackupFlags(flags *pflag.FlagSet) {
 		"The history version of the backup task, e.g. 1m, 1h. Do not exceed GCSafePoint")
 
 	// TODO: remove experimental tag if it's stable
-	flags.Uint64(flagLastBackupTS, 0, "(experimental) the last time backup ts,"+
-		" use for incremental backup, support TSO only")
-	flags.String(flagBackupTS, "", "the backup ts support TSO or datetime,"+
-		" e.g. '400036290571534337', '2018-05-11 01:42:23'")
+	flags.String(flagLastBackupTS, "", "(experimental) the last time backup ts,"+
+		" use for incremental backup, support TSO only. 'auto' takes the end version of the last backup"+
+		" recorded in the backup index of the parent directory of the storage, or does a full backup if none")
+	flags.String(flagBackupTS, "", "the backup ts support TSO, datetime in the local time zone or with the offset,"+
+		" or the duration before the current TSO of PD like --timeago,"+
+		" e.g. '400036290571534337', '2018-05-11 01:42:23', '2018-05-11 01:42:23+08:00', '-2h'")
 	flags.Int64(flagGCTTL, utils.DefaultBRGCSafePointTTL, "the TTL (in seconds) that PD holds for BR's GC safepoint")
 	flags.String(flagCompressionType, "zstd",
 		"backup sst file compression algorithm, value can be one of 'lz4|zstd|snappy'")
-	flags.Int32(flagCompressionLevel, 0, "compression level used for sst file compression")
+	flags.Int32(flagCompressionLevel, 0, "compression level used for sst file compression, "+
+		"0 means the default level of TiKV. 1~22 for zstd, 1~12 for lz4, snappy has no level")
 
 	flags.Bool(flagRemoveSchedulers, false,
 		"disable the balance, shuffle and region-merge schedulers in PD to speed up backup")
@@ -110,6 +223,63 @@ func DefineBackupFlags(flags *pflag.FlagSet) {
 	// but will generate v1 meta due to this flag is false. the behaviour is as same as v4.0.15, v4.0.16.
 	// finally v4.0.17 will set this flag to true, and generate v2 meta.
 	_ = flags.MarkHidden(flagUseBackupMetaV2)
+
+	flags.Bool(flagChecksumManifest, true,
+		"write a manifest of the sha256 digests of all backup files, which can be verified by `br validate data`")
+	defineVerifyFilesFlag(flags)
+
+	flags.Bool(flagPerDBMeta, false,
+		"store the files of each database in a sub directory with a standalone backupmeta besides the global one, "+
+			"so a single database can be copied or restored by syncing only its sub directory")
+
+	flags.Bool(flagRegionTopology, true,
+		"record the region boundaries of the backed up ranges, so restore can pre-split the target cluster to a similar topology")
+	flags.Bool(flagSchemaOnly, false,
+		"only back up the schemas, the DDL jobs of incremental backup and the statistics, without the data of the tables")
+	flags.Bool(flagReuseBaseSchema, false,
+		"(experimental) only back up the schemas of the tables changed since the last backup in incremental backup, "+
+			"the others are referenced from the last backup found in the backup index of the parent directory")
+	flags.Bool(flagDedupBaseFiles, false,
+		"(experimental) reference the data files of the backups of the incremental chain found in the backup index "+
+			"of the parent directory instead of keeping the data files of the same contents in incremental backup, "+
+			"the referenced backups must be kept for restore, and are copied into the backup by `br copy`")
+	flags.Bool(flagSkipIndexes, false,
+		"only back up the row data of the tables without the data of their indexes, "+
+			"the indexes are rebuilt by ADD INDEX after the data is restored, "+
+			"the primary keys and the expression indexes are still backed up")
+	flags.String(flagSplitRangeSize, "",
+		"split the range of a table whose estimated size from the region stats exceeds it, e.g. '64GiB', "+
+			"into several requests by the regions, so a huge table is backed up by the stores in parallel, "+
+			"empty means the ranges aren't split")
+	flags.Bool(flagIncludeSystemTables, false,
+		"back up the users, the privileges and the global variables in the `mysql` schema besides the tables "+
+			"selected by --filter, which can be restored by restore with --include-system-tables")
+	flags.String(flagVerifyQueries, "",
+		"the path of a SQL file of the SELECT queries separated by semicolons, their results at the backup ts "+
+			"are recorded with the backup, and compared with the restored data by restore with --run-verify-queries")
+
+	flags.String(flagRateLimitWindows, "",
+		"the daily time windows of the local time with their own --ratelimit, in the form of HH:MM-HH:MM=rate "+
+			"separated by commas, e.g. '00:00-06:00=0,18:00-20:00=100', 0 means unlimited, --ratelimit takes effect "+
+			"out of the windows, the rate limit switches when the next ranges are sent")
+	flags.String(flagPathTemplate, "",
+		"write the backup to the sub directory of the storage rendered from the template, e.g. "+
+			"'{cluster_id}/{date}/{backup_id}', the placeholders are {cluster_id}, {date} (YYYYMMDD in UTC), "+
+			"{time} (HHMMSS in UTC) and {backup_id} (a random UUID), the backups are indexed in the storage "+
+			"so --lastbackupts=auto and br gc find them")
+	flags.Duration(flagProgressInterval, defaultProgressInterval,
+		"the interval to write the snapshots of the progress to the storage, which are read by `br backup status`, "+
+			"0 disables the snapshots")
+	flags.Duration(flagTaskLockTTL, defaultTaskLockTTL, fmt.Sprintf(
+		"lock the storage during the backup so the concurrent backups to it fail, the lock of a killed task "+
+			"expires after the TTL since its last heartbeat, and the backup losing its lock is aborted. "+
+			"Every backup waits %s after writing the lock to confirm it's held, 0 disables the lock",
+		taskLockSettleDelay))
+
+	defineMirrorFlags(flags)
+	defineStreamFlags(flags)
+	defineAdaptiveFlags(flags)
+	defineRangeRetryFlags(flags)
 }
 
 // ParseFromFlags parses the backup-related flags from the flag set.
@@ -122,18 +292,35 @@ func (cfg *BackupConfig) ParseFromFlags(flags *pflag.FlagSet) error {
 		return errors.Annotate(berrors.ErrInvalidArgument, "negative timeago is not allowed")
 	}
 	cfg.TimeAgo = timeAgo
-	cfg.LastBackupTS, err = flags.GetUint64(flagLastBackupTS)
+	lastBackupTS, err := flags.GetString(flagLastBackupTS)
 	if err != nil {
 		return errors.Trace(err)
 	}
+	if lastBackupTS == lastBackupTSAuto {
+		cfg.LastBackupTSAuto = true
+	} else if lastBackupTS != "" {
+		cfg.LastBackupTS, err = strconv.ParseUint(lastBackupTS, 10, 64)
+		if err != nil {
+			return errors.Annotatef(berrors.ErrInvalidArgument,
+				"invalid --%s %q, it should be a TSO or '%s'", flagLastBackupTS, lastBackupTS, lastBackupTSAuto)
+		}
+	}
 	backupTS, err := flags.GetString(flagBackupTS)
 	if err != nil {
 		return errors.Trace(err)
 	}
-	cfg.BackupTS, err = parseTSString(backupTS)
+	var backupAgo time.Duration
+	cfg.BackupTS, backupAgo, err = parseBackupTS(backupTS)
 	if err != nil {
 		return errors.Trace(err)
 	}
+	if backupAgo > 0 {
+		if cfg.TimeAgo > 0 {
+			return errors.Annotatef(berrors.ErrInvalidArgument,
+				"--%s %s conflicts with --%s", flagBackupTS, backupTS, flagBackupTimeago)
+		}
+		cfg.TimeAgo = backupAgo
+	}
 	gcTTL, err := flags.GetInt64(flagGCTTL)
 	if err != nil {
 		return errors.Trace(err)
@@ -158,7 +345,154 @@ func (cfg *BackupConfig) ParseFromFlags(flags *pflag.FlagSet) error {
 		return errors.Trace(err)
 	}
 	cfg.UseBackupMetaV2, err = flags.GetBool(flagUseBackupMetaV2)
-	return errors.Trace(err)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	cfg.ChecksumManifest, err = flags.GetBool(flagChecksumManifest)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	cfg.VerifyFiles, err = parseVerifyFilesFlag(flags)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	cfg.PerDBMeta, err = flags.GetBool(flagPerDBMeta)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	cfg.RegionTopology, err = flags.GetBool(flagRegionTopology)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	cfg.SchemaOnly, err = flags.GetBool(flagSchemaOnly)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	cfg.ReuseBaseSchema, err = flags.GetBool(flagReuseBaseSchema)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	cfg.DedupBaseFiles, err = flags.GetBool(flagDedupBaseFiles)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	cfg.SkipIndexes, err = flags.GetBool(flagSkipIndexes)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	splitRangeSize, err := flags.GetString(flagSplitRangeSize)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if splitRangeSize != "" {
+		size, err := units.RAMInBytes(splitRangeSize)
+		if err != nil || size <= 0 {
+			return errors.Annotatef(berrors.ErrInvalidArgument, "invalid --%s %q", flagSplitRangeSize, splitRangeSize)
+		}
+		cfg.SplitRangeSize = uint64(size)
+	}
+	cfg.VerifyQueries, err = flags.GetString(flagVerifyQueries)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	cfg.IncludeSystemTables, err = flags.GetBool(flagIncludeSystemTables)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if cfg.IncludeSystemTables {
+		if err = cfg.Config.includeSystemTables(flags); err != nil {
+			return errors.Trace(err)
+		}
+	}
+	if cfg.ReuseBaseSchema && cfg.LastBackupTS == 0 && !cfg.LastBackupTSAuto {
+		return errors.Annotatef(berrors.ErrInvalidArgument,
+			"--%s is only supported by incremental backup", flagReuseBaseSchema)
+	}
+	if cfg.ReuseBaseSchema && cfg.SchemaOnly {
+		return errors.Annotatef(berrors.ErrInvalidArgument,
+			"--%s is not supported with --%s", flagReuseBaseSchema, flagSchemaOnly)
+	}
+	if cfg.DedupBaseFiles && cfg.LastBackupTS == 0 && !cfg.LastBackupTSAuto {
+		return errors.Annotatef(berrors.ErrInvalidArgument,
+			"--%s is only supported by incremental backup", flagDedupBaseFiles)
+	}
+	// the rows of incremental backup are restored to the tables with the
+	// indexes, whose data must be restored as well.
+	if cfg.SkipIndexes && (cfg.LastBackupTS > 0 || cfg.LastBackupTSAuto) {
+		return errors.Annotatef(berrors.ErrInvalidArgument,
+			"--%s is not supported by incremental backup", flagSkipIndexes)
+	}
+	if cfg.SkipIndexes && cfg.PerDBMeta {
+		return errors.Annotatef(berrors.ErrInvalidArgument,
+			"--%s is not supported with --%s", flagSkipIndexes, flagPerDBMeta)
+	}
+	if cfg.PerDBMeta && (cfg.LastBackupTS > 0 || cfg.LastBackupTSAuto) {
+		return errors.Annotatef(berrors.ErrInvalidArgument,
+			"--%s is not supported by incremental backup", flagPerDBMeta)
+	}
+	if err = cfg.MirrorConfig.parseFromFlags(flags); err != nil {
+		return errors.Trace(err)
+	}
+	if cfg.PerDBMeta && cfg.MirrorStorage != "" {
+		return errors.Annotatef(berrors.ErrInvalidArgument,
+			"--%s is not supported with --%s", flagMirrorStorage, flagPerDBMeta)
+	}
+	if err = cfg.StreamConfig.parseFromFlags(flags); err != nil {
+		return errors.Trace(err)
+	}
+	pathTemplate, err := flags.GetString(flagPathTemplate)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if pathTemplate != "" {
+		cfg.PathTemplate = metautil.PathTemplate(pathTemplate)
+		if err = cfg.PathTemplate.Validate(); err != nil {
+			return errors.Trace(err)
+		}
+		if storage.IsStreamURL(cfg.Storage) {
+			return errors.Annotatef(berrors.ErrInvalidArgument,
+				"--%s is not supported by the stream storage %s", flagPathTemplate, cfg.Storage)
+		}
+	}
+	cfg.ProgressInterval, err = flags.GetDuration(flagProgressInterval)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	cfg.TaskLockTTL, err = flags.GetDuration(flagTaskLockTTL)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if err = cfg.AdaptiveConfig.parseFromFlags(flags); err != nil {
+		return errors.Trace(err)
+	}
+	if err = cfg.RangeRetryConfig.parseFromFlags(flags); err != nil {
+		return errors.Trace(err)
+	}
+	if cfg.TolerateFailedRanges || cfg.FillGaps {
+		if storage.IsStreamURL(cfg.Storage) || cfg.PerDBMeta || cfg.PathTemplate != "" {
+			return errors.Annotatef(berrors.ErrInvalidArgument,
+				"--%s and --%s are not supported with the stream storage, --%s or --%s",
+				flagTolerateFailedRanges, flagFillGaps, flagPerDBMeta, flagPathTemplate)
+		}
+	}
+	windows, err := flags.GetString(flagRateLimitWindows)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if windows != "" {
+		if cfg.AdaptiveConcurrency {
+			return errors.Annotatef(berrors.ErrInvalidArgument,
+				"--%s conflicts with --%s", flagRateLimitWindows, flagAdaptiveConcurrency)
+		}
+		var unit uint64
+		if unit, err = flags.GetUint64(flagRateLimitUnit); err != nil {
+			return errors.Trace(err)
+		}
+		if cfg.RateLimitWindows, err = backup.ParseRateLimitWindows(windows, unit); err != nil {
+			return errors.Trace(err)
+		}
+	}
+	return nil
 }
 
 // ParseFromFlags parses the backup-related flags from the flag set.
@@ -175,12 +509,54 @@ func parseCompressionFlags(flags *pflag.FlagSet) (*CompressionConfig, error) {
 	if err != nil {
 		return nil, errors.Trace(err)
 	}
+	if err = checkCompressionLevel(compressionType, level); err != nil {
+		return nil, errors.Trace(err)
+	}
 	return &CompressionConfig{
 		CompressionLevel: level,
 		CompressionType:  compressionType,
 	}, nil
 }
 
+func defineVerifyFilesFlag(flags *pflag.FlagSet) {
+	flags.Float64(flagVerifyFiles, 0,
+		"the ratio of the data files read back from the storage after the backup, whose sha256 digests, "+
+			"SST block checksums and key ranges are checked against the backupmeta, the backup fails if any "+
+			"is corrupted, 0 disables it and 1 verifies all files")
+}
+
+func parseVerifyFilesFlag(flags *pflag.FlagSet) (float64, error) {
+	ratio, err := flags.GetFloat64(flagVerifyFiles)
+	if err != nil {
+		return 0, errors.Trace(err)
+	}
+	if ratio < 0 || ratio > 1 {
+		return 0, errors.Annotatef(berrors.ErrInvalidArgument, "--%s must be between 0 and 1, got %v", flagVerifyFiles, ratio)
+	}
+	return ratio, nil
+}
+
+// verifyBackupFiles reads back the ratio of the data files of the backup, so
+// a bad write of the storage fails the backup instead of the restore.
+func verifyBackupFiles(
+	ctx context.Context,
+	s storage.ExternalStorage,
+	backupMeta *backuppb.BackupMeta,
+	isRawKv bool,
+	ratio float64,
+	concurrency uint32,
+) error {
+	if ratio <= 0 {
+		return nil
+	}
+	files, err := metautil.NewMetaReader(backupMeta, s).ReadDataFiles(ctx)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	_, err = backup.VerifyDataFiles(ctx, s, files, isRawKv, ratio, uint(concurrency))
+	return errors.Trace(err)
+}
+
 // adjustBackupConfig is use for BR(binary) and BR in TiDB.
 // When new config was add and not included in parser.
 // we should set proper value in this function.
@@ -195,7 +571,9 @@ func (cfg *BackupConfig) adjustBackupConfig() {
 	if cfg.Config.Concurrency > maxBackupConcurrency {
 		cfg.Config.Concurrency = maxBackupConcurrency
 	}
-	if cfg.RateLimit != unlimited {
+	// the adaptive concurrency and the rate limit schedule share the rate
+	// limit among the concurrent backup requests themselves.
+	if cfg.RateLimit != unlimited && !cfg.AdaptiveConcurrency && len(cfg.RateLimitWindows) == 0 {
 		// TiKV limits the upload rate by each backup request.
 		// When the backup requests are sent concurrently,
 		// the ratelimit couldn't work as intended.
@@ -212,6 +590,9 @@ func (cfg *BackupConfig) adjustBackupConfig() {
 	if cfg.GCTTL == 0 {
 		cfg.GCTTL = utils.DefaultBRGCSafePointTTL
 	}
+	if cfg.AdaptiveMaxCPUUsage == 0 {
+		cfg.AdaptiveMaxCPUUsage = defaultAdaptiveMaxCPUUsage
+	}
 	// Use zstd as default
 	if cfg.CompressionType == backuppb.CompressionType_UNKNOWN {
 		cfg.CompressionType = backuppb.CompressionType_ZSTD
@@ -219,12 +600,20 @@ func (cfg *BackupConfig) adjustBackupConfig() {
 }
 
 // RunBackup starts a backup task inside the current goroutine.
-func RunBackup(c context.Context, g glue.Glue, cmdName string, cfg *BackupConfig) error {
+func RunBackup(c context.Context, g glue.Glue, cmdName string, cfg *BackupConfig) (err error) {
 	cfg.adjustBackupConfig()
+	if cfg.FillGaps {
+		return errors.Trace(runBackupFillGaps(c, g, cmdName, cfg))
+	}
 
 	defer summary.Summary(cmdName)
+	c, collectS3Requests := withS3RequestCounter(c)
+	defer collectS3Requests()
 	ctx, cancel := context.WithCancel(c)
 	defer cancel()
+	if err = startContinuousProfiling(ctx, &cfg.Config); err != nil {
+		return errors.Trace(err)
+	}
 
 	if span := opentracing.SpanFromContext(ctx); span != nil && span.Tracer() != nil {
 		span1 := span.Tracer().StartSpan("task.RunBackup", opentracing.ChildOf(span.Context()))
@@ -232,6 +621,19 @@ func RunBackup(c context.Context, g glue.Glue, cmdName string, cfg *BackupConfig
 		ctx = opentracing.ContextWithSpan(ctx, span1)
 	}
 
+	// the backup is written to the staging storage and streamed at the end.
+	stream, err := cfg.useStreamStaging(&cfg.Config)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	var streamWriter io.WriteCloser
+	if stream != "" {
+		if streamWriter, err = storage.OpenStreamWriter(stream); err != nil {
+			return errors.Trace(err)
+		}
+		defer streamWriter.Close()
+	}
+
 	u, err := storage.ParseBackend(cfg.Storage, &cfg.BackendOptions)
 	if err != nil {
 		return errors.Trace(err)
@@ -241,11 +643,23 @@ func RunBackup(c context.Context, g glue.Glue, cmdName string, cfg *BackupConfig
 	// Domain loads all table info into memory. By skipping Domain, we save
 	// lots of memory (about 500MB for 40K 40 fields YCSB tables).
 	needDomain := !skipStats
-	mgr, err := NewMgr(ctx, g, cfg.PD, cfg.TLS, GetKeepalive(&cfg.Config), cfg.CheckRequirements, needDomain)
+	mgr, err := NewMgr(ctx, g, cfg.PD, cfg.TLS, GetKeepalive(&cfg.Config), cfg.Timeout, cfg.CheckRequirements, needDomain)
 	if err != nil {
 		return errors.Trace(err)
 	}
 	defer mgr.Close()
+	// the backup is written to the directory rendered from the template, and
+	// indexed in the root of the template.
+	root := u
+	var backupPath *metautil.BackupPath
+	if cfg.PathTemplate != "" {
+		backupPath = renderBackupPath(cfg.PathTemplate, mgr.GetPDClient().GetClusterID(ctx), time.Now())
+		if u, err = storage.SubBackend(root, backupPath.Path); err != nil {
+			return errors.Trace(err)
+		}
+		log.Info("backup path rendered from the template",
+			zap.String("template", string(cfg.PathTemplate)), zap.String("path", backupPath.Path))
+	}
 	var statsHandle *handle.Handle
 	if !skipStats {
 		statsHandle = mgr.GetDomain().StatsHandle()
@@ -255,19 +669,87 @@ func RunBackup(c context.Context, g glue.Glue, cmdName string, cfg *BackupConfig
 	if err != nil {
 		return errors.Trace(err)
 	}
-	opts := storage.ExternalStorageOptions{
-		NoCredentials:   cfg.NoCreds,
-		SendCredentials: cfg.SendCreds,
-		SkipCheckPath:   cfg.SkipCheckPath,
+	opts := backupStorageOptions(&cfg.Config)
+	if err = client.SetStorage(ctx, u, opts); err != nil {
+		return errors.Trace(err)
+	}
+	if stream == "" {
+		// the staging storage of the stream is private to this task.
+		var taskLock *taskLocker
+		if taskLock, err = acquireTaskLock(ctx, client.GetStorage(), cmdName, cfg.TaskLockTTL, cancel); err != nil {
+			return errors.Trace(err)
+		}
+		defer func() {
+			// the backup aborted by losing the lock fails with the reason.
+			if lostErr := taskLock.release(); lostErr != nil {
+				err = lostErr
+			}
+		}()
+		// check again, another task may finish the backup before locked.
+		if err = backup.CheckNoBackup(ctx, client.GetStorage()); err != nil {
+			return errors.Trace(err)
+		}
 	}
-	if err = client.SetStorage(ctx, u, &opts); err != nil {
+	if u.GetLocal() != nil {
+		// only the host of BR can be probed, the files written by TiKV are
+		// checked after the backup with --local.fsync.
+		if err = storage.ProbeWritable(ctx, client.GetStorage()); err != nil {
+			return errors.Trace(err)
+		}
+	}
+	if err = cfg.setMirrorStorage(ctx, client, &cfg.BackendOptions, opts); err != nil {
 		return errors.Trace(err)
 	}
+	if opts.ObjectLock != nil {
+		if err = metautil.WriteRetention(ctx, client.GetStorage(), opts.ObjectLock); err != nil {
+			return errors.Trace(err)
+		}
+	}
 	err = client.SetLockFile(ctx)
 	if err != nil {
 		return errors.Trace(err)
 	}
 	client.SetGCTTL(cfg.GCTTL)
+	client.SetRangeRetry(cfg.RangeRetryTimes, cfg.TolerateFailedRanges)
+	client.SetAllowPartial(cfg.AllowPartial)
+	// the rate limit can be changed by the status server while backing up.
+	defer registerBackup(client)()
+	progressInterval := cfg.ProgressInterval
+	if stream != "" {
+		// the staging storage is on this host and copied to the stream as a
+		// whole, it's not monitored by the snapshots.
+		progressInterval = 0
+	}
+	progress := startProgressReporter(ctx, client.GetStorage(), client, progressInterval)
+	defer func() {
+		progress.finish(err)
+	}()
+	if backupPath != nil {
+		if err = metautil.WriteBackupPath(ctx, client.GetStorage(), backupPath); err != nil {
+			return errors.Trace(err)
+		}
+	}
+
+	// the backups are indexed in the parent directory of the storage, or the
+	// root of the path template.
+	var indexStorage storage.ExternalStorage
+	var backupName string
+	if backupPath != nil {
+		backupName = backupPath.Path
+		if indexStorage, err = newBackupIndexStorage(ctx, root, opts); err != nil {
+			log.Warn("failed to open the backup index, the backup isn't indexed", zap.Error(err))
+		}
+	} else if stream == "" {
+		indexStorage, backupName, err = openBackupIndexStorage(ctx, u, opts)
+		if err != nil {
+			log.Warn("failed to open the backup index, the backup isn't indexed", zap.Error(err))
+		}
+	}
+	if cfg.LastBackupTSAuto {
+		if err = cfg.resolveLastBackupTS(ctx, indexStorage); err != nil {
+			return errors.Trace(err)
+		}
+	}
 
 	backupTS, err := client.GetTS(ctx, cfg.TimeAgo, cfg.BackupTS)
 	if err != nil {
@@ -285,10 +767,20 @@ func RunBackup(c context.Context, g glue.Glue, cmdName string, cfg *BackupConfig
 	}
 
 	log.Info("current backup safePoint job", zap.Object("safePoint", sp))
-	err = utils.StartServiceSafePointKeeper(ctx, mgr.GetPDClient(), sp)
+	stopSafePoint, err := utils.StartServiceSafePointKeeper(ctx, mgr.GetPDClient(), sp)
 	if err != nil {
 		return errors.Trace(err)
 	}
+	defer func() {
+		// stop renewing the service safe point before removing it, and remove
+		// it even if the backup is canceled, so it doesn't block GC till the
+		// TTL expires.
+		stopSafePoint()
+		if e := utils.RemoveServiceSafePoint(context.Background(), mgr.GetPDClient(), sp); e != nil {
+			log.Warn("failed to remove the service safe point, it's kept until the TTL expires",
+				zap.Object("safePoint", sp), zap.Error(e))
+		}
+	}()
 
 	isIncrementalBackup := cfg.LastBackupTS > 0
 
@@ -350,6 +842,26 @@ func RunBackup(c context.Context, g glue.Glue, cmdName string, cfg *BackupConfig
 		return metawriter.FinishWriteMetas(ctx, metautil.AppendSchema)
 	}
 
+	// the placement rules of all tables are recorded before the unchanged
+	// schemas are skipped by --reuse-base-schema.
+	if err = writePlacementRules(ctx, client.GetStorage(), schemas, cfg.PD, mgr.GetTLSConfig()); err != nil {
+		return errors.Trace(err)
+	}
+	writeClusterTopology(ctx, mgr, client.GetStorage())
+	// the key is recorded so it can be rotated by `br key rotate` later.
+	encryptionKey := backupEncryptionKey(u)
+	if encryptionKey != nil {
+		encryption := &metautil.Encryption{Keys: []metautil.EncryptionKey{*encryptionKey}}
+		if err = metautil.WriteEncryption(ctx, client.GetStorage(), encryption); err != nil {
+			return errors.Trace(err)
+		}
+	}
+	if cfg.VerifyQueries != "" {
+		if err = recordVerifyQueries(ctx, g, mgr, client.GetStorage(), cfg.VerifyQueries, backupTS); err != nil {
+			return errors.Trace(err)
+		}
+	}
+
 	if isIncrementalBackup {
 		if backupTS <= cfg.LastBackupTS {
 			log.Error("LastBackupTS is larger or equal to current TS")
@@ -369,9 +881,54 @@ func RunBackup(c context.Context, g glue.Glue, cmdName string, cfg *BackupConfig
 		if err = metawriter.FinishWriteMetas(ctx, metautil.AppendDDL); err != nil {
 			return errors.Trace(err)
 		}
+
+		if cfg.ReuseBaseSchema {
+			err = writeBaseBackup(ctx, client.GetStorage(), indexStorage, schemas, cfg.LastBackupTS, backupPath)
+			if err != nil {
+				return errors.Trace(err)
+			}
+		}
+	}
+
+	if cfg.SchemaOnly {
+		// no data is backed up, the backupmeta has the schemas without files.
+		log.Info("schema only backup, skip backing up the data of the tables",
+			zap.Int("tables", schemas.Len()))
+		ranges = []rtree.Range{}
+	} else if cfg.SkipIndexes {
+		var skipped []metautil.TableSkippedIndexes
+		ranges, skipped = schemas.SkipIndexes(ranges)
+		if err = metautil.WriteSkippedIndexes(ctx, client.GetStorage(), skipped); err != nil {
+			return errors.Trace(err)
+		}
+		n := 0
+		for _, table := range skipped {
+			n += len(table.Indexes)
+		}
+		log.Info("skip backing up the data of the indexes, they're rebuilt after restore",
+			zap.Int("tables", len(skipped)), zap.Int("indexes", n))
+		summary.CollectInt("skipped indexes", n)
+	}
+	// the region boundaries are collected from the ranges before split, the
+	// split keys are region boundaries as well.
+	topologyRanges := ranges
+	if cfg.SplitRangeSize > 0 {
+		var split int
+		ranges, split, err = backup.SplitLargeRanges(ctx, mgr, ranges, cfg.SplitRangeSize)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		if split > 0 {
+			summary.CollectInt("split large ranges", split)
+		}
 	}
 
 	summary.CollectInt("backup total ranges", len(ranges))
+	if n := schemas.TiFlashReplicaTables(); n > 0 {
+		log.Warn("the TiFlash replicas are excluded from backup, they're rebuilt from TiKV after restore",
+			zap.Int("tables", n))
+		summary.CollectInt("tables with TiFlash replicas to rebuild", n)
+	}
 
 	var updateCh glue.Progress
 	var unit backup.ProgressUnit
@@ -391,17 +948,20 @@ func RunBackup(c context.Context, g glue.Glue, cmdName string, cfg *BackupConfig
 		updateCh = g.StartProgress(
 			ctx, cmdName, int64(approximateRegions), !cfg.LogProgress)
 		summary.CollectInt("backup total regions", approximateRegions)
+		progress.startBackup(unit, int64(approximateRegions))
 	} else {
 		unit = backup.RangeUnit
 		// To reduce the costs, we can use the range as unit of progress.
 		updateCh = g.StartProgress(
 			ctx, cmdName, int64(len(ranges)), !cfg.LogProgress)
+		progress.startBackup(unit, int64(len(ranges)))
 	}
 
 	progressCount := 0
 	progressCallBack := func(callBackUnit backup.ProgressUnit) {
 		if unit == callBackUnit {
 			updateCh.Inc()
+			progress.inc()
 			progressCount++
 			failpoint.Inject("progress-call-back", func(v failpoint.Value) {
 				log.Info("failpoint progress-call-back injected")
@@ -419,51 +979,131 @@ func RunBackup(c context.Context, g glue.Glue, cmdName string, cfg *BackupConfig
 			})
 		}
 	}
-	metawriter.StartWriteMetasAsync(ctx, metautil.AppendDataFile)
-	err = client.BackupRanges(ctx, ranges, req, uint(cfg.Concurrency), metawriter, progressCallBack)
-	if err != nil {
-		return errors.Trace(err)
-	}
-	// Backup has finished
-	updateCh.Close()
-
-	err = metawriter.FinishWriteMetas(ctx, metautil.AppendDataFile)
-	if err != nil {
-		return errors.Trace(err)
-	}
-
-	metawriter.Update(func(m *backuppb.BackupMeta) {
+	updateMeta := func(m *backuppb.BackupMeta) {
 		m.StartVersion = req.StartVersion
 		m.EndVersion = req.EndVersion
 		m.IsRawKv = req.IsRawKv
 		m.ClusterId = req.ClusterId
 		m.ClusterVersion = clusterVersion
 		m.BrVersion = brVersion
-	})
+	}
+	if cfg.AdaptiveConcurrency {
+		adaptiveCtx, cancelAdaptive := context.WithCancel(ctx)
+		defer cancelAdaptive()
+		err = cfg.AdaptiveConfig.startAdaptiveController(adaptiveCtx, client, mgr, uint(cfg.Concurrency), cfg.RateLimit)
+		if err != nil {
+			return errors.Trace(err)
+		}
+	}
+	if len(cfg.RateLimitWindows) > 0 {
+		client.SetRateLimitSchedule(backup.NewRateLimitSchedule(cfg.RateLimitWindows, cfg.RateLimit, uint(cfg.Concurrency)))
+	}
+	var dbBackups []*dbBackup
+	if cfg.PerDBMeta {
+		dbBackups, err = newDBBackups(ctx, u, opts, schemas, ranges, cfg.UseBackupMetaV2)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		for _, db := range dbBackups {
+			err = db.backupRanges(ctx, client, req, uint(cfg.Concurrency), updateMeta, progressCallBack)
+			if err != nil {
+				return errors.Trace(err)
+			}
+		}
+		// Backup has finished
+		updateCh.Close()
+	} else {
+		metawriter.StartWriteMetasAsync(ctx, metautil.AppendDataFile)
+		err = client.BackupRanges(ctx, ranges, req, uint(cfg.Concurrency), metawriter, progressCallBack)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		// Backup has finished
+		updateCh.Close()
 
-	skipChecksum := !cfg.Checksum || isIncrementalBackup
+		// copy the SST files written by TiKV before the backupmeta.
+		if err = storage.SyncMirror(ctx, client.GetStorage(), uint(cfg.Concurrency)); err != nil {
+			return errors.Trace(err)
+		}
+
+		err = metawriter.FinishWriteMetas(ctx, metautil.AppendDataFile)
+		if err != nil {
+			return errors.Trace(err)
+		}
+	}
+
+	metawriter.Update(updateMeta)
+
+	// the tables with the failed ranges don't match their checksums.
+	failedRanges := client.FailedRanges()
+	skipChecksum := !cfg.Checksum || isIncrementalBackup || cfg.SchemaOnly || len(failedRanges) > 0
 	checksumProgress := int64(schemas.Len())
 	if skipChecksum {
 		checksumProgress = 1
-		if isIncrementalBackup {
+		if cfg.SchemaOnly {
+			// The checksum of the tables doesn't match the data which isn't backed up.
+			log.Info("Skip fast checksum in schema only backup")
+		} else if isIncrementalBackup {
 			// Since we don't support checksum for incremental data, fast checksum should be skipped.
 			log.Info("Skip fast checksum in incremental backup")
+		} else if len(failedRanges) > 0 {
+			log.Info("Skip fast checksum in incomplete backup", zap.Int("failed-ranges", len(failedRanges)))
 		} else {
 			// When user specified not to calculate checksum, don't calculate checksum.
 			log.Info("Skip fast checksum")
 		}
 	}
 	updateCh = g.StartProgress(ctx, "Checksum", checksumProgress, !cfg.LogProgress)
-	schemasConcurrency := uint(utils.MinInt(backup.DefaultSchemaConcurrency, schemas.Len()))
+	progress.setPhase(progressPhaseChecksum)
+	if cfg.PerDBMeta {
+		for _, db := range dbBackups {
+			err = db.backupSchemas(ctx, mgr, statsHandle, backupTS, cfg, skipChecksum, updateCh)
+			if err != nil {
+				return errors.Trace(err)
+			}
+		}
+		// The global backupmeta refers to the files in the sub directories.
+		if err = mergeDBBackups(ctx, metawriter, dbBackups); err != nil {
+			return errors.Trace(err)
+		}
+	} else {
+		schemasConcurrency := uint(utils.MinInt(backup.DefaultSchemaConcurrency, schemas.Len()))
 
-	err = schemas.BackupSchemas(
-		ctx, metawriter, mgr.GetStorage(), statsHandle, backupTS, schemasConcurrency, cfg.ChecksumConcurrency, skipChecksum, updateCh)
-	if err != nil {
-		return errors.Trace(err)
+		err = schemas.BackupSchemas(
+			ctx, metawriter, mgr.GetStorage(), statsHandle, backupTS, schemasConcurrency, cfg.ChecksumConcurrency, skipChecksum, updateCh)
+		if err != nil {
+			return errors.Trace(err)
+		}
 	}
 	// Checksum has finished, close checksum progress.
 	updateCh.Close()
 
+	if u.GetLocal() != nil && cfg.BackendOptions.Local.Fsync {
+		// the local storage is shared by BR and TiKV, e.g. NFS, so the files
+		// written by TiKV must be visible to BR.
+		if err = metautil.CheckDataFiles(ctx, client.GetStorage(), metawriter.Backupmeta()); err != nil {
+			return errors.Trace(err)
+		}
+	}
+	err = verifyBackupFiles(ctx, client.GetStorage(), metawriter.Backupmeta(), false, cfg.VerifyFiles, cfg.Concurrency)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	// the data files are removed after they are verified.
+	if cfg.DedupBaseFiles && isIncrementalBackup && !cfg.SchemaOnly {
+		indexBackend := root
+		if backupPath == nil && indexStorage != nil {
+			if indexBackend, _, err = storage.ParentBackend(u); err != nil {
+				return errors.Trace(err)
+			}
+		}
+		err = dedupBaseFiles(ctx, client.GetStorage(), indexBackend, indexStorage, opts,
+			metawriter.Backupmeta(), cfg.LastBackupTS, backupPath, uint(cfg.Concurrency))
+		if err != nil {
+			return errors.Trace(err)
+		}
+	}
+
 	if !skipChecksum {
 		// Check if checksum from files matches checksum from coprocessor.
 		err = checksum.FastChecksum(ctx, metawriter.Backupmeta(), client.GetStorage())
@@ -472,6 +1112,47 @@ func RunBackup(c context.Context, g glue.Glue, cmdName string, cfg *BackupConfig
 		}
 	}
 
+	if cfg.SchemaOnly {
+		// restore tells the backup has no data by the features.
+		err = metautil.WriteBackupFeatures(ctx, client.GetStorage(), &metautil.BackupFeatures{SchemaOnly: true})
+		if err != nil {
+			return errors.Trace(err)
+		}
+	} else {
+		if err = writeBackupFeatures(ctx, client, &req); err != nil {
+			return errors.Trace(err)
+		}
+		// the largest and the slowest tables are in the summary.
+		tableStats := client.TableStats(schemas)
+		summary.CollectTableStats(tableStats)
+		if err = metautil.WriteTableStats(ctx, client.GetStorage(), tableStats); err != nil {
+			return errors.Trace(err)
+		}
+	}
+
+	if cfg.RegionTopology && !cfg.SchemaOnly {
+		var boundaries [][]byte
+		boundaries, err = backup.CollectRegionBoundaries(ctx, mgr.GetPDClient(), topologyRanges)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		if err = metautil.WriteRegionTopology(ctx, client.GetStorage(), boundaries); err != nil {
+			return errors.Trace(err)
+		}
+	}
+
+	if cfg.ChecksumManifest {
+		err = metautil.WriteChecksumManifest(ctx, client.GetStorage(), metawriter.Backupmeta(), uint(cfg.Concurrency))
+		if err != nil {
+			return errors.Trace(err)
+		}
+	}
+
+	// lock the SST files written by TiKV after all files are written.
+	if err = storage.LockS3Objects(ctx, u, opts, uint(cfg.Concurrency)); err != nil {
+		return errors.Trace(err)
+	}
+
 	g.Record(summary.BackupDataSize, metawriter.ArchiveSize())
 	failpoint.Inject("s3-outage-during-writing-file", func(v failpoint.Value) {
 		log.Info("failpoint s3-outage-during-writing-file injected, " +
@@ -487,12 +1168,234 @@ func RunBackup(c context.Context, g glue.Glue, cmdName string, cfg *BackupConfig
 		}
 		time.Sleep(3 * time.Second)
 	})
+	if streamWriter != nil {
+		if err = writeBackupStream(ctx, client.GetStorage(), streamWriter); err != nil {
+			return errors.Trace(err)
+		}
+		if err = streamWriter.Close(); err != nil {
+			return errors.Trace(err)
+		}
+	}
+	// the incomplete backup isn't indexed until the gaps are filled.
+	if len(failedRanges) > 0 && !cfg.AllowPartial {
+		return errors.Trace(writeFailedRanges(ctx, client.GetStorage(), cfg.LastBackupTS, backupTS, failedRanges))
+	}
+	if len(failedRanges) > 0 {
+		err = writePartialReport(ctx, client.GetStorage(), schemas, cfg.LastBackupTS, backupTS, failedRanges)
+		if err != nil {
+			return errors.Trace(err)
+		}
+	}
+	// the schema only backup can't be the base of the incremental backups.
+	if indexStorage != nil && !cfg.SchemaOnly && len(failedRanges) == 0 {
+		entry := metautil.BackupIndexEntry{
+			Name:         backupName,
+			StartVersion: cfg.LastBackupTS,
+			EndVersion:   backupTS,
+			Time:         time.Now(),
+		}
+		if backupPath != nil {
+			entry.PathTemplate = backupPath.Template
+		}
+		if encryptionKey != nil {
+			entry.KeyID, entry.KeyVersion = encryptionKey.KeyID, encryptionKey.Version
+		}
+		if err = metautil.RecordBackupIndex(ctx, indexStorage, entry); err != nil {
+			log.Warn("failed to record the backup index, the next backup can't find this one by --lastbackupts=auto",
+				zap.Error(err))
+		}
+	}
+	summary.CollectArtifact("backup", client.GetStorage().URI())
 	// Set task summary to success status.
 	summary.SetSuccessStatus(true)
 	return nil
 }
 
+// writeBackupFeatures records the features of the backup requests, and the
+// ones disabled on the stores of old versions.
+func writeBackupFeatures(ctx context.Context, client *backup.Client, req *backuppb.BackupRequest) error {
+	features := &metautil.BackupFeatures{
+		Requested:  backup.RequestedFeatures(req),
+		Downgraded: client.DowngradedFeatures(),
+	}
+	if req.CompressionType != backuppb.CompressionType_UNKNOWN {
+		features.Compression = compressionName(req.CompressionType)
+		features.CompressionLevel = req.CompressionLevel
+	}
+	if len(features.Downgraded) > 0 {
+		summary.CollectInt("stores with downgraded backup features", len(features.Downgraded))
+	}
+	return errors.Trace(metautil.WriteBackupFeatures(ctx, client.GetStorage(), features))
+}
+
+// writePlacementRules records the placement rules of the tables in PD, which
+// are re-applied by restore with --with-placement-rules. The backup goes on
+// without them if they can't be read.
+func writePlacementRules(
+	ctx context.Context,
+	s storage.ExternalStorage,
+	schemas *backup.Schemas,
+	pdAddrs []string,
+	tlsConf *tls.Config,
+) error {
+	var rules []placement.Rule
+	var err error
+	for _, pdAddr := range pdAddrs {
+		rules, err = pdutil.GetPlacementRules(ctx, pdAddr, tlsConf)
+		if err == nil {
+			break
+		}
+	}
+	if err != nil {
+		log.Warn("failed to read the placement rules, they aren't backed up", zap.Error(err))
+		return nil
+	}
+	tables := schemas.PlacementRules(rules)
+	if len(tables) == 0 {
+		return nil
+	}
+	summary.CollectInt("tables with placement rules", len(tables))
+	return errors.Trace(metautil.WritePlacementRules(ctx, s, tables))
+}
+
+// writeBaseBackup references the last backup as the base of the incremental
+// backup, and removes the schemas of the tables not changed since it, which
+// are read from the base backup by restore. The backup path is nil unless the
+// backup is laid out by a path template.
+func writeBaseBackup(
+	ctx context.Context,
+	s storage.ExternalStorage,
+	indexStorage storage.ExternalStorage,
+	schemas *backup.Schemas,
+	lastBackupTS uint64,
+	backupPath *metautil.BackupPath,
+) error {
+	if indexStorage == nil {
+		return errors.Annotatef(berrors.ErrInvalidArgument,
+			"--%s needs the backup index in the parent directory of the storage", flagReuseBaseSchema)
+	}
+	index, err := metautil.ReadBackupIndex(ctx, indexStorage)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	last := index.Find(lastBackupTS)
+	if last == nil {
+		return errors.Annotatef(berrors.ErrInvalidArgument,
+			"--%s needs the last backup of end version %d in the backup index", flagReuseBaseSchema, lastBackupTS)
+	}
+	tableIDs := schemas.SkipUnchanged(lastBackupTS)
+	log.Info("reuse the schemas of the base backup",
+		zap.String("base", last.Name), zap.Int("tables", len(tableIDs)), zap.Int("changed tables", schemas.Len()))
+	base := &metautil.BaseBackup{
+		Name:       last.Name,
+		EndVersion: last.EndVersion,
+		TableIDs:   tableIDs,
+	}
+	if backupPath != nil {
+		base.Depth = backupPath.Template.Depth()
+	}
+	return errors.Trace(metautil.WriteBaseBackup(ctx, s, base))
+}
+
+// backupStorageOptions returns the options of the storage written by the
+// backup.
+func backupStorageOptions(cfg *Config) *storage.ExternalStorageOptions {
+	opts := storageOpts(cfg)
+	opts.ObjectLock = cfg.BackendOptions.S3.ObjectLock(time.Now())
+	return opts
+}
+
+// openBackupIndexStorage opens the parent directory of the storage, which holds
+// the backup index, and returns the name of the storage in it.
+func openBackupIndexStorage(
+	ctx context.Context,
+	u *backuppb.StorageBackend,
+	opts *storage.ExternalStorageOptions,
+) (storage.ExternalStorage, string, error) {
+	parent, name, err := storage.ParentBackend(u)
+	if err != nil {
+		return nil, "", errors.Trace(err)
+	}
+	s, err := newBackupIndexStorage(ctx, parent, opts)
+	return s, name, errors.Trace(err)
+}
+
+// newBackupIndexStorage opens the directory holding the backup index.
+func newBackupIndexStorage(
+	ctx context.Context,
+	u *backuppb.StorageBackend,
+	opts *storage.ExternalStorageOptions,
+) (storage.ExternalStorage, error) {
+	indexOpts := *opts
+	indexOpts.SkipCheckPath = true
+	indexOpts.CheckPermissions = nil
+	// the index is rewritten by every backup.
+	indexOpts.ObjectLock = nil
+	s, err := storage.New(ctx, u, &indexOpts)
+	return s, errors.Trace(err)
+}
+
+// renderBackupPath renders the path of the backup of the cluster started at
+// the time from the template.
+func renderBackupPath(tmpl metautil.PathTemplate, clusterID uint64, now time.Time) *metautil.BackupPath {
+	values := metautil.PathTemplateValues(clusterID, now, uuid.New().String())
+	return &metautil.BackupPath{
+		Template:     tmpl,
+		Path:         tmpl.Render(values),
+		Placeholders: values,
+	}
+}
+
+// resolveLastBackupTS takes the end version of the last backup in the index
+// as LastBackupTS, or leaves it 0 for a full backup if the index is empty.
+func (cfg *BackupConfig) resolveLastBackupTS(ctx context.Context, indexStorage storage.ExternalStorage) error {
+	if indexStorage == nil {
+		return errors.Annotatef(berrors.ErrInvalidArgument,
+			"--%s=%s needs the backup index in the parent directory of the storage", flagLastBackupTS, lastBackupTSAuto)
+	}
+	index, err := metautil.ReadBackupIndex(ctx, indexStorage)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	last := index.Last()
+	if last == nil {
+		log.Info("no backup found in the backup index, do a full backup")
+		return nil
+	}
+	cfg.LastBackupTS = last.EndVersion
+	log.Info("take the last backup in the backup index as the last backup ts",
+		zap.String("name", last.Name), zap.Uint64("last backup ts", last.EndVersion))
+	return nil
+}
+
 // parseTSString port from tidb setSnapshotTS.
+// parseBackupTS parses --backupts, the relative form like -2h is returned as
+// the duration before the current TSO, which is resolved by PD like --timeago.
+func parseBackupTS(ts string) (uint64, time.Duration, error) {
+	if strings.HasPrefix(ts, "-") {
+		ago, err := time.ParseDuration(ts[1:])
+		if err != nil || ago <= 0 {
+			return 0, 0, errors.Annotatef(berrors.ErrInvalidArgument,
+				"invalid --%s %q, the relative form should be a positive duration like -2h", flagBackupTS, ts)
+		}
+		return 0, ago, nil
+	}
+	tso, err := parseTSString(ts)
+	if err != nil {
+		return 0, 0, errors.Annotatef(berrors.ErrInvalidArgument,
+			"invalid --%s %q, it should be a TSO, a datetime or a duration like -2h: %v", flagBackupTS, ts, err)
+	}
+	return tso, 0, nil
+}
+
+// tsTimeLayouts are the layouts of the datetime with the time zone offset,
+// the fractional seconds are accepted by them too.
+var tsTimeLayouts = []string{
+	"2006-01-02 15:04:05Z07:00",
+	"2006-01-02 15:04:05 Z07:00",
+	time.RFC3339,
+}
+
 func parseTSString(ts string) (uint64, error) {
 	if len(ts) == 0 {
 		return 0, nil
@@ -500,6 +1403,11 @@ func parseTSString(ts string) (uint64, error) {
 	if tso, err := strconv.ParseUint(ts, 10, 64); err == nil {
 		return tso, nil
 	}
+	for _, layout := range tsTimeLayouts {
+		if t, err := time.Parse(layout, ts); err == nil {
+			return oracle.GoTimeToTS(t), nil
+		}
+	}
 
 	loc := time.Local
 	sc := &stmtctx.StatementContext{
@@ -516,6 +1424,29 @@ func parseTSString(ts string) (uint64, error) {
 	return oracle.GoTimeToTS(t1), nil
 }
 
+// compressionName returns the name of the codec accepted by --compression.
+func compressionName(ct backuppb.CompressionType) string {
+	return strings.ToLower(ct.String())
+}
+
+// checkCompressionLevel checks the level is accepted by the codec, 0 means
+// the default level of TiKV.
+func checkCompressionLevel(ct backuppb.CompressionType, level int32) error {
+	var maxLevel int32
+	switch ct {
+	case backuppb.CompressionType_ZSTD:
+		maxLevel = 22
+	case backuppb.CompressionType_LZ4:
+		maxLevel = 12
+	}
+	if level < 0 || level > maxLevel {
+		return errors.Annotatef(berrors.ErrInvalidArgument,
+			"invalid --%s %d, it should be in [0, %d] for %s",
+			flagCompressionLevel, level, maxLevel, compressionName(ct))
+	}
+	return nil
+}
+
 func parseCompressionType(s string) (backuppb.CompressionType, error) {
 	var ct backuppb.CompressionType
 	switch s {
@@ -0,0 +1,223 @@
+// Copyright 2021 PingCAP, Inc. Licensed under Apache-2.0.
+
+package task
+
+import (
+	"context"
+	"path"
+
+	"github.com/gogo/protobuf/proto"
+	"github.com/pingcap/errors"
+	backuppb "github.com/pingcap/kvproto/pkg/backup"
+	"github.com/pingcap/log"
+	"go.uber.org/zap"
+	"golang.org/x/sync/errgroup"
+
+	berrors "github.com/pingcap/br/pkg/errors"
+	"github.com/pingcap/br/pkg/metautil"
+	"github.com/pingcap/br/pkg/restore"
+	"github.com/pingcap/br/pkg/storage"
+	"github.com/pingcap/br/pkg/summary"
+	"github.com/pingcap/br/pkg/utils"
+)
+
+// fileContentKey identifies the content of a data file by its digest and size.
+type fileContentKey struct {
+	sha256 string
+	size   uint64
+}
+
+// chainFileRefs returns the data files of the backups of the incremental
+// chain by their contents, the files referenced by a backup are resolved to
+// the backups holding them.
+func chainFileRefs(
+	ctx context.Context,
+	indexBackend *backuppb.StorageBackend,
+	chain []metautil.BackupIndexEntry,
+	opts *storage.ExternalStorageOptions,
+) (map[fileContentKey]metautil.FileRef, error) {
+	known := make(map[fileContentKey]metautil.FileRef)
+	for _, entry := range chain {
+		u, err := storage.SubBackend(indexBackend, entry.Name)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		s, err := storage.New(ctx, u, opts)
+		if err != nil {
+			return nil, errors.Annotatef(err, "failed to open the base backup %s", entry.Name)
+		}
+		data, err := s.ReadFile(ctx, metautil.MetaFile)
+		if err != nil {
+			return nil, errors.Annotatef(err, "failed to read the base backup %s", entry.Name)
+		}
+		meta := &backuppb.BackupMeta{}
+		if err = proto.Unmarshal(data, meta); err != nil {
+			return nil, errors.Annotatef(berrors.ErrInvalidMetaFile,
+				"failed to parse the backupmeta of the base backup %s: %v", entry.Name, err)
+		}
+		files, err := metautil.NewMetaReader(meta, s).ReadDataFiles(ctx)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		refs, err := metautil.ReadFileRefs(ctx, s)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		referenced := make(map[string]metautil.FileRef)
+		if refs != nil {
+			for _, ref := range refs.Refs {
+				referenced[ref.Name] = ref
+			}
+		}
+		for _, file := range files {
+			if len(file.Sha256) == 0 {
+				continue
+			}
+			key := fileContentKey{sha256: string(file.Sha256), size: file.Size_}
+			if _, ok := known[key]; ok {
+				continue
+			}
+			ref, ok := referenced[file.Name]
+			if !ok {
+				ref = metautil.FileRef{Backup: entry.Name, File: file.Name}
+			}
+			known[key] = ref
+		}
+	}
+	return known, nil
+}
+
+// dedupBaseFiles removes the data files of the incremental backup whose
+// contents are in the backups of its chain, and references them instead. The
+// references are written before the files are removed, so an interrupted
+// deduplication leaves the removed files referenced.
+func dedupBaseFiles(
+	ctx context.Context,
+	s storage.ExternalStorage,
+	indexBackend *backuppb.StorageBackend,
+	indexStorage storage.ExternalStorage,
+	opts *storage.ExternalStorageOptions,
+	backupMeta *backuppb.BackupMeta,
+	lastBackupTS uint64,
+	backupPath *metautil.BackupPath,
+	concurrency uint,
+) error {
+	if indexStorage == nil {
+		return errors.Annotatef(berrors.ErrInvalidArgument,
+			"--%s needs the backup index in the parent directory of the storage", flagDedupBaseFiles)
+	}
+	index, err := metautil.ReadBackupIndex(ctx, indexStorage)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	chain := index.Chain(lastBackupTS)
+	if len(chain) == 0 {
+		return errors.Annotatef(berrors.ErrInvalidArgument,
+			"--%s needs the last backup of end version %d in the backup index", flagDedupBaseFiles, lastBackupTS)
+	}
+	known, err := chainFileRefs(ctx, indexBackend, chain, opts)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	files, err := metautil.NewMetaReader(backupMeta, s).ReadDataFiles(ctx)
+	if err != nil {
+		return errors.Trace(err)
+	}
+
+	refs := &metautil.FileRefs{}
+	if backupPath != nil {
+		refs.Depth = backupPath.Template.Depth()
+	}
+	var size uint64
+	for _, file := range files {
+		if len(file.Sha256) == 0 {
+			continue
+		}
+		ref, ok := known[fileContentKey{sha256: string(file.Sha256), size: file.Size_}]
+		if !ok {
+			continue
+		}
+		// the referenced file may be deleted by hand.
+		exists, err := indexStorage.FileExists(ctx, path.Join(ref.Backup, ref.File))
+		if err != nil {
+			return errors.Trace(err)
+		}
+		if !exists {
+			continue
+		}
+		ref.Name = file.Name
+		refs.Refs = append(refs.Refs, ref)
+		size += file.Size_
+	}
+	if len(refs.Refs) == 0 {
+		log.Info("no data files duplicated with the base backups", zap.Int("bases", len(chain)))
+		return nil
+	}
+	if err = metautil.WriteFileRefs(ctx, s, refs); err != nil {
+		return errors.Trace(err)
+	}
+
+	pool := utils.NewWorkerPool(concurrency, "dedup base files")
+	eg, ectx := errgroup.WithContext(ctx)
+	for _, ref := range refs.Refs {
+		name := ref.Name
+		pool.ApplyOnErrorGroup(eg, func() error {
+			return errors.Trace(s.DeleteFile(ectx, name))
+		})
+	}
+	if err = eg.Wait(); err != nil {
+		return errors.Trace(err)
+	}
+	log.Info("data files deduplicated against the base backups",
+		zap.Int("files", len(refs.Refs)), zap.Int("total-files", len(files)), zap.Uint64("size", size))
+	summary.CollectInt("deduplicated data files", len(refs.Refs))
+	summary.CollectUint("deduplicated data size", size)
+	return nil
+}
+
+// fileRefsRoot returns the directory of the backup index, which the names of
+// the backups referenced by the backup u of the depth are relative to.
+func fileRefsRoot(u *backuppb.StorageBackend, depth int) (*backuppb.StorageBackend, error) {
+	parent := u
+	var err error
+	for d := 0; d < depth || d == 0; d++ {
+		if parent, _, err = storage.ParentBackend(parent); err != nil {
+			return nil, errors.Annotatef(err, "failed to find the backups referenced by %s", metautil.FileRefsFile)
+		}
+	}
+	return parent, nil
+}
+
+// setFileSources resolves the data files of the backup referencing the other
+// backups of its chain, so they are downloaded from the backups holding them.
+func setFileSources(
+	ctx context.Context,
+	u *backuppb.StorageBackend,
+	s storage.ExternalStorage,
+	client *restore.Client,
+) error {
+	refs, err := metautil.ReadFileRefs(ctx, s)
+	if err != nil || refs == nil {
+		return errors.Trace(err)
+	}
+	parent, err := fileRefsRoot(u, refs.Depth)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	backends := make(map[string]*backuppb.StorageBackend)
+	sources := make(map[string]restore.FileSource, len(refs.Refs))
+	for _, ref := range refs.Refs {
+		backend, ok := backends[ref.Backup]
+		if !ok {
+			if backend, err = storage.SubBackend(parent, ref.Backup); err != nil {
+				return errors.Trace(err)
+			}
+			backends[ref.Backup] = backend
+		}
+		sources[ref.Name] = restore.FileSource{Backend: backend, Name: ref.File}
+	}
+	log.Info("restore the data files referenced from the base backups",
+		zap.Int("files", len(sources)), zap.Int("backups", len(backends)))
+	client.SetFileSources(sources)
+	return nil
+}
@@ -0,0 +1,126 @@
+// Copyright 2021 PingCAP, Inc. Licensed under Apache-2.0.
+
+package task
+
+import (
+	"context"
+	"crypto/sha256"
+	"os"
+	"path"
+
+	"github.com/gogo/protobuf/proto"
+	. "github.com/pingcap/check"
+	backuppb "github.com/pingcap/kvproto/pkg/backup"
+
+	"github.com/pingcap/br/pkg/metautil"
+	"github.com/pingcap/br/pkg/storage"
+)
+
+func writeDedupBackup(c *C, s storage.ExternalStorage, dir string, contents map[string]string) *backuppb.BackupMeta {
+	ctx := context.Background()
+	meta := &backuppb.BackupMeta{}
+	for name, content := range contents {
+		c.Assert(s.WriteFile(ctx, path.Join(dir, name), []byte(content)), IsNil)
+		checksum := sha256.Sum256([]byte(content))
+		meta.Files = append(meta.Files, &backuppb.File{Name: name, Sha256: checksum[:], Size_: uint64(len(content))})
+	}
+	data, err := proto.Marshal(meta)
+	c.Assert(err, IsNil)
+	c.Assert(s.WriteFile(ctx, path.Join(dir, metautil.MetaFile), data), IsNil)
+	return meta
+}
+
+func (s *testBackupSuite) TestDedupBaseFiles(c *C) {
+	ctx := context.Background()
+	dir := c.MkDir()
+	rootBackend, err := storage.ParseBackend(dir, nil)
+	c.Assert(err, IsNil)
+	root, err := storage.NewLocalStorage(dir)
+	c.Assert(err, IsNil)
+	opts := &storage.ExternalStorageOptions{}
+
+	writeDedupBackup(c, root, "full", map[string]string{"1.sst": "a", "2.sst": "b"})
+	c.Assert(metautil.RecordBackupIndex(ctx, root, metautil.BackupIndexEntry{Name: "full", EndVersion: 100}), IsNil)
+	// an unrelated full backup isn't in the chain.
+	writeDedupBackup(c, root, "other", map[string]string{"5.sst": "e"})
+	c.Assert(metautil.RecordBackupIndex(ctx, root, metautil.BackupIndexEntry{Name: "other", EndVersion: 150}), IsNil)
+
+	inc1, err := storage.NewLocalStorage(path.Join(dir, "inc1"))
+	c.Assert(err, IsNil)
+	meta := writeDedupBackup(c, root, "inc1", map[string]string{"3.sst": "a", "4.sst": "c"})
+	c.Assert(dedupBaseFiles(ctx, inc1, rootBackend, root, opts, meta, 100, nil, 2), IsNil)
+	refs, err := metautil.ReadFileRefs(ctx, inc1)
+	c.Assert(err, IsNil)
+	c.Assert(refs.Refs, DeepEquals, []metautil.FileRef{{Name: "3.sst", Backup: "full", File: "1.sst"}})
+	exists, err := inc1.FileExists(ctx, "3.sst")
+	c.Assert(err, IsNil)
+	c.Assert(exists, IsFalse)
+	c.Assert(metautil.RecordBackupIndex(ctx, root,
+		metautil.BackupIndexEntry{Name: "inc1", StartVersion: 100, EndVersion: 200}), IsNil)
+
+	// the files referenced by the base are resolved to the backups holding them.
+	inc2, err := storage.NewLocalStorage(path.Join(dir, "inc2"))
+	c.Assert(err, IsNil)
+	meta = writeDedupBackup(c, root, "inc2", map[string]string{"6.sst": "a", "7.sst": "c", "8.sst": "e"})
+	c.Assert(dedupBaseFiles(ctx, inc2, rootBackend, root, opts, meta, 200, nil, 2), IsNil)
+	refs, err = metautil.ReadFileRefs(ctx, inc2)
+	c.Assert(err, IsNil)
+	c.Assert(refs.Refs, HasLen, 2)
+	byName := make(map[string]metautil.FileRef)
+	for _, ref := range refs.Refs {
+		byName[ref.Name] = ref
+	}
+	c.Assert(byName["6.sst"], DeepEquals, metautil.FileRef{Name: "6.sst", Backup: "full", File: "1.sst"})
+	c.Assert(byName["7.sst"], DeepEquals, metautil.FileRef{Name: "7.sst", Backup: "inc1", File: "4.sst"})
+	files, err := metautil.BackupFiles(ctx, inc2)
+	c.Assert(err, IsNil)
+	c.Assert(files[0], Equals, "8.sst")
+	exists, err = inc2.FileExists(ctx, "7.sst")
+	c.Assert(err, IsNil)
+	c.Assert(exists, IsFalse)
+
+	// nothing is deduplicated without the last backup in the index.
+	c.Assert(dedupBaseFiles(ctx, inc2, rootBackend, root, opts, meta, 300, nil, 2), NotNil)
+	c.Assert(dedupBaseFiles(ctx, inc2, rootBackend, nil, opts, meta, 200, nil, 2), NotNil)
+}
+
+func (s *testBackupSuite) TestCopyDedupBackup(c *C) {
+	ctx := context.Background()
+	dir := c.MkDir()
+	rootBackend, err := storage.ParseBackend(dir, nil)
+	c.Assert(err, IsNil)
+	root, err := storage.NewLocalStorage(dir)
+	c.Assert(err, IsNil)
+
+	writeDedupBackup(c, root, "full", map[string]string{"1.sst": "a", "2.sst": "b"})
+	c.Assert(metautil.RecordBackupIndex(ctx, root, metautil.BackupIndexEntry{Name: "full", EndVersion: 100}), IsNil)
+	inc, err := storage.NewLocalStorage(path.Join(dir, "inc"))
+	c.Assert(err, IsNil)
+	meta := writeDedupBackup(c, root, "inc", map[string]string{"3.sst": "a", "4.sst": "c"})
+	c.Assert(dedupBaseFiles(ctx, inc, rootBackend, root, &storage.ExternalStorageOptions{}, meta, 100, nil, 2), IsNil)
+
+	dstDir := c.MkDir()
+	cfg := &CopyConfig{To: "local://" + dstDir}
+	cfg.Storage = "local://" + path.Join(dir, "inc")
+	result, err := RunCopy(ctx, cfg)
+	c.Assert(err, IsNil)
+	c.Assert(result.Resolved, Equals, 1)
+
+	// the copy is restored without the backups of the chain.
+	c.Assert(os.RemoveAll(path.Join(dir, "full")), IsNil)
+	dstBackend, err := storage.ParseBackend(dstDir, nil)
+	c.Assert(err, IsNil)
+	dst, err := storage.NewLocalStorage(dstDir)
+	c.Assert(err, IsNil)
+	refs, err := metautil.ReadFileRefs(ctx, dst)
+	c.Assert(err, IsNil)
+	c.Assert(refs, IsNil)
+	c.Assert(setFileSources(ctx, dstBackend, dst, nil), IsNil)
+	c.Assert(metautil.CheckDataFiles(ctx, dst, meta), IsNil)
+	for _, file := range meta.Files {
+		data, err := dst.ReadFile(ctx, file.Name)
+		c.Assert(err, IsNil)
+		checksum := sha256.Sum256(data)
+		c.Assert(checksum[:], DeepEquals, file.Sha256, Commentf("%s", file.Name))
+	}
+}
@@ -0,0 +1,298 @@
+// Copyright 2021 PingCAP, Inc. Licensed under Apache-2.0.
+
+package task
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/pingcap/errors"
+	backuppb "github.com/pingcap/kvproto/pkg/backup"
+	"github.com/pingcap/log"
+	"github.com/spf13/pflag"
+	"go.uber.org/zap"
+
+	"github.com/pingcap/br/pkg/backup"
+	berrors "github.com/pingcap/br/pkg/errors"
+	"github.com/pingcap/br/pkg/glue"
+	"github.com/pingcap/br/pkg/metautil"
+	"github.com/pingcap/br/pkg/rtree"
+	"github.com/pingcap/br/pkg/storage"
+	"github.com/pingcap/br/pkg/summary"
+	"github.com/pingcap/br/pkg/utils"
+)
+
+const (
+	flagRangeRetryTimes      = "range-retry-times"
+	flagTolerateFailedRanges = "tolerate-failed-ranges"
+	flagFillGaps             = "fill-gaps"
+	flagAllowPartial         = "allow-partial"
+)
+
+// RangeRetryConfig is the configuration of retrying the failed ranges of the
+// backup, and filling the ranges failed after all retries.
+type RangeRetryConfig struct {
+	RangeRetryTimes      int  `json:"range-retry-times" toml:"range-retry-times"`
+	TolerateFailedRanges bool `json:"tolerate-failed-ranges" toml:"tolerate-failed-ranges"`
+	// FillGaps backs up the failed ranges recorded in the storage again,
+	// instead of starting a new backup.
+	FillGaps bool `json:"fill-gaps" toml:"fill-gaps"`
+	// AllowPartial skips the regions still failing after all retries instead
+	// of the whole ranges, and finishes the backup without them.
+	AllowPartial bool `json:"allow-partial" toml:"allow-partial"`
+}
+
+func defineRangeRetryFlags(flags *pflag.FlagSet) {
+	flags.Int(flagRangeRetryTimes, backup.DefaultRangeRetryTimes,
+		"the times of retrying a range with backoff if it fails, e.g. by the failures of the stores, "+
+			"before aborting the backup")
+	flags.Bool(flagTolerateFailedRanges, false,
+		"record the ranges still failing after all retries in the storage instead of aborting the backup, "+
+			"the other ranges are backed up, and the backup fails as incomplete until the gaps are filled by --"+
+			flagFillGaps)
+	flags.Bool(flagFillGaps, false,
+		"back up the failed ranges of the incomplete backup in the storage again at its backup ts, "+
+			"it must be done before the GC safe point passes the backup ts")
+	flags.Bool(flagAllowPartial, false,
+		"skip the regions still failing after all retries, e.g. by a corrupted peer, and finish the backup "+
+			"without them, the missing ranges and the tables they belong to are reported in "+
+			metautil.PartialReportFile+" of the storage, and can be filled by --"+flagFillGaps+" later. "+
+			"it implies --"+flagTolerateFailedRanges)
+}
+
+func (cfg *RangeRetryConfig) parseFromFlags(flags *pflag.FlagSet) error {
+	var err error
+	if cfg.RangeRetryTimes, err = flags.GetInt(flagRangeRetryTimes); err != nil {
+		return errors.Trace(err)
+	}
+	if cfg.RangeRetryTimes < 0 {
+		return errors.Annotatef(berrors.ErrInvalidArgument, "--%s must not be negative", flagRangeRetryTimes)
+	}
+	if cfg.TolerateFailedRanges, err = flags.GetBool(flagTolerateFailedRanges); err != nil {
+		return errors.Trace(err)
+	}
+	if cfg.FillGaps, err = flags.GetBool(flagFillGaps); err != nil {
+		return errors.Trace(err)
+	}
+	if cfg.AllowPartial, err = flags.GetBool(flagAllowPartial); err != nil {
+		return errors.Trace(err)
+	}
+	if cfg.AllowPartial {
+		cfg.TolerateFailedRanges = true
+	}
+	return nil
+}
+
+// writeFailedRanges records the failed ranges of the backup, and returns the
+// error of the incomplete backup.
+func writeFailedRanges(
+	ctx context.Context,
+	s storage.ExternalStorage,
+	startVersion, endVersion uint64,
+	ranges []metautil.FailedRange,
+) error {
+	failed := &metautil.FailedRanges{StartVersion: startVersion, EndVersion: endVersion, Ranges: ranges}
+	if err := metautil.WriteFailedRanges(ctx, s, failed); err != nil {
+		return errors.Trace(err)
+	}
+	summary.CollectInt("failed ranges", len(ranges))
+	return errors.Annotatef(berrors.ErrBackupIncomplete,
+		"%d ranges failed to be backed up, fill them by running the backup with --%s "+
+			"before the GC safe point passes %d", len(ranges), flagFillGaps, endVersion)
+}
+
+// writePartialReport records the failed ranges of the backup finished without
+// them, and the report of the missing data.
+func writePartialReport(
+	ctx context.Context,
+	s storage.ExternalStorage,
+	schemas *backup.Schemas,
+	startVersion, endVersion uint64,
+	ranges []metautil.FailedRange,
+) error {
+	failed := &metautil.FailedRanges{StartVersion: startVersion, EndVersion: endVersion, Ranges: ranges}
+	if err := metautil.WriteFailedRanges(ctx, s, failed); err != nil {
+		return errors.Trace(err)
+	}
+	report := &metautil.PartialReport{
+		EndVersion:    endVersion,
+		Time:          time.Now(),
+		MissingRanges: ranges,
+		Tables:        schemas.MissingTables(ranges),
+	}
+	if err := metautil.WritePartialReport(ctx, s, report); err != nil {
+		return errors.Trace(err)
+	}
+	tables := make([]string, 0, len(report.Tables))
+	for _, table := range report.Tables {
+		tables = append(tables, utils.EncloseDBAndTable(table.DB, table.Table))
+	}
+	log.Warn("the backup finished without the failed ranges, the data of the tables is partially missing",
+		zap.Int("missing-ranges", len(ranges)), zap.Strings("tables", tables))
+	summary.CollectInt("missing ranges", len(ranges))
+	summary.CollectInt("tables with missing data", len(report.Tables))
+	summary.CollectArtifact("partial report", strings.TrimSuffix(s.URI(), "/")+"/"+metautil.PartialReportFile)
+	return nil
+}
+
+// runBackupFillGaps backs up the failed ranges of the incomplete backup in the
+// storage at its backup ts, and adds the files to its backupmeta.
+func runBackupFillGaps(c context.Context, g glue.Glue, cmdName string, cfg *BackupConfig) (err error) {
+	defer summary.Summary(cmdName)
+	ctx, cancel := context.WithCancel(c)
+	defer cancel()
+
+	u, err := storage.ParseBackend(cfg.Storage, &cfg.BackendOptions)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	mgr, err := NewMgr(ctx, g, cfg.PD, cfg.TLS, GetKeepalive(&cfg.Config), cfg.Timeout, cfg.CheckRequirements, false)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	defer mgr.Close()
+	client, err := backup.NewBackupClient(ctx, mgr)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	opts := backupStorageOptions(&cfg.Config)
+	if err = client.ReopenStorage(ctx, u, opts); err != nil {
+		return errors.Trace(err)
+	}
+	s := client.GetStorage()
+	taskLock, err := acquireTaskLock(ctx, s, cmdName, cfg.TaskLockTTL, cancel)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	defer func() {
+		if lostErr := taskLock.release(); lostErr != nil {
+			err = lostErr
+		}
+	}()
+	failed, err := metautil.ReadFailedRanges(ctx, s)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if failed == nil || len(failed.Ranges) == 0 {
+		return errors.Annotatef(berrors.ErrInvalidArgument, "no failed range is recorded in %s", s.URI())
+	}
+	_, _, backupMeta, err := ReadBackupMeta(ctx, metautil.MetaFile, &cfg.Config)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if backupMeta.EndVersion != failed.EndVersion || backupMeta.IsRawKv {
+		return errors.Annotatef(berrors.ErrInvalidMetaFile,
+			"the failed ranges at %d don't belong to the backup at %d", failed.EndVersion, backupMeta.EndVersion)
+	}
+	files, err := metautil.NewMetaReader(backupMeta, s).ReadDataFiles(ctx)
+	if err != nil {
+		return errors.Trace(err)
+	}
+
+	client.SetGCTTL(cfg.GCTTL)
+	sp := utils.BRServiceSafePoint{
+		BackupTS: failed.EndVersion,
+		TTL:      client.GetGCTTL(),
+		ID:       utils.MakeSafePointID(),
+	}
+	if failed.StartVersion > 0 {
+		sp.BackupTS = failed.StartVersion
+	}
+	stopSafePoint, err := utils.StartServiceSafePointKeeper(ctx, mgr.GetPDClient(), sp)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	defer func() {
+		stopSafePoint()
+		if e := utils.RemoveServiceSafePoint(context.Background(), mgr.GetPDClient(), sp); e != nil {
+			log.Warn("failed to remove the service safe point, it's kept until the TTL expires",
+				zap.Object("safePoint", sp), zap.Error(e))
+		}
+	}()
+
+	ranges := make([]rtree.Range, 0, len(failed.Ranges))
+	for _, r := range failed.Ranges {
+		ranges = append(ranges, rtree.Range{StartKey: r.StartKey, EndKey: r.EndKey})
+	}
+	log.Info("fill the gaps of the backup",
+		zap.Int("ranges", len(ranges)), zap.Uint64("backup-ts", failed.EndVersion))
+	summary.CollectInt("backup total ranges", len(ranges))
+	req := backuppb.BackupRequest{
+		ClusterId:        client.GetClusterID(),
+		StartVersion:     failed.StartVersion,
+		EndVersion:       failed.EndVersion,
+		RateLimit:        cfg.RateLimit,
+		Concurrency:      defaultBackupConcurrency,
+		CompressionType:  cfg.CompressionType,
+		CompressionLevel: cfg.CompressionLevel,
+	}
+	client.SetRangeRetry(cfg.RangeRetryTimes, cfg.TolerateFailedRanges)
+	client.SetAllowPartial(cfg.AllowPartial)
+	defer registerBackup(client)()
+
+	// the backupmeta refers to the files of both runs after it's rewritten.
+	writer := metautil.NewFillGapsMetaWriter(s, metautil.MetaFileSize, backupMeta)
+	writer.StartWriteMetasAsync(ctx, metautil.AppendDataFile)
+	for _, file := range files {
+		if err = writer.Send([]*backuppb.File{file}, metautil.AppendDataFile); err != nil {
+			return errors.Trace(err)
+		}
+	}
+	updateCh := g.StartProgress(ctx, cmdName, int64(len(ranges)), !cfg.LogProgress)
+	err = client.BackupRanges(ctx, ranges, req, uint(cfg.Concurrency), writer, func(unit backup.ProgressUnit) {
+		if unit == backup.RangeUnit {
+			updateCh.Inc()
+		}
+	})
+	updateCh.Close()
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if err = writer.FinishWriteMetas(ctx, metautil.AppendDataFile); err != nil {
+		return errors.Trace(err)
+	}
+	if cfg.ChecksumManifest {
+		err = metautil.WriteChecksumManifest(ctx, s, writer.Backupmeta(), uint(cfg.Concurrency))
+		if err != nil {
+			return errors.Trace(err)
+		}
+	}
+	if err = storage.LockS3Objects(ctx, u, opts, uint(cfg.Concurrency)); err != nil {
+		return errors.Trace(err)
+	}
+	g.Record(summary.BackupDataSize, writer.ArchiveSize())
+
+	if remaining := client.FailedRanges(); len(remaining) > 0 {
+		return errors.Trace(writeFailedRanges(ctx, s, failed.StartVersion, failed.EndVersion, remaining))
+	}
+	if err = s.DeleteFile(ctx, metautil.FailedRangesFile); err != nil {
+		return errors.Trace(err)
+	}
+	exists, err := s.FileExists(ctx, metautil.PartialReportFile)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if exists {
+		if err = s.DeleteFile(ctx, metautil.PartialReportFile); err != nil {
+			return errors.Trace(err)
+		}
+	}
+	// the backup is complete, index it like the other backups.
+	indexStorage, backupName, err := openBackupIndexStorage(ctx, u, opts)
+	if err == nil {
+		err = metautil.RecordBackupIndex(ctx, indexStorage, metautil.BackupIndexEntry{
+			Name:         backupName,
+			StartVersion: failed.StartVersion,
+			EndVersion:   failed.EndVersion,
+			Time:         time.Now(),
+		})
+	}
+	if err != nil {
+		log.Warn("failed to record the backup index, the next backup can't find this one by --lastbackupts=auto",
+			zap.Error(err))
+	}
+	summary.CollectArtifact("backup", s.URI())
+	summary.SetSuccessStatus(true)
+	return nil
+}
@@ -0,0 +1,127 @@
+// Copyright 2021 PingCAP, Inc. Licensed under Apache-2.0.
+
+package task
+
+import (
+	"context"
+	"net/url"
+	"sort"
+
+	"github.com/pingcap/errors"
+	backuppb "github.com/pingcap/kvproto/pkg/backup"
+	"github.com/pingcap/log"
+	"github.com/pingcap/tidb/statistics/handle"
+	"go.uber.org/zap"
+
+	"github.com/pingcap/br/pkg/backup"
+	"github.com/pingcap/br/pkg/conn"
+	"github.com/pingcap/br/pkg/glue"
+	"github.com/pingcap/br/pkg/metautil"
+	"github.com/pingcap/br/pkg/rtree"
+	"github.com/pingcap/br/pkg/storage"
+	"github.com/pingcap/br/pkg/utils"
+)
+
+// dbBackup is the backup of a database, whose data files and standalone
+// backupmeta are stored in a sub directory named after the database, so the
+// database can be copied or restored by syncing the sub directory only.
+type dbBackup struct {
+	name    string
+	dir     string
+	backend *backuppb.StorageBackend
+	storage storage.ExternalStorage
+	schemas *backup.Schemas
+	ranges  []rtree.Range
+	writer  *metautil.MetaWriter
+}
+
+// newDBBackups splits the schemas and ranges by databases, and prepares the
+// sub directories of the databases.
+func newDBBackups(
+	ctx context.Context,
+	backend *backuppb.StorageBackend,
+	opts *storage.ExternalStorageOptions,
+	schemas *backup.Schemas,
+	ranges []rtree.Range,
+	useV2Meta bool,
+) ([]*dbBackup, error) {
+	dbSchemas, dbRanges := schemas.SplitByDB(ranges)
+	names := make([]string, 0, len(dbSchemas))
+	for name := range dbSchemas {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	dbs := make([]*dbBackup, 0, len(names))
+	for _, name := range names {
+		dir := url.PathEscape(name)
+		sub, err := storage.SubBackend(backend, dir)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		s, err := storage.New(ctx, sub, opts)
+		if err != nil {
+			return nil, errors.Annotatef(err, "failed to create storage for database %s", name)
+		}
+		dbs = append(dbs, &dbBackup{
+			name:    name,
+			dir:     dir,
+			backend: sub,
+			storage: s,
+			schemas: dbSchemas[name],
+			ranges:  dbRanges[name],
+			writer:  metautil.NewMetaWriter(s, metautil.MetaFileSize, useV2Meta),
+		})
+	}
+	return dbs, nil
+}
+
+// backupRanges backs up the ranges of the database to its sub directory,
+// and writes the data files to its backupmeta.
+func (db *dbBackup) backupRanges(
+	ctx context.Context,
+	client *backup.Client,
+	req backuppb.BackupRequest,
+	concurrency uint,
+	updateMeta func(m *backuppb.BackupMeta),
+	progressCallBack func(backup.ProgressUnit),
+) error {
+	log.Info("backup database", zap.String("db", db.name), zap.String("dir", db.dir),
+		zap.Int("ranges", len(db.ranges)))
+	req.StorageBackend = db.backend
+	db.writer.StartWriteMetasAsync(ctx, metautil.AppendDataFile)
+	err := client.BackupRanges(ctx, db.ranges, req, concurrency, db.writer, progressCallBack)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if err = db.writer.FinishWriteMetas(ctx, metautil.AppendDataFile); err != nil {
+		return errors.Trace(err)
+	}
+	db.writer.Update(updateMeta)
+	return nil
+}
+
+// backupSchemas writes the schemas of the database to its backupmeta.
+func (db *dbBackup) backupSchemas(
+	ctx context.Context,
+	mgr *conn.Mgr,
+	statsHandle *handle.Handle,
+	backupTS uint64,
+	cfg *BackupConfig,
+	skipChecksum bool,
+	updateCh glue.Progress,
+) error {
+	concurrency := uint(utils.MinInt(backup.DefaultSchemaConcurrency, db.schemas.Len()))
+	return db.schemas.BackupSchemas(ctx, db.writer, mgr.GetStorage(), statsHandle, backupTS,
+		concurrency, cfg.ChecksumConcurrency, skipChecksum, updateCh)
+}
+
+// mergeDBBackups writes the data files and schemas of the databases to the
+// root backupmeta, so the whole backup can still be restored at once.
+func mergeDBBackups(ctx context.Context, writer *metautil.MetaWriter, dbs []*dbBackup) error {
+	subs := make(map[string]*metautil.MetaReader, len(dbs))
+	for _, db := range dbs {
+		subs[db.dir] = metautil.NewMetaReader(db.writer.Backupmeta(), db.storage)
+	}
+	return errors.Trace(metautil.MergeSubMetas(ctx, writer, subs))
+}
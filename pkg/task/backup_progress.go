@@ -0,0 +1,187 @@
+// Copyright 2021 PingCAP, Inc. Licensed under Apache-2.0.
+
+package task
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/pingcap/errors"
+	"github.com/pingcap/log"
+	"go.uber.org/zap"
+
+	"github.com/pingcap/br/pkg/backup"
+	berrors "github.com/pingcap/br/pkg/errors"
+	"github.com/pingcap/br/pkg/metautil"
+	"github.com/pingcap/br/pkg/storage"
+)
+
+const (
+	flagProgressInterval    = "progress-interval"
+	defaultProgressInterval = 30 * time.Second
+
+	// the phases of the backup progress.
+	progressPhasePrepare  = "prepare"
+	progressPhaseBackup   = "backup"
+	progressPhaseChecksum = "checksum"
+)
+
+// progressReporter writes the snapshots of the backup progress to the storage
+// periodically, which are read by `br backup status`.
+type progressReporter struct {
+	storage  storage.ExternalStorage
+	client   *backup.Client
+	interval time.Duration
+	start    time.Time
+
+	completed int64
+	mu        sync.Mutex
+	phase     string
+	unit      backup.ProgressUnit
+	total     int64
+	// backupStart is when the ranges start to be backed up.
+	backupStart time.Time
+
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// startProgressReporter starts to write the snapshots by the interval, it's
+// nil if the interval is 0.
+func startProgressReporter(
+	ctx context.Context,
+	s storage.ExternalStorage,
+	client *backup.Client,
+	interval time.Duration,
+) *progressReporter {
+	if interval <= 0 {
+		return nil
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	r := &progressReporter{
+		storage:  s,
+		client:   client,
+		interval: interval,
+		start:    time.Now(),
+		phase:    progressPhasePrepare,
+		cancel:   cancel,
+		done:     make(chan struct{}),
+	}
+	go r.run(ctx)
+	return r
+}
+
+func (r *progressReporter) run(ctx context.Context) {
+	defer close(r.done)
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+	for {
+		r.write(ctx, metautil.ProgressRunning, nil)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// startBackup enters the phase of backing up the ranges of the total units.
+func (r *progressReporter) startBackup(unit backup.ProgressUnit, total int64) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.phase = progressPhaseBackup
+	r.unit = unit
+	r.total = total
+	r.backupStart = time.Now()
+}
+
+// inc completes a unit of the ranges.
+func (r *progressReporter) inc() {
+	if r == nil {
+		return
+	}
+	atomic.AddInt64(&r.completed, 1)
+}
+
+// setPhase enters the phase after backing up the ranges.
+func (r *progressReporter) setPhase(phase string) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.phase = phase
+}
+
+// finish stops the snapshots and writes the final one by the error of the
+// backup.
+func (r *progressReporter) finish(err error) {
+	if r == nil {
+		return
+	}
+	r.cancel()
+	<-r.done
+	state := metautil.ProgressFinished
+	if err != nil {
+		state = metautil.ProgressFailed
+	}
+	// write it even if the backup is canceled.
+	r.write(context.Background(), state, err)
+}
+
+func (r *progressReporter) snapshot(state string, err error) *metautil.BackupProgress {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	now := time.Now()
+	progress := &metautil.BackupProgress{
+		State:      state,
+		Phase:      r.phase,
+		Unit:       string(r.unit),
+		Total:      r.total,
+		Completed:  atomic.LoadInt64(&r.completed),
+		Size:       r.client.BackedUpSize(),
+		StartTime:  r.start,
+		UpdateTime: now,
+		Interval:   r.interval,
+	}
+	if r.phase == progressPhaseBackup && state == metautil.ProgressRunning {
+		progress.ETA = metautil.EstimateETA(now.Sub(r.backupStart), progress.Completed, progress.Total)
+	}
+	if err != nil {
+		progress.Error = err.Error()
+	}
+	return progress
+}
+
+func (r *progressReporter) write(ctx context.Context, state string, err error) {
+	if e := metautil.WriteBackupProgress(ctx, r.storage, r.snapshot(state, err)); e != nil && ctx.Err() == nil {
+		log.Warn("failed to write the backup progress", zap.Error(e))
+	}
+}
+
+// ReadBackupStatus reads the snapshot of the progress of the backup in the
+// storage.
+func ReadBackupStatus(c context.Context, cfg *Config) (*metautil.BackupProgress, error) {
+	ctx, cancel := context.WithCancel(c)
+	defer cancel()
+
+	_, s, err := GetStorage(ctx, cfg)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	progress, err := metautil.ReadBackupProgress(ctx, s)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	if progress == nil {
+		return nil, errors.Annotatef(berrors.ErrInvalidArgument,
+			"no backup progress is found in %s, the backup may be taken by an old version or with --%s=0",
+			s.URI(), flagProgressInterval)
+	}
+	return progress, nil
+}
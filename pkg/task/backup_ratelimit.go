@@ -0,0 +1,242 @@
+// Copyright 2021 PingCAP, Inc. Licensed under Apache-2.0.
+
+package task
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/pingcap/errors"
+	"github.com/pingcap/log"
+	"github.com/spf13/pflag"
+	"go.uber.org/zap"
+
+	"github.com/pingcap/br/pkg/backup"
+	berrors "github.com/pingcap/br/pkg/errors"
+	"github.com/pingcap/br/pkg/httputil"
+)
+
+const (
+	flagOperatorAddr = "addr"
+	flagOperatorJob  = "job"
+)
+
+var (
+	runningBackupMu  sync.Mutex
+	runningBackupSeq uint64
+	// runningBackups are the clients of the running backups of the process by
+	// the job IDs.
+	runningBackups = make(map[string]*backup.Client)
+)
+
+// BackupRateLimit is the rate limit of each store of a running backup.
+type BackupRateLimit struct {
+	Job string `json:"job"`
+	// RateLimit is the rate limit in bytes per second, 0 means unlimited.
+	RateLimit uint64 `json:"rate-limit"`
+	// Set is whether the rate limit is set while backing up, it's the one of
+	// the config otherwise.
+	Set bool `json:"set"`
+}
+
+// The status server lists the running backups at `GET /backup/jobs`, serves
+// the rate limit of each store of a running backup at
+// `GET /backup/ratelimit?job=<id>`, and changes it by
+// `POST /backup/ratelimit?job=<id>&rate=<bytes per second>`. The job can be
+// omitted if there is only one running backup.
+func init() { // nolint:gochecknoinits
+	http.HandleFunc("/backup/jobs", handleBackupJobs)
+	http.HandleFunc("/backup/ratelimit", handleBackupRateLimit)
+}
+
+// registerBackup registers the client of a running backup to the status
+// server, and returns the function unregistering it.
+func registerBackup(client *backup.Client) func() {
+	runningBackupMu.Lock()
+	runningBackupSeq++
+	jobID := strconv.FormatUint(runningBackupSeq, 10)
+	runningBackups[jobID] = client
+	runningBackupMu.Unlock()
+	log.Info("backup registered to the status server", zap.String("job", jobID))
+	return func() {
+		runningBackupMu.Lock()
+		delete(runningBackups, jobID)
+		runningBackupMu.Unlock()
+	}
+}
+
+// getRunningBackup returns the running backup of the job, or the only one if
+// the job is empty, the error is written to the response if not found.
+func getRunningBackup(w http.ResponseWriter, jobID string) (string, *backup.Client) {
+	runningBackupMu.Lock()
+	defer runningBackupMu.Unlock()
+	if jobID == "" {
+		switch len(runningBackups) {
+		case 0:
+		case 1:
+			for id, client := range runningBackups {
+				return id, client
+			}
+		default:
+			writeJSONError(w, http.StatusBadRequest, "there are many running backups, job is required")
+			return "", nil
+		}
+	}
+	client, ok := runningBackups[jobID]
+	if !ok {
+		writeJSONError(w, http.StatusNotFound, "no running backup")
+		return "", nil
+	}
+	return jobID, client
+}
+
+func handleBackupJobs(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if req.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		writeJSONError(w, http.StatusMethodNotAllowed, "only GET is allowed")
+		return
+	}
+	runningBackupMu.Lock()
+	jobs := make([]string, 0, len(runningBackups))
+	for jobID := range runningBackups {
+		jobs = append(jobs, jobID)
+	}
+	runningBackupMu.Unlock()
+	sort.Strings(jobs)
+	w.WriteHeader(http.StatusOK)
+	_ = json.NewEncoder(w).Encode(jobs)
+}
+
+func handleBackupRateLimit(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if req.Method != http.MethodGet && req.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodGet+", "+http.MethodPost)
+		writeJSONError(w, http.StatusMethodNotAllowed, "only GET and POST are allowed")
+		return
+	}
+	jobID, client := getRunningBackup(w, req.URL.Query().Get("job"))
+	if client == nil {
+		return
+	}
+	if req.Method == http.MethodPost {
+		rate, err := strconv.ParseUint(req.URL.Query().Get("rate"), 10, 64)
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, "rate must be the bytes per second")
+			return
+		}
+		client.SetStoreRateLimit(rate)
+		log.Info("backup rate limit set by the status server", zap.String("job", jobID),
+			zap.Uint64("rate", rate), zap.String("remote", req.RemoteAddr))
+	}
+	rate, set := client.StoreRateLimit()
+	w.WriteHeader(http.StatusOK)
+	_ = json.NewEncoder(w).Encode(BackupRateLimit{Job: jobID, RateLimit: rate, Set: set})
+}
+
+// SetBackupRateLimitConfig is the config of changing the rate limit of a
+// running backup by its status server.
+type SetBackupRateLimitConfig struct {
+	TLS TLSConfig
+	// Addr is the status address of the running BR.
+	Addr string
+	// Job is the job of the backup, it can be empty if BR runs only one.
+	Job string
+	// Token is the control token of the status server.
+	Token string
+	// RateLimit is the rate limit of each store in bytes per second, 0 means
+	// unlimited.
+	RateLimit uint64
+}
+
+// DefineSetBackupRateLimitFlags defines the flags of changing the rate limit
+// of a running backup, the rate limit is --ratelimit of the common flags.
+func DefineSetBackupRateLimitFlags(flags *pflag.FlagSet) {
+	flags.String(flagOperatorAddr, "", "the status address of the BR running the backup, i.e. its --status-addr")
+	flags.String(flagOperatorJob, "", "the job of the backup listed at /backup/jobs, "+
+		"it can be omitted if the BR runs only one backup")
+}
+
+// ParseFromFlags parses the config from the flag set, the control token of
+// the status server is read from the flag tokenFlag.
+func (cfg *SetBackupRateLimitConfig) ParseFromFlags(flags *pflag.FlagSet, tokenFlag string) error {
+	var err error
+	if cfg.Addr, err = flags.GetString(flagOperatorAddr); err != nil {
+		return errors.Trace(err)
+	}
+	if cfg.Addr == "" {
+		return errors.Annotatef(berrors.ErrInvalidArgument, "--%s is required", flagOperatorAddr)
+	}
+	if cfg.Job, err = flags.GetString(flagOperatorJob); err != nil {
+		return errors.Trace(err)
+	}
+	if cfg.Token, err = flags.GetString(tokenFlag); err != nil {
+		return errors.Trace(err)
+	}
+	rate, err := flags.GetUint64(flagRateLimit)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	unit, err := flags.GetUint64(flagRateLimitUnit)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	cfg.RateLimit = rate * unit
+	return errors.Trace(cfg.TLS.ParseFromFlags(flags))
+}
+
+// SetBackupRateLimit changes the rate limit of each store of the running
+// backup by its status server, and returns the rate limit in effect.
+func SetBackupRateLimit(ctx context.Context, cfg *SetBackupRateLimitConfig) (*BackupRateLimit, error) {
+	scheme := "http"
+	var tlsConf *tls.Config
+	if cfg.TLS.IsEnabled() {
+		var err error
+		if tlsConf, err = cfg.TLS.ToTLSConfig(); err != nil {
+			return nil, errors.Trace(err)
+		}
+		scheme = "https"
+	}
+	addr := cfg.Addr
+	if !strings.Contains(addr, "://") {
+		addr = scheme + "://" + addr
+	}
+	query := url.Values{"rate": []string{strconv.FormatUint(cfg.RateLimit, 10)}}
+	if cfg.Job != "" {
+		query.Set("job", cfg.Job)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost,
+		fmt.Sprintf("%s/backup/ratelimit?%s", strings.TrimSuffix(addr, "/"), query.Encode()), nil)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	if cfg.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+cfg.Token)
+	}
+	resp, err := httputil.NewClient(tlsConf).Do(req)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		var body struct {
+			Error string `json:"error"`
+		}
+		_ = json.NewDecoder(resp.Body).Decode(&body)
+		return nil, errors.Annotatef(berrors.ErrUnknown, "set the rate limit of the backup at %s: %s %s",
+			cfg.Addr, resp.Status, body.Error)
+	}
+	result := &BackupRateLimit{}
+	if err = json.NewDecoder(resp.Body).Decode(result); err != nil {
+		return nil, errors.Trace(err)
+	}
+	return result, nil
+}
@@ -5,6 +5,10 @@ package task
 import (
 	"bytes"
 	"context"
+	"encoding/json"
+	"os"
+	"sort"
+	"strings"
 
 	"github.com/pingcap/br/pkg/metautil"
 
@@ -19,6 +23,7 @@ import (
 	"github.com/pingcap/br/pkg/backup"
 	berrors "github.com/pingcap/br/pkg/errors"
 	"github.com/pingcap/br/pkg/glue"
+	"github.com/pingcap/br/pkg/redact"
 	"github.com/pingcap/br/pkg/rtree"
 	"github.com/pingcap/br/pkg/storage"
 	"github.com/pingcap/br/pkg/summary"
@@ -30,6 +35,8 @@ const (
 	flagTiKVColumnFamily = "cf"
 	flagStartKey         = "start"
 	flagEndKey           = "end"
+	flagRawRange         = "range"
+	flagRawRangesFile    = "ranges-file"
 )
 
 // RawKvConfig is the common config for rawkv backup and restore.
@@ -38,9 +45,16 @@ type RawKvConfig struct {
 
 	StartKey []byte `json:"start-key" toml:"start-key"`
 	EndKey   []byte `json:"end-key" toml:"end-key"`
-	CF       string `json:"cf" toml:"cf"`
+	// Ranges are the ranges of the raw backup sorted by the start keys, they
+	// replace StartKey and EndKey if not empty.
+	Ranges []rtree.Range `json:"ranges" toml:"ranges"`
+	CF     string        `json:"cf" toml:"cf"`
 	CompressionConfig
 	RemoveSchedulers bool `json:"remove-schedulers" toml:"remove-schedulers"`
+	ChecksumManifest bool `json:"checksum-manifest" toml:"checksum-manifest"`
+	// VerifyFiles is the ratio of the data files read back after the backup.
+	VerifyFiles float64 `json:"verify-files" toml:"verify-files"`
+	MirrorConfig
 }
 
 // DefineRawBackupFlags defines common flags for the backup command.
@@ -49,16 +63,42 @@ func DefineRawBackupFlags(command *cobra.Command) {
 	command.Flags().StringP(flagTiKVColumnFamily, "", "default", "backup specify cf, correspond to tikv cf")
 	command.Flags().StringP(flagStartKey, "", "", "backup raw kv start key, key is inclusive")
 	command.Flags().StringP(flagEndKey, "", "", "backup raw kv end key, key is exclusive")
+	command.Flags().StringArray(flagRawRange, nil,
+		"backup a raw kv range in the form of 'start,end' instead of --start and --end, "+
+			"the keys are in --format and the flag can be repeated to backup many ranges")
+	command.Flags().String(flagRawRangesFile, "",
+		"the path of a JSON file of the raw kv ranges to backup, "+
+			`e.g. [{"start": "61", "end": "62"}], the keys are in --format`)
 	command.Flags().String(flagCompressionType, "zstd",
 		"backup sst file compression algorithm, value can be one of 'lz4|zstd|snappy'")
 	command.Flags().Bool(flagRemoveSchedulers, false,
 		"disable the balance, shuffle and region-merge schedulers in PD to speed up backup")
 	// This flag can impact the online cluster, so hide it in case of abuse.
 	_ = command.Flags().MarkHidden(flagRemoveSchedulers)
+	command.Flags().Bool(flagChecksumManifest, true,
+		"write a manifest of the sha256 digests of all backup files, which can be verified by `br validate data`")
+	defineVerifyFilesFlag(command.Flags())
+	defineMirrorFlags(command.Flags())
 }
 
 // ParseFromFlags parses the raw kv backup&restore common flags from the flag set.
 func (cfg *RawKvConfig) ParseFromFlags(flags *pflag.FlagSet) error {
+	err := cfg.parseKeyFlags(flags)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	cfg.CF, err = flags.GetString(flagTiKVColumnFamily)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if err = cfg.Config.ParseFromFlags(flags); err != nil {
+		return errors.Trace(err)
+	}
+	return nil
+}
+
+// parseKeyFlags parses --start and --end in --format.
+func (cfg *RawKvConfig) parseKeyFlags(flags *pflag.FlagSet) error {
 	format, err := flags.GetString(flagKeyFormat)
 	if err != nil {
 		return errors.Trace(err)
@@ -83,13 +123,6 @@ func (cfg *RawKvConfig) ParseFromFlags(flags *pflag.FlagSet) error {
 	if len(cfg.StartKey) > 0 && len(cfg.EndKey) > 0 && bytes.Compare(cfg.StartKey, cfg.EndKey) >= 0 {
 		return errors.Annotate(berrors.ErrBackupInvalidRange, "endKey must be greater than startKey")
 	}
-	cfg.CF, err = flags.GetString(flagTiKVColumnFamily)
-	if err != nil {
-		return errors.Trace(err)
-	}
-	if err = cfg.Config.ParseFromFlags(flags); err != nil {
-		return errors.Trace(err)
-	}
 	return nil
 }
 
@@ -99,7 +132,12 @@ func (cfg *RawKvConfig) ParseBackupConfigFromFlags(flags *pflag.FlagSet) error {
 	if err != nil {
 		return errors.Trace(err)
 	}
+	return errors.Trace(cfg.parseBackupOptionsFromFlags(flags))
+}
 
+// parseBackupOptionsFromFlags parses the backup options shared by the raw kv
+// and the txn kv backup.
+func (cfg *RawKvConfig) parseBackupOptionsFromFlags(flags *pflag.FlagSet) error {
 	compressionCfg, err := parseCompressionFlags(flags)
 	if err != nil {
 		return errors.Trace(err)
@@ -110,13 +148,113 @@ func (cfg *RawKvConfig) ParseBackupConfigFromFlags(flags *pflag.FlagSet) error {
 	if err != nil {
 		return errors.Trace(err)
 	}
+	cfg.ChecksumManifest, err = flags.GetBool(flagChecksumManifest)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	cfg.VerifyFiles, err = parseVerifyFilesFlag(flags)
+	if err != nil {
+		return errors.Trace(err)
+	}
 	level, err := flags.GetInt32(flagCompressionLevel)
 	if err != nil {
 		return errors.Trace(err)
 	}
 	cfg.CompressionLevel = level
+	if err = cfg.MirrorConfig.parseFromFlags(flags); err != nil {
+		return errors.Trace(err)
+	}
+	return errors.Trace(cfg.parseRangesFromFlags(flags))
+}
 
-	return nil
+// rawRangeJSON is a raw kv range in the ranges file.
+type rawRangeJSON struct {
+	Start string `json:"start"`
+	End   string `json:"end"`
+}
+
+// parseRangesFromFlags parses the ranges of --range and --ranges-file.
+func (cfg *RawKvConfig) parseRangesFromFlags(flags *pflag.FlagSet) error {
+	format, err := flags.GetString(flagKeyFormat)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	specs, err := flags.GetStringArray(flagRawRange)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	file, err := flags.GetString(flagRawRangesFile)
+	if err != nil {
+		return errors.Trace(err)
+	}
+
+	var items []rawRangeJSON
+	for _, spec := range specs {
+		parts := strings.Split(spec, ",")
+		if len(parts) != 2 {
+			return errors.Annotatef(berrors.ErrInvalidArgument, "--%s %q should be in the form of 'start,end'",
+				flagRawRange, spec)
+		}
+		items = append(items, rawRangeJSON{Start: parts[0], End: parts[1]})
+	}
+	if file != "" {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return errors.Annotatef(err, "failed to read the ranges file %s", file)
+		}
+		var fileItems []rawRangeJSON
+		if err = json.Unmarshal(data, &fileItems); err != nil {
+			return errors.Annotatef(berrors.ErrInvalidArgument, "failed to parse the ranges file %s: %v", file, err)
+		}
+		items = append(items, fileItems...)
+	}
+	if len(items) == 0 {
+		return nil
+	}
+	if len(cfg.StartKey) > 0 || len(cfg.EndKey) > 0 {
+		return errors.Annotatef(berrors.ErrInvalidArgument, "--%s and --%s can't be used with --%s or --%s",
+			flagRawRange, flagRawRangesFile, flagStartKey, flagEndKey)
+	}
+
+	ranges := make([]rtree.Range, 0, len(items))
+	for _, item := range items {
+		var rg rtree.Range
+		if rg.StartKey, err = utils.ParseKey(format, item.Start); err != nil {
+			return errors.Trace(err)
+		}
+		if rg.EndKey, err = utils.ParseKey(format, item.End); err != nil {
+			return errors.Trace(err)
+		}
+		ranges = append(ranges, rg)
+	}
+	cfg.Ranges, err = sortRawRanges(ranges)
+	return errors.Trace(err)
+}
+
+// sortRawRanges sorts the ranges by the start keys, and checks that they're
+// valid and don't overlap, since TiKV would back up the overlapped keys twice.
+func sortRawRanges(ranges []rtree.Range) ([]rtree.Range, error) {
+	sort.Slice(ranges, func(i, j int) bool { return bytes.Compare(ranges[i].StartKey, ranges[j].StartKey) < 0 })
+	for i, rg := range ranges {
+		if len(rg.EndKey) > 0 && bytes.Compare(rg.StartKey, rg.EndKey) >= 0 {
+			return nil, errors.Annotatef(berrors.ErrBackupInvalidRange,
+				"endKey must be greater than startKey in range [%s, %s)", redact.Key(rg.StartKey), redact.Key(rg.EndKey))
+		}
+		if i > 0 && utils.CompareEndKey(ranges[i-1].EndKey, rg.StartKey) > 0 {
+			return nil, errors.Annotatef(berrors.ErrBackupInvalidRange, "range [%s, %s) overlaps with [%s, %s)",
+				redact.Key(rg.StartKey), redact.Key(rg.EndKey),
+				redact.Key(ranges[i-1].StartKey), redact.Key(ranges[i-1].EndKey))
+		}
+	}
+	return ranges, nil
+}
+
+// backupRanges returns the ranges to backup.
+func (cfg *RawKvConfig) backupRanges() []rtree.Range {
+	if len(cfg.Ranges) > 0 {
+		return cfg.Ranges
+	}
+	return []rtree.Range{{StartKey: cfg.StartKey, EndKey: cfg.EndKey}}
 }
 
 // RunBackupRaw starts a backup task inside the current goroutine.
@@ -124,8 +262,13 @@ func RunBackupRaw(c context.Context, g glue.Glue, cmdName string, cfg *RawKvConf
 	cfg.adjust()
 
 	defer summary.Summary(cmdName)
+	c, collectS3Requests := withS3RequestCounter(c)
+	defer collectS3Requests()
 	ctx, cancel := context.WithCancel(c)
 	defer cancel()
+	if err := startContinuousProfiling(ctx, &cfg.Config); err != nil {
+		return errors.Trace(err)
+	}
 
 	if span := opentracing.SpanFromContext(ctx); span != nil && span.Tracer() != nil {
 		span1 := span.Tracer().StartSpan("task.RunBackupRaw", opentracing.ChildOf(span.Context()))
@@ -139,7 +282,7 @@ func RunBackupRaw(c context.Context, g glue.Glue, cmdName string, cfg *RawKvConf
 	}
 	// Backup raw does not need domain.
 	needDomain := false
-	mgr, err := NewMgr(ctx, g, cfg.PD, cfg.TLS, GetKeepalive(&cfg.Config), cfg.CheckRequirements, needDomain)
+	mgr, err := NewMgr(ctx, g, cfg.PD, cfg.TLS, GetKeepalive(&cfg.Config), cfg.Timeout, cfg.CheckRequirements, needDomain)
 	if err != nil {
 		return errors.Trace(err)
 	}
@@ -149,16 +292,20 @@ func RunBackupRaw(c context.Context, g glue.Glue, cmdName string, cfg *RawKvConf
 	if err != nil {
 		return errors.Trace(err)
 	}
-	opts := storage.ExternalStorageOptions{
-		NoCredentials:   cfg.NoCreds,
-		SendCredentials: cfg.SendCreds,
-		SkipCheckPath:   cfg.SkipCheckPath,
+	opts := backupStorageOptions(&cfg.Config)
+	if err = client.SetStorage(ctx, u, opts); err != nil {
+		return errors.Trace(err)
 	}
-	if err = client.SetStorage(ctx, u, &opts); err != nil {
+	if err = cfg.setMirrorStorage(ctx, client, &cfg.BackendOptions, opts); err != nil {
 		return errors.Trace(err)
 	}
+	if opts.ObjectLock != nil {
+		if err = metautil.WriteRetention(ctx, client.GetStorage(), opts.ObjectLock); err != nil {
+			return errors.Trace(err)
+		}
+	}
 
-	backupRange := rtree.Range{StartKey: cfg.StartKey, EndKey: cfg.EndKey}
+	backupRanges := cfg.backupRanges()
 
 	if cfg.RemoveSchedulers {
 		restore, e := mgr.RemoveSchedulers(ctx)
@@ -183,9 +330,13 @@ func RunBackupRaw(c context.Context, g glue.Glue, cmdName string, cfg *RawKvConf
 	}
 
 	// The number of regions need to backup
-	approximateRegions, err := mgr.GetRegionCount(ctx, backupRange.StartKey, backupRange.EndKey)
-	if err != nil {
-		return errors.Trace(err)
+	approximateRegions := 0
+	for _, backupRange := range backupRanges {
+		regions, err := mgr.GetRegionCount(ctx, backupRange.StartKey, backupRange.EndKey)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		approximateRegions += regions
 	}
 
 	summary.CollectInt("backup total regions", approximateRegions)
@@ -215,13 +366,20 @@ func RunBackupRaw(c context.Context, g glue.Glue, cmdName string, cfg *RawKvConf
 	}
 	metaWriter := metautil.NewMetaWriter(client.GetStorage(), metautil.MetaFileSize, false)
 	metaWriter.StartWriteMetasAsync(ctx, metautil.AppendDataFile)
-	err = client.BackupRange(ctx, backupRange.StartKey, backupRange.EndKey, req, metaWriter, progressCallBack)
-	if err != nil {
-		return errors.Trace(err)
+	rawRanges := make([]*backuppb.RawRange, 0, len(backupRanges))
+	for _, backupRange := range backupRanges {
+		err = client.BackupRange(ctx, backupRange.StartKey, backupRange.EndKey, req, metaWriter, progressCallBack)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		rawRanges = append(rawRanges, &backuppb.RawRange{StartKey: backupRange.StartKey, EndKey: backupRange.EndKey, Cf: cfg.CF})
 	}
 	// Backup has finished
 	updateCh.Close()
-	rawRanges := []*backuppb.RawRange{{StartKey: backupRange.StartKey, EndKey: backupRange.EndKey, Cf: cfg.CF}}
+	// copy the SST files written by TiKV before the backupmeta.
+	if err = storage.SyncMirror(ctx, client.GetStorage(), uint(cfg.Concurrency)); err != nil {
+		return errors.Trace(err)
+	}
 	metaWriter.Update(func(m *backuppb.BackupMeta) {
 		m.StartVersion = req.StartVersion
 		m.EndVersion = req.EndVersion
@@ -235,8 +393,26 @@ func RunBackupRaw(c context.Context, g glue.Glue, cmdName string, cfg *RawKvConf
 	if err != nil {
 		return errors.Trace(err)
 	}
+	if err = writeBackupFeatures(ctx, client, &req); err != nil {
+		return errors.Trace(err)
+	}
+	err = verifyBackupFiles(ctx, client.GetStorage(), metaWriter.Backupmeta(), true, cfg.VerifyFiles, cfg.Concurrency)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if cfg.ChecksumManifest {
+		err = metautil.WriteChecksumManifest(ctx, client.GetStorage(), metaWriter.Backupmeta(), uint(cfg.Concurrency))
+		if err != nil {
+			return errors.Trace(err)
+		}
+	}
+	// lock the SST files written by TiKV after all files are written.
+	if err = storage.LockS3Objects(ctx, u, opts, uint(cfg.Concurrency)); err != nil {
+		return errors.Trace(err)
+	}
 	g.Record(summary.BackupDataSize, metaWriter.ArchiveSize())
 
+	summary.CollectArtifact("backup", client.GetStorage().URI())
 	// Set task summary to success status.
 	summary.SetSuccessStatus(true)
 	return nil
@@ -3,11 +3,22 @@
 package task
 
 import (
+	"context"
+	"os"
+	"path/filepath"
 	"testing"
 	"time"
 
 	. "github.com/pingcap/check"
+	"github.com/pingcap/errors"
 	backuppb "github.com/pingcap/kvproto/pkg/backup"
+	"github.com/spf13/cobra"
+	"github.com/tikv/client-go/v2/oracle"
+
+	"github.com/pingcap/br/pkg/backup"
+	"github.com/pingcap/br/pkg/metautil"
+	"github.com/pingcap/br/pkg/rtree"
+	"github.com/pingcap/br/pkg/storage"
 )
 
 var _ = Suite(&testBackupSuite{})
@@ -36,6 +47,32 @@ func (s *testBackupSuite) TestParseTSString(c *C) {
 	ts, err = parseTSString("2018-05-11 01:42:23")
 	c.Assert(err, IsNil)
 	c.Assert(int(ts), Equals, 400032515489792000-(offset*1000)<<18)
+
+	for _, str := range []string{"2018-05-11 01:42:23+08:00", "2018-05-11 01:42:23 +08:00", "2018-05-11T01:42:23+08:00"} {
+		ts, err = parseTSString(str)
+		c.Assert(err, IsNil)
+		c.Assert(int(ts), Equals, 400032515489792000-(8*3600*1000)<<18)
+	}
+	ts, err = parseTSString("2018-05-11 01:42:23.5Z")
+	c.Assert(err, IsNil)
+	c.Assert(int(ts), Equals, 400032515489792000+500<<18)
+}
+
+func (s *testBackupSuite) TestParseBackupTS(c *C) {
+	ts, ago, err := parseBackupTS("400036290571534337")
+	c.Assert(err, IsNil)
+	c.Assert(ts, Equals, uint64(400036290571534337))
+	c.Assert(ago, Equals, time.Duration(0))
+
+	ts, ago, err = parseBackupTS("-2h30m")
+	c.Assert(err, IsNil)
+	c.Assert(ts, Equals, uint64(0))
+	c.Assert(ago, Equals, 150*time.Minute)
+
+	for _, str := range []string{"-0s", "-2x", "yesterday"} {
+		_, _, err = parseBackupTS(str)
+		c.Assert(err, ErrorMatches, ".*invalid --backupts.*")
+	}
 }
 
 func (s *testBackupSuite) TestParseCompressionType(c *C) {
@@ -55,7 +92,117 @@ func (s *testBackupSuite) TestParseCompressionType(c *C) {
 	c.Assert(err, IsNil)
 	c.Assert(int(ct), Equals, 3)
 
+	c.Assert(compressionName(ct), Equals, "zstd")
+	c.Assert(checkCompressionLevel(ct, 0), IsNil)
+	c.Assert(checkCompressionLevel(ct, 22), IsNil)
+	c.Assert(checkCompressionLevel(ct, 23), ErrorMatches, ".*invalid --compression-level 23.*")
+	c.Assert(checkCompressionLevel(backuppb.CompressionType_SNAPPY, 1), ErrorMatches, ".*\\[0, 0\\] for snappy.*")
+
 	ct, err = parseCompressionType("Other Compression (strings)")
 	c.Assert(err, ErrorMatches, "invalid compression.*")
 	c.Assert(int(ct), Equals, 0)
 }
+
+func (s *testBackupSuite) TestParseRawRanges(c *C) {
+	command := &cobra.Command{}
+	DefineRawBackupFlags(command)
+	flags := command.Flags()
+	rangesFile := filepath.Join(c.MkDir(), "ranges.json")
+	c.Assert(os.WriteFile(rangesFile, []byte(`[{"start": "61", "end": "62"}]`), 0o644), IsNil)
+	c.Assert(flags.Set(flagRawRange, "63,64"), IsNil)
+	c.Assert(flags.Set(flagRawRangesFile, rangesFile), IsNil)
+
+	cfg := &RawKvConfig{}
+	c.Assert(cfg.parseRangesFromFlags(flags), IsNil)
+	c.Assert(cfg.backupRanges(), DeepEquals, []rtree.Range{
+		{StartKey: []byte("a"), EndKey: []byte("b")},
+		{StartKey: []byte("c"), EndKey: []byte("d")},
+	})
+
+	c.Assert(flags.Set(flagRawRange, "6162,"), IsNil)
+	c.Assert(cfg.parseRangesFromFlags(flags), ErrorMatches, ".*overlaps.*")
+	c.Assert(flags.Set(flagRawRange, "62"), IsNil)
+	c.Assert(cfg.parseRangesFromFlags(flags), ErrorMatches, ".*should be in the form of 'start,end'.*")
+
+	cfg = &RawKvConfig{StartKey: []byte("a"), EndKey: []byte("b")}
+	c.Assert(cfg.backupRanges(), DeepEquals, []rtree.Range{{StartKey: []byte("a"), EndKey: []byte("b")}})
+}
+
+func (s *testBackupSuite) TestCheckStorage(c *C) {
+	ctx := context.Background()
+	store, err := storage.NewLocalStorage(c.MkDir())
+	c.Assert(err, IsNil)
+	result := checkStorage(ctx, store)
+	c.Assert(result.Status, Equals, CheckPass, Commentf("%s", result.Detail))
+	exists, err := store.FileExists(ctx, metautil.LockFile)
+	c.Assert(err, IsNil)
+	c.Assert(exists, IsFalse)
+
+	c.Assert(store.WriteFile(ctx, metautil.MetaFile, []byte{}), IsNil)
+	result = checkStorage(ctx, store)
+	c.Assert(result.Status, Equals, CheckFail)
+	c.Assert(result.Detail, Matches, ".*backup meta file exists.*")
+}
+
+func (s *testBackupSuite) TestCheckHeadroomAndQuota(c *C) {
+	now := time.Now()
+	ts := oracle.GoTimeToTS(now)
+	c.Assert(gcHeadroomResult(ts, oracle.GoTimeToTS(now.Add(-time.Hour))).Status, Equals, CheckPass)
+	c.Assert(gcHeadroomResult(ts, oracle.GoTimeToTS(now.Add(-time.Minute))).Status, Equals, CheckWarn)
+	c.Assert(gcHeadroomResult(ts, ts).Status, Equals, CheckFail)
+
+	status, _ := storageQuotaStatus(100, 0)
+	c.Assert(status, Equals, CheckPass)
+	status, _ = storageQuotaStatus(100, 200)
+	c.Assert(status, Equals, CheckPass)
+	status, _ = storageQuotaStatus(190, 200)
+	c.Assert(status, Equals, CheckWarn)
+	status, detail := storageQuotaStatus(300, 200)
+	c.Assert(status, Equals, CheckFail)
+	c.Assert(detail, Matches, "exceeds the storage quota.*")
+}
+
+func (s *testBackupSuite) TestRenderBackupPath(c *C) {
+	now := time.Date(2021, 10, 1, 8, 30, 5, 0, time.UTC)
+	backupPath := renderBackupPath("{cluster_id}/{date}/{backup_id}", 6880, now)
+	id := backupPath.Placeholders[metautil.PlaceholderBackupID]
+	c.Assert(id, Not(Equals), "")
+	c.Assert(backupPath.Path, Equals, "6880/20211001/"+id)
+
+	dir := c.MkDir()
+	root, err := storage.ParseBackend("local://"+dir, nil)
+	c.Assert(err, IsNil)
+	u, err := storage.SubBackend(root, backupPath.Path)
+	c.Assert(err, IsNil)
+	c.Assert(u.GetLocal().Path, Equals, filepath.Join(dir, "6880", "20211001", id))
+
+	// the other backup started at the same time gets another directory.
+	c.Assert(renderBackupPath("{cluster_id}/{date}/{backup_id}", 6880, now).Path, Not(Equals), backupPath.Path)
+}
+
+func (s *testBackupSuite) TestProgressReporter(c *C) {
+	ctx := context.Background()
+	store, err := storage.NewLocalStorage(c.MkDir())
+	c.Assert(err, IsNil)
+	c.Assert(startProgressReporter(ctx, store, &backup.Client{}, 0), IsNil)
+
+	r := startProgressReporter(ctx, store, &backup.Client{}, time.Hour)
+	r.startBackup(backup.RangeUnit, 4)
+	r.inc()
+	r.inc()
+	progress := r.snapshot(metautil.ProgressRunning, nil)
+	c.Assert(progress.Phase, Equals, progressPhaseBackup)
+	c.Assert(progress.Completed, Equals, int64(2))
+	c.Assert(progress.Total, Equals, int64(4))
+
+	r.setPhase(progressPhaseChecksum)
+	r.finish(errors.New("checksum mismatched"))
+	progress, err = metautil.ReadBackupProgress(ctx, store)
+	c.Assert(err, IsNil)
+	c.Assert(progress.State, Equals, metautil.ProgressFailed)
+	c.Assert(progress.Phase, Equals, progressPhaseChecksum)
+	c.Assert(progress.Unit, Equals, "range")
+	c.Assert(progress.Completed, Equals, int64(2))
+	c.Assert(progress.Error, Equals, "checksum mismatched")
+	c.Assert(progress.ETA, Equals, time.Duration(0))
+}
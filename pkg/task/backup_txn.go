@@ -0,0 +1,312 @@
+// Copyright 2021 PingCAP, Inc. Licensed under Apache-2.0.
+
+package task
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/opentracing/opentracing-go"
+	"github.com/pingcap/errors"
+	backuppb "github.com/pingcap/kvproto/pkg/backup"
+	"github.com/pingcap/log"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"go.uber.org/zap"
+
+	"github.com/pingcap/br/pkg/backup"
+	berrors "github.com/pingcap/br/pkg/errors"
+	"github.com/pingcap/br/pkg/glue"
+	"github.com/pingcap/br/pkg/metautil"
+	"github.com/pingcap/br/pkg/storage"
+	"github.com/pingcap/br/pkg/summary"
+	"github.com/pingcap/br/pkg/utils"
+)
+
+// TxnKvConfig is the config for the backup of a TiKV cluster used via the
+// TxnKV client, the versions of the keys are kept in the backup.
+type TxnKvConfig struct {
+	RawKvConfig
+
+	BackupTS     uint64        `json:"backup-ts" toml:"backup-ts"`
+	LastBackupTS uint64        `json:"last-backup-ts" toml:"last-backup-ts"`
+	TimeAgo      time.Duration `json:"time-ago" toml:"time-ago"`
+	GCTTL        int64         `json:"gc-ttl" toml:"gc-ttl"`
+}
+
+// DefineTxnBackupFlags defines common flags for the txn kv backup command.
+func DefineTxnBackupFlags(command *cobra.Command) {
+	command.Flags().StringP(flagKeyFormat, "", "hex", "start/end key format, support raw|escaped|hex")
+	command.Flags().StringP(flagStartKey, "", "", "backup txn kv start key, key is inclusive")
+	command.Flags().StringP(flagEndKey, "", "", "backup txn kv end key, key is exclusive")
+	command.Flags().StringArray(flagRawRange, nil,
+		"backup a txn kv range in the form of 'start,end' instead of --start and --end, "+
+			"the keys are in --format and the flag can be repeated to backup many ranges")
+	command.Flags().String(flagRawRangesFile, "",
+		"the path of a JSON file of the txn kv ranges to backup, "+
+			`e.g. [{"start": "61", "end": "62"}], the keys are in --format`)
+	command.Flags().String(flagCompressionType, "zstd",
+		"backup sst file compression algorithm, value can be one of 'lz4|zstd|snappy'")
+	command.Flags().Bool(flagRemoveSchedulers, false,
+		"disable the balance, shuffle and region-merge schedulers in PD to speed up backup")
+	// This flag can impact the online cluster, so hide it in case of abuse.
+	_ = command.Flags().MarkHidden(flagRemoveSchedulers)
+	command.Flags().Bool(flagChecksumManifest, true,
+		"write a manifest of the sha256 digests of all backup files, which can be verified by `br validate data`")
+	defineVerifyFilesFlag(command.Flags())
+	defineMirrorFlags(command.Flags())
+}
+
+// ParseFromFlags parses the txn kv backup flags from the flag set.
+func (cfg *TxnKvConfig) ParseFromFlags(flags *pflag.FlagSet) error {
+	err := cfg.parseKeyFlags(flags)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if err = cfg.Config.ParseFromFlags(flags); err != nil {
+		return errors.Trace(err)
+	}
+	if err = cfg.parseBackupOptionsFromFlags(flags); err != nil {
+		return errors.Trace(err)
+	}
+
+	cfg.TimeAgo, err = flags.GetDuration(flagBackupTimeago)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if cfg.TimeAgo < 0 {
+		return errors.Annotate(berrors.ErrInvalidArgument, "negative timeago is not allowed")
+	}
+	lastBackupTS, err := flags.GetString(flagLastBackupTS)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if lastBackupTS != "" {
+		// the txn kv backup isn't indexed, so the last one can't be found.
+		cfg.LastBackupTS, err = strconv.ParseUint(lastBackupTS, 10, 64)
+		if err != nil {
+			return errors.Annotatef(berrors.ErrInvalidArgument,
+				"invalid --%s %q, it should be a TSO for txn kv backup", flagLastBackupTS, lastBackupTS)
+		}
+	}
+	backupTS, err := flags.GetString(flagBackupTS)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	var backupAgo time.Duration
+	cfg.BackupTS, backupAgo, err = parseBackupTS(backupTS)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if backupAgo > 0 {
+		if cfg.TimeAgo > 0 {
+			return errors.Annotatef(berrors.ErrInvalidArgument,
+				"--%s %s conflicts with --%s", flagBackupTS, backupTS, flagBackupTimeago)
+		}
+		cfg.TimeAgo = backupAgo
+	}
+	cfg.GCTTL, err = flags.GetInt64(flagGCTTL)
+	return errors.Trace(err)
+}
+
+func (cfg *TxnKvConfig) adjust() {
+	cfg.RawKvConfig.adjust()
+	if cfg.GCTTL == 0 {
+		cfg.GCTTL = utils.DefaultBRGCSafePointTTL
+	}
+}
+
+// RunBackupTxn starts a txn kv backup task inside the current goroutine.
+func RunBackupTxn(c context.Context, g glue.Glue, cmdName string, cfg *TxnKvConfig) error {
+	cfg.adjust()
+
+	defer summary.Summary(cmdName)
+	c, collectS3Requests := withS3RequestCounter(c)
+	defer collectS3Requests()
+	ctx, cancel := context.WithCancel(c)
+	defer cancel()
+	if err := startContinuousProfiling(ctx, &cfg.Config); err != nil {
+		return errors.Trace(err)
+	}
+
+	if span := opentracing.SpanFromContext(ctx); span != nil && span.Tracer() != nil {
+		span1 := span.Tracer().StartSpan("task.RunBackupTxn", opentracing.ChildOf(span.Context()))
+		defer span1.Finish()
+		ctx = opentracing.ContextWithSpan(ctx, span1)
+	}
+
+	u, err := storage.ParseBackend(cfg.Storage, &cfg.BackendOptions)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	// Backup txn does not need domain.
+	needDomain := false
+	mgr, err := NewMgr(ctx, g, cfg.PD, cfg.TLS, GetKeepalive(&cfg.Config), cfg.Timeout, cfg.CheckRequirements, needDomain)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	defer mgr.Close()
+
+	client, err := backup.NewBackupClient(ctx, mgr)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	opts := backupStorageOptions(&cfg.Config)
+	if err = client.SetStorage(ctx, u, opts); err != nil {
+		return errors.Trace(err)
+	}
+	if err = cfg.setMirrorStorage(ctx, client, &cfg.BackendOptions, opts); err != nil {
+		return errors.Trace(err)
+	}
+	if opts.ObjectLock != nil {
+		if err = metautil.WriteRetention(ctx, client.GetStorage(), opts.ObjectLock); err != nil {
+			return errors.Trace(err)
+		}
+	}
+	client.SetGCTTL(cfg.GCTTL)
+
+	backupTS, err := client.GetTS(ctx, cfg.TimeAgo, cfg.BackupTS)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if backupTS <= cfg.LastBackupTS {
+		return errors.Annotatef(berrors.ErrInvalidArgument,
+			"the backup ts %d should be greater than the last backup ts %d", backupTS, cfg.LastBackupTS)
+	}
+	g.Record("BackupTS", backupTS)
+	// the versions after the last backup ts are backed up, so they should be
+	// kept from GC.
+	sp := utils.BRServiceSafePoint{
+		BackupTS: backupTS,
+		TTL:      client.GetGCTTL(),
+		ID:       utils.MakeSafePointID(),
+	}
+	if cfg.LastBackupTS > 0 {
+		sp.BackupTS = cfg.LastBackupTS
+	}
+	log.Info("current backup safePoint job", zap.Object("safePoint", sp))
+	stopSafePoint, err := utils.StartServiceSafePointKeeper(ctx, mgr.GetPDClient(), sp)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	defer func() {
+		stopSafePoint()
+		if e := utils.RemoveServiceSafePoint(context.Background(), mgr.GetPDClient(), sp); e != nil {
+			log.Warn("failed to remove the service safe point, it's kept until the TTL expires",
+				zap.Object("safePoint", sp), zap.Error(e))
+		}
+	}()
+
+	backupRanges := cfg.backupRanges()
+
+	if cfg.RemoveSchedulers {
+		restore, e := mgr.RemoveSchedulers(ctx)
+		defer func() {
+			if ctx.Err() != nil {
+				log.Warn("context canceled, try shutdown")
+				ctx = context.Background()
+			}
+			if restoreE := restore(ctx); restoreE != nil {
+				log.Warn("failed to restore removed schedulers, you may need to restore them manually", zap.Error(restoreE))
+			}
+		}()
+		if e != nil {
+			return errors.Trace(err)
+		}
+	}
+
+	brVersion := g.GetVersion()
+	clusterVersion, err := mgr.GetClusterVersion(ctx)
+	if err != nil {
+		return errors.Trace(err)
+	}
+
+	// The number of regions need to backup
+	approximateRegions := 0
+	for _, backupRange := range backupRanges {
+		regions, err := mgr.GetRegionCount(ctx, backupRange.StartKey, backupRange.EndKey)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		approximateRegions += regions
+	}
+
+	summary.CollectInt("backup total regions", approximateRegions)
+
+	// Redirect to log if there is no log file to avoid unreadable output.
+	updateCh := g.StartProgress(
+		ctx, cmdName, int64(approximateRegions), !cfg.LogProgress)
+
+	progressCallBack := func(unit backup.ProgressUnit) {
+		if unit == backup.RangeUnit {
+			return
+		}
+		updateCh.Inc()
+	}
+
+	// Both the default and the write column families are backed up by the
+	// transactional request.
+	req := backuppb.BackupRequest{
+		ClusterId:        client.GetClusterID(),
+		StartVersion:     cfg.LastBackupTS,
+		EndVersion:       backupTS,
+		RateLimit:        cfg.RateLimit,
+		Concurrency:      cfg.Concurrency,
+		CompressionType:  cfg.CompressionType,
+		CompressionLevel: cfg.CompressionLevel,
+	}
+	metaWriter := metautil.NewMetaWriter(client.GetStorage(), metautil.MetaFileSize, false)
+	metaWriter.StartWriteMetasAsync(ctx, metautil.AppendDataFile)
+	txnRanges := make([]metautil.TxnKvRange, 0, len(backupRanges))
+	for _, backupRange := range backupRanges {
+		err = client.BackupRange(ctx, backupRange.StartKey, backupRange.EndKey, req, metaWriter, progressCallBack)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		txnRanges = append(txnRanges, metautil.TxnKvRange{StartKey: backupRange.StartKey, EndKey: backupRange.EndKey})
+	}
+	// Backup has finished
+	updateCh.Close()
+	// copy the SST files written by TiKV before the backupmeta.
+	if err = storage.SyncMirror(ctx, client.GetStorage(), uint(cfg.Concurrency)); err != nil {
+		return errors.Trace(err)
+	}
+	metaWriter.Update(func(m *backuppb.BackupMeta) {
+		m.StartVersion = req.StartVersion
+		m.EndVersion = req.EndVersion
+		m.ClusterId = req.ClusterId
+		m.ClusterVersion = clusterVersion
+		m.BrVersion = brVersion
+	})
+	err = metaWriter.FinishWriteMetas(ctx, metautil.AppendDataFile)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if err = metautil.WriteTxnKvMeta(ctx, client.GetStorage(), &metautil.TxnKvMeta{Ranges: txnRanges}); err != nil {
+		return errors.Trace(err)
+	}
+	if err = writeBackupFeatures(ctx, client, &req); err != nil {
+		return errors.Trace(err)
+	}
+	err = verifyBackupFiles(ctx, client.GetStorage(), metaWriter.Backupmeta(), false, cfg.VerifyFiles, cfg.Concurrency)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if cfg.ChecksumManifest {
+		err = metautil.WriteChecksumManifest(ctx, client.GetStorage(), metaWriter.Backupmeta(), uint(cfg.Concurrency))
+		if err != nil {
+			return errors.Trace(err)
+		}
+	}
+	// lock the SST files written by TiKV after all files are written.
+	if err = storage.LockS3Objects(ctx, u, opts, uint(cfg.Concurrency)); err != nil {
+		return errors.Trace(err)
+	}
+	g.Record(summary.BackupDataSize, metaWriter.ArchiveSize())
+
+	summary.CollectArtifact("backup", client.GetStorage().URI())
+	// Set task summary to success status.
+	summary.SetSuccessStatus(true)
+	return nil
+}
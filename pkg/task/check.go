@@ -0,0 +1,315 @@
+// Copyright 2021 PingCAP, Inc. Licensed under Apache-2.0.
+
+package task
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/docker/go-units"
+	"github.com/pingcap/errors"
+	"github.com/pingcap/log"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"github.com/tikv/client-go/v2/oracle"
+	"go.uber.org/zap"
+
+	"github.com/pingcap/br/pkg/backup"
+	"github.com/pingcap/br/pkg/conn"
+	berrors "github.com/pingcap/br/pkg/errors"
+	"github.com/pingcap/br/pkg/glue"
+	"github.com/pingcap/br/pkg/storage"
+	"github.com/pingcap/br/pkg/utils"
+	"github.com/pingcap/br/pkg/version"
+)
+
+const (
+	flagStorageQuota = "storage-quota"
+
+	checkProbePrefix = "br.check."
+
+	// gcHeadroomWarning is the headroom of the backup ts over the GC safe
+	// point, below which the safe point may pass the backup ts before the
+	// backup registers its service safe point.
+	gcHeadroomWarning = 10 * time.Minute
+	// storageQuotaWarning is the ratio of the storage quota, above which the
+	// estimated size leaves little room for its error.
+	storageQuotaWarning = 0.8
+)
+
+// CheckStatus is the outcome of a pre-flight check.
+type CheckStatus string
+
+const (
+	// CheckPass means the backup won't fail for the check.
+	CheckPass CheckStatus = "pass"
+	// CheckWarn means the backup may fail or be slow for the check.
+	CheckWarn CheckStatus = "warn"
+	// CheckFail means the backup fails for the check.
+	CheckFail CheckStatus = "fail"
+)
+
+// CheckResult is the result of a pre-flight check of the backup.
+type CheckResult struct {
+	Name   string
+	Status CheckStatus
+	Detail string
+}
+
+func failedCheck(name string, err error) *CheckResult {
+	return &CheckResult{Name: name, Status: CheckFail, Detail: err.Error()}
+}
+
+// CheckBackupConfig is the configuration specific for the pre-flight checks
+// of backup tasks.
+type CheckBackupConfig struct {
+	BackupConfig
+
+	// StorageQuota is the bytes available in the storage, 0 means unknown.
+	StorageQuota uint64 `json:"storage-quota" toml:"storage-quota"`
+}
+
+// DefineCheckBackupFlags defines the flags for the `check backup` command
+// besides the backup flags.
+func DefineCheckBackupFlags(command *cobra.Command) {
+	command.Flags().String(flagStorageQuota, "",
+		"the space available in the storage, e.g. '500GiB', the estimated size of the backup is checked against it")
+}
+
+// ParseFromFlags parses the check-related flags from the flag set.
+func (cfg *CheckBackupConfig) ParseFromFlags(flags *pflag.FlagSet) error {
+	quota, err := flags.GetString(flagStorageQuota)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if quota != "" {
+		q, err := units.RAMInBytes(quota)
+		if err != nil || q < 0 {
+			return errors.Annotatef(berrors.ErrInvalidArgument, "invalid --%s %q", flagStorageQuota, quota)
+		}
+		cfg.StorageQuota = uint64(q)
+	}
+	return errors.Trace(cfg.BackupConfig.ParseFromFlags(flags))
+}
+
+// RunCheckBackup checks whether a backup by the config would fail for the
+// storage or the cluster without backing up anything, so a long backup
+// doesn't fail at the end. It returns ErrBackupCheckFailed with the results if
+// any check fails.
+func RunCheckBackup(c context.Context, g glue.Glue, cfg *CheckBackupConfig) ([]*CheckResult, error) {
+	cfg.adjustBackupConfig()
+	ctx, cancel := context.WithCancel(c)
+	defer cancel()
+
+	_, s, err := GetStorage(ctx, &cfg.Config)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	results := []*CheckResult{checkStorage(ctx, s)}
+	results = append(results, checkBackupCluster(ctx, g, cfg)...)
+
+	failed := 0
+	for _, r := range results {
+		log.Info("backup pre-flight check",
+			zap.String("check", r.Name), zap.String("status", string(r.Status)), zap.String("detail", r.Detail))
+		if r.Status == CheckFail {
+			failed++
+		}
+	}
+	if failed > 0 {
+		return results, errors.Annotatef(berrors.ErrBackupCheckFailed, "%d of %d checks failed", failed, len(results))
+	}
+	return results, nil
+}
+
+// checkStorage probes the permissions of writing, listing and deleting the
+// files, and that there isn't any backup in the storage.
+func checkStorage(ctx context.Context, s storage.ExternalStorage) *CheckResult {
+	const name = "storage"
+	if err := backup.CheckNoBackup(ctx, s); err != nil {
+		return failedCheck(name, err)
+	}
+	if err := probeStorage(ctx, s); err != nil {
+		return failedCheck(name, err)
+	}
+	return &CheckResult{Name: name, Status: CheckPass, Detail: fmt.Sprintf("%s is writable, listable and deletable", s.URI())}
+}
+
+func probeStorage(ctx context.Context, s storage.ExternalStorage) error {
+	probe := fmt.Sprintf("%s%d", checkProbePrefix, time.Now().UnixNano())
+	if err := s.WriteFile(ctx, probe, []byte(probe)); err != nil {
+		return errors.Annotatef(err, "failed to write the probe file %s", probe)
+	}
+	listed := false
+	err := s.WalkDir(ctx, &storage.WalkOption{Glob: probe}, func(path string, _ int64) error {
+		listed = listed || path == probe
+		return nil
+	})
+	if err != nil {
+		return errors.Annotatef(err, "failed to list the probe file %s", probe)
+	}
+	if !listed {
+		return errors.Annotatef(berrors.ErrStorageInvalidPermission, "the probe file %s isn't listed", probe)
+	}
+	if err = s.DeleteFile(ctx, probe); err != nil {
+		return errors.Annotatef(err, "failed to delete the probe file %s", probe)
+	}
+	exists, err := s.FileExists(ctx, probe)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if exists {
+		return errors.Annotatef(berrors.ErrStorageInvalidPermission, "the probe file %s isn't deleted", probe)
+	}
+	return nil
+}
+
+// checkBackupCluster checks the connectivity and the versions of PD and TiKV,
+// the GC safe point and the estimated backup size. The checks depending on
+// the connection to PD are skipped if it fails.
+func checkBackupCluster(ctx context.Context, g glue.Glue, cfg *CheckBackupConfig) []*CheckResult {
+	mgr, err := NewMgr(ctx, g, cfg.PD, cfg.TLS, GetKeepalive(&cfg.Config), cfg.Timeout, false, false)
+	if err != nil {
+		return []*CheckResult{failedCheck("cluster", err)}
+	}
+	defer mgr.Close()
+
+	results := []*CheckResult{checkClusterStores(ctx, mgr)}
+	backupTS, err := checkBackupTS(ctx, mgr, &cfg.BackupConfig)
+	if err != nil {
+		return append(results, failedCheck("backup ts", err))
+	}
+	results = append(results, checkGCSafePoint(ctx, mgr, &cfg.BackupConfig, backupTS))
+
+	const name = "backup size"
+	estimated, ranges, err := estimateBackupSize(ctx, mgr, &cfg.BackupConfig, backupTS)
+	if err != nil {
+		return append(results, failedCheck(name, err))
+	}
+	status, detail := storageQuotaStatus(estimated, cfg.StorageQuota)
+	detail = fmt.Sprintf("about %s in %d ranges, %s", units.BytesSize(float64(estimated)), ranges, detail)
+	if cfg.LastBackupTS > 0 {
+		detail += ", the incremental backup only has the changes"
+	}
+	return append(results, &CheckResult{Name: name, Status: status, Detail: detail})
+}
+
+func checkClusterStores(ctx context.Context, mgr *conn.Mgr) *CheckResult {
+	const name = "cluster"
+	if err := version.CheckClusterVersion(ctx, mgr.GetPDClient(), version.CheckVersionForBR); err != nil {
+		return failedCheck(name, err)
+	}
+	clusterVersion, err := mgr.GetClusterVersion(ctx)
+	if err != nil {
+		return failedCheck(name, errors.Annotate(err, "failed to get the cluster version from PD"))
+	}
+	stores, err := conn.GetAllTiKVStores(ctx, mgr.GetPDClient(), conn.SkipTiFlash)
+	if err != nil {
+		return failedCheck(name, err)
+	}
+	versions := make(map[string]int)
+	for _, store := range stores {
+		if _, err = mgr.GetBackupClient(ctx, store.GetId()); err != nil {
+			return failedCheck(name, errors.Annotatef(err, "TiKV store %d at %s is unreachable", store.GetId(), store.GetAddress()))
+		}
+		versions[store.GetVersion()]++
+	}
+	storeVersions := make([]string, 0, len(versions))
+	for v, n := range versions {
+		storeVersions = append(storeVersions, fmt.Sprintf("%d of %s", n, v))
+	}
+	sort.Strings(storeVersions)
+	return &CheckResult{Name: name, Status: CheckPass, Detail: fmt.Sprintf(
+		"cluster version %s, %d TiKV stores reachable (%s)",
+		strings.Trim(clusterVersion, "\"\n"), len(stores), strings.Join(storeVersions, ", "))}
+}
+
+// checkBackupTS returns the backup ts as the backup would take it, without
+// checking the GC safe point.
+func checkBackupTS(ctx context.Context, mgr *conn.Mgr, cfg *BackupConfig) (uint64, error) {
+	if cfg.BackupTS > 0 {
+		return cfg.BackupTS, nil
+	}
+	if cfg.TimeAgo < 0 {
+		return 0, errors.Annotate(berrors.ErrInvalidArgument, "negative timeago is not allowed")
+	}
+	p, l, err := mgr.GetPDClient().GetTS(ctx)
+	if err != nil {
+		return 0, errors.Trace(err)
+	}
+	return oracle.ComposeTS(p-cfg.TimeAgo.Milliseconds(), l), nil
+}
+
+// checkGCSafePoint checks the headroom of the ts kept by the service safe
+// point of the backup over the GC safe point.
+func checkGCSafePoint(ctx context.Context, mgr *conn.Mgr, cfg *BackupConfig, backupTS uint64) *CheckResult {
+	const name = "gc safe point"
+	// the service safe point of incremental backup is the last backup ts.
+	ts := backupTS
+	if cfg.LastBackupTS > 0 {
+		ts = cfg.LastBackupTS
+	}
+	safePoint, err := utils.GetGCSafePoint(ctx, mgr.GetPDClient())
+	if err != nil {
+		return failedCheck(name, errors.Annotate(err, "failed to get the GC safe point"))
+	}
+	return gcHeadroomResult(ts, safePoint)
+}
+
+func gcHeadroomResult(ts, safePoint uint64) *CheckResult {
+	const name = "gc safe point"
+	if ts <= safePoint {
+		return failedCheck(name, errors.Annotatef(berrors.ErrBackupGCSafepointExceeded,
+			"GC safepoint %d exceed TS %d", safePoint, ts))
+	}
+	headroom := oracle.GetTimeFromTS(ts).Sub(oracle.GetTimeFromTS(safePoint))
+	result := &CheckResult{Name: name, Status: CheckPass,
+		Detail: fmt.Sprintf("TS %d is %s ahead of the GC safepoint %d", ts, headroom, safePoint)}
+	if headroom < gcHeadroomWarning {
+		result.Status = CheckWarn
+	}
+	return result
+}
+
+// estimateBackupSize estimates the backup size by the approximate size of a
+// replica of the backed up ranges in PD, which is compressed like the backup
+// files.
+func estimateBackupSize(ctx context.Context, mgr *conn.Mgr, cfg *BackupConfig, backupTS uint64) (uint64, int, error) {
+	if cfg.SchemaOnly {
+		return 0, 0, nil
+	}
+	ranges, schemas, err := backup.BuildBackupRangeAndSchema(mgr.GetStorage(), cfg.TableFilter, backupTS)
+	if err != nil {
+		return 0, 0, errors.Trace(err)
+	}
+	if cfg.SkipIndexes && schemas != nil {
+		ranges, _ = schemas.SkipIndexes(ranges)
+	}
+	var sizeMiB int64
+	for _, r := range ranges {
+		stats, err := mgr.GetRegionStats(ctx, r.StartKey, r.EndKey)
+		if err != nil {
+			return 0, 0, errors.Annotate(err, "failed to get the region stats from PD")
+		}
+		sizeMiB += stats.StorageSize
+	}
+	return uint64(sizeMiB) * units.MiB, len(ranges), nil
+}
+
+// storageQuotaStatus checks the estimated size against the quota.
+func storageQuotaStatus(estimated, quota uint64) (CheckStatus, string) {
+	quotaSize := units.BytesSize(float64(quota))
+	switch {
+	case quota == 0:
+		return CheckPass, "the storage quota is unknown"
+	case estimated > quota:
+		return CheckFail, fmt.Sprintf("exceeds the storage quota %s", quotaSize)
+	case float64(estimated) > float64(quota)*storageQuotaWarning:
+		return CheckWarn, fmt.Sprintf("close to the storage quota %s", quotaSize)
+	default:
+		return CheckPass, fmt.Sprintf("within the storage quota %s", quotaSize)
+	}
+}
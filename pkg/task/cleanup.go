@@ -0,0 +1,183 @@
+// Copyright 2021 PingCAP, Inc. Licensed under Apache-2.0.
+
+package task
+
+import (
+	"context"
+	"path"
+	"time"
+
+	"github.com/pingcap/errors"
+	"github.com/pingcap/log"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"go.uber.org/zap"
+	"golang.org/x/sync/errgroup"
+
+	berrors "github.com/pingcap/br/pkg/errors"
+	"github.com/pingcap/br/pkg/metautil"
+	"github.com/pingcap/br/pkg/storage"
+)
+
+const (
+	flagDryRun = "dry-run"
+
+	defaultCleanupConcurrency = 16
+)
+
+// CleanupConfig is the configuration specific for cleanup tasks.
+type CleanupConfig struct {
+	Config
+
+	DryRun bool `json:"dry-run" toml:"dry-run"`
+}
+
+// DefineCleanupFlags defines the flags for the cleanup command.
+func DefineCleanupFlags(command *cobra.Command) {
+	command.Flags().Bool(flagDryRun, false, "only list the files to delete without deleting them")
+}
+
+// ParseFromFlags parses the cleanup-related flags from the flag set.
+func (cfg *CleanupConfig) ParseFromFlags(flags *pflag.FlagSet) error {
+	var err error
+	cfg.DryRun, err = flags.GetBool(flagDryRun)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	return errors.Trace(cfg.Config.ParseFromFlags(flags))
+}
+
+// CleanupPlan is the files of a backup to delete.
+type CleanupPlan struct {
+	URI string
+	// Files are the existing files of the backup in the deletion order.
+	Files []string
+	// Size is the total size of the Files.
+	Size int64
+	// Unrelated is the number of the other files under the prefix, which are
+	// never deleted.
+	Unrelated int
+}
+
+// RunCleanup deletes the files of the backup from the storage. Only the files
+// referenced by the backupmeta are deleted, the other files under the prefix,
+// e.g. another backup sharing the prefix, are kept. The confirm function is
+// called with the plan before the deletion, which is canceled when it returns
+// false.
+func RunCleanup(c context.Context, cfg *CleanupConfig, confirm func(*CleanupPlan) bool) (*CleanupPlan, error) {
+	ctx, cancel := context.WithCancel(c)
+	defer cancel()
+
+	_, s, err := GetStorage(ctx, &cfg.Config)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	retention, err := metautil.ReadRetention(ctx, s)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	if retention.IsLocked(time.Now()) {
+		return nil, errors.Annotatef(berrors.ErrInvalidArgument,
+			"the backup in %s is locked, legal hold: %v, retained until: %s",
+			s.URI(), retention.LegalHold, retention.RetainUntil)
+	}
+
+	plan, err := planCleanup(ctx, s)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	log.Info("cleanup planned",
+		zap.String("storage", plan.URI),
+		zap.Int("files", len(plan.Files)),
+		zap.Int64("size", plan.Size),
+		zap.Int("unrelated", plan.Unrelated))
+	if cfg.DryRun || (confirm != nil && !confirm(plan)) {
+		return plan, nil
+	}
+
+	concurrency := int(cfg.Concurrency)
+	if concurrency == 0 {
+		concurrency = defaultCleanupConcurrency
+	}
+	if err = deleteBackupFiles(ctx, s, plan.Files, concurrency); err != nil {
+		return plan, errors.Trace(err)
+	}
+	log.Info("cleanup finished", zap.String("storage", plan.URI), zap.Int("files", len(plan.Files)))
+	return plan, nil
+}
+
+// planCleanup intersects the files of the backup with the existing files.
+func planCleanup(ctx context.Context, s storage.ExternalStorage) (*CleanupPlan, error) {
+	files, err := metautil.BackupFiles(ctx, s)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	sizes := make(map[string]int64)
+	plan := &CleanupPlan{URI: s.URI()}
+	err = s.WalkDir(ctx, &storage.WalkOption{}, func(name string, size int64) error {
+		sizes[path.Clean(name)] = size
+		return nil
+	})
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	for _, name := range files {
+		size, ok := sizes[name]
+		if !ok {
+			continue
+		}
+		delete(sizes, name)
+		plan.Files = append(plan.Files, name)
+		plan.Size += size
+	}
+	plan.Unrelated = len(sizes)
+	return plan, nil
+}
+
+// deleteBackupFiles deletes the files in order. The backupmetas are deleted
+// after all other files, so an interrupted cleanup can be run again.
+func deleteBackupFiles(ctx context.Context, s storage.ExternalStorage, files []string, concurrency int) error {
+	var metas []string
+	others := make([]string, 0, len(files))
+	for _, name := range files {
+		if path.Base(name) == metautil.MetaFile {
+			metas = append(metas, name)
+		} else {
+			others = append(others, name)
+		}
+	}
+	if err := deleteFilesConcurrently(ctx, s, others, concurrency); err != nil {
+		return errors.Trace(err)
+	}
+	// the backupmetas of the sub directories before the root one.
+	for _, name := range metas {
+		if err := s.DeleteFile(ctx, name); err != nil {
+			return errors.Annotatef(err, "failed to delete %s", name)
+		}
+	}
+	return nil
+}
+
+func deleteFilesConcurrently(ctx context.Context, s storage.ExternalStorage, files []string, concurrency int) error {
+	eg, ectx := errgroup.WithContext(ctx)
+	workers := make(chan struct{}, concurrency)
+	for _, name := range files {
+		name := name
+		select {
+		case <-ectx.Done():
+			if err := eg.Wait(); err != nil {
+				return errors.Trace(err)
+			}
+			return errors.Trace(ctx.Err())
+		case workers <- struct{}{}:
+		}
+		eg.Go(func() error {
+			defer func() { <-workers }()
+			if err := s.DeleteFile(ectx, name); err != nil {
+				return errors.Annotatef(err, "failed to delete %s", name)
+			}
+			return nil
+		})
+	}
+	return errors.Trace(eg.Wait())
+}
@@ -0,0 +1,205 @@
+// Copyright 2021 PingCAP, Inc. Licensed under Apache-2.0.
+
+package task
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/coreos/go-semver/semver"
+	"github.com/pingcap/errors"
+	"github.com/pingcap/log"
+	pd "github.com/tikv/pd/client"
+	"go.uber.org/zap"
+
+	"github.com/pingcap/br/pkg/conn"
+	"github.com/pingcap/br/pkg/metautil"
+	"github.com/pingcap/br/pkg/storage"
+	"github.com/pingcap/br/pkg/summary"
+	"github.com/pingcap/br/pkg/version"
+)
+
+// topologyStoreRatio is how many times the TiKV stores of the backup cluster
+// are more than the ones of the restore cluster to warn.
+const topologyStoreRatio = 2
+
+// collectClusterTopology takes the snapshot of the stores and the versions of
+// the cluster.
+func collectClusterTopology(ctx context.Context, mgr *conn.Mgr) (*metautil.ClusterTopology, error) {
+	topology := &metautil.ClusterTopology{}
+	var err error
+	if topology.PDVersion, err = mgr.GetPDVersion(ctx); err != nil {
+		return nil, errors.Annotate(err, "failed to get the version of PD")
+	}
+	clusterVersion, err := mgr.GetClusterVersion(ctx)
+	if err != nil {
+		return nil, errors.Annotate(err, "failed to get the cluster version from PD")
+	}
+	topology.ClusterVersion = strings.Trim(clusterVersion, "\"\n")
+	if topology.RegionCount, err = mgr.GetRegionCount(ctx, []byte{}, []byte{}); err != nil {
+		return nil, errors.Annotate(err, "failed to get the region count from PD")
+	}
+	stores, err := mgr.GetPDClient().GetAllStores(ctx, pd.WithExcludeTombstone())
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	for _, store := range stores {
+		st := metautil.StoreTopology{
+			ID:      store.GetId(),
+			Address: store.GetAddress(),
+			Version: store.GetVersion(),
+			TiFlash: version.IsTiFlash(store),
+		}
+		for _, label := range store.GetLabels() {
+			if st.Labels == nil {
+				st.Labels = make(map[string]string)
+			}
+			st.Labels[label.GetKey()] = label.GetValue()
+		}
+		info, err := mgr.GetStoreInfo(ctx, store.GetId())
+		if err != nil {
+			return nil, errors.Annotatef(err, "failed to get the info of store %d from PD", store.GetId())
+		}
+		if info.Status != nil {
+			st.RegionCount = info.Status.RegionCount
+		}
+		topology.Stores = append(topology.Stores, st)
+	}
+	sort.Slice(topology.Stores, func(i, j int) bool { return topology.Stores[i].ID < topology.Stores[j].ID })
+	return topology, nil
+}
+
+// writeClusterTopology records the cluster topology with the backup. It's
+// only used by the checks of restore, so the failure doesn't fail the backup.
+func writeClusterTopology(ctx context.Context, mgr *conn.Mgr, s storage.ExternalStorage) {
+	topology, err := collectClusterTopology(ctx, mgr)
+	if err == nil {
+		err = metautil.WriteClusterTopology(ctx, s, topology)
+	}
+	if err != nil {
+		log.Warn("failed to record the cluster topology, restore can't compare the clusters", zap.Error(err))
+	}
+}
+
+// parseComponentVersion parses the version of a component, nil if it's
+// invalid.
+func parseComponentVersion(v string) *semver.Version {
+	v = strings.TrimPrefix(strings.TrimSpace(v), "v")
+	if i := strings.IndexAny(v, "-+"); i >= 0 {
+		v = v[:i]
+	}
+	parsed, err := semver.NewVersion(v)
+	if err != nil {
+		return nil
+	}
+	return parsed
+}
+
+// tikvVersionRange returns the lowest and the highest versions of the TiKV
+// stores, nil if there is no valid one.
+func tikvVersionRange(topology *metautil.ClusterTopology) (lowest, highest *semver.Version) {
+	for _, store := range topology.Stores {
+		v := parseComponentVersion(store.Version)
+		if v == nil || store.TiFlash {
+			continue
+		}
+		if lowest == nil || v.LessThan(*lowest) {
+			lowest = v
+		}
+		if highest == nil || highest.LessThan(*v) {
+			highest = v
+		}
+	}
+	return lowest, highest
+}
+
+// labelKeys returns the sorted keys of the labels of the TiKV stores.
+func labelKeys(topology *metautil.ClusterTopology) []string {
+	keys := make(map[string]struct{})
+	for _, store := range topology.Stores {
+		if store.TiFlash {
+			continue
+		}
+		for key := range store.Labels {
+			keys[key] = struct{}{}
+		}
+	}
+	result := make([]string, 0, len(keys))
+	for key := range keys {
+		result = append(result, key)
+	}
+	sort.Strings(result)
+	return result
+}
+
+// topologyWarnings compares the topology of the backup cluster with the one
+// of the restore cluster, and returns the differences affecting the restore.
+func topologyWarnings(backup, current *metautil.ClusterTopology) []string {
+	var warnings []string
+	backupStores, currentStores := backup.TiKVStores(), current.TiKVStores()
+	if currentStores*topologyStoreRatio <= backupStores {
+		warnings = append(warnings, fmt.Sprintf(
+			"the backup is taken from %d TiKV stores with %d regions, but the cluster has only %d TiKV stores, "+
+				"the restore may be slow or run out of the disk space",
+			backupStores, backup.RegionCount, currentStores))
+	}
+	if backupStores < len(backup.Stores) && currentStores == len(current.Stores) {
+		warnings = append(warnings,
+			"the backup cluster has TiFlash stores but the cluster has none, the TiFlash replicas can't be restored")
+	}
+
+	_, backupTiKV := tikvVersionRange(backup)
+	currentTiKV, _ := tikvVersionRange(current)
+	if backupTiKV != nil && currentTiKV != nil && currentTiKV.Major < backupTiKV.Major {
+		warnings = append(warnings, fmt.Sprintf(
+			"the backup is taken from TiKV %s, but the cluster has TiKV %s of an older major version",
+			backupTiKV, currentTiKV))
+	}
+	backupPD, currentPD := parseComponentVersion(backup.PDVersion), parseComponentVersion(current.PDVersion)
+	if backupPD != nil && currentPD != nil && backupPD.Major != currentPD.Major {
+		warnings = append(warnings, fmt.Sprintf(
+			"the backup is taken with PD %s, but the cluster has PD %s of another major version",
+			backup.PDVersion, current.PDVersion))
+	}
+
+	var missing []string
+	currentKeys := labelKeys(current)
+	for _, key := range labelKeys(backup) {
+		i := sort.SearchStrings(currentKeys, key)
+		if i == len(currentKeys) || currentKeys[i] != key {
+			missing = append(missing, key)
+		}
+	}
+	if len(missing) > 0 {
+		warnings = append(warnings, fmt.Sprintf(
+			"the labels %s of the backup cluster aren't on the stores of the cluster, "+
+				"the placement rules and the replicas by them may not be restored",
+			strings.Join(missing, ", ")))
+	}
+	return warnings
+}
+
+// checkClusterTopology warns the differences between the cluster of the
+// backup and the cluster to restore, e.g. far fewer stores. The backups
+// without the cluster topology aren't checked.
+func checkClusterTopology(ctx context.Context, mgr *conn.Mgr, s storage.ExternalStorage) error {
+	backup, err := metautil.ReadClusterTopology(ctx, s)
+	if err != nil || backup == nil {
+		return errors.Trace(err)
+	}
+	current, err := collectClusterTopology(ctx, mgr)
+	if err != nil {
+		log.Warn("failed to get the cluster topology, skip comparing it with the backup cluster", zap.Error(err))
+		return nil
+	}
+	warnings := topologyWarnings(backup, current)
+	for _, warning := range warnings {
+		log.Warn("the cluster differs from the backup cluster", zap.String("warning", warning))
+	}
+	if len(warnings) > 0 {
+		summary.CollectInt("cluster topology warnings", len(warnings))
+	}
+	return nil
+}
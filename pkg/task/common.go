@@ -6,7 +6,9 @@ import (
 	"context"
 	"crypto/tls"
 	"fmt"
+	"io"
 	"net/url"
+	"os"
 	"path"
 	"strings"
 	"time"
@@ -17,6 +19,7 @@ import (
 	"github.com/pingcap/errors"
 	backuppb "github.com/pingcap/kvproto/pkg/backup"
 	"github.com/pingcap/log"
+	"github.com/pingcap/parser/mysql"
 	filter "github.com/pingcap/tidb-tools/pkg/table-filter"
 	"github.com/pingcap/tidb/sessionctx/variable"
 	"github.com/spf13/cobra"
@@ -29,7 +32,10 @@ import (
 	"github.com/pingcap/br/pkg/conn"
 	berrors "github.com/pingcap/br/pkg/errors"
 	"github.com/pingcap/br/pkg/glue"
+	"github.com/pingcap/br/pkg/metautil"
+	"github.com/pingcap/br/pkg/restore"
 	"github.com/pingcap/br/pkg/storage"
+	"github.com/pingcap/br/pkg/summary"
 	"github.com/pingcap/br/pkg/utils"
 )
 
@@ -59,6 +65,9 @@ const (
 	flagChecksum            = "checksum"
 	flagFilter              = "filter"
 	flagCaseSensitive       = "case-sensitive"
+	// flagIncludeSystemTables adds the users, the privileges and the global
+	// variables to the tables selected by --filter.
+	flagIncludeSystemTables = "include-system-tables"
 	flagRemoveTiFlash       = "remove-tiflash"
 	flagCheckRequirement    = "check-requirements"
 	flagSwitchModeInterval  = "switch-mode-interval"
@@ -70,9 +79,25 @@ const (
 	flagEnableOpenTracing = "enable-opentracing"
 	flagSkipCheckPath     = "skip-check-path"
 
+	flagDialTimeout            = "dial-timeout"
+	flagPDTimeout              = "pd-timeout"
+	flagIngestTimeout          = "ingest-timeout"
+	flagWriteStreamIdleTimeout = "write-stream-idle-timeout"
+	flagChecksumTimeout        = "checksum-timeout"
+
+	flagAutoTune        = "auto-tune"
+	flagProfileInterval = "profile-interval"
+	flagProfileStorage  = "profile-storage"
+	flagBackupMeta      = "backupmeta"
+
+	// backupMetaStdin is the value of --backupmeta reading the backupmeta
+	// from stdin.
+	backupMetaStdin = "-"
+
 	defaultSwitchInterval       = 5 * time.Minute
 	defaultGRPCKeepaliveTime    = 10 * time.Second
 	defaultGRPCKeepaliveTimeout = 3 * time.Second
+	maxChecksumConcurrency      = 64
 
 	unlimited = 0
 )
@@ -150,6 +175,23 @@ type Config struct {
 	GRPCKeepaliveTime time.Duration `json:"grpc-keepalive-time" toml:"grpc-keepalive-time"`
 	// GrpcKeepaliveTimeout is the max time a grpc conn can keep idel before killed.
 	GRPCKeepaliveTimeout time.Duration `json:"grpc-keepalive-timeout" toml:"grpc-keepalive-timeout"`
+	// Timeout is the timeouts of the RPCs sent to the cluster.
+	Timeout utils.TimeoutConfig `json:"timeout" toml:"timeout"`
+
+	// AutoTune derives the default concurrencies from the processors of the
+	// host, the explicitly specified ones are kept.
+	AutoTune bool `json:"auto-tune" toml:"auto-tune"`
+	// ProfileInterval is the interval of capturing the profiles to
+	// ProfileStorage, 0 disables it.
+	ProfileInterval time.Duration `json:"profile-interval" toml:"profile-interval"`
+	ProfileStorage  string        `json:"profile-storage" toml:"profile-storage"`
+
+	// BackupMeta is the URL of the backupmeta staged apart from the data of
+	// Storage, "-" reads it from stdin, empty reads it from Storage.
+	BackupMeta string `json:"backupmeta" toml:"backupmeta"`
+	// backupMetaData caches the backupmeta read from stdin, which can only be
+	// read once.
+	backupMetaData []byte
 }
 
 // DefineCommonFlags defines the flags common to all BRIE commands.
@@ -189,9 +231,26 @@ func DefineCommonFlags(flags *pflag.FlagSet) {
 	_ = flags.MarkHidden(flagGrpcKeepaliveTime)
 	_ = flags.MarkHidden(flagGrpcKeepaliveTimeout)
 
+	flags.Duration(flagDialTimeout, utils.DefaultDialTimeout, "the timeout of connecting to TiKV")
+	flags.Duration(flagPDTimeout, utils.DefaultPDTimeout, "the timeout of each request sent to PD")
+	flags.Duration(flagIngestTimeout, 0, "the timeout of each ingest request sent to TiKV, 0 means unlimited")
+	flags.Duration(flagWriteStreamIdleTimeout, 0,
+		"the max time a write stream to TiKV can keep idle before canceled, 0 means unlimited")
+	flags.Duration(flagChecksumTimeout, 0, "the timeout of checksumming a table, 0 means unlimited")
+	_ = flags.MarkHidden(flagIngestTimeout)
+	_ = flags.MarkHidden(flagWriteStreamIdleTimeout)
+	_ = flags.MarkHidden(flagChecksumTimeout)
+
 	flags.Bool(flagEnableOpenTracing, false,
 		"Set whether to enable opentracing during the backup/restore process")
 
+	flags.Bool(flagAutoTune, false,
+		"(experimental) derive the default concurrencies from the CPUs and NUMA layout of the host")
+	_ = flags.MarkHidden(flagAutoTune)
+	flags.Duration(flagProfileInterval, 0,
+		"capture the CPU and heap profiles to --profile-storage at this interval, 0 means disabled")
+	flags.String(flagProfileStorage, "", `specify the url where the profiles are written, eg, "s3://bucket/path/profiles"`)
+
 	flags.BoolP(flagNoCreds, "", false, "Don't load credentials")
 	_ = flags.MarkHidden(flagNoCreds)
 	flags.BoolP(flagSkipCheckPath, "", false, "Skip path verification")
@@ -220,6 +279,35 @@ func DefineFilterFlags(command *cobra.Command, defaultFilter []string) {
 	flags.Bool(flagCaseSensitive, false, "whether the table names used in --filter should be case-sensitive")
 }
 
+// includeSystemTables adds the system tables of the users, the privileges and
+// the global variables to the rules of --filter, which exclude `mysql.*` by
+// default.
+func (cfg *Config) includeSystemTables(flags *pflag.FlagSet) error {
+	filterFlag := flags.Lookup(flagFilter)
+	if filterFlag == nil {
+		return errors.Annotatef(berrors.ErrInvalidArgument,
+			"--%s is only supported with --%s", flagIncludeSystemTables, flagFilter)
+	}
+	// the later rules take precedence, so they override `!mysql.*`.
+	rules := append([]string{}, filterFlag.Value.(pflag.SliceValue).GetSlice()...)
+	for _, table := range restore.PrivilegeSystemTables {
+		rules = append(rules, mysql.SystemDB+"."+table)
+	}
+	f, err := filter.Parse(rules)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	caseSensitive, err := flags.GetBool(flagCaseSensitive)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if !caseSensitive {
+		f = filter.CaseInsensitive(f)
+	}
+	cfg.TableFilter = f
+	return nil
+}
+
 // ParseFromFlags parses the TLS config from the flag set.
 func (tls *TLSConfig) ParseFromFlags(flags *pflag.FlagSet) error {
 	var err error
@@ -343,6 +431,9 @@ func (cfg *Config) ParseFromFlags(flags *pflag.FlagSet) error {
 	if err != nil {
 		return errors.Trace(err)
 	}
+	if err = cfg.parseTimeoutFromFlags(flags); err != nil {
+		return errors.Trace(err)
+	}
 	cfg.EnableOpenTracing, err = flags.GetBool(flagEnableOpenTracing)
 	if err != nil {
 		return errors.Trace(err)
@@ -368,14 +459,84 @@ func (cfg *Config) ParseFromFlags(flags *pflag.FlagSet) error {
 	if cfg.SkipCheckPath, err = flags.GetBool(flagSkipCheckPath); err != nil {
 		return errors.Trace(err)
 	}
+	if metaFlag := flags.Lookup(flagBackupMeta); metaFlag != nil {
+		cfg.BackupMeta = metaFlag.Value.String()
+	}
+	if err = cfg.parseProfileFromFlags(flags); err != nil {
+		return errors.Trace(err)
+	}
 	return cfg.normalizePDURLs()
 }
 
+func (cfg *Config) parseProfileFromFlags(flags *pflag.FlagSet) error {
+	var err error
+	if cfg.AutoTune, err = flags.GetBool(flagAutoTune); err != nil {
+		return errors.Trace(err)
+	}
+	if cfg.AutoTune && !flags.Changed(flagChecksumConcurrency) {
+		host := utils.ProbeHost()
+		cfg.ChecksumConcurrency = uint(host.ScaleConcurrency(int(cfg.ChecksumConcurrency), maxChecksumConcurrency))
+		log.Info("derive checksum concurrency from the host", zap.Object("host", host),
+			zap.Uint("concurrency", cfg.ChecksumConcurrency))
+	}
+	if cfg.ProfileInterval, err = flags.GetDuration(flagProfileInterval); err != nil {
+		return errors.Trace(err)
+	}
+	if cfg.ProfileStorage, err = flags.GetString(flagProfileStorage); err != nil {
+		return errors.Trace(err)
+	}
+	if cfg.ProfileInterval > 0 && cfg.ProfileStorage == "" {
+		return errors.Annotatef(berrors.ErrInvalidArgument, "--%s requires --%s", flagProfileInterval, flagProfileStorage)
+	}
+	return nil
+}
+
+// startContinuousProfiling captures the profiles to the profile storage in
+// background until the context is done, if it is enabled.
+func startContinuousProfiling(ctx context.Context, cfg *Config) error {
+	if cfg.ProfileInterval <= 0 {
+		return nil
+	}
+	u, err := storage.ParseBackend(cfg.ProfileStorage, &cfg.BackendOptions)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	s, err := storage.New(ctx, u, storageOpts(cfg))
+	if err != nil {
+		return errors.Annotate(err, "create profile storage failed")
+	}
+	go utils.RunContinuousProfiling(ctx, s, cfg.ProfileInterval, utils.DefaultProfileDuration)
+	return nil
+}
+
+func (cfg *Config) parseTimeoutFromFlags(flags *pflag.FlagSet) error {
+	var err error
+	cfg.Timeout.Dial, err = flags.GetDuration(flagDialTimeout)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	cfg.Timeout.PD, err = flags.GetDuration(flagPDTimeout)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	cfg.Timeout.Ingest, err = flags.GetDuration(flagIngestTimeout)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	cfg.Timeout.WriteStreamIdle, err = flags.GetDuration(flagWriteStreamIdleTimeout)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	cfg.Timeout.Checksum, err = flags.GetDuration(flagChecksumTimeout)
+	return errors.Trace(err)
+}
+
 // NewMgr creates a new mgr at the given PD address.
 func NewMgr(ctx context.Context,
 	g glue.Glue, pds []string,
 	tlsConfig TLSConfig,
 	keepalive keepalive.ClientParameters,
+	timeout utils.TimeoutConfig,
 	checkRequirements bool,
 	needDomain bool,
 ) (*conn.Mgr, error) {
@@ -407,7 +568,7 @@ func NewMgr(ctx context.Context,
 
 	// Is it necessary to remove `StoreBehavior`?
 	return conn.NewMgr(
-		ctx, g, pdAddress, store, tlsConf, securityOption, keepalive, conn.SkipTiFlash,
+		ctx, g, pdAddress, store, tlsConf, securityOption, keepalive, timeout, conn.SkipTiFlash,
 		checkRequirements, needDomain,
 	)
 }
@@ -433,6 +594,32 @@ func storageOpts(cfg *Config) *storage.ExternalStorageOptions {
 		NoCredentials:   cfg.NoCreds,
 		SendCredentials: cfg.SendCreds,
 		SkipCheckPath:   cfg.SkipCheckPath,
+		RetryOptions:    &cfg.BackendOptions.Retry,
+		RateLimit:       cfg.BackendOptions.RateLimit,
+		RequesterPays:   cfg.BackendOptions.S3.RequesterPays,
+		Proxy:           cfg.BackendOptions.Proxy,
+		GCSUpload:       &cfg.BackendOptions.GCSUpload,
+		S3Upload:        &cfg.BackendOptions.S3Upload,
+		S3RequestBudget: &cfg.BackendOptions.S3RequestBudget,
+		Local:           &cfg.BackendOptions.Local,
+		Credentials:     &cfg.BackendOptions.Credentials,
+	}
+}
+
+// withS3RequestCounter returns the context counting the requests sent to S3
+// by the task, and the function collecting them into the summary of the task,
+// so the request budgets of the next runs can be estimated. The requests are
+// counted per task since many tasks may run in a process.
+func withS3RequestCounter(ctx context.Context) (context.Context, func()) {
+	counter := storage.NewS3RequestCounter()
+	ctx = storage.WithS3RequestCounter(ctx, counter)
+	return ctx, func() {
+		counts := counter.Counts()
+		for _, class := range []string{storage.S3RequestGet, storage.S3RequestPut, storage.S3RequestList} {
+			if n := counts[class]; n > 0 {
+				summary.FromContext(ctx).CollectUInt("s3 "+class+" requests", n)
+			}
+		}
 	}
 }
 
@@ -446,9 +633,14 @@ func ReadBackupMeta(
 	if err != nil {
 		return nil, nil, nil, errors.Trace(err)
 	}
-	metaData, err := s.ReadFile(ctx, fileName)
+	var metaData []byte
+	if cfg.BackupMeta != "" && fileName == metautil.MetaFile {
+		metaData, err = cfg.readStagedBackupMeta(ctx)
+	} else {
+		metaData, err = s.ReadFile(ctx, fileName)
+	}
 	if err != nil {
-		if gcsObjectNotFound(err) {
+		if gcsObjectNotFound(err) && cfg.BackupMeta == "" {
 			// change gcs://bucket/abc/def to gcs://bucket/abc and read defbackupmeta
 			oldPrefix := u.GetGcs().GetPrefix()
 			newPrefix, file := path.Split(oldPrefix)
@@ -473,9 +665,55 @@ func ReadBackupMeta(
 	if err = proto.Unmarshal(metaData, backupMeta); err != nil {
 		return nil, nil, nil, errors.Annotate(err, "parse backupmeta failed")
 	}
+	if err = metautil.CheckMetaVersion(backupMeta); err != nil {
+		return nil, nil, nil, errors.Trace(err)
+	}
 	return u, s, backupMeta, nil
 }
 
+// metaStdin is where --backupmeta=- reads the backupmeta from.
+var metaStdin io.Reader = os.Stdin
+
+// readStagedBackupMeta reads the backupmeta of --backupmeta, which is staged
+// apart from the data by e.g. an orchestration system. The metafiles of a v2
+// backupmeta are still read from the storage of the data.
+func (cfg *Config) readStagedBackupMeta(ctx context.Context) ([]byte, error) {
+	if cfg.BackupMeta == backupMetaStdin {
+		if cfg.backupMetaData == nil {
+			data, err := io.ReadAll(metaStdin)
+			if err != nil {
+				return nil, errors.Annotate(err, "failed to read backupmeta from stdin")
+			}
+			cfg.backupMetaData = data
+		}
+		log.Info("read backupmeta from stdin", zap.Int("size", len(cfg.backupMetaData)))
+		return cfg.backupMetaData, nil
+	}
+
+	dir, name := ".", cfg.BackupMeta
+	if i := strings.LastIndex(cfg.BackupMeta, "/"); i >= 0 {
+		dir, name = cfg.BackupMeta[:i], cfg.BackupMeta[i+1:]
+	}
+	if name == "" {
+		return nil, errors.Annotatef(berrors.ErrInvalidArgument,
+			"--%s %q should be the URL of the backupmeta file", flagBackupMeta, cfg.BackupMeta)
+	}
+	u, err := storage.ParseBackend(dir, &cfg.BackendOptions)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	s, err := storage.New(ctx, u, storageOpts(cfg))
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	data, err := s.ReadFile(ctx, name)
+	if err != nil {
+		return nil, errors.Annotatef(err, "failed to read backupmeta from %s", cfg.BackupMeta)
+	}
+	log.Info("read backupmeta from the staged location", zap.String("url", cfg.BackupMeta), zap.Int("size", len(data)))
+	return data, nil
+}
+
 // flagToZapField checks whether this flag can be logged,
 // if need to log, return its zap field. Or return a field with hidden value.
 func flagToZapField(f *pflag.Flag) zap.Field {
@@ -522,6 +760,8 @@ func (cfg *Config) adjust() {
 	if cfg.ChecksumConcurrency == 0 {
 		cfg.ChecksumConcurrency = variable.DefChecksumTableConcurrency
 	}
+	cfg.BackendOptions.Retry.Adjust()
+	cfg.Timeout.Adjust()
 }
 
 func normalizePDURL(pd string, useTLS bool) (string, error) {
@@ -3,12 +3,21 @@
 package task
 
 import (
+	"bytes"
+	"context"
 	"fmt"
+	"os"
+	"path/filepath"
 
+	"github.com/gogo/protobuf/proto"
+	backuppb "github.com/pingcap/kvproto/pkg/backup"
 	"github.com/pingcap/tidb/config"
 
 	. "github.com/pingcap/check"
+	"github.com/spf13/cobra"
 	"github.com/spf13/pflag"
+
+	"github.com/pingcap/br/pkg/metautil"
 )
 
 var _ = Suite(&testCommonSuite{})
@@ -63,3 +72,46 @@ func (s *testCommonSuite) TestStripingPDURL(c *C) {
 	c.Assert(err, IsNil)
 	c.Assert(noChange, Equals, "127.0.0.1:2379")
 }
+
+func (s *testCommonSuite) TestReadStagedBackupMeta(c *C) {
+	ctx := context.Background()
+	meta := &backuppb.BackupMeta{ClusterId: 42, EndVersion: 100}
+	data, err := proto.Marshal(meta)
+	c.Assert(err, IsNil)
+
+	dataDir, metaDir := c.MkDir(), c.MkDir()
+	c.Assert(os.WriteFile(filepath.Join(metaDir, "meta-of-backup"), data, 0o644), IsNil)
+	cfg := &Config{Storage: "local://" + dataDir, BackupMeta: "local://" + metaDir + "/meta-of-backup"}
+	_, _, got, err := ReadBackupMeta(ctx, metautil.MetaFile, cfg)
+	c.Assert(err, IsNil)
+	c.Assert(got.ClusterId, Equals, uint64(42))
+
+	// the backupmeta from stdin is read once and reused.
+	metaStdin = bytes.NewReader(data)
+	defer func() { metaStdin = os.Stdin }()
+	cfg = &Config{Storage: "local://" + dataDir, BackupMeta: backupMetaStdin}
+	for i := 0; i < 2; i++ {
+		_, _, got, err = ReadBackupMeta(ctx, metautil.MetaFile, cfg)
+		c.Assert(err, IsNil)
+		c.Assert(got.EndVersion, Equals, uint64(100))
+	}
+
+	cfg = &Config{Storage: "local://" + dataDir, BackupMeta: "local://" + metaDir + "/"}
+	_, _, _, err = ReadBackupMeta(ctx, metautil.MetaFile, cfg)
+	c.Assert(err, ErrorMatches, ".*should be the URL of the backupmeta file.*")
+}
+
+func (*testCommonSuite) TestIncludeSystemTables(c *C) {
+	command := &cobra.Command{}
+	DefineFilterFlags(command, []string{"*.*", "!mysql.*"})
+	cfg := &Config{}
+	c.Assert(cfg.includeSystemTables(command.Flags()), IsNil)
+	c.Assert(cfg.TableFilter.MatchTable("mysql", "user"), IsTrue)
+	c.Assert(cfg.TableFilter.MatchTable("MySQL", "global_variables"), IsTrue)
+	c.Assert(cfg.TableFilter.MatchTable("mysql", "tidb"), IsFalse)
+	c.Assert(cfg.TableFilter.MatchTable("test", "t"), IsTrue)
+
+	command = &cobra.Command{}
+	DefineDatabaseFlags(command)
+	c.Assert(cfg.includeSystemTables(command.Flags()), ErrorMatches, ".*only supported with --filter.*")
+}
@@ -0,0 +1,147 @@
+// Copyright 2021 PingCAP, Inc. Licensed under Apache-2.0.
+
+package task
+
+import (
+	"context"
+	"sort"
+
+	"github.com/pingcap/errors"
+	"github.com/pingcap/tidb/util"
+	"github.com/spf13/pflag"
+
+	"github.com/pingcap/br/pkg/glue"
+	"github.com/pingcap/br/pkg/metautil"
+	"github.com/pingcap/br/pkg/restore"
+	"github.com/pingcap/br/pkg/utils"
+)
+
+// StorageSchemes are the schemes of the storage URLs, e.g. --storage.
+var StorageSchemes = []string{"local://", "s3://", "gcs://", "noop://"}
+
+// flagValues are the values of the flags accepting a fixed set of values.
+var flagValues = map[string][]string{
+	flagCompressionType: {"lz4", "zstd", "snappy"},
+	flagMergeConflict: {
+		string(restore.MergeConflictError),
+		string(restore.MergeConflictRename),
+		string(restore.MergeConflictMerge),
+	},
+	flagWatchdogPolicy: {
+		string(restore.WatchdogPolicyLog),
+		string(restore.WatchdogPolicyRetry),
+		string(restore.WatchdogPolicyAbort),
+	},
+	flagGranularity: {
+		string(restore.GranularityTable),
+		string(restore.GranularityRegion),
+	},
+}
+
+// FlagValues returns the values of the flag accepting a fixed set of values,
+// nil if the flag accepts any value. It is used by the shell completion.
+func FlagValues(name string) []string {
+	return flagValues[name]
+}
+
+// IsStorageFlag returns whether the flag is the URL of a storage.
+func IsStorageFlag(name string) bool {
+	switch name {
+	case flagStorage, flagProfileStorage, flagCopyFrom, flagCopyTo:
+		return true
+	}
+	return false
+}
+
+// IsTableFlag returns whether the flag selects the databases or the tables,
+// i.e. --db, --table and --filter.
+func IsTableFlag(name string) bool {
+	return name == flagDatabase || name == flagTable || name == flagFilter
+}
+
+// parseConnectionFlags parses the flags needed to connect to the storage and
+// the cluster, the other flags, e.g. the required --db, may not be set yet
+// when completing the command line.
+func parseConnectionFlags(flags *pflag.FlagSet) (*Config, error) {
+	cfg := &Config{}
+	var err error
+	if cfg.Storage, err = flags.GetString(flagStorage); err != nil {
+		return nil, errors.Trace(err)
+	}
+	if cfg.SendCreds, err = flags.GetBool(flagSendCreds); err != nil {
+		return nil, errors.Trace(err)
+	}
+	if cfg.NoCreds, err = flags.GetBool(flagNoCreds); err != nil {
+		return nil, errors.Trace(err)
+	}
+	if err = cfg.BackendOptions.ParseFromFlags(flags); err != nil {
+		return nil, errors.Trace(err)
+	}
+	if err = cfg.TLS.ParseFromFlags(flags); err != nil {
+		return nil, errors.Trace(err)
+	}
+	if cfg.PD, err = flags.GetStringSlice(flagPD); err != nil {
+		return nil, errors.Trace(err)
+	}
+	for i := range cfg.PD {
+		if cfg.PD[i], err = normalizePDURL(cfg.PD[i], cfg.TLS.IsEnabled()); err != nil {
+			return nil, errors.Trace(err)
+		}
+	}
+	cfg.adjust()
+	return cfg, nil
+}
+
+// ListBackupTables lists the tables of the backup in --storage, by the names
+// of the databases. It is used by the shell completion of restore.
+func ListBackupTables(ctx context.Context, flags *pflag.FlagSet) (map[string][]string, error) {
+	cfg, err := parseConnectionFlags(flags)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	_, s, backupMeta, err := ReadBackupMeta(ctx, metautil.MetaFile, cfg)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	databases, err := utils.LoadBackupTables(ctx, metautil.NewMetaReader(backupMeta, s))
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	tables := make(map[string][]string, len(databases))
+	for _, db := range databases {
+		names := make([]string, 0, len(db.Tables))
+		for _, table := range db.Tables {
+			names = append(names, table.Info.Name.O)
+		}
+		sort.Strings(names)
+		tables[db.Info.Name.O] = names
+	}
+	return tables, nil
+}
+
+// ListClusterTables lists the user tables of the cluster, by the names of the
+// databases. It is used by the shell completion of backup.
+func ListClusterTables(ctx context.Context, g glue.Glue, flags *pflag.FlagSet) (map[string][]string, error) {
+	cfg, err := parseConnectionFlags(flags)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	mgr, err := NewMgr(ctx, g, cfg.PD, cfg.TLS, GetKeepalive(cfg), cfg.Timeout, false, true)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	defer mgr.Close()
+	tables := make(map[string][]string)
+	for _, db := range mgr.GetDomain().InfoSchema().AllSchemas() {
+		if utils.IsSysDB(db.Name.L) || util.IsMemDB(db.Name.L) {
+			continue
+		}
+		names := make([]string, 0, len(db.Tables))
+		for _, table := range db.Tables {
+			names = append(names, table.Name.O)
+		}
+		sort.Strings(names)
+		tables[db.Name.O] = names
+	}
+	return tables, nil
+}
@@ -0,0 +1,126 @@
+// Copyright 2021 PingCAP, Inc. Licensed under Apache-2.0.
+
+package task
+
+import (
+	"context"
+
+	"github.com/pingcap/errors"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+
+	berrors "github.com/pingcap/br/pkg/errors"
+	"github.com/pingcap/br/pkg/metautil"
+	"github.com/pingcap/br/pkg/storage"
+)
+
+const (
+	flagCopyFrom       = "from"
+	flagCopyTo         = "to"
+	flagServerSideCopy = "server-side-copy"
+
+	defaultCopyConcurrency = 16
+)
+
+// CopyConfig is the configuration specific for copy tasks.
+type CopyConfig struct {
+	Config
+
+	// To is the storage URL the backup is copied to.
+	To string `json:"to" toml:"to"`
+	// ServerSideCopy is whether to copy the files inside the storage service
+	// when possible, e.g. between the buckets of the same S3 endpoint.
+	ServerSideCopy bool `json:"server-side-copy" toml:"server-side-copy"`
+}
+
+// DefineCopyFlags defines the flags for the copy command.
+func DefineCopyFlags(command *cobra.Command) {
+	command.Flags().String(flagCopyFrom, "", "the storage URL of the backup to copy, the same as --storage")
+	command.Flags().String(flagCopyTo, "", "the storage URL the backup is copied to")
+	command.Flags().Bool(flagServerSideCopy, true,
+		"copy the files inside the storage service if both storages are the buckets of the same S3 endpoint")
+}
+
+// ParseFromFlags parses the copy-related flags from the flag set.
+func (cfg *CopyConfig) ParseFromFlags(flags *pflag.FlagSet) error {
+	var err error
+	cfg.To, err = flags.GetString(flagCopyTo)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if cfg.To == "" {
+		return errors.Annotatef(berrors.ErrInvalidArgument, "--%s is required", flagCopyTo)
+	}
+	cfg.ServerSideCopy, err = flags.GetBool(flagServerSideCopy)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if err = cfg.Config.ParseFromFlags(flags); err != nil {
+		return errors.Trace(err)
+	}
+	from, err := flags.GetString(flagCopyFrom)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if from != "" {
+		if cfg.Storage != "" && cfg.Storage != from {
+			return errors.Annotatef(berrors.ErrInvalidArgument,
+				"--%s and --%s are different storages", flagCopyFrom, flagStorage)
+		}
+		cfg.Storage = from
+	}
+	if cfg.Storage == "" {
+		return errors.Annotatef(berrors.ErrInvalidArgument, "--%s is required", flagCopyFrom)
+	}
+	return nil
+}
+
+// RunCopy copies the backup in the storage to the storage of cfg.To. The
+// files under the prefix not belonging to the backup aren't copied, and the
+// data files referenced from the other backups of its chain are copied.
+func RunCopy(c context.Context, cfg *CopyConfig) (*metautil.CopyResult, error) {
+	ctx, cancel := context.WithCancel(c)
+	defer cancel()
+
+	srcBackend, src, err := GetStorage(ctx, &cfg.Config)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	dstCfg := cfg.Config
+	dstCfg.Storage = cfg.To
+	dstBackend, dst, err := GetStorage(ctx, &dstCfg)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	if src.URI() == dst.URI() {
+		return nil, errors.Annotatef(berrors.ErrInvalidArgument, "cannot copy the backup in %s to itself", src.URI())
+	}
+
+	var copier storage.FileCopier = storage.NewStreamCopier(src, dst)
+	if cfg.ServerSideCopy {
+		copier, err = storage.NewServerSideCopier(srcBackend, dstBackend, storageOpts(&cfg.Config), copier)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+	}
+	concurrency := cfg.Concurrency
+	if concurrency == 0 {
+		concurrency = defaultCopyConcurrency
+	}
+	// the files deduplicated against the other backups of the chain are copied
+	// from them, so the copied backup can be restored without the chain.
+	openRef := func(ctx context.Context, depth int, backup string) (storage.ExternalStorage, error) {
+		root, err := fileRefsRoot(srcBackend, depth)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		backend, err := storage.SubBackend(root, backup)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		s, err := storage.New(ctx, backend, storageOpts(&cfg.Config))
+		return s, errors.Trace(err)
+	}
+	result, err := metautil.CopyBackup(ctx, src, dst, copier, openRef, uint(concurrency))
+	return result, errors.Trace(err)
+}
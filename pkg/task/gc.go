@@ -0,0 +1,114 @@
+// Copyright 2021 PingCAP, Inc. Licensed under Apache-2.0.
+
+package task
+
+import (
+	"context"
+	"time"
+
+	"github.com/pingcap/errors"
+	"github.com/pingcap/log"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"go.uber.org/zap"
+
+	"github.com/pingcap/br/pkg/gc"
+	"github.com/pingcap/br/pkg/storage"
+)
+
+const flagRetention = "retention"
+
+// GCConfig is the configuration specific for gc tasks.
+type GCConfig struct {
+	Config
+
+	Retention string `json:"retention" toml:"retention"`
+	DryRun    bool   `json:"dry-run" toml:"dry-run"`
+}
+
+// DefineGCFlags defines the flags for the gc command.
+func DefineGCFlags(command *cobra.Command) {
+	command.Flags().String(flagRetention, "",
+		"the retention policy of the backups in the sub directories of the storage, "+
+			"a number N keeps the latest N backups and a duration keeps the ones taken within it, e.g. '7,30d'")
+	command.Flags().Bool(flagDryRun, false, "only list the expired backups without deleting them")
+}
+
+// ParseFromFlags parses the gc-related flags from the flag set.
+func (cfg *GCConfig) ParseFromFlags(flags *pflag.FlagSet) error {
+	var err error
+	cfg.Retention, err = flags.GetString(flagRetention)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	cfg.DryRun, err = flags.GetBool(flagDryRun)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	return errors.Trace(cfg.Config.ParseFromFlags(flags))
+}
+
+// RunGC expires the backups in the sub directories of the storage by the
+// retention policy. The confirm function is called with the decisions before
+// the deletion, which is canceled when it returns false.
+func RunGC(c context.Context, cfg *GCConfig, confirm func([]*gc.Decision) bool) ([]*gc.Decision, error) {
+	ctx, cancel := context.WithCancel(c)
+	defer cancel()
+
+	policy, err := gc.ParsePolicy(cfg.Retention)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	u, s, err := GetStorage(ctx, &cfg.Config)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	backups, err := gc.ListBackups(ctx, s)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	decisions := gc.Plan(backups, policy, time.Now())
+	expired := 0
+	for _, d := range decisions {
+		if d.Expired {
+			expired++
+		}
+		log.Info("backup gc planned",
+			zap.String("dir", d.Backup.Dir),
+			zap.Time("time", d.Backup.Time()),
+			zap.Bool("expired", d.Expired),
+			zap.String("reason", d.Reason))
+	}
+	if cfg.DryRun || expired == 0 || (confirm != nil && !confirm(decisions)) {
+		return decisions, nil
+	}
+
+	concurrency := int(cfg.Concurrency)
+	if concurrency == 0 {
+		concurrency = defaultCleanupConcurrency
+	}
+	// the decisions are from the latest to the earliest, so the incremental
+	// backups are deleted before their bases.
+	for _, d := range decisions {
+		if !d.Expired {
+			continue
+		}
+		sub, err := storage.SubBackend(u, d.Backup.Dir)
+		if err != nil {
+			return decisions, errors.Trace(err)
+		}
+		subStorage, err := storage.New(ctx, sub, storageOpts(&cfg.Config))
+		if err != nil {
+			return decisions, errors.Annotatef(err, "failed to create storage for backup %s", d.Backup.Dir)
+		}
+		plan, err := planCleanup(ctx, subStorage)
+		if err != nil {
+			return decisions, errors.Trace(err)
+		}
+		if err = deleteBackupFiles(ctx, subStorage, plan.Files, concurrency); err != nil {
+			return decisions, errors.Annotatef(err, "failed to delete backup %s", d.Backup.Dir)
+		}
+		log.Info("backup expired", zap.String("dir", d.Backup.Dir), zap.Int("files", len(plan.Files)))
+	}
+	return decisions, nil
+}
@@ -0,0 +1,215 @@
+// Copyright 2021 PingCAP, Inc. Licensed under Apache-2.0.
+
+package task
+
+import (
+	"context"
+	"time"
+
+	"github.com/gogo/protobuf/proto"
+	"github.com/pingcap/errors"
+	backuppb "github.com/pingcap/kvproto/pkg/backup"
+	"github.com/pingcap/log"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"go.uber.org/zap"
+
+	berrors "github.com/pingcap/br/pkg/errors"
+	"github.com/pingcap/br/pkg/metautil"
+	"github.com/pingcap/br/pkg/storage"
+)
+
+const (
+	flagNewKeyID = "new-key-id"
+	flagKeyChain = "chain"
+
+	// kmsEncryption is the server-side encryption of S3 by the KMS keys.
+	kmsEncryption = "aws:kms"
+)
+
+// KeyRotateConfig is the configuration specific for key rotate tasks.
+type KeyRotateConfig struct {
+	Config
+
+	// NewKeyID is the ID of the KMS key the backup is encrypted by after the
+	// rotation.
+	NewKeyID string `json:"new-key-id" toml:"new-key-id"`
+	// Chain is whether to rotate the keys of the base backups of the
+	// incremental chain found in the backup index as well.
+	Chain bool `json:"chain" toml:"chain"`
+}
+
+// DefineKeyRotateFlags defines the flags for the key rotate command.
+func DefineKeyRotateFlags(command *cobra.Command) {
+	command.Flags().String(flagNewKeyID, "", "the ID of the KMS key the backup is encrypted by after the rotation")
+	command.Flags().Bool(flagKeyChain, false,
+		"rotate the keys of the base backups of the incremental chain found in the backup index "+
+			"of the parent directory as well")
+}
+
+// ParseFromFlags parses the key-rotate-related flags from the flag set.
+func (cfg *KeyRotateConfig) ParseFromFlags(flags *pflag.FlagSet) error {
+	var err error
+	cfg.NewKeyID, err = flags.GetString(flagNewKeyID)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if cfg.NewKeyID == "" {
+		return errors.Annotatef(berrors.ErrInvalidArgument, "--%s is required", flagNewKeyID)
+	}
+	cfg.Chain, err = flags.GetBool(flagKeyChain)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	return errors.Trace(cfg.Config.ParseFromFlags(flags))
+}
+
+// RotatedBackup is the result of rotating the key of a backup.
+type RotatedBackup struct {
+	metautil.RotateResult
+	// URI is the storage of the backup.
+	URI string
+}
+
+// backupEncryptionKey returns the master key the files of the backup in the
+// storage are encrypted by, nil if they aren't encrypted by the storage.
+func backupEncryptionKey(u *backuppb.StorageBackend) *metautil.EncryptionKey {
+	s3 := u.GetS3()
+	if s3 == nil || s3.Sse == "" {
+		return nil
+	}
+	return &metautil.EncryptionKey{Method: s3.Sse, KeyID: s3.SseKmsKeyId, Version: 1, Time: time.Now()}
+}
+
+// RunKeyRotate encrypts the backup in the storage by the new KMS key. The
+// files are copied to themselves inside S3, so the data keys are re-wrapped
+// by the new master key without taking the backup again or passing the
+// files through BR, except for the files larger than 5 GiB. The new key is
+// recorded in the backup and in the backup index of the parent directory.
+func RunKeyRotate(c context.Context, cfg *KeyRotateConfig) ([]RotatedBackup, error) {
+	ctx, cancel := context.WithCancel(c)
+	defer cancel()
+
+	u, err := storage.ParseBackend(cfg.Storage, &cfg.BackendOptions)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	if u.GetS3() == nil {
+		return nil, errors.Annotatef(berrors.ErrInvalidArgument,
+			"the key rotation is only supported by the backups encrypted by S3 SSE-KMS")
+	}
+	opts := storageOpts(&cfg.Config)
+	backends := []*backuppb.StorageBackend{u}
+	var names []string
+	var indexStorage storage.ExternalStorage
+	if parent, name, err := storage.ParentBackend(u); err == nil {
+		if indexStorage, err = newBackupIndexStorage(ctx, parent, opts); err != nil {
+			return nil, errors.Trace(err)
+		}
+		names = []string{name}
+		if cfg.Chain {
+			names, err = chainBackupNames(ctx, indexStorage, name)
+			if err != nil {
+				return nil, errors.Trace(err)
+			}
+			backends = backends[:0]
+			for _, name := range names {
+				backend, err := storage.SubBackend(parent, name)
+				if err != nil {
+					return nil, errors.Trace(err)
+				}
+				backends = append(backends, backend)
+			}
+		}
+	} else if cfg.Chain {
+		return nil, errors.Annotatef(err, "--%s needs the backup index of the parent directory", flagKeyChain)
+	}
+
+	concurrency := cfg.Concurrency
+	if concurrency == 0 {
+		concurrency = defaultCopyConcurrency
+	}
+	key := metautil.EncryptionKey{Method: kmsEncryption, KeyID: cfg.NewKeyID}
+	results := make([]RotatedBackup, 0, len(backends))
+	for i, backend := range backends {
+		uri := storage.FormatBackendURL(backend)
+		result, err := rotateBackupKey(ctx, backend, opts, key, uint(concurrency))
+		if err != nil {
+			return results, errors.Annotatef(err, "failed to rotate the key of the backup in %s", uri.String())
+		}
+		results = append(results, RotatedBackup{RotateResult: *result, URI: uri.String()})
+		if indexStorage != nil {
+			recordRotatedKey(ctx, indexStorage, names[i], result.Key)
+		}
+	}
+	return results, nil
+}
+
+// chainBackupNames returns the names of the backup and its bases in the
+// backup index, the newest first.
+func chainBackupNames(ctx context.Context, indexStorage storage.ExternalStorage, name string) ([]string, error) {
+	index, err := metautil.ReadBackupIndex(ctx, indexStorage)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	for _, entry := range index.Backups {
+		if entry.Name != name {
+			continue
+		}
+		chain := index.Chain(entry.EndVersion)
+		names := make([]string, 0, len(chain))
+		for _, base := range chain {
+			names = append(names, base.Name)
+		}
+		return names, nil
+	}
+	return nil, errors.Annotatef(berrors.ErrInvalidArgument,
+		"--%s needs the backup %s in the backup index of the parent directory", flagKeyChain, name)
+}
+
+// recordRotatedKey records the new key of the backup in the backup index if
+// the backup is indexed.
+func recordRotatedKey(ctx context.Context, indexStorage storage.ExternalStorage, name string, key metautil.EncryptionKey) {
+	index, err := metautil.ReadBackupIndex(ctx, indexStorage)
+	if err == nil {
+		for _, entry := range index.Backups {
+			if entry.Name != name {
+				continue
+			}
+			entry.KeyID, entry.KeyVersion = key.KeyID, key.Version
+			err = metautil.RecordBackupIndex(ctx, indexStorage, entry)
+			break
+		}
+	}
+	if err != nil {
+		log.Warn("failed to record the new key in the backup index", zap.String("backup", name), zap.Error(err))
+	}
+}
+
+// rotateBackupKey encrypts the files of the backup in the storage by the key.
+func rotateBackupKey(
+	ctx context.Context,
+	u *backuppb.StorageBackend,
+	opts *storage.ExternalStorageOptions,
+	key metautil.EncryptionKey,
+	concurrency uint,
+) (*metautil.RotateResult, error) {
+	s, err := storage.New(ctx, u, opts)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	rotatedBackend := proto.Clone(u).(*backuppb.StorageBackend)
+	rotatedBackend.GetS3().Sse = key.Method
+	rotatedBackend.GetS3().SseKmsKeyId = key.KeyID
+	rotated, err := storage.New(ctx, rotatedBackend, opts)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	// the files too large to copy inside S3 are written again through BR.
+	copier, err := storage.NewServerSideCopier(u, rotatedBackend, opts, storage.NewStreamCopier(s, rotated))
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	result, err := metautil.RotateEncryptionKey(ctx, s, rotated, copier, key, concurrency)
+	return result, errors.Trace(err)
+}
@@ -0,0 +1,55 @@
+// Copyright 2021 PingCAP, Inc. Licensed under Apache-2.0.
+
+package task
+
+import (
+	"context"
+
+	. "github.com/pingcap/check"
+	backuppb "github.com/pingcap/kvproto/pkg/backup"
+
+	"github.com/pingcap/br/pkg/metautil"
+	"github.com/pingcap/br/pkg/storage"
+)
+
+func (s *testBackupSuite) TestBackupEncryptionKey(c *C) {
+	c.Assert(backupEncryptionKey(&backuppb.StorageBackend{
+		Backend: &backuppb.StorageBackend_Local{Local: &backuppb.Local{Path: "/tmp"}},
+	}), IsNil)
+	c.Assert(backupEncryptionKey(&backuppb.StorageBackend{
+		Backend: &backuppb.StorageBackend_S3{S3: &backuppb.S3{Bucket: "bucket"}},
+	}), IsNil)
+	key := backupEncryptionKey(&backuppb.StorageBackend{
+		Backend: &backuppb.StorageBackend_S3{S3: &backuppb.S3{Bucket: "bucket", Sse: "aws:kms", SseKmsKeyId: "key1"}},
+	})
+	c.Assert(key.Method, Equals, "aws:kms")
+	c.Assert(key.KeyID, Equals, "key1")
+	c.Assert(key.Version, Equals, 1)
+}
+
+func (s *testBackupSuite) TestRecordRotatedKey(c *C) {
+	ctx := context.Background()
+	root, err := storage.NewLocalStorage(c.MkDir())
+	c.Assert(err, IsNil)
+	for _, entry := range []metautil.BackupIndexEntry{
+		{Name: "full", EndVersion: 100, KeyID: "key1", KeyVersion: 1},
+		{Name: "inc1", StartVersion: 100, EndVersion: 200, KeyID: "key1", KeyVersion: 1},
+		{Name: "other", EndVersion: 150},
+	} {
+		c.Assert(metautil.RecordBackupIndex(ctx, root, entry), IsNil)
+	}
+
+	names, err := chainBackupNames(ctx, root, "inc1")
+	c.Assert(err, IsNil)
+	c.Assert(names, DeepEquals, []string{"inc1", "full"})
+	_, err = chainBackupNames(ctx, root, "inc2")
+	c.Assert(err, ErrorMatches, ".*needs the backup inc2 in the backup index.*")
+
+	recordRotatedKey(ctx, root, "full", metautil.EncryptionKey{KeyID: "key2", Version: 2})
+	index, err := metautil.ReadBackupIndex(ctx, root)
+	c.Assert(err, IsNil)
+	c.Assert(index.Backups[0].Name, Equals, "full")
+	c.Assert(index.Backups[0].KeyID, Equals, "key2")
+	c.Assert(index.Backups[0].KeyVersion, Equals, 2)
+	c.Assert(index.Backups[2].KeyID, Equals, "key1")
+}
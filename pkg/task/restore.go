@@ -4,22 +4,29 @@ package task
 
 import (
 	"context"
+	"os"
 	"time"
 
 	"github.com/pingcap/br/pkg/metautil"
 
 	"github.com/pingcap/br/pkg/version"
 
+	"github.com/docker/go-units"
+	"github.com/gogo/protobuf/proto"
 	"github.com/opentracing/opentracing-go"
 	"github.com/pingcap/errors"
 	"github.com/pingcap/failpoint"
 	backuppb "github.com/pingcap/kvproto/pkg/backup"
 	"github.com/pingcap/log"
+	"github.com/pingcap/parser/model"
+	filter "github.com/pingcap/tidb-tools/pkg/table-filter"
 	"github.com/pingcap/tidb/config"
+	"github.com/pingcap/tidb/domain"
 	"github.com/spf13/pflag"
 	"go.uber.org/multierr"
 	"go.uber.org/zap"
 
+	"github.com/pingcap/br/pkg/cdcutil"
 	"github.com/pingcap/br/pkg/conn"
 	berrors "github.com/pingcap/br/pkg/errors"
 	"github.com/pingcap/br/pkg/glue"
@@ -31,8 +38,35 @@ import (
 )
 
 const (
-	flagOnline   = "online"
-	flagNoSchema = "no-schema"
+	flagOnline           = "online"
+	flagNoSchema         = "no-schema"
+	flagRewriteRulesFile = "rewrite-rules-file"
+	flagJournal          = "journal"
+	flagJournalStorage   = "journal-storage"
+	flagPreSplit         = "pre-split"
+	flagMergeSchema      = "merge-schema"
+	flagMergeTableName   = "merge-table-name"
+	flagMergeConflict    = "merge-conflict"
+	flagProvenance       = "provenance"
+	flagWatchdogTimeout  = "watchdog-timeout"
+	flagWatchdogPolicy   = "watchdog-policy"
+
+	flagChecksumChunkRegions = "checksum-chunk-regions"
+	flagCompact              = "compact"
+	flagCompactWait          = "compact-wait"
+	flagStoreMetricsInterval = "store-metrics-interval"
+	flagGranularity          = "granularity"
+	flagWithPlacementRules   = "with-placement-rules"
+	flagPlacementLabelMap    = "placement-label-mapping"
+	flagRunVerifyQueries     = "run-verify-queries"
+	flagMockCluster          = "mock-cluster"
+	flagCoordinateCDC        = "coordinate-cdc"
+	flagSystemTablesPolicy   = "system-tables-policy"
+	flagRestoreTiFlash       = "restore-tiflash-replica"
+	flagWaitTiFlashReady     = "wait-tiflash-ready"
+
+	flagStoreImportConcurrency = "store-import-concurrency"
+	flagClusterWriteLimit      = "cluster-write-limit"
 
 	// FlagMergeRegionSizeBytes is the flag name of merge small regions by size
 	FlagMergeRegionSizeBytes = "merge-region-size-bytes"
@@ -48,22 +82,46 @@ const (
 type RestoreCommonConfig struct {
 	Online bool `json:"online" toml:"online"`
 
-	// MergeSmallRegionSizeBytes is the threshold of merging small regions (Default 96MB, region split size).
-	// MergeSmallRegionKeyCount is the threshold of merging smalle regions (Default 960_000, region split key count).
+	// MergeSmallRegionSizeBytes is the threshold of merging small regions (Default region split size).
+	// MergeSmallRegionKeyCount is the threshold of merging smalle regions (Default region split key count).
+	// They are read from the config of the TiKV stores if they are 0.
 	// See https://github.com/tikv/tikv/blob/v4.0.8/components/raftstore/src/coprocessor/config.rs#L35-L38
 	MergeSmallRegionSizeBytes uint64 `json:"merge-region-size-bytes" toml:"merge-region-size-bytes"`
 	MergeSmallRegionKeyCount  uint64 `json:"merge-region-key-count" toml:"merge-region-key-count"`
+
+	// RewriteRulesFile is the path of a JSON file contains extra key rewrite rules.
+	RewriteRulesFile string `json:"rewrite-rules-file" toml:"rewrite-rules-file"`
+
+	// StoreImportConcurrency is the max in-flight download and ingest
+	// requests of each store, 0 means reading `import.num-threads` of the
+	// stores, negative means unlimited.
+	StoreImportConcurrency int `json:"store-import-concurrency" toml:"store-import-concurrency"`
+	// ClusterWriteLimit is the max bytes per second downloaded to all the
+	// stores, apart from the speed limit of each store. 0 means unlimited.
+	ClusterWriteLimit uint64 `json:"cluster-write-limit" toml:"cluster-write-limit"`
 }
 
-// adjust adjusts the abnormal config value in the current config.
-// useful when not starting BR from CLI (e.g. from BRIE in SQL).
-func (cfg *RestoreCommonConfig) adjust() {
-	if cfg.MergeSmallRegionKeyCount == 0 {
-		cfg.MergeSmallRegionKeyCount = restore.DefaultMergeRegionKeyCount
+// adjustMergeRegion reads the thresholds of merging small regions from the
+// split config of the TiKV stores, unless they are set explicitly. Both
+// binary and TiDB (BRIE in SQL) leave them 0 by default.
+func (cfg *RestoreCommonConfig) adjustMergeRegion(ctx context.Context, client *restore.Client) error {
+	if cfg.MergeSmallRegionSizeBytes != 0 && cfg.MergeSmallRegionKeyCount != 0 {
+		return nil
+	}
+	splitConfig, err := client.GetSplitConfig(ctx)
+	if err != nil {
+		return errors.Trace(err)
 	}
 	if cfg.MergeSmallRegionSizeBytes == 0 {
-		cfg.MergeSmallRegionSizeBytes = restore.DefaultMergeRegionSizeBytes
+		cfg.MergeSmallRegionSizeBytes = splitConfig.SplitSizeBytes
 	}
+	if cfg.MergeSmallRegionKeyCount == 0 {
+		cfg.MergeSmallRegionKeyCount = splitConfig.SplitKeyCount
+	}
+	log.Info("merge small regions",
+		zap.Uint64("size-bytes", cfg.MergeSmallRegionSizeBytes),
+		zap.Uint64("key-count", cfg.MergeSmallRegionKeyCount))
+	return nil
 }
 
 // DefineRestoreCommonFlags defines common flags for the restore command.
@@ -71,12 +129,31 @@ func DefineRestoreCommonFlags(flags *pflag.FlagSet) {
 	// TODO remove experimental tag if it's stable
 	flags.Bool(flagOnline, false, "(experimental) Whether online when restore")
 
-	flags.Uint64(FlagMergeRegionSizeBytes, restore.DefaultMergeRegionSizeBytes,
-		"the threshold of merging small regions (Default 96MB, region split size)")
-	flags.Uint64(FlagMergeRegionKeyCount, restore.DefaultMergeRegionKeyCount,
-		"the threshold of merging smalle regions (Default 960_000, region split key count)")
+	flags.Uint64(FlagMergeRegionSizeBytes, 0,
+		"the threshold of merging small regions (Default region split size, "+
+			"0 reads coprocessor.region-split-size from the config of the TiKV stores)")
+	flags.Uint64(FlagMergeRegionKeyCount, 0,
+		"the threshold of merging smalle regions (Default region split key count, "+
+			"0 reads coprocessor.region-split-keys from the config of the TiKV stores)")
 	_ = flags.MarkHidden(FlagMergeRegionSizeBytes)
 	_ = flags.MarkHidden(FlagMergeRegionKeyCount)
+
+	flags.String(flagRewriteRulesFile, "",
+		"(experimental) the path of a JSON file contains extra key rewrite rules, which are matched against "+
+			"the keys in the backup before the rules of the tables")
+	_ = flags.MarkHidden(flagRewriteRulesFile)
+
+	flags.Int(flagStoreImportConcurrency, 0,
+		"the max in-flight download and ingest requests of each TiKV store, "+
+			"0 reads import.num-threads from the config of each store, negative disables the limit")
+	flags.String(flagClusterWriteLimit, "0",
+		"the max bytes per second downloaded to all the TiKV stores in total, e.g. 200MB, "+
+			"the bytes are counted once for each replica, it's apart from --ratelimit of each store, "+
+			"0 means unlimited")
+
+	flags.String(flagBackupMeta, "",
+		"the URL of the backupmeta file if it's staged apart from the data of --storage, "+
+			"e.g. \"s3://bucket/meta/backupmeta\", '-' reads it from stdin")
 }
 
 // ParseFromFlags parses the config from the flag set.
@@ -94,7 +171,65 @@ func (cfg *RestoreCommonConfig) ParseFromFlags(flags *pflag.FlagSet) error {
 	if err != nil {
 		return errors.Trace(err)
 	}
-	return errors.Trace(err)
+	cfg.RewriteRulesFile, err = flags.GetString(flagRewriteRulesFile)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	cfg.StoreImportConcurrency, err = flags.GetInt(flagStoreImportConcurrency)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	limit, err := flags.GetString(flagClusterWriteLimit)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if limit != "" {
+		n, err := units.RAMInBytes(limit)
+		if err != nil || n < 0 {
+			return errors.Annotatef(berrors.ErrInvalidArgument, "invalid --%s %q", flagClusterWriteLimit, limit)
+		}
+		cfg.ClusterWriteLimit = uint64(n)
+	}
+	return nil
+}
+
+// limitImport limits the import requests of the client to the stores, it
+// must be called after InitBackupMeta.
+func (cfg *RestoreCommonConfig) limitImport(ctx context.Context, client *restore.Client) error {
+	if err := client.LimitStoreImportConcurrency(ctx, cfg.StoreImportConcurrency); err != nil {
+		return errors.Trace(err)
+	}
+	client.LimitClusterWriteSpeed(cfg.ClusterWriteLimit)
+	return nil
+}
+
+// checkRequesterPays returns an error if the backup files are downloaded by
+// TiKV from a requester-pays bucket, since the requests of TiKV can't be
+// charged to the requester and are going to be denied.
+func checkRequesterPays(u *backuppb.StorageBackend, cfg *Config) error {
+	if u.GetS3() == nil || !cfg.BackendOptions.S3.RequesterPays {
+		return nil
+	}
+	return errors.Annotatef(berrors.ErrStorageInvalidConfig,
+		"TiKV can't download the backup files from the requester-pays bucket, "+
+			"please copy the backup to a bucket charged to its owner by `br copy` before restoring")
+}
+
+// loadExtraRewriteRules loads the user provided rewrite rules from the file.
+func loadExtraRewriteRules(path string) (*restore.RewriteRules, error) {
+	if len(path) == 0 {
+		return nil, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, errors.Annotatef(err, "failed to read rewrite rules file %s", path)
+	}
+	rules, err := restore.ParseRewriteRules(data)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	log.Info("load extra rewrite rules", zap.String("file", path), zap.Int("count", len(rules.Data)))
+	return rules, nil
 }
 
 // RestoreConfig is the configuration specific for restore tasks.
@@ -103,6 +238,87 @@ type RestoreConfig struct {
 	RestoreCommonConfig
 
 	NoSchema bool `json:"no-schema" toml:"no-schema"`
+	// Journal is whether to record the cluster-mutating operations into the
+	// backup storage or JournalStorage, so a crashed job can be rolled back by
+	// recover-journal.
+	Journal bool `json:"journal" toml:"journal"`
+	// JournalStorage is the URL of the storage the journal and the checksum
+	// checkpoints are recorded into instead of the backup storage, e.g. when
+	// the backup storage is read-only. Setting it enables the journal.
+	JournalStorage string `json:"journal-storage" toml:"journal-storage"`
+	// PreSplit is whether to split the regions of all tables by the region
+	// boundaries recorded at backup time before restoring any batch.
+	PreSplit bool `json:"pre-split" toml:"pre-split"`
+
+	// MergeSchema is the schema which the tables of all restored schemas are
+	// mapped into, empty means keeping the schemas.
+	MergeSchema string `json:"merge-schema" toml:"merge-schema"`
+	// MergeTableName is the template of the table names in MergeSchema.
+	MergeTableName string `json:"merge-table-name" toml:"merge-table-name"`
+	// MergeConflict is the policy of the tables mapped to the same name.
+	MergeConflict restore.MergeConflictPolicy `json:"merge-conflict" toml:"merge-conflict"`
+
+	// Provenance is whether to record the job metadata into the comments of
+	// the restored tables.
+	Provenance bool `json:"provenance" toml:"provenance"`
+
+	// WatchdogTimeout is how long the restore can make no progress before the
+	// watchdog takes the action of WatchdogPolicy, 0 disables the watchdog.
+	WatchdogTimeout time.Duration          `json:"watchdog-timeout" toml:"watchdog-timeout"`
+	WatchdogPolicy  restore.WatchdogPolicy `json:"watchdog-policy" toml:"watchdog-policy"`
+
+	// ChecksumChunkRegions is the number of the regions of each chunk of the
+	// chunked checksum, 0 disables it.
+	ChecksumChunkRegions uint `json:"checksum-chunk-regions" toml:"checksum-chunk-regions"`
+
+	// Compact is whether to compact the restored ranges on the stores after
+	// the data is restored, and CompactWait is whether to wait for it.
+	Compact     bool `json:"compact" toml:"compact"`
+	CompactWait bool `json:"compact-wait" toml:"compact-wait"`
+
+	// StoreMetricsInterval is the interval of sampling the import metrics of
+	// the TiKV stores during the restore, 0 disables it.
+	StoreMetricsInterval time.Duration `json:"store-metrics-interval" toml:"store-metrics-interval"`
+
+	// Granularity is the unit scheduled to the workers of the file restore.
+	Granularity restore.Granularity `json:"granularity" toml:"granularity"`
+
+	// WithPlacementRules is whether to re-apply the placement rules of the
+	// tables recorded by backup, and PlacementLabelMapping maps the labels of
+	// the rules to the ones of the restore cluster.
+	WithPlacementRules    bool     `json:"with-placement-rules" toml:"with-placement-rules"`
+	PlacementLabelMapping []string `json:"placement-label-mapping" toml:"placement-label-mapping"`
+
+	// RestoreTiFlashReplica is whether to create the tables without the
+	// TiFlash replicas and set them after the checksum, and WaitTiFlashReady is
+	// whether to wait for the TiFlash replicas to be available.
+	RestoreTiFlashReplica bool `json:"restore-tiflash-replica" toml:"restore-tiflash-replica"`
+	WaitTiFlashReady      bool `json:"wait-tiflash-ready" toml:"wait-tiflash-ready"`
+
+	// RunVerifyQueries is whether to run the verification queries recorded by
+	// backup after restore, and compare the results with the recorded ones.
+	RunVerifyQueries bool `json:"run-verify-queries" toml:"run-verify-queries"`
+
+	// MockCluster is whether to restore into an in-process mock cluster
+	// instead of the cluster of PD, which runs the planning, the rewrite and
+	// the split of the restore without any TiKV, e.g. in the CI.
+	MockCluster bool `json:"mock-cluster" toml:"mock-cluster"`
+
+	// CoordinateCDC is how to handle the active TiCDC changefeeds replicating
+	// the restored tables.
+	CoordinateCDC cdcutil.Policy `json:"coordinate-cdc" toml:"coordinate-cdc"`
+
+	// IncludeSystemTables is whether to restore the users, the privileges and
+	// the global variables, by SystemTablesPolicy.
+	IncludeSystemTables bool                       `json:"include-system-tables" toml:"include-system-tables"`
+	SystemTablesPolicy  restore.SystemTablesPolicy `json:"system-tables-policy" toml:"system-tables-policy"`
+
+	// Confirm is called with the existing tables overwritten by the restore
+	// before changing the cluster, the restore is aborted if it returns false.
+	// nil means no confirmation is needed.
+	Confirm func(*RestoreOverwrites) bool `json:"-" toml:"-"`
+
+	StreamConfig
 }
 
 // DefineRestoreFlags defines common flags for the restore tidb command.
@@ -110,6 +326,78 @@ func DefineRestoreFlags(flags *pflag.FlagSet) {
 	flags.Bool(flagNoSchema, false, "skip creating schemas and tables, reuse existing empty ones")
 	// Do not expose this flag
 	_ = flags.MarkHidden(flagNoSchema)
+	flags.Bool(flagJournal, false,
+		"(experimental) record the cluster-mutating operations into the backup storage, "+
+			"so that a crashed restore can be rolled back by `br restore recover-journal`")
+	flags.String(flagJournalStorage, "",
+		"(experimental) the URL of the storage to record the journal into instead of the backup storage, "+
+			"it enables --journal, and `br restore recover-journal` needs the same option")
+	flags.Bool(flagPreSplit, false,
+		"(experimental) split the regions of all tables by the region topology recorded by the backup in one pass "+
+			"before restoring data, the restore starts after all tables are created")
+	flags.String(flagMergeSchema, "",
+		"restore the tables of all schemas matched by the filter into this schema, e.g. for merging sharded schemas")
+	flags.String(flagMergeTableName, restore.DefaultMergeTableName,
+		"the template of the table names in the --merge-schema, {schema} and {table} are replaced by the source names")
+	flags.String(flagMergeConflict, string(restore.MergeConflictError),
+		"the policy of the tables mapped to the same name in the --merge-schema, value can be one of "+
+			"'error|rename|merge'. 'rename' appends the source schema to the name, "+
+			"'merge' restores them into a single table")
+	flags.Bool(flagProvenance, false,
+		"(experimental) record the restore job metadata, e.g. the backup ts and BR version, into the comments "+
+			"of the restored tables, which can be listed by `br restore provenance`")
+	flags.Duration(flagWatchdogTimeout, 0,
+		"(experimental) dump the in-flight requests and the goroutines into the temporary directory "+
+			"when the restore makes no progress for this duration, 0 disables it")
+	flags.String(flagWatchdogPolicy, string(restore.WatchdogPolicyLog),
+		"the action taken by the watchdog when the restore is stuck, value can be one of 'log|retry|abort'. "+
+			"'retry' cancels and retries the in-flight download and ingest requests, 'abort' fails the restore")
+	flags.Uint(flagChecksumChunkRegions, 0,
+		"(experimental) checksum each table in the chunks of this number of regions, and record the finished chunks "+
+			"into the storage of --journal-storage, or the backup storage if it's not set, so a failed checksum "+
+			"of a large table resumes by the next restore of the same backup into the same cluster, 0 disables it")
+	flags.Bool(flagCompact, false,
+		"(experimental) compact the restored ranges on the stores holding them after the data is restored, "+
+			"so the read amplification after the ingestion drops sooner")
+	flags.Bool(flagCompactWait, true,
+		"wait for the compaction of --compact to finish, otherwise the compaction is only triggered "+
+			"and keeps running in TiKV after the restore")
+	flags.Duration(flagStoreMetricsInterval, 15*time.Second,
+		"the interval of sampling the import metrics of the TiKV stores, e.g. the pending ingest tasks and "+
+			"the apply duration, into the metrics and the summary of the restore, 0 disables it")
+	flags.String(flagGranularity, string(restore.GranularityTable),
+		"the unit scheduled to the workers of the restore, value can be one of 'table|region'. "+
+			"'region' restores the files of all in-flight batches in a global worker pool, "+
+			"which utilizes the cluster better when the sizes of the tables are skewed")
+	flags.Bool(flagWithPlacementRules, false,
+		"(experimental) re-apply the placement rules of the tables recorded by backup to the restored tables")
+	flags.StringSlice(flagPlacementLabelMap, nil,
+		"map the labels of the placement rules to the labels of the restore cluster, "+
+			"in the form of 'key=value:new-key=new-value' or 'key:new-key', e.g. 'zone=bj:zone=sh'")
+	flags.Bool(flagRestoreTiFlash, true,
+		"create the tables without the TiFlash replicas recorded by backup, and set them by "+
+			"ALTER TABLE ... SET TIFLASH REPLICA after the checksum, so TiFlash doesn't replicate the data "+
+			"during the restore")
+	flags.Bool(flagWaitTiFlashReady, false,
+		"wait for the TiFlash replicas set by --"+flagRestoreTiFlash+" to catch up with the restored data")
+	flags.Bool(flagRunVerifyQueries, false,
+		"run the verification queries recorded by backup with --verify-queries at the snapshot after restore, "+
+			"and fail if the results differ from the ones at the backup ts")
+	flags.Bool(flagMockCluster, false,
+		"(experimental) restore into an in-process mock cluster instead of the cluster of --pd, "+
+			"the tables are created and the regions are split in memory, but no data is ingested")
+	_ = flags.MarkHidden(flagMockCluster)
+	flags.String(flagCoordinateCDC, string(cdcutil.PolicyWarn),
+		"how to handle the active TiCDC changefeeds replicating the restored tables, whose downstream misses "+
+			"the ingested data, value can be one of 'none|warn|pause|fail'. "+
+			"'pause' pauses them during the restore and resumes them afterwards")
+	flags.Bool(flagIncludeSystemTables, false,
+		"restore the users, the privileges and the global variables backed up with --include-system-tables "+
+			"besides the tables selected by --filter")
+	flags.String(flagSystemTablesPolicy, string(restore.SystemTablesMerge),
+		"how the users, the privileges and the global variables are restored into the existing ones, "+
+			"value can be one of 'merge|replace'. 'merge' keeps the existing rows, 'replace' overwrites them")
+	defineStreamFlags(flags)
 
 	DefineRestoreCommonFlags(flags)
 }
@@ -121,35 +409,212 @@ func (cfg *RestoreConfig) ParseFromFlags(flags *pflag.FlagSet) error {
 	if err != nil {
 		return errors.Trace(err)
 	}
+	cfg.Journal, err = flags.GetBool(flagJournal)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	cfg.JournalStorage, err = flags.GetString(flagJournalStorage)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if cfg.JournalStorage != "" {
+		cfg.Journal = true
+	}
+	cfg.PreSplit, err = flags.GetBool(flagPreSplit)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	cfg.MergeSchema, err = flags.GetString(flagMergeSchema)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	cfg.MergeTableName, err = flags.GetString(flagMergeTableName)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	conflict, err := flags.GetString(flagMergeConflict)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	cfg.MergeConflict = restore.MergeConflictPolicy(conflict)
+	switch cfg.MergeConflict {
+	case restore.MergeConflictError, restore.MergeConflictRename, restore.MergeConflictMerge:
+	default:
+		return errors.Annotatef(berrors.ErrInvalidArgument, "invalid --%s %q", flagMergeConflict, conflict)
+	}
+	cfg.Provenance, err = flags.GetBool(flagProvenance)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	cfg.WatchdogTimeout, err = flags.GetDuration(flagWatchdogTimeout)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	policy, err := flags.GetString(flagWatchdogPolicy)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	cfg.WatchdogPolicy = restore.WatchdogPolicy(policy)
+	if err = cfg.WatchdogPolicy.Validate(); err != nil {
+		return errors.Trace(err)
+	}
+	cfg.ChecksumChunkRegions, err = flags.GetUint(flagChecksumChunkRegions)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	cfg.Compact, err = flags.GetBool(flagCompact)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	cfg.CompactWait, err = flags.GetBool(flagCompactWait)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	cfg.StoreMetricsInterval, err = flags.GetDuration(flagStoreMetricsInterval)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	granularity, err := flags.GetString(flagGranularity)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	cfg.Granularity = restore.Granularity(granularity)
+	if err = cfg.Granularity.Validate(); err != nil {
+		return errors.Trace(err)
+	}
+	cfg.WithPlacementRules, err = flags.GetBool(flagWithPlacementRules)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	cfg.PlacementLabelMapping, err = flags.GetStringSlice(flagPlacementLabelMap)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	cfg.RestoreTiFlashReplica, err = flags.GetBool(flagRestoreTiFlash)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	cfg.WaitTiFlashReady, err = flags.GetBool(flagWaitTiFlashReady)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if cfg.WaitTiFlashReady && !cfg.RestoreTiFlashReplica {
+		return errors.Annotatef(berrors.ErrInvalidArgument,
+			"--%s needs --%s", flagWaitTiFlashReady, flagRestoreTiFlash)
+	}
+	cfg.RunVerifyQueries, err = flags.GetBool(flagRunVerifyQueries)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	cfg.MockCluster, err = flags.GetBool(flagMockCluster)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	coordinateCDC, err := flags.GetString(flagCoordinateCDC)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	cfg.CoordinateCDC = cdcutil.Policy(coordinateCDC)
+	if err = cfg.CoordinateCDC.Validate(); err != nil {
+		return errors.Trace(err)
+	}
+	if _, err = restore.ParsePlacementLabelMapping(cfg.PlacementLabelMapping); err != nil {
+		return errors.Trace(err)
+	}
+	cfg.IncludeSystemTables, err = flags.GetBool(flagIncludeSystemTables)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	systemTablesPolicy, err := flags.GetString(flagSystemTablesPolicy)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	cfg.SystemTablesPolicy = restore.SystemTablesPolicy(systemTablesPolicy)
+	if err = cfg.SystemTablesPolicy.Validate(); err != nil {
+		return errors.Trace(err)
+	}
 	err = cfg.Config.ParseFromFlags(flags)
 	if err != nil {
 		return errors.Trace(err)
 	}
+	if cfg.IncludeSystemTables {
+		if err = cfg.Config.includeSystemTables(flags); err != nil {
+			return errors.Trace(err)
+		}
+	}
 	err = cfg.RestoreCommonConfig.ParseFromFlags(flags)
 	if err != nil {
 		return errors.Trace(err)
 	}
+	if err = cfg.StreamConfig.parseFromFlags(flags); err != nil {
+		return errors.Trace(err)
+	}
 
 	if cfg.Config.Concurrency == 0 {
-		cfg.Config.Concurrency = defaultRestoreConcurrency
+		cfg.Config.Concurrency = cfg.defaultConcurrency()
 	}
 	return nil
 }
 
+// defaultConcurrency returns the concurrency used when it isn't specified.
+func (cfg *RestoreConfig) defaultConcurrency() uint32 {
+	if !cfg.AutoTune {
+		return defaultRestoreConcurrency
+	}
+	host := utils.ProbeHost()
+	concurrency := uint32(host.ScaleConcurrency(defaultRestoreConcurrency, maxRestoreBatchSizeLimit))
+	log.Info("derive restore concurrency from the host", zap.Object("host", host),
+		zap.Uint32("concurrency", concurrency))
+	return concurrency
+}
+
 // adjustRestoreConfig is use for BR(binary) and BR in TiDB.
 // When new config was add and not included in parser.
 // we should set proper value in this function.
 // so that both binary and TiDB will use same default value.
 func (cfg *RestoreConfig) adjustRestoreConfig() {
 	cfg.Config.adjust()
-	cfg.RestoreCommonConfig.adjust()
 
 	if cfg.Config.Concurrency == 0 {
-		cfg.Config.Concurrency = defaultRestoreConcurrency
+		cfg.Config.Concurrency = cfg.defaultConcurrency()
 	}
 	if cfg.Config.SwitchModeInterval == 0 {
 		cfg.Config.SwitchModeInterval = defaultSwitchInterval
 	}
+	if cfg.WatchdogPolicy == "" {
+		cfg.WatchdogPolicy = restore.WatchdogPolicyLog
+	}
+	if cfg.Granularity == "" {
+		cfg.Granularity = restore.GranularityTable
+	}
+	if cfg.CoordinateCDC == "" {
+		cfg.CoordinateCDC = cdcutil.PolicyWarn
+	}
+	if cfg.SystemTablesPolicy == "" {
+		cfg.SystemTablesPolicy = restore.SystemTablesMerge
+	}
+	if cfg.MockCluster {
+		cfg.adjustMockCluster()
+	}
+}
+
+// adjustMockCluster turns off the steps needing the data or the HTTP APIs of
+// a real cluster, since the mock cluster ingests nothing.
+func (cfg *RestoreConfig) adjustMockCluster() {
+	log.Info("restore into the mock cluster, skip the checksum, the compaction, "+
+		"the placement rules, the TiFlash replicas, the verification queries and the store metrics",
+		zap.Bool("checksum", cfg.Checksum),
+		zap.Bool("compact", cfg.Compact),
+		zap.Bool("with-placement-rules", cfg.WithPlacementRules),
+		zap.Bool("wait-tiflash-ready", cfg.WaitTiFlashReady),
+		zap.Bool("run-verify-queries", cfg.RunVerifyQueries))
+	cfg.Checksum = false
+	cfg.Compact = false
+	cfg.WithPlacementRules = false
+	cfg.WaitTiFlashReady = false
+	cfg.RunVerifyQueries = false
+	cfg.StoreMetricsInterval = 0
+	cfg.CheckRequirements = false
 }
 
 // CheckRestoreDBAndTable is used to check whether the restore dbs or tables have been backup
@@ -187,13 +652,109 @@ func CheckRestoreDBAndTable(client *restore.Client, cfg *RestoreConfig) error {
 	return nil
 }
 
+// acceptedCompressions are the codecs of the SST files which the importer of
+// TiKV decodes when downloading them.
+var acceptedCompressions = []string{"lz4", "snappy", "zstd"}
+
+// checkBackupFeatures checks the codec of the SST files recorded by the
+// backup is accepted, so the restore doesn't fail after changing the cluster,
+// and returns whether the backup is schema only, which has no data to
+// restore. The backups not recording the features are accepted.
+func checkBackupFeatures(ctx context.Context, s storage.ExternalStorage) (bool, error) {
+	features, err := metautil.ReadBackupFeatures(ctx, s)
+	if err != nil || features == nil {
+		return false, errors.Trace(err)
+	}
+	if features.SchemaOnly {
+		log.Warn("the backup is taken by --schema-only, the tables are restored without data")
+	}
+	if features.Compression == "" {
+		return features.SchemaOnly, nil
+	}
+	for _, codec := range acceptedCompressions {
+		if features.Compression == codec {
+			log.Info("the SST files of the backup are compressed",
+				zap.String("compression", features.Compression),
+				zap.Int32("level", features.CompressionLevel))
+			return features.SchemaOnly, nil
+		}
+	}
+	return false, errors.Annotatef(berrors.ErrRestoreInvalidBackup,
+		"the SST files are compressed by %s, only %v are accepted", features.Compression, acceptedCompressions)
+}
+
+// checkPartialBackup warns the tables to restore whose data is partially
+// missing from the backup finished by --allow-partial.
+func checkPartialBackup(ctx context.Context, s storage.ExternalStorage, tableFilter filter.Filter) error {
+	report, err := metautil.ReadPartialReport(ctx, s)
+	if err != nil || report == nil {
+		return errors.Trace(err)
+	}
+	var tables []string
+	for _, table := range report.Tables {
+		if tableFilter.MatchTable(table.DB, table.Table) {
+			tables = append(tables, utils.EncloseDBAndTable(table.DB, table.Table))
+		}
+	}
+	if len(tables) > 0 {
+		log.Warn("the data of the tables is partially missing from the backup, "+
+			"the gaps can be filled by backup with --"+flagFillGaps+" before restoring",
+			zap.Strings("tables", tables), zap.Int("missing-ranges", len(report.MissingRanges)))
+		summary.FromContext(ctx).CollectInt("tables with missing data", len(tables))
+	}
+	return nil
+}
+
+// RestoreOverwrites is the existing tables of the cluster overwritten by the
+// restore, the names are enclosed by backquotes.
+type RestoreOverwrites struct {
+	// Tables are the user tables, their rows are merged with the restored ones.
+	Tables []string
+	// SystemTables are the tables of the system schemas, they are replaced by
+	// the restored ones.
+	SystemTables []string
+}
+
+// Empty returns whether no table is overwritten.
+func (o *RestoreOverwrites) Empty() bool {
+	return len(o.Tables) == 0 && len(o.SystemTables) == 0
+}
+
+// findRestoreOverwrites finds the tables to restore which already exist in
+// the cluster.
+func findRestoreOverwrites(dom *domain.Domain, tables []*metautil.Table) *RestoreOverwrites {
+	overwrites := &RestoreOverwrites{}
+	is := dom.InfoSchema()
+	for _, table := range tables {
+		dbName, isSystem := utils.GetSysDBName(table.DB.Name)
+		if !is.TableExists(model.NewCIStr(dbName), table.Info.Name) {
+			continue
+		}
+		name := utils.EncloseDBAndTable(dbName, table.Info.Name.O)
+		if isSystem {
+			overwrites.SystemTables = append(overwrites.SystemTables, name)
+		} else {
+			overwrites.Tables = append(overwrites.Tables, name)
+		}
+	}
+	return overwrites
+}
+
 // RunRestore starts a restore task inside the current goroutine.
 func RunRestore(c context.Context, g glue.Glue, cmdName string, cfg *RestoreConfig) error {
 	cfg.adjustRestoreConfig()
 
-	defer summary.Summary(cmdName)
+	// the summary and the requests to S3 are collected per restore, many
+	// restores may run in parallel in a process.
+	c = summary.WithJobCollector(c)
+	defer summary.FromContext(c).Summary(cmdName)
+	c, collectS3Requests := withS3RequestCounter(c)
+	defer collectS3Requests()
 	ctx, cancel := context.WithCancel(c)
 	defer cancel()
+	if err := startContinuousProfiling(ctx, &cfg.Config); err != nil {
+		return errors.Trace(err)
+	}
 
 	if span := opentracing.SpanFromContext(ctx); span != nil && span.Tracer() != nil {
 		span1 := span.Tracer().StartSpan("task.RunRestore", opentracing.ChildOf(span.Context()))
@@ -201,9 +762,25 @@ func RunRestore(c context.Context, g glue.Glue, cmdName string, cfg *RestoreConf
 		ctx = opentracing.ContextWithSpan(ctx, span1)
 	}
 
+	// the streamed backup is extracted to the staging storage to restore.
+	stream, err := cfg.useStreamStaging(&cfg.Config)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if stream != "" {
+		if err = readBackupStream(ctx, &cfg.Config, stream); err != nil {
+			return errors.Trace(err)
+		}
+	}
+
 	// Restore needs domain to do DDL.
 	needDomain := true
-	mgr, err := NewMgr(ctx, g, cfg.PD, cfg.TLS, GetKeepalive(&cfg.Config), cfg.CheckRequirements, needDomain)
+	var mgr *conn.Mgr
+	if cfg.MockCluster {
+		mgr, err = conn.NewMockMgr(GetKeepalive(&cfg.Config), cfg.Timeout)
+	} else {
+		mgr, err = NewMgr(ctx, g, cfg.PD, cfg.TLS, GetKeepalive(&cfg.Config), cfg.Timeout, cfg.CheckRequirements, needDomain)
+	}
 	if err != nil {
 		return errors.Trace(err)
 	}
@@ -216,21 +793,32 @@ func RunRestore(c context.Context, g glue.Glue, cmdName string, cfg *RestoreConf
 		return errors.Trace(err)
 	}
 	defer client.Close()
+	client.SetTimeoutConfig(cfg.Timeout)
+	if cfg.MockCluster {
+		stores, err := conn.GetAllTiKVStores(ctx, mgr.GetPDClient(), conn.SkipTiFlash)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		client.UseMockCluster(stores)
+	}
 
 	u, err := storage.ParseBackend(cfg.Storage, &cfg.BackendOptions)
 	if err != nil {
 		return errors.Trace(err)
 	}
-	opts := storage.ExternalStorageOptions{
-		NoCredentials:   cfg.NoCreds,
-		SendCredentials: cfg.SendCreds,
-		SkipCheckPath:   cfg.SkipCheckPath,
+	if err = checkRequesterPays(u, &cfg.Config); err != nil {
+		return errors.Trace(err)
 	}
-	if err = client.SetStorage(ctx, u, &opts); err != nil {
+	opts := storageOpts(&cfg.Config)
+	if err = client.SetStorage(ctx, u, opts); err != nil {
 		return errors.Trace(err)
 	}
 	client.SetRateLimit(cfg.RateLimit)
 	client.SetConcurrency(uint(cfg.Concurrency))
+	client.SetGranularity(cfg.Granularity)
+	if cfg.IncludeSystemTables {
+		client.SetSystemTablesPolicy(cfg.SystemTablesPolicy)
+	}
 	if cfg.Online {
 		client.EnableOnline()
 	}
@@ -238,6 +826,11 @@ func RunRestore(c context.Context, g glue.Glue, cmdName string, cfg *RestoreConf
 		client.EnableSkipCreateSQL()
 	}
 	client.SetSwitchModeInterval(cfg.SwitchModeInterval)
+	extraRules, err := loadExtraRewriteRules(cfg.RewriteRulesFile)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	client.SetExtraRewriteRules(extraRules)
 	err = client.LoadRestoreStores(ctx)
 	if err != nil {
 		return errors.Trace(err)
@@ -253,14 +846,78 @@ func RunRestore(c context.Context, g glue.Glue, cmdName string, cfg *RestoreConf
 			return errors.Trace(versionErr)
 		}
 	}
+	schemaOnly, err := checkBackupFeatures(ctx, s)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if err = checkPartialBackup(ctx, s, cfg.TableFilter); err != nil {
+		return errors.Trace(err)
+	}
+	// the mock cluster has no stores to compare.
+	if !cfg.MockCluster {
+		if err = checkClusterTopology(ctx, mgr, s); err != nil {
+			return errors.Trace(err)
+		}
+	}
 	reader := metautil.NewMetaReader(backupMeta, s)
-	if err = client.InitBackupMeta(c, backupMeta, u, s, reader); err != nil {
+	if err = setBaseBackups(ctx, u, s, reader, storageOpts(&cfg.Config)); err != nil {
 		return errors.Trace(err)
 	}
+	if err = client.InitBackupMeta(c, backupMeta, u, reader); err != nil {
+		return errors.Trace(err)
+	}
+	if err = setFileSources(ctx, u, s, client); err != nil {
+		return errors.Trace(err)
+	}
+	skippedIndexes, err := metautil.ReadSkippedIndexes(ctx, s)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if len(skippedIndexes) > 0 {
+		log.Info("the data of the indexes isn't backed up, they're rebuilt after the data is restored",
+			zap.Int("tables", len(skippedIndexes)))
+		client.SetSkippedIndexes(skippedIndexes)
+	}
+	if err = cfg.limitImport(ctx, client); err != nil {
+		return errors.Trace(err)
+	}
+	if err = cfg.adjustMergeRegion(ctx, client); err != nil {
+		return errors.Trace(err)
+	}
+	var journal *restore.Journal
+	if cfg.Journal {
+		journalStorage, err := cfg.journalStorage(ctx, s)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		journal = restore.NewJournal(journalStorage)
+		if err = journal.Record(ctx, restore.JournalEntry{Op: restore.JournalOpStart}); err != nil {
+			return errors.Trace(err)
+		}
+		log.Info("restore journal enabled", zap.String("job", journal.JobID()))
+		client.SetJournal(journal)
+	}
+	if cfg.Checksum && cfg.ChecksumChunkRegions > 0 {
+		// the checkpoints are recorded along with the journal, so the backup
+		// storage can be read-only.
+		checkpointStorage, err := cfg.journalStorage(ctx, s)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		client.SetChecksumChunks(cfg.ChecksumChunkRegions, checkpointStorage,
+			cfg.checksumCheckpointScope(ctx, mgr, backupMeta.GetEndVersion()))
+	}
 
 	if client.IsRawKvMode() {
 		return errors.Annotate(berrors.ErrRestoreModeMismatch, "cannot do transactional restore from raw kv data")
 	}
+	txnKvMeta, err := metautil.ReadTxnKvMeta(ctx, s)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if txnKvMeta != nil {
+		return errors.Annotate(berrors.ErrRestoreModeMismatch, "cannot do TiDB restore from txn kv data, use `br restore txn`")
+	}
 	if err = CheckRestoreDBAndTable(client, cfg); err != nil {
 		return err
 	}
@@ -268,8 +925,29 @@ func RunRestore(c context.Context, g glue.Glue, cmdName string, cfg *RestoreConf
 	if len(dbs) == 0 && len(tables) != 0 {
 		return errors.Annotate(berrors.ErrRestoreInvalidBackup, "contain tables but no databases")
 	}
+	if schemaOnly {
+		// the summary tells the tables are restored without data.
+		summary.FromContext(ctx).CollectInt("schema only tables", len(tables))
+	}
+	if len(cfg.MergeSchema) > 0 {
+		if tables, dbs, err = mergeSchemas(client, cfg, tables, dbs); err != nil {
+			return errors.Trace(err)
+		}
+	}
+	if cfg.Provenance && !cfg.NoSchema {
+		provenance := newProvenance(g, backupMeta, journal)
+		log.Info("record restore provenance into table comments", zap.String("job", provenance.JobID))
+		restore.TagProvenance(tables, provenance)
+	}
+	if err = checkArchivedFiles(ctx, s, files); err != nil {
+		return errors.Trace(err)
+	}
+	canceler := restore.NewTableCanceler(tables)
+	client.SetTableCanceler(canceler)
+	jobID, unregister := registerRestoreTables(canceler)
+	defer unregister()
 	archiveSize := reader.ArchiveSize(ctx, files)
-	g.Record(summary.RestoreDataSize, archiveSize)
+	recordRestoreDataSize(ctx, g, archiveSize)
 	restoreTS, err := client.GetTS(ctx)
 	if err != nil {
 		return errors.Trace(err)
@@ -283,10 +961,19 @@ func RunRestore(c context.Context, g glue.Glue, cmdName string, cfg *RestoreConf
 	// restore checksum will check safe point with its start ts, see details at
 	// https://github.com/pingcap/tidb/blob/180c02127105bed73712050594da6ead4d70a85f/store/tikv/kv.go#L186-L190
 	// so, we should keep the safe point unchangeable. to avoid GC life time is shorter than transaction duration.
-	err = utils.StartServiceSafePointKeeper(ctx, mgr.GetPDClient(), sp)
+	err = journal.Record(ctx, restore.JournalEntry{Op: restore.JournalOpSetSafePoint, SafePoint: &sp})
 	if err != nil {
 		return errors.Trace(err)
 	}
+	stopSafePoint := func() {}
+	// the mock cluster never runs GC.
+	if !cfg.MockCluster {
+		stopSafePoint, err = utils.StartServiceSafePointKeeper(ctx, mgr.GetPDClient(), sp)
+		if err != nil {
+			return errors.Trace(err)
+		}
+	}
+	defer stopSafePoint()
 
 	var newTS uint64
 	if client.IsIncremental() {
@@ -294,6 +981,10 @@ func RunRestore(c context.Context, g glue.Glue, cmdName string, cfg *RestoreConf
 	}
 	ddlJobs := restore.FilterDDLJobs(client.GetDDLJobs(), tables)
 
+	var tiFlashReplicas []restore.TiFlashReplica
+	if cfg.RestoreTiFlashReplica {
+		tiFlashReplicas = restore.TakeTiFlashReplicas(tables)
+	}
 	err = client.PreCheckTableTiFlashReplica(ctx, tables)
 	if err != nil {
 		return errors.Trace(err)
@@ -304,6 +995,22 @@ func RunRestore(c context.Context, g glue.Glue, cmdName string, cfg *RestoreConf
 		return errors.Trace(err)
 	}
 
+	if cfg.Confirm != nil && !cfg.NoSchema {
+		overwrites := findRestoreOverwrites(mgr.GetDomain(), tables)
+		if !overwrites.Empty() && !cfg.Confirm(overwrites) {
+			return errors.Annotate(berrors.ErrRestoreNotConfirmed, "the existing tables would be overwritten")
+		}
+	}
+
+	// the mock cluster has no changefeeds.
+	if !cfg.MockCluster {
+		resumeChangefeeds, err := coordinateCDC(ctx, cfg.CoordinateCDC, cfg.PD, mgr.GetTLSConfig(), tables)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		defer resumeChangefeeds()
+	}
+
 	// pre-set TiDB config for restore
 	restoreDBConfig := enableTiDBConfig()
 	defer restoreDBConfig()
@@ -318,8 +1025,8 @@ func RunRestore(c context.Context, g glue.Glue, cmdName string, cfg *RestoreConf
 	if len(dbs) == 0 && len(tables) == 0 {
 		log.Info("nothing to restore, all databases and tables are filtered out")
 		// even nothing to restore, we show a success message since there is no failure.
-		summary.SetSuccessStatus(true)
-		return nil
+		summary.FromContext(ctx).SetSuccessStatus(true)
+		return errors.Trace(journal.Record(ctx, restore.JournalEntry{Op: restore.JournalOpFinish}))
 	}
 
 	for _, db := range dbs {
@@ -350,10 +1057,24 @@ func RunRestore(c context.Context, g glue.Glue, cmdName string, cfg *RestoreConf
 			zap.Int("sessionCount", len(dbPool)),
 		)
 	}
+	// the ranges are found before the new tables are created.
+	warmUpRanges := restore.WarmUpRanges(mgr.GetDomain().InfoSchema().AllSchemas(), tables)
 	tableStream := client.GoCreateTables(ctx, mgr.GetDomain(), tables, newTS, dbPool, errCh)
+	if cfg.PreSplit {
+		var boundaries [][]byte
+		boundaries, err = metautil.ReadRegionTopology(ctx, s)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		if boundaries == nil {
+			log.Warn("the backup doesn't record the region topology, skip pre-split")
+		} else {
+			tableStream = client.GoPreSplitTables(ctx, tableStream, boundaries, errCh)
+		}
+	}
 	if len(files) == 0 {
 		log.Info("no files, empty databases and tables are restored")
-		summary.SetSuccessStatus(true)
+		summary.FromContext(ctx).SetSuccessStatus(true)
 		// don't return immediately, wait all pipeline done.
 	}
 
@@ -361,23 +1082,34 @@ func RunRestore(c context.Context, g glue.Glue, cmdName string, cfg *RestoreConf
 	log.Debug("mapped table to files", zap.Any("result map", tableFileMap))
 
 	rangeStream := restore.GoValidateFileRanges(
-		ctx, tableStream, tableFileMap, cfg.MergeSmallRegionKeyCount, cfg.MergeSmallRegionKeyCount, errCh)
+		ctx, tableStream, tableFileMap, cfg.MergeSmallRegionSizeBytes, cfg.MergeSmallRegionKeyCount, errCh)
+	var compactor *restore.Compactor
+	if cfg.Compact {
+		compactor = client.NewCompactor(cfg.CompactWait)
+		rangeStream = compactor.GoCollectRanges(ctx, rangeStream, errCh)
+		registerRestoreCompactor(jobID, compactor)
+	}
 
 	rangeSize := restore.EstimateRangeSize(files)
-	summary.CollectInt("restore ranges", rangeSize)
+	summary.FromContext(ctx).CollectInt("restore ranges", rangeSize)
 	log.Info("range and file prepared", zap.Int("file count", len(files)), zap.Int("range count", rangeSize))
 
-	restoreSchedulers, err := restorePreWork(ctx, client, mgr)
-	if err != nil {
-		return errors.Trace(err)
+	// the mock cluster has no schedulers to remove.
+	var rollback *restoreRollback
+	if !cfg.MockCluster {
+		rollback, err = restorePreWork(ctx, client, mgr, journal)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		rollback.safePoint, rollback.stopSafePoint = &sp, stopSafePoint
+		// Always run the post-work even on error, so we don't stuck in the import
+		// mode or emptied schedulers
+		defer restorePostWork(ctx, client, mgr, rollback, journal)
 	}
-	// Always run the post-work even on error, so we don't stuck in the import
-	// mode or emptied schedulers
-	defer restorePostWork(ctx, client, restoreSchedulers)
 
 	// Do not reset timestamp if we are doing incremental restore, because
 	// we are not allowed to decrease timestamp.
-	if !client.IsIncremental() {
+	if !client.IsIncremental() && !cfg.MockCluster {
 		if err = client.ResetTS(ctx, cfg.PD); err != nil {
 			log.Error("reset pd TS failed", zap.Error(err))
 			return errors.Trace(err)
@@ -391,6 +1123,10 @@ func RunRestore(c context.Context, g glue.Glue, cmdName string, cfg *RestoreConf
 		batchSize = v.(int)
 	})
 
+	// the regions of the new tables don't exist until they're split, the region
+	// after the existing tables is split into them.
+	client.WarmUp(ctx, warmUpRanges)
+
 	// Redirect to log if there is no log file to avoid unreadable output.
 	updateCh := g.StartProgress(
 		ctx,
@@ -399,6 +1135,35 @@ func RunRestore(c context.Context, g glue.Glue, cmdName string, cfg *RestoreConf
 		int64(rangeSize+len(files)+len(tables)),
 		!cfg.LogProgress)
 	defer updateCh.Close()
+	// the watchdog only watches the data restore, the system tables are
+	// restored without progress events.
+	watchdogCtx, stopWatchdog := context.WithCancel(ctx)
+	defer stopWatchdog()
+	if cfg.WatchdogTimeout > 0 {
+		watchdog := restore.NewWatchdog(cfg.WatchdogTimeout, cfg.WatchdogPolicy, os.TempDir())
+		watchdog.SetPhase("restore data")
+		client.SetWatchdog(watchdog)
+		updateCh = watchdog.Progress(updateCh)
+		go watchdog.Run(watchdogCtx, errCh)
+	}
+	// the import metrics of the stores are sampled during the data restore.
+	var storeMetrics *restore.StoreMetricsCollector
+	storeMetricsDone := make(chan struct{})
+	storeMetricsCtx, stopStoreMetrics := context.WithCancel(ctx)
+	defer stopStoreMetrics()
+	if cfg.StoreMetricsInterval > 0 {
+		stores, err := conn.GetAllTiKVStores(ctx, mgr.GetPDClient(), conn.SkipTiFlash)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		storeMetrics = restore.NewStoreMetricsCollector(client.GetTLSConfig(), stores, cfg.StoreMetricsInterval)
+		go func() {
+			defer close(storeMetricsDone)
+			storeMetrics.Run(storeMetricsCtx)
+		}()
+	} else {
+		close(storeMetricsDone)
+	}
 	sender, err := restore.NewTiKVSender(ctx, client, updateCh)
 	if err != nil {
 		return errors.Trace(err)
@@ -406,8 +1171,10 @@ func RunRestore(c context.Context, g glue.Glue, cmdName string, cfg *RestoreConf
 	manager := restore.NewBRContextManager(client)
 	batcher, afterRestoreStream := restore.NewBatcher(ctx, sender, manager, errCh)
 	batcher.SetThreshold(batchSize)
+	batcher.SetTableCanceler(canceler)
 	batcher.EnableAutoCommit(ctx, time.Second)
 	go restoreTableStream(ctx, rangeStream, batcher, errCh)
+	afterRestoreStream = client.GoRebuildIndexes(ctx, afterRestoreStream, errCh)
 
 	var finish <-chan struct{}
 	// Checksum
@@ -416,7 +1183,7 @@ func RunRestore(c context.Context, g glue.Glue, cmdName string, cfg *RestoreConf
 			ctx, afterRestoreStream, mgr.GetStorage().GetClient(), errCh, updateCh, cfg.ChecksumConcurrency)
 	} else {
 		// when user skip checksum, just collect tables, and drop them.
-		finish = dropToBlackhole(ctx, afterRestoreStream, errCh, updateCh)
+		finish = dropToBlackhole(ctx, afterRestoreStream, canceler, errCh, updateCh)
 	}
 
 	select {
@@ -424,26 +1191,150 @@ func RunRestore(c context.Context, g glue.Glue, cmdName string, cfg *RestoreConf
 		err = multierr.Append(err, multierr.Combine(restore.Exhaust(errCh)...))
 	case <-finish:
 	}
+	stopWatchdog()
+	stopStoreMetrics()
+	<-storeMetricsDone
+	if storeMetrics != nil {
+		storeMetrics.CollectSummary(ctx)
+	}
 
 	// If any error happened, return now.
 	if err != nil {
 		return errors.Trace(err)
 	}
 
+	if canceled := canceler.Canceled(); len(canceled) > 0 {
+		summary.FromContext(ctx).CollectInt("canceled tables", len(canceled))
+		log.Warn("some tables are canceled from the restore and probably incomplete, "+
+			"please drop or restore them again", zap.Any("tables", canceled))
+	}
+
+	if compactor != nil {
+		if err = compactor.Compact(ctx); err != nil {
+			return errors.Trace(err)
+		}
+	}
+
+	if cfg.WithPlacementRules {
+		if err = restorePlacementRules(ctx, client, mgr, s, cfg, tables); err != nil {
+			return errors.Trace(err)
+		}
+	}
+
+	if len(tiFlashReplicas) > 0 {
+		if err = client.RestoreTiFlashReplicas(ctx, tiFlashReplicas, cfg.WaitTiFlashReady); err != nil {
+			return errors.Trace(err)
+		}
+	}
+
 	// The cost of rename user table / replace into system table wouldn't be so high.
 	// So leave it out of the pipeline for easier implementation.
 	client.RestoreSystemSchemas(ctx, cfg.TableFilter)
 
+	if cfg.RunVerifyQueries {
+		if err = checkVerifyQueries(ctx, g, client, mgr, s); err != nil {
+			return errors.Trace(err)
+		}
+	}
+
+	if err = journal.Record(ctx, restore.JournalEntry{Op: restore.JournalOpFinish}); err != nil {
+		return errors.Trace(err)
+	}
+	if rollback != nil {
+		rollback.succeeded = true
+	}
 	// Set task summary to success status.
-	summary.SetSuccessStatus(true)
+	summary.FromContext(ctx).SetSuccessStatus(true)
+	return nil
+}
+
+// restorePlacementRules re-applies the placement rules of the tables recorded
+// by backup to the restored tables.
+func restorePlacementRules(
+	ctx context.Context,
+	client *restore.Client,
+	mgr *conn.Mgr,
+	s storage.ExternalStorage,
+	cfg *RestoreConfig,
+	tables []*metautil.Table,
+) error {
+	tableRules, err := metautil.ReadPlacementRules(ctx, s)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if len(tableRules) == 0 {
+		log.Info("no placement rules are recorded by backup")
+		return nil
+	}
+	mapping, err := restore.ParsePlacementLabelMapping(cfg.PlacementLabelMapping)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	err = client.RestorePlacementRules(ctx, mgr.GetDomain(), cfg.PD, tables, tableRules, mapping)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	summary.FromContext(ctx).CollectInt("tables with placement rules", len(tableRules))
 	return nil
 }
 
+// setBaseBackups sets the base backups of the data only incremental backup to
+// the reader, so the schemas of the tables not changed since the base backups
+// are read from them.
+func setBaseBackups(
+	ctx context.Context,
+	u *backuppb.StorageBackend,
+	s storage.ExternalStorage,
+	reader *metautil.MetaReader,
+	opts *storage.ExternalStorageOptions,
+) error {
+	for {
+		base, err := metautil.ReadBaseBackup(ctx, s)
+		if err != nil || base == nil {
+			return errors.Trace(err)
+		}
+		// the name is relative to the directory of the backup index.
+		parent := u
+		for depth := 0; depth < base.Depth || depth == 0; depth++ {
+			if parent, _, err = storage.ParentBackend(parent); err != nil {
+				return errors.Annotatef(err, "failed to find the base backup %s", base.Name)
+			}
+		}
+		u, err = storage.SubBackend(parent, base.Name)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		s, err = storage.New(ctx, u, opts)
+		if err != nil {
+			return errors.Annotatef(err, "failed to open the base backup %s", base.Name)
+		}
+		data, err := s.ReadFile(ctx, metautil.MetaFile)
+		if err != nil {
+			return errors.Annotatef(err, "failed to read the base backup %s", base.Name)
+		}
+		baseMeta := &backuppb.BackupMeta{}
+		if err = proto.Unmarshal(data, baseMeta); err != nil {
+			return errors.Annotatef(berrors.ErrInvalidMetaFile,
+				"failed to parse the backupmeta of the base backup %s: %v", base.Name, err)
+		}
+		if baseMeta.EndVersion != base.EndVersion {
+			return errors.Annotatef(berrors.ErrInvalidMetaFile,
+				"the end version of the base backup %s is %d, expect %d", base.Name, baseMeta.EndVersion, base.EndVersion)
+		}
+		log.Info("read the unchanged schemas from the base backup",
+			zap.String("base", base.Name), zap.Int("tables", len(base.TableIDs)))
+		baseReader := metautil.NewMetaReader(baseMeta, s)
+		reader.SetBase(baseReader, base.TableIDs)
+		reader = baseReader
+	}
+}
+
 // dropToBlackhole drop all incoming tables into black hole,
 // i.e. don't execute checksum, just increase the process anyhow.
 func dropToBlackhole(
 	ctx context.Context,
 	tableStream <-chan restore.CreatedTable,
+	canceler *restore.TableCanceler,
 	errCh chan<- error,
 	updateCh glue.Progress,
 ) <-chan struct{} {
@@ -457,10 +1348,11 @@ func dropToBlackhole(
 			case <-ctx.Done():
 				errCh <- ctx.Err()
 				return
-			case _, ok := <-tableStream:
+			case tbl, ok := <-tableStream:
 				if !ok {
 					return
 				}
+				canceler.Finish(tbl.OldTable)
 				updateCh.Inc()
 			}
 		}
@@ -493,36 +1385,148 @@ func filterRestoreFiles(
 	return
 }
 
+// checkArchivedFiles reads the first byte of the first and the last data
+// files, so the restore fails fast with an actionable error if the files are
+// archived by the storage, instead of failing when TiKV downloads them.
+func checkArchivedFiles(ctx context.Context, s storage.ExternalStorage, files []*backuppb.File) error {
+	if len(files) == 0 {
+		return nil
+	}
+	for _, file := range []*backuppb.File{files[0], files[len(files)-1]} {
+		_, err := s.ReadRange(ctx, file.Name, 0, 1)
+		// Other errors are left to TiKV, which reports them in detail.
+		if berrors.Is(err, berrors.ErrStorageObjectArchived) {
+			return errors.Trace(err)
+		}
+	}
+	return nil
+}
+
+// mergeSchemas maps the tables into the --merge-schema, and returns the tables
+// and databases to restore.
+func mergeSchemas(
+	client *restore.Client,
+	cfg *RestoreConfig,
+	tables []*metautil.Table,
+	dbs []*utils.Database,
+) ([]*metautil.Table, []*utils.Database, error) {
+	if client.IsIncremental() {
+		return nil, nil, errors.Annotatef(berrors.ErrInvalidArgument,
+			"--%s is not supported by incremental restore", flagMergeSchema)
+	}
+	target, tables, merged, err := restore.MergeSchemas(tables, restore.SchemaMerge{
+		Target:    cfg.MergeSchema,
+		TableName: cfg.MergeTableName,
+		Conflict:  cfg.MergeConflict,
+	})
+	if err != nil {
+		return nil, nil, errors.Trace(err)
+	}
+	client.SetMergedTables(merged)
+
+	newDBs := make([]*utils.Database, 0, len(dbs))
+	for _, db := range dbs {
+		if _, ok := utils.GetSysDBName(db.Info.Name); ok || utils.IsSysDB(db.Info.Name.L) {
+			newDBs = append(newDBs, db)
+		}
+	}
+	if target != nil {
+		newDBs = append(newDBs, &utils.Database{Info: target})
+	}
+	log.Info("merge schemas", zap.String("schema", cfg.MergeSchema),
+		zap.Int("tables", len(tables)), zap.Int("merged tables", len(merged)))
+	return tables, newDBs, nil
+}
+
+// recordRestoreDataSize records the size of the restored data by the glue, and
+// into the summary of the restore if it's collected apart from the summary of
+// the process, which the glue records into.
+func recordRestoreDataSize(ctx context.Context, g glue.Glue, size uint64) {
+	g.Record(summary.RestoreDataSize, size)
+	if c := summary.FromContext(ctx); c != summary.FromContext(context.Background()) {
+		c.CollectSuccessUnit(summary.RestoreDataSize, 1, size)
+	}
+}
+
+// restoreRollback is the cluster changes of the restore rolled back by
+// restorePostWork.
+type restoreRollback struct {
+	// restoreSchedulers restores the PD schedulers, unless they are kept
+	// removed for the other restores of the cluster still running.
+	restoreSchedulers func(context.Context) (kept bool, err error)
+	// schedulersKept is whether the PD schedulers are kept removed after the
+	// restore exits.
+	schedulersKept bool
+	// origin is the PD schedulers and configs before restorePreWork removed
+	// them, nil if the restore is online.
+	origin *pdutil.ClusterConfig
+	// safePoint is the service safe point kept by the restore until
+	// stopSafePoint is called, nil if there's none. stopSafePoint returns
+	// after the keeper of the service safe point exits.
+	safePoint     *utils.BRServiceSafePoint
+	stopSafePoint func()
+	// succeeded is whether the restore succeeded, the rollback of a failed or
+	// aborted restore is verified.
+	succeeded bool
+}
+
 // restorePreWork executes some prepare work before restore.
-// TODO make this function returns a restore post work.
-func restorePreWork(ctx context.Context, client *restore.Client, mgr *conn.Mgr) (pdutil.UndoFunc, error) {
+func restorePreWork(
+	ctx context.Context, client *restore.Client, mgr *conn.Mgr, journal *restore.Journal,
+) (*restoreRollback, error) {
 	if client.IsOnline() {
-		return pdutil.Nop, nil
+		return &restoreRollback{restoreSchedulers: func(context.Context) (bool, error) { return false, nil }}, nil
 	}
 
+	err := journal.Record(ctx, restore.JournalEntry{Op: restore.JournalOpSwitchImportMode})
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
 	// Switch TiKV cluster to import mode (adjust rocksdb configuration).
 	client.SwitchToImportMode(ctx)
 
-	return mgr.RemoveSchedulers(ctx)
+	// The schedulers are shared by the restores of the cluster in the process.
+	origin, restoreSchedulers, err := removeSchedulers(ctx, mgr.GetPDClient().GetClusterID(ctx), mgr)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	// The origin config is known only after removing, and the paused schedulers
+	// would be resumed by PD after the pause TTL anyway, so just warn on failure.
+	err = journal.Record(ctx, restore.JournalEntry{Op: restore.JournalOpRemoveSchedulers, Schedulers: &origin})
+	if err != nil {
+		log.Warn("failed to record removed schedulers into journal", zap.Error(err))
+	}
+	return &restoreRollback{restoreSchedulers: restoreSchedulers, origin: &origin}, nil
 }
 
-// restorePostWork executes some post work after restore.
+// restorePostWork executes some post work after restore. If the restore
+// failed or was aborted, it verifies the cluster is rolled back and reports
+// the leftovers with the commands to roll them back manually.
 // TODO: aggregate all lifetime manage methods into batcher's context manager field.
 func restorePostWork(
-	ctx context.Context, client *restore.Client, restoreSchedulers pdutil.UndoFunc,
+	ctx context.Context, client *restore.Client, mgr *conn.Mgr, rollback *restoreRollback, journal *restore.Journal,
 ) {
 	if ctx.Err() != nil {
 		log.Warn("context canceled, try shutdown")
 		ctx = context.Background()
 	}
-	if client.IsOnline() {
-		return
-	}
-	if err := client.SwitchToNormalMode(ctx); err != nil {
-		log.Warn("fail to switch to normal mode", zap.Error(err))
+	if !client.IsOnline() {
+		if err := client.SwitchToNormalMode(ctx); err != nil {
+			log.Warn("fail to switch to normal mode", zap.Error(err))
+		} else if err = journal.Record(ctx, restore.JournalEntry{Op: restore.JournalOpSwitchNormalMode}); err != nil {
+			log.Warn("failed to record switching to normal mode into journal", zap.Error(err))
+		}
+		kept, err := rollback.restoreSchedulers(ctx)
+		rollback.schedulersKept = kept
+		if err != nil {
+			log.Warn("failed to restore PD schedulers", zap.Error(err))
+		} else if err = journal.Record(ctx, restore.JournalEntry{Op: restore.JournalOpRestoreSchedulers}); err != nil {
+			log.Warn("failed to record restoring PD schedulers into journal", zap.Error(err))
+		}
 	}
-	if err := restoreSchedulers(ctx); err != nil {
-		log.Warn("failed to restore PD schedulers", zap.Error(err))
+	if !rollback.succeeded {
+		verifier := &clusterRollbackVerifier{client: client, Mgr: mgr}
+		reportRestoreLeftovers(ctx, verifyRestoreRollback(ctx, verifier, rollback))
 	}
 }
 
@@ -0,0 +1,190 @@
+// Copyright 2021 PingCAP, Inc. Licensed under Apache-2.0.
+
+package task
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+
+	"github.com/pingcap/log"
+	"go.uber.org/zap"
+
+	berrors "github.com/pingcap/br/pkg/errors"
+	"github.com/pingcap/br/pkg/restore"
+)
+
+var (
+	runningRestoreMu  sync.Mutex
+	runningRestoreSeq uint64
+	// runningRestores are the running restores of the process by the job IDs,
+	// a process may run many restores in parallel, e.g. in the server mode.
+	runningRestores = make(map[string]*runningRestore)
+)
+
+// runningRestore is the state of a running restore served by the status server.
+type runningRestore struct {
+	// canceler is the canceler of the tables of the restore.
+	canceler *restore.TableCanceler
+	// compactor is the compactor of the restore, nil if disabled.
+	compactor *restore.Compactor
+}
+
+// The status server lists the running restores at `GET /restore/jobs`,
+// serves the tables of a running restore at `GET /restore/tables?job=<id>`,
+// and cancels a table by
+// `POST /restore/tables/cancel?job=<id>&db=<db>&table=<table>`. The progress
+// of the compaction of each store after the restore is served at
+// `GET /restore/compaction?job=<id>`. The job can be omitted if there is only
+// one running restore.
+func init() { // nolint:gochecknoinits
+	http.HandleFunc("/restore/jobs", handleRestoreJobs)
+	http.HandleFunc("/restore/tables", handleRestoreTables)
+	http.HandleFunc("/restore/tables/cancel", handleCancelRestoreTable)
+	http.HandleFunc("/restore/compaction", handleRestoreCompaction)
+}
+
+// registerRestoreTables registers the canceler of a running restore to the
+// status server, and returns the job ID and the function unregistering it.
+func registerRestoreTables(canceler *restore.TableCanceler) (string, func()) {
+	runningRestoreMu.Lock()
+	runningRestoreSeq++
+	jobID := strconv.FormatUint(runningRestoreSeq, 10)
+	runningRestores[jobID] = &runningRestore{canceler: canceler}
+	runningRestoreMu.Unlock()
+	log.Info("restore registered to the status server", zap.String("job", jobID))
+	return jobID, func() {
+		runningRestoreMu.Lock()
+		delete(runningRestores, jobID)
+		runningRestoreMu.Unlock()
+	}
+}
+
+// registerRestoreCompactor registers the compactor of the running restore of
+// the job to the status server.
+func registerRestoreCompactor(jobID string, compactor *restore.Compactor) {
+	runningRestoreMu.Lock()
+	defer runningRestoreMu.Unlock()
+	if r, ok := runningRestores[jobID]; ok {
+		r.compactor = compactor
+	}
+}
+
+// getRunningRestore returns the running restore of the job, or the only one
+// if the job is empty, the error is written to the response if not found.
+func getRunningRestore(w http.ResponseWriter, jobID string) *runningRestore {
+	runningRestoreMu.Lock()
+	defer runningRestoreMu.Unlock()
+	if jobID == "" {
+		switch len(runningRestores) {
+		case 0:
+		case 1:
+			for _, r := range runningRestores {
+				return r
+			}
+		default:
+			writeJSONError(w, http.StatusBadRequest, "there are many running restores, job is required")
+			return nil
+		}
+	}
+	r, ok := runningRestores[jobID]
+	if !ok {
+		writeJSONError(w, http.StatusNotFound, "no running restore")
+		return nil
+	}
+	return r
+}
+
+func handleRestoreJobs(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if req.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		writeJSONError(w, http.StatusMethodNotAllowed, "only GET is allowed")
+		return
+	}
+	runningRestoreMu.Lock()
+	jobs := make([]string, 0, len(runningRestores))
+	for jobID := range runningRestores {
+		jobs = append(jobs, jobID)
+	}
+	runningRestoreMu.Unlock()
+	sort.Strings(jobs)
+	w.WriteHeader(http.StatusOK)
+	_ = json.NewEncoder(w).Encode(jobs)
+}
+
+func handleRestoreTables(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if req.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		writeJSONError(w, http.StatusMethodNotAllowed, "only GET is allowed")
+		return
+	}
+	r := getRunningRestore(w, req.URL.Query().Get("job"))
+	if r == nil {
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	_ = json.NewEncoder(w).Encode(r.canceler.List())
+}
+
+func handleCancelRestoreTable(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if req.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		writeJSONError(w, http.StatusMethodNotAllowed, "only POST is allowed")
+		return
+	}
+	db, table := req.URL.Query().Get("db"), req.URL.Query().Get("table")
+	if db == "" || table == "" {
+		writeJSONError(w, http.StatusBadRequest, "db and table are required")
+		return
+	}
+	r := getRunningRestore(w, req.URL.Query().Get("job"))
+	if r == nil {
+		return
+	}
+	if err := r.canceler.Cancel(db, table); err != nil {
+		code := http.StatusInternalServerError
+		if berrors.Is(err, berrors.ErrRestoreTableNotCancelable) {
+			code = http.StatusConflict
+		}
+		writeJSONError(w, code, err.Error())
+		return
+	}
+	log.Info("table canceled by the status server", zap.String("db", db), zap.String("table", table),
+		zap.String("remote", req.RemoteAddr))
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write([]byte("{}"))
+}
+
+func handleRestoreCompaction(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if req.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		writeJSONError(w, http.StatusMethodNotAllowed, "only GET is allowed")
+		return
+	}
+	r := getRunningRestore(w, req.URL.Query().Get("job"))
+	if r == nil {
+		return
+	}
+	runningRestoreMu.Lock()
+	compactor := r.compactor
+	runningRestoreMu.Unlock()
+	if compactor == nil {
+		writeJSONError(w, http.StatusNotFound, "no running restore with compaction")
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	_ = json.NewEncoder(w).Encode(compactor.Progress())
+}
+
+func writeJSONError(w http.ResponseWriter, code int, message string) {
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(struct {
+		Error string `json:"error"`
+	}{Error: message})
+}
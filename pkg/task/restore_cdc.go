@@ -0,0 +1,118 @@
+// Copyright 2021 PingCAP, Inc. Licensed under Apache-2.0.
+
+package task
+
+import (
+	"context"
+	"crypto/tls"
+	"time"
+
+	"github.com/pingcap/errors"
+	"github.com/pingcap/log"
+	"go.etcd.io/etcd/clientv3"
+	"go.uber.org/zap"
+
+	"github.com/pingcap/br/pkg/cdcutil"
+	berrors "github.com/pingcap/br/pkg/errors"
+	"github.com/pingcap/br/pkg/metautil"
+)
+
+const cdcEtcdDialTimeout = 5 * time.Second
+
+// changefeedsOfTables returns the active changefeeds replicating any of the
+// tables.
+func changefeedsOfTables(changefeeds []*cdcutil.Changefeed, tables []*metautil.Table) ([]*cdcutil.Changefeed, error) {
+	var matched []*cdcutil.Changefeed
+	for _, cf := range changefeeds {
+		if !cf.IsActive() {
+			continue
+		}
+		for _, table := range tables {
+			match, err := cf.MatchTable(table.DB.Name.O, table.Info.Name.O)
+			if err != nil {
+				return nil, errors.Trace(err)
+			}
+			if match {
+				matched = append(matched, cf)
+				break
+			}
+		}
+	}
+	return matched, nil
+}
+
+// coordinateCDC finds the active TiCDC changefeeds replicating the restored
+// tables by the etcd of PD, since the ingested data bypasses them and the
+// downstream would miss it. The changefeeds are warned about, paused or fail
+// the restore by the policy. The returned function resumes the paused ones.
+func coordinateCDC(
+	ctx context.Context,
+	policy cdcutil.Policy,
+	pdAddrs []string,
+	tlsConf *tls.Config,
+	tables []*metautil.Table,
+) (func(), error) {
+	nop := func() {}
+	if policy == cdcutil.PolicyNone || len(tables) == 0 {
+		return nop, nil
+	}
+	etcdCli, err := clientv3.New(clientv3.Config{
+		Endpoints:   pdAddrs,
+		TLS:         tlsConf,
+		DialTimeout: cdcEtcdDialTimeout,
+	})
+	if err != nil {
+		return nil, errors.Annotate(err, "failed to connect the etcd of PD to find the changefeeds")
+	}
+	cli := cdcutil.NewClient(etcdCli, tlsConf)
+	changefeeds, err := cli.Changefeeds(ctx)
+	if err != nil {
+		_ = etcdCli.Close()
+		return nil, errors.Trace(err)
+	}
+	matched, err := changefeedsOfTables(changefeeds, tables)
+	if err != nil || len(matched) == 0 {
+		_ = etcdCli.Close()
+		return nop, errors.Trace(err)
+	}
+	ids := make([]string, 0, len(matched))
+	for _, cf := range matched {
+		ids = append(ids, cf.ID)
+	}
+
+	switch policy {
+	case cdcutil.PolicyWarn:
+		_ = etcdCli.Close()
+		log.Warn("the restored tables are replicated by the active changefeeds, "+
+			"the ingested data isn't replicated to their downstream", zap.Strings("changefeeds", ids))
+		return nop, nil
+	case cdcutil.PolicyFail:
+		_ = etcdCli.Close()
+		return nil, errors.Annotatef(berrors.ErrRestoreActiveChangefeed,
+			"changefeeds %v, pause them or use --%s=pause", ids, flagCoordinateCDC)
+	}
+
+	paused := make([]string, 0, len(ids))
+	resume := func() {
+		defer etcdCli.Close()
+		// resume them even if the restore is canceled.
+		resumeCtx := context.Background()
+		for _, id := range paused {
+			if e := cli.Resume(resumeCtx, id); e != nil {
+				log.Warn("failed to resume the changefeed, you may need to resume it manually",
+					zap.String("changefeed", id), zap.Error(e))
+				continue
+			}
+			log.Info("changefeed resumed", zap.String("changefeed", id))
+		}
+	}
+	for _, id := range ids {
+		if err = cli.Pause(ctx, id); err != nil {
+			resume()
+			return nil, errors.Annotatef(err, "failed to pause the changefeed %s", id)
+		}
+		log.Info("changefeed paused during the restore", zap.String("changefeed", id))
+		paused = append(paused, id)
+	}
+	return resume, nil
+}
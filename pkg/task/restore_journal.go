@@ -0,0 +1,172 @@
+// Copyright 2021 PingCAP, Inc. Licensed under Apache-2.0.
+
+package task
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"sort"
+
+	"github.com/pingcap/errors"
+	"github.com/pingcap/log"
+	"go.uber.org/zap"
+
+	"github.com/pingcap/br/pkg/checksum"
+	"github.com/pingcap/br/pkg/conn"
+	"github.com/pingcap/br/pkg/glue"
+	"github.com/pingcap/br/pkg/restore"
+	"github.com/pingcap/br/pkg/storage"
+	"github.com/pingcap/br/pkg/summary"
+)
+
+// journalStorage returns the storage the journal and the checksum checkpoints
+// are recorded into, the backup storage s unless --journal-storage is set.
+func (cfg *RestoreConfig) journalStorage(ctx context.Context, s storage.ExternalStorage) (storage.ExternalStorage, error) {
+	if cfg.JournalStorage == "" {
+		return s, nil
+	}
+	u, err := storage.ParseBackend(cfg.JournalStorage, &cfg.BackendOptions)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	journalStorage, err := storage.New(ctx, u, storageOpts(&cfg.Config))
+	if err != nil {
+		return nil, errors.Annotate(err, "create journal storage failed")
+	}
+	return journalStorage, nil
+}
+
+// checksumCheckpointScope returns the scope of the checksum checkpoints of the
+// restore. The job is identified by the backup storage, whose URL is hashed
+// since it may contain the credentials.
+func (cfg *RestoreConfig) checksumCheckpointScope(
+	ctx context.Context, mgr *conn.Mgr, snapshotTS uint64,
+) checksum.CheckpointScope {
+	job := sha256.Sum256([]byte(cfg.Storage))
+	return checksum.CheckpointScope{
+		ClusterID:  mgr.GetPDClient().GetClusterID(ctx),
+		Job:        hex.EncodeToString(job[:]),
+		SnapshotTS: snapshotTS,
+	}
+}
+
+// RunRecoverJournal rolls back the cluster changes left by the crashed restore
+// jobs according to their journals in the backup storage, or in the storage
+// of --journal-storage if it's set.
+func RunRecoverJournal(c context.Context, g glue.Glue, cmdName string, cfg *RestoreConfig) error {
+	cfg.adjustRestoreConfig()
+
+	defer summary.Summary(cmdName)
+	ctx, cancel := context.WithCancel(c)
+	defer cancel()
+
+	_, s, err := GetStorage(ctx, &cfg.Config)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if s, err = cfg.journalStorage(ctx, s); err != nil {
+		return errors.Trace(err)
+	}
+	journals, err := restore.LoadJournals(ctx, s)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	jobIDs := make([]string, 0, len(journals))
+	for jobID, entries := range journals {
+		if restore.ReplayJournal(entries).NeedRecover() {
+			jobIDs = append(jobIDs, jobID)
+		}
+	}
+	if len(jobIDs) == 0 {
+		log.Info("no restore job needs to recover", zap.Int("journal count", len(journals)))
+		summary.SetSuccessStatus(true)
+		return nil
+	}
+	sort.Strings(jobIDs)
+
+	mgr, err := NewMgr(ctx, g, cfg.PD, cfg.TLS, GetKeepalive(&cfg.Config), cfg.Timeout, cfg.CheckRequirements, false)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	defer mgr.Close()
+
+	keepaliveCfg := GetKeepalive(&cfg.Config)
+	keepaliveCfg.PermitWithoutStream = true
+	client, err := restore.NewRestoreClient(g, mgr.GetPDClient(), mgr.GetStorage(), mgr.GetTLSConfig(), keepaliveCfg)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	defer client.Close()
+	client.SetTimeoutConfig(cfg.Timeout)
+
+	switchedToNormal := false
+	for _, jobID := range jobIDs {
+		entries := journals[jobID]
+		journal := restore.OpenJournal(s, jobID, entries)
+		client.SetJournal(journal)
+		err = recoverRestoreJob(ctx, client, mgr, journal, restore.ReplayJournal(entries), &switchedToNormal)
+		if err != nil {
+			return errors.Annotatef(err, "failed to recover restore job %s", jobID)
+		}
+	}
+	summary.CollectInt("recovered jobs", len(jobIDs))
+	summary.SetSuccessStatus(true)
+	return nil
+}
+
+func recoverRestoreJob(
+	ctx context.Context,
+	client *restore.Client,
+	mgr *conn.Mgr,
+	journal *restore.Journal,
+	state *restore.JournalState,
+	switchedToNormal *bool,
+) error {
+	log.Info("start recovering restore job", zap.String("job", journal.JobID()), zap.Bool("finished", state.Finished))
+	if len(state.PlacementTableIDs) > 0 {
+		if err := client.DeletePlacementRules(ctx, state.PlacementTableIDs); err != nil {
+			return errors.Trace(err)
+		}
+	}
+	if state.ImportMode {
+		// the import mode is cluster-wide, so switch only once for all jobs.
+		if !*switchedToNormal {
+			if err := client.SwitchToNormalMode(ctx); err != nil {
+				return errors.Trace(err)
+			}
+			*switchedToNormal = true
+		}
+		err := journal.Record(ctx, restore.JournalEntry{Op: restore.JournalOpSwitchNormalMode})
+		if err != nil {
+			return errors.Trace(err)
+		}
+	}
+	if state.Schedulers != nil {
+		if err := mgr.MakeUndoFunctionByConfig(*state.Schedulers)(ctx); err != nil {
+			return errors.Trace(err)
+		}
+		err := journal.Record(ctx, restore.JournalEntry{Op: restore.JournalOpRestoreSchedulers})
+		if err != nil {
+			return errors.Trace(err)
+		}
+	}
+	if sp := state.SafePoint; sp != nil {
+		// a service safe point with non-positive TTL is removed by PD.
+		_, err := mgr.GetPDClient().UpdateServiceGCSafePoint(ctx, sp.ID, 0, sp.BackupTS-1)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		log.Info("service safe point removed", zap.Object("safePoint", sp))
+	}
+	if !state.Finished {
+		for _, change := range state.SchemaChanges {
+			log.Warn("schema changed by the unfinished restore job, please check and revert it manually if needed",
+				zap.String("op", string(change.Op)),
+				zap.String("db", change.DB),
+				zap.String("table", change.Table),
+				zap.String("query", change.Query))
+		}
+	}
+	return errors.Trace(journal.Record(ctx, restore.JournalEntry{Op: restore.JournalOpRecovered}))
+}
@@ -4,12 +4,19 @@ package task
 
 import (
 	"context"
+	"time"
 
+	"github.com/docker/go-units"
 	"github.com/pingcap/errors"
+	"github.com/pingcap/log"
 	"github.com/spf13/cobra"
 	"github.com/spf13/pflag"
+	"go.uber.org/zap"
 
+	"github.com/pingcap/br/pkg/cdclog"
+	berrors "github.com/pingcap/br/pkg/errors"
 	"github.com/pingcap/br/pkg/glue"
+	"github.com/pingcap/br/pkg/kv"
 	"github.com/pingcap/br/pkg/restore"
 	"github.com/pingcap/br/pkg/storage"
 )
@@ -19,6 +26,15 @@ const (
 	flagEndTS           = "end-ts"
 	flagBatchWriteCount = "write-kvs"
 	flagBatchFlushCount = "flush-kvs"
+	flagFlushInterval   = "flush-interval"
+	flagFlushTSWindow   = "flush-ts-window"
+	flagMetaCacheSize   = "meta-cache-size"
+	flagShardHandle     = "shard-handle"
+	flagShardHandleBits = "shard-handle-bits"
+	flagColumnTransform = "column-transform"
+	flagTransformPlugin = "transform-plugin"
+	flagReadConcurrency = "read-concurrency"
+	flagReadRateLimit   = "read-rate-limit"
 
 	// represents kv flush to storage for each table.
 	defaultFlushKV = 5120
@@ -26,6 +42,10 @@ const (
 	defaultFlushKVSize = 5 << 20
 	// represents kv that write to TiKV once at at time.
 	defaultWriteKV = 1280
+	// represents the capacity of the cache of ddl files and log meta.
+	defaultMetaCacheSize = 64 << 20
+	// represents the parallel reads of the log files shared by all tables.
+	defaultReadConcurrency = 32
 )
 
 // LogRestoreConfig is the configuration specific for restore tasks.
@@ -38,6 +58,40 @@ type LogRestoreConfig struct {
 	BatchFlushKVPairs int
 	BatchFlushKVSize  int64
 	BatchWriteKVPairs int
+
+	// FlushInterval and FlushTSWindow flush the buffered kvs of a table once
+	// the oldest kv waits for the interval or the kvs span the window of the
+	// commit ts, besides the kv count and size. 0 disables them.
+	FlushInterval time.Duration
+	FlushTSWindow time.Duration
+
+	// MetaCacheSize is the capacity in bytes of the caches of the small
+	// objects read repeatedly: the cache of the storage, e.g. of the log meta,
+	// and the cache of the ddl files read by every table. 0 disables them.
+	MetaCacheSize int64
+
+	// ShardHandles are the rules in the form of `pattern=shard-id`, the
+	// handles of the matched tables are rewritten with the shard id in the
+	// highest ShardHandleBits bits, so the shard tables merged into one table
+	// by the rewrite rules don't collide.
+	ShardHandles    []string
+	ShardHandleBits uint64
+
+	// ColumnTransforms are the rules in the form of `pattern:column=transform`
+	// transforming the columns of the matched tables before restored, e.g.
+	// masking the sensitive data. TransformPlugin is the path of the Go plugin
+	// exporting the functions used by the `plugin(name)` transforms.
+	ColumnTransforms []string
+	TransformPlugin  string
+
+	// ReadConcurrency and ReadRateLimit bound the parallel reads and the
+	// bytes per second of reading the log files by the pullers of all tables.
+	// 0 means unlimited.
+	ReadConcurrency int
+	ReadRateLimit   uint64
+
+	// RewriteRulesFile is the path of a JSON file contains extra key rewrite rules.
+	RewriteRulesFile string
 }
 
 // DefineLogRestoreFlags defines common flags for the backup command.
@@ -47,6 +101,29 @@ func DefineLogRestoreFlags(command *cobra.Command) {
 
 	command.Flags().Uint64P(flagBatchWriteCount, "", 0, "the kv count that write to TiKV once at a time")
 	command.Flags().Uint64P(flagBatchFlushCount, "", 0, "the kv count that flush from memory to TiKV")
+	command.Flags().Duration(flagFlushInterval, 0,
+		"flush the kvs of a table to TiKV once the oldest one has been buffered for the interval, 0 disables it")
+	command.Flags().Duration(flagFlushTSWindow, 0,
+		"flush the kvs of a table to TiKV once their commit ts span the window, 0 disables it")
+	command.Flags().Int64(flagMetaCacheSize, defaultMetaCacheSize,
+		"the capacity in bytes of the cache of the small objects read from the storage, e.g. the log meta, "+
+			"and of the cache of ddl files shared by all tables, 0 disables the caches")
+	command.Flags().StringArray(flagShardHandle, nil,
+		"rewrite the integer handles of the tables matched by the pattern with the shard id, "+
+			"in the form of pattern=shard-id, e.g. 'shard_0001.*=1'. "+
+			"the shard id replaces the shard bits of auto_random primary keys")
+	command.Flags().Uint64(flagShardHandleBits, kv.DefaultShardHandleBits,
+		"the number of the highest bits of the handles reserved for the shard id")
+	command.Flags().StringArray(flagColumnTransform, nil,
+		"transform the column of the tables matched by the pattern before restored, "+
+			"in the form of pattern:column=transform, e.g. 'prod.users:email=hash'. "+
+			"the transform is one of null, const(value), mask(prefix,suffix), hash, hash(salt) and plugin(name)")
+	command.Flags().String(flagTransformPlugin, "",
+		"the path of the Go plugin exporting the func(string) (string, error) used by the plugin(name) transforms")
+	command.Flags().Int(flagReadConcurrency, defaultReadConcurrency,
+		"the max parallel reads of the log files shared by all tables, 0 means unlimited")
+	command.Flags().String(flagReadRateLimit, "0",
+		"the max bytes per second of reading the log files shared by all tables, e.g. 200MB, 0 means unlimited")
 }
 
 // ParseFromFlags parses the restore-related flags from the flag set.
@@ -60,6 +137,56 @@ func (cfg *LogRestoreConfig) ParseFromFlags(flags *pflag.FlagSet) error {
 	if err != nil {
 		return errors.Trace(err)
 	}
+	cfg.RewriteRulesFile, err = flags.GetString(flagRewriteRulesFile)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	cfg.FlushInterval, err = flags.GetDuration(flagFlushInterval)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	cfg.FlushTSWindow, err = flags.GetDuration(flagFlushTSWindow)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	cfg.MetaCacheSize, err = flags.GetInt64(flagMetaCacheSize)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	cfg.ShardHandles, err = flags.GetStringArray(flagShardHandle)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	cfg.ShardHandleBits, err = flags.GetUint64(flagShardHandleBits)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	cfg.ColumnTransforms, err = flags.GetStringArray(flagColumnTransform)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	cfg.TransformPlugin, err = flags.GetString(flagTransformPlugin)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	cfg.ReadConcurrency, err = flags.GetInt(flagReadConcurrency)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if cfg.ReadConcurrency < 0 {
+		return errors.Annotatef(berrors.ErrInvalidArgument, "--%s must not be negative", flagReadConcurrency)
+	}
+	readRateLimit, err := flags.GetString(flagReadRateLimit)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if readRateLimit != "" {
+		limit, err := units.RAMInBytes(readRateLimit)
+		if err != nil || limit < 0 {
+			return errors.Annotatef(berrors.ErrInvalidArgument, "invalid --%s %q", flagReadRateLimit, readRateLimit)
+		}
+		cfg.ReadRateLimit = uint64(limit)
+	}
 	err = cfg.Config.ParseFromFlags(flags)
 	if err != nil {
 		return errors.Trace(err)
@@ -83,6 +210,9 @@ func (cfg *LogRestoreConfig) adjustRestoreConfig() {
 	if cfg.BatchWriteKVPairs == 0 {
 		cfg.BatchWriteKVPairs = defaultWriteKV
 	}
+	if cfg.ShardHandleBits == 0 {
+		cfg.ShardHandleBits = kv.DefaultShardHandleBits
+	}
 	if cfg.BatchFlushKVSize == 0 {
 		cfg.BatchFlushKVSize = defaultFlushKVSize
 	}
@@ -92,16 +222,31 @@ func (cfg *LogRestoreConfig) adjustRestoreConfig() {
 	}
 }
 
+// flushPolicy returns the policy of flushing the table buffers.
+func (cfg *LogRestoreConfig) flushPolicy() cdclog.FlushPolicy {
+	policies := []cdclog.FlushPolicy{cdclog.DefaultFlushPolicy(cfg.BatchFlushKVPairs, cfg.BatchFlushKVSize)}
+	if cfg.FlushInterval > 0 {
+		policies = append(policies, cdclog.FlushOnInterval(cfg.FlushInterval))
+	}
+	if cfg.FlushTSWindow > 0 {
+		policies = append(policies, cdclog.FlushOnTSWindow(cfg.FlushTSWindow))
+	}
+	return cdclog.FlushOnAny(policies...)
+}
+
 // RunLogRestore starts a restore task inside the current goroutine.
 func RunLogRestore(c context.Context, g glue.Glue, cfg *LogRestoreConfig) error {
 	cfg.adjustRestoreConfig()
 
 	ctx, cancel := context.WithCancel(c)
 	defer cancel()
+	if err := startContinuousProfiling(ctx, &cfg.Config); err != nil {
+		return errors.Trace(err)
+	}
 
 	// Restore needs domain to do DDL.
 	needDomain := true
-	mgr, err := NewMgr(ctx, g, cfg.PD, cfg.TLS, GetKeepalive(&cfg.Config), cfg.CheckRequirements, needDomain)
+	mgr, err := NewMgr(ctx, g, cfg.PD, cfg.TLS, GetKeepalive(&cfg.Config), cfg.Timeout, cfg.CheckRequirements, needDomain)
 	if err != nil {
 		return errors.Trace(err)
 	}
@@ -118,13 +263,25 @@ func RunLogRestore(c context.Context, g glue.Glue, cfg *LogRestoreConfig) error
 		return errors.Trace(err)
 	}
 	defer client.Close()
+	client.SetTimeoutConfig(cfg.Timeout)
 
-	opts := storage.ExternalStorageOptions{
-		NoCredentials:   cfg.NoCreds,
-		SendCredentials: cfg.SendCreds,
-		SkipCheckPath:   cfg.SkipCheckPath,
+	opts := storageOpts(&cfg.Config)
+	opts.CacheSize = cfg.MetaCacheSize
+	if err = client.SetStorage(ctx, u, opts); err != nil {
+		return errors.Trace(err)
 	}
-	if err = client.SetStorage(ctx, u, &opts); err != nil {
+
+	extraRules, err := loadExtraRewriteRules(cfg.RewriteRulesFile)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	client.SetExtraRewriteRules(extraRules)
+	shardHandleRules, err := restore.ParseShardHandleRules(cfg.ShardHandles, cfg.ShardHandleBits)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	columnTransformRules, err := restore.ParseColumnTransformRules(cfg.ColumnTransforms, cfg.TransformPlugin)
+	if err != nil {
 		return errors.Trace(err)
 	}
 
@@ -139,6 +296,18 @@ func RunLogRestore(c context.Context, g glue.Glue, cfg *LogRestoreConfig) error
 	if err != nil {
 		return errors.Trace(err)
 	}
+	logClient.SetShardHandleRules(shardHandleRules)
+	logClient.SetColumnTransformRules(columnTransformRules)
+	logClient.SetFlushPolicy(cfg.flushPolicy())
+	readerPool := storage.NewReaderPool(cfg.ReadConcurrency, cfg.ReadRateLimit)
+	logClient.SetReaderPool(readerPool)
+	logClient.SetDDLCacheSize(cfg.MetaCacheSize)
 
-	return logClient.RestoreLogData(ctx, mgr.GetDomain())
+	err = logClient.RestoreLogData(ctx, mgr.GetDomain())
+	stats := readerPool.Stats()
+	log.Info("log files read",
+		zap.Int64("requests", stats.Requests),
+		zap.String("size", units.HumanSize(float64(stats.Bytes))),
+		zap.Duration("wait", stats.WaitDuration))
+	return errors.Trace(err)
 }
@@ -0,0 +1,47 @@
+// Copyright 2021 PingCAP, Inc. Licensed under Apache-2.0.
+
+package task
+
+import (
+	"context"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/pingcap/errors"
+	backuppb "github.com/pingcap/kvproto/pkg/backup"
+
+	"github.com/pingcap/br/pkg/glue"
+	"github.com/pingcap/br/pkg/restore"
+)
+
+// newProvenance returns the provenance of the current restore job. The job ID
+// is the ID of the journal if it's enabled.
+func newProvenance(g glue.Glue, backupMeta *backuppb.BackupMeta, journal *restore.Journal) *restore.Provenance {
+	jobID := journal.JobID()
+	if jobID == "" {
+		jobID = uuid.New().String()
+	}
+	return &restore.Provenance{
+		JobID:      jobID,
+		BackupTS:   backupMeta.GetEndVersion(),
+		ClusterID:  backupMeta.GetClusterId(),
+		Version:    g.GetVersion(),
+		RestoredAt: time.Now().UTC().Format(time.RFC3339),
+	}
+}
+
+// ListRestoreProvenance returns the provenance recorded in the comments of the
+// tables matched by the table filter.
+func ListRestoreProvenance(c context.Context, g glue.Glue, cfg *Config) ([]restore.TableProvenance, error) {
+	ctx, cancel := context.WithCancel(c)
+	defer cancel()
+
+	mgr, err := NewMgr(ctx, g, cfg.PD, cfg.TLS, GetKeepalive(cfg), cfg.Timeout, cfg.CheckRequirements, true)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	defer mgr.Close()
+
+	dbs := mgr.GetDomain().InfoSchema().AllSchemas()
+	return restore.ListProvenance(dbs, cfg.TableFilter.MatchTable), nil
+}
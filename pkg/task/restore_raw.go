@@ -50,7 +50,6 @@ func (cfg *RestoreRawConfig) ParseFromFlags(flags *pflag.FlagSet) error {
 
 func (cfg *RestoreRawConfig) adjust() {
 	cfg.Config.adjust()
-	cfg.RestoreCommonConfig.adjust()
 
 	if cfg.Concurrency == 0 {
 		cfg.Concurrency = defaultRestoreConcurrency
@@ -61,13 +60,21 @@ func (cfg *RestoreRawConfig) adjust() {
 func RunRestoreRaw(c context.Context, g glue.Glue, cmdName string, cfg *RestoreRawConfig) (err error) {
 	cfg.adjust()
 
-	defer summary.Summary(cmdName)
+	// the summary and the requests to S3 are collected per restore, many
+	// restores may run in parallel in a process.
+	c = summary.WithJobCollector(c)
+	defer summary.FromContext(c).Summary(cmdName)
+	c, collectS3Requests := withS3RequestCounter(c)
+	defer collectS3Requests()
 	ctx, cancel := context.WithCancel(c)
 	defer cancel()
+	if err := startContinuousProfiling(ctx, &cfg.Config); err != nil {
+		return errors.Trace(err)
+	}
 
 	// Restore raw does not need domain.
 	needDomain := false
-	mgr, err := NewMgr(ctx, g, cfg.PD, cfg.TLS, GetKeepalive(&cfg.Config), cfg.CheckRequirements, needDomain)
+	mgr, err := NewMgr(ctx, g, cfg.PD, cfg.TLS, GetKeepalive(&cfg.Config), cfg.Timeout, cfg.CheckRequirements, needDomain)
 	if err != nil {
 		return errors.Trace(err)
 	}
@@ -82,19 +89,37 @@ func RunRestoreRaw(c context.Context, g glue.Glue, cmdName string, cfg *RestoreR
 		return errors.Trace(err)
 	}
 	defer client.Close()
+	client.SetTimeoutConfig(cfg.Timeout)
 	client.SetRateLimit(cfg.RateLimit)
 	client.SetConcurrency(uint(cfg.Concurrency))
 	if cfg.Online {
 		client.EnableOnline()
 	}
 	client.SetSwitchModeInterval(cfg.SwitchModeInterval)
+	extraRules, err := loadExtraRewriteRules(cfg.RewriteRulesFile)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	client.SetExtraRewriteRules(extraRules)
 
 	u, s, backupMeta, err := ReadBackupMeta(ctx, metautil.MetaFile, &cfg.Config)
 	if err != nil {
 		return errors.Trace(err)
 	}
+	if err = checkRequesterPays(u, &cfg.Config); err != nil {
+		return errors.Trace(err)
+	}
+	if _, err = checkBackupFeatures(ctx, s); err != nil {
+		return errors.Trace(err)
+	}
 	reader := metautil.NewMetaReader(backupMeta, s)
-	if err = client.InitBackupMeta(c, backupMeta, u, s, reader); err != nil {
+	if err = client.InitBackupMeta(c, backupMeta, u, reader); err != nil {
+		return errors.Trace(err)
+	}
+	if err = cfg.limitImport(ctx, client); err != nil {
+		return errors.Trace(err)
+	}
+	if err = cfg.adjustMergeRegion(ctx, client); err != nil {
 		return errors.Trace(err)
 	}
 
@@ -107,16 +132,16 @@ func RunRestoreRaw(c context.Context, g glue.Glue, cmdName string, cfg *RestoreR
 		return errors.Trace(err)
 	}
 	archiveSize := reader.ArchiveSize(ctx, files)
-	g.Record(summary.RestoreDataSize, archiveSize)
+	recordRestoreDataSize(ctx, g, archiveSize)
 
 	if len(files) == 0 {
 		log.Info("all files are filtered out from the backup archive, nothing to restore")
 		return nil
 	}
-	summary.CollectInt("restore files", len(files))
+	summary.FromContext(ctx).CollectInt("restore files", len(files))
 
 	ranges, _, err := restore.MergeFileRanges(
-		files, cfg.MergeSmallRegionKeyCount, cfg.MergeSmallRegionKeyCount)
+		files, cfg.MergeSmallRegionSizeBytes, cfg.MergeSmallRegionKeyCount)
 	if err != nil {
 		return errors.Trace(err)
 	}
@@ -130,18 +155,19 @@ func RunRestoreRaw(c context.Context, g glue.Glue, cmdName string, cfg *RestoreR
 		int64(len(ranges)+len(files)),
 		!cfg.LogProgress)
 
-	// RawKV restore does not need to rewrite keys.
+	// RawKV restore is only rewritten by the user provided rules.
 	rewrite := &restore.RewriteRules{}
 	err = restore.SplitRanges(ctx, client, ranges, rewrite, updateCh)
 	if err != nil {
 		return errors.Trace(err)
 	}
+	client.WarmUp(ctx, ranges)
 
-	restoreSchedulers, err := restorePreWork(ctx, client, mgr)
+	rollback, err := restorePreWork(ctx, client, mgr, nil)
 	if err != nil {
 		return errors.Trace(err)
 	}
-	defer restorePostWork(ctx, client, restoreSchedulers)
+	defer restorePostWork(ctx, client, mgr, rollback, nil)
 
 	err = client.RestoreRaw(ctx, cfg.StartKey, cfg.EndKey, files, updateCh)
 	if err != nil {
@@ -151,7 +177,8 @@ func RunRestoreRaw(c context.Context, g glue.Glue, cmdName string, cfg *RestoreR
 	// Restore has finished.
 	updateCh.Close()
 
+	rollback.succeeded = true
 	// Set task summary to success status.
-	summary.SetSuccessStatus(true)
+	summary.FromContext(ctx).SetSuccessStatus(true)
 	return nil
 }
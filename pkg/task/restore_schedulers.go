@@ -0,0 +1,88 @@
+// Copyright 2021 PingCAP, Inc. Licensed under Apache-2.0.
+
+package task
+
+import (
+	"context"
+	"sync"
+
+	"github.com/pingcap/errors"
+	"github.com/pingcap/log"
+	"go.uber.org/zap"
+
+	"github.com/pingcap/br/pkg/pdutil"
+)
+
+// schedulerRemover removes the PD schedulers and configs slowing down the
+// restore, it's implemented by the PD controller of each restore.
+type schedulerRemover interface {
+	RemoveSchedulersWithOrigin(ctx context.Context) (pdutil.ClusterConfig, pdutil.ClusterConfig, error)
+	RemoveSchedulersWithCfg(ctx context.Context, removeCfg pdutil.ClusterConfig) error
+	MakeUndoFunctionByConfig(config pdutil.ClusterConfig) pdutil.UndoFunc
+}
+
+// sharedSchedulers are the PD schedulers removed by the restores of a
+// cluster running in the process.
+type sharedSchedulers struct {
+	// origin is the schedulers and configs before the first restore removed
+	// them.
+	origin  pdutil.ClusterConfig
+	removed pdutil.ClusterConfig
+	holders int
+}
+
+var (
+	removedSchedulersMu sync.Mutex
+	// removedSchedulers are the schedulers removed by the restores of each
+	// cluster in the process, they are restored only when the last restore of
+	// the cluster finishes.
+	removedSchedulers = make(map[uint64]*sharedSchedulers)
+)
+
+// removeSchedulers removes the PD schedulers of the cluster for a restore.
+// The first restore of the cluster in the process removes them and records
+// the origin config, the later ones keep them removed by their own PD
+// controllers, so they are still paused after any restore exits. It returns
+// the origin config, and the function restoring the schedulers, which keeps
+// them removed and returns true if other restores of the cluster are still
+// running.
+func removeSchedulers(
+	ctx context.Context, clusterID uint64, remover schedulerRemover,
+) (pdutil.ClusterConfig, func(context.Context) (bool, error), error) {
+	removedSchedulersMu.Lock()
+	defer removedSchedulersMu.Unlock()
+	shared, ok := removedSchedulers[clusterID]
+	if ok {
+		if err := remover.RemoveSchedulersWithCfg(ctx, shared.removed); err != nil {
+			return pdutil.ClusterConfig{}, nil, errors.Trace(err)
+		}
+		log.Info("the PD schedulers are already removed by other restores", zap.Int("restores", shared.holders))
+	} else {
+		origin, removed, err := remover.RemoveSchedulersWithOrigin(ctx)
+		if err != nil {
+			return pdutil.ClusterConfig{}, nil, errors.Trace(err)
+		}
+		shared = &sharedSchedulers{origin: origin, removed: removed}
+		removedSchedulers[clusterID] = shared
+	}
+	shared.holders++
+
+	var once sync.Once
+	restore := func(ctx context.Context) (kept bool, err error) {
+		once.Do(func() {
+			removedSchedulersMu.Lock()
+			defer removedSchedulersMu.Unlock()
+			shared.holders--
+			if shared.holders > 0 {
+				log.Info("other restores are still running, keep the PD schedulers removed",
+					zap.Int("restores", shared.holders))
+				kept = true
+				return
+			}
+			delete(removedSchedulers, clusterID)
+			err = remover.MakeUndoFunctionByConfig(shared.origin)(ctx)
+		})
+		return kept, errors.Trace(err)
+	}
+	return shared.origin, restore, nil
+}
@@ -0,0 +1,93 @@
+// Copyright 2021 PingCAP, Inc. Licensed under Apache-2.0.
+
+package task
+
+import (
+	"context"
+
+	. "github.com/pingcap/check"
+
+	"github.com/pingcap/br/pkg/pdutil"
+)
+
+// fakeSchedulerRemover records the schedulers removed and restored by the PD
+// controller of a restore.
+type fakeSchedulerRemover struct {
+	origin pdutil.ClusterConfig
+
+	removedWithOrigin int
+	removedWithCfg    []pdutil.ClusterConfig
+	restored          []pdutil.ClusterConfig
+}
+
+func (r *fakeSchedulerRemover) RemoveSchedulersWithOrigin(
+	context.Context,
+) (pdutil.ClusterConfig, pdutil.ClusterConfig, error) {
+	r.removedWithOrigin++
+	return r.origin, pdutil.ClusterConfig{Schedulers: r.origin.Schedulers}, nil
+}
+
+func (r *fakeSchedulerRemover) RemoveSchedulersWithCfg(_ context.Context, removeCfg pdutil.ClusterConfig) error {
+	r.removedWithCfg = append(r.removedWithCfg, removeCfg)
+	return nil
+}
+
+func (r *fakeSchedulerRemover) MakeUndoFunctionByConfig(config pdutil.ClusterConfig) pdutil.UndoFunc {
+	return func(context.Context) error {
+		r.restored = append(r.restored, config)
+		return nil
+	}
+}
+
+func (s *testRestoreSuite) TestRemoveSchedulersOfOverlappingRestores(c *C) {
+	ctx := context.Background()
+	origin := pdutil.ClusterConfig{Schedulers: []string{"balance-leader-scheduler", "balance-region-scheduler"}}
+	job1 := &fakeSchedulerRemover{origin: origin}
+	// the schedulers are already removed when the second restore starts.
+	job2 := &fakeSchedulerRemover{}
+
+	origin1, restore1, err := removeSchedulers(ctx, 1, job1)
+	c.Assert(err, IsNil)
+	c.Assert(origin1, DeepEquals, origin)
+	origin2, restore2, err := removeSchedulers(ctx, 1, job2)
+	c.Assert(err, IsNil)
+	// the later restore gets the origin config of the first one, and keeps the
+	// schedulers removed by its own PD controller.
+	c.Assert(origin2, DeepEquals, origin)
+	c.Assert(job2.removedWithOrigin, Equals, 0)
+	c.Assert(job2.removedWithCfg, DeepEquals, []pdutil.ClusterConfig{{Schedulers: origin.Schedulers}})
+
+	// the restore of another cluster doesn't share the schedulers.
+	other := &fakeSchedulerRemover{}
+	_, restoreOther, err := removeSchedulers(ctx, 2, other)
+	c.Assert(err, IsNil)
+	c.Assert(other.removedWithOrigin, Equals, 1)
+
+	// the first restore finishing keeps the schedulers removed for the second.
+	kept, err := restore1(ctx)
+	c.Assert(err, IsNil)
+	c.Assert(kept, IsTrue)
+	c.Assert(job1.restored, HasLen, 0)
+	// restoring again doesn't release the schedulers of the second restore.
+	_, err = restore1(ctx)
+	c.Assert(err, IsNil)
+	c.Assert(job1.restored, HasLen, 0)
+
+	kept, err = restore2(ctx)
+	c.Assert(err, IsNil)
+	c.Assert(kept, IsFalse)
+	c.Assert(job2.restored, DeepEquals, []pdutil.ClusterConfig{origin})
+
+	kept, err = restoreOther(ctx)
+	c.Assert(err, IsNil)
+	c.Assert(kept, IsFalse)
+	c.Assert(other.restored, HasLen, 1)
+
+	// the next restore removes the schedulers again.
+	job3 := &fakeSchedulerRemover{origin: origin}
+	_, restore3, err := removeSchedulers(ctx, 1, job3)
+	c.Assert(err, IsNil)
+	c.Assert(job3.removedWithOrigin, Equals, 1)
+	_, err = restore3(ctx)
+	c.Assert(err, IsNil)
+}
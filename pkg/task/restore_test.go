@@ -3,9 +3,19 @@
 package task
 
 import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+
 	. "github.com/pingcap/check"
+	"github.com/pingcap/parser/model"
 
+	"github.com/pingcap/br/pkg/cdcutil"
+	"github.com/pingcap/br/pkg/metautil"
 	"github.com/pingcap/br/pkg/restore"
+	"github.com/pingcap/br/pkg/storage"
 )
 
 type testRestoreSuite struct{}
@@ -18,6 +28,179 @@ func (s *testRestoreSuite) TestRestoreConfigAdjust(c *C) {
 
 	c.Assert(cfg.Config.Concurrency, Equals, uint32(defaultRestoreConcurrency))
 	c.Assert(cfg.Config.SwitchModeInterval, Equals, defaultSwitchInterval)
-	c.Assert(cfg.MergeSmallRegionKeyCount, Equals, restore.DefaultMergeRegionKeyCount)
-	c.Assert(cfg.MergeSmallRegionSizeBytes, Equals, restore.DefaultMergeRegionSizeBytes)
+	// the thresholds of merging small regions are read from the cluster.
+	c.Assert(cfg.MergeSmallRegionKeyCount, Equals, uint64(0))
+	c.Assert(cfg.MergeSmallRegionSizeBytes, Equals, uint64(0))
+	c.Assert(cfg.CoordinateCDC, Equals, cdcutil.PolicyWarn)
+	c.Assert(cfg.SystemTablesPolicy, Equals, restore.SystemTablesMerge)
+	c.Assert(restore.SystemTablesPolicy("overwrite").Validate(), ErrorMatches, ".*unknown system tables policy.*")
+}
+
+func (s *testRestoreSuite) TestChangefeedsOfTables(c *C) {
+	tables := []*metautil.Table{{
+		DB:   &model.DBInfo{Name: model.NewCIStr("db1")},
+		Info: &model.TableInfo{Name: model.NewCIStr("t1")},
+	}}
+	changefeeds := []*cdcutil.Changefeed{
+		{ID: "all", State: "normal"},
+		{ID: "other-db", State: "normal", Rules: []string{"db2.*"}},
+		{ID: "stopped", State: "stopped"},
+		{ID: "table", State: "normal", Rules: []string{"db1.t1"}},
+	}
+	matched, err := changefeedsOfTables(changefeeds, tables)
+	c.Assert(err, IsNil)
+	c.Assert(matched, DeepEquals, []*cdcutil.Changefeed{changefeeds[0], changefeeds[3]})
+
+	changefeeds = []*cdcutil.Changefeed{{ID: "invalid", State: "normal", Rules: []string{"db1.t1["}}}
+	_, err = changefeedsOfTables(changefeeds, tables)
+	c.Assert(err, ErrorMatches, ".*invalid filter rules of the changefeed invalid.*")
+}
+
+func (s *testRestoreSuite) TestCheckBackupFeatures(c *C) {
+	ctx := context.Background()
+	store, err := storage.NewLocalStorage(c.MkDir())
+	c.Assert(err, IsNil)
+	// the backups of old versions don't record the features.
+	schemaOnly, err := checkBackupFeatures(ctx, store)
+	c.Assert(err, IsNil)
+	c.Assert(schemaOnly, IsFalse)
+
+	features := &metautil.BackupFeatures{Requested: []string{"compression"}, Compression: "zstd", CompressionLevel: 3}
+	c.Assert(metautil.WriteBackupFeatures(ctx, store, features), IsNil)
+	schemaOnly, err = checkBackupFeatures(ctx, store)
+	c.Assert(err, IsNil)
+	c.Assert(schemaOnly, IsFalse)
+
+	features.Compression = "brotli"
+	c.Assert(metautil.WriteBackupFeatures(ctx, store, features), IsNil)
+	_, err = checkBackupFeatures(ctx, store)
+	c.Assert(err, ErrorMatches, ".*compressed by brotli.*")
+
+	c.Assert(metautil.WriteBackupFeatures(ctx, store, &metautil.BackupFeatures{SchemaOnly: true}), IsNil)
+	schemaOnly, err = checkBackupFeatures(ctx, store)
+	c.Assert(err, IsNil)
+	c.Assert(schemaOnly, IsTrue)
+}
+
+func (s *testRestoreSuite) TestVerifyQueries(c *C) {
+	path := filepath.Join(c.MkDir(), "verify.sql")
+	c.Assert(os.WriteFile(path, []byte("SELECT COUNT(*) FROM test.t;\n"+
+		"select id from test.t where id < 3 union all select 1;"), 0o644), IsNil)
+	queries, err := loadVerifyQueries(path)
+	c.Assert(err, IsNil)
+	c.Assert(queries, DeepEquals, []string{
+		"SELECT COUNT(*) FROM test.t",
+		"select id from test.t where id < 3 union all select 1",
+	})
+
+	c.Assert(os.WriteFile(path, []byte("SELECT 1; DELETE FROM test.t"), 0o644), IsNil)
+	_, err = loadVerifyQueries(path)
+	c.Assert(err, ErrorMatches, ".*isn't a SELECT statement.*")
+	c.Assert(os.WriteFile(path, []byte("  "), 0o644), IsNil)
+	_, err = loadVerifyQueries(path)
+	c.Assert(err, ErrorMatches, ".*no verification query.*")
+
+	expected := []metautil.VerifyQuery{
+		{SQL: "SELECT COUNT(*) FROM test.t", Rows: [][]string{{"42"}}},
+		{SQL: "SELECT id FROM test.t WHERE id < 0", Rows: nil},
+		{SQL: "SELECT id, name FROM test.t", Rows: [][]string{{"1", "a"}}},
+	}
+	results := []metautil.VerifyQuery{
+		{SQL: "SELECT COUNT(*) FROM test.t", Rows: [][]string{{"42"}}},
+		{SQL: "SELECT id FROM test.t WHERE id < 0", Rows: [][]string{}},
+		{SQL: "SELECT id, name FROM test.t", Rows: [][]string{{"1", "b"}}},
+	}
+	c.Assert(compareVerifyQueries(expected, results), DeepEquals, []string{"SELECT id, name FROM test.t"})
+}
+
+func (s *testRestoreSuite) TestRunningRestores(c *C) {
+	get := func(path string) int {
+		w := httptest.NewRecorder()
+		handleRestoreTables(w, httptest.NewRequest(http.MethodGet, path, nil))
+		return w.Code
+	}
+	c.Assert(get("/restore/tables"), Equals, http.StatusNotFound)
+
+	job1, unregister1 := registerRestoreTables(restore.NewTableCanceler(nil))
+	c.Assert(get("/restore/tables"), Equals, http.StatusOK)
+	job2, unregister2 := registerRestoreTables(restore.NewTableCanceler(nil))
+	c.Assert(job2, Not(Equals), job1)
+	// the job is required if many restores are running.
+	c.Assert(get("/restore/tables"), Equals, http.StatusBadRequest)
+	c.Assert(get("/restore/tables?job="+job1), Equals, http.StatusOK)
+	c.Assert(get("/restore/tables?job="+job2), Equals, http.StatusOK)
+
+	unregister1()
+	c.Assert(get("/restore/tables?job="+job1), Equals, http.StatusNotFound)
+	c.Assert(get("/restore/tables"), Equals, http.StatusOK)
+	unregister2()
+	c.Assert(get("/restore/tables"), Equals, http.StatusNotFound)
+}
+
+func (s *testRestoreSuite) TestTopologyWarnings(c *C) {
+	newTopology := func(tikvStores int, version string, labels map[string]string, tiflash bool) *metautil.ClusterTopology {
+		topology := &metautil.ClusterTopology{PDVersion: "v" + version, RegionCount: 100}
+		for i := 0; i < tikvStores; i++ {
+			topology.Stores = append(topology.Stores,
+				metautil.StoreTopology{ID: uint64(i + 1), Version: version, Labels: labels})
+		}
+		if tiflash {
+			topology.Stores = append(topology.Stores,
+				metautil.StoreTopology{ID: 100, Version: "v" + version, TiFlash: true})
+		}
+		return topology
+	}
+	zone := map[string]string{"zone": "z1"}
+
+	backup := newTopology(3, "5.1.0", zone, false)
+	c.Assert(topologyWarnings(backup, newTopology(3, "5.1.0", zone, true)), HasLen, 0)
+
+	backup = newTopology(30, "5.1.0-alpha", zone, true)
+	warnings := topologyWarnings(backup, newTopology(3, "4.0.14", nil, false))
+	c.Assert(warnings, HasLen, 5)
+	c.Assert(warnings[0], Matches, "the backup is taken from 30 TiKV stores.*only 3 TiKV stores.*")
+	c.Assert(warnings[1], Matches, ".*TiFlash stores.*")
+	c.Assert(warnings[2], Matches, ".*TiKV 5.1.0.*TiKV 4.0.14 of an older major version")
+	c.Assert(warnings[3], Matches, ".*PD v5.1.0-alpha.*PD v4.0.14.*")
+	c.Assert(warnings[4], Matches, "the labels zone .*")
+}
+
+func (s *testRestoreSuite) TestJournalStorage(c *C) {
+	ctx := context.Background()
+	backupDir, journalDir := c.MkDir(), c.MkDir()
+	backupStorage, err := storage.NewLocalStorage(backupDir)
+	c.Assert(err, IsNil)
+
+	cfg := &RestoreConfig{}
+	journalStorage, err := cfg.journalStorage(ctx, backupStorage)
+	c.Assert(err, IsNil)
+	c.Assert(journalStorage, Equals, backupStorage)
+
+	// the journal is recorded into its own storage, the backup is untouched.
+	cfg.JournalStorage = "local://" + journalDir
+	journalStorage, err = cfg.journalStorage(ctx, backupStorage)
+	c.Assert(err, IsNil)
+	journal := restore.NewJournal(journalStorage)
+	c.Assert(journal.Record(ctx, restore.JournalEntry{Op: restore.JournalOpStart}), IsNil)
+	journals, err := restore.LoadJournals(ctx, journalStorage)
+	c.Assert(err, IsNil)
+	c.Assert(journals, HasLen, 1)
+	journals, err = restore.LoadJournals(ctx, backupStorage)
+	c.Assert(err, IsNil)
+	c.Assert(journals, HasLen, 0)
+}
+
+func (s *testRestoreSuite) TestCheckRequesterPays(c *C) {
+	cfg := &Config{}
+	cfg.BackendOptions.S3.RequesterPays = true
+	s3Backend, err := storage.ParseBackend("s3://bucket/prefix", &cfg.BackendOptions)
+	c.Assert(err, IsNil)
+	c.Assert(checkRequesterPays(s3Backend, cfg), ErrorMatches, ".*TiKV can't download the backup files.*")
+
+	localBackend, err := storage.ParseBackend("local://"+c.MkDir(), nil)
+	c.Assert(err, IsNil)
+	c.Assert(checkRequesterPays(localBackend, cfg), IsNil)
+
+	cfg.BackendOptions.S3.RequesterPays = false
+	c.Assert(checkRequesterPays(s3Backend, cfg), IsNil)
 }
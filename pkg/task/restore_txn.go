@@ -0,0 +1,193 @@
+// Copyright 2021 PingCAP, Inc. Licensed under Apache-2.0.
+
+package task
+
+import (
+	"context"
+
+	"github.com/pingcap/errors"
+	"github.com/pingcap/log"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"go.uber.org/zap"
+
+	berrors "github.com/pingcap/br/pkg/errors"
+	"github.com/pingcap/br/pkg/glue"
+	"github.com/pingcap/br/pkg/metautil"
+	"github.com/pingcap/br/pkg/restore"
+	"github.com/pingcap/br/pkg/summary"
+)
+
+// RestoreTxnConfig is the configuration specific for txn kv restore tasks.
+type RestoreTxnConfig struct {
+	Config
+	RestoreCommonConfig
+}
+
+// DefineTxnRestoreFlags defines common flags for the txn kv restore command.
+func DefineTxnRestoreFlags(command *cobra.Command) {
+	DefineRestoreCommonFlags(command.PersistentFlags())
+}
+
+// ParseFromFlags parses the txn kv restore flags from the flag set.
+func (cfg *RestoreTxnConfig) ParseFromFlags(flags *pflag.FlagSet) error {
+	var err error
+	cfg.Online, err = flags.GetBool(flagOnline)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	err = cfg.RestoreCommonConfig.ParseFromFlags(flags)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	return errors.Trace(cfg.Config.ParseFromFlags(flags))
+}
+
+func (cfg *RestoreTxnConfig) adjust() {
+	cfg.Config.adjust()
+
+	if cfg.Concurrency == 0 {
+		cfg.Concurrency = defaultRestoreConcurrency
+	}
+}
+
+// RunRestoreTxn starts a txn kv restore task inside the current goroutine.
+// The keys are restored with the commit ts of the backup, and the timestamp
+// of PD is moved beyond the backup ts, so the restored versions are visible
+// and the new transactions are committed after them.
+func RunRestoreTxn(c context.Context, g glue.Glue, cmdName string, cfg *RestoreTxnConfig) (err error) {
+	cfg.adjust()
+
+	// the summary and the requests to S3 are collected per restore, many
+	// restores may run in parallel in a process.
+	c = summary.WithJobCollector(c)
+	defer summary.FromContext(c).Summary(cmdName)
+	c, collectS3Requests := withS3RequestCounter(c)
+	defer collectS3Requests()
+	ctx, cancel := context.WithCancel(c)
+	defer cancel()
+	if err := startContinuousProfiling(ctx, &cfg.Config); err != nil {
+		return errors.Trace(err)
+	}
+
+	// Restore txn does not need domain.
+	needDomain := false
+	mgr, err := NewMgr(ctx, g, cfg.PD, cfg.TLS, GetKeepalive(&cfg.Config), cfg.Timeout, cfg.CheckRequirements, needDomain)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	defer mgr.Close()
+
+	keepaliveCfg := GetKeepalive(&cfg.Config)
+	// sometimes we have pooled the connections.
+	// sending heartbeats in idle times is useful.
+	keepaliveCfg.PermitWithoutStream = true
+	client, err := restore.NewRestoreClient(g, mgr.GetPDClient(), mgr.GetStorage(), mgr.GetTLSConfig(), keepaliveCfg)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	defer client.Close()
+	client.SetTimeoutConfig(cfg.Timeout)
+	client.SetRateLimit(cfg.RateLimit)
+	client.SetConcurrency(uint(cfg.Concurrency))
+	if cfg.Online {
+		client.EnableOnline()
+	}
+	client.SetSwitchModeInterval(cfg.SwitchModeInterval)
+
+	u, s, backupMeta, err := ReadBackupMeta(ctx, metautil.MetaFile, &cfg.Config)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if err = checkRequesterPays(u, &cfg.Config); err != nil {
+		return errors.Trace(err)
+	}
+	if backupMeta.IsRawKv {
+		return errors.Annotate(berrors.ErrRestoreModeMismatch, "cannot do txn kv restore from raw kv data")
+	}
+	txnKvMeta, err := metautil.ReadTxnKvMeta(ctx, s)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if txnKvMeta == nil {
+		return errors.Annotate(berrors.ErrRestoreModeMismatch, "cannot do txn kv restore from TiDB data")
+	}
+	if _, err = checkBackupFeatures(ctx, s); err != nil {
+		return errors.Trace(err)
+	}
+	client.EnableTxnKvMode()
+	reader := metautil.NewMetaReader(backupMeta, s)
+	if err = client.InitBackupMeta(c, backupMeta, u, reader); err != nil {
+		return errors.Trace(err)
+	}
+	if err = cfg.limitImport(ctx, client); err != nil {
+		return errors.Trace(err)
+	}
+	if err = cfg.adjustMergeRegion(ctx, client); err != nil {
+		return errors.Trace(err)
+	}
+
+	files, err := reader.ReadDataFiles(ctx)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	archiveSize := reader.ArchiveSize(ctx, files)
+	recordRestoreDataSize(ctx, g, archiveSize)
+
+	if len(files) == 0 {
+		log.Info("no files in the backup archive, nothing to restore")
+		return nil
+	}
+	summary.FromContext(ctx).CollectInt("restore files", len(files))
+	log.Info("restore txn kv", zap.Int("ranges", len(txnKvMeta.Ranges)),
+		zap.Uint64("backupTS", backupMeta.EndVersion))
+
+	ranges, _, err := restore.MergeFileRanges(
+		files, cfg.MergeSmallRegionSizeBytes, cfg.MergeSmallRegionKeyCount)
+	if err != nil {
+		return errors.Trace(err)
+	}
+
+	// Redirect to log if there is no log file to avoid unreadable output.
+	updateCh := g.StartProgress(
+		ctx,
+		"Txn Restore",
+		// Split/Scatter + Download/Ingest
+		int64(len(ranges)+len(files)),
+		!cfg.LogProgress)
+
+	// TxnKV restore does not need to rewrite keys.
+	rewrite := &restore.RewriteRules{}
+	err = restore.SplitRanges(ctx, client, ranges, rewrite, updateCh)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	client.WarmUp(ctx, ranges)
+
+	rollback, err := restorePreWork(ctx, client, mgr, nil)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	defer restorePostWork(ctx, client, mgr, rollback, nil)
+
+	// the restored keys are committed at or before the backup ts, reset the
+	// timestamp before ingesting them so that no new transaction commits
+	// earlier. PD refuses to move the timestamp backwards, which is fine.
+	if err = client.ResetTS(ctx, cfg.PD); err != nil {
+		log.Error("reset pd TS failed", zap.Error(err))
+		return errors.Trace(err)
+	}
+
+	err = client.RestoreTxn(ctx, files, updateCh)
+	if err != nil {
+		return errors.Trace(err)
+	}
+
+	// Restore has finished.
+	updateCh.Close()
+
+	rollback.succeeded = true
+	// Set task summary to success status.
+	summary.FromContext(ctx).SetSuccessStatus(true)
+	return nil
+}
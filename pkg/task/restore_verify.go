@@ -0,0 +1,192 @@
+// Copyright 2021 PingCAP, Inc. Licensed under Apache-2.0.
+
+package task
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+
+	"github.com/pingcap/errors"
+	"github.com/pingcap/log"
+	"go.uber.org/zap"
+
+	"github.com/pingcap/br/pkg/conn"
+	"github.com/pingcap/br/pkg/pdutil"
+	"github.com/pingcap/br/pkg/restore"
+	"github.com/pingcap/br/pkg/summary"
+	"github.com/pingcap/br/pkg/utils"
+)
+
+// restoreLeftover is a cluster change left by a failed restore, with the
+// command to roll it back manually.
+type restoreLeftover struct {
+	What        string
+	Remediation string
+}
+
+// rollbackVerifier reads the cluster state left by a failed restore.
+type rollbackVerifier interface {
+	// PDAddr returns the address of PD used by the remediation commands.
+	PDAddr() string
+	// AbnormalStores returns the addresses of the TiKV stores which may still
+	// be in the import mode, it never switches the mode of the stores.
+	AbnormalStores(ctx context.Context) ([]string, error)
+	ListSchedulers(ctx context.Context) ([]string, error)
+	ListPausedSchedulers(ctx context.Context) ([]string, error)
+	GetPDScheduleConfig(ctx context.Context) (map[string]interface{}, error)
+	RemoveSafePoint(ctx context.Context, sp utils.BRServiceSafePoint) error
+}
+
+type clusterRollbackVerifier struct {
+	client *restore.Client
+	*conn.Mgr
+}
+
+func (v *clusterRollbackVerifier) PDAddr() string {
+	return v.GetPDClient().GetLeaderAddr()
+}
+
+func (v *clusterRollbackVerifier) AbnormalStores(context.Context) ([]string, error) {
+	stores, err := v.client.AbnormalStores()
+	return stores, errors.Trace(err)
+}
+
+func (v *clusterRollbackVerifier) RemoveSafePoint(ctx context.Context, sp utils.BRServiceSafePoint) error {
+	return errors.Trace(utils.RemoveServiceSafePoint(ctx, v.GetPDClient(), sp))
+}
+
+// verifyRestoreRollback verifies the cluster is rolled back after the restore
+// failed: the TiKV stores are in the normal mode, the PD schedulers and their
+// configs are restored, and the service safe point of the restore is removed.
+// It returns the leftovers, including the states failed to be verified.
+func verifyRestoreRollback(
+	ctx context.Context, v rollbackVerifier, rollback *restoreRollback,
+) []restoreLeftover {
+	pd := v.PDAddr()
+	var leftovers []restoreLeftover
+	if rollback.origin != nil {
+		stores, err := v.AbnormalStores(ctx)
+		if err != nil {
+			leftovers = append(leftovers, restoreLeftover{
+				What:        fmt.Sprintf("cannot verify the TiKV stores are in the normal mode: %v", err),
+				Remediation: fmt.Sprintf("tidb-lightning-ctl --switch-mode=normal --pd-urls=%s", pd),
+			})
+		}
+		for _, store := range stores {
+			leftovers = append(leftovers, restoreLeftover{
+				What:        fmt.Sprintf("the TiKV store %s may still be in the import mode", store),
+				Remediation: fmt.Sprintf("tidb-lightning-ctl --switch-mode=normal --pd-urls=%s", pd),
+			})
+		}
+		// the schedulers kept removed for the other restores are restored by
+		// the last one.
+		if !rollback.schedulersKept {
+			leftovers = append(leftovers, verifySchedulers(ctx, v, pd, rollback.origin)...)
+		}
+	}
+	if sp := rollback.safePoint; sp != nil {
+		// the keeper has exited once stopped, so it can't put the removed
+		// service safe point back.
+		if rollback.stopSafePoint != nil {
+			rollback.stopSafePoint()
+		}
+		if err := v.RemoveSafePoint(ctx, *sp); err != nil {
+			leftovers = append(leftovers, restoreLeftover{
+				What: fmt.Sprintf("the service safe point %s is kept after failing to remove it: %v", sp.ID, err),
+				Remediation: fmt.Sprintf("pd-ctl -u %s service-gc-safepoint "+
+					"(it is removed by PD %d seconds after the restore exits)", pd, sp.TTL),
+			})
+		}
+	}
+	return leftovers
+}
+
+// verifySchedulers verifies the schedulers and the configs removed by the
+// restore are restored.
+func verifySchedulers(
+	ctx context.Context, v rollbackVerifier, pd string, origin *pdutil.ClusterConfig,
+) []restoreLeftover {
+	var leftovers []restoreLeftover
+	all, err := v.ListSchedulers(ctx)
+	var paused []string
+	if err == nil {
+		paused, err = v.ListPausedSchedulers(ctx)
+	}
+	for _, scheduler := range origin.Schedulers {
+		switch {
+		case err != nil:
+			leftovers = append(leftovers, restoreLeftover{
+				What:        fmt.Sprintf("cannot verify the PD scheduler %s is restored: %v", scheduler, err),
+				Remediation: fmt.Sprintf("pd-ctl -u %s scheduler add %s", pd, scheduler),
+			})
+		case containsString(paused, scheduler):
+			leftovers = append(leftovers, restoreLeftover{
+				What:        fmt.Sprintf("the PD scheduler %s is still paused", scheduler),
+				Remediation: fmt.Sprintf("pd-ctl -u %s scheduler resume %s", pd, scheduler),
+			})
+		case !containsString(all, scheduler):
+			leftovers = append(leftovers, restoreLeftover{
+				What:        fmt.Sprintf("the PD scheduler %s is removed", scheduler),
+				Remediation: fmt.Sprintf("pd-ctl -u %s scheduler add %s", pd, scheduler),
+			})
+		}
+	}
+
+	keys := make([]string, 0, len(origin.ScheduleCfg))
+	for key := range origin.ScheduleCfg {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	cfg, err := v.GetPDScheduleConfig(ctx)
+	for _, key := range keys {
+		want := origin.ScheduleCfg[key]
+		remediation := fmt.Sprintf("pd-ctl -u %s config set %s %s", pd, key, formatConfigValue(want))
+		if err != nil {
+			leftovers = append(leftovers, restoreLeftover{
+				What:        fmt.Sprintf("cannot verify the PD config %s is restored: %v", key, err),
+				Remediation: remediation,
+			})
+		} else if got := cfg[key]; !reflect.DeepEqual(got, want) {
+			leftovers = append(leftovers, restoreLeftover{
+				What:        fmt.Sprintf("the PD config %s is %s instead of %s", key, formatConfigValue(got), formatConfigValue(want)),
+				Remediation: remediation,
+			})
+		}
+	}
+	return leftovers
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// formatConfigValue formats the value of the PD config read from JSON, the
+// numbers are never formatted in the exponent form.
+func formatConfigValue(value interface{}) string {
+	if f, ok := value.(float64); ok {
+		return strconv.FormatFloat(f, 'f', -1, 64)
+	}
+	return fmt.Sprint(value)
+}
+
+// reportRestoreLeftovers reports the leftovers of the failed restore.
+func reportRestoreLeftovers(ctx context.Context, leftovers []restoreLeftover) {
+	if len(leftovers) == 0 {
+		log.Info("the cluster is rolled back after the restore failed")
+		return
+	}
+	for _, leftover := range leftovers {
+		log.Warn("the failed restore left the cluster changed, please roll it back manually",
+			zap.String("leftover", leftover.What),
+			zap.String("remediation", leftover.Remediation))
+	}
+	summary.FromContext(ctx).CollectInt("restore leftovers", len(leftovers))
+}
@@ -0,0 +1,122 @@
+// Copyright 2021 PingCAP, Inc. Licensed under Apache-2.0.
+
+package task
+
+import (
+	"context"
+	"errors"
+
+	. "github.com/pingcap/check"
+
+	"github.com/pingcap/br/pkg/pdutil"
+	"github.com/pingcap/br/pkg/utils"
+)
+
+type fakeRollbackVerifier struct {
+	abnormalStores []string
+	schedulers     []string
+	paused         []string
+	scheduleCfg    map[string]interface{}
+	listErr        error
+	removeErr      error
+
+	removedSafePoints []string
+}
+
+func (v *fakeRollbackVerifier) PDAddr() string {
+	return "http://pd:2379"
+}
+
+func (v *fakeRollbackVerifier) AbnormalStores(context.Context) ([]string, error) {
+	return v.abnormalStores, nil
+}
+
+func (v *fakeRollbackVerifier) ListSchedulers(context.Context) ([]string, error) {
+	return v.schedulers, v.listErr
+}
+
+func (v *fakeRollbackVerifier) ListPausedSchedulers(context.Context) ([]string, error) {
+	return v.paused, v.listErr
+}
+
+func (v *fakeRollbackVerifier) GetPDScheduleConfig(context.Context) (map[string]interface{}, error) {
+	return v.scheduleCfg, nil
+}
+
+func (v *fakeRollbackVerifier) RemoveSafePoint(_ context.Context, sp utils.BRServiceSafePoint) error {
+	v.removedSafePoints = append(v.removedSafePoints, sp.ID)
+	return v.removeErr
+}
+
+func (s *testRestoreSuite) TestVerifyRestoreRollback(c *C) {
+	ctx := context.Background()
+	origin := &pdutil.ClusterConfig{
+		Schedulers: []string{"balance-leader-scheduler", "balance-region-scheduler", "shuffle-leader-scheduler"},
+		ScheduleCfg: map[string]interface{}{
+			"max-merge-region-keys": float64(200000),
+			"max-snapshot-count":    float64(3),
+		},
+	}
+	stopped := false
+	rollback := &restoreRollback{
+		origin:        origin,
+		safePoint:     &utils.BRServiceSafePoint{ID: "br-1", TTL: 300},
+		stopSafePoint: func() { stopped = true },
+	}
+
+	rolledBack := &fakeRollbackVerifier{
+		schedulers:  origin.Schedulers,
+		scheduleCfg: map[string]interface{}{"max-merge-region-keys": float64(200000), "max-snapshot-count": float64(3)},
+	}
+	c.Assert(verifyRestoreRollback(ctx, rolledBack, rollback), HasLen, 0)
+	c.Assert(stopped, IsTrue)
+	c.Assert(rolledBack.removedSafePoints, DeepEquals, []string{"br-1"})
+
+	leftover := &fakeRollbackVerifier{
+		abnormalStores: []string{"tikv1:20160"},
+		schedulers:     []string{"balance-leader-scheduler", "balance-region-scheduler"},
+		paused:         []string{"balance-region-scheduler"},
+		scheduleCfg:    map[string]interface{}{"max-merge-region-keys": float64(0), "max-snapshot-count": float64(3)},
+		removeErr:      errors.New("pd is down"),
+	}
+	c.Assert(verifyRestoreRollback(ctx, leftover, rollback), DeepEquals, []restoreLeftover{
+		{
+			What:        "the TiKV store tikv1:20160 may still be in the import mode",
+			Remediation: "tidb-lightning-ctl --switch-mode=normal --pd-urls=http://pd:2379",
+		},
+		{
+			What:        "the PD scheduler balance-region-scheduler is still paused",
+			Remediation: "pd-ctl -u http://pd:2379 scheduler resume balance-region-scheduler",
+		},
+		{
+			What:        "the PD scheduler shuffle-leader-scheduler is removed",
+			Remediation: "pd-ctl -u http://pd:2379 scheduler add shuffle-leader-scheduler",
+		},
+		{
+			What:        "the PD config max-merge-region-keys is 0 instead of 200000",
+			Remediation: "pd-ctl -u http://pd:2379 config set max-merge-region-keys 200000",
+		},
+		{
+			What: "the service safe point br-1 is kept after failing to remove it: pd is down",
+			Remediation: "pd-ctl -u http://pd:2379 service-gc-safepoint " +
+				"(it is removed by PD 300 seconds after the restore exits)",
+		},
+	})
+
+	// the online restore changes neither the mode nor the schedulers.
+	failed := &fakeRollbackVerifier{abnormalStores: []string{"tikv1:20160"}, listErr: errors.New("pd is down")}
+	c.Assert(verifyRestoreRollback(ctx, failed, &restoreRollback{}), HasLen, 0)
+	leftovers := verifyRestoreRollback(ctx, failed, &restoreRollback{origin: &pdutil.ClusterConfig{
+		Schedulers: []string{"balance-leader-scheduler"},
+	}})
+	c.Assert(leftovers, HasLen, 2)
+	c.Assert(leftovers[1], DeepEquals, restoreLeftover{
+		What:        "cannot verify the PD scheduler balance-leader-scheduler is restored: pd is down",
+		Remediation: "pd-ctl -u http://pd:2379 scheduler add balance-leader-scheduler",
+	})
+
+	// the schedulers kept removed for the other restores still running are
+	// restored by the last one.
+	removed := &fakeRollbackVerifier{}
+	c.Assert(verifyRestoreRollback(ctx, removed, &restoreRollback{origin: origin, schedulersKept: true}), HasLen, 0)
+}
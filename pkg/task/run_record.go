@@ -0,0 +1,290 @@
+// Copyright 2021 PingCAP, Inc. Licensed under Apache-2.0.
+
+package task
+
+import (
+	"encoding/json"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pingcap/errors"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+
+	berrors "github.com/pingcap/br/pkg/errors"
+	"github.com/pingcap/br/pkg/version/build"
+)
+
+const (
+	// RunRecordSuffix is the suffix of the run record written beside the log
+	// file.
+	RunRecordSuffix = ".run.json"
+
+	// RedactedValue replaces the secrets in the run record.
+	RedactedValue = "<redacted>"
+
+	runRecordVersion = 1
+)
+
+// runRecordSkippedFlags are the flags not replayed, the replay writes its own
+// log file.
+var runRecordSkippedFlags = map[string]bool{
+	"help":     true,
+	"log-file": true,
+}
+
+// runRecordEnvPrefixes are the prefixes of the environment variables changing
+// the behavior of BR, e.g. the credentials and the proxies.
+var runRecordEnvPrefixes = []string{"BR_", "AWS_", "GOOGLE_", "AZURE_", "HTTP_PROXY", "HTTPS_PROXY", "NO_PROXY"}
+
+// RunRecord is the effective configuration of a run of BR, which is enough to
+// run it again with the same settings by `br replay`. The secrets, e.g. the
+// tokens and the access keys in the storage URLs, are redacted.
+type RunRecord struct {
+	Version   int       `json:"version"`
+	BRVersion string    `json:"br-version"`
+	GitHash   string    `json:"git-hash"`
+	StartTime time.Time `json:"start-time"`
+	// Command is the path of the command, e.g. "br backup full".
+	Command string `json:"command"`
+	// Subcommands are the names of the subcommands, e.g. ["backup", "full"].
+	Subcommands []string `json:"subcommands"`
+	// Args are the flags set by the command line in the form of --name=value.
+	Args []string `json:"args"`
+	// Defaults are the values of the flags not set by the command line, so
+	// the replay by a newer BR with different defaults is the same.
+	Defaults map[string]string `json:"defaults"`
+	// Redacted are the flags whose secrets are removed from Args, they must be
+	// passed to the replay again.
+	Redacted []string `json:"redacted,omitempty"`
+	// Env are the environment variables of BR, the secrets are redacted.
+	Env map[string]string `json:"env,omitempty"`
+	// Config is the config of the task after the defaults and the auto tuning
+	// are applied.
+	Config json.RawMessage `json:"config,omitempty"`
+}
+
+// isSecretName checks whether the name of a flag, a URL parameter, a config
+// field or an environment variable is a secret.
+func isSecretName(name string) bool {
+	name = strings.ToLower(name)
+	if strings.HasSuffix(name, "file") {
+		// the paths of the files of the secrets.
+		return false
+	}
+	for _, s := range []string{"secret", "token", "password", "credential"} {
+		if strings.Contains(name, s) {
+			return true
+		}
+	}
+	name = strings.NewReplacer("_", "-", ".", "-").Replace(name)
+	return strings.HasSuffix(name, "access-key") || strings.HasSuffix(name, "account-key") ||
+		strings.HasSuffix(name, "access-key-id")
+}
+
+// redactURL removes the secret parameters of the URL, it returns whether any
+// is removed.
+func redactURL(s string) (string, bool) {
+	u, err := url.Parse(s)
+	if err != nil || u.Scheme == "" || (u.RawQuery == "" && u.User == nil) {
+		return s, false
+	}
+	redacted := u.User != nil
+	u.User = nil
+	query := u.Query()
+	for name := range query {
+		if isSecretName(name) {
+			query.Del(name)
+			redacted = true
+		}
+	}
+	u.RawQuery = query.Encode()
+	return u.String(), redacted
+}
+
+// redactJSONValue replaces the secrets in the decoded JSON value.
+func redactJSONValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for key, child := range v {
+			if s, ok := child.(string); ok && s != "" && isSecretName(key) {
+				v[key] = RedactedValue
+				continue
+			}
+			v[key] = redactJSONValue(child)
+		}
+		return v
+	case []interface{}:
+		for i := range v {
+			v[i] = redactJSONValue(v[i])
+		}
+		return v
+	case string:
+		s, _ := redactURL(v)
+		return s
+	default:
+		return v
+	}
+}
+
+// flagArgs returns the flag in the form of --name=value, a slice flag is
+// repeated by each element.
+func flagArgs(f *pflag.Flag) []string {
+	if sv, ok := f.Value.(pflag.SliceValue); ok {
+		values := sv.GetSlice()
+		args := make([]string, 0, len(values))
+		for _, v := range values {
+			args = append(args, "--"+f.Name+"="+v)
+		}
+		return args
+	}
+	return []string{"--" + f.Name + "=" + f.Value.String()}
+}
+
+// NewRunRecord records the flags of the command and the environment.
+func NewRunRecord(cmd *cobra.Command) *RunRecord {
+	r := &RunRecord{
+		Version:   runRecordVersion,
+		BRVersion: build.ReleaseVersion,
+		GitHash:   build.GitHash,
+		StartTime: time.Now(),
+		Command:   cmd.CommandPath(),
+		Args:      []string{},
+		Defaults:  make(map[string]string),
+		Env:       make(map[string]string),
+	}
+	for c := cmd; c.HasParent(); c = c.Parent() {
+		r.Subcommands = append([]string{c.Name()}, r.Subcommands...)
+	}
+	cmd.Flags().VisitAll(func(f *pflag.Flag) {
+		if runRecordSkippedFlags[f.Name] {
+			return
+		}
+		if !f.Changed {
+			r.Defaults[f.Name] = f.Value.String()
+			return
+		}
+		if isSecretName(f.Name) {
+			r.Redacted = append(r.Redacted, f.Name)
+			return
+		}
+		args := flagArgs(f)
+		for i := range args {
+			prefix := "--" + f.Name + "="
+			value, redacted := redactURL(strings.TrimPrefix(args[i], prefix))
+			args[i] = prefix + value
+			if redacted && (len(r.Redacted) == 0 || r.Redacted[len(r.Redacted)-1] != f.Name) {
+				r.Redacted = append(r.Redacted, f.Name)
+			}
+		}
+		r.Args = append(r.Args, args...)
+	})
+	for _, kv := range os.Environ() {
+		parts := strings.SplitN(kv, "=", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		for _, prefix := range runRecordEnvPrefixes {
+			if !strings.HasPrefix(strings.ToUpper(parts[0]), prefix) {
+				continue
+			}
+			if isSecretName(parts[0]) {
+				r.Env[parts[0]] = RedactedValue
+			} else {
+				r.Env[parts[0]] = parts[1]
+			}
+			break
+		}
+	}
+	return r
+}
+
+// SetConfig records the effective config of the task, the secrets in it are
+// redacted.
+func (r *RunRecord) SetConfig(cfg interface{}) error {
+	data, err := json.Marshal(cfg)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	var v interface{}
+	if err = json.Unmarshal(data, &v); err != nil {
+		return errors.Trace(err)
+	}
+	r.Config, err = json.Marshal(redactJSONValue(v))
+	return errors.Trace(err)
+}
+
+// WriteRunRecord writes the run record to the local file.
+func WriteRunRecord(path string, r *RunRecord) error {
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return errors.Trace(err)
+	}
+	return errors.Trace(os.WriteFile(path, data, 0o600))
+}
+
+// ReadRunRecord reads the run record from the local file.
+func ReadRunRecord(path string) (*RunRecord, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	r := &RunRecord{}
+	if err = json.Unmarshal(data, r); err != nil {
+		return nil, errors.Annotatef(berrors.ErrInvalidArgument, "failed to parse the run record %s: %v", path, err)
+	}
+	if r.Version != runRecordVersion || len(r.Subcommands) == 0 {
+		return nil, errors.Annotatef(berrors.ErrInvalidArgument, "%s isn't a run record of BR", path)
+	}
+	return r, nil
+}
+
+// ReplayArgs returns the arguments running the recorded command again by the
+// root command, the extra arguments are appended to override the recorded
+// ones, e.g. the redacted secrets. The defaults of the flags changed since the
+// record are pinned to the recorded ones. It also returns the redacted flags
+// not in the extra arguments.
+func (r *RunRecord) ReplayArgs(root *cobra.Command, extra []string) ([]string, []string, error) {
+	cmd, rest, err := root.Find(r.Subcommands)
+	if err != nil || len(rest) > 0 || cmd == root {
+		return nil, nil, errors.Annotatef(berrors.ErrInvalidArgument,
+			"command %q of the run record isn't supported by this BR", r.Command)
+	}
+	args := append([]string{}, r.Subcommands...)
+	args = append(args, r.Args...)
+	var names []string
+	for name := range r.Defaults {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		f := cmd.Flags().Lookup(name)
+		if f == nil {
+			// the flag is removed, so is the feature.
+			continue
+		}
+		if _, ok := f.Value.(pflag.SliceValue); ok || f.DefValue == r.Defaults[name] {
+			continue
+		}
+		args = append(args, "--"+name+"="+r.Defaults[name])
+	}
+	args = append(args, extra...)
+
+	var missing []string
+	for _, name := range r.Redacted {
+		passed := false
+		for _, arg := range extra {
+			if arg == "--"+name || strings.HasPrefix(arg, "--"+name+"=") {
+				passed = true
+				break
+			}
+		}
+		if !passed {
+			missing = append(missing, name)
+		}
+	}
+	return args, missing, nil
+}
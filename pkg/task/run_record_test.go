@@ -0,0 +1,87 @@
+// Copyright 2021 PingCAP, Inc. Licensed under Apache-2.0.
+
+package task
+
+import (
+	"encoding/json"
+	"path/filepath"
+
+	. "github.com/pingcap/check"
+	"github.com/spf13/cobra"
+
+	"github.com/pingcap/br/pkg/storage"
+)
+
+func newRunRecordCommands(rateLimit uint64) (root, full *cobra.Command) {
+	root = &cobra.Command{Use: "br"}
+	root.PersistentFlags().String("log-file", "br.log", "")
+	root.PersistentFlags().String("status-token", "", "")
+	root.PersistentFlags().String(flagStorage, "", "")
+	backup := &cobra.Command{Use: "backup"}
+	full = &cobra.Command{Use: "full", Run: func(*cobra.Command, []string) {}}
+	full.Flags().Uint64(flagRateLimit, rateLimit, "")
+	full.Flags().Uint32(flagConcurrency, 4, "")
+	full.Flags().StringArrayP(flagFilter, "f", nil, "")
+	backup.AddCommand(full)
+	root.AddCommand(backup)
+	return root, full
+}
+
+func (s *testCommonSuite) TestRunRecord(c *C) {
+	root, full := newRunRecordCommands(0)
+	root.SetArgs([]string{
+		"backup", "full", "--log-file=a.log", "--status-token=abc", "--concurrency=8",
+		"--storage=s3://bucket/path?endpoint=http://minio:9000&access-key=ak&secret-access-key=sk",
+		"-f", "db.*", "-f", "!db.t",
+	})
+	c.Assert(root.Execute(), IsNil)
+
+	record := NewRunRecord(full)
+	c.Assert(record.Command, Equals, "br backup full")
+	c.Assert(record.Subcommands, DeepEquals, []string{"backup", "full"})
+	c.Assert(record.Args, DeepEquals, []string{
+		"--concurrency=8",
+		"--filter=db.*",
+		"--filter=!db.t",
+		"--storage=s3://bucket/path?endpoint=http%3A%2F%2Fminio%3A9000",
+	})
+	c.Assert(record.Redacted, DeepEquals, []string{"status-token", "storage"})
+	c.Assert(record.Defaults, DeepEquals, map[string]string{flagRateLimit: "0"})
+
+	opts := storage.BackendOptions{}
+	opts.S3.Endpoint = "http://minio:9000"
+	opts.S3.AccessKey, opts.S3.SecretAccessKey = "ak", "sk"
+	c.Assert(record.SetConfig(&opts), IsNil)
+	var cfg storage.BackendOptions
+	c.Assert(json.Unmarshal(record.Config, &cfg), IsNil)
+	c.Assert(cfg.S3.Endpoint, Equals, "http://minio:9000")
+	c.Assert(cfg.S3.AccessKey, Equals, RedactedValue)
+	c.Assert(cfg.S3.SecretAccessKey, Equals, RedactedValue)
+
+	path := filepath.Join(c.MkDir(), "br.log"+RunRecordSuffix)
+	c.Assert(WriteRunRecord(path, record), IsNil)
+	read, err := ReadRunRecord(path)
+	c.Assert(err, IsNil)
+	c.Assert(read.Args, DeepEquals, record.Args)
+	_, err = ReadRunRecord(filepath.Join(c.MkDir(), "missing"))
+	c.Assert(err, NotNil)
+
+	// the default changed since the record is pinned.
+	root, _ = newRunRecordCommands(100)
+	args, missing, err := read.ReplayArgs(root, []string{"--storage=s3://bucket/path?access-key=ak"})
+	c.Assert(err, IsNil)
+	c.Assert(args, DeepEquals, []string{
+		"backup", "full",
+		"--concurrency=8",
+		"--filter=db.*",
+		"--filter=!db.t",
+		"--storage=s3://bucket/path?endpoint=http%3A%2F%2Fminio%3A9000",
+		"--ratelimit=0",
+		"--storage=s3://bucket/path?access-key=ak",
+	})
+	c.Assert(missing, DeepEquals, []string{"status-token"})
+
+	read.Subcommands = []string{"restore", "full"}
+	_, _, err = read.ReplayArgs(root, nil)
+	c.Assert(err, NotNil)
+}
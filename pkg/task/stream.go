@@ -0,0 +1,80 @@
+// Copyright 2021 PingCAP, Inc. Licensed under Apache-2.0.
+
+package task
+
+import (
+	"context"
+	"io"
+
+	"github.com/pingcap/errors"
+	"github.com/spf13/pflag"
+
+	berrors "github.com/pingcap/br/pkg/errors"
+	"github.com/pingcap/br/pkg/metautil"
+	"github.com/pingcap/br/pkg/storage"
+)
+
+const flagStreamStaging = "stream-staging"
+
+// StreamConfig is the configuration of streaming the backup as a tar archive
+// through `stdout://`, `stdin://` or `pipe:///path` given as the storage.
+type StreamConfig struct {
+	// StreamStaging is the storage holding the files of the streamed backup.
+	// TiKV writes the SST files to it in backup and reads them from it in
+	// restore, so it must be accessible by BR and all TiKV nodes.
+	StreamStaging string `json:"stream-staging" toml:"stream-staging"`
+}
+
+func defineStreamFlags(flags *pflag.FlagSet) {
+	flags.String(flagStreamStaging, "",
+		"the storage holding the files of the backup streamed through stdout://, stdin:// or pipe:///path, "+
+			"which must be accessible by all TiKV nodes")
+}
+
+func (cfg *StreamConfig) parseFromFlags(flags *pflag.FlagSet) error {
+	var err error
+	cfg.StreamStaging, err = flags.GetString(flagStreamStaging)
+	return errors.Trace(err)
+}
+
+// useStreamStaging replaces the stream given as the storage by the staging
+// storage, and returns the stream URL, or "" if the storage isn't a stream.
+func (cfg *StreamConfig) useStreamStaging(common *Config) (string, error) {
+	if !storage.IsStreamURL(common.Storage) {
+		return "", nil
+	}
+	if cfg.StreamStaging == "" {
+		return "", errors.Annotatef(berrors.ErrInvalidArgument,
+			"--%s is required by the storage %s", flagStreamStaging, common.Storage)
+	}
+	stream := common.Storage
+	common.Storage = cfg.StreamStaging
+	return stream, nil
+}
+
+// writeBackupStream writes the files of the backup in the staging storage to
+// the stream, the backupmeta is the last one, so a truncated archive is never
+// restored.
+func writeBackupStream(ctx context.Context, s storage.ExternalStorage, w io.Writer) error {
+	names, err := metautil.BackupFiles(ctx, s)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	return errors.Trace(storage.WriteArchive(ctx, s, names, w))
+}
+
+// readBackupStream extracts the backup read from the stream to the staging
+// storage.
+func readBackupStream(ctx context.Context, cfg *Config, stream string) error {
+	_, s, err := GetStorage(ctx, cfg)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	r, err := storage.OpenStreamReader(stream)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	defer r.Close()
+	_, err = storage.ExtractArchive(ctx, r, s)
+	return errors.Trace(err)
+}
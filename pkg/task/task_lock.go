@@ -0,0 +1,185 @@
+// Copyright 2021 PingCAP, Inc. Licensed under Apache-2.0.
+
+package task
+
+import (
+	"context"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/pingcap/errors"
+	"github.com/pingcap/log"
+	"go.uber.org/zap"
+
+	berrors "github.com/pingcap/br/pkg/errors"
+	"github.com/pingcap/br/pkg/metautil"
+	"github.com/pingcap/br/pkg/storage"
+)
+
+const (
+	flagTaskLockTTL    = "task-lock-ttl"
+	defaultTaskLockTTL = time.Minute
+
+	// taskLockHeartbeats is the number of the heartbeats in a TTL, so a few
+	// failed heartbeats don't expire the lock.
+	taskLockHeartbeats = 3
+)
+
+// taskLockSettleDelay is the delay to read the lock back after it's written,
+// the storages without conditional writes let the last concurrent writer win,
+// so the others find they lost the lock by the read.
+var taskLockSettleDelay = 2 * time.Second
+
+// taskLocker holds the lock of the storage by sending heartbeats, until it's
+// released when the task exits.
+type taskLocker struct {
+	storage storage.ExternalStorage
+	lock    metautil.TaskLock
+	// abort cancels the task once the lock is taken over by another task, and
+	// lost is the reason, which is only read after the heartbeats stop.
+	abort context.CancelFunc
+	lost  error
+
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// acquireTaskLock locks the storage for the task, it fails with
+// ErrBackupLocked if the storage is locked by another live task. The lock
+// expired by the ttl after the last heartbeat, e.g. the task is killed, is
+// taken over. It's nil if the ttl is 0. If the lock is taken over while the
+// task runs, abort is called to cancel the context of the task, so the task
+// fails before writing its backupmeta.
+func acquireTaskLock(
+	ctx context.Context,
+	s storage.ExternalStorage,
+	cmdName string,
+	ttl time.Duration,
+	abort context.CancelFunc,
+) (*taskLocker, error) {
+	if ttl <= 0 {
+		return nil, nil
+	}
+	if err := checkTaskLock(ctx, s, ""); err != nil {
+		return nil, errors.Trace(err)
+	}
+	host, err := os.Hostname()
+	if err != nil {
+		host = "unknown"
+	}
+	now := time.Now()
+	l := &taskLocker{
+		storage: s,
+		lock: metautil.TaskLock{
+			ID:        uuid.New().String(),
+			Holder:    host + ":" + strconv.Itoa(os.Getpid()),
+			Command:   cmdName,
+			StartTime: now,
+			Heartbeat: now,
+			TTL:       ttl,
+		},
+		abort: abort,
+		done:  make(chan struct{}),
+	}
+	if err = metautil.WriteTaskLock(ctx, s, &l.lock); err != nil {
+		return nil, errors.Trace(err)
+	}
+	select {
+	case <-ctx.Done():
+		return nil, errors.Trace(ctx.Err())
+	case <-time.After(taskLockSettleDelay):
+	}
+	if err = checkTaskLock(ctx, s, l.lock.ID); err != nil {
+		return nil, errors.Trace(err)
+	}
+	log.Info("storage locked for the task", zap.String("id", l.lock.ID), zap.Duration("ttl", ttl))
+
+	hbCtx, cancel := context.WithCancel(ctx)
+	l.cancel = cancel
+	go l.heartbeat(hbCtx)
+	return l, nil
+}
+
+// checkTaskLock checks the storage isn't locked by the tasks other than id.
+func checkTaskLock(ctx context.Context, s storage.ExternalStorage, id string) error {
+	lock, err := metautil.ReadTaskLock(ctx, s)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if lock == nil || lock.ID == id {
+		return nil
+	}
+	if !lock.IsLive(time.Now()) {
+		log.Warn("the lock of the storage has expired, take it over",
+			zap.String("holder", lock.Holder), zap.String("command", lock.Command),
+			zap.Time("heartbeat", lock.Heartbeat))
+		return nil
+	}
+	return errors.Annotatef(berrors.ErrBackupLocked,
+		"%s is locked by `%s` on %s since %s, the last heartbeat is at %s, "+
+			"remove %s if the task doesn't exist",
+		s.URI(), lock.Command, lock.Holder, lock.StartTime.Format(time.RFC3339),
+		lock.Heartbeat.Format(time.RFC3339), metautil.TaskLockFile)
+}
+
+func (l *taskLocker) heartbeat(ctx context.Context) {
+	defer close(l.done)
+	ticker := time.NewTicker(l.lock.TTL / taskLockHeartbeats)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		lock, err := metautil.ReadTaskLock(ctx, l.storage)
+		if err == nil && lock != nil && lock.ID != l.lock.ID {
+			log.Error("the lock of the storage is taken over by another task, abort the backup",
+				zap.String("holder", lock.Holder), zap.String("command", lock.Command))
+			l.lost = errors.Annotatef(berrors.ErrBackupLocked,
+				"the lock of %s is taken over by `%s` on %s, the backup is aborted",
+				l.storage.URI(), lock.Command, lock.Holder)
+			l.abort()
+			return
+		}
+		l.lock.Heartbeat = time.Now()
+		if err == nil {
+			err = metautil.WriteTaskLock(ctx, l.storage, &l.lock)
+		}
+		if err != nil && ctx.Err() == nil {
+			log.Warn("failed to send the heartbeat of the task lock", zap.Error(err))
+		}
+	}
+}
+
+// release stops the heartbeats and removes the lock if it's still held. It
+// returns the error if the lock was taken over, which is the reason why the
+// task is aborted.
+func (l *taskLocker) release() error {
+	if l == nil {
+		return nil
+	}
+	l.cancel()
+	<-l.done
+	if l.lost != nil {
+		return l.lost
+	}
+	// the task may be canceled, remove the lock anyway.
+	ctx := context.Background()
+	lock, err := metautil.ReadTaskLock(ctx, l.storage)
+	if err == nil && (lock == nil || lock.ID != l.lock.ID) {
+		return nil
+	}
+	if err == nil {
+		err = l.storage.DeleteFile(ctx, metautil.TaskLockFile)
+	}
+	if err != nil {
+		log.Warn("failed to remove the task lock, it expires after the TTL",
+			zap.Duration("ttl", l.lock.TTL), zap.Error(err))
+		return nil
+	}
+	log.Info("storage unlocked for the task", zap.String("id", l.lock.ID))
+	return nil
+}
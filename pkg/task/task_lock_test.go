@@ -0,0 +1,72 @@
+// Copyright 2021 PingCAP, Inc. Licensed under Apache-2.0.
+
+package task
+
+import (
+	"context"
+	"time"
+
+	. "github.com/pingcap/check"
+
+	berrors "github.com/pingcap/br/pkg/errors"
+	"github.com/pingcap/br/pkg/metautil"
+	"github.com/pingcap/br/pkg/storage"
+)
+
+func (s *testBackupSuite) TestTaskLock(c *C) {
+	defer func(delay time.Duration) { taskLockSettleDelay = delay }(taskLockSettleDelay)
+	taskLockSettleDelay = 0
+	ctx := context.Background()
+	store, err := storage.NewLocalStorage(c.MkDir())
+	c.Assert(err, IsNil)
+
+	abort := func() {}
+	locker, err := acquireTaskLock(ctx, store, "Full backup", 0, abort)
+	c.Assert(err, IsNil)
+	c.Assert(locker, IsNil)
+	c.Assert(locker.release(), IsNil)
+
+	locker, err = acquireTaskLock(ctx, store, "Full backup", 300*time.Millisecond, abort)
+	c.Assert(err, IsNil)
+	_, err = acquireTaskLock(ctx, store, "Full backup", time.Minute, abort)
+	c.Assert(berrors.Is(err, berrors.ErrBackupLocked), IsTrue)
+	// the heartbeats keep the lock live after the TTL.
+	time.Sleep(500 * time.Millisecond)
+	lock, err := metautil.ReadTaskLock(ctx, store)
+	c.Assert(err, IsNil)
+	c.Assert(lock.IsLive(time.Now()), IsTrue)
+	c.Assert(locker.release(), IsNil)
+	lock, err = metautil.ReadTaskLock(ctx, store)
+	c.Assert(err, IsNil)
+	c.Assert(lock, IsNil)
+
+	// the expired lock of a killed task is taken over.
+	expired := &metautil.TaskLock{ID: "killed", Heartbeat: time.Now().Add(-time.Hour), TTL: time.Minute}
+	c.Assert(metautil.WriteTaskLock(ctx, store, expired), IsNil)
+	locker, err = acquireTaskLock(ctx, store, "Full backup", time.Minute, abort)
+	c.Assert(err, IsNil)
+	lock, err = metautil.ReadTaskLock(ctx, store)
+	c.Assert(err, IsNil)
+	c.Assert(lock.ID, Equals, locker.lock.ID)
+	c.Assert(locker.release(), IsNil)
+
+	// the task losing the lock is aborted.
+	taskCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	locker, err = acquireTaskLock(taskCtx, store, "Full backup", 300*time.Millisecond, cancel)
+	c.Assert(err, IsNil)
+	other := &metautil.TaskLock{ID: "other", Heartbeat: time.Now(), TTL: time.Minute}
+	// write it again in case a heartbeat overwrites it.
+	deadline := time.Now().Add(5 * time.Second)
+	for taskCtx.Err() == nil {
+		c.Assert(time.Now().Before(deadline), IsTrue, Commentf("the task isn't aborted after losing the lock"))
+		c.Assert(metautil.WriteTaskLock(ctx, store, other), IsNil)
+		time.Sleep(50 * time.Millisecond)
+	}
+	err = locker.release()
+	c.Assert(berrors.Is(err, berrors.ErrBackupLocked), IsTrue)
+	// the lock of the other task is kept.
+	lock, err = metautil.ReadTaskLock(ctx, store)
+	c.Assert(err, IsNil)
+	c.Assert(lock.ID, Equals, "other")
+}
@@ -0,0 +1,177 @@
+// Copyright 2021 PingCAP, Inc. Licensed under Apache-2.0.
+
+package task
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/pingcap/errors"
+	"github.com/pingcap/log"
+	"github.com/pingcap/parser"
+	"github.com/pingcap/parser/ast"
+	"go.uber.org/zap"
+
+	"github.com/pingcap/br/pkg/conn"
+	berrors "github.com/pingcap/br/pkg/errors"
+	"github.com/pingcap/br/pkg/glue"
+	"github.com/pingcap/br/pkg/metautil"
+	"github.com/pingcap/br/pkg/restore"
+	"github.com/pingcap/br/pkg/storage"
+	"github.com/pingcap/br/pkg/summary"
+)
+
+// loadVerifyQueries reads the queries of the SQL file, only the read-only
+// queries are accepted.
+func loadVerifyQueries(path string) ([]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, errors.Annotatef(err, "failed to read the verification queries file %s", path)
+	}
+	stmts, _, err := parser.New().Parse(string(data), "", "")
+	if err != nil {
+		return nil, errors.Annotatef(berrors.ErrInvalidArgument,
+			"failed to parse the verification queries in %s: %v", path, err)
+	}
+	queries := make([]string, 0, len(stmts))
+	for _, stmt := range stmts {
+		query := strings.TrimRight(strings.TrimSpace(stmt.Text()), ";")
+		switch stmt.(type) {
+		case *ast.SelectStmt, *ast.SetOprStmt:
+		default:
+			return nil, errors.Annotatef(berrors.ErrInvalidArgument,
+				"the verification query %q isn't a SELECT statement", query)
+		}
+		queries = append(queries, query)
+	}
+	if len(queries) == 0 {
+		return nil, errors.Annotatef(berrors.ErrInvalidArgument, "no verification query in %s", path)
+	}
+	return queries, nil
+}
+
+// runVerifyQueries runs the queries at the snapshot of the ts.
+func runVerifyQueries(
+	ctx context.Context,
+	g glue.Glue,
+	mgr *conn.Mgr,
+	ts uint64,
+	queries []string,
+) ([]metautil.VerifyQuery, error) {
+	se, err := g.CreateSession(mgr.GetStorage())
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	defer se.Close()
+	qs, ok := se.(glue.QuerySession)
+	if !ok {
+		return nil, errors.Annotate(berrors.ErrInvalidArgument, "the verification queries aren't supported here")
+	}
+	if err = qs.Execute(ctx, fmt.Sprintf("SET @@tidb_snapshot = '%d'", ts)); err != nil {
+		return nil, errors.Trace(err)
+	}
+	results := make([]metautil.VerifyQuery, 0, len(queries))
+	for _, query := range queries {
+		rows, err := qs.Query(ctx, query)
+		if err != nil {
+			return nil, errors.Annotatef(err, "failed to run the verification query %q", query)
+		}
+		results = append(results, metautil.VerifyQuery{SQL: query, Rows: rows})
+	}
+	return results, nil
+}
+
+// recordVerifyQueries runs the queries of the SQL file at the backup ts, and
+// records their results with the backup.
+func recordVerifyQueries(
+	ctx context.Context,
+	g glue.Glue,
+	mgr *conn.Mgr,
+	s storage.ExternalStorage,
+	path string,
+	backupTS uint64,
+) error {
+	queries, err := loadVerifyQueries(path)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	results, err := runVerifyQueries(ctx, g, mgr, backupTS, queries)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	return errors.Trace(metautil.WriteVerifyQueries(ctx, s, results))
+}
+
+// checkVerifyQueries runs the verification queries recorded by backup at the
+// snapshot after restore, and compares the results with the recorded ones.
+func checkVerifyQueries(
+	ctx context.Context,
+	g glue.Glue,
+	client *restore.Client,
+	mgr *conn.Mgr,
+	s storage.ExternalStorage,
+) error {
+	expected, err := metautil.ReadVerifyQueries(ctx, s)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if len(expected) == 0 {
+		log.Warn("no verification queries are recorded by backup, skip verifying the restored data")
+		return nil
+	}
+	ts, err := client.GetTS(ctx)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	queries := make([]string, 0, len(expected))
+	for _, query := range expected {
+		queries = append(queries, query.SQL)
+	}
+	results, err := runVerifyQueries(ctx, g, mgr, ts, queries)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	mismatched := compareVerifyQueries(expected, results)
+	summary.CollectInt("verification queries", len(expected))
+	if len(mismatched) > 0 {
+		return errors.Annotatef(berrors.ErrRestoreVerifyMismatch,
+			"%d of %d verification queries mismatch: %s", len(mismatched), len(expected), strings.Join(mismatched, "; "))
+	}
+	log.Info("restored data verified", zap.Int("queries", len(expected)), zap.Uint64("ts", ts))
+	return nil
+}
+
+// compareVerifyQueries returns the queries whose results differ from the
+// expected ones.
+func compareVerifyQueries(expected, results []metautil.VerifyQuery) []string {
+	var mismatched []string
+	for i, query := range expected {
+		if !rowsEqual(query.Rows, results[i].Rows) {
+			log.Error("the result of the verification query mismatches",
+				zap.String("query", query.SQL),
+				zap.Any("expected", query.Rows),
+				zap.Any("got", results[i].Rows))
+			mismatched = append(mismatched, query.SQL)
+		}
+	}
+	return mismatched
+}
+
+func rowsEqual(a, b [][]string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if len(a[i]) != len(b[i]) {
+			return false
+		}
+		for j := range a[i] {
+			if a[i][j] != b[i][j] {
+				return false
+			}
+		}
+	}
+	return true
+}
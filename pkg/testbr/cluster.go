@@ -0,0 +1,285 @@
+// Copyright 2021 PingCAP, Inc. Licensed under Apache-2.0.
+
+// Package testbr is the harness of the end-to-end tests of backup and restore,
+// which runs the tasks in the test process against a cluster provisioned by
+// docker, an in-process mock cluster or an existing cluster.
+package testbr
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	_ "github.com/go-sql-driver/mysql" // mysql driver
+	"github.com/pingcap/errors"
+	"github.com/pingcap/log"
+	"go.uber.org/zap"
+
+	berrors "github.com/pingcap/br/pkg/errors"
+	"github.com/pingcap/br/pkg/mock"
+)
+
+const (
+	// EnvCluster selects the cluster of StartCluster, it's one of 'mock',
+	// 'docker' and 'existing', 'mock' by default.
+	EnvCluster = "BR_TEST_CLUSTER"
+	// EnvPD is the comma separated PD addresses of the existing cluster.
+	EnvPD = "BR_TEST_PD"
+	// EnvDSN is the data source name of TiDB of the existing cluster.
+	EnvDSN = "BR_TEST_DSN"
+	// EnvImageVersion is the image tag of the docker cluster.
+	EnvImageVersion = "BR_TEST_VERSION"
+
+	defaultComposeFile  = "docker/testbr-compose.yaml"
+	defaultProject      = "testbr"
+	defaultPDPort       = 12379
+	defaultTiDBPort     = 14000
+	defaultStartTimeout = 3 * time.Minute
+)
+
+// Cluster is a cluster of PD, TiKV and TiDB the tests run against.
+type Cluster interface {
+	// PDAddrs returns the addresses of PD, empty if BR can't run against the
+	// cluster, e.g. the mock cluster.
+	PDAddrs() []string
+	// DSN returns the data source name of TiDB.
+	DSN() string
+	// DataDir returns the directory shared with TiKV for the local storages,
+	// empty means any directory, e.g. TiKV runs on this host.
+	DataDir() string
+	// Stop stops the cluster and releases its resources.
+	Stop() error
+}
+
+// StartCluster starts the cluster selected by EnvCluster.
+func StartCluster(ctx context.Context) (Cluster, error) {
+	switch kind := os.Getenv(EnvCluster); kind {
+	case "", "mock":
+		return StartMockCluster()
+	case "docker":
+		return StartDockerCluster(ctx, DockerOptions{Version: os.Getenv(EnvImageVersion)})
+	case "existing":
+		var pd []string
+		if addrs := os.Getenv(EnvPD); addrs != "" {
+			pd = strings.Split(addrs, ",")
+		}
+		return ExistingCluster(pd, os.Getenv(EnvDSN)), nil
+	default:
+		return nil, errors.Annotatef(berrors.ErrInvalidArgument,
+			"unknown %s %q, should be one of 'mock|docker|existing'", EnvCluster, kind)
+	}
+}
+
+type mockCluster struct {
+	*mock.Cluster
+}
+
+// StartMockCluster starts an in-process mock cluster, which only serves SQL.
+func StartMockCluster() (Cluster, error) {
+	cluster, err := mock.NewCluster()
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	if err = cluster.Start(); err != nil {
+		cluster.Stop()
+		return nil, errors.Trace(err)
+	}
+	return mockCluster{Cluster: cluster}, nil
+}
+
+func (m mockCluster) PDAddrs() []string {
+	return nil
+}
+
+func (m mockCluster) DSN() string {
+	return m.Cluster.DSN
+}
+
+func (m mockCluster) DataDir() string {
+	return ""
+}
+
+func (m mockCluster) Stop() error {
+	m.Cluster.Stop()
+	return nil
+}
+
+type existingCluster struct {
+	pd  []string
+	dsn string
+}
+
+// ExistingCluster is a cluster started outside the tests, e.g. by
+// `tiup playground`, which isn't stopped by the tests.
+func ExistingCluster(pd []string, dsn string) Cluster {
+	return existingCluster{pd: pd, dsn: dsn}
+}
+
+func (e existingCluster) PDAddrs() []string {
+	return e.pd
+}
+
+func (e existingCluster) DSN() string {
+	return e.dsn
+}
+
+func (e existingCluster) DataDir() string {
+	return ""
+}
+
+func (e existingCluster) Stop() error {
+	return nil
+}
+
+// DockerOptions are the options of the docker cluster.
+type DockerOptions struct {
+	// ComposeFile is the path of the compose file, the file in the docker
+	// directory of the repository by default.
+	ComposeFile string
+	// Project is the name of the compose project.
+	Project string
+	// Version is the tag of the images, 'nightly' by default.
+	Version string
+	// DataDir is the directory shared by the tests and TiKV for the local
+	// storages.
+	DataDir string
+	// PDPort and TiDBPort are the ports listened by PD and TiDB on the host.
+	PDPort   int
+	TiDBPort int
+	// StartTimeout is how long to wait for TiDB to serve after starting.
+	StartTimeout time.Duration
+}
+
+func (opts *DockerOptions) adjust() error {
+	if opts.ComposeFile == "" {
+		file, err := findInParents(defaultComposeFile)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		opts.ComposeFile = file
+	}
+	if opts.Project == "" {
+		opts.Project = defaultProject
+	}
+	if opts.Version == "" {
+		opts.Version = "nightly"
+	}
+	if opts.DataDir == "" {
+		opts.DataDir = filepath.Join(os.TempDir(), "br", opts.Project)
+	}
+	if opts.PDPort == 0 {
+		opts.PDPort = defaultPDPort
+	}
+	if opts.TiDBPort == 0 {
+		opts.TiDBPort = defaultTiDBPort
+	}
+	if opts.StartTimeout == 0 {
+		opts.StartTimeout = defaultStartTimeout
+	}
+	return nil
+}
+
+// findInParents finds the file relative to the working directory or its
+// parents, since the tests run in the directories of the packages.
+func findInParents(name string) (string, error) {
+	dir, err := os.Getwd()
+	if err != nil {
+		return "", errors.Trace(err)
+	}
+	for {
+		path := filepath.Join(dir, name)
+		if _, err = os.Stat(path); err == nil {
+			return path, nil
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return "", errors.Annotatef(berrors.ErrInvalidArgument, "%s isn't found in the parents of the working directory", name)
+		}
+		dir = parent
+	}
+}
+
+type dockerCluster struct {
+	opts DockerOptions
+}
+
+// StartDockerCluster starts a cluster of a PD, a TiKV and a TiDB by docker
+// compose, and waits for TiDB to serve.
+func StartDockerCluster(ctx context.Context, opts DockerOptions) (Cluster, error) {
+	if err := opts.adjust(); err != nil {
+		return nil, errors.Trace(err)
+	}
+	if err := os.MkdirAll(opts.DataDir, 0o755); err != nil {
+		return nil, errors.Trace(err)
+	}
+	d := &dockerCluster{opts: opts}
+	if err := d.compose(ctx, "up", "-d"); err != nil {
+		return nil, errors.Trace(err)
+	}
+	if err := waitForTiDB(ctx, d.DSN(), opts.StartTimeout); err != nil {
+		_ = d.Stop()
+		return nil, errors.Trace(err)
+	}
+	log.Info("docker cluster started", zap.Strings("pd", d.PDAddrs()), zap.String("project", opts.Project))
+	return d, nil
+}
+
+func (d *dockerCluster) compose(ctx context.Context, args ...string) error {
+	cmd := exec.CommandContext(ctx, "docker",
+		append([]string{"compose", "-f", d.opts.ComposeFile, "-p", d.opts.Project}, args...)...)
+	cmd.Env = append(os.Environ(),
+		"TESTBR_VERSION="+d.opts.Version,
+		"TESTBR_DATA_DIR="+d.opts.DataDir,
+		fmt.Sprintf("TESTBR_PD_PORT=%d", d.opts.PDPort),
+		fmt.Sprintf("TESTBR_TIDB_PORT=%d", d.opts.TiDBPort),
+	)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return errors.Annotatef(err, "docker compose %s failed: %s", strings.Join(args, " "), out)
+	}
+	return nil
+}
+
+func (d *dockerCluster) PDAddrs() []string {
+	return []string{fmt.Sprintf("127.0.0.1:%d", d.opts.PDPort)}
+}
+
+func (d *dockerCluster) DSN() string {
+	return fmt.Sprintf("root@tcp(127.0.0.1:%d)/", d.opts.TiDBPort)
+}
+
+func (d *dockerCluster) DataDir() string {
+	return d.opts.DataDir
+}
+
+func (d *dockerCluster) Stop() error {
+	return errors.Trace(d.compose(context.Background(), "down", "-v"))
+}
+
+// waitForTiDB waits for TiDB to serve, which is after PD and TiKV serve and
+// the cluster is bootstrapped.
+func waitForTiDB(ctx context.Context, dsn string, timeout time.Duration) error {
+	db, err := sql.Open("mysql", dsn)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	defer db.Close()
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		if err = db.PingContext(ctx); err == nil {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return errors.Annotatef(err, "TiDB isn't serving after %s", timeout)
+		case <-ticker.C:
+		}
+	}
+}
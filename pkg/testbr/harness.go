@@ -0,0 +1,182 @@
+// Copyright 2021 PingCAP, Inc. Licensed under Apache-2.0.
+
+package testbr
+
+import (
+	"context"
+	"database/sql"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/pingcap/check"
+	"github.com/pingcap/errors"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+
+	"github.com/pingcap/br/pkg/glue"
+	"github.com/pingcap/br/pkg/gluetidb"
+	"github.com/pingcap/br/pkg/task"
+	"github.com/pingcap/br/pkg/utils"
+)
+
+// defaultFilter is the default --filter of `br backup full` and
+// `br restore full` without the system tables.
+var defaultFilter = []string{
+	"*.*",
+	"!mysql.*",
+	"!sys.*",
+	"!INFORMATION_SCHEMA.*",
+	"!PERFORMANCE_SCHEMA.*",
+	"!METRICS_SCHEMA.*",
+	"!INSPECTION_SCHEMA.*",
+}
+
+// Harness runs the backup and restore tasks against a cluster and asserts on
+// its state by SQL.
+type Harness struct {
+	Cluster Cluster
+	DB      *sql.DB
+	// Dir is the directory of the local storages of Storage.
+	Dir string
+
+	glue glue.Glue
+}
+
+// New creates a harness of the cluster, the local storages are in a temporary
+// directory unless the cluster shares one with TiKV.
+func New(cluster Cluster) (*Harness, error) {
+	db, err := sql.Open("mysql", cluster.DSN())
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	dir := cluster.DataDir()
+	if dir == "" {
+		dir = os.TempDir()
+	}
+	dir, err = os.MkdirTemp(dir, "testbr")
+	if err != nil {
+		db.Close()
+		return nil, errors.Trace(err)
+	}
+	return &Harness{Cluster: cluster, DB: db, Dir: dir, glue: gluetidb.New()}, nil
+}
+
+// Close closes the connection to TiDB, stops the cluster and removes the
+// local storages.
+func (h *Harness) Close() error {
+	err := h.DB.Close()
+	if e := h.Cluster.Stop(); e != nil && err == nil {
+		err = e
+	}
+	if e := os.RemoveAll(h.Dir); e != nil && err == nil {
+		err = e
+	}
+	return errors.Trace(err)
+}
+
+// RequireBR skips the test if BR can't run against the cluster.
+func (h *Harness) RequireBR(c *check.C) {
+	if len(h.Cluster.PDAddrs()) == 0 {
+		c.Skip("BR can't run against the cluster without PD, set " + EnvCluster + " to docker or existing")
+	}
+}
+
+// Storage returns the URL of a local storage named by the name.
+func (h *Harness) Storage(name string) string {
+	return "local://" + filepath.Join(h.Dir, name)
+}
+
+// Backup runs a full backup with the flags of `br backup full` except --pd,
+// which is the PD of the cluster.
+func (h *Harness) Backup(ctx context.Context, args ...string) error {
+	command := h.newCommand(task.DefineBackupFlags)
+	if err := h.parseFlags(command, args); err != nil {
+		return errors.Trace(err)
+	}
+	cfg := task.BackupConfig{Config: task.Config{LogProgress: true}}
+	if err := cfg.ParseFromFlags(command.Flags()); err != nil {
+		return errors.Trace(err)
+	}
+	return errors.Trace(task.RunBackup(ctx, h.glue, "Full backup", &cfg))
+}
+
+// Restore runs a full restore with the flags of `br restore full` except
+// --pd, which is the PD of the cluster.
+func (h *Harness) Restore(ctx context.Context, args ...string) error {
+	command := h.newCommand(task.DefineRestoreFlags)
+	if err := h.parseFlags(command, args); err != nil {
+		return errors.Trace(err)
+	}
+	cfg := task.RestoreConfig{Config: task.Config{LogProgress: true}}
+	if err := cfg.ParseFromFlags(command.Flags()); err != nil {
+		return errors.Trace(err)
+	}
+	return errors.Trace(task.RunRestore(ctx, h.glue, "Full restore", &cfg))
+}
+
+func (h *Harness) newCommand(defineFlags func(*pflag.FlagSet)) *cobra.Command {
+	command := &cobra.Command{}
+	task.DefineCommonFlags(command.PersistentFlags())
+	defineFlags(command.PersistentFlags())
+	task.DefineFilterFlags(command, defaultFilter)
+	return command
+}
+
+func (h *Harness) parseFlags(command *cobra.Command, args []string) error {
+	pd := "--pd=" + strings.Join(h.Cluster.PDAddrs(), ",")
+	return errors.Trace(command.ParseFlags(append([]string{pd}, args...)))
+}
+
+// MustExec executes the statements in order.
+func (h *Harness) MustExec(c *check.C, statements ...string) {
+	for _, stmt := range statements {
+		_, err := h.DB.Exec(stmt)
+		c.Assert(err, check.IsNil, check.Commentf("%s", stmt))
+	}
+}
+
+// MustQuery returns the rows of the query as strings, NULL is "NULL".
+func (h *Harness) MustQuery(c *check.C, query string, args ...interface{}) [][]string {
+	rows, err := h.DB.Query(query, args...)
+	c.Assert(err, check.IsNil, check.Commentf("%s", query))
+	defer rows.Close()
+	columns, err := rows.Columns()
+	c.Assert(err, check.IsNil)
+
+	var result [][]string
+	for rows.Next() {
+		values := make([]sql.NullString, len(columns))
+		dest := make([]interface{}, len(columns))
+		for i := range values {
+			dest[i] = &values[i]
+		}
+		c.Assert(rows.Scan(dest...), check.IsNil)
+		row := make([]string, len(columns))
+		for i, v := range values {
+			row[i] = "NULL"
+			if v.Valid {
+				row[i] = v.String
+			}
+		}
+		result = append(result, row)
+	}
+	c.Assert(rows.Err(), check.IsNil)
+	return result
+}
+
+// Checksum is the result of `ADMIN CHECKSUM TABLE`.
+type Checksum struct {
+	Crc64Xor   string
+	TotalKvs   string
+	TotalBytes string
+}
+
+// MustChecksum returns the checksum of the table, which is the same after
+// the table is restored.
+func (h *Harness) MustChecksum(c *check.C, schema, table string) Checksum {
+	rows := h.MustQuery(c, "ADMIN CHECKSUM TABLE "+utils.EncloseDBAndTable(schema, table))
+	c.Assert(rows, check.HasLen, 1)
+	c.Assert(rows[0], check.HasLen, 5)
+	return Checksum{Crc64Xor: rows[0][2], TotalKvs: rows[0][3], TotalBytes: rows[0][4]}
+}
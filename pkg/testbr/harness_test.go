@@ -0,0 +1,62 @@
+// Copyright 2021 PingCAP, Inc. Licensed under Apache-2.0.
+
+package testbr_test
+
+import (
+	"context"
+	"testing"
+
+	. "github.com/pingcap/check"
+
+	"github.com/pingcap/br/pkg/testbr"
+)
+
+func Test(t *testing.T) {
+	TestingT(t)
+}
+
+var _ = Suite(&testHarnessSuite{})
+
+type testHarnessSuite struct {
+	h *testbr.Harness
+}
+
+func (s *testHarnessSuite) SetUpSuite(c *C) {
+	cluster, err := testbr.StartCluster(context.Background())
+	c.Assert(err, IsNil)
+	s.h, err = testbr.New(cluster)
+	c.Assert(err, IsNil)
+}
+
+func (s *testHarnessSuite) TearDownSuite(c *C) {
+	c.Assert(s.h.Close(), IsNil)
+}
+
+func (s *testHarnessSuite) TestQuery(c *C) {
+	s.h.MustExec(c,
+		"CREATE DATABASE IF NOT EXISTS testbr_query",
+		"CREATE TABLE IF NOT EXISTS testbr_query.t (a INT PRIMARY KEY, b VARCHAR(10))",
+		"REPLACE INTO testbr_query.t VALUES (1, 'x'), (2, NULL)",
+	)
+	rows := s.h.MustQuery(c, "SELECT a, b FROM testbr_query.t WHERE a > ? ORDER BY a", 0)
+	c.Assert(rows, DeepEquals, [][]string{{"1", "x"}, {"2", "NULL"}})
+}
+
+func (s *testHarnessSuite) TestBackupRestore(c *C) {
+	s.h.RequireBR(c)
+	ctx := context.Background()
+	s.h.MustExec(c,
+		"CREATE DATABASE testbr_flow",
+		"CREATE TABLE testbr_flow.t (a INT PRIMARY KEY, b VARCHAR(10))",
+		"INSERT INTO testbr_flow.t VALUES (1, 'x'), (2, 'y'), (3, 'z')",
+	)
+	checksum := s.h.MustChecksum(c, "testbr_flow", "t")
+
+	storage := s.h.Storage("flow")
+	c.Assert(s.h.Backup(ctx, "--storage", storage, "--filter", "testbr_flow.*"), IsNil)
+	s.h.MustExec(c, "DROP DATABASE testbr_flow")
+	c.Assert(s.h.Restore(ctx, "--storage", storage, "--filter", "testbr_flow.*"), IsNil)
+
+	c.Assert(s.h.MustChecksum(c, "testbr_flow", "t"), Equals, checksum)
+	c.Assert(s.h.MustQuery(c, "SELECT COUNT(*) FROM testbr_flow.t"), DeepEquals, [][]string{{"3"}})
+}
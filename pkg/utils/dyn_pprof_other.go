@@ -6,6 +6,6 @@ package utils
 import tidbutils "github.com/pingcap/tidb-tools/pkg/utils"
 
 // StartDynamicPProfListener starts the listener that will enable pprof when received `startPProfSignal`
-func StartDynamicPProfListener(tls *tidbutils.TLS) {
+func StartDynamicPProfListener(tls *tidbutils.TLS, auth *StatusAuth) {
 	// nothing to do on no posix signal supporting systems.
 }
@@ -0,0 +1,106 @@
+// Copyright 2021 PingCAP, Inc. Licensed under Apache-2.0.
+
+package utils
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+	"runtime"
+	"time"
+
+	"github.com/pingcap/errors"
+	"github.com/pingcap/log"
+	"go.uber.org/multierr"
+	"go.uber.org/zap"
+
+	berrors "github.com/pingcap/br/pkg/errors"
+)
+
+const (
+	// DefaultHookTimeout is the default timeout of each hook.
+	DefaultHookTimeout = 30 * time.Second
+
+	// HookStatusEnv is the environment variable passing the status of the task,
+	// i.e. "success" or "failed", to the exec hook.
+	HookStatusEnv = "BR_HOOK_STATUS"
+)
+
+// Hooks notifies the result of a task by the webhooks and the exec hook. The
+// payload is a JSON document, which is posted to the webhooks and written to
+// the stdin of the exec hook.
+type Hooks struct {
+	// URLs are the webhooks receiving the payload by POST requests.
+	URLs []string
+	// Exec is the command run by the shell.
+	Exec    string
+	Timeout time.Duration
+}
+
+// Enabled returns whether any hook is configured.
+func (h *Hooks) Enabled() bool {
+	return len(h.URLs) > 0 || h.Exec != ""
+}
+
+// Fire fires all the hooks with the payload, the failures of the hooks don't
+// stop the others and are returned together.
+func (h *Hooks) Fire(ctx context.Context, status string, payload []byte) error {
+	timeout := h.Timeout
+	if timeout <= 0 {
+		timeout = DefaultHookTimeout
+	}
+	var errs error
+	for _, url := range h.URLs {
+		if err := postWebhook(ctx, url, payload, timeout); err != nil {
+			log.Warn("failed to call the webhook", zap.String("url", url), zap.Error(err))
+			errs = multierr.Append(errs, err)
+		}
+	}
+	if h.Exec != "" {
+		if err := runExecHook(ctx, h.Exec, status, payload, timeout); err != nil {
+			log.Warn("failed to run the exec hook", zap.String("command", h.Exec), zap.Error(err))
+			errs = multierr.Append(errs, err)
+		}
+	}
+	return errs
+}
+
+func postWebhook(ctx context.Context, url string, payload []byte, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(payload))
+	if err != nil {
+		return errors.Annotatef(berrors.ErrInvalidArgument, "invalid webhook %s: %v", url, err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return errors.Annotatef(berrors.ErrUnknown, "webhook %s responds %s", url, resp.Status)
+	}
+	return nil
+}
+
+func runExecHook(ctx context.Context, command, status string, payload []byte, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	var cmd *exec.Cmd
+	if runtime.GOOS == "windows" {
+		cmd = exec.CommandContext(ctx, "cmd.exe", "/C", command)
+	} else {
+		cmd = exec.CommandContext(ctx, "sh", "-c", command)
+	}
+	cmd.Env = append(os.Environ(), fmt.Sprintf("%s=%s", HookStatusEnv, status))
+	cmd.Stdin = bytes.NewReader(payload)
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return errors.Annotatef(err, "exec hook output: %s", bytes.TrimSpace(output))
+	}
+	return nil
+}
@@ -0,0 +1,55 @@
+// Copyright 2021 PingCAP, Inc. Licensed under Apache-2.0.
+
+package utils
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+
+	. "github.com/pingcap/check"
+)
+
+type testHookSuite struct{}
+
+var _ = Suite(&testHookSuite{})
+
+func (*testHookSuite) TestFireHooks(c *C) {
+	if runtime.GOOS == "windows" {
+		c.Skip("the exec hook is tested with sh")
+	}
+	var received []byte
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c.Assert(r.Method, Equals, http.MethodPost)
+		c.Assert(r.Header.Get("Content-Type"), Equals, "application/json")
+		received, _ = io.ReadAll(r.Body)
+	}))
+	defer server.Close()
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer failing.Close()
+
+	out := filepath.Join(c.MkDir(), "hook.out")
+	payload := []byte(`{"status":"failed"}`)
+	hooks := &Hooks{
+		URLs: []string{failing.URL, server.URL},
+		Exec: "cat > " + out + " && echo $" + HookStatusEnv + " >> " + out,
+	}
+	c.Assert(hooks.Enabled(), IsTrue)
+	err := hooks.Fire(context.Background(), "failed", payload)
+	// the failing webhook doesn't stop the others.
+	c.Assert(err, ErrorMatches, ".*500 Internal Server Error.*")
+	c.Assert(received, DeepEquals, payload)
+	data, err := os.ReadFile(out)
+	c.Assert(err, IsNil)
+	c.Assert(string(data), Equals, `{"status":"failed"}failed`+"\n")
+
+	hooks = &Hooks{Exec: "echo oops && exit 3"}
+	c.Assert(hooks.Fire(context.Background(), "success", payload), ErrorMatches, ".*oops.*")
+	c.Assert((&Hooks{}).Enabled(), IsFalse)
+}
@@ -0,0 +1,85 @@
+// Copyright 2021 PingCAP, Inc. Licensed under Apache-2.0.
+
+package utils
+
+import (
+	"os"
+	"runtime"
+	"strings"
+
+	"go.uber.org/zap/zapcore"
+)
+
+const (
+	sysNodeDir = "/sys/devices/system/node"
+
+	// referenceCPUs is the number of CPUs of a NUMA node of the x86_64 hosts
+	// which the default concurrencies are tuned on.
+	referenceCPUs = 16
+	// referenceCPUsArm64 is the counterpart for arm64 hosts, whose cores are
+	// weaker but more, and have no SMT.
+	referenceCPUsArm64 = 32
+)
+
+// HostProfile describes the processors of the host running BR.
+type HostProfile struct {
+	Arch      string
+	NumCPU    int
+	NUMANodes int
+}
+
+// ProbeHost measures the processors of the current host. The NUMA layout is
+// only available on Linux, other systems are treated as a single node.
+func ProbeHost() HostProfile {
+	return HostProfile{
+		Arch:      runtime.GOARCH,
+		NumCPU:    runtime.NumCPU(),
+		NUMANodes: numaNodes(sysNodeDir),
+	}
+}
+
+func numaNodes(dir string) int {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return 1
+	}
+	nodes := 0
+	for _, entry := range entries {
+		name := strings.TrimPrefix(entry.Name(), "node")
+		if entry.IsDir() && name != entry.Name() && len(name) > 0 && strings.Trim(name, "0123456789") == "" {
+			nodes++
+		}
+	}
+	if nodes == 0 {
+		return 1
+	}
+	return nodes
+}
+
+// CPUsPerNode returns the number of CPUs of a NUMA node.
+func (p HostProfile) CPUsPerNode() int {
+	if p.NUMANodes <= 1 {
+		return MaxInt(p.NumCPU, 1)
+	}
+	return MaxInt(p.NumCPU/p.NUMANodes, 1)
+}
+
+// ScaleConcurrency scales the default concurrency by the CPUs of a NUMA node
+// against the reference host, and clamps the result to [def/2, max].
+// Goroutines are not pinned to nodes, but the pools larger than a node mostly
+// contend on the remote memory.
+func (p HostProfile) ScaleConcurrency(def, max int) int {
+	reference := referenceCPUs
+	if p.Arch == "arm64" {
+		reference = referenceCPUsArm64
+	}
+	return ClampInt(def*p.CPUsPerNode()/reference, MaxInt(def/2, 1), max)
+}
+
+// MarshalLogObject implements zapcore.ObjectMarshaler.
+func (p HostProfile) MarshalLogObject(enc zapcore.ObjectEncoder) error {
+	enc.AddString("arch", p.Arch)
+	enc.AddInt("cpus", p.NumCPU)
+	enc.AddInt("numa-nodes", p.NUMANodes)
+	return nil
+}
@@ -0,0 +1,40 @@
+// Copyright 2021 PingCAP, Inc. Licensed under Apache-2.0.
+
+package utils
+
+import (
+	"os"
+	"path/filepath"
+
+	. "github.com/pingcap/check"
+)
+
+type testHostSuite struct{}
+
+var _ = Suite(&testHostSuite{})
+
+func (*testHostSuite) TestNUMANodes(c *C) {
+	dir := c.MkDir()
+	c.Assert(numaNodes(filepath.Join(dir, "missing")), Equals, 1)
+	for _, name := range []string{"node0", "node1", "power", "nodeX"} {
+		c.Assert(os.Mkdir(filepath.Join(dir, name), 0o755), IsNil)
+	}
+	c.Assert(os.WriteFile(filepath.Join(dir, "node2"), nil, 0o644), IsNil)
+	c.Assert(numaNodes(dir), Equals, 2)
+}
+
+func (*testHostSuite) TestScaleConcurrency(c *C) {
+	host := HostProfile{Arch: "amd64", NumCPU: 64, NUMANodes: 2}
+	c.Assert(host.CPUsPerNode(), Equals, 32)
+	c.Assert(host.ScaleConcurrency(128, 10240), Equals, 256)
+	c.Assert(host.ScaleConcurrency(128, 200), Equals, 200)
+
+	// arm64 cores are weaker.
+	host.Arch = "arm64"
+	c.Assert(host.ScaleConcurrency(128, 10240), Equals, 128)
+
+	// never lower than the half of the default.
+	host = HostProfile{Arch: "amd64", NumCPU: 2, NUMANodes: 1}
+	c.Assert(host.ScaleConcurrency(128, 10240), Equals, 64)
+	c.Assert(host.ScaleConcurrency(1, 10240), Equals, 1)
+}
@@ -0,0 +1,117 @@
+// Copyright 2021 PingCAP, Inc. Licensed under Apache-2.0.
+
+package utils
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/pingcap/errors"
+	"github.com/pingcap/log"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/push"
+	"go.uber.org/zap"
+)
+
+const (
+	// DefaultMetricsPushJob is the job label of the metrics pushed by BR.
+	DefaultMetricsPushJob = "br"
+	// DefaultMetricsPushInterval is the default interval of pushing the metrics.
+	DefaultMetricsPushInterval = 15 * time.Second
+
+	metricsPushTimeout = 10 * time.Second
+)
+
+// MetricsPusher pushes the metrics to the Prometheus pushgateway periodically,
+// since the BR tasks may finish before they are scraped from the status
+// service. The metrics are grouped by the job and instance labels.
+type MetricsPusher struct {
+	pusher   *push.Pusher
+	url      string
+	interval time.Duration
+
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// MetricsPushInstance returns the instance label of the pushed metrics, which
+// is the hostname and the pid, so the concurrent tasks don't overwrite each
+// other.
+func MetricsPushInstance() string {
+	host, err := os.Hostname()
+	if err != nil {
+		host = "unknown"
+	}
+	return host + ":" + strconv.Itoa(os.Getpid())
+}
+
+// NewMetricsPusher creates a pusher of the metrics in the gatherer.
+func NewMetricsPusher(url, job, instance string, interval time.Duration, g prometheus.Gatherer) *MetricsPusher {
+	if interval <= 0 {
+		interval = DefaultMetricsPushInterval
+	}
+	return &MetricsPusher{
+		pusher: push.New(url, job).
+			Gatherer(g).
+			Grouping("instance", instance).
+			Client(&http.Client{Timeout: metricsPushTimeout}),
+		url:      url,
+		interval: interval,
+	}
+}
+
+// StartMetricsPusher starts to push the default metrics to the pushgateway at
+// the url periodically.
+func StartMetricsPusher(url, job string, interval time.Duration) *MetricsPusher {
+	p := NewMetricsPusher(url, job, MetricsPushInstance(), interval, prometheus.DefaultGatherer)
+	p.Start()
+	return p
+}
+
+// Start starts pushing the metrics in background.
+func (p *MetricsPusher) Start() {
+	ctx, cancel := context.WithCancel(context.Background())
+	p.cancel = cancel
+	p.done = make(chan struct{})
+	log.Info("start pushing metrics", zap.String("url", p.url), zap.Duration("interval", p.interval))
+	go func() {
+		defer close(p.done)
+		ticker := time.NewTicker(p.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if err := p.Push(); err != nil {
+					log.Warn("failed to push metrics", zap.String("url", p.url), zap.Error(err))
+				}
+			}
+		}
+	}()
+}
+
+// Push pushes the metrics once, replacing the metrics of the same group in
+// the pushgateway.
+func (p *MetricsPusher) Push() error {
+	return errors.Trace(p.pusher.Push())
+}
+
+// Stop stops the periodic pushes and pushes the final metrics.
+func (p *MetricsPusher) Stop() {
+	if p == nil {
+		return
+	}
+	if p.cancel != nil {
+		p.cancel()
+		<-p.done
+	}
+	if err := p.Push(); err != nil {
+		log.Warn("failed to push the final metrics", zap.String("url", p.url), zap.Error(err))
+		return
+	}
+	log.Info("pushed the final metrics", zap.String("url", p.url))
+}
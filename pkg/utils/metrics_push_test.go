@@ -0,0 +1,59 @@
+// Copyright 2021 PingCAP, Inc. Licensed under Apache-2.0.
+
+package utils
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"time"
+
+	. "github.com/pingcap/check"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+type testMetricsPushSuite struct{}
+
+var _ = Suite(&testMetricsPushSuite{})
+
+func (*testMetricsPushSuite) TestMetricsPusher(c *C) {
+	var (
+		mu     sync.Mutex
+		paths  []string
+		bodies []string
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		mu.Lock()
+		paths = append(paths, r.Method+" "+r.URL.Path)
+		bodies = append(bodies, string(body))
+		mu.Unlock()
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	registry := prometheus.NewRegistry()
+	counter := prometheus.NewCounter(prometheus.CounterOpts{Name: "br_test_pushed_total", Help: "test"})
+	registry.MustRegister(counter)
+	counter.Add(3)
+
+	p := NewMetricsPusher(server.URL, "br", "host:1", time.Hour, registry)
+	p.Start()
+	p.Stop()
+
+	mu.Lock()
+	defer mu.Unlock()
+	// only the final push is sent since the interval isn't reached.
+	c.Assert(paths, DeepEquals, []string{"PUT /metrics/job/br/instance/host:1"})
+	c.Assert(len(bodies[0]) > 0, IsTrue)
+
+	// the pushes without pushgateway fail but don't panic.
+	server.Close()
+	c.Assert(p.Push(), NotNil)
+	c.Assert(strings.Contains(MetricsPushInstance(), ":"), IsTrue)
+
+	var nilPusher *MetricsPusher
+	nilPusher.Stop()
+}
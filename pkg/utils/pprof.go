@@ -21,6 +21,7 @@ import (
 
 	"github.com/pingcap/failpoint"
 	"github.com/pingcap/log"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"go.uber.org/zap"
 )
 
@@ -29,6 +30,11 @@ var (
 	mu           sync.Mutex
 )
 
+func init() { // nolint:gochecknoinits
+	// register HTTP handler for /metrics
+	http.Handle("/metrics", promhttp.Handler())
+}
+
 func listen(statusAddr string) (net.Listener, error) {
 	mu.Lock()
 	defer mu.Unlock()
@@ -53,14 +59,15 @@ func listen(statusAddr string) (net.Listener, error) {
 }
 
 // StartPProfListener forks a new goroutine listening on specified port and provide pprof info.
-func StartPProfListener(statusAddr string, wrapper *tidbutils.TLS) error {
+// The requests are authenticated by auth if it is enabled.
+func StartPProfListener(statusAddr string, wrapper *tidbutils.TLS, auth *StatusAuth) error {
 	listener, err := listen(statusAddr)
 	if err != nil {
 		return err
 	}
 
 	go func() {
-		if e := http.Serve(wrapper.WrapListener(listener), nil); e != nil {
+		if e := http.Serve(wrapper.WrapListener(listener), auth.Wrap(http.DefaultServeMux)); e != nil {
 			log.Warn("failed to serve pprof", zap.String("addr", startedPProf), zap.Error(e))
 			mu.Lock()
 			startedPProf = ""
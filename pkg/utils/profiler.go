@@ -0,0 +1,80 @@
+// Copyright 2021 PingCAP, Inc. Licensed under Apache-2.0.
+
+package utils
+
+import (
+	"bytes"
+	"context"
+	"runtime/pprof"
+	"time"
+
+	"github.com/pingcap/errors"
+	"github.com/pingcap/log"
+	"go.uber.org/zap"
+
+	"github.com/pingcap/br/pkg/storage"
+)
+
+// DefaultProfileDuration is the duration of each CPU profile captured by
+// RunContinuousProfiling.
+const DefaultProfileDuration = 30 * time.Second
+
+// RunContinuousProfiling captures a CPU profile of `duration` and a heap
+// profile every `interval`, and writes them to the storage, until the context
+// is done. The failures are logged and never stop the task.
+func RunContinuousProfiling(
+	ctx context.Context,
+	s storage.ExternalStorage,
+	interval time.Duration,
+	duration time.Duration,
+) {
+	if duration > interval {
+		duration = interval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	log.Info("continuous profiling started", zap.String("storage", s.URI()),
+		zap.Duration("interval", interval), zap.Duration("duration", duration))
+	for {
+		if err := captureProfiles(ctx, s, duration); err != nil {
+			log.Warn("failed to capture profiles", zap.Error(err))
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func captureProfiles(ctx context.Context, s storage.ExternalStorage, duration time.Duration) error {
+	suffix := time.Now().UTC().Format("20060102T150405Z") + ".pprof"
+
+	var cpu bytes.Buffer
+	// fails if the CPU profile is being captured by the status server.
+	if err := pprof.StartCPUProfile(&cpu); err != nil {
+		return errors.Annotate(err, "failed to start CPU profile")
+	}
+	timer := time.NewTimer(duration)
+	select {
+	case <-ctx.Done():
+		timer.Stop()
+		pprof.StopCPUProfile()
+		return nil
+	case <-timer.C:
+	}
+	pprof.StopCPUProfile()
+	if err := s.WriteFile(ctx, "cpu-"+suffix, cpu.Bytes()); err != nil {
+		return errors.Trace(err)
+	}
+
+	var heap bytes.Buffer
+	if err := pprof.Lookup("heap").WriteTo(&heap, 0); err != nil {
+		return errors.Annotate(err, "failed to capture heap profile")
+	}
+	if err := s.WriteFile(ctx, "heap-"+suffix, heap.Bytes()); err != nil {
+		return errors.Trace(err)
+	}
+	log.Debug("profiles captured", zap.String("suffix", suffix))
+	return nil
+}
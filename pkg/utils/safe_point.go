@@ -44,9 +44,9 @@ func (sp BRServiceSafePoint) MarshalLogObject(encoder zapcore.ObjectEncoder) err
 	return nil
 }
 
-// getGCSafePoint returns the current gc safe point.
+// GetGCSafePoint returns the current gc safe point.
 // TODO: Some cluster may not enable distributed GC.
-func getGCSafePoint(ctx context.Context, pdClient pd.Client) (uint64, error) {
+func GetGCSafePoint(ctx context.Context, pdClient pd.Client) (uint64, error) {
 	safePoint, err := pdClient.UpdateGCSafePoint(ctx, 0)
 	if err != nil {
 		return 0, errors.Trace(err)
@@ -63,7 +63,7 @@ func MakeSafePointID() string {
 // Note: It ignores errors other than exceed GC safepoint.
 func CheckGCSafePoint(ctx context.Context, pdClient pd.Client, ts uint64) error {
 	// TODO: use PDClient.GetGCSafePoint instead once PD client exports it.
-	safePoint, err := getGCSafePoint(ctx, pdClient)
+	safePoint, err := GetGCSafePoint(ctx, pdClient)
 	if err != nil {
 		log.Warn("fail to get GC safe point", zap.Error(err))
 		return nil
@@ -88,30 +88,47 @@ func updateServiceSafePoint(ctx context.Context, pdClient pd.Client, sp BRServic
 	return errors.Trace(err)
 }
 
+// RemoveServiceSafePoint removes the service safe point from PD, so it doesn't
+// block GC until the TTL expires after the task exits.
+func RemoveServiceSafePoint(ctx context.Context, pdClient pd.Client, sp BRServiceSafePoint) error {
+	// PD removes the service safe point whose TTL isn't positive.
+	_, err := pdClient.UpdateServiceGCSafePoint(ctx, sp.ID, 0, 0)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	log.Info("service safe point removed", zap.Object("safePoint", sp))
+	return nil
+}
+
 // StartServiceSafePointKeeper will run UpdateServiceSafePoint periodicity
-// hence keeping service safepoint won't lose.
+// hence keeping service safepoint won't lose. The returned function stops the
+// keeper and returns after it exits, so no update of the keeper reaches PD
+// after the service safe point is removed by RemoveServiceSafePoint.
 func StartServiceSafePointKeeper(
 	ctx context.Context,
 	pdClient pd.Client,
 	sp BRServiceSafePoint,
-) error {
+) (stop func(), err error) {
 	if sp.ID == "" || sp.TTL <= 0 {
-		return errors.Annotatef(berrors.ErrInvalidArgument, "invalid service safe point %v", sp)
+		return nil, errors.Annotatef(berrors.ErrInvalidArgument, "invalid service safe point %v", sp)
 	}
 	if err := CheckGCSafePoint(ctx, pdClient, sp.BackupTS); err != nil {
-		return errors.Trace(err)
+		return nil, errors.Trace(err)
 	}
 	// Update service safe point immediately to cover the gap between starting
 	// update goroutine and updating service safe point.
 	if err := updateServiceSafePoint(ctx, pdClient, sp); err != nil {
-		return errors.Trace(err)
+		return nil, errors.Trace(err)
 	}
 
 	// It would be OK since TTL won't be zero, so gapTime should > `0.
 	updateGapTime := time.Duration(sp.TTL) * time.Second / preUpdateServiceSafePointFactor
 	updateTick := time.NewTicker(updateGapTime)
 	checkTick := time.NewTicker(checkGCSafePointGapTime)
+	ctx, cancel := context.WithCancel(ctx)
+	done := make(chan struct{})
 	go func() {
+		defer close(done)
 		defer updateTick.Stop()
 		defer checkTick.Stop()
 		for {
@@ -135,5 +152,8 @@ func StartServiceSafePointKeeper(
 			}
 		}
 	}()
-	return nil
+	return func() {
+		cancel()
+		<-done
+	}, nil
 }
@@ -49,12 +49,17 @@ type mockSafePoint struct {
 	pd.Client
 	safepoint           uint64
 	minServiceSafepoint uint64
+	removed             []string
 }
 
 func (m *mockSafePoint) UpdateServiceGCSafePoint(ctx context.Context, serviceID string, ttl int64, safePoint uint64) (uint64, error) {
 	m.Lock()
 	defer m.Unlock()
 
+	if ttl <= 0 {
+		m.removed = append(m.removed, serviceID)
+		return m.safepoint, nil
+	}
 	if m.safepoint > safePoint {
 		return m.safepoint, nil
 	}
@@ -128,12 +133,30 @@ func (s *testSafePointSuite) TestStartServiceSafePointKeeper(c *C) {
 	}
 	for i, cs := range cases {
 		ctx, cancel := context.WithCancel(context.Background())
-		err := utils.StartServiceSafePointKeeper(ctx, pdClient, cs.sp)
+		stop, err := utils.StartServiceSafePointKeeper(ctx, pdClient, cs.sp)
 		checker := IsNil
 		if !cs.ok {
 			checker = NotNil
 		}
 		c.Assert(err, checker, Commentf("case #%d, %v", i, cs))
+		if stop != nil {
+			stop()
+		}
 		cancel()
 	}
 }
+
+func (s *testSafePointSuite) TestRemoveServiceSafePoint(c *C) {
+	pdClient := &mockSafePoint{safepoint: 2333}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	sp := utils.BRServiceSafePoint{ID: "br", TTL: 10, BackupTS: 2333 + 1}
+	stop, err := utils.StartServiceSafePointKeeper(ctx, pdClient, sp)
+	c.Assert(err, IsNil)
+	// the keeper has exited once stopped.
+	stop()
+	c.Assert(utils.RemoveServiceSafePoint(ctx, pdClient, sp), IsNil)
+	pdClient.Lock()
+	defer pdClient.Unlock()
+	c.Assert(pdClient.removed, DeepEquals, []string{"br"})
+}
@@ -0,0 +1,95 @@
+// Copyright 2021 PingCAP, Inc. Licensed under Apache-2.0.
+
+package utils
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+
+	"github.com/pingcap/log"
+	"go.uber.org/zap"
+)
+
+// StatusScope is the permission granted to a token of the status server.
+type StatusScope int
+
+const (
+	// StatusScopeNone means the request isn't authenticated.
+	StatusScopeNone StatusScope = iota
+	// StatusScopeRead allows the read-only requests, e.g. GET /debug/pprof.
+	StatusScopeRead
+	// StatusScopeControl allows all requests, including the ones which change
+	// the state of the running task.
+	StatusScopeControl
+)
+
+// StatusAuth authenticates the requests to the status server by bearer tokens.
+// The authentication is disabled if both tokens are empty.
+type StatusAuth struct {
+	// ReadToken grants the read-only scope.
+	ReadToken string
+	// ControlToken grants the control scope, which implies the read-only one.
+	ControlToken string
+}
+
+// Enabled returns whether any token is set.
+func (auth *StatusAuth) Enabled() bool {
+	return auth != nil && (auth.ReadToken != "" || auth.ControlToken != "")
+}
+
+// scopeOf returns the scope granted to the token.
+func (auth *StatusAuth) scopeOf(token string) StatusScope {
+	switch {
+	case token == "":
+		return StatusScopeNone
+	case auth.ControlToken != "" && subtle.ConstantTimeCompare([]byte(token), []byte(auth.ControlToken)) == 1:
+		return StatusScopeControl
+	case auth.ReadToken != "" && subtle.ConstantTimeCompare([]byte(token), []byte(auth.ReadToken)) == 1:
+		return StatusScopeRead
+	}
+	return StatusScopeNone
+}
+
+// requiredScope returns the scope required by the request. The safe methods
+// are read-only, others require the control scope.
+func requiredScope(r *http.Request) StatusScope {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return StatusScopeRead
+	}
+	return StatusScopeControl
+}
+
+func bearerToken(r *http.Request) string {
+	const prefix = "Bearer "
+	header := r.Header.Get("Authorization")
+	if len(header) < len(prefix) || !strings.EqualFold(header[:len(prefix)], prefix) {
+		return ""
+	}
+	return strings.TrimSpace(header[len(prefix):])
+}
+
+// Wrap returns a handler which rejects the requests without a token of the
+// required scope.
+func (auth *StatusAuth) Wrap(h http.Handler) http.Handler {
+	if !auth.Enabled() {
+		return h
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		scope := auth.scopeOf(bearerToken(r))
+		if scope == StatusScopeNone {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="br"`)
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		if scope < requiredScope(r) {
+			log.Warn("reject the status request without the control scope",
+				zap.String("method", r.Method), zap.String("path", r.URL.Path),
+				zap.String("remote", r.RemoteAddr))
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
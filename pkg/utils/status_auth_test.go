@@ -0,0 +1,49 @@
+// Copyright 2021 PingCAP, Inc. Licensed under Apache-2.0.
+
+package utils
+
+import (
+	"net/http"
+	"net/http/httptest"
+
+	. "github.com/pingcap/check"
+)
+
+type testStatusAuthSuite struct{}
+
+var _ = Suite(&testStatusAuthSuite{})
+
+func (*testStatusAuthSuite) TestStatusAuth(c *C) {
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	var nilAuth *StatusAuth
+	c.Assert(nilAuth.Enabled(), IsFalse)
+
+	auth := &StatusAuth{ReadToken: "read", ControlToken: "control"}
+	h := auth.Wrap(ok)
+	cases := []struct {
+		method string
+		token  string
+		code   int
+	}{
+		{http.MethodGet, "", http.StatusUnauthorized},
+		{http.MethodGet, "wrong", http.StatusUnauthorized},
+		{http.MethodGet, "read", http.StatusOK},
+		{http.MethodGet, "control", http.StatusOK},
+		{http.MethodPost, "read", http.StatusForbidden},
+		{http.MethodPost, "control", http.StatusOK},
+	}
+	for _, ca := range cases {
+		req := httptest.NewRequest(ca.method, "/debug/pprof/", nil)
+		if ca.token != "" {
+			req.Header.Set("Authorization", "Bearer "+ca.token)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		c.Assert(w.Code, Equals, ca.code, Commentf("%s %s", ca.method, ca.token))
+	}
+
+	// the control token can't be guessed from an empty read token.
+	auth = &StatusAuth{ControlToken: "control"}
+	c.Assert(auth.scopeOf("control"), Equals, StatusScopeControl)
+	c.Assert(auth.scopeOf("read"), Equals, StatusScopeNone)
+}
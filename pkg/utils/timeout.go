@@ -0,0 +1,60 @@
+// Copyright 2021 PingCAP, Inc. Licensed under Apache-2.0.
+
+package utils
+
+import (
+	"context"
+	"time"
+)
+
+const (
+	// DefaultDialTimeout is the default timeout of dialing to TiKV.
+	DefaultDialTimeout = 30 * time.Second
+	// DefaultPDTimeout is the default timeout of the requests sent to PD.
+	DefaultPDTimeout = 10 * time.Second
+)
+
+// TimeoutConfig is the timeouts of the RPCs sent by BR, so they can be tuned
+// for slow networks, e.g. restoring across regions.
+//
+// Ingest, WriteStreamIdle and Checksum are unlimited if they are zero.
+type TimeoutConfig struct {
+	// Dial is the timeout of establishing a gRPC connection to TiKV.
+	Dial time.Duration `json:"dial" toml:"dial"`
+	// PD is the timeout of each request sent to PD.
+	PD time.Duration `json:"pd" toml:"pd"`
+	// Ingest is the timeout of each ingest request sent to TiKV.
+	Ingest time.Duration `json:"ingest" toml:"ingest"`
+	// WriteStreamIdle is the max time a write stream can keep idle, i.e.
+	// nothing is sent through it, before it is canceled.
+	WriteStreamIdle time.Duration `json:"write-stream-idle" toml:"write-stream-idle"`
+	// Checksum is the timeout of checksumming a table.
+	Checksum time.Duration `json:"checksum" toml:"checksum"`
+}
+
+// DefaultTimeoutConfig returns the default timeouts.
+func DefaultTimeoutConfig() TimeoutConfig {
+	return TimeoutConfig{
+		Dial: DefaultDialTimeout,
+		PD:   DefaultPDTimeout,
+	}
+}
+
+// Adjust fills the zero required timeouts with default values.
+func (cfg *TimeoutConfig) Adjust() {
+	if cfg.Dial == 0 {
+		cfg.Dial = DefaultDialTimeout
+	}
+	if cfg.PD == 0 {
+		cfg.PD = DefaultPDTimeout
+	}
+}
+
+// ContextWithTimeout is like context.WithTimeout, but the returned context
+// has no deadline if the timeout isn't positive.
+func ContextWithTimeout(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, timeout)
+}
@@ -0,0 +1,39 @@
+// Copyright 2021 PingCAP, Inc. Licensed under Apache-2.0.
+
+package utils
+
+import (
+	"context"
+	"time"
+
+	. "github.com/pingcap/check"
+)
+
+type testTimeoutSuite struct{}
+
+var _ = Suite(&testTimeoutSuite{})
+
+func (*testTimeoutSuite) TestAdjust(c *C) {
+	cfg := TimeoutConfig{PD: time.Minute, Ingest: time.Hour}
+	cfg.Adjust()
+	c.Assert(cfg.Dial, Equals, DefaultDialTimeout)
+	c.Assert(cfg.PD, Equals, time.Minute)
+	c.Assert(cfg.Ingest, Equals, time.Hour)
+	c.Assert(cfg.WriteStreamIdle, Equals, time.Duration(0))
+	c.Assert(cfg.Checksum, Equals, time.Duration(0))
+}
+
+func (*testTimeoutSuite) TestContextWithTimeout(c *C) {
+	ctx, cancel := ContextWithTimeout(context.Background(), 0)
+	_, ok := ctx.Deadline()
+	c.Assert(ok, IsFalse)
+	cancel()
+	c.Assert(ctx.Err(), Equals, context.Canceled)
+
+	ctx, cancel = ContextWithTimeout(context.Background(), time.Millisecond)
+	defer cancel()
+	_, ok = ctx.Deadline()
+	c.Assert(ok, IsTrue)
+	<-ctx.Done()
+	c.Assert(ctx.Err(), Equals, context.DeadlineExceeded)
+}